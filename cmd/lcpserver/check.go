@@ -0,0 +1,45 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/edrlab/lcp-server/pkg/server"
+)
+
+// errCheckFailed is returned when at least one startup check fails
+var errCheckFailed = errors.New("the server cannot start with this configuration")
+
+// check validates the configuration and the access to every resource required by the server,
+// and prints a pass/fail report. It can be used as a pre-deployment gate.
+func check(args []string) error {
+
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	readConfig := configFlags(flags)
+	flags.Parse(args)
+
+	c, err := readConfig()
+	if err != nil {
+		fmt.Printf("FAIL  configuration: %v\n", err)
+		return errCheckFailed
+	}
+
+	failed := false
+	for _, r := range server.Check(c) {
+		if r.Err != nil {
+			failed = true
+			fmt.Printf("FAIL  %s: %v\n", r.Name, r.Err)
+		} else {
+			fmt.Printf("PASS  %s\n", r.Name)
+		}
+	}
+	if failed {
+		return errCheckFailed
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+// db analyzes the execution plans of the hot queries on the database of the server.
+func db(args []string, out io.Writer) error {
+
+	if len(args) == 0 || args[0] != "analyze" {
+		return errors.New("usage: lcpserver db analyze [-config file] [-profile name] [-region name] [-v]")
+	}
+	flags := flag.NewFlagSet("db analyze", flag.ExitOnError)
+	readConfig := configFlags(flags)
+	region := flags.String("region", "", "data residency region whose database is analyzed, instead of the main database")
+	verbose := flags.Bool("v", false, "prints the query and the plan of every query")
+	flags.Parse(args[1:])
+
+	c, err := readConfig()
+	if err != nil {
+		return err
+	}
+	dsn := c.Dsn
+	if *region != "" {
+		r, ok := c.Regions[*region]
+		if !ok {
+			return fmt.Errorf("unknown region %q", *region)
+		}
+		dsn = r.Dsn
+	}
+	plans, err := stor.Analyze(dsn)
+	if err != nil {
+		return err
+	}
+
+	var slow int
+	for _, plan := range plans {
+		var issues []string
+		for _, table := range plan.Scans {
+			issues = append(issues, "sequential scan of "+table)
+		}
+		if plan.Filesort {
+			issues = append(issues, "sort without index")
+		}
+		if len(issues) == 0 {
+			fmt.Fprintf(out, "ok    %s\n", plan.Query)
+		} else {
+			slow++
+			fmt.Fprintf(out, "slow  %s: %s\n", plan.Query, strings.Join(issues, ", "))
+		}
+		if *verbose || len(issues) > 0 {
+			fmt.Fprintf(out, "      %s\n", plan.SQL)
+			for _, line := range plan.Plan {
+				fmt.Fprintf(out, "      | %s\n", line)
+			}
+		}
+	}
+	if slow > 0 {
+		fmt.Fprintf(out, "%d of %d queries would benefit from an index; the plans depend on the statistics of the database, analyze it at production size\n", slow, len(plans))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDB(t *testing.T) {
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "lcpserver.yaml")
+	config := "dsn: \"sqlite3://" + filepath.Join(dir, "lcp.sqlite") + "\"\n"
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate([]string{"up", "-profile", "dev", "-config", configFile}, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := db([]string{"analyze", "-profile", "dev", "-config", configFile, "-v"}, &out); err != nil {
+		t.Fatalf("Failed to analyze the database: %v", err)
+	}
+	if !strings.Contains(out.String(), "ok    License().Get") || !strings.Contains(out.String(), "| SEARCH license_infos") {
+		t.Errorf("Expected the plans of the queries, got %s", out.String())
+	}
+	if !strings.Contains(out.String(), "slow  License().FindByPublication: sequential scan of license_infos") {
+		t.Errorf("Expected the scan of the licenses by publication, got %s", out.String())
+	}
+
+	if err := db([]string{"vacuum"}, &bytes.Buffer{}); err == nil {
+		t.Error("Expected an error for an unknown action")
+	}
+}
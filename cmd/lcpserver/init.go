@@ -0,0 +1,197 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/testca"
+)
+
+// setup gathers the answers of the bootstrap wizard
+type setup struct {
+	ConfigFile    string
+	DataDir       string
+	PublicBaseURL string
+	Port          string
+	Provider      string
+	HintLink      string
+	Dsn           string
+	AdminUser     string
+	AdminPassword string
+	Cert          string
+	PrivateKey    string
+}
+
+// minimal configuration generated by init, see the README for every option
+var configTemplate = template.Must(template.New("config").Parse(`# generated by lcpserver init on {{.Now}}
+public_base_url: {{printf "%q" .PublicBaseURL}}
+port: {{.Port}}
+dsn: {{printf "%q" .Dsn}}
+
+# admin credentials of the private routes, used as an API key
+login:
+  user: {{printf "%q" .AdminUser}}
+  password: {{printf "%q" .AdminPassword}}
+
+# test certificate, for evaluation only: reading applications only accept licenses
+# signed by a provider certificate delivered by EDRLab
+certificate:
+  cert: {{printf "%q" .Cert}}
+  private_key: {{printf "%q" .PrivateKey}}
+
+license:
+  provider: {{printf "%q" .Provider}}
+  # hint page of the passphrase, the built-in page of the server by default
+  hint_link: {{printf "%q" .HintLink}}
+
+status:
+  renew_default_days: 7
+  renew_max_days: 40
+`))
+
+// initialize generates a configuration file, a test certificate and the database schema,
+// with the values passed as flags or entered interactively.
+func initialize(args []string, in io.Reader, out io.Writer) error {
+
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	s := setup{}
+	flags.StringVar(&s.ConfigFile, "config", "lcpserver.yaml", "path of the generated configuration file")
+	flags.StringVar(&s.DataDir, "dir", "lcp-data", "directory of the database and certificate")
+	flags.StringVar(&s.PublicBaseURL, "public-url", "http://localhost:8081", "public base url of the server")
+	flags.StringVar(&s.Port, "port", "8081", "port of the server")
+	flags.StringVar(&s.Provider, "provider", "", "provider uri set in licenses (default: the public url)")
+	flags.StringVar(&s.Dsn, "dsn", "", "data source name of the database (default: a sqlite file in the data directory)")
+	flags.StringVar(&s.AdminUser, "admin", "admin", "admin user of the private routes")
+	interactive := flags.Bool("i", false, "ask for each value, the flags giving the defaults")
+	force := flags.Bool("force", false, "overwrite an existing configuration file")
+	flags.Parse(args)
+
+	if *interactive {
+		r := bufio.NewReader(in)
+		for _, q := range []struct {
+			label string
+			value *string
+		}{
+			{"Configuration file", &s.ConfigFile},
+			{"Data directory", &s.DataDir},
+			{"Public base url", &s.PublicBaseURL},
+			{"Port", &s.Port},
+			{"Provider uri", &s.Provider},
+			{"Database (dsn)", &s.Dsn},
+			{"Admin user", &s.AdminUser},
+		} {
+			fmt.Fprintf(out, "%s [%s]: ", q.label, *q.value)
+			answer, err := r.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if answer = strings.TrimSpace(answer); answer != "" {
+				*q.value = answer
+			}
+		}
+	}
+
+	if _, err := os.Stat(s.ConfigFile); err == nil && !*force {
+		return fmt.Errorf("%s already exists, use -force to overwrite it", s.ConfigFile)
+	}
+	dir, err := filepath.Abs(s.DataDir)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if s.Provider == "" {
+		s.Provider = s.PublicBaseURL
+	}
+	s.HintLink = strings.TrimSuffix(s.PublicBaseURL, "/") + "/hint/{license_id}"
+	if s.Dsn == "" {
+		s.Dsn = "sqlite3://file:" + filepath.ToSlash(filepath.Join(dir, "lcp.sqlite"))
+	}
+
+	// admin credentials
+	if s.AdminPassword, err = randomSecret(); err != nil {
+		return err
+	}
+
+	// test certificate
+	if s.Cert, s.PrivateKey, err = generateCertificate(dir, s.Provider); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "Generated the test certificate", s.Cert)
+
+	// configuration, validated as the server will
+	f, err := os.OpenFile(s.ConfigFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = configTemplate.Execute(f, struct {
+		setup
+		Now string
+	}{s, time.Now().Format(time.RFC3339)})
+	f.Close()
+	if err != nil {
+		return err
+	}
+	c, err := conf.ReadConfig(s.ConfigFile)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "Generated the configuration", s.ConfigFile)
+
+	// database schema
+	st, err := stor.DBSetup(c.Dsn)
+	if err != nil {
+		return err
+	}
+	if err = st.Check(); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "Created the database schema")
+
+	fmt.Fprintf(out, "\nAdmin API credentials (also in the configuration file):\n  user: %s\n  password: %s\n", s.AdminUser, s.AdminPassword)
+	fmt.Fprintf(out, "\nStart the server with: lcpserver -config %s\n", s.ConfigFile)
+	return nil
+}
+
+// randomSecret returns a random secret usable as a password
+func randomSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// generateCertificate writes a test provider certificate valid for a year, its private key,
+// and the certificate of the local CA which issued it
+func generateCertificate(dir, provider string) (certFile, keyFile string, err error) {
+	ca, err := testca.New("LCP test CA")
+	if err != nil {
+		return "", "", err
+	}
+	cred, err := ca.Provider(provider, time.Now().AddDate(1, 0, 0))
+	if err != nil {
+		return "", "", err
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "privkey.pem")
+	if err = os.WriteFile(filepath.Join(dir, "ca.pem"), ca.CertPEM(), 0644); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, cred.WriteFiles(certFile, keyFile)
+}
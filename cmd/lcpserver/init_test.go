@@ -0,0 +1,108 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/server"
+	"github.com/edrlab/lcp-server/pkg/stortest"
+)
+
+func TestInitialize(t *testing.T) {
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "lcpserver.yaml")
+	args := []string{"-config", configFile, "-dir", filepath.Join(dir, "data"), "-dsn", "sqlite3://file:init?mode=memory&cache=shared"}
+	var out bytes.Buffer
+	if err := initialize(args, nil, &out); err != nil {
+		t.Fatalf("Failed to initialize: %v", err)
+	}
+
+	// the generated configuration passes every check
+	c, err := conf.ReadConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), c.Login.Password) {
+		t.Error("The admin credentials must be printed")
+	}
+	for _, r := range server.Check(c) {
+		if r.Err != nil {
+			t.Errorf("The %s check failed: %v", r.Name, r.Err)
+		}
+	}
+
+	// and issues licenses
+	srv, err := server.New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := stortest.NewPublication("application/epub+zip")
+	if err = srv.Store.Publication().Create(pub); err != nil {
+		t.Fatal(err)
+	}
+	body := `{"publication_id": "` + pub.UUID + `", "user_id": "user1", "profile": "http://readium.org/lcp/basic-profile", "text_hint": "hint", ` +
+		`"pass_hash": "FAEB00CA518BEA7CB11A7EF31FB6183B489B1B6EADB792BEC64A03B3F6FF80A8"}`
+	req := httptest.NewRequest("POST", "/licenses/", strings.NewReader(body))
+	req.SetBasicAuth(c.Login.User, c.Login.Password)
+	rr := httptest.NewRecorder()
+	srv.Router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), c.PublicBaseUrl+"/hint/") {
+		t.Errorf("Failed to issue a license: %d %s", rr.Code, rr.Body)
+	}
+
+	// an existing configuration is not overwritten
+	if err = initialize(args, nil, &out); err == nil {
+		t.Error("Expected an error for an existing configuration file")
+	}
+
+	// interactive answers replace the defaults
+	in := strings.NewReader(filepath.Join(dir, "other.yaml") + "\n\nhttps://lcp.example.com\n\n\n\n\n")
+	if err = initialize(append(args, "-i"), in, &out); err != nil {
+		t.Fatalf("Failed to initialize interactively: %v", err)
+	}
+	if c, err = conf.ReadConfig(filepath.Join(dir, "other.yaml")); err != nil || c.PublicBaseUrl != "https://lcp.example.com" || c.License.Provider != c.PublicBaseUrl {
+		t.Errorf("Unexpected configuration %+v, %v", c, err)
+	}
+}
+
+func TestGenerateTestCA(t *testing.T) {
+
+	dir := t.TempDir()
+	var out bytes.Buffer
+	if err := generateTestCA([]string{"-dir", dir, "-days", "30"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "privkey.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPem, err := os.ReadFile(filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPem)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = leaf.Verify(x509.VerifyOptions{Roots: roots}); err != nil {
+		t.Errorf("Failed to verify the provider certificate: %v", err)
+	}
+	if err = generateTestCA([]string{"-dir", dir, "-days", "0"}, &out); err == nil {
+		t.Error("Expected an error for an invalid validity")
+	}
+}
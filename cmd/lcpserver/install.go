@@ -0,0 +1,121 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"text/template"
+)
+
+// service describes the server run as a system service.
+type service struct {
+	Name        string
+	Description string
+	Exe         string // absolute path of the executable
+	Config      string // absolute path of the configuration file
+}
+
+// systemd unit of a notify service, see the systemd socket activation in the README
+var systemdUnit = template.Must(template.New("systemd").Parse(`[Unit]
+Description={{.Description}}
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=notify
+ExecStart="{{.Exe}}" -config "{{.Config}}"
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// launchd daemon, kept alive by launchd
+var launchdPlist = template.Must(template.New("launchd").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>org.edrlab.{{.Name}}</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{.Exe}}</string>
+		<string>-config</string>
+		<string>{{.Config}}</string>
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+</dict>
+</plist>
+`))
+
+// definition returns the service definition applicable to an operating system.
+func (s service) definition(goos string) (string, error) {
+	var tpl *template.Template
+	switch goos {
+	case "linux":
+		tpl = systemdUnit
+	case "darwin":
+		tpl = launchdPlist
+	default:
+		return "", fmt.Errorf("no service definition on %s", goos)
+	}
+	var buf bytes.Buffer
+	err := tpl.Execute(&buf, s)
+	return buf.String(), err
+}
+
+// definitionPath returns the path of the service definition applicable to an operating system.
+func (s service) definitionPath(goos string) (string, error) {
+	switch goos {
+	case "linux":
+		return "/etc/systemd/system/" + s.Name + ".service", nil
+	case "darwin":
+		return "/Library/LaunchDaemons/org.edrlab." + s.Name + ".plist", nil
+	}
+	return "", fmt.Errorf("services are not supported on %s", goos)
+}
+
+// install installs the server as a system service: a systemd unit on Linux, a launchd daemon on macOS,
+// or a service of the service control manager on Windows.
+func install(args []string) error {
+
+	flags := flag.NewFlagSet("install", flag.ExitOnError)
+	configFile := flags.String("config", os.Getenv("EDRLAB_LCPSERVER_CONFIG"), "path of the configuration file")
+	printOnly := flags.Bool("print", false, "print the service definition instead of installing it")
+	flags.Parse(args)
+
+	if *configFile == "" {
+		return errors.New("missing configuration file")
+	}
+	config, err := filepath.Abs(*configFile)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	s := service{Name: serviceName, Description: "LCP License Server", Exe: exe, Config: config}
+
+	if *printOnly {
+		def, err := s.definition(runtime.GOOS)
+		if err != nil {
+			return err
+		}
+		fmt.Print(def)
+		return nil
+	}
+	return installService(s)
+}
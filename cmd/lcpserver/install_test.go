@@ -0,0 +1,39 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServiceDefinition(t *testing.T) {
+
+	s := service{Name: "lcpserver", Description: "LCP License Server", Exe: "/usr/local/bin/lcpserver", Config: "/etc/lcp/config.yaml"}
+
+	unit, err := s.definition("linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(unit, `ExecStart="/usr/local/bin/lcpserver" -config "/etc/lcp/config.yaml"`) || !strings.Contains(unit, "Type=notify") {
+		t.Errorf("Invalid systemd unit:\n%s", unit)
+	}
+	if path, _ := s.definitionPath("linux"); path != "/etc/systemd/system/lcpserver.service" {
+		t.Errorf("Invalid systemd unit path %s", path)
+	}
+
+	plist, err := s.definition("darwin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(plist, "<string>org.edrlab.lcpserver</string>") || !strings.Contains(plist, "<string>/etc/lcp/config.yaml</string>") {
+		t.Errorf("Invalid launchd daemon:\n%s", plist)
+	}
+
+	// Windows services are registered in the service control manager
+	if _, err = s.definition("windows"); err == nil {
+		t.Error("Expected no service definition on Windows")
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+
+	"github.com/edrlab/lcp-server/pkg/slo"
+)
+
+// metrics generates the Prometheus rules of the service level objectives of the configuration.
+func metrics(args []string, out io.Writer) error {
+
+	if len(args) == 0 || args[0] != "rules" {
+		return errors.New("usage: lcpserver metrics rules [-config file] [-profile name]")
+	}
+	flags := flag.NewFlagSet("metrics rules", flag.ExitOnError)
+	readConfig := configFlags(flags)
+	flags.Parse(args[1:])
+
+	c, err := readConfig()
+	if err != nil {
+		return err
+	}
+	rules, err := slo.Rules(c.SLO)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(rules)
+	return err
+}
@@ -0,0 +1,41 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestMetricsRules(t *testing.T) {
+
+	configFile := filepath.Join(t.TempDir(), "lcpserver.yaml")
+	if err := os.WriteFile(configFile, []byte("slo:\n  issuance_success: 0.99\n  status_latency: 300\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := metrics([]string{"rules", "-profile", "dev", "-config", configFile}, &out); err != nil {
+		t.Fatalf("Failed to generate the rules: %v", err)
+	}
+	var rules struct {
+		Groups []struct {
+			Rules []map[string]interface{}
+		}
+	}
+	if err := yaml.Unmarshal(out.Bytes(), &rules); err != nil || len(rules.Groups) != 1 {
+		t.Fatalf("Expected a rule group, got %v", err)
+	}
+	if s := out.String(); !strings.Contains(s, "(14.4 * 0.01)") || !strings.Contains(s, "p99_5m > 0.3") || !strings.Contains(s, "> 3600") {
+		t.Errorf("Expected the objectives of the configuration, got %s", s)
+	}
+	if err := metrics(nil, &out); err == nil {
+		t.Error("Expected a usage error")
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+// migrate applies, reverts or lists the schema migrations of the database of the server.
+func migrate(args []string, out io.Writer) error {
+
+	if len(args) == 0 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		return errors.New("usage: lcpserver migrate up|down|status [-config file] [-profile name] [-steps n] [-region name]")
+	}
+	action := args[0]
+	flags := flag.NewFlagSet("migrate "+action, flag.ExitOnError)
+	readConfig := configFlags(flags)
+	steps := flags.Int("steps", 1, "number of migrations reverted by down")
+	region := flags.String("region", "", "data residency region whose database is migrated, instead of the main database")
+	flags.Parse(args[1:])
+
+	c, err := readConfig()
+	if err != nil {
+		return err
+	}
+	dsn := c.Dsn
+	if *region != "" {
+		r, ok := c.Regions[*region]
+		if !ok {
+			return fmt.Errorf("unknown region %q", *region)
+		}
+		dsn = r.Dsn
+	}
+	m, err := stor.NewMigrator(dsn)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	var migrations []stor.Migration
+	switch action {
+	case "up":
+		migrations, err = m.Up()
+		for _, migration := range migrations {
+			fmt.Fprintf(out, "applied   %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(migrations) == 0 {
+			fmt.Fprintln(out, "The database schema is up to date")
+		}
+	case "down":
+		if *steps < 1 {
+			return errors.New("at least one migration must be reverted")
+		}
+		migrations, err = m.Down(*steps)
+		for _, migration := range migrations {
+			fmt.Fprintf(out, "reverted  %04d_%s\n", migration.Version, migration.Name)
+		}
+	case "status":
+		migrations, err = m.Status()
+		for _, migration := range migrations {
+			applied := "pending"
+			if migration.Applied != nil {
+				applied = "applied " + migration.Applied.Local().Format(time.RFC3339)
+			}
+			fmt.Fprintf(out, "%04d_%-30s %s\n", migration.Version, migration.Name, applied)
+		}
+	}
+	return err
+}
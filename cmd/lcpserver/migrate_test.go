@@ -0,0 +1,61 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "lcpserver.yaml")
+	dsn := "sqlite3://" + filepath.Join(dir, "lcp.sqlite")
+	regionDsn := "sqlite3://" + filepath.Join(dir, "lcp-eu.sqlite")
+	config := "dsn: \"" + dsn + "\"\nregions:\n  eu:\n    dsn: \"" + regionDsn + "\"\n    providers: [\"https://eu.example.com\"]\n"
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := migrate(append(args, "-profile", "dev", "-config", configFile), &out); err != nil {
+			t.Fatalf("Failed to migrate %v: %v", args, err)
+		}
+		return out.String()
+	}
+	if out := run("status"); !strings.Contains(out, "0001_initial") || !strings.Contains(out, "pending") {
+		t.Errorf("Expected the pending initial migration, got %s", out)
+	}
+	if out := run("up"); !strings.Contains(out, "applied   0001_initial") {
+		t.Errorf("Expected the initial migration to be applied, got %s", out)
+	}
+	if out := run("status"); strings.Contains(out, "pending") {
+		t.Errorf("Expected no pending migration, got %s", out)
+	}
+	if out := run("down", "-steps", "1"); !strings.Contains(out, "reverted") {
+		t.Errorf("Expected a migration to be reverted, got %s", out)
+	}
+
+	// the database of a region is migrated on its own
+	if out := run("status", "-region", "eu"); !strings.Contains(out, "0001_initial") || !strings.Contains(out, "pending") {
+		t.Errorf("Expected the pending migrations of the region, got %s", out)
+	}
+	if out := run("up", "-region", "eu"); !strings.Contains(out, "applied   0001_initial") {
+		t.Errorf("Expected the initial migration of the region to be applied, got %s", out)
+	}
+	if err := migrate([]string{"up", "-profile", "dev", "-config", configFile, "-region", "us"}, &bytes.Buffer{}); err == nil {
+		t.Error("Expected an error for an unknown region")
+	}
+
+	if err := migrate([]string{"sideways"}, &bytes.Buffer{}); err == nil {
+		t.Error("Expected an error for an unknown action")
+	}
+}
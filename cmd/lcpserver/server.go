@@ -3,182 +3,138 @@
 // specified in the Github project LICENSE file.
 
 // LCP Server generates LCP licenses.
+//
+// Usage:
+//
+//	lcpserver [-config file] [-profile name]          runs the server
+//	lcpserver check [-config file] [-profile name]    checks the configuration and the resources required by the server
+//	lcpserver init [-i] [-config file] [-dir path]    generates a configuration, a test certificate and the database
+//	lcpserver migrate up|down|status [-config file]   applies, reverts (-steps n) or lists the schema migrations
+//	lcpserver db analyze [-config file] [-v]          reports the hot queries not served by an index
+//	lcpserver testca [-dir path] [-provider uri]      generates a test CA and provider certificate
+//	lcpserver metrics rules [-config file]            prints the Prometheus alerting rules of the service level objectives
+//	lcpserver install [-config file]                  installs the server as a system service
+//	lcpserver uninstall                               removes the system service
 package main
 
 import (
-	"crypto/tls"
+	"context"
+	"flag"
+	"fmt"
 	"log"
-	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/render"
-
-	"github.com/edrlab/lcp-server/pkg/api"
 	"github.com/edrlab/lcp-server/pkg/conf"
-	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/server"
 )
 
-// Server context
-type Server struct {
-	*conf.Config
-	stor.Store
-	Cert   *tls.Certificate
-	Router *chi.Mux
-}
+// name of the system service
+const serviceName = "lcpserver"
 
 func main() {
 
-	s := Server{}
-
-	configFile := os.Getenv("EDRLAB_LCPSERVER_CONFIG")
-	if configFile == "" {
-		panic("Failed to retrieve the configuration file path.")
-	}
-
-	c, err := conf.ReadConfig(configFile)
-	if err != nil {
-		panic("Failed to read the configuration.")
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "init":
+			err = initialize(os.Args[2:], os.Stdin, os.Stdout)
+		case "check":
+			err = check(os.Args[2:])
+		case "migrate":
+			err = migrate(os.Args[2:], os.Stdout)
+		case "db":
+			err = db(os.Args[2:], os.Stdout)
+		case "testca":
+			err = generateTestCA(os.Args[2:], os.Stdout)
+		case "metrics":
+			err = metrics(os.Args[2:], os.Stdout)
+		case "install":
+			err = install(os.Args[2:])
+		case "uninstall":
+			err = uninstallService(serviceName)
+		default:
+			run(os.Args[1:])
+			return
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
+	run(nil)
+}
 
-	s.Config = c
-
-	s.Initialize()
-
-	log.Printf("The server is ready.")
-
-	if c.Port == 0 {
-		c.Port = 8081
+// configFlags declares the flags selecting the configuration, and returns a function reading it.
+func configFlags(flags *flag.FlagSet) func() (*conf.Config, error) {
+	configFile := flags.String("config", os.Getenv("EDRLAB_LCPSERVER_CONFIG"), "path of the configuration file")
+	profile := flags.String("profile", os.Getenv("EDRLAB_LCPSERVER_PROFILE"),
+		"configuration profile providing the defaults: "+strings.Join(conf.ProfileNames(), ", "))
+	return func() (*conf.Config, error) {
+		return conf.ReadProfileConfig(*profile, *configFile)
 	}
-
-	s.Run(":" + strconv.Itoa(c.Port))
 }
 
-// Initialize sets up the database and routes
-func (s *Server) Initialize() {
-	var err error
+// run starts the server, as a system service if launched by the Windows service manager
+func run(args []string) {
+
+	flags := flag.NewFlagSet("lcpserver", flag.ExitOnError)
+	readConfig := configFlags(flags)
+	flags.Parse(args)
 
-	// Setup the database
-	s.Store, err = stor.DBSetup(s.Config.Dsn)
+	c, err := readConfig()
 	if err != nil {
-		panic("Database setup failed.")
+		log.Fatalf("Failed to read the configuration: %v", err)
 	}
 
-	// Setup the X509 certificate
-	var certFile, privKeyFile string
-	if certFile = s.Config.Certificate.Cert; certFile == "" {
-		panic("Must specify a certificate.")
-	}
-	if privKeyFile = s.Config.Certificate.PrivateKey; privKeyFile == "" {
-		panic("Must specify a private key.")
-	}
-	cert, err := tls.LoadX509KeyPair(certFile, privKeyFile)
+	s, err := server.New(c)
 	if err != nil {
 		panic(err)
 	}
-	s.Cert = &cert
-
-	// Setup the routes
-	s.Router = s.setRoutes()
-}
-
-func (s *Server) setRoutes() *chi.Mux {
-
-	// Set a context for handlers
-	h := api.NewAPIHandler(s.Config, s.Store, s.Cert)
-
-	// Define the router
-	r := chi.NewRouter()
 
-	//r.Use(middleware.RequestID)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-	//r.Use(middleware.URLFormat)
-
-	// Public routes
-	// Heartbeat
-	r.Group(func(r chi.Router) {
-		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-			w.Write([]byte("This is the LCP Server running!"))
-		})
-	})
-
-	// Status document management
-	r.Group(func(r chi.Router) {
-		r.Use(render.SetContentType(render.ContentTypeJSON))
-		r.Get("/status/{licenseID}", h.StatusDoc)   // Get /status/123
-		r.Post("/register/{licenseID}", h.Register) // POST /register/123
-		r.Put("/renew/{licenseID}", h.Renew)        // PUT /renew/123
-		r.Put("/return/{licenseID}", h.Return)      // PUT /return/123
-	})
-
-	// Private Routes
-	// Require Authentication
-	credentials := make(map[string]string)
-	credentials[s.Config.Login.User] = s.Config.Login.Password
-
-	r.Group(func(r chi.Router) {
-		r.Use(middleware.BasicAuth("restricted", credentials))
-		r.Use(render.SetContentType(render.ContentTypeJSON))
-
-		// Publications, CRUD
-		r.Route("/publications", func(r chi.Router) {
-			r.With(paginate).Get("/", h.ListPublications)
-			r.With(paginate).Get("/search", h.SearchPublications) // GET /publication/search{?format}
-			r.Post("/", h.CreatePublication)                      // POST /publications
-
-			r.Route("/{publicationID}", func(r chi.Router) {
-				r.Get("/", h.GetPublication)       // GET /publications/123
-				r.Put("/", h.UpdatePublication)    // PUT /publications/123
-				r.Delete("/", h.DeletePublication) // DELETE /publications/123
-			})
-		})
-
-		// LicenseInfo, CRUD
-		r.Route("/licenseinfo", func(r chi.Router) {
-			r.With(paginate).Get("/", h.ListLicenses)
-			r.With(paginate).Get("/search", h.SearchLicenses) // GET /licenses/search{?pub,user,status,count}
-			r.Post("/", h.CreateLicense)                      // POST /licenses
-
-			r.Route("/{licenseID}", func(r chi.Router) {
-				r.Get("/", h.GetLicense)       // GET /licenses/123
-				r.Put("/", h.UpdateLicense)    // PUT /licenses/123
-				r.Delete("/", h.DeleteLicense) // DELETE /licenses/123
-			})
-		})
-
-		// License generation
-		r.Route("/licenses/", func(r chi.Router) {
-			r.Post("/", h.GenerateLicense) // POST /licenses
-
-			r.Route("/{licenseID}", func(r chi.Router) {
-				r.Post("/", h.GetFreshLicense) // POST /licenses/123
-			})
-		})
-
-		// License revocation
-		r.Put("/revoke/{licenseID}", h.Revoke) // PUT /revoke/123
-
-	})
+	log.Printf("The server is ready.")
 
-	return r
+	isService, err := runService(serviceName, s.Serve, func() error { return shutdown(s) })
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !isService {
+		if err = serve(s); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("The server is stopped.")
+	}
 }
 
-// Run starts the server
-func (s *Server) Run(port string) {
-	log.Fatal(http.ListenAndServe(port, s.Router))
-
-	//  TODO sort of db.Close()
+// serve runs the server until it fails, or until it is stopped by SIGTERM or SIGINT,
+// e.g. during a rolling deploy: the requests in process are then drained.
+func serve(s *server.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	errc := make(chan error, 1)
+	go func() { errc <- s.Serve() }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	// a second signal stops the process at once
+	stop()
+	log.Printf("Shutting down, the requests in process are drained.")
+	return shutdown(s)
 }
 
-// paginate is a stub, but very possible to implement middleware logic
-// to handle the request params for handling a paginated request.
-func paginate(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// just a stub.. some ideas are to look at URL query params for something like
-		// the page number, or the limit, and send a query cursor down the chain
-		next.ServeHTTP(w, r)
-	})
+// shutdown stops the server gracefully, within the shutdown timeout of the configuration
+func shutdown(s *server.Server) error {
+	timeout := time.Duration(s.Config.ShutdownTimeout) * time.Second
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.Shutdown(ctx)
 }
@@ -0,0 +1,53 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// installService writes the service definition; the service manager must then load it.
+func installService(s service) error {
+	path, err := s.definitionPath(runtime.GOOS)
+	if err != nil {
+		return err
+	}
+	def, err := s.definition(runtime.GOOS)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(path, []byte(def), 0644); err != nil {
+		return err
+	}
+	fmt.Println("Installed", path)
+	if runtime.GOOS == "darwin" {
+		fmt.Println("Start the service with: launchctl load -w", path)
+	} else {
+		fmt.Printf("Start the service with: systemctl daemon-reload && systemctl enable --now %s\n", s.Name)
+	}
+	return nil
+}
+
+// uninstallService removes the service definition; the service must be stopped first.
+func uninstallService(name string) error {
+	path, err := service{Name: name}.definitionPath(runtime.GOOS)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil {
+		return err
+	}
+	fmt.Println("Removed", path)
+	return nil
+}
+
+// runService returns false, services are started as regular processes by systemd or launchd.
+func runService(name string, serve func() error, shutdown func() error) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// installService registers the server in the service control manager, started automatically
+// and restarted after a failure.
+func installService(s service) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if existing, err := m.OpenService(s.Name); err == nil {
+		existing.Close()
+		return fmt.Errorf("the service %s already exists", s.Name)
+	}
+	ws, err := m.CreateService(s.Name, s.Exe, mgr.Config{
+		DisplayName: s.Description,
+		StartType:   mgr.StartAutomatic,
+	}, "-config", s.Config)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	restart := mgr.RecoveryAction{Type: mgr.ServiceRestart, Delay: 5 * time.Second}
+	if err = ws.SetRecoveryActions([]mgr.RecoveryAction{restart, restart, restart}, 24*60*60); err != nil {
+		return err
+	}
+	fmt.Printf("Installed the service %s\n", s.Name)
+	return nil
+}
+
+// uninstallService removes the server from the service control manager.
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	ws, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("the service %s is not installed", name)
+	}
+	defer ws.Close()
+	if err = ws.Delete(); err != nil {
+		return err
+	}
+	fmt.Printf("Removed the service %s\n", name)
+	return nil
+}
+
+// windowsService answers the requests of the service control manager.
+type windowsService struct {
+	serve    func() error
+	shutdown func() error // stops the server gracefully
+}
+
+// Execute runs the server until it fails or the service is stopped.
+func (ws *windowsService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+
+	changes <- svc.Status{State: svc.StartPending}
+	errc := make(chan error, 1)
+	go func() { errc <- ws.serve() }()
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case err := <-errc:
+			log.Printf("The server failed: %v", err)
+			return true, 1
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				if err := ws.shutdown(); err != nil {
+					log.Printf("The server did not stop gracefully: %v", err)
+				}
+				return false, 0
+			}
+		}
+	}
+}
+
+// runService runs the server as a service if it was launched by the service control manager.
+func runService(name string, serve func() error, shutdown func() error) (bool, error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false, err
+	}
+	return true, svc.Run(name, &windowsService{serve: serve, shutdown: shutdown})
+}
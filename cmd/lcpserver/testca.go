@@ -0,0 +1,56 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/testca"
+)
+
+// generateTestCA writes a local CA, and a provider certificate issued by this CA with its private key.
+func generateTestCA(args []string, out io.Writer) error {
+
+	flags := flag.NewFlagSet("testca", flag.ExitOnError)
+	dir := flags.String("dir", "lcp-testca", "directory of the generated files")
+	provider := flags.String("provider", "http://localhost:8081", "provider uri, common name of the provider certificate")
+	days := flags.Int("days", 365, "validity of the provider certificate, in days")
+	flags.Parse(args)
+
+	if *days <= 0 {
+		return fmt.Errorf("invalid validity of %d days", *days)
+	}
+	if err := os.MkdirAll(*dir, 0700); err != nil {
+		return err
+	}
+	ca, err := testca.New("LCP test CA")
+	if err != nil {
+		return err
+	}
+	cred, err := ca.Provider(*provider, time.Now().AddDate(0, 0, *days))
+	if err != nil {
+		return err
+	}
+
+	files := struct{ ca, caKey, cert, key string }{
+		filepath.Join(*dir, "ca.pem"), filepath.Join(*dir, "ca-key.pem"),
+		filepath.Join(*dir, "cert.pem"), filepath.Join(*dir, "privkey.pem"),
+	}
+	if err = ca.WriteFiles(files.ca, files.caKey); err != nil {
+		return err
+	}
+	if err = cred.WriteFiles(files.cert, files.key); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "CA certificate:       %s\nCA private key:       %s\n", files.ca, files.caKey)
+	fmt.Fprintf(out, "Provider certificate: %s\nProvider private key: %s\n", files.cert, files.key)
+	fmt.Fprintln(out, "\nFor evaluation only: reading applications only accept licenses signed by a provider certificate delivered by EDRLab.")
+	return nil
+}
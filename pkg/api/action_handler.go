@@ -0,0 +1,185 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// ListActions returns the status changes scheduled on a license, pending or executed, in the order of execution.
+func (h *APIHandler) ListActions(w http.ResponseWriter, r *http.Request) {
+
+	var licenseID string
+	if licenseID = getLicenseID(w, r); licenseID == "" {
+		return
+	}
+	st := h.store(r)
+	if _, err := st.License().Get(licenseID); err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	actions, err := st.Action().List(licenseID)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err := render.RenderList(w, r, NewActionListResponse(actions)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// ScheduleAction schedules a status change of a license at a future time: a revocation (or the cancellation
+// of a ready license) or a renew. The action is executed by the scheduler of the server.
+func (h *APIHandler) ScheduleAction(w http.ResponseWriter, r *http.Request) {
+
+	var licenseID string
+	if licenseID = getLicenseID(w, r); licenseID == "" {
+		return
+	}
+	st := h.store(r)
+	license, err := st.License().Get(licenseID)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	// get the payload
+	data := &ActionRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if !data.At.After(h.Clock()) {
+		render.Render(w, r, ErrInvalidRequest(errors.New("an action must be scheduled in the future")))
+		return
+	}
+	if data.End != nil && !data.End.After(data.At) {
+		render.Render(w, r, ErrInvalidRequest(errors.New("the new end date must be after the time of the action")))
+		return
+	}
+	if license.Status != stor.STATUS_READY && license.Status != stor.STATUS_ACTIVE {
+		render.Render(w, r, ErrInvalidRequest(errors.New("scheduling an action on a license that is neither ready nor active is not allowed")))
+		return
+	}
+	action := &stor.Action{
+		LicenseID: licenseID,
+		Type:      data.Type,
+		At:        data.At.Truncate(time.Second),
+		End:       data.End,
+		Reason:    data.Reason,
+		Actor:     data.Actor,
+	}
+	if action.Actor == "" {
+		action.Actor, _, _ = r.BasicAuth()
+	}
+	if err := action.Validate(); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// db create
+	if err := st.Action().Create(action); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	if err := render.Render(w, r, NewActionResponse(action)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// CancelAction cancels a pending action, and returns it. An executed action cannot be cancelled.
+func (h *APIHandler) CancelAction(w http.ResponseWriter, r *http.Request) {
+
+	var licenseID string
+	if licenseID = getLicenseID(w, r); licenseID == "" {
+		return
+	}
+	st := h.store(r)
+	if _, err := st.License().Get(licenseID); err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	actionID, err := strconv.ParseUint(chi.URLParam(r, "actionID"), 10, 0)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	action, err := st.Action().Get(licenseID, uint(actionID))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if action.Executed != nil {
+		render.Render(w, r, ErrConflict(errors.New("the action is already executed")))
+		return
+	}
+
+	// db delete; the action may have been executed meanwhile
+	if err := st.Action().Delete(action); err != nil {
+		render.Render(w, r, ErrConflict(errors.New("the action is already executed")))
+		return
+	}
+
+	if err := render.Render(w, r, NewActionResponse(action)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// --
+// Request and Response payloads for the REST api.
+// --
+
+// ActionRequest is the request payload of a scheduled action.
+type ActionRequest struct {
+	Type   string     `json:"type"`
+	At     time.Time  `json:"at"`
+	End    *time.Time `json:"end"`
+	Reason string     `json:"reason"`
+	Actor  string     `json:"actor"`
+}
+
+// ActionResponse is the response payload of a scheduled action.
+type ActionResponse struct {
+	*stor.Action
+}
+
+// NewActionListResponse creates a rendered list of actions.
+func NewActionListResponse(actions *[]stor.Action) []render.Renderer {
+	list := []render.Renderer{}
+	for i := 0; i < len(*actions); i++ {
+		list = append(list, NewActionResponse(&(*actions)[i]))
+	}
+	return list
+}
+
+// NewActionResponse creates a rendered action.
+func NewActionResponse(action *stor.Action) *ActionResponse {
+	return &ActionResponse{Action: action}
+}
+
+// Bind post-processes requests after unmarshalling.
+func (a *ActionRequest) Bind(r *http.Request) error {
+	if a.Type == "" || a.At.IsZero() {
+		return errors.New("missing required type or time of the action")
+	}
+	if a.End != nil && a.Type != stor.ACTION_RENEW {
+		return errors.New("an end date is only allowed for a renew")
+	}
+	return nil
+}
+
+// Render processes responses before marshalling.
+func (a *ActionResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
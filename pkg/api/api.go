@@ -7,23 +7,112 @@ package api
 
 import (
 	"crypto/tls"
+	"net/http"
+	"time"
 
+	"github.com/edrlab/lcp-server/pkg/cache"
 	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/job"
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/oauth"
+	"github.com/edrlab/lcp-server/pkg/sign"
 	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/storage"
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
 )
 
 // APIHandler contains the context required by http handlers.
+// Every dependency is exported, so that tests and applications embedding the server can replace it.
 type APIHandler struct {
 	*conf.Config // TODO: change for an interface (dependency)
 	stor.Store
-	Cert *tls.Certificate
+	Cert          *tls.Certificate
+	Signer        sign.Signer                 // signs licenses; if nil, a signer is created from the certificate
+	Logger        log.FieldLogger             // logs the events which do not interrupt a request
+	Clock         func() time.Time            // returns the current time
+	Tiering       *storage.Tiering            // nil if publication files are not managed by the server
+	RegionTiering map[string]*storage.Tiering // storage of the publications of the data residency regions, by provider URI
+	Jobs          *job.Runner                 // executes the bulk operations
+	Cache         cache.Cache                 // caches the responses of the public routes; nil if they are not cached
+	Searches      *SearchLimits               // caps the results of the searches; the defaults of the configuration if nil
+	OAuth         *oauth.Server               // issues the tokens of the token endpoint; nil if it is disabled
+
+	warmup *warmup // warmup of the handler, if started
 }
 
 // NewAPIHandler returns a new API context
 func NewAPIHandler(cf *conf.Config, st stor.Store, cr *tls.Certificate) *APIHandler {
 	return &APIHandler{
-		Config: cf,
-		Store:  st,
-		Cert:   cr,
+		Config:   cf,
+		Store:    st,
+		Cert:     cr,
+		Logger:   log.StandardLogger(),
+		Clock:    time.Now,
+		Jobs:     job.NewRunner(st),
+		Searches: NewSearchLimits(cf.Search),
+	}
+}
+
+// searchLimits returns the limits of the searches.
+func (h *APIHandler) searchLimits() *SearchLimits {
+	if h.Searches == nil {
+		return NewSearchLimits(conf.Search{})
+	}
+	return h.Searches
+}
+
+// signer returns the signer of licenses, or a signer created from the certificate if none is set, e.g. in tests.
+// The signature limits only apply to the signer set by the server.
+func (h *APIHandler) signer() (sign.Signer, error) {
+	if h.Signer != nil {
+		return h.Signer, nil
+	}
+	if h.Cert == nil {
+		return nil, nil
+	}
+	return sign.NewSigner(h.Cert)
+}
+
+// licenseHandler returns the license status handler applicable to a request.
+func (h *APIHandler) licenseHandler(r *http.Request) *lic.LicenseHandler {
+	lh := lic.NewLicenseHandler(h.requestConfig(r), h.store(r))
+	lh.Clock = h.Clock
+	return lh
+}
+
+// store returns the store bound to the context of a request:
+// queries are cancelled when the client disconnects or the request deadline is exceeded.
+// The publications and licenses are those of the provider the principal of the request is restricted to, if any.
+// Otherwise, the license or publication of the route is looked up in the data residency regions.
+// The changes of the licenses are recorded in their audit log as made by the caller of the request.
+func (h *APIHandler) store(r *http.Request) stor.Store {
+	st := h.Store.WithContext(stor.WithActor(r.Context(), actor(r)))
+	if p := PrincipalFromContext(r.Context()); p != nil && p.Provider != "" {
+		return st.ForProvider(p.Provider)
+	}
+	if licenseID := chi.URLParam(r, "licenseID"); licenseID != "" {
+		return st.ForLicense(licenseID)
+	}
+	if publicationID := chi.URLParam(r, "publicationID"); publicationID != "" {
+		return st.ForPublication(publicationID)
+	}
+	return st
+}
+
+// tiering returns the storage of the publications of a provider, in its data residency region if any.
+func (h *APIHandler) tiering(provider string) *storage.Tiering {
+	if t, ok := h.RegionTiering[provider]; ok {
+		return t
+	}
+	return h.Tiering
+}
+
+// provider returns the provider of the licenses issued on a request: the provider the principal of the request
+// is restricted to, or else the provider of the configuration.
+func (h *APIHandler) provider(r *http.Request) string {
+	if p := PrincipalFromContext(r.Context()); p != nil && p.Provider != "" {
+		return p.Provider
 	}
+	return h.Config.License.Provider
 }
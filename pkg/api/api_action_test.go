@@ -0,0 +1,64 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+func TestScheduleActions(t *testing.T) {
+
+	inLic, _ := createLicense(t)
+	defer deleteLicense(t, inLic.UUID)
+	path := "/licenses/" + inLic.UUID + "/actions"
+
+	at := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	end := inLic.End.AddDate(0, 0, 10).UTC().Truncate(time.Second)
+	tests := []struct {
+		name    string
+		payload string
+		code    int
+	}{
+		{"revoke", fmt.Sprintf(`{"type": "revoke", "at": "%s", "reason": "End of the promotion"}`, at.Format(time.RFC3339)), http.StatusCreated},
+		{"renew", fmt.Sprintf(`{"type": "renew", "at": "%s", "end": "%s"}`, at.Format(time.RFC3339), end.Format(time.RFC3339)), http.StatusCreated},
+		{"past", fmt.Sprintf(`{"type": "revoke", "at": "%s"}`, at.AddDate(0, 0, -1).Format(time.RFC3339)), http.StatusBadRequest},
+		{"unknown type", fmt.Sprintf(`{"type": "return", "at": "%s"}`, at.Format(time.RFC3339)), http.StatusBadRequest},
+		{"end of a revoke", fmt.Sprintf(`{"type": "revoke", "at": "%s", "end": "%s"}`, at.Format(time.RFC3339), end.Format(time.RFC3339)), http.StatusBadRequest},
+		{"missing time", `{"type": "revoke"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(tt.payload))
+		req.Header.Set("Content-Type", "application/json")
+		if !checkResponseCode(t, tt.code, executeRequest(req)) {
+			t.Errorf("Unexpected status code for the %s action", tt.name)
+		}
+	}
+
+	// the pending actions are listed in the order of execution
+	req, _ := http.NewRequest("GET", path, nil)
+	response := executeRequest(req)
+	var actions []stor.Action
+	if checkResponseCode(t, http.StatusOK, response) {
+		if err := json.Unmarshal(response.Body.Bytes(), &actions); err != nil {
+			t.Fatal(err)
+		}
+		if len(actions) != 2 || actions[0].Type != stor.ACTION_REVOKE || !actions[0].At.Equal(at) || actions[0].Executed != nil ||
+			actions[1].End == nil || !actions[1].End.Equal(end) {
+			t.Fatalf("Unexpected actions %s", response.Body)
+		}
+	}
+
+	// a pending action can be cancelled once
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("%s/%d", path, actions[0].ID), nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("%s/%d", path, actions[0].ID), nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+
+	req, _ = http.NewRequest("GET", "/licenses/unknown/actions", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+func TestListAudit(t *testing.T) {
+
+	inLic, _ := createLicense(t)
+	defer deleteLicense(t, inLic.UUID)
+
+	// the caller of a change is its actor
+	end := inLic.End.AddDate(0, 0, 10)
+	inLic.End = &end
+	data, _ := json.Marshal(inLic)
+	req, _ := http.NewRequest("PUT", "/licenseinfo/"+inLic.UUID, bytes.NewReader(data))
+	req.SetBasicAuth("cms", "secret")
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+
+	// and the device of a status request
+	req, _ = http.NewRequest("POST", "/licenses/"+inLic.UUID+"/register?id=d1&name=device1", nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+
+	req, _ = http.NewRequest("GET", "/licenses/"+inLic.UUID+"/audit", nil)
+	response := executeRequest(req)
+	if !checkResponseCode(t, http.StatusOK, response) {
+		return
+	}
+	var records []stor.AuditRecord
+	if err := json.Unmarshal(response.Body.Bytes(), &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 audit records, got %s", response.Body)
+	}
+	if records[0].Action != stor.AUDIT_CREATE || records[1].Action != stor.AUDIT_RENEW || records[1].Actor != "cms" ||
+		records[2].Action != stor.AUDIT_REGISTER || records[2].Actor != "device:d1" {
+		t.Errorf("Unexpected audit records %s", response.Body)
+	}
+	if _, ok := records[1].Changes["end"]; !ok {
+		t.Errorf("Expected the change of the end date, got %s", response.Body)
+	}
+
+	req, _ = http.NewRequest("GET", "/licenses/unknown/audit", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+)
+
+func TestBasicAuth(t *testing.T) {
+
+	logins := []conf.Login{{User: "admin", Password: "secret"}, {User: "cms", Password: "cms-secret"}, {User: "disabled"}}
+	handler := BasicAuth("restricted", logins)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		user, password string
+		code           int
+	}{
+		{"admin", "secret", http.StatusOK},
+		{"cms", "cms-secret", http.StatusOK},
+		{"cms", "secret", http.StatusUnauthorized},
+		{"admin", "cms-secret", http.StatusUnauthorized},
+		{"disabled", "", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("GET", "/licenses/", nil)
+		if tc.user != "" {
+			req.SetBasicAuth(tc.user, tc.password)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != tc.code {
+			t.Errorf("Expected %d for %s:%s, got %d", tc.code, tc.user, tc.password, rr.Code)
+		}
+		if rr.Code == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") != `Basic realm="restricted"` {
+			t.Errorf("Expected a basic auth challenge, got %q", rr.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
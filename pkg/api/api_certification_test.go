@@ -0,0 +1,79 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/lic"
+)
+
+func TestCertificationMode(t *testing.T) {
+
+	s.Config.Certification = true
+	defer func() { s.Config.Certification = false }()
+
+	// a 128 bits key and a checksum which is not a SHA-256 hash are rejected
+	pub := newPublication()
+	data, _ := json.Marshal(pub)
+	req, _ := http.NewRequest("POST", "/publications/", bytes.NewReader(data))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response)
+	if !strings.Contains(response.Body.String(), "certification") {
+		t.Errorf("Expected a certification error, got %s", response.Body)
+	}
+
+	// a conforming publication is accepted
+	pub.EncryptionKey = make([]byte, 32)
+	rand.Read(pub.EncryptionKey)
+	hash := sha256.Sum256([]byte(pub.UUID))
+	pub.Checksum = base64.StdEncoding.EncodeToString(hash[:])
+	data, _ = json.Marshal(pub)
+	req, _ = http.NewRequest("POST", "/publications/", bytes.NewReader(data))
+	if !checkResponseCode(t, http.StatusCreated, executeRequest(req)) {
+		t.FailNow()
+	}
+	defer deletePublication(t, pub.UUID)
+
+	// rights ending before they start are rejected
+	payload := newLicenseRequest(pub.UUID)
+	end := payload.Start.Add(-time.Hour)
+	payload.End = &end
+	data, _ = json.Marshal(payload)
+	req, _ = http.NewRequest("POST", "/licenses/", bytes.NewReader(data))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+	payload.Profile = "http://readium.org/lcp/unknown-profile"
+	payload.End = nil
+	data, _ = json.Marshal(payload)
+	req, _ = http.NewRequest("POST", "/licenses/", bytes.NewReader(data))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+
+	// a generated license conforms to the schema, and its signature is valid
+	payload = newLicenseRequest(pub.UUID)
+	data, _ = json.Marshal(payload)
+	req, _ = http.NewRequest("POST", "/licenses/", bytes.NewReader(data))
+	response = executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		var license lic.License
+		json.Unmarshal(response.Body.Bytes(), &license)
+		req, _ = http.NewRequest("DELETE", "/licenseinfo/"+license.UUID, nil)
+		checkResponseCode(t, http.StatusOK, executeRequest(req))
+	}
+
+	// as are the rights of a license info ending before they start
+	license := newLicense(pub.UUID)
+	license.End = &end
+	data, _ = json.Marshal(license)
+	req, _ = http.NewRequest("POST", "/licenseinfo/", bytes.NewReader(data))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response)
+	if !strings.Contains(response.Body.String(), "certification") {
+		t.Errorf("Expected a certification error, got %s", response.Body)
+	}
+}
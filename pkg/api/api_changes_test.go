@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func listChanges(t *testing.T, since string, limit string) *ChangesResponse {
+	req, _ := http.NewRequest("GET", "/publications/changes?since="+url.QueryEscape(since)+"&limit="+limit, nil)
+	response := executeRequest(req)
+	if !checkResponseCode(t, http.StatusOK, response) {
+		t.FailNow()
+	}
+	out := &ChangesResponse{}
+	if err := json.Unmarshal(response.Body.Bytes(), out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestListPublicationChanges(t *testing.T) {
+
+	// the cursor of the end of the feed
+	out := listChanges(t, "", "1000")
+	for out.HasMore {
+		out = listChanges(t, out.Cursor, "1000")
+	}
+	cursor := out.Cursor
+
+	a, response := createPublication(t)
+	checkResponseCode(t, http.StatusCreated, response)
+	b, response := createPublication(t)
+	checkResponseCode(t, http.StatusCreated, response)
+	defer deletePublication(t, b.UUID)
+	time.Sleep(10 * time.Millisecond)
+	checkResponseCode(t, http.StatusOK, deletePublication(t, a.UUID))
+
+	// a is created then deleted, b is created
+	out = listChanges(t, cursor, "1")
+	if len(out.Changes) != 1 || !out.HasMore || out.Changes[0].UUID != b.UUID || out.Changes[0].Change != CHANGE_CREATED || out.Changes[0].Publication == nil {
+		t.Fatalf("Expected the creation of %s, got %+v", b.UUID, out)
+	}
+	out = listChanges(t, out.Cursor, "10")
+	if len(out.Changes) != 1 || out.HasMore || out.Changes[0].UUID != a.UUID || out.Changes[0].Change != CHANGE_DELETED || out.Changes[0].Publication != nil {
+		t.Fatalf("Expected the deletion of %s, got %+v", a.UUID, out)
+	}
+
+	// nothing new: the cursor is kept
+	if next := listChanges(t, out.Cursor, "10"); len(next.Changes) != 0 || next.Cursor != out.Cursor {
+		t.Errorf("Expected no change, got %+v", next)
+	}
+
+	// a time is accepted, a malformed cursor is not
+	if out = listChanges(t, time.Now().Add(time.Hour).Format(time.RFC3339), "10"); len(out.Changes) != 0 {
+		t.Errorf("Expected no future change, got %+v", out)
+	}
+	for _, query := range []string{"since=yesterday", "limit=0", "limit=1001"} {
+		req, _ := http.NewRequest("GET", "/publications/changes?"+query, nil)
+		checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/google/uuid"
+)
+
+func TestCollections(t *testing.T) {
+
+	pub1, _ := createPublication(t)
+	pub2, _ := createPublication(t)
+	defer deletePublication(t, pub1.UUID)
+	defer deletePublication(t, pub2.UUID)
+
+	createCollection := func(collectionType string) *stor.Collection {
+		collection := &stor.Collection{UUID: uuid.New().String(), Type: collectionType, Title: "Collection"}
+		data, _ := json.Marshal(collection)
+		req, _ := http.NewRequest("POST", "/collections/", bytes.NewReader(data))
+		if !checkResponseCode(t, http.StatusCreated, executeRequest(req)) {
+			t.FailNow()
+		}
+		return collection
+	}
+	bundle := createCollection(stor.COLLECTION_BUNDLE)
+	series := createCollection(stor.COLLECTION_SERIES)
+	defer func() {
+		req, _ := http.NewRequest("DELETE", "/collections/"+bundle.UUID, nil)
+		checkResponseCode(t, http.StatusOK, executeRequest(req))
+	}()
+
+	// the type of a collection is series or bundle
+	data, _ := json.Marshal(&stor.Collection{UUID: uuid.New().String(), Type: "shelf", Title: "Shelf"})
+	req, _ := http.NewRequest("POST", "/collections/", bytes.NewReader(data))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+
+	// members are added at a position; an unknown publication is rejected
+	setMember := func(collectionID, publicationID string, position int) *http.Response {
+		req, _ := http.NewRequest("PUT", "/collections/"+collectionID+"/members/"+publicationID,
+			strings.NewReader(fmt.Sprintf(`{"position":%d}`, position)))
+		return executeRequest(req).Result()
+	}
+	for i, pubID := range []string{pub1.UUID, pub2.UUID} {
+		if code := setMember(bundle.UUID, pubID, i+1).StatusCode; code != http.StatusOK {
+			t.Fatalf("Expected 200 adding a member, got %d", code)
+		}
+	}
+	if code := setMember(series.UUID, pub2.UUID, 1).StatusCode; code != http.StatusOK {
+		t.Errorf("Expected 200 adding a member, got %d", code)
+	}
+	if code := setMember(bundle.UUID, uuid.New().String(), 3).StatusCode; code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown publication, got %d", code)
+	}
+
+	req, _ = http.NewRequest("GET", "/collections/"+bundle.UUID+"/members", nil)
+	response := executeRequest(req)
+	var members []stor.CollectionMember
+	json.Unmarshal(response.Body.Bytes(), &members)
+	if len(members) != 2 || members[0].PublicationID != pub1.UUID || members[1].Position != 2 {
+		t.Errorf("Unexpected members %+v", members)
+	}
+	req, _ = http.NewRequest("GET", "/publications/"+pub2.UUID+"/collections", nil)
+	response = executeRequest(req)
+	var collections []stor.Collection
+	json.Unmarshal(response.Body.Bytes(), &collections)
+	if len(collections) != 2 {
+		t.Errorf("Expected 2 collections, got %d", len(collections))
+	}
+
+	// a single operation licenses every member of a bundle
+	start := time.Now()
+	end := start.AddDate(0, 0, 10)
+	data, _ = json.Marshal(&stor.LicenseInfo{Provider: "http://edrlab.org", UserID: uuid.New().String(), Start: &start, End: &end})
+	req, _ = http.NewRequest("POST", "/collections/"+bundle.UUID+"/licenses", bytes.NewReader(data))
+	response = executeRequest(req)
+	if !checkResponseCode(t, http.StatusOK, response) {
+		t.FailNow()
+	}
+	var batch BatchResponse
+	json.Unmarshal(response.Body.Bytes(), &batch)
+	if batch.Created != 2 || batch.Failed != 0 {
+		t.Fatalf("Expected 2 licenses, got %+v", batch)
+	}
+	for _, result := range batch.Results {
+		defer deleteLicense(t, result.UUID)
+	}
+
+	// the licenses are linked by the bundle
+	req, _ = http.NewRequest("GET", "/licenseinfo/search?bundle="+bundle.UUID, nil)
+	response = executeRequest(req)
+	var licenses []stor.LicenseInfo
+	json.Unmarshal(response.Body.Bytes(), &licenses)
+	if len(licenses) != 2 || licenses[0].PublicationID == licenses[1].PublicationID || licenses[0].BundleID != bundle.UUID {
+		t.Errorf("Unexpected bundle licenses %+v", licenses)
+	}
+
+	// a series is not licensed as a whole
+	req, _ = http.NewRequest("POST", "/collections/"+series.UUID+"/licenses", bytes.NewReader(data))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+
+	// members are removed with their collection
+	req, _ = http.NewRequest("DELETE", "/collections/"+bundle.UUID+"/members/"+pub1.UUID, nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	req, _ = http.NewRequest("DELETE", "/collections/"+bundle.UUID+"/members/"+pub1.UUID, nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+	req, _ = http.NewRequest("DELETE", "/collections/"+series.UUID, nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	req, _ = http.NewRequest("GET", "/collections/"+series.UUID+"/members", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/google/uuid"
+)
+
+func TestCoupons(t *testing.T) {
+
+	inPub, _ := createPublication(t)
+	defer deletePublication(t, inPub.UUID)
+
+	// gift cards: codes generated by the server
+	copy := int32(5)
+	data, _ := json.Marshal(&stor.Coupon{PublicationID: inPub.UUID, Days: 7, Copy: &copy, MaxUses: 1})
+	req, _ := http.NewRequest("POST", "/coupons/generate?count=3", bytes.NewReader(data))
+	response := executeRequest(req)
+	if !checkResponseCode(t, http.StatusCreated, response) {
+		t.FailNow()
+	}
+	var generated []stor.Coupon
+	json.Unmarshal(response.Body.Bytes(), &generated)
+	if len(generated) != 3 || !regexp.MustCompile(`^[A-Z2-9]{4}(-[A-Z2-9]{4}){3}$`).MatchString(generated[0].Code) {
+		t.Fatalf("Unexpected generated coupons %+v", generated)
+	}
+	req, _ = http.NewRequest("POST", "/coupons/generate?count=0", bytes.NewReader(data))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+
+	// a promo code chosen by the client, not case sensitive
+	promo := &stor.Coupon{Code: "promo-" + uuid.New().String()[:8], PublicationID: inPub.UUID, MaxUses: 2}
+	data, _ = json.Marshal(promo)
+	req, _ = http.NewRequest("POST", "/coupons/", bytes.NewReader(data))
+	checkResponseCode(t, http.StatusCreated, executeRequest(req))
+	req, _ = http.NewRequest("POST", "/coupons/", bytes.NewReader(data))
+	checkResponseCode(t, http.StatusConflict, executeRequest(req))
+	data, _ = json.Marshal(&stor.Coupon{Code: "unknown-publication", PublicationID: uuid.New().String(), MaxUses: 1})
+	req, _ = http.NewRequest("POST", "/coupons/", bytes.NewReader(data))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+
+	redeem := func(code string) *lic.License {
+		payload := &RedeemRequest{Code: code, LicenseRequest: *newLicenseRequest("")}
+		data, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", "/redeem", bytes.NewReader(data))
+		response := executeRequest(req)
+		if response.Code != http.StatusOK {
+			return nil
+		}
+		var license lic.License
+		json.Unmarshal(response.Body.Bytes(), &license)
+		return &license
+	}
+
+	// a redemption issues a license with the rights of the coupon
+	license := redeem(generated[0].Code)
+	if license == nil {
+		t.Fatal("Failed to redeem a generated code")
+	}
+	defer deleteLicense(t, license.UUID)
+	if license.Rights.Copy == nil || *license.Rights.Copy != copy || license.Rights.End == nil ||
+		license.Rights.End.Sub(*license.Rights.Start).Hours() != 7*24 {
+		t.Errorf("Unexpected license rights %+v", license.Rights)
+	}
+	if redeem(generated[0].Code) != nil {
+		t.Error("Expected a used code to be rejected")
+	}
+
+	// a promo code is redeemed up to its limit
+	for i := 0; i < 2; i++ {
+		license := redeem(promo.Code)
+		if license == nil {
+			t.Fatalf("Failed to redeem a promo code")
+		}
+		defer deleteLicense(t, license.UUID)
+	}
+	payload := &RedeemRequest{Code: promo.Code, LicenseRequest: *newLicenseRequest("")}
+	data, _ = json.Marshal(payload)
+	req, _ = http.NewRequest("POST", "/redeem", bytes.NewReader(data))
+	checkResponseCode(t, http.StatusConflict, executeRequest(req))
+	payload.Code = "no-such-code"
+	data, _ = json.Marshal(payload)
+	req, _ = http.NewRequest("POST", "/redeem", bytes.NewReader(data))
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+
+	req, _ = http.NewRequest("GET", "/coupons/"+promo.Code+"/redemptions", nil)
+	response = executeRequest(req)
+	var redemptions []stor.Redemption
+	json.Unmarshal(response.Body.Bytes(), &redemptions)
+	if len(redemptions) != 2 || redemptions[0].UserID == "" {
+		t.Errorf("Expected 2 redemptions, got %+v", redemptions)
+	}
+
+	// more uses can be granted
+	req, _ = http.NewRequest("GET", "/coupons/"+promo.Code, nil)
+	response = executeRequest(req)
+	var coupon stor.Coupon
+	json.Unmarshal(response.Body.Bytes(), &coupon)
+	if coupon.Uses != 2 {
+		t.Errorf("Expected 2 uses, got %d", coupon.Uses)
+	}
+	coupon.MaxUses, coupon.Uses = 3, 0
+	data, _ = json.Marshal(&coupon)
+	req, _ = http.NewRequest("PUT", "/coupons/"+promo.Code, bytes.NewReader(data))
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	license = redeem(promo.Code)
+	if license == nil {
+		t.Fatal("Failed to redeem a promo code with more uses")
+	}
+	defer deleteLicense(t, license.UUID)
+	if redeem(promo.Code) != nil {
+		t.Error("Expected the uses to be kept by an update")
+	}
+
+	for _, code := range []string{promo.Code, generated[0].Code, generated[1].Code, generated[2].Code} {
+		req, _ = http.NewRequest("DELETE", "/coupons/"+code, nil)
+		checkResponseCode(t, http.StatusOK, executeRequest(req))
+	}
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// newCleartextEPUB builds a minimal EPUB file in memory
+func newCleartextEPUB(t *testing.T) []byte {
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range []struct{ name, content string }{
+		{"mimetype", "application/epub+zip"},
+		{"META-INF/container.xml", `<?xml version="1.0"?><container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container"><rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`},
+		{"OEBPS/content.opf", `<?xml version="1.0"?><package xmlns="http://www.idpf.org/2007/opf" version="3.0"></package>`},
+		{"OEBPS/chapter1.xhtml", "<html><body><p>Chapter 1</p></body></html>"},
+	} {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(f.content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncryptPublication(t *testing.T) {
+
+	publicationID := uuid.New().String()
+	path := "/publications/" + publicationID + "/file"
+	epub := newCleartextEPUB(t)
+
+	// an unknown publication is created
+	req, _ := http.NewRequest("PUT", path+"?title=Nineteen+Eighty-Four", bytes.NewReader(epub))
+	req.Header.Set("Content-Type", "application/epub+zip")
+	response := executeRequest(req)
+	var created PublicationTest
+	if !checkResponseCode(t, http.StatusCreated, response) {
+		t.Fatalf("Failed to encrypt a publication: %s", response.Body)
+	}
+	defer deletePublication(t, publicationID)
+	if err := json.Unmarshal(response.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if len(created.EncryptionKey) != 32 || created.Title != "Nineteen Eighty-Four" || created.ContentType != "application/epub+zip" ||
+		created.Location != "https://cdn.example.com/publications/"+publicationID+".epub" || created.Checksum == "" || created.Size == 0 {
+		t.Errorf("Unexpected publication %s", response.Body)
+	}
+
+	// a known publication keeps its content key
+	req, _ = http.NewRequest("PUT", path, bytes.NewReader(epub))
+	req.Header.Set("Content-Type", "application/epub+zip")
+	response = executeRequest(req)
+	var updated PublicationTest
+	if checkResponseCode(t, http.StatusOK, response) {
+		if err := json.Unmarshal(response.Body.Bytes(), &updated); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(updated.EncryptionKey, created.EncryptionKey) || updated.Title != created.Title {
+			t.Errorf("Unexpected publication %s", response.Body)
+		}
+	}
+
+	// invalid requests
+	req, _ = http.NewRequest("PUT", path, bytes.NewReader(epub))
+	req.Header.Set("Content-Type", "text/plain")
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+	req, _ = http.NewRequest("PUT", "/publications/123/file", bytes.NewReader(epub))
+	req.Header.Set("Content-Type", "application/epub+zip")
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+	req, _ = http.NewRequest("PUT", "/publications/"+uuid.New().String()+"/file", bytes.NewReader([]byte("not a zip")))
+	req.Header.Set("Content-Type", "application/epub+zip")
+	checkResponseCode(t, http.StatusUnprocessableEntity, executeRequest(req))
+}
+
+// newUploadRequest builds a multipart/form-data request uploading a file
+func newUploadRequest(t *testing.T, path, filename string, data []byte, fields map[string]string) *http.Request {
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for name, value := range fields {
+		mw.WriteField(name, value)
+	}
+	if filename != "" {
+		fw, err := mw.CreateFormFile("file", filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(fw, bytes.NewReader(data))
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("POST", path, &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestUploadPublication(t *testing.T) {
+
+	publicationID := uuid.New().String()
+	path := "/publications/" + publicationID + "/file"
+	epub := newCleartextEPUB(t)
+
+	// the media type is found from the file name
+	response := executeRequest(newUploadRequest(t, path, "1984.epub", epub, map[string]string{"title": "Nineteen Eighty-Four", "author": "George Orwell"}))
+	if !checkResponseCode(t, http.StatusCreated, response) {
+		t.Fatalf("Failed to upload a publication: %s", response.Body)
+	}
+	defer deletePublication(t, publicationID)
+	var created PublicationTest
+	if err := json.Unmarshal(response.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if len(created.EncryptionKey) != 32 || created.Title != "Nineteen Eighty-Four" || created.ContentType != "application/epub+zip" ||
+		created.Location != "https://cdn.example.com/publications/"+publicationID+".epub" || created.Size == 0 {
+		t.Errorf("Unexpected publication %s", response.Body)
+	}
+
+	// the content_type field overrides the file name
+	response = executeRequest(newUploadRequest(t, path, "source.bin", epub, map[string]string{"content_type": "application/epub+zip"}))
+	checkResponseCode(t, http.StatusOK, response)
+
+	// invalid requests
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(newUploadRequest(t, path, "", nil, map[string]string{"title": "No file"})))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(newUploadRequest(t, path, "notes.txt", epub, nil)))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(newUploadRequest(t, path, "large.epub", make([]byte, 1<<20+1), nil)))
+	req, _ := http.NewRequest("POST", path, bytes.NewReader(epub))
+	req.Header.Set("Content-Type", "application/epub+zip")
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+func TestVerifyEvents(t *testing.T) {
+
+	// revoke a license, which records an event
+	license, response := createLicense(t)
+	checkResponseCode(t, http.StatusCreated, response)
+	defer deleteLicense(t, license.UUID)
+	req, _ := http.NewRequest("PUT", "/licenses/"+license.UUID+"/revoke", strings.NewReader(`{"reason": "refunded", "actor": "support"}`))
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+
+	req, _ = http.NewRequest("GET", "/events/verify", nil)
+	response = executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		var report stor.ChainReport
+		if err := json.Unmarshal(response.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		if report.Checked == 0 || report.Head == "" {
+			t.Errorf("Expected chained events, got %+v", report)
+		}
+	}
+}
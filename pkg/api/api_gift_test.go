@@ -0,0 +1,107 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/google/uuid"
+)
+
+func TestGifts(t *testing.T) {
+
+	inPub, _ := createPublication(t)
+	defer deletePublication(t, inPub.UUID)
+
+	createGift := func(gift *stor.Gift) *GiftResponse {
+		data, _ := json.Marshal(gift)
+		req, _ := http.NewRequest("POST", "/gifts/", bytes.NewReader(data))
+		response := executeRequest(req)
+		if response.Code != http.StatusCreated {
+			return nil
+		}
+		created := &GiftResponse{}
+		json.Unmarshal(response.Body.Bytes(), created)
+		return created
+	}
+	claim := func(token string) *http.Response {
+		payload := &ClaimRequest{Token: token, LicenseRequest: *newLicenseRequest("")}
+		data, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", "/claim", bytes.NewReader(data))
+		return executeRequest(req).Result()
+	}
+
+	// a pending gift, with its claim token
+	purchaser := uuid.New().String()
+	copy := int32(5)
+	gift := createGift(&stor.Gift{PublicationID: inPub.UUID, Purchaser: purchaser, Days: 14, Copy: &copy})
+	if gift == nil {
+		t.Fatal("Failed to create a gift")
+	}
+	if gift.Token == "" || gift.Status != stor.GIFT_PENDING || gift.Expires == nil || gift.Expires.Before(time.Now().AddDate(0, 0, 29)) {
+		t.Errorf("Unexpected gift %+v", gift)
+	}
+	past := time.Now().Add(-time.Hour)
+	if createGift(&stor.Gift{PublicationID: inPub.UUID, Purchaser: purchaser, Expires: &past}) != nil {
+		t.Error("Expected a gift expired at creation to be rejected")
+	}
+	if createGift(&stor.Gift{PublicationID: uuid.New().String(), Purchaser: purchaser}) != nil {
+		t.Error("Expected a gift of an unknown publication to be rejected")
+	}
+
+	// the token is only returned on creation
+	req, _ := http.NewRequest("GET", "/gifts/"+gift.UUID, nil)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response)
+	if bytes.Contains(response.Body.Bytes(), []byte(gift.Token)) {
+		t.Error("Expected the claim token not to be returned")
+	}
+
+	// the recipient claims the gift once, with the rights of the gift
+	if code := claim("unknown").StatusCode; code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown token, got %d", code)
+	}
+	resp := claim(gift.Token)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 claiming a gift, got %d", resp.StatusCode)
+	}
+	var license lic.License
+	json.NewDecoder(resp.Body).Decode(&license)
+	defer deleteLicense(t, license.UUID)
+	if license.Rights.Copy == nil || *license.Rights.Copy != copy || license.Rights.End == nil ||
+		license.Rights.End.Sub(*license.Rights.Start).Hours() != 14*24 {
+		t.Errorf("Unexpected license rights %+v", license.Rights)
+	}
+	if code := claim(gift.Token).StatusCode; code != http.StatusConflict {
+		t.Errorf("Expected 409 for a claimed gift, got %d", code)
+	}
+	req, _ = http.NewRequest("GET", "/gifts/"+gift.UUID, nil)
+	response = executeRequest(req)
+	var claimed stor.Gift
+	json.Unmarshal(response.Body.Bytes(), &claimed)
+	if claimed.Status != stor.GIFT_CLAIMED || claimed.LicenseID != license.UUID {
+		t.Errorf("Unexpected claimed gift %+v", claimed)
+	}
+
+	// a cancelled gift cannot be claimed
+	cancelled := createGift(&stor.Gift{PublicationID: inPub.UUID, Purchaser: purchaser})
+	req, _ = http.NewRequest("DELETE", "/gifts/"+cancelled.UUID, nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	req, _ = http.NewRequest("DELETE", "/gifts/"+gift.UUID, nil)
+	checkResponseCode(t, http.StatusConflict, executeRequest(req))
+	if code := claim(cancelled.Token).StatusCode; code != http.StatusConflict {
+		t.Errorf("Expected 409 for a cancelled gift, got %d", code)
+	}
+
+	req, _ = http.NewRequest("GET", "/gifts/?purchaser="+purchaser, nil)
+	response = executeRequest(req)
+	var gifts []stor.Gift
+	json.Unmarshal(response.Body.Bytes(), &gifts)
+	if len(gifts) != 2 || gifts[1].Status != stor.GIFT_CANCELLED {
+		t.Errorf("Unexpected gifts of the purchaser %+v", gifts)
+	}
+}
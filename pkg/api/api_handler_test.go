@@ -0,0 +1,64 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/sign"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// stubSigner returns a constant signature
+type stubSigner struct{}
+
+func (stubSigner) Sign(interface{}) (sign.Signature, error) {
+	return sign.Signature{Algorithm: "stub", Value: []byte("signed")}, nil
+}
+
+func TestHandlerDependencies(t *testing.T) {
+
+	// a handler with its own signer and clock
+	h := NewAPIHandler(s.Config, s.Store, nil)
+	h.Signer = stubSigner{}
+	fixed := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	h.Clock = func() time.Time { return fixed }
+
+	r := chi.NewRouter()
+	r.Use(render.SetContentType(render.ContentTypeJSON))
+	r.Post("/licenses/", h.GenerateLicense)
+	r.Post("/register/{licenseID}", h.Register)
+
+	pub, _ := createPublication(t)
+	data, _ := json.Marshal(newLicenseRequest(pub.UUID))
+	req, _ := http.NewRequest("POST", "/licenses/", bytes.NewReader(data))
+	response := httptest.NewRecorder()
+	r.ServeHTTP(response, req)
+	if !checkResponseCode(t, http.StatusOK, response) {
+		deletePublication(t, pub.UUID)
+		t.FailNow()
+	}
+	var license lic.License
+	json.Unmarshal(response.Body.Bytes(), &license)
+	defer deleteLicense(t, license.UUID)
+	if license.Signature == nil || license.Signature.Algorithm != "stub" {
+		t.Error("The license must be signed by the injected signer")
+	}
+
+	// events are timestamped by the injected clock
+	req, _ = http.NewRequest("POST", "/register/"+license.UUID+"?id=d1&name=device1", nil)
+	response = httptest.NewRecorder()
+	r.ServeHTTP(response, req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		var statusDoc lic.StatusDoc
+		json.Unmarshal(response.Body.Bytes(), &statusDoc)
+		if !statusDoc.Updated.Status.Equal(fixed) {
+			t.Errorf("Expected the status update time %v, got %v", fixed, statusDoc.Updated.Status)
+		}
+	}
+}
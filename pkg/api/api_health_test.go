@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/storage"
+)
+
+func TestHealthz(t *testing.T) {
+
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	response := executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		var health HealthResponse
+		if err := json.Unmarshal(response.Body.Bytes(), &health); err != nil {
+			t.Fatal(err)
+		}
+		if health.Status != HEALTH_OK || health.Checks["database"] == nil || health.Checks["storage"] == nil ||
+			health.Checks["storage"].Status != HEALTH_OK {
+			t.Errorf("Expected healthy dependencies, got %+v", health)
+		}
+	}
+
+	// an unavailable storage makes the server unhealthy
+	dir := t.TempDir()
+	cold, err := storage.NewFileStorage(filepath.Join(dir, "cold"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewAPIHandler(setConfig(), s.Store, nil)
+	h.Tiering = &storage.Tiering{Hot: s.Tiering.Hot, Cold: cold, Store: s.Store}
+	if err = os.RemoveAll(filepath.Join(dir, "cold")); err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	h.Healthz(rr, httptest.NewRequest("GET", "/healthz", nil))
+	var health HealthResponse
+	if err = json.Unmarshal(rr.Body.Bytes(), &health); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusServiceUnavailable || health.Status != HEALTH_FAIL || health.Checks["cold_storage"] == nil ||
+		health.Checks["cold_storage"].Status != HEALTH_FAIL || health.Checks["database"].Status != HEALTH_OK {
+		t.Errorf("Expected an unavailable cold storage, got %d %+v", rr.Code, health)
+	}
+}
+
+func TestProbes(t *testing.T) {
+
+	req, _ := http.NewRequest("GET", "/livez", nil)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response)
+
+	req, _ = http.NewRequest("GET", "/readyz", nil)
+	response = executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		var ready HealthResponse
+		if err := json.Unmarshal(response.Body.Bytes(), &ready); err != nil {
+			t.Fatal(err)
+		}
+		if ready.Status != HEALTH_OK || len(ready.Checks) != 3 || ready.Checks["migrations"] == nil {
+			t.Errorf("Expected a ready server, got %+v", ready)
+		}
+	}
+
+	// a server without certificate is alive, but not ready
+	h := NewAPIHandler(setConfig(), s.Store, nil)
+	rr := httptest.NewRecorder()
+	h.Livez(rr, httptest.NewRequest("GET", "/livez", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected a live server, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.Readyz(rr, httptest.NewRequest("GET", "/readyz", nil))
+	var ready HealthResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &ready); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusServiceUnavailable || ready.Checks["certificate"] == nil || ready.Checks["certificate"].Status != HEALTH_FAIL ||
+		ready.Checks["database"].Status != HEALTH_OK {
+		t.Errorf("Expected a missing certificate, got %d %+v", rr.Code, ready)
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHintPage(t *testing.T) {
+
+	inLic, _ := createLicense(t)
+	defer deleteLicense(t, inLic.UUID)
+
+	req, _ := http.NewRequest("GET", "/hint/"+inLic.UUID, nil)
+	req.Header.Set("Accept-Language", "fr-CA, en;q=0.8")
+	response := executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		if !strings.HasPrefix(response.Header().Get("Content-Type"), "text/html") || response.Header().Get("Content-Language") != "fr" ||
+			!strings.Contains(response.Body.String(), "Votre phrase de passe") {
+			t.Errorf("Unexpected hint page %s", response.Body)
+		}
+	}
+
+	// the lang parameter overrides the header, unknown languages fall back to english
+	req, _ = http.NewRequest("GET", "/hint/"+inLic.UUID+"?lang=ja", nil)
+	req.Header.Set("Accept-Language", "fr")
+	response = executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) && !strings.Contains(response.Body.String(), "Your passphrase") {
+		t.Errorf("Expected an english page, got %s", response.Body)
+	}
+
+	req, _ = http.NewRequest("GET", "/hint/unknown", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+}
+
+func TestRateLimiter(t *testing.T) {
+
+	now := time.Date(2023, 5, 1, 10, 0, 30, 0, time.UTC)
+	l := NewRateLimiter(2)
+	l.clock = func() time.Time { return now }
+
+	if l.allow("192.0.2.1") != 0 || l.allow("192.0.2.1") != 0 {
+		t.Fatal("Expected the first requests to be allowed")
+	}
+	if wait := l.allow("192.0.2.1"); wait != 30*time.Second {
+		t.Errorf("Expected a 30s wait, got %v", wait)
+	}
+	if l.allow("192.0.2.2") != 0 {
+		t.Error("Expected another client to be allowed")
+	}
+	now = now.Add(30 * time.Second)
+	if l.allow("192.0.2.1") != 0 {
+		t.Error("Expected the count to be reset after a minute")
+	}
+}
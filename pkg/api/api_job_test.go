@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+// waitJob polls a job until it is finished
+func waitJob(t *testing.T, jobID string) *JobResponse {
+
+	for i := 0; i < 100; i++ {
+		req, _ := http.NewRequest("GET", "/jobs/"+jobID, nil)
+		response := executeRequest(req)
+		if !checkResponseCode(t, http.StatusOK, response) {
+			return nil
+		}
+		job := &JobResponse{}
+		if err := json.Unmarshal(response.Body.Bytes(), job); err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != stor.JOB_RUNNING {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("The job %s is still running", jobID)
+	return nil
+}
+
+func TestJobs(t *testing.T) {
+
+	inLic, _ := createLicense(t)
+	defer deleteLicense(t, inLic.UUID)
+	newLic := newLicense(inLic.PublicationID)
+	defer deleteLicense(t, newLic.UUID)
+
+	// a batch of licenses, one of them invalid
+	payload, _ := json.Marshal(map[string]interface{}{"type": "licenses", "items": []interface{}{newLic, map[string]string{"uuid": "invalid"}}})
+	req, _ := http.NewRequest("POST", "/jobs", bytes.NewReader(payload))
+	response := executeRequest(req)
+	var started JobResponse
+	if checkResponseCode(t, http.StatusAccepted, response) {
+		if err := json.Unmarshal(response.Body.Bytes(), &started); err != nil {
+			t.Fatal(err)
+		}
+		job := waitJob(t, started.UUID)
+		if job.Status != stor.JOB_COMPLETED || job.Progress != 100 || job.Failed != 1 || len(job.Results) != 2 ||
+			job.Results[0].Status != http.StatusCreated || job.Results[1].Status != http.StatusBadRequest {
+			t.Errorf("Unexpected job %+v", job)
+		}
+	}
+
+	// a bulk revocation
+	payload, _ = json.Marshal(map[string]interface{}{"type": "revoke", "reason": "Refund", "items": []string{inLic.UUID, newLic.UUID, "unknown"}})
+	req, _ = http.NewRequest("POST", "/jobs", bytes.NewReader(payload))
+	response = executeRequest(req)
+	if checkResponseCode(t, http.StatusAccepted, response) {
+		if err := json.Unmarshal(response.Body.Bytes(), &started); err != nil {
+			t.Fatal(err)
+		}
+		job := waitJob(t, started.UUID)
+		if job.Status != stor.JOB_COMPLETED || job.Processed != 3 || job.Failed != 1 || job.Results[2].Status != http.StatusNotFound {
+			t.Errorf("Unexpected job %+v", job)
+		}
+	}
+	req, _ = http.NewRequest("GET", "/status/"+newLic.UUID, nil)
+	if response = executeRequest(req); !bytes.Contains(response.Body.Bytes(), []byte(stor.STATUS_CANCELLED)) {
+		t.Errorf("Expected a cancelled license, got %s", response.Body)
+	}
+
+	// a finished job cannot be cancelled
+	req, _ = http.NewRequest("DELETE", "/jobs/"+started.UUID, nil)
+	checkResponseCode(t, http.StatusConflict, executeRequest(req))
+	req, _ = http.NewRequest("DELETE", "/jobs/unknown", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+
+	for _, payload := range []string{`{"type": "export", "items": ["1"]}`, `{"type": "revoke", "items": []}`} {
+		req, _ = http.NewRequest("POST", "/jobs", bytes.NewBufferString(payload))
+		checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+	}
+}
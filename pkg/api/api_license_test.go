@@ -2,12 +2,16 @@ package api
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
 	"github.com/google/uuid"
 	"syreclabs.com/go/faker"
 )
@@ -117,11 +121,173 @@ func TestGetFreshLicense(t *testing.T) {
 		if outLic.UUID != inLic.UUID {
 			t.Fatal("Failed to get the same uuid.")
 		}
+		if ct := response.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("Expected a JSON license, got %s", ct)
+		}
 
 		// visual clue
 		//log.Printf("%s \n", response.Body.String())
 	}
 
+	// the license document requires the user key, unknown to the server
+	req, _ = http.NewRequest("GET", "/licenses/"+inLic.UUID+"/document", nil)
+	checkResponseCode(t, http.StatusConflict, executeRequest(req))
+
 	// delete the license
 	deleteLicense(t, inLic.UUID)
 }
+
+func TestGenerateLicenseWithPassphrase(t *testing.T) {
+
+	inPub, _ := createPublication(t)
+
+	payload := newLicenseRequest(inPub.UUID)
+	payload.PassHash = ""
+	payload.GeneratePassphrase = true
+	data, _ := json.Marshal(payload)
+
+	req, _ := http.NewRequest("POST", "/licenses/", bytes.NewReader(data))
+	response := executeRequest(req)
+	if !checkResponseCode(t, http.StatusOK, response) {
+		t.FailNow()
+	}
+	if response.Header().Get("Cache-Control") != "no-store" {
+		t.Error("A generated passphrase must not be cached")
+	}
+	var out GeneratedLicenseResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Passphrase == "" || out.License == nil {
+		t.Fatal("Failed to get the license and its passphrase")
+	}
+	if strings.Contains(response.Body.String(), lic.HashPassphrase(out.Passphrase)) {
+		t.Error("The passphrase hash must not be returned")
+	}
+	defer deleteLicense(t, out.License.UUID)
+
+	// the passphrase is never returned again
+	req, _ = http.NewRequest("GET", "/licenseinfo/"+out.License.UUID, nil)
+	response = executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) && strings.Contains(response.Body.String(), lic.HashPassphrase(out.Passphrase)) {
+		t.Error("The passphrase hash must not be exposed")
+	}
+
+	// a fresh license reuses the generated passphrase
+	payload.GeneratePassphrase = false
+	data, _ = json.Marshal(payload)
+	req, _ = http.NewRequest("POST", "/licenses/"+out.License.UUID, bytes.NewReader(data))
+	response = executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		var fresh lic.License
+		if err := json.Unmarshal(response.Body.Bytes(), &fresh); err != nil {
+			t.Fatal(err)
+		}
+		if fresh.Encryption.UserKey.Keycheck == nil {
+			t.Error("Missing key check in the fresh license")
+		}
+	}
+
+	// the license document is served without the user key, known by the server
+	req, _ = http.NewRequest("GET", "/licenses/"+out.License.UUID+"/document", nil)
+	response = executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		if ct := response.Header().Get("Content-Type"); ct != lic.ContentType_LCP_JSON {
+			t.Errorf("Expected the media type of licenses, got %s", ct)
+		}
+		var doc lic.License
+		if err := json.Unmarshal(response.Body.Bytes(), &doc); err != nil {
+			t.Fatal(err)
+		}
+		if doc.UUID != out.License.UUID || doc.Encryption.Profile != s.Config.License.Profile ||
+			doc.Encryption.UserKey.TextHint != payload.TextHint || doc.User.ID != payload.UserID {
+			t.Errorf("Unexpected license document %s", response.Body)
+		}
+		if err := doc.CheckSignature(); err != nil {
+			t.Errorf("Invalid signature: %v", err)
+		}
+	}
+
+	// but a new passphrase cannot be generated for an existing license
+	payload.GeneratePassphrase = true
+	data, _ = json.Marshal(payload)
+	req, _ = http.NewRequest("POST", "/licenses/"+out.License.UUID, bytes.NewReader(data))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response)
+}
+
+func TestFreshLicenseCache(t *testing.T) {
+
+	s.Config.License.CacheTTL = 10
+	defer func() { s.Config.License.CacheTTL = 0 }()
+
+	inLic, _ := createLicense(t)
+	defer deleteLicense(t, inLic.UUID)
+
+	payload := newLicenseRequest(inLic.PublicationID)
+	data, _ := json.Marshal(payload)
+	fetch := func() string {
+		req, _ := http.NewRequest("POST", "/licenses/"+inLic.UUID, bytes.NewReader(data))
+		response := executeRequest(req)
+		if !checkResponseCode(t, http.StatusOK, response) {
+			t.FailNow()
+		}
+		return strings.TrimSpace(response.Body.String())
+	}
+
+	// encryption is randomized, identical documents come from the cache
+	first := fetch()
+	if fetch() != first {
+		t.Error("The second fetch should hit the cache")
+	}
+
+	// a change of the user data misses the cache
+	payload.UserName = "Somebody Else"
+	data, _ = json.Marshal(payload)
+	if fetch() == first {
+		t.Error("A change of user data should miss the cache")
+	}
+	fetch()
+
+	// a change of rights invalidates the cache
+	inLic.Print = 42
+	body, _ := json.Marshal(inLic)
+	req, _ := http.NewRequest("PUT", "/licenseinfo/"+inLic.UUID, bytes.NewReader(body))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response)
+
+	var outLic lic.License
+	json.Unmarshal([]byte(fetch()), &outLic)
+	if outLic.Rights.Print == nil || *outLic.Rights.Print != 42 {
+		t.Error("The fresh license should reflect the new rights")
+	}
+
+	// the encryption of personal data disables the cache
+	s.Config.PII.MasterKey = "ZmFrZS1rZXktZm9yLWRvY3VtZW50YXRpb24tb25seSE="
+	defer func() { s.Config.PII.MasterKey = "" }()
+	if first = fetch(); fetch() == first {
+		t.Error("The cache should be disabled with the encryption of personal data")
+	}
+}
+
+func TestFreshLicenseHashCertificate(t *testing.T) {
+
+	r := httptest.NewRequest("POST", "/licenses/1", nil)
+	pubInfo := &stor.Publication{}
+	licInfo := &stor.LicenseInfo{UUID: "1"}
+	userInfo := &lic.UserInfo{ID: "user"}
+	encryption := &lic.Encryption{}
+
+	h := &APIHandler{Config: s.Config, Cert: s.Cert}
+	hash := h.freshLicenseHash(r, pubInfo, licInfo, nil, userInfo, encryption, "")
+	if h.freshLicenseHash(r, pubInfo, licInfo, nil, userInfo, encryption, "") != hash {
+		t.Error("The hash of identical parameters should be stable")
+	}
+
+	// a renewed certificate misses the cache
+	renewed := &tls.Certificate{Certificate: [][]byte{[]byte("renewed")}, PrivateKey: s.Cert.PrivateKey}
+	h = &APIHandler{Config: s.Config, Cert: renewed}
+	if h.freshLicenseHash(r, pubInfo, licInfo, nil, userInfo, encryption, "") == hash {
+		t.Error("A change of certificate should change the hash")
+	}
+}
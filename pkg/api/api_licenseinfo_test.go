@@ -8,6 +8,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
 	"github.com/google/uuid"
 )
 
@@ -48,6 +50,85 @@ func TestCreateLicenseInfo(t *testing.T) {
 	deleteLicense(t, inLic.UUID)
 }
 
+func TestCreateDuplicateLicenseInfo(t *testing.T) {
+
+	inLic, response := createLicense(t)
+	checkResponseCode(t, http.StatusCreated, response)
+	defer deleteLicense(t, inLic.UUID)
+
+	// a duplicate uuid is a conflict, located by the existing license
+	inLic.Copy = 42
+	data, _ := json.Marshal(inLic)
+	req, _ := http.NewRequest("POST", "/licenseinfo/", bytes.NewReader(data))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusConflict, response)
+	if location := response.Header().Get("Location"); location != "/licenseinfo/"+inLic.UUID {
+		t.Errorf("Expected the location of the existing license, got %q", location)
+	}
+
+	// unless the creation is an upsert, which keeps the status of the license
+	revoked := *inLic
+	revoked.Status = "revoked"
+	data, _ = json.Marshal(revoked)
+	req, _ = http.NewRequest("PUT", "/licenseinfo/"+inLic.UUID, bytes.NewReader(data))
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	data, _ = json.Marshal(inLic)
+	req, _ = http.NewRequest("POST", "/licenseinfo/?upsert=true", bytes.NewReader(data))
+	response = executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		var outLic LicenseTest
+		if err := json.Unmarshal(response.Body.Bytes(), &outLic); err != nil {
+			t.Fatal(err)
+		}
+		if outLic.Copy != 42 || outLic.Status != "revoked" {
+			t.Errorf("Expected the rights to be updated and the status kept, got %+v", outLic)
+		}
+	}
+}
+
+func TestCreateLicenses(t *testing.T) {
+
+	inPub, _ := createPublication(t)
+	defer deletePublication(t, inPub.UUID)
+
+	valid := newLicense(inPub.UUID)
+	invalid := newLicense(inPub.UUID)
+	invalid.UserID = ""
+	orphan := newLicense(uuid.New().String())
+	batch := []interface{}{valid, newLicense(inPub.UUID), invalid, valid, orphan, map[string]interface{}{"copy": "many"}}
+	data, _ := json.Marshal(batch)
+	req, _ := http.NewRequest("POST", "/licenses/batch", bytes.NewReader(data))
+	response := executeRequest(req)
+	if !checkResponseCode(t, http.StatusOK, response) {
+		return
+	}
+	var out BatchResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Created != 2 || out.Failed != 4 || len(out.Results) != len(batch) {
+		t.Fatalf("Expected 2 licenses created and 4 rejected, got %+v", out)
+	}
+	for i, status := range []int{http.StatusCreated, http.StatusCreated, http.StatusBadRequest,
+		http.StatusConflict, http.StatusUnprocessableEntity, http.StatusBadRequest} {
+		if out.Results[i].Index != i || out.Results[i].Status != status {
+			t.Errorf("Expected the status %d for the item %d, got %+v", status, i, out.Results[i])
+		}
+	}
+	for _, result := range out.Results[:2] {
+		req, _ = http.NewRequest("GET", "/licenseinfo/"+result.UUID, nil)
+		checkResponseCode(t, http.StatusOK, executeRequest(req))
+		req, _ = http.NewRequest("DELETE", "/licenseinfo/"+result.UUID, nil)
+		executeRequest(req)
+	}
+
+	// a batch is a non-empty array
+	for _, body := range []string{"[]", "{}"} {
+		req, _ = http.NewRequest("POST", "/licenses/batch", strings.NewReader(body))
+		checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+	}
+}
+
 func TestGetLicenseInfo(t *testing.T) {
 
 	// create a license
@@ -107,6 +188,65 @@ func TestUpdateLicenseInfo(t *testing.T) {
 
 }
 
+func TestUpdateLicenseTimes(t *testing.T) {
+
+	inLic, _ := createLicense(t)
+	defer deleteLicense(t, inLic.UUID)
+
+	update := func(l *LicenseTest) *LicenseTest {
+		data, _ := json.Marshal(l)
+		req, _ := http.NewRequest("PUT", "/licenseinfo/"+l.UUID, bytes.NewReader(data))
+		response := executeRequest(req)
+		var outLic LicenseTest
+		if !checkResponseCode(t, http.StatusOK, response) || json.Unmarshal(response.Body.Bytes(), &outLic) != nil {
+			t.FailNow()
+		}
+		return &outLic
+	}
+
+	// a status change only updates the status, and the times of the payload are ignored
+	past := time.Now().AddDate(-1, 0, 0)
+	inLic.Status = stor.STATUS_ACTIVE
+	inLic.Updated = &past
+	outLic := update(inLic)
+	if outLic.Updated != nil || outLic.StatusUpdated == nil || outLic.StatusUpdated.Before(past.AddDate(1, 0, -1)) {
+		t.Errorf("Expected only the status to be updated, got %v, %v", outLic.Updated, outLic.StatusUpdated)
+	}
+
+	// an unchanged license keeps its times
+	statusUpdated := outLic.StatusUpdated
+	outLic = update(inLic)
+	if outLic.Updated != nil || !outLic.StatusUpdated.Equal(*statusUpdated) {
+		t.Errorf("Expected unchanged times, got %v, %v", outLic.Updated, outLic.StatusUpdated)
+	}
+
+	// a change of rights updates the license and its status, as reflected by fresh licenses and status documents
+	inLic.Copy++
+	outLic = update(inLic)
+	if outLic.Updated == nil || outLic.StatusUpdated == nil || !outLic.Updated.Equal(*outLic.StatusUpdated) {
+		t.Fatalf("Expected the rights to be updated, got %v, %v", outLic.Updated, outLic.StatusUpdated)
+	}
+	data, _ := json.Marshal(newLicenseRequest(inLic.PublicationID))
+	req, _ := http.NewRequest("POST", "/licenses/"+inLic.UUID, bytes.NewReader(data))
+	response := executeRequest(req)
+	var license lic.License
+	if checkResponseCode(t, http.StatusOK, response) {
+		json.Unmarshal(response.Body.Bytes(), &license)
+		if license.Updated == nil || !license.Updated.Equal(*outLic.Updated) {
+			t.Errorf("Expected the fresh license to be updated on %v, got %v", outLic.Updated, license.Updated)
+		}
+	}
+	req, _ = http.NewRequest("GET", "/status/"+inLic.UUID, nil)
+	response = executeRequest(req)
+	var statusDoc lic.StatusDoc
+	if checkResponseCode(t, http.StatusOK, response) {
+		json.Unmarshal(response.Body.Bytes(), &statusDoc)
+		if !statusDoc.Updated.License.Equal(*outLic.Updated) || !statusDoc.Updated.Status.Equal(*outLic.StatusUpdated) {
+			t.Errorf("Expected the status document to be updated on %v, got %+v", outLic.Updated, statusDoc.Updated)
+		}
+	}
+}
+
 func TestDeleteLicense(t *testing.T) {
 
 	// create a license
@@ -145,6 +285,30 @@ func TestListLicenses(t *testing.T) {
 		}
 	}
 
+	// sort the list by user, descending
+	req, _ = http.NewRequest("GET", path+"?sort=-user_id", nil)
+	response = executeRequest(req)
+
+	if checkResponseCode(t, http.StatusOK, response) {
+		var list []LicenseTest
+
+		if err := json.Unmarshal(response.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < len(list); i++ {
+			if list[i-1].UserID < list[i].UserID {
+				t.Errorf("Expected the licenses sorted by user, got %s before %s", list[i-1].UserID, list[i].UserID)
+			}
+		}
+	}
+
+	// unknown sort fields are rejected
+	for _, p := range []string{path + "?sort=pass_hash", path + "search?user=x&sort=id%3BDROP"} {
+		req, _ = http.NewRequest("GET", p, nil)
+		response = executeRequest(req)
+		checkResponseCode(t, http.StatusBadRequest, response)
+	}
+
 	// delete the licenses
 	for _, lic := range inLics {
 		deleteLicense(t, lic.UUID)
@@ -255,6 +419,46 @@ func TestSearchLicensesByStatus(t *testing.T) {
 	}
 }
 
+func TestSearchLicensesCombined(t *testing.T) {
+
+	var inLics []*LicenseTest
+	// create some licenses
+	for i := 0; i < 2; i++ {
+		lic, _ := createLicense(t)
+		inLics = append(inLics, lic)
+	}
+
+	// every criteria must be met
+	for _, c := range []struct {
+		query    string
+		expected int
+	}{
+		{"user=" + inLics[0].UserID + "&pub=" + inLics[0].PublicationID, 1},
+		{"user=" + inLics[0].UserID + "&pub=" + inLics[1].PublicationID, 0},
+		{"user=" + inLics[0].UserID + "&status=ready", 1},
+		{"pub=" + inLics[1].PublicationID + "&status=revoked", 0},
+	} {
+		req, _ := http.NewRequest("GET", "/licenseinfo/search?"+c.query, nil)
+		response := executeRequest(req)
+
+		if checkResponseCode(t, http.StatusOK, response) {
+			var list []LicenseTest
+
+			if err := json.Unmarshal(response.Body.Bytes(), &list); err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != c.expected {
+				t.Errorf("Expected %d licenses for %s, got %d", c.expected, c.query, len(list))
+			}
+		}
+	}
+
+	// delete the licenses
+	for _, lic := range inLics {
+		deleteLicense(t, lic.UUID)
+	}
+}
+
 func TestSearchLicensesByCount(t *testing.T) {
 
 	var inLics []*LicenseTest
@@ -324,3 +528,31 @@ func TestDeleteNoExistingLicense(t *testing.T) {
 
 	checkResponseCode(t, http.StatusNotFound, response)
 }
+
+func TestRestoreLicense(t *testing.T) {
+
+	inLic, _ := createLicense(t)
+	req, _ := http.NewRequest("DELETE", "/licenseinfo/"+inLic.UUID, nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+
+	// the deleted license is only listed on request
+	total := func(query string) string {
+		req, _ := http.NewRequest("GET", "/licenseinfo/?per_page=1"+query, nil)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response)
+		return response.Header().Get("X-Total-Count")
+	}
+	if total("") == total("&include_deleted=true") {
+		t.Error("Expected the deleted license to be counted with include_deleted")
+	}
+
+	// restore
+	req, _ = http.NewRequest("POST", "/licenses/"+inLic.UUID+"/restore", nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	req, _ = http.NewRequest("GET", "/licenseinfo/"+inLic.UUID, nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	deleteLicense(t, inLic.UUID)
+
+	req, _ = http.NewRequest("POST", "/licenses/"+uuid.New().String()+"/restore", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShedder(t *testing.T) {
+
+	shedder := NewShedder(1, 0, 10*time.Millisecond)
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	handler := shedder.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-unblock
+	}))
+
+	// the first request takes the only slot
+	done := make(chan int)
+	go func() {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+		done <- response.Code
+	}()
+	<-started
+
+	// the second one is shed at the queue deadline
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	if response.Code != http.StatusServiceUnavailable || response.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected a 503 status code with a Retry-After header, got %d", response.Code)
+	}
+
+	close(unblock)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("Expected a 200 status code, got %d", code)
+	}
+	stats := shedder.Stats()
+	if stats.Total != 1 || stats.Shed != 1 || stats.InFlight != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+			w.Write([]byte("too late"))
+		}
+	})
+	response := httptest.NewRecorder()
+	Timeout(10*time.Millisecond)(slow).ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	if response.Code != http.StatusServiceUnavailable || !strings.Contains(response.Body.String(), ErrTimeout.Error()) {
+		t.Errorf("Expected a 503 status code, got %d %s", response.Code, response.Body.String())
+	}
+
+	// no timeout
+	fast := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); ok {
+			w.WriteHeader(http.StatusTeapot)
+		}
+	})
+	response = httptest.NewRecorder()
+	Timeout(0)(fast).ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected no deadline, got %d", response.Code)
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestAccessLog(t *testing.T) {
+
+	logger, hook := test.NewNullLogger()
+	h := &APIHandler{Logger: logger}
+	r := chi.NewRouter()
+	r.Use(RequestID, AccessLog(logger))
+	r.Route("/licenses", func(r chi.Router) {
+		// the caller is authenticated by a subrouter
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r.WithContext(NewPrincipalContext(r.Context(), &Principal{Name: "cms"})))
+			})
+		})
+		r.Post("/{licenseID}", func(w http.ResponseWriter, r *http.Request) {
+			h.logger(r).Warn("Generation failed")
+			render.Render(w, r, ErrRender(errors.New("signature failed")))
+		})
+	})
+
+	// the identifier of the caller is kept, and logged with the request
+	req := httptest.NewRequest("POST", "/licenses/123", nil)
+	req.Header.Set(RequestIDHeader, "cms-42")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if id := rr.Header().Get(RequestIDHeader); id != "cms-42" {
+		t.Errorf("Expected the request id of the caller, got %q", id)
+	}
+	entries := hook.AllEntries()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 log entries, got %d", len(entries))
+	}
+	if entries[0].Data["request_id"] != "cms-42" || entries[0].Data["method"] != "POST" {
+		t.Errorf("Expected the fields of the request in the logs of the handler, got %v", entries[0].Data)
+	}
+	access := entries[1]
+	if access.Level != logrus.WarnLevel || access.Data["request_id"] != "cms-42" || access.Data["route"] != "/licenses/{licenseID}" ||
+		access.Data["status"] != http.StatusUnprocessableEntity || access.Data["caller"] != "cms" {
+		t.Errorf("Unexpected access log %v", access.Data)
+	}
+	if err, ok := access.Data[logrus.ErrorKey].(error); !ok || err.Error() != "signature failed" {
+		t.Errorf("Expected the rendered error in the access log, got %v", access.Data[logrus.ErrorKey])
+	}
+
+	// an invalid identifier is replaced
+	hook.Reset()
+	req = httptest.NewRequest("GET", "/unknown", nil)
+	req.Header.Set(RequestIDHeader, "bad id\n")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if _, err := uuid.Parse(rr.Header().Get(RequestIDHeader)); err != nil {
+		t.Errorf("Expected a new request id, got %q", rr.Header().Get(RequestIDHeader))
+	}
+	if entry := hook.LastEntry(); entry == nil || entry.Data["request_id"] != rr.Header().Get(RequestIDHeader) || entry.Data["status"] != http.StatusNotFound {
+		t.Errorf("Unexpected access log %v", entry)
+	}
+}
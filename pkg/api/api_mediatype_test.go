@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+func searchByFormat(format string) *http.Request {
+	req, _ := http.NewRequest("GET", "/publications/search?format="+url.QueryEscape(format), nil)
+	return req
+}
+
+func TestMediaTypeRegistry(t *testing.T) {
+
+	// default formats and formats declared in the configuration are registered
+	req, _ := http.NewRequest("GET", "/mediatypes/", nil)
+	response := executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		var list []stor.MediaType
+		json.Unmarshal(response.Body.Bytes(), &list)
+		found := make(map[string]string)
+		for _, mt := range list {
+			found[mt.Format] = mt.ContentType
+		}
+		if found["epub"] != "application/epub+zip" || found["cbz"] != "application/vnd.comicbook+zip" {
+			t.Errorf("Missing registered formats: %v", found)
+		}
+	}
+
+	// a format unknown to the registry cannot be searched
+	response = executeRequest(searchByFormat("webpub"))
+	checkResponseCode(t, http.StatusNotFound, response)
+
+	// register a new format
+	data, _ := json.Marshal(stor.MediaType{Format: "webpub", ContentType: "application/webpub+zip"})
+	req, _ = http.NewRequest("POST", "/mediatypes/", bytes.NewReader(data))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response)
+	// formats are unique
+	req, _ = http.NewRequest("POST", "/mediatypes/", bytes.NewReader(data))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusConflict, response)
+
+	// a publication of the new format can be searched
+	pub := newPublication()
+	pub.ContentType = "application/webpub+zip"
+	data, _ = json.Marshal(pub)
+	req, _ = http.NewRequest("POST", "/publications/", bytes.NewReader(data))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response)
+	defer deletePublication(t, pub.UUID)
+
+	response = executeRequest(searchByFormat("webpub"))
+	if checkResponseCode(t, http.StatusOK, response) {
+		var list []PublicationTest
+		json.Unmarshal(response.Body.Bytes(), &list)
+		if len(list) != 1 || list[0].UUID != pub.UUID {
+			t.Errorf("Expected the new publication, got %d results", len(list))
+		}
+	}
+
+	// the format name is immutable
+	data, _ = json.Marshal(stor.MediaType{Format: "rwp", ContentType: "application/webpub+zip"})
+	req, _ = http.NewRequest("PUT", "/mediatypes/webpub", bytes.NewReader(data))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response)
+
+	data, _ = json.Marshal(stor.MediaType{Format: "webpub", ContentType: "application/webpub+zip", Label: "Web Publication"})
+	req, _ = http.NewRequest("PUT", "/mediatypes/webpub", bytes.NewReader(data))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response)
+
+	// once deleted, the format can no longer be searched
+	req, _ = http.NewRequest("DELETE", "/mediatypes/webpub", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response)
+	response = executeRequest(searchByFormat("webpub"))
+	checkResponseCode(t, http.StatusNotFound, response)
+
+	// a default format cannot be deleted, as it would be registered again at startup
+	req, _ = http.NewRequest("DELETE", "/mediatypes/epub", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusConflict, response)
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/google/uuid"
+)
+
+// createOrganization creates an organization with a pool of two passphrases
+func createOrganization(t *testing.T) *stor.Organization {
+
+	org := &stor.Organization{UUID: uuid.New().String(), Name: "Springfield Elementary"}
+	data, _ := json.Marshal(org)
+	req, _ := http.NewRequest("POST", "/organizations/", bytes.NewReader(data))
+	response := executeRequest(req)
+	if !checkResponseCode(t, http.StatusCreated, response) {
+		t.FailNow()
+	}
+
+	for _, label := range []string{"teachers", "pupils"} {
+		data, _ = json.Marshal(map[string]string{
+			"label":     label,
+			"text_hint": "Passphrase of the " + label,
+			"pass_hash": "FAEB00CA518BEA7CB11A7EF31FB6183B489B1B6EADB792BEC64A03B3F6FF80A8",
+		})
+		req, _ = http.NewRequest("POST", "/organizations/"+org.UUID+"/passphrases/", bytes.NewReader(data))
+		response = executeRequest(req)
+		if !checkResponseCode(t, http.StatusCreated, response) {
+			t.FailNow()
+		}
+		if strings.Contains(response.Body.String(), "pass_hash") {
+			t.Error("The passphrase hash must not be returned")
+		}
+	}
+	return org
+}
+
+func deleteOrganization(t *testing.T, uuid string) {
+	req, _ := http.NewRequest("DELETE", "/organizations/"+uuid, nil)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response)
+}
+
+func TestPassphrasePool(t *testing.T) {
+
+	org := createOrganization(t)
+	defer deleteOrganization(t, org.UUID)
+
+	// list the pool
+	req, _ := http.NewRequest("GET", "/organizations/"+org.UUID+"/passphrases/", nil)
+	response := executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		var pool []stor.Passphrase
+		json.Unmarshal(response.Body.Bytes(), &pool)
+		if len(pool) != 2 {
+			t.Fatalf("Expected 2 passphrases, got %d", len(pool))
+		}
+	}
+
+	// an invalid passphrase hash is rejected
+	data, _ := json.Marshal(map[string]string{"label": "staff", "text_hint": "hint", "pass_hash": "1234"})
+	req, _ = http.NewRequest("POST", "/organizations/"+org.UUID+"/passphrases/", bytes.NewReader(data))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response)
+
+	// remove a passphrase from the pool
+	req, _ = http.NewRequest("DELETE", "/organizations/"+org.UUID+"/passphrases/pupils", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response)
+	req, _ = http.NewRequest("DELETE", "/organizations/"+org.UUID+"/passphrases/pupils", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response)
+}
+
+func TestGenerateLicenseFromPool(t *testing.T) {
+
+	inPub, _ := createPublication(t)
+	org := createOrganization(t)
+	defer deleteOrganization(t, org.UUID)
+
+	payload := newLicenseRequest(inPub.UUID)
+	payload.TextHint = ""
+	payload.PassHash = ""
+	payload.OrganizationID = org.UUID
+
+	// an unknown label is rejected
+	payload.PassphraseLabel = "unknown"
+	data, _ := json.Marshal(payload)
+	req, _ := http.NewRequest("POST", "/licenses/", bytes.NewReader(data))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response)
+
+	payload.PassphraseLabel = "pupils"
+	data, _ = json.Marshal(payload)
+
+	req, _ = http.NewRequest("POST", "/licenses/", bytes.NewReader(data))
+	response = executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		var outLic lic.License
+		if err := json.Unmarshal(response.Body.Bytes(), &outLic); err != nil {
+			t.Fatal(err)
+		}
+		if outLic.Encryption.UserKey.TextHint != "Passphrase of the pupils" {
+			t.Errorf("Unexpected text hint %q", outLic.Encryption.UserKey.TextHint)
+		}
+
+		// a fresh license keeps the passphrase the license was issued with, not the first of the pool
+		payload.PassphraseLabel = ""
+		data, _ = json.Marshal(payload)
+		req, _ = http.NewRequest("POST", "/licenses/"+outLic.UUID, bytes.NewReader(data))
+		response = executeRequest(req)
+		if checkResponseCode(t, http.StatusOK, response) {
+			var freshLic lic.License
+			json.Unmarshal(response.Body.Bytes(), &freshLic)
+			if freshLic.Encryption.UserKey.TextHint != "Passphrase of the pupils" {
+				t.Errorf("Unexpected text hint %q of the fresh license", freshLic.Encryption.UserKey.TextHint)
+			}
+		}
+		deleteLicense(t, outLic.UUID)
+	}
+}
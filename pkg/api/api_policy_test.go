@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+)
+
+func TestAuthorize(t *testing.T) {
+
+	logins := []conf.Login{{User: "admin", Password: "secret"}, {User: "ops", Password: "ops-secret", Role: conf.ROLE_OPERATOR},
+		{User: "viewer", Password: "viewer-secret", Role: conf.ROLE_READER}}
+	var principal *Principal
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { principal = PrincipalFromContext(r.Context()) })
+	routes := http.NewServeMux()
+	routes.Handle("/licenseinfo/", Authorize(ok))
+	routes.Handle("/providers/", Authorize(RequireRole(conf.ROLE_ADMIN)(ok)))
+	handler := BasicAuth("restricted", logins)(routes)
+
+	for _, tc := range []struct {
+		user, method, path string
+		code               int
+	}{
+		{"viewer", "GET", "/licenseinfo/", http.StatusOK},
+		{"viewer", "PUT", "/licenseinfo/123", http.StatusForbidden},
+		{"viewer", "GET", "/providers/", http.StatusForbidden},
+		{"ops", "PUT", "/licenseinfo/123", http.StatusOK},
+		{"ops", "DELETE", "/licenseinfo/123", http.StatusOK},
+		{"ops", "GET", "/providers/", http.StatusForbidden},
+		{"admin", "DELETE", "/providers/123", http.StatusOK},
+	} {
+		principal = nil
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		for _, login := range logins {
+			if login.User == tc.user {
+				req.SetBasicAuth(login.User, login.Password)
+			}
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != tc.code {
+			t.Errorf("Expected %d for %s %s by %s, got %d", tc.code, tc.method, tc.path, tc.user, rr.Code)
+		}
+		if rr.Code == http.StatusOK && (principal == nil || principal.Name != tc.user) {
+			t.Errorf("Expected the principal %s, got %+v", tc.user, principal)
+		}
+	}
+
+	// requests authenticated by a custom middleware have no principal
+	rr := httptest.NewRecorder()
+	Authorize(RequireRole(conf.ROLE_ADMIN)(ok)).ServeHTTP(rr, httptest.NewRequest("DELETE", "/providers/123", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 without principal, got %d", rr.Code)
+	}
+}
+
+func TestHighestRole(t *testing.T) {
+
+	for _, tc := range []struct {
+		roles []string
+		role  string
+	}{
+		{[]string{"reader", "admin", "operator"}, "admin"},
+		{[]string{"operator", "reader"}, "operator"},
+		{[]string{"editor", "reader"}, "reader"},
+		{[]string{"editor"}, ""},
+		{nil, ""},
+	} {
+		if role := HighestRole(tc.roles); role != tc.role {
+			t.Errorf("Expected %q for %v, got %q", tc.role, tc.roles, role)
+		}
+	}
+}
+
+func TestUnscoped(t *testing.T) {
+
+	handler := Unscoped(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tc := range []struct {
+		principal *Principal
+		code      int
+	}{
+		{nil, http.StatusOK},
+		{&Principal{Name: "admin", Role: conf.ROLE_ADMIN}, http.StatusOK},
+		{&Principal{Name: "cms", Role: conf.ROLE_ADMIN, Provider: "https://publisher.example.com"}, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("GET", "/coupons/", nil)
+		if tc.principal != nil {
+			req = req.WithContext(NewPrincipalContext(req.Context(), tc.principal))
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != tc.code {
+			t.Errorf("Expected %d for %+v, got %d", tc.code, tc.principal, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/google/uuid"
+)
+
+func TestPreviewLicenses(t *testing.T) {
+
+	publicationID := uuid.New().String()
+	epub := newCleartextEPUB(t)
+	req, _ := http.NewRequest("PUT", "/publications/"+publicationID+"/file", bytes.NewReader(epub))
+	req.Header.Set("Content-Type", "application/epub+zip")
+	if !checkResponseCode(t, http.StatusCreated, executeRequest(req)) {
+		t.FailNow()
+	}
+	defer deletePublication(t, publicationID)
+
+	setSample := func(query string) *http.Response {
+		req, _ := http.NewRequest("PUT", "/publications/"+publicationID+"/sample"+query, bytes.NewReader(epub))
+		req.Header.Set("Content-Type", "application/epub+zip")
+		return executeRequest(req).Result()
+	}
+	preview := func() *http.Response {
+		data, _ := json.Marshal(newLicenseRequest(""))
+		req, _ := http.NewRequest("POST", "/publications/"+publicationID+"/preview", bytes.NewReader(data))
+		return executeRequest(req).Result()
+	}
+
+	// no preview without a sample
+	if code := preview().StatusCode; code != http.StatusNotFound {
+		t.Errorf("Expected 404 without a sample, got %d", code)
+	}
+
+	// the sample holds resources of the publication
+	if code := setSample("").StatusCode; code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without resource, got %d", code)
+	}
+	if code := setSample("?resource=OEBPS/chapter9.xhtml").StatusCode; code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for an unknown resource, got %d", code)
+	}
+
+	// the sample is a publication with its own content key, kept when the sample is replaced
+	var sample, replaced stor.Publication
+	response := setSample("?resource=OEBPS/chapter1.xhtml")
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected 201 setting a sample, got %d", response.StatusCode)
+	}
+	json.NewDecoder(response.Body).Decode(&sample)
+	defer deletePublication(t, sample.UUID)
+	if sample.UUID == publicationID || sample.SampleOf != publicationID || len(sample.EncryptionKey) != 32 {
+		t.Errorf("Unexpected sample %+v", sample)
+	}
+	response = setSample("?resource=OEBPS/")
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 replacing a sample, got %d", response.StatusCode)
+	}
+	json.NewDecoder(response.Body).Decode(&replaced)
+	if replaced.UUID != sample.UUID || !bytes.Equal(replaced.EncryptionKey, sample.EncryptionKey) {
+		t.Errorf("Unexpected replaced sample %+v", replaced)
+	}
+
+	// a preview license is a short-lived license of the sample, without copy or print
+	response = preview()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 generating a preview license, got %d", response.StatusCode)
+	}
+	var license lic.License
+	json.NewDecoder(response.Body).Decode(&license)
+	defer deleteLicense(t, license.UUID)
+	if !strings.Contains(linkHref(license.Links, "publication"), sample.UUID) {
+		t.Errorf("Expected a license of the sample, got %+v", license.Links)
+	}
+	if license.Rights.End == nil || license.Rights.End.Sub(*license.Rights.Start).Hours() != defaultPreviewTTL ||
+		license.Rights.Copy == nil || *license.Rights.Copy != 0 || license.Rights.Print == nil || *license.Rights.Print != 0 {
+		t.Errorf("Unexpected preview rights %+v", license.Rights)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/publications/"+publicationID+"/sample", nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	req, _ = http.NewRequest("GET", "/publications/"+publicationID+"/sample", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/google/uuid"
+)
+
+// linkHref returns the href of the first link of a given relation, or an empty string
+func linkHref(links []lic.Link, rel string) string {
+	for _, l := range links {
+		if l.Rel == rel {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+func TestProviderPolicyLinks(t *testing.T) {
+
+	inLic, _ := createLicense(t)
+	defer deleteLicense(t, inLic.UUID)
+
+	// declare the policy links of the provider of the license
+	provider := &stor.Provider{
+		UUID:        uuid.New().String(),
+		URI:         inLic.Provider,
+		Name:        "Publisher",
+		TermsLink:   "https://publisher.example/terms",
+		PrivacyLink: "https://publisher.example/privacy",
+		SupportLink: "https://publisher.example/support",
+	}
+	data, _ := json.Marshal(provider)
+	req, _ := http.NewRequest("POST", "/providers/", bytes.NewReader(data))
+	if !checkResponseCode(t, http.StatusCreated, executeRequest(req)) {
+		t.FailNow()
+	}
+	defer func() {
+		req, _ := http.NewRequest("DELETE", "/providers/"+provider.UUID, nil)
+		checkResponseCode(t, http.StatusOK, executeRequest(req))
+	}()
+
+	// a provider URI is declared once, and policy links are urls
+	dup := *provider
+	dup.UUID = uuid.New().String()
+	data, _ = json.Marshal(dup)
+	req, _ = http.NewRequest("POST", "/providers/", bytes.NewReader(data))
+	checkResponseCode(t, http.StatusConflict, executeRequest(req))
+	dup.URI, dup.TermsLink = "https://other.example", "not a url"
+	data, _ = json.Marshal(dup)
+	req, _ = http.NewRequest("POST", "/providers/", bytes.NewReader(data))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+
+	// the links are set in licenses, and the support page of the provider replaces the self-service page
+	fetchLicense := func() *lic.License {
+		data, _ := json.Marshal(newLicenseRequest(inLic.PublicationID))
+		req, _ := http.NewRequest("POST", "/licenses/"+inLic.UUID, bytes.NewReader(data))
+		response := executeRequest(req)
+		if !checkResponseCode(t, http.StatusOK, response) {
+			t.FailNow()
+		}
+		var license lic.License
+		json.Unmarshal(response.Body.Bytes(), &license)
+		return &license
+	}
+	license := fetchLicense()
+	if linkHref(license.Links, "terms-of-service") != provider.TermsLink || linkHref(license.Links, "privacy-policy") != provider.PrivacyLink ||
+		linkHref(license.Links, "support") != provider.SupportLink {
+		t.Errorf("Unexpected license links %+v", license.Links)
+	}
+
+	// and in status documents
+	req, _ = http.NewRequest("GET", "/status/"+inLic.UUID, nil)
+	response := executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		var statusDoc lic.StatusDoc
+		json.Unmarshal(response.Body.Bytes(), &statusDoc)
+		if linkHref(statusDoc.Links, "terms-of-service") != provider.TermsLink || linkHref(statusDoc.Links, "privacy-policy") != provider.PrivacyLink {
+			t.Errorf("Unexpected status document links %+v", statusDoc.Links)
+		}
+	}
+
+	// an updated link is set in fresh licenses right away
+	provider.PrivacyLink = "https://publisher.example/privacy-v2"
+	provider.SupportLink = ""
+	data, _ = json.Marshal(provider)
+	req, _ = http.NewRequest("PUT", "/providers/"+provider.UUID, bytes.NewReader(data))
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	license = fetchLicense()
+	if linkHref(license.Links, "privacy-policy") != provider.PrivacyLink {
+		t.Errorf("Expected the updated privacy link, got %+v", license.Links)
+	}
+	if support := linkHref(license.Links, "support"); support == "" || support == "https://publisher.example/support" {
+		t.Errorf("Expected the self-service page as support link, got %s", support)
+	}
+}
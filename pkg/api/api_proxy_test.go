@@ -0,0 +1,170 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/lic"
+)
+
+// registerLink returns the register link of a status document fetched with the given headers
+func registerLink(t *testing.T, licenseID, remoteAddr string, headers map[string]string) string {
+
+	req, _ := http.NewRequest("GET", "/status/"+licenseID, nil)
+	req.RemoteAddr = remoteAddr
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	response := executeRequest(req)
+	if !checkResponseCode(t, http.StatusOK, response) {
+		t.FailNow()
+	}
+	var statusDoc lic.StatusDoc
+	if err := json.Unmarshal(response.Body.Bytes(), &statusDoc); err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range statusDoc.Links {
+		if link.Rel == "register" {
+			return link.Href
+		}
+	}
+	t.Fatal("Missing register link")
+	return ""
+}
+
+func TestProxyHeaders(t *testing.T) {
+
+	inLic, _ := createLicense(t)
+	defer deleteLicense(t, inLic.UUID)
+
+	// no proxy
+	href := registerLink(t, inLic.UUID, "192.0.2.1:1234", nil)
+	if !strings.HasPrefix(href, s.Config.PublicBaseUrl+"/register/") {
+		t.Errorf("Expected the configured base url, got %s", href)
+	}
+
+	// path-rewriting proxy using X-Forwarded-* headers
+	href = registerLink(t, inLic.UUID, "192.0.2.1:1234", map[string]string{
+		"X-Forwarded-Proto":  "https",
+		"X-Forwarded-Host":   "books.example.com",
+		"X-Forwarded-Prefix": "/lcp/",
+	})
+	if !strings.HasPrefix(href, "https://books.example.com/lcp/register/") {
+		t.Errorf("Expected the forwarded base url, got %s", href)
+	}
+
+	// a Forwarded header sent by the client is passed on by a proxy using X-Forwarded-* headers
+	href = registerLink(t, inLic.UUID, "192.0.2.1:1234", map[string]string{
+		"Forwarded":       `host=evil.example;for=1.2.3.4`,
+		"X-Forwarded-For": "203.0.113.5",
+	})
+	if !strings.HasPrefix(href, s.Config.PublicBaseUrl+"/register/") {
+		t.Errorf("A Forwarded header sent by the client must be ignored, got %s", href)
+	}
+
+	// forwarded headers sent by the client are passed on by the proxy, which appends its own
+	href = registerLink(t, inLic.UUID, "192.0.2.1:1234", map[string]string{
+		"X-Forwarded-For":   "10.1.2.3, 203.0.113.5",
+		"X-Forwarded-Host":  "evil.example, books.example.com",
+		"X-Forwarded-Proto": "http, https",
+	})
+	if !strings.HasPrefix(href, "https://books.example.com/register/") {
+		t.Errorf("Expected the base url forwarded by the proxy, got %s", href)
+	}
+
+	// headers sent by an untrusted client are ignored
+	href = registerLink(t, inLic.UUID, "203.0.113.9:1234", map[string]string{
+		"X-Forwarded-Host": "evil.example.com",
+	})
+	if !strings.HasPrefix(href, s.Config.PublicBaseUrl+"/register/") {
+		t.Errorf("Forwarded headers of an untrusted client must be ignored, got %s", href)
+	}
+}
+
+// TestForwardedHeader checks a chain of proxies using the Forwarded header
+func TestForwardedHeader(t *testing.T) {
+
+	proxyHeaders, err := ProxyHeaders("/lcp", []string{"192.0.2.0/24"}, conf.PROXY_FORWARDED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &APIHandler{Config: &conf.Config{PublicBaseUrl: "http://localhost:8989/lcp"}}
+	for _, tc := range []struct {
+		headers map[string]string
+		baseURL string
+		addr    string
+	}{
+		{map[string]string{"Forwarded": `for=198.51.100.17;proto=https;host="cdn.example.com", for=192.0.2.43;host=internal`},
+			"https://cdn.example.com/lcp", "198.51.100.17"},
+		// the element sent by the client is ignored
+		{map[string]string{"Forwarded": `for=10.1.2.3;host=evil.example, for=198.51.100.17;proto=https;host=cdn.example.com`},
+			"https://cdn.example.com/lcp", "198.51.100.17"},
+		// X-Forwarded-* headers are passed on from the client
+		{map[string]string{"Forwarded": `for=198.51.100.17`, "X-Forwarded-Host": "evil.example", "X-Forwarded-For": "1.2.3.4"},
+			"http://localhost:8989/lcp", "198.51.100.17"},
+	} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		var baseURL, addr string
+		proxyHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			baseURL, addr = h.publicBaseURL(r), clientAddr(r)
+		})).ServeHTTP(httptest.NewRecorder(), req)
+		if baseURL != tc.baseURL || addr != tc.addr {
+			t.Errorf("Expected %s and %s for %v, got %s and %s", tc.baseURL, tc.addr, tc.headers, baseURL, addr)
+		}
+	}
+}
+
+func TestProxyHeadersConfig(t *testing.T) {
+
+	if _, err := ProxyHeaders("", []string{"10.0.0.0/8", "::1", "192.168.1.1"}, ""); err != nil {
+		t.Errorf("Failed to parse trusted proxies: %v", err)
+	}
+	if _, err := ProxyHeaders("", []string{"not-an-ip"}, ""); err == nil {
+		t.Error("Expected an error for an invalid trusted proxy")
+	}
+	if _, err := ProxyHeaders("", nil, "x-real-ip"); err == nil {
+		t.Error("Expected an error for invalid proxy headers")
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+
+	_, trusted, _ := net.ParseCIDR("192.0.2.0/24")
+	for _, tc := range []struct {
+		mode    string
+		headers map[string]string
+		addr    string
+	}{
+		{conf.PROXY_FORWARDED, map[string]string{"Forwarded": `for=198.51.100.17;proto=https, for=192.0.2.43`}, "198.51.100.17"},
+		{conf.PROXY_FORWARDED, map[string]string{"Forwarded": `for="[2001:db8::17]:4711"`}, "2001:db8::17"},
+		{conf.PROXY_X_FORWARDED, map[string]string{"X-Forwarded-For": "203.0.113.5, 192.0.2.43"}, "203.0.113.5"},
+		{conf.PROXY_X_FORWARDED, map[string]string{"X-Forwarded-For": "unknown"}, ""},
+		// the values sent by the client are ignored
+		{conf.PROXY_X_FORWARDED, map[string]string{"X-Forwarded-For": "10.1.2.3, 203.0.113.5, 192.0.2.43"}, "203.0.113.5"},
+		{conf.PROXY_FORWARDED, map[string]string{"Forwarded": `for=10.1.2.3, for=198.51.100.17, for=192.0.2.43`}, "198.51.100.17"},
+		{conf.PROXY_X_FORWARDED, map[string]string{"X-Forwarded-For": "203.0.113.5, unknown, 192.0.2.43"}, ""},
+		// a chain of trusted proxies
+		{conf.PROXY_X_FORWARDED, map[string]string{"X-Forwarded-For": "192.0.2.7, 192.0.2.43"}, "192.0.2.7"},
+		// the headers of the other kind are passed on from the client
+		{conf.PROXY_X_FORWARDED, map[string]string{"Forwarded": `for=198.51.100.17`}, ""},
+		{conf.PROXY_FORWARDED, map[string]string{"X-Forwarded-For": "203.0.113.5"}, ""},
+		{conf.PROXY_X_FORWARDED, nil, ""},
+	} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		if addr, _ := forwardedFor(forwardedHops(req, tc.mode), []*net.IPNet{trusted}); addr != tc.addr {
+			t.Errorf("Expected %q for %v, got %q", tc.addr, tc.headers, addr)
+		}
+	}
+}
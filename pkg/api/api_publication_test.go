@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"strings"
@@ -179,6 +180,46 @@ func TestListPublications(t *testing.T) {
 				t.Error("Failed to get the same content back")
 			}
 		}
+		if total := response.Header().Get("X-Total-Count"); total != "10" {
+			t.Errorf("Expected a total count of 10, got %q", total)
+		}
+	}
+
+	// get a page of publications
+	req, _ = http.NewRequest("GET", path+"?page=2&per_page=4", nil)
+	response = executeRequest(req)
+
+	if checkResponseCode(t, http.StatusOK, response) {
+		var list []PublicationTest
+
+		if err := json.Unmarshal(response.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 4 {
+			t.Errorf("Expected 4 publications, got %d", len(list))
+		} else if !comparePublications(inPubs[4], &list[0]) {
+			t.Error("Failed to get the publications of the second page")
+		}
+		if total := response.Header().Get("X-Total-Count"); total != "10" {
+			t.Errorf("Expected a total count of 10, got %q", total)
+		}
+		link := response.Header().Get("Link")
+		for _, expected := range []string{
+			`</publications/?page=1&per_page=4>; rel="prev"`,
+			`</publications/?page=3&per_page=4>; rel="next"`,
+			`</publications/?page=3&per_page=4>; rel="last"`,
+		} {
+			if !strings.Contains(link, expected) {
+				t.Errorf("Expected the link %s, got %s", expected, link)
+			}
+		}
+	}
+
+	// invalid page sizes are rejected
+	for _, query := range []string{"?per_page=0", "?per_page=1001", "?page=x"} {
+		req, _ = http.NewRequest("GET", path+query, nil)
+		response = executeRequest(req)
+		checkResponseCode(t, http.StatusBadRequest, response)
 	}
 
 	// delete the publications
@@ -261,6 +302,130 @@ func TestSearchPublications(t *testing.T) {
 
 }
 
+func TestSearchPublicationsByText(t *testing.T) {
+
+	var inPubs []*PublicationTest
+	for _, p := range []struct{ title, author, contentType string }{
+		{"The Zebra Handbook", "Ann Smith", "application/epub+zip"},
+		{"Striped horses", "Bob ZEBRA", "application/epub+zip"},
+		{"Zebras in pictures", "Carl Jones", "application/pdf+lcp"},
+		{"100% Giraffe", "Dora Miles", "application/epub+zip"},
+	} {
+		pub := newPublication()
+		pub.Title, pub.Author, pub.ContentType = p.title, p.author, p.contentType
+		data, _ := json.Marshal(pub)
+		req, _ := http.NewRequest("POST", "/publications/", bytes.NewReader(data))
+		checkResponseCode(t, http.StatusCreated, executeRequest(req))
+		inPubs = append(inPubs, pub)
+	}
+	defer func() {
+		for _, pub := range inPubs {
+			deletePublication(t, pub.UUID)
+		}
+	}()
+
+	search := func(query string) []PublicationTest {
+		req, _ := http.NewRequest("GET", "/publications/search?"+query, nil)
+		response := executeRequest(req)
+		var list []PublicationTest
+		if checkResponseCode(t, http.StatusOK, response) {
+			if err := json.Unmarshal(response.Body.Bytes(), &list); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return list
+	}
+
+	// case-insensitive, in the title and the author
+	if list := search("q=zebra"); len(list) != 3 {
+		t.Errorf("Expected 3 publications, got %d", len(list))
+	}
+	// combined with the format
+	if list := search("q=zebra&format=epub&sort=title"); len(list) != 2 || list[0].Author != "Bob ZEBRA" {
+		t.Errorf("Expected 2 epub publications sorted by title, got %v", list)
+	}
+	// by uuid
+	if list := search("q=" + strings.ToUpper(inPubs[3].UUID[:13])); len(list) != 1 || list[0].UUID != inPubs[3].UUID {
+		t.Errorf("Expected the publication %s, got %v", inPubs[3].UUID, list)
+	}
+	// wildcards are searched literally
+	if list := search("q=100%25"); len(list) != 1 || list[0].Title != "100% Giraffe" {
+		t.Errorf("Expected a single publication, got %v", list)
+	}
+	if list := search("q=_"); len(list) != 0 {
+		t.Errorf("Expected no publication, got %d", len(list))
+	}
+}
+
+func TestUpsertPublication(t *testing.T) {
+
+	// a publication unknown to the server is created
+	inPub := newPublication()
+	data, _ := json.Marshal(inPub)
+	req, _ := http.NewRequest("PUT", "/publications/"+inPub.UUID, bytes.NewReader(data))
+	checkResponseCode(t, http.StatusCreated, executeRequest(req))
+	defer deletePublication(t, inPub.UUID)
+
+	// omitted fields keep their values
+	req, _ = http.NewRequest("PUT", "/publications/"+inPub.UUID, strings.NewReader(`{"title":"Merged title"}`))
+	response := executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		var outPub PublicationTest
+		if err := json.Unmarshal(response.Body.Bytes(), &outPub); err != nil {
+			t.Fatal(err)
+		}
+		inPub.Title = "Merged title"
+		if !comparePublications(inPub, &outPub) {
+			t.Errorf("Expected %+v, got %+v", inPub, outPub)
+		}
+	}
+
+	// the encryption key cannot change
+	changed := *inPub
+	changed.EncryptionKey = []byte("0123456789abcdef")
+	data, _ = json.Marshal(changed)
+	req, _ = http.NewRequest("PUT", "/publications/"+inPub.UUID, bytes.NewReader(data))
+	checkResponseCode(t, http.StatusConflict, executeRequest(req))
+
+	// the uuid of the url identifies the publication
+	req, _ = http.NewRequest("PUT", "/publications/"+uuid.New().String(), bytes.NewReader(data))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+	// and a new publication must be complete
+	req, _ = http.NewRequest("PUT", "/publications/"+uuid.New().String(), strings.NewReader(`{"title":"Incomplete"}`))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+}
+
+func TestCreateDuplicatePublication(t *testing.T) {
+
+	inPub, _ := createPublication(t)
+	defer deletePublication(t, inPub.UUID)
+
+	// a duplicate uuid is a conflict, located by the existing publication
+	inPub.Title = "Upserted title"
+	data, _ := json.Marshal(inPub)
+	req, _ := http.NewRequest("POST", "/publications/", bytes.NewReader(data))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusConflict, response)
+	if location := response.Header().Get("Location"); location != "/publications/"+inPub.UUID {
+		t.Errorf("Expected the location of the existing publication, got %q", location)
+	}
+
+	// unless the creation is an upsert
+	req, _ = http.NewRequest("POST", "/publications/?upsert=true", bytes.NewReader(data))
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	req, _ = http.NewRequest("GET", "/publications/"+inPub.UUID, nil)
+	response = executeRequest(req)
+	var outPub PublicationTest
+	if checkResponseCode(t, http.StatusOK, response) {
+		if err := json.Unmarshal(response.Body.Bytes(), &outPub); err != nil {
+			t.Fatal(err)
+		}
+		if !comparePublications(inPub, &outPub) {
+			t.Errorf("Expected the publication to be updated, got %+v", outPub)
+		}
+	}
+}
+
 func TestDeleteNoExistingPublication(t *testing.T) {
 
 	path := "/publications/" + uuid.New().String()
@@ -286,3 +451,57 @@ func TestGetDeletedPublication(t *testing.T) {
 
 	checkResponseCode(t, http.StatusNotFound, response)
 }
+
+func TestRestorePublication(t *testing.T) {
+
+	inPub, _ := createPublication(t)
+	deletePublication(t, inPub.UUID)
+
+	// the deleted publication is only listed on request
+	total := func(query string) string {
+		req, _ := http.NewRequest("GET", "/publications/?per_page=1"+query, nil)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response)
+		return response.Header().Get("X-Total-Count")
+	}
+	if total("") == total("&include_deleted=true") {
+		t.Error("Expected the deleted publication to be counted with include_deleted")
+	}
+	req, _ := http.NewRequest("GET", "/publications/?include_deleted=maybe", nil)
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+
+	// restore
+	req, _ = http.NewRequest("POST", "/publications/"+inPub.UUID+"/restore", nil)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response)
+	if strings.Contains(response.Body.String(), "deleted_at") {
+		t.Errorf("Expected an active publication, got %s", response.Body)
+	}
+	req, _ = http.NewRequest("GET", "/publications/"+inPub.UUID, nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	deletePublication(t, inPub.UUID)
+
+	req, _ = http.NewRequest("POST", "/publications/"+uuid.New().String()+"/restore", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+
+	// a publication whose file was collected after its deletion is not restored
+	inPub, _ = createPublication(t)
+	pub, err := s.Store.Publication().Get(inPub.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub.StorageKey = inPub.UUID + ".epub"
+	if err = s.Store.Publication().Update(pub); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Tiering.Hot.Put(context.Background(), pub.StorageKey, strings.NewReader("content")); err != nil {
+		t.Fatal(err)
+	}
+	deletePublication(t, inPub.UUID)
+	req, _ = http.NewRequest("POST", "/storage/gc?dry_run=false&grace=0s", nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	req, _ = http.NewRequest("POST", "/publications/"+inPub.UUID+"/restore", nil)
+	checkResponseCode(t, http.StatusConflict, executeRequest(req))
+	req, _ = http.NewRequest("GET", "/publications/"+inPub.UUID, nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+func TestStorageReport(t *testing.T) {
+
+	req, _ := http.NewRequest("GET", "/reports/storage", nil)
+	response := executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		var usage []stor.StorageUsage
+		if err := json.Unmarshal(response.Body.Bytes(), &usage); err != nil {
+			t.Fatal(err)
+		}
+		// publications created by the tests are not managed by the server
+		if len(usage) != 0 {
+			t.Errorf("Unexpected storage usage %v", usage)
+		}
+	}
+}
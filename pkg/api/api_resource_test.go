@@ -0,0 +1,99 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMultiPartPublication(t *testing.T) {
+
+	inPub, _ := createPublication(t)
+	defer deletePublication(t, inPub.UUID)
+
+	// store two tracks
+	tracks := []string{strings.Repeat("a", 1000), strings.Repeat("b", 2000)}
+	for i, track := range tracks {
+		if _, err := s.Tiering.Hot.Put(context.Background(), inPub.UUID+"/track"+string(rune('1'+i))+".mp3", strings.NewReader(track)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	payload := `{"resources": [
+		{"position": 1, "href": "track1.mp3", "content_type": "audio/mpeg", "size": 1000, "checksum": "YQ==", "duration": 62.5, "storage_key": "` + inPub.UUID + `/track1.mp3"},
+		{"position": 2, "href": "track2.mp3", "content_type": "audio/mpeg", "size": 2000, "checksum": "Yg==", "duration": 120, "storage_key": "` + inPub.UUID + `/track2.mp3"}
+	]}`
+	req, _ := http.NewRequest("PUT", "/publications/"+inPub.UUID+"/resources", strings.NewReader(payload))
+	response := executeRequest(req)
+	if !checkResponseCode(t, http.StatusOK, response) {
+		t.FailNow()
+	}
+
+	// duplicate positions are rejected
+	req, _ = http.NewRequest("PUT", "/publications/"+inPub.UUID+"/resources", bytes.NewReader([]byte(`{"resources": [
+		{"position": 1, "href": "a.mp3", "content_type": "audio/mpeg", "checksum": "YQ=="},
+		{"position": 1, "href": "b.mp3", "content_type": "audio/mpeg", "checksum": "YQ=="}]}`)))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response)
+
+	// get the manifest
+	req, _ = http.NewRequest("GET", "/content/"+inPub.UUID+"/manifest", nil)
+	response = executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		var manifest ManifestResponse
+		if err := json.Unmarshal(response.Body.Bytes(), &manifest); err != nil {
+			t.Fatal(err)
+		}
+		if len(manifest.ReadingOrder) != 2 || manifest.Metadata.Duration != 182.5 {
+			t.Fatalf("Unexpected manifest %+v", manifest)
+		}
+		if manifest.ReadingOrder[1].Href != s.Config.PublicBaseUrl+"/content/"+inPub.UUID+"/2" || manifest.ReadingOrder[1].Properties.Size != 2000 {
+			t.Errorf("Unexpected link %+v", manifest.ReadingOrder[1])
+		}
+	}
+
+	// the links of the manifest use the base url of the provider of the publication
+	pub, err := s.Store.Publication().Get(inPub.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub.Provider = "https://publisher.example"
+	if err = s.Store.Publication().Update(pub); err != nil {
+		t.Fatal(err)
+	}
+	c := setConfig()
+	c.License.BaseUrls = map[string]string{pub.Provider: "https://drm.publisher.example"}
+	r := chi.NewRouter()
+	r.Get("/content/{publicationID}/manifest", NewAPIHandler(c, s.Store, s.Cert).GetManifest)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/content/"+inPub.UUID+"/manifest", nil))
+	var manifest ManifestResponse
+	json.Unmarshal(rr.Body.Bytes(), &manifest)
+	if len(manifest.ReadingOrder) != 2 || manifest.ReadingOrder[0].Href != "https://drm.publisher.example/content/"+inPub.UUID+"/1" {
+		t.Errorf("Unexpected manifest %s", rr.Body)
+	}
+
+	// stream a range of a track
+	req, _ = http.NewRequest("GET", "/content/"+inPub.UUID+"/2", nil)
+	req.Header.Set("Range", "bytes=100-199")
+	response = executeRequest(req)
+	if checkResponseCode(t, http.StatusPartialContent, response) {
+		if response.Body.String() != strings.Repeat("b", 100) || response.Header().Get("Content-Type") != "audio/mpeg" {
+			t.Errorf("Unexpected range response %q", response.Body.String())
+		}
+	}
+	// the streaming is recorded as a fulfillment, so that the publication is not archived
+	if pub, err = s.Store.Publication().Get(inPub.UUID); err != nil || pub.LastFulfilled == nil {
+		t.Errorf("Expected the streamed publication to be fulfilled, got %v, %v", pub.LastFulfilled, err)
+	}
+
+	// unknown track
+	req, _ = http.NewRequest("GET", "/content/"+inPub.UUID+"/3", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response)
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/cache"
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestResponseCache(t *testing.T) {
+
+	h := NewAPIHandler(setConfig(), s.Store, s.Cert)
+	h.Cache = cache.NewMemory(10)
+	r := chi.NewRouter()
+	r.With(h.CacheResponses(cache.StatusKey, "licenseID", time.Minute)).Get("/status/{licenseID}", h.StatusDoc)
+	r.With(h.InvalidateCache(cache.StatusKey, "licenseID")).Put("/revoke/{licenseID}", h.Revoke)
+
+	status := func(licenseID string) (int, string) {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", "/status/"+licenseID, nil))
+		var statusDoc lic.StatusDoc
+		json.Unmarshal(rr.Body.Bytes(), &statusDoc)
+		if rr.Code == http.StatusOK && rr.Header().Get("Content-Type") != lic.ContentType_LSD_JSON {
+			t.Errorf("Expected the media type of status documents, got %s", rr.Header().Get("Content-Type"))
+		}
+		return rr.Code, statusDoc.Status
+	}
+
+	inLic, _ := createLicense(t)
+	defer deleteLicense(t, inLic.UUID)
+
+	// the status document is served from the cache until the license is changed by a route
+	if code, st := status(inLic.UUID); code != http.StatusOK || st != stor.STATUS_READY {
+		t.Fatalf("Expected a ready license, got %d %s", code, st)
+	}
+	license, err := s.Store.License().Get(inLic.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	license.Status = stor.STATUS_ACTIVE
+	if err = s.Store.License().Update(license); err != nil {
+		t.Fatal(err)
+	}
+	if _, st := status(inLic.UUID); st != stor.STATUS_READY {
+		t.Errorf("Expected the cached status document, got %s", st)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("PUT", "/revoke/"+inLic.UUID, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected a 200 status code, got %d %s", rr.Code, rr.Body)
+	}
+	if _, st := status(inLic.UUID); st != stor.STATUS_REVOKED {
+		t.Errorf("Expected a revoked license, got %s", st)
+	}
+
+	// errors are not cached
+	if code, _ := status("unknown"); code != http.StatusNotFound {
+		t.Errorf("Expected a 404 status code, got %d", code)
+	}
+	if _, err = h.Cache.Get(context.Background(), cache.StatusKey("unknown")); !errors.Is(err, cache.ErrMiss) {
+		t.Errorf("Expected no cached error, got %v", err)
+	}
+
+	// a response is cached for the url it was requested at
+	data := encodeResponse("https://lcp.example.com/status/1", "application/json", "", []byte("{}"))
+	if _, _, _, ok := decodeResponse(data, "https://proxy.example.com/status/1"); ok {
+		t.Error("Expected a response cached for another url to be ignored")
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRosters(t *testing.T) {
+
+	pub, _ := createPublication(t)
+	defer deletePublication(t, pub.UUID)
+	template := map[string]interface{}{"publication_id": pub.UUID, "provider": "https://www.edrlab.org", "copy": 100}
+
+	// a JSON roster; the license template must be of a known publication
+	roster := func(publicationID string, users ...string) *http.Request {
+		students := []RosterStudent{}
+		for _, u := range users {
+			students = append(students, RosterStudent{UserID: u})
+		}
+		template["publication_id"] = publicationID
+		payload, _ := json.Marshal(map[string]interface{}{"license": template, "students": students})
+		req, _ := http.NewRequest("POST", "/rosters", bytes.NewReader(payload))
+		return req
+	}
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(roster(pub.UUID)))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(roster(pub.UUID, "s1", "s1")))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(roster("2c7d5e4a-3b4f-4b0e-9c3e-6a8e0f1d2b3c", "s1")))
+	template["publication_id"] = pub.UUID
+
+	// a CSV roster, uploaded with the license template
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	data, _ := json.Marshal(template)
+	mw.WriteField("license", string(data))
+	fw, _ := mw.CreateFormFile("roster", "class-6b.csv")
+	fw.Write([]byte("name,user_id\nAda,s1\nAlan,s2\n"))
+	mw.Close()
+	req, _ := http.NewRequest("POST", "/rosters", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	response := executeRequest(req)
+	if !checkResponseCode(t, http.StatusAccepted, response) {
+		t.FailNow()
+	}
+	var started JobResponse
+	json.Unmarshal(response.Body.Bytes(), &started)
+
+	// the delivery report gives the license and the links of each student
+	job := waitJob(t, started.UUID)
+	if job.Type != JOB_ROSTER || job.Failed != 0 || len(job.Results) != 2 {
+		t.Fatalf("Unexpected roster job %+v", job)
+	}
+	for i, user := range []string{"s1", "s2"} {
+		res := job.Results[i]
+		defer deleteLicense(t, res.UUID)
+		if res.Status != http.StatusCreated || res.UserID != user || !strings.HasSuffix(res.Links["status"], "/status/"+res.UUID) {
+			t.Errorf("Unexpected result %+v", res)
+		}
+	}
+	req, _ = http.NewRequest("GET", "/rosters/"+started.UUID+"?format=csv", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response)
+	records, err := csv.NewReader(response.Body).ReadAll()
+	if err != nil || len(records) != 3 || records[1][1] != "s1" || records[2][3] != job.Results[1].UUID {
+		t.Errorf("Unexpected CSV report %v, %v", records, err)
+	}
+	req, _ = http.NewRequest("GET", "/licenseinfo/"+job.Results[0].UUID, nil)
+	if response = executeRequest(req); !bytes.Contains(response.Body.Bytes(), []byte(`"copy":100`)) {
+		t.Errorf("Expected a license of the template, got %s", response.Body)
+	}
+
+	// other jobs are not rosters
+	payload, _ := json.Marshal(map[string]interface{}{"type": "revoke", "items": []string{"unknown"}})
+	req, _ = http.NewRequest("POST", "/jobs", bytes.NewReader(payload))
+	json.Unmarshal(executeRequest(req).Body.Bytes(), &started)
+	waitJob(t, started.UUID)
+	req, _ = http.NewRequest("GET", "/rosters/"+started.UUID, nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+}
+
+func TestParseRosterCSV(t *testing.T) {
+
+	students, err := parseRosterCSV([]byte("\ufeffFirst Name, User_ID\nAda, s1\n\nAlan\n"))
+	if err != nil || len(students) != 2 || students[0].UserID != "s1" || students[1].UserID != "" {
+		t.Errorf("Unexpected students %+v, %v", students, err)
+	}
+	if _, err = parseRosterCSV([]byte("name,email\nAda,ada@example.com\n")); err == nil {
+		t.Error("Expected an error without user_id column")
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/check"
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestSchemaCheck(t *testing.T) {
+
+	// status documents conform only if they link to the fresh license
+	s.Config.SchemaCheck = "strict"
+	s.Config.Status.LicenseLink = "https://provider.example.com/licenses/{license_id}"
+	defer func() {
+		s.Config.SchemaCheck = ""
+		s.Config.Status.LicenseLink = ""
+	}()
+	inLic, _ := createLicense(t)
+	defer deleteLicense(t, inLic.UUID)
+
+	// generated licenses and status documents conform to the schemas
+	data, _ := json.Marshal(newLicenseRequest(inLic.PublicationID))
+	req, _ := http.NewRequest("POST", "/licenses/"+inLic.UUID, bytes.NewReader(data))
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	req, _ = http.NewRequest("GET", "/status/"+inLic.UUID, nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	req, _ = http.NewRequest("POST", "/licenses/"+inLic.UUID+"/register?id=1&name=device1", nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+
+	// a nonconforming document is rejected in strict mode, and only logged in log mode
+	logger, hook := test.NewNullLogger()
+	h := &APIHandler{Config: &conf.Config{SchemaCheck: "strict"}, Logger: logger}
+	var schemaErr *check.SchemaError
+	if err := h.checkSchema(check.StatusSchema, &lic.StatusDoc{}); !errors.As(err, &schemaErr) {
+		t.Errorf("Expected a schema error, got %v", err)
+	}
+	h.Config.SchemaCheck = "log"
+	if err := h.checkSchema(check.StatusSchema, &lic.StatusDoc{}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if entry := hook.LastEntry(); entry == nil || entry.Level != logrus.ErrorLevel {
+		t.Errorf("Expected the nonconforming document to be logged, got %v", entry)
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestSearchLimits(t *testing.T) {
+
+	h := NewAPIHandler(setConfig(), s.Store, s.Cert)
+	h.Searches = NewSearchLimits(conf.Search{DefaultLimit: 2, MaxLimit: 3})
+	r := chi.NewRouter()
+	r.Get("/licenseinfo/search", h.SearchLicenses)
+	r.Get("/licenseinfo/export", h.ExportLicenses)
+
+	inPub, _ := createPublication(t)
+	defer deletePublication(t, inPub.UUID)
+	for i := 0; i < 3; i++ {
+		data, _ := json.Marshal(newLicense(inPub.UUID))
+		response := executeRequest(httptest.NewRequest("POST", "/licenseinfo", bytes.NewReader(data)))
+		if !checkResponseCode(t, http.StatusCreated, response) {
+			t.FailNow()
+		}
+		var outLic LicenseTest
+		json.Unmarshal(response.Body.Bytes(), &outLic)
+		defer deleteLicense(t, outLic.UUID)
+	}
+	search := func(query string) (*httptest.ResponseRecorder, []LicenseTest) {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", "/licenseinfo/search?pub="+inPub.UUID+query, nil))
+		var list []LicenseTest
+		json.Unmarshal(rr.Body.Bytes(), &list)
+		return rr, list
+	}
+
+	// a search without limit gets the default limit, and links to the export of its results
+	rr, list := search("")
+	if rr.Code != http.StatusOK || len(list) != 2 || rr.Header().Get("X-Result-Limit") != "2" || rr.Header().Get("X-Result-Truncated") != "true" {
+		t.Errorf("Expected 2 truncated results, got %d %d %v", rr.Code, len(list), rr.Header())
+	}
+	if link := rr.Header().Get("Link"); link != "</licenseinfo/export?pub="+inPub.UUID+">; rel=\"export\"" {
+		t.Errorf("Unexpected link %s", link)
+	}
+
+	// a search requests its limit, up to the max
+	if rr, list = search("&limit=3"); rr.Code != http.StatusOK || len(list) != 3 || rr.Header().Get("X-Result-Truncated") != "" {
+		t.Errorf("Expected 3 results, got %d %d %v", rr.Code, len(list), rr.Header())
+	}
+	if rr, _ = search("&limit=4"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a 400 status code, got %d", rr.Code)
+	}
+	if stats := h.Searches.Stats(); stats.Searches != 2 || stats.Truncated != 1 || stats.DefaultLimit != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// the export streams every result
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/licenseinfo/export?pub="+inPub.UUID, nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("Expected a stream, got %d %v", rr.Code, rr.Header())
+	}
+	lines := 0
+	for scanner := bufio.NewScanner(rr.Body); scanner.Scan(); lines++ {
+		var license LicenseTest
+		if err := json.Unmarshal(scanner.Bytes(), &license); err != nil || license.PublicationID != inPub.UUID {
+			t.Errorf("Unexpected line %s: %v", scanner.Text(), err)
+		}
+	}
+	if lines != 3 {
+		t.Errorf("Expected 3 exported licenses, got %d", lines)
+	}
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/licenseinfo/export", nil))
+	if rr.Code != http.StatusNotFound || strings.Contains(rr.Header().Get("Content-Type"), "ndjson") {
+		t.Errorf("Expected a 404 status code without criteria, got %d", rr.Code)
+	}
+}
+
+func TestAdaptiveSearchLimit(t *testing.T) {
+
+	l := NewSearchLimits(conf.Search{TargetDuration: 100})
+	current := func() int { return l.Stats().DefaultLimit }
+
+	// a slow search halves the default limit, unless its limit was requested
+	l.observe(false, true, 200*time.Millisecond)
+	if current() != 500 {
+		t.Errorf("Expected a default limit of 500, got %d", current())
+	}
+	l.observe(true, true, 200*time.Millisecond)
+	if current() != 500 || l.Stats().Slow != 2 {
+		t.Errorf("Expected a default limit of 500 after 2 slow searches, got %+v", l.Stats())
+	}
+
+	// a fast truncated search raises it back, up to the configuration
+	l.observe(false, true, 10*time.Millisecond)
+	if current() != 551 {
+		t.Errorf("Expected a default limit of 551, got %d", current())
+	}
+	l.observe(false, false, 10*time.Millisecond)
+	if current() != 551 {
+		t.Errorf("Expected a default limit kept for a complete search, got %d", current())
+	}
+	for i := 0; i < 20; i++ {
+		l.observe(false, true, 10*time.Millisecond)
+	}
+	if current() != 1000 {
+		t.Errorf("Expected a default limit of 1000, got %d", current())
+	}
+
+	// it is never lowered below 100
+	for i := 0; i < 10; i++ {
+		l.observe(false, true, time.Second)
+	}
+	if current() != minSearchLimit {
+		t.Errorf("Expected a default limit of %d, got %d", minSearchLimit, current())
+	}
+}
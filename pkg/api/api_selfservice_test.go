@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+func TestSelfService(t *testing.T) {
+
+	inLic, _ := createLicense(t)
+	defer deleteLicense(t, inLic.UUID)
+	token := lic.SelfServiceToken(s.Config.SelfService.Secret, inLic.UUID)
+	page := "/self-service/" + inLic.UUID + "?token=" + token
+
+	// a ready license cannot be returned
+	req, _ := http.NewRequest("GET", page, nil)
+	response := executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		body := response.Body.String()
+		if !strings.HasPrefix(response.Header().Get("Content-Type"), "text/html") || !strings.Contains(body, "ready to be opened") ||
+			strings.Contains(body, "<form") {
+			t.Errorf("Unexpected self-service page %s", body)
+		}
+	}
+
+	// an active license lists its devices and can be returned
+	req, _ = http.NewRequest("POST", "/register/"+inLic.UUID+"?id=d1&name=My+reader", nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	req, _ = http.NewRequest("GET", page+"&lang=fr", nil)
+	response = executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		body := response.Body.String()
+		if !strings.Contains(body, "actif") || !strings.Contains(body, "My reader") || !strings.Contains(body, `action="`+inLic.UUID+`/return"`) {
+			t.Errorf("Unexpected self-service page %s", body)
+		}
+	}
+	req, _ = http.NewRequest("POST", "/self-service/"+inLic.UUID+"/return", strings.NewReader(url.Values{"token": {token}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response = executeRequest(req)
+	if checkResponseCode(t, http.StatusSeeOther, response) && response.Header().Get("Location") != s.Config.PublicBaseUrl+page {
+		t.Errorf("Unexpected redirection to %s", response.Header().Get("Location"))
+	}
+	req, _ = http.NewRequest("GET", page, nil)
+	if response = executeRequest(req); !strings.Contains(response.Body.String(), stor.STATUS_RETURNED) {
+		t.Errorf("Expected a returned license, got %s", response.Body)
+	}
+
+	// an invalid token is processed as an unknown license
+	req, _ = http.NewRequest("GET", "/self-service/"+inLic.UUID+"?token=invalid", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+	req, _ = http.NewRequest("POST", "/self-service/"+inLic.UUID+"/return", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+}
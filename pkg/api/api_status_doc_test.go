@@ -1,12 +1,19 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/edrlab/lcp-server/pkg/conf"
 	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/google/uuid"
 )
 
 // ---
@@ -30,10 +37,20 @@ func TestGetStatusDoc(t *testing.T) {
 		if err := json.Unmarshal((response.Body.Bytes()), &statusDoc); err != nil {
 			t.Fatal(err)
 		}
+		if ct := response.Header().Get("Content-Type"); ct != lic.ContentType_LSD_JSON {
+			t.Errorf("Expected the media type of status documents, got %s", ct)
+		}
+		if statusDoc.ID != inLic.UUID || statusDoc.Status != stor.STATUS_READY || statusDoc.PotentialRights == nil {
+			t.Errorf("Unexpected status document %s", response.Body)
+		}
 		// visual clue
 		log.Printf("%s\n", response.Body.String())
 	}
 
+	// an unknown license has no status document
+	req, _ = http.NewRequest("GET", "/status/unknown", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+
 	// delete the license
 	deleteLicense(t, inLic.UUID)
 }
@@ -63,6 +80,61 @@ func TestRegister(t *testing.T) {
 	deleteLicense(t, inLic.UUID)
 }
 
+func TestRegisterLicense(t *testing.T) {
+
+	inLic, _ := createLicense(t)
+	defer deleteLicense(t, inLic.UUID)
+
+	// a device registered twice is counted once
+	for _, device := range []string{"?id=1&name=device1", "?id=1&name=device1", "?id=2&name=device2"} {
+		req, _ := http.NewRequest("POST", "/licenses/"+inLic.UUID+"/register"+device, nil)
+		checkResponseCode(t, http.StatusOK, executeRequest(req))
+	}
+	count := strconv.Itoa(inLic.DeviceCount + 2)
+	req, _ := http.NewRequest("GET", "/licenseinfo/search?user="+inLic.UserID+"&count="+count+":"+count, nil)
+	response := executeRequest(req)
+	if !strings.Contains(response.Body.String(), inLic.UUID) {
+		t.Errorf("Expected the license to be found by its device count %s, got %s", count, response.Body)
+	}
+
+	// the device parameters are required, and the license must exist
+	req, _ = http.NewRequest("POST", "/licenses/"+inLic.UUID+"/register?id=3", nil)
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+	req, _ = http.NewRequest("POST", "/licenses/unknown/register?id=1&name=device1", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+}
+
+func TestListDevices(t *testing.T) {
+
+	inLic, _ := createLicense(t)
+	defer deleteLicense(t, inLic.UUID)
+
+	for _, device := range []string{"?id=1&name=device1", "?id=2&name=device2"} {
+		req, _ := http.NewRequest("POST", "/licenses/"+inLic.UUID+"/register"+device, nil)
+		checkResponseCode(t, http.StatusOK, executeRequest(req))
+	}
+	req, _ := http.NewRequest("PUT", "/licenses/"+inLic.UUID+"/renew?id=1&name=device1", nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+
+	// the devices are listed with their last event
+	req, _ = http.NewRequest("GET", "/licenses/"+inLic.UUID+"/devices", nil)
+	response := executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		var devices []DeviceResponse
+		if err := json.Unmarshal(response.Body.Bytes(), &devices); err != nil {
+			t.Fatal(err)
+		}
+		if len(devices) != 2 || devices[0].ID != "1" || devices[0].Name != "device1" || devices[0].FirstSeen.IsZero() ||
+			devices[0].LastEvent == nil || devices[0].LastEvent.Type != stor.EVENT_RENEW ||
+			devices[1].LastEvent == nil || devices[1].LastEvent.Type != stor.EVENT_REGISTER {
+			t.Errorf("Unexpected devices %s", response.Body)
+		}
+	}
+
+	req, _ = http.NewRequest("GET", "/licenses/unknown/devices", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+}
+
 func TestRenew(t *testing.T) {
 
 	// create a license
@@ -98,6 +170,29 @@ func TestRenew(t *testing.T) {
 		log.Printf("%s\n", response.Body.String())
 	}
 
+	// renew up to an explicit end date, via the license route
+	newEnd := inLic.End.AddDate(0, 0, 20).UTC().Format(time.RFC3339)
+	path = "/licenses/" + inLic.UUID + "/renew?id=1&name=device1&end=" + newEnd
+	req, _ = http.NewRequest("PUT", path, nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	req, _ = http.NewRequest("GET", "/licenseinfo/"+inLic.UUID, nil)
+	response = executeRequest(req)
+	if !strings.Contains(response.Body.String(), `"end":"`+newEnd+`"`) {
+		t.Errorf("Expected the end date %s, got %s", newEnd, response.Body)
+	}
+
+	// an end date before the current one, or beyond the potential end, is rejected
+	path = "/licenses/" + inLic.UUID + "/renew?id=1&name=device1&end=" + inLic.End.UTC().Format(time.RFC3339)
+	req, _ = http.NewRequest("PUT", path, nil)
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+	path = "/licenses/" + inLic.UUID + "/renew?id=1&name=device1&end=" + inLic.End.AddDate(1, 0, 0).UTC().Format(time.RFC3339)
+	req, _ = http.NewRequest("PUT", path, nil)
+	checkResponseCode(t, http.StatusForbidden, executeRequest(req))
+
+	// an unknown license is not found
+	req, _ = http.NewRequest("PUT", "/licenses/unknown/renew?id=1&name=device1", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+
 	// delete the license
 	deleteLicense(t, inLic.UUID)
 }
@@ -137,10 +232,57 @@ func TestReturn(t *testing.T) {
 		log.Printf("%s\n", response.Body.String())
 	}
 
+	// a returned license cannot be returned again
+	req, _ = http.NewRequest("PUT", "/licenses/"+inLic.UUID+"/return?id=1&name=device1", nil)
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+
 	// delete the license
 	deleteLicense(t, inLic.UUID)
 }
 
+func TestReturnLicense(t *testing.T) {
+
+	// an active license is returned via the license route
+	inLic, _ := createLicense(t)
+	req, _ := http.NewRequest("POST", "/register/"+inLic.UUID+"?id=1&name=device1", nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	req, _ = http.NewRequest("PUT", "/licenses/"+inLic.UUID+"/return?id=1&name=device1", nil)
+	response := executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		var statusDoc lic.StatusDoc
+		if err := json.Unmarshal(response.Body.Bytes(), &statusDoc); err != nil {
+			t.Fatal(err)
+		}
+		event := statusDoc.Events[len(statusDoc.Events)-1]
+		if statusDoc.Status != stor.STATUS_RETURNED || event.Type != stor.EVENT_RETURN || event.DeviceID != "1" || event.DeviceName != "device1" {
+			t.Errorf("Expected a returned license with a return event, got %s", response.Body)
+		}
+	}
+	deleteLicense(t, inLic.UUID)
+
+	// an expired license cannot be returned
+	inLic, _ = createLicense(t)
+	req, _ = http.NewRequest("POST", "/register/"+inLic.UUID+"?id=1&name=device1", nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	end := time.Now().Add(-time.Hour)
+	inLic.End = &end
+	inLic.Status = stor.STATUS_ACTIVE
+	data, _ := json.Marshal(inLic)
+	req, _ = http.NewRequest("PUT", "/licenseinfo/"+inLic.UUID, bytes.NewReader(data))
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	req, _ = http.NewRequest("PUT", "/licenses/"+inLic.UUID+"/return?id=1&name=device1", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response)
+	if !strings.Contains(response.Body.String(), "expired") {
+		t.Errorf("Expected an expired license error, got %s", response.Body)
+	}
+	deleteLicense(t, inLic.UUID)
+
+	// an unknown license is not found
+	req, _ = http.NewRequest("PUT", "/licenses/unknown/return?id=1&name=device1", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+}
+
 func TestRevoke(t *testing.T) {
 
 	// create a license
@@ -179,3 +321,74 @@ func TestRevoke(t *testing.T) {
 	// delete the license
 	deleteLicense(t, inLic.UUID)
 }
+
+func TestRevokeWithReason(t *testing.T) {
+
+	inLic, _ := createLicense(t)
+	defer deleteLicense(t, inLic.UUID)
+
+	req, _ := http.NewRequest("POST", "/register/"+inLic.UUID+"?id=1&name=device1", nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+
+	req, _ = http.NewRequest("PUT", "/licenses/"+inLic.UUID+"/revoke", strings.NewReader(`{"reason": "refunded", "actor": "support"}`))
+	response := executeRequest(req)
+	if !checkResponseCode(t, http.StatusOK, response) {
+		t.FailNow()
+	}
+	var statusDoc lic.StatusDoc
+	if err := json.Unmarshal(response.Body.Bytes(), &statusDoc); err != nil {
+		t.Fatal(err)
+	}
+	if statusDoc.Status != stor.STATUS_REVOKED || statusDoc.Message != "The license has been revoked: refunded" {
+		t.Errorf("Expected a revoked license with a reason, got %s %q", statusDoc.Status, statusDoc.Message)
+	}
+	if !statusDoc.Updated.License.Equal(statusDoc.Updated.Status) {
+		t.Errorf("Expected the license to be updated with its status, got %+v", statusDoc.Updated)
+	}
+
+	// the status document served to readers reflects the revocation
+	req, _ = http.NewRequest("GET", "/status/"+inLic.UUID, nil)
+	response = executeRequest(req)
+	if !strings.Contains(response.Body.String(), `"status":"revoked"`) || !strings.Contains(response.Body.String(), "refunded") {
+		t.Errorf("Expected a revoked status document, got %s", response.Body)
+	}
+
+	// a revoked license cannot be revoked again, an unknown one is not found
+	req, _ = http.NewRequest("PUT", "/licenses/"+inLic.UUID+"/revoke", nil)
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+	req, _ = http.NewRequest("PUT", "/licenses/"+uuid.New().String()+"/revoke", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+	req, _ = http.NewRequest("PUT", "/licenses/"+inLic.UUID+"/revoke", strings.NewReader(`{"reason": `))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+}
+
+func TestRevocationPropagation(t *testing.T) {
+
+	s.Config.Revocation.Channels = map[string]conf.Endpoint{"provider": {URL: "https://provider.example.com/revocations"}}
+	defer func() { s.Config.Revocation.Channels = nil }()
+
+	inLic, _ := createLicense(t)
+	defer deleteLicense(t, inLic.UUID)
+	req, _ := http.NewRequest("POST", "/licenses/"+inLic.UUID+"/register?id=1&name=device1", nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+
+	// a propagated revocation is pending until the channels confirm it, but readers get a revoked license
+	req, _ = http.NewRequest("PUT", "/licenses/"+inLic.UUID+"/revoke", nil)
+	response := executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) && !strings.Contains(response.Body.String(), `"status":"revoked"`) {
+		t.Errorf("Expected a revoked status document, got %s", response.Body)
+	}
+	req, _ = http.NewRequest("GET", "/licenses/"+inLic.UUID+"/revocation", nil)
+	response = executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		var revocation RevocationResponse
+		json.Unmarshal(response.Body.Bytes(), &revocation)
+		if revocation.Status != stor.STATUS_REVOKING || len(revocation.Channels) != 1 ||
+			revocation.Channels[0].Channel != "provider" || revocation.Channels[0].Confirmed != nil {
+			t.Errorf("Unexpected revocation %s", response.Body)
+		}
+	}
+
+	req, _ = http.NewRequest("GET", "/licenses/unknown/revocation", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func syncCatalog(t *testing.T, source string, pubs ...interface{}) *SyncResponse {
+	data, _ := json.Marshal(map[string]interface{}{"source": source, "publications": pubs})
+	req, _ := http.NewRequest("POST", "/publications/sync", bytes.NewReader(data))
+	response := executeRequest(req)
+	if !checkResponseCode(t, http.StatusOK, response) {
+		t.FailNow()
+	}
+	out := &SyncResponse{}
+	if err := json.Unmarshal(response.Body.Bytes(), out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestSyncPublications(t *testing.T) {
+
+	a, b, c := newPublication(), newPublication(), newPublication()
+	defer func() {
+		for _, pub := range []*PublicationTest{a, b, c} {
+			deletePublication(t, pub.UUID)
+		}
+	}()
+
+	// first synchronization: every publication is created
+	out := syncCatalog(t, "publisher1", a, b)
+	if out.Created != 2 || out.Updated != 0 || out.Failed != 0 || len(out.Deleted) != 0 {
+		t.Errorf("Expected 2 publications created, got %+v", out)
+	}
+
+	// a is updated, b is deleted, c is created
+	a.Title = "Synchronized title"
+	out = syncCatalog(t, "publisher1", a, c)
+	if out.Created != 1 || out.Updated != 1 || len(out.Deleted) != 1 || out.Deleted[0] != b.UUID {
+		t.Errorf("Expected a publication created, updated and deleted, got %+v", out)
+	}
+	req, _ := http.NewRequest("GET", "/publications/"+b.UUID, nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+	req, _ = http.NewRequest("GET", "/publications/"+a.UUID, nil)
+	response := executeRequest(req)
+	if !strings.Contains(response.Body.String(), "Synchronized title") || !strings.Contains(response.Body.String(), `"source":"publisher1"`) {
+		t.Errorf("Expected the publication to be updated, got %s", response.Body)
+	}
+
+	// the publications of another source are left alone, and a rejected item prevents any deletion
+	out = syncCatalog(t, "publisher2", a, map[string]string{"title": "no uuid"})
+	if out.Failed != 2 || out.Results[0].Status != http.StatusConflict || out.Results[1].Status != http.StatusBadRequest || len(out.Deleted) != 0 {
+		t.Errorf("Expected 2 rejected publications, got %+v", out)
+	}
+
+	// an empty catalog is rejected
+	req, _ = http.NewRequest("POST", "/publications/sync", strings.NewReader(`{"source":"publisher1","publications":[]}`))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+	req, _ = http.NewRequest("POST", "/publications/sync", strings.NewReader(`{"publications":[{}]}`))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/edrlab/lcp-server/pkg/conf"
 	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/storage"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
@@ -24,8 +25,9 @@ import (
 type Server struct {
 	Config *conf.Config
 	stor.Store
-	Cert   *tls.Certificate
-	Router *chi.Mux
+	Cert    *tls.Certificate
+	Tiering *storage.Tiering
+	Router  *chi.Mux
 }
 
 // s is the server variable shared by all tests
@@ -35,10 +37,11 @@ var s Server
 type PublicationTest struct {
 	UUID          string `json:"uuid"`
 	Title         string `json:"title"`
+	Author        string `json:"author,omitempty"`
 	EncryptionKey []byte `json:"encryption_key"`
 	Location      string `json:"location"`
 	ContentType   string `json:"content_type"`
-	Size          uint32 `json:"size"`
+	Size          int64  `json:"size"`
 	Checksum      string `json:"checksum"`
 }
 
@@ -57,6 +60,7 @@ type LicenseTest struct {
 	Status        string     `json:"status"`
 	StatusUpdated *time.Time `json:"status_updated,omitempty"`
 	DeviceCount   int        `json:"device_count"`
+	OrderRef      string     `json:"order_ref,omitempty"`
 }
 
 // ---
@@ -81,6 +85,12 @@ func setConfig() *conf.Config {
 			Profile:  "http://readium.org/lcp/basic-profile",
 			HintLink: "https://www.edrlab.org/lcp-help/{license_id}",
 		},
+		Status:         conf.Status{RenewDefaultDays: 7, RenewMaxDays: 40},
+		Void:           conf.Void{Window: 14, MaxDevices: 1, MaxEvents: 1},
+		Storage:        conf.Storage{MaxUploadSize: 1},
+		SelfService:    conf.SelfService{Secret: "a self-service secret"},
+		Formats:        map[string]string{"cbz": "application/vnd.comicbook+zip"},
+		TrustedProxies: []string{"192.0.2.0/24"}, // remote address of test requests
 	}
 
 	return &c
@@ -99,7 +109,7 @@ func newPublication() *PublicationTest {
 	rand.Read(pub.EncryptionKey)
 	pub.Location = faker.Internet().Url()
 	pub.ContentType = "application/epub+zip"
-	pub.Size = uint32(faker.Number().NumberInt(5))
+	pub.Size = int64(faker.Number().NumberInt(5))
 	pub.Checksum = faker.Lorem().Characters(16)
 
 	return pub
@@ -240,6 +250,11 @@ func TestMain(m *testing.M) {
 		panic("Database setup failed")
 	}
 
+	// Setup the media type registry
+	if err = RegisterMediaTypes(s.Store, s.Config.Formats); err != nil {
+		panic(err)
+	}
+
 	// Setup the X509 certificate
 	var certFile, privKeyFile string
 	if certFile = s.Config.Certificate.Cert; certFile == "" {
@@ -254,23 +269,44 @@ func TestMain(m *testing.M) {
 	}
 	s.Cert = &cert
 
+	// Setup a temporary storage of publications
+	dir, err := os.MkdirTemp("", "lcp-storage-")
+	if err != nil {
+		panic(err)
+	}
+	hot, err := storage.NewFileStorage(dir, "https://cdn.example.com/publications")
+	if err != nil {
+		panic(err)
+	}
+	s.Tiering = &storage.Tiering{Hot: hot, Store: s.Store}
+
 	// Set a context for handlers
 	h := NewAPIHandler(s.Config, s.Store, s.Cert)
+	h.Tiering = s.Tiering
 
 	// Define the router
 	r := chi.NewRouter()
 
 	s.Router = r
 
+	proxyHeaders, err := ProxyHeaders(s.Config.BasePath, s.Config.TrustedProxies, s.Config.ProxyHeaders)
+	if err != nil {
+		panic(err)
+	}
+
 	r.Use(middleware.RequestID)
 	//r.Use(middleware.Logger)
 	r.Use(middleware.URLFormat)
+	r.Use(proxyHeaders)
 
 	// Only public routes for these tests
 	r.Group(func(r chi.Router) {
 		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte("This is the LCP Server running!"))
 		})
+		r.Get("/healthz", h.Healthz)
+		r.Get("/livez", h.Livez)
+		r.Get("/readyz", h.Readyz)
 	})
 
 	r.Group(func(r chi.Router) {
@@ -278,21 +314,34 @@ func TestMain(m *testing.M) {
 
 		// Publications
 		r.Route("/publications", func(r chi.Router) {
-			r.Get("/", h.ListPublications)
-			r.Get("/search", h.SearchPublications) // GET /publication/search{?format}
-			r.Post("/", h.CreatePublication)       // POST /publications
+			r.With(Paginate).Get("/", h.ListPublications)
+			r.Get("/search", h.SearchPublications)      // GET /publication/search{?format}
+			r.Post("/", h.CreatePublication)            // POST /publications
+			r.Post("/sync", h.SyncPublications)         // POST /publications/sync
+			r.Get("/changes", h.ListPublicationChanges) // GET /publications/changes{?since,limit}
 
 			r.Route("/{publicationID}", func(r chi.Router) {
-				r.Get("/", h.GetPublication)       // GET /publications/123
-				r.Put("/", h.UpdatePublication)    // PUT /publications/123
-				r.Delete("/", h.DeletePublication) // DELETE /publications/123
+				r.Get("/", h.GetPublication)                        // GET /publications/123
+				r.Put("/", h.UpdatePublication)                     // PUT /publications/123
+				r.Delete("/", h.DeletePublication)                  // DELETE /publications/123
+				r.Get("/resources", h.ListResources)                // GET /publications/123/resources
+				r.Put("/resources", h.SetResources)                 // PUT /publications/123/resources
+				r.Post("/restore", h.RestorePublication)            // POST /publications/123/restore
+				r.Put("/file", h.EncryptPublication)                // PUT /publications/123/file
+				r.Post("/file", h.UploadPublication)                // POST /publications/123/file
+				r.Get("/usage", h.GetPublicationUsage)              // GET /publications/123/usage{?from,to}
+				r.Get("/collections", h.ListPublicationCollections) // GET /publications/123/collections
+				r.Get("/sample", h.GetSample)                       // GET /publications/123/sample
+				r.Put("/sample", h.SetSample)                       // PUT /publications/123/sample{?resource}
+				r.Delete("/sample", h.DeleteSample)                 // DELETE /publications/123/sample
+				r.Post("/preview", h.GeneratePreviewLicense)        // POST /publications/123/preview
 			})
 		})
 
 		// LicenseInfo, CRUD
 		r.Route("/licenseinfo", func(r chi.Router) {
-			r.Get("/", h.ListLicenses)
-			r.Get("/search", h.SearchLicenses) // GET /licenses/search{?pub,user,status,count}
+			r.With(Paginate).Get("/", h.ListLicenses)
+			r.Get("/search", h.SearchLicenses) // GET /licenses/search{?pub,user,status,count,bundle,order_ref}
 			r.Post("/", h.CreateLicense)       // POST /licenses
 
 			r.Route("/{licenseID}", func(r chi.Router) {
@@ -302,15 +351,154 @@ func TestMain(m *testing.M) {
 			})
 		})
 
+		// Redemption of coupons, generating a license
+		r.Post("/redeem", h.Redeem) // POST /redeem
+
+		// Claim of gifts, generating the license of the recipient
+		r.Post("/claim", h.ClaimGift) // POST /claim
+
 		// License generation
 		r.Route("/licenses/", func(r chi.Router) {
-			r.Post("/", h.GenerateLicense) // POST /licenses
+			r.Post("/", h.GenerateLicense)     // POST /licenses
+			r.Post("/batch", h.CreateLicenses) // POST /licenses/batch
 
 			r.Route("/{licenseID}", func(r chi.Router) {
-				r.Post("/", h.GetFreshLicense) // POST /licenses/123
+				r.Post("/", h.GetFreshLicense)                  // POST /licenses/123
+				r.Get("/document", h.GetLicenseDocument)        // GET /licenses/123/document
+				r.Post("/restore", h.RestoreLicense)            // POST /licenses/123/restore
+				r.Put("/revoke", h.Revoke)                      // PUT /licenses/123/revoke
+				r.Get("/revocation", h.GetRevocation)           // GET /licenses/123/revocation
+				r.Post("/void", h.Void)                         // POST /licenses/123/void
+				r.Post("/register", h.Register)                 // POST /licenses/123/register{?id,name}
+				r.Put("/renew", h.Renew)                        // PUT /licenses/123/renew{?end,id,name}
+				r.Put("/return", h.Return)                      // PUT /licenses/123/return{?id,name}
+				r.Get("/devices", h.ListDevices)                // GET /licenses/123/devices
+				r.Get("/actions", h.ListActions)                // GET /licenses/123/actions
+				r.Get("/audit", h.ListAudit)                    // GET /licenses/123/audit
+				r.Post("/actions", h.ScheduleAction)            // POST /licenses/123/actions
+				r.Delete("/actions/{actionID}", h.CancelAction) // DELETE /licenses/123/actions/1
+			})
+		})
+
+		// Bulk operations
+		r.Route("/jobs", func(r chi.Router) {
+			r.Post("/", h.CreateJob)          // POST /jobs
+			r.Get("/{jobID}", h.GetJob)       // GET /jobs/123
+			r.Delete("/{jobID}", h.CancelJob) // DELETE /jobs/123
+		})
+
+		// Class rosters
+		r.Route("/rosters", func(r chi.Router) {
+			r.Post("/", h.CreateRoster)    // POST /rosters
+			r.Get("/{jobID}", h.GetRoster) // GET /rosters/123{?format}
+		})
+
+		// Media type registry
+		r.Route("/mediatypes", func(r chi.Router) {
+			r.Get("/", h.ListMediaTypes)
+			r.Post("/", h.CreateMediaType) // POST /mediatypes
+
+			r.Route("/{format}", func(r chi.Router) {
+				r.Get("/", h.GetMediaType)       // GET /mediatypes/epub
+				r.Put("/", h.UpdateMediaType)    // PUT /mediatypes/epub
+				r.Delete("/", h.DeleteMediaType) // DELETE /mediatypes/epub
 			})
 		})
 
+		// Organizations and their passphrase pools
+		r.Route("/organizations", func(r chi.Router) {
+			r.Get("/", h.ListOrganizations)
+			r.Post("/", h.CreateOrganization) // POST /organizations
+
+			r.Route("/{organizationID}", func(r chi.Router) {
+				r.Get("/", h.GetOrganization)       // GET /organizations/123
+				r.Put("/", h.UpdateOrganization)    // PUT /organizations/123
+				r.Delete("/", h.DeleteOrganization) // DELETE /organizations/123
+
+				r.Route("/passphrases", func(r chi.Router) {
+					r.Get("/", h.ListPassphrases)            // GET /organizations/123/passphrases
+					r.Post("/", h.AddPassphrase)             // POST /organizations/123/passphrases
+					r.Delete("/{label}", h.DeletePassphrase) // DELETE /organizations/123/passphrases/teachers
+				})
+			})
+		})
+
+		// Providers and their policy links
+		r.Route("/providers", func(r chi.Router) {
+			r.Get("/", h.ListProviders)
+			r.Post("/", h.CreateProvider) // POST /providers
+
+			r.Route("/{providerID}", func(r chi.Router) {
+				r.Get("/", h.GetProvider)       // GET /providers/123
+				r.Put("/", h.UpdateProvider)    // PUT /providers/123
+				r.Delete("/", h.DeleteProvider) // DELETE /providers/123
+			})
+		})
+
+		// Collections of publications: series and bundles
+		r.Route("/collections", func(r chi.Router) {
+			r.Get("/", h.ListCollections)
+			r.Post("/", h.CreateCollection) // POST /collections
+
+			r.Route("/{collectionID}", func(r chi.Router) {
+				r.Get("/", h.GetCollection)                                    // GET /collections/123
+				r.Put("/", h.UpdateCollection)                                 // PUT /collections/123
+				r.Delete("/", h.DeleteCollection)                              // DELETE /collections/123
+				r.Get("/members", h.ListCollectionMembers)                     // GET /collections/123/members
+				r.Put("/members/{publicationID}", h.SetCollectionMember)       // PUT /collections/123/members/456
+				r.Delete("/members/{publicationID}", h.DeleteCollectionMember) // DELETE /collections/123/members/456
+				r.Post("/licenses", h.CreateBundleLicenses)                    // POST /collections/123/licenses
+			})
+		})
+
+		// Coupons, exchanged for licenses by POST /redeem
+		r.Route("/coupons", func(r chi.Router) {
+			r.Get("/", h.ListCoupons)
+			r.Post("/", h.CreateCoupon)            // POST /coupons
+			r.Post("/generate", h.GenerateCoupons) // POST /coupons/generate{?count}
+
+			r.Route("/{code}", func(r chi.Router) {
+				r.Get("/", h.GetCoupon)                  // GET /coupons/ABCD-EFGH
+				r.Put("/", h.UpdateCoupon)               // PUT /coupons/ABCD-EFGH
+				r.Delete("/", h.DeleteCoupon)            // DELETE /coupons/ABCD-EFGH
+				r.Get("/redemptions", h.ListRedemptions) // GET /coupons/ABCD-EFGH/redemptions
+			})
+		})
+
+		// Gifts, claimed by their recipient by POST /claim
+		r.Route("/gifts", func(r chi.Router) {
+			r.Get("/", h.ListGifts)   // GET /gifts{?purchaser}
+			r.Post("/", h.CreateGift) // POST /gifts
+
+			r.Route("/{giftID}", func(r chi.Router) {
+				r.Get("/", h.GetGift)       // GET /gifts/123
+				r.Delete("/", h.CancelGift) // DELETE /gifts/123
+			})
+		})
+
+		// Storage maintenance
+		r.Post("/storage/gc", h.CollectOrphans) // POST /storage/gc{?dry_run,grace}
+
+		// Reports
+		r.Get("/reports/storage", h.StorageReport) // GET /reports/storage
+
+		// Verification of the history of the licenses
+		r.Get("/events/verify", h.VerifyEvents) // GET /events/verify
+
+		// Notifications dropped after their last attempt
+		r.Get("/webhooks/dead-letters", h.ListDeadLetters) // GET /webhooks/dead-letters{?target,limit}
+
+		// Multi-part publications
+		r.Group(func(r chi.Router) {
+			r.Get("/content/{publicationID}/manifest", h.GetManifest)      // GET /content/123/manifest
+			r.Get("/content/{publicationID}/{position}", h.StreamResource) // GET /content/123/1
+		})
+
+		// Html pages for users
+		r.With(NewRateLimiter(100).Handler).Get("/hint/{licenseID}", h.HintPage) // GET /hint/123{?lang}
+		r.Get("/self-service/{licenseID}", h.SelfService)                        // GET /self-service/123{?token,lang}
+		r.Post("/self-service/{licenseID}/return", h.SelfServiceReturn)          // POST /self-service/123/return
+
 		// Status document management
 		r.Group(func(r chi.Router) {
 			r.Use(render.SetContentType(render.ContentTypeJSON))
@@ -324,5 +512,6 @@ func TestMain(m *testing.M) {
 	})
 
 	code := m.Run()
+	os.RemoveAll(dir)
 	os.Exit(code)
 }
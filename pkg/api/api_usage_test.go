@@ -0,0 +1,73 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getUsage(t *testing.T, pubID string, query string) *UsageResponse {
+	req, _ := http.NewRequest("GET", "/publications/"+pubID+"/usage"+query, nil)
+	response := executeRequest(req)
+	if !checkResponseCode(t, http.StatusOK, response) {
+		t.FailNow()
+	}
+	out := &UsageResponse{}
+	if err := json.Unmarshal(response.Body.Bytes(), out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestPublicationUsage(t *testing.T) {
+
+	inLic, _ := createLicense(t)
+	defer deleteLicense(t, inLic.UUID)
+
+	// two fresh licenses are fetched
+	data, _ := json.Marshal(newLicenseRequest(inLic.PublicationID))
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "/licenses/"+inLic.UUID, bytes.NewReader(data))
+		checkResponseCode(t, http.StatusOK, executeRequest(req))
+	}
+
+	// a resource is downloaded, then streamed by ranges
+	key := inLic.PublicationID + "/track.mp3"
+	if _, err := s.Tiering.Hot.Put(context.Background(), key, strings.NewReader(strings.Repeat("a", 1000))); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("PUT", "/publications/"+inLic.PublicationID+"/resources", strings.NewReader(`{"resources": [
+		{"position": 1, "href": "track.mp3", "content_type": "audio/mpeg", "size": 1000, "checksum": "YQ==", "storage_key": "`+key+`"}]}`))
+	checkResponseCode(t, http.StatusOK, executeRequest(req))
+	for _, rng := range []string{"", "bytes=0-99", "bytes=100-199"} {
+		req, _ = http.NewRequest("GET", "/content/"+inLic.PublicationID+"/1", nil)
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		executeRequest(req)
+	}
+
+	out := getUsage(t, inLic.PublicationID, "")
+	today := time.Now().UTC().Format("2006-01-02")
+	if out.Licenses != 1 || out.LicenseFetches != 2 || out.Downloads != 2 || out.To != today {
+		t.Errorf("Expected 1 license, 2 fetches and 2 downloads today, got %+v", out)
+	}
+	if len(out.Days) != 1 || out.Days[0].Day != today || out.Days[0].LicenseFetches != 2 {
+		t.Errorf("Expected the usage of today, got %+v", out.Days)
+	}
+
+	// another period
+	if out = getUsage(t, inLic.PublicationID, "?from=2020-01-01&to=2020-01-31"); len(out.Days) != 0 || out.LicenseFetches != 0 {
+		t.Errorf("Expected no usage, got %+v", out)
+	}
+	for _, query := range []string{"?from=yesterday", "?from=2020-02-01&to=2020-01-01", "?from=2020-01-01&to=2022-01-01"} {
+		req, _ = http.NewRequest("GET", "/publications/"+inLic.PublicationID+"/usage"+query, nil)
+		checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+	}
+	req, _ = http.NewRequest("GET", "/publications/unknown/usage", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req))
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+// createOrderedLicense creates an unused license sold by an order
+func createOrderedLicense(t *testing.T, orderRef string) *LicenseTest {
+
+	inPub, _ := createPublication(t)
+	inLic := newLicense(inPub.UUID)
+	inLic.DeviceCount = 0
+	inLic.OrderRef = orderRef
+	data, _ := json.Marshal(inLic)
+	req, _ := http.NewRequest("POST", "/licenseinfo", bytes.NewReader(data))
+	if !checkResponseCode(t, http.StatusCreated, executeRequest(req)) {
+		t.Fatal("Failed to create a license")
+	}
+	return inLic
+}
+
+func TestVoid(t *testing.T) {
+
+	inLic := createOrderedLicense(t, "order-1")
+	defer deleteLicense(t, inLic.UUID)
+	path := "/licenses/" + inLic.UUID + "/void"
+
+	for _, tc := range []struct {
+		name    string
+		path    string
+		payload string
+		code    int
+	}{
+		{"missing reason", path, `{"order_ref": "order-1"}`, http.StatusBadRequest},
+		{"other order", path, `{"reason": "Refunded", "order_ref": "order-2"}`, http.StatusConflict},
+		{"unknown license", "/licenses/unknown/void", `{"reason": "Refunded"}`, http.StatusNotFound},
+	} {
+		req, _ := http.NewRequest("POST", tc.path, bytes.NewBufferString(tc.payload))
+		if !checkResponseCode(t, tc.code, executeRequest(req)) {
+			t.Errorf("Unexpected status code for the %s", tc.name)
+		}
+	}
+
+	// the license is voided once
+	req, _ := http.NewRequest("POST", path, bytes.NewBufferString(`{"reason": "Refunded", "actor": "storefront", "order_ref": "order-1"}`))
+	response := executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		var statusDoc lic.StatusDoc
+		if err := json.Unmarshal(response.Body.Bytes(), &statusDoc); err != nil {
+			t.Fatal(err)
+		}
+		if statusDoc.Status != stor.STATUS_CANCELLED {
+			t.Errorf("Expected a cancelled license, got %s", statusDoc.Status)
+		}
+	}
+	req, _ = http.NewRequest("POST", path, bytes.NewBufferString(`{"reason": "Refunded", "order_ref": "order-1"}`))
+	checkResponseCode(t, http.StatusConflict, executeRequest(req))
+
+	// the license is found by its order
+	req, _ = http.NewRequest("GET", "/licenseinfo/search?order_ref=order-1", nil)
+	if response = executeRequest(req); !strings.Contains(response.Body.String(), inLic.UUID) {
+		t.Errorf("Expected the license to be found by its order, got %s", response.Body)
+	}
+}
+
+func TestVoidUsedLicense(t *testing.T) {
+
+	inLic := createOrderedLicense(t, "")
+	defer deleteLicense(t, inLic.UUID)
+
+	// a license registered by two devices is not voided
+	for _, device := range []string{"?id=1&name=device1", "?id=2&name=device2"} {
+		req, _ := http.NewRequest("POST", "/register/"+inLic.UUID+device, nil)
+		checkResponseCode(t, http.StatusOK, executeRequest(req))
+	}
+	req, _ := http.NewRequest("POST", "/licenses/"+inLic.UUID+"/void", bytes.NewBufferString(`{"reason": "Refunded"}`))
+	response := executeRequest(req)
+	if checkResponseCode(t, http.StatusConflict, response) && !strings.Contains(response.Body.String(), "devices") {
+		t.Errorf("Expected the devices to be the cause, got %s", response.Body)
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrewarm(t *testing.T) {
+
+	readyz := func(h *APIHandler) (int, HealthResponse) {
+		rr := httptest.NewRecorder()
+		h.Readyz(rr, httptest.NewRequest("GET", "/readyz", nil))
+		var ready HealthResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &ready); err != nil {
+			t.Fatal(err)
+		}
+		return rr.Code, ready
+	}
+
+	// the server is not ready during the warmup
+	h := NewAPIHandler(setConfig(), s.Store, s.Cert)
+	h.warmup = &warmup{done: make(chan struct{})}
+	if code, ready := readyz(h); code != http.StatusServiceUnavailable || ready.Checks["warmup"] == nil || ready.Checks["warmup"].Status != HEALTH_FAIL {
+		t.Errorf("Expected a warmup in progress, got %d %+v", code, ready)
+	}
+
+	// the signer and the store are warmed up before the server is ready
+	h = NewAPIHandler(setConfig(), s.Store, s.Cert)
+	<-h.Prewarm(context.Background())
+	if code, ready := readyz(h); code != http.StatusOK || ready.Checks["warmup"] == nil || ready.Checks["warmup"].Status != HEALTH_OK {
+		t.Errorf("Expected a warm server, got %d %+v", code, ready)
+	}
+
+	// a signature which can't be verified fails the warmup
+	h = NewAPIHandler(setConfig(), s.Store, nil)
+	h.Signer = stubSigner{}
+	<-h.Prewarm(context.Background())
+	if h.warmup.err == nil {
+		t.Error("Expected the test signature to fail the warmup")
+	}
+	if code, ready := readyz(h); code != http.StatusServiceUnavailable || ready.Checks["warmup"].Status != HEALTH_FAIL {
+		t.Errorf("Expected a failed warmup, got %d %+v", code, ready)
+	}
+}
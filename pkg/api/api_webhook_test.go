@@ -0,0 +1,46 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+func TestListDeadLetters(t *testing.T) {
+
+	for _, target := range []string{"cms", "callback:status"} {
+		letter := &stor.DeadLetter{Target: target, NotificationID: "audit-1", Type: "reader.action", Payload: json.RawMessage(`{"id":"audit-1"}`), Attempts: 3, LastError: "unavailable"}
+		if err := s.Store.Webhook().Drop(letter); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the letters of a target
+	req, _ := http.NewRequest("GET", "/webhooks/dead-letters?target=callback:status", nil)
+	response := executeRequest(req)
+	if !checkResponseCode(t, http.StatusOK, response) {
+		return
+	}
+	var letters []stor.DeadLetter
+	if err := json.Unmarshal(response.Body.Bytes(), &letters); err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].Target != "callback:status" || string(letters[0].Payload) != `{"id":"audit-1"}` {
+		t.Errorf("Unexpected dead letters %s", response.Body)
+	}
+
+	// the latest letters of every target
+	req, _ = http.NewRequest("GET", "/webhooks/dead-letters?limit=1", nil)
+	response = executeRequest(req)
+	if checkResponseCode(t, http.StatusOK, response) {
+		json.Unmarshal(response.Body.Bytes(), &letters)
+		if len(letters) != 1 || letters[0].Target != "callback:status" {
+			t.Errorf("Unexpected dead letters %s", response.Body)
+		}
+	}
+
+	req, _ = http.NewRequest("GET", "/webhooks/dead-letters?limit=0", nil)
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req))
+}
@@ -0,0 +1,79 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/render"
+)
+
+// ListAudit returns the audit log of a license: who created, updated, renewed, returned, revoked or deleted it,
+// when, and which values changed, in the order of the changes.
+func (h *APIHandler) ListAudit(w http.ResponseWriter, r *http.Request) {
+
+	var licenseID string
+	if licenseID = getLicenseID(w, r); licenseID == "" {
+		return
+	}
+	st := h.store(r)
+	if _, err := st.License().WithDeleted().Get(licenseID); err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	records, err := st.Audit().List(licenseID)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err := render.RenderList(w, r, NewAuditListResponse(records)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// actor returns the actor of the changes made on a request, recorded in the audit log of the licenses:
+// the authenticated principal, or else the user of the basic authentication, or else the device of a
+// status request, e.g. "device:123". It is empty for an anonymous request.
+func actor(r *http.Request) string {
+	if p := PrincipalFromContext(r.Context()); p != nil {
+		return p.Name
+	}
+	if user, _, ok := r.BasicAuth(); ok {
+		return user
+	}
+	if deviceID := r.URL.Query().Get("id"); deviceID != "" {
+		return "device:" + deviceID
+	}
+	return ""
+}
+
+// --
+// Request and Response payloads for the REST api.
+// --
+
+// AuditResponse is the response payload of an audit record.
+type AuditResponse struct {
+	*stor.AuditRecord
+}
+
+// NewAuditListResponse creates a rendered list of audit records.
+func NewAuditListResponse(records *[]stor.AuditRecord) []render.Renderer {
+	list := []render.Renderer{}
+	for i := 0; i < len(*records); i++ {
+		list = append(list, NewAuditResponse(&(*records)[i]))
+	}
+	return list
+}
+
+// NewAuditResponse creates a rendered audit record.
+func NewAuditResponse(record *stor.AuditRecord) *AuditResponse {
+	return &AuditResponse{AuditRecord: record}
+}
+
+// Render processes responses before marshalling.
+func (a *AuditResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/jwt"
+	"github.com/go-chi/render"
+)
+
+// BasicAuth returns a middleware requiring the HTTP Basic credentials of one of the logins, e.g. on the private routes;
+// the login and its role are added to the context of the request as its principal. Every login is compared in
+// constant time, so that response times reveal neither the users nor the passwords.
+func BasicAuth(realm string, logins []conf.Login) func(http.Handler) http.Handler {
+
+	// hashes have the same length, whatever the length of the credentials
+	type credentials struct{ user, password [sha256.Size]byte }
+	accepted := make([]credentials, 0, len(logins))
+	principals := make([]*Principal, 0, len(logins))
+	for _, login := range logins {
+		if login.User == "" || login.Password == "" {
+			continue
+		}
+		accepted = append(accepted, credentials{sha256.Sum256([]byte(login.User)), sha256.Sum256([]byte(login.Password))})
+		role := login.Role
+		if role == "" {
+			role = conf.ROLE_ADMIN
+		}
+		principals = append(principals, &Principal{Name: login.User, Role: role, Provider: login.Provider})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, password, ok := r.BasicAuth()
+			userHash, passwordHash := sha256.Sum256([]byte(user)), sha256.Sum256([]byte(password))
+			match, index := 0, 0
+			for i, c := range accepted {
+				m := subtle.ConstantTimeCompare(userHash[:], c.user[:]) & subtle.ConstantTimeCompare(passwordHash[:], c.password[:])
+				index = subtle.ConstantTimeSelect(m, i, index)
+				match |= m
+			}
+			if !ok || match != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
+				render.Render(w, r, ErrUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(NewPrincipalContext(r.Context(), principals[index])))
+		})
+	}
+}
+
+// TokenValidator validates bearer tokens, e.g. a jwt.Validator or an oauth.Server. The errors of an invalid
+// token wrap jwt.ErrInvalidToken.
+type TokenValidator interface {
+	Validate(ctx context.Context, token string) (*jwt.Claims, error)
+}
+
+// BearerAuth returns a middleware requiring a bearer token accepted by one of the validators, e.g. issued by the
+// identity provider of the organization; the claims of the token and its principal, with the most privileged role
+// of the token, are added to the context of the request. Requests
+// without bearer token are passed to the fallback middleware, e.g. BasicAuth, or rejected if there is none.
+func BearerAuth(realm string, fallback func(http.Handler) http.Handler, validators ...TokenValidator) func(http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+		var other http.Handler
+		if fallback != nil {
+			other = fallback(next)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := ""
+			if authz := r.Header.Get("Authorization"); len(authz) > 7 && strings.EqualFold(authz[:7], "Bearer ") {
+				token = strings.TrimSpace(authz[7:])
+			}
+			if token == "" && other != nil {
+				other.ServeHTTP(w, r)
+				return
+			}
+			if token == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`"`)
+				render.Render(w, r, ErrUnauthorized)
+				return
+			}
+			var claims *jwt.Claims
+			err := jwt.ErrInvalidToken
+			for _, v := range validators {
+				if claims, err = v.Validate(r.Context(), token); !errors.Is(err, jwt.ErrInvalidToken) {
+					break
+				}
+			}
+			if errors.Is(err, jwt.ErrInvalidToken) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`", error="invalid_token"`)
+				render.Render(w, r, ErrUnauthorized)
+				return
+			}
+			if err != nil {
+				// the keys of the identity provider are not available
+				render.Render(w, r, ErrUnavailable(err))
+				return
+			}
+			ctx := jwt.NewContext(r.Context(), claims)
+			ctx = NewPrincipalContext(ctx, &Principal{Name: claims.Subject, Role: HighestRole(claims.Roles), Provider: claims.Provider})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
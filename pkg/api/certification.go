@@ -0,0 +1,122 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/check"
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+// In certification mode, the data ingested by the server is checked against the requirements of the LCP
+// and LSD specifications, and nonconforming data is rejected, instead of producing licenses which would
+// fail the certification tests. Generated licenses are also checked before being returned.
+
+// link relations required in a license
+var licenseRels = []string{"hint", "publication", "status"}
+
+// certifyPublication checks the properties of a publication required by the LCP specification:
+// a 256 bits content key, and the url, media type, length and SHA-256 hash of the protected publication.
+func (h *APIHandler) certifyPublication(p *stor.Publication) error {
+	if !h.Config.Certification {
+		return nil
+	}
+	if len(p.EncryptionKey) != 32 {
+		return errors.New("certification: the encryption key must be a 256 bits key")
+	}
+	if u, err := url.Parse(p.Location); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("certification: the location must be an absolute http(s) url")
+	}
+	if p.ContentType == "" {
+		return errors.New("certification: the content type is required")
+	}
+	if p.Size == 0 {
+		return errors.New("certification: the size is required")
+	}
+	if hash, err := base64.StdEncoding.DecodeString(p.Checksum); err != nil || len(hash) != sha256.Size {
+		return errors.New("certification: the checksum must be the base64 encoded SHA-256 hash of the publication")
+	}
+	return nil
+}
+
+// validatePublication validates a publication, and certifies it in certification mode.
+func (h *APIHandler) validatePublication(p *stor.Publication) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	return h.certifyPublication(p)
+}
+
+// certifyLicenseInfo checks that the rights of a license are consistent.
+func (h *APIHandler) certifyLicenseInfo(l *stor.LicenseInfo) error {
+	if !h.Config.Certification {
+		return nil
+	}
+	if err := certifyRights(l.Start, l.End); err != nil {
+		return err
+	}
+	if l.End != nil && l.MaxEnd != nil && l.MaxEnd.Before(*l.End) {
+		return errors.New("certification: the max end date must not be before the end date")
+	}
+	return nil
+}
+
+// certifyLicenseRequest checks the parameters of a license generation required by the LCP specification:
+// a known encryption profile, and consistent rights.
+func (h *APIHandler) certifyLicenseRequest(l *LicenseRequest) error {
+	if !h.Config.Certification {
+		return nil
+	}
+	if l.Profile != lic.LCP_Basic_Profile && l.Profile != lic.LCP_10_Profile {
+		return fmt.Errorf("certification: unknown encryption profile %q", l.Profile)
+	}
+	return certifyRights(l.Start, l.End)
+}
+
+// certifyRights checks that a license ends after it starts
+func certifyRights(start, end *time.Time) error {
+	if start != nil && end != nil && !end.After(*start) {
+		return errors.New("certification: the end date must be after the start date")
+	}
+	return nil
+}
+
+// certifyLicense checks a generated license against the JSON schema of the LCP specification,
+// its required links and its signature, computed on the canonical form of the license.
+func (h *APIHandler) certifyLicense(license *lic.License) error {
+	if !h.Config.Certification {
+		return nil
+	}
+	data, err := json.Marshal(license)
+	if err != nil {
+		return err
+	}
+	if err = check.ValidateSchema(check.LicenseSchema, data); err != nil {
+		return fmt.Errorf("certification: %w", err)
+	}
+	for _, rel := range licenseRels {
+		found := false
+		for _, link := range license.Links {
+			found = found || link.Rel == rel
+		}
+		if !found {
+			return fmt.Errorf("certification: missing %s link", rel)
+		}
+	}
+	// the signature is checked on a copy, as the check removes it from the license
+	signed := *license
+	if err = signed.CheckSignature(); err != nil {
+		return fmt.Errorf("certification: invalid signature: %w", err)
+	}
+	return nil
+}
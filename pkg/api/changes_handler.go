@@ -0,0 +1,133 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+)
+
+// Types of change of a publication
+const (
+	CHANGE_CREATED = "created"
+	CHANGE_UPDATED = "updated"
+	CHANGE_DELETED = "deleted"
+)
+
+// ListPublicationChanges returns the publications created, updated or deleted since a time or a cursor,
+// in the order of the changes, so that a storefront can incrementally synchronize its catalog.
+// The cursor of the response is the since parameter of the next request; a publication changed
+// several times is returned once, with its last change.
+func (h *APIHandler) ListPublicationChanges(w http.ResponseWriter, r *http.Request) {
+
+	since, afterID, err := parseSince(r.URL.Query().Get("since"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	limit := DefaultPerPage
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > MaxPerPage {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("limit must be an integer between 1 and %d", MaxPerPage)))
+			return
+		}
+	}
+
+	// one more publication is requested to tell if others follow
+	publications, err := h.store(r).Publication().ListChanges(since, afterID, limit+1)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	response := &ChangesResponse{Changes: []PublicationChange{}, Cursor: r.URL.Query().Get("since")}
+	for i := range *publications {
+		if i == limit {
+			response.HasMore = true
+			break
+		}
+		publication := &(*publications)[i]
+		change := PublicationChange{UUID: publication.UUID, ChangedAt: publication.ChangedAt()}
+		switch {
+		case publication.DeletedAt.Valid:
+			change.Change = CHANGE_DELETED
+		case publication.CreatedAt.Equal(publication.UpdatedAt):
+			change.Change = CHANGE_CREATED
+			change.Publication = NewPublicationResponse(publication)
+		default:
+			change.Change = CHANGE_UPDATED
+			change.Publication = NewPublicationResponse(publication)
+		}
+		response.Changes = append(response.Changes, change)
+		response.Cursor = encodeCursor(change.ChangedAt, publication.ID)
+	}
+
+	if err := render.Render(w, r, response); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// parseSince parses the since parameter of a changes request: an RFC 3339 time, or a cursor
+// returned by a previous request. An empty parameter stands for the start of the catalog.
+func parseSince(since string) (time.Time, uint, error) {
+	if since == "" {
+		return time.Time{}, 0, nil
+	}
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		return t, 0, nil
+	}
+	errCursor := errors.New("since must be an RFC 3339 time or a cursor returned by a previous request")
+	data, err := base64.RawURLEncoding.DecodeString(since)
+	if err != nil {
+		return time.Time{}, 0, errCursor
+	}
+	parts := strings.SplitN(string(data), "/", 2)
+	if len(parts) != 2 {
+		return time.Time{}, 0, errCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, 0, errCursor
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, errCursor
+	}
+	return t, uint(id), nil
+}
+
+// encodeCursor returns an opaque cursor identifying a change by its time and the id of the publication changed,
+// as several publications may change at the same time.
+func encodeCursor(t time.Time, id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(t.Format(time.RFC3339Nano) + "/" + strconv.FormatUint(uint64(id), 10)))
+}
+
+// PublicationChange is the last change of a publication; deleted publications are only identified by their uuid.
+type PublicationChange struct {
+	Change      string               `json:"change"` // created, updated or deleted
+	UUID        string               `json:"uuid"`
+	ChangedAt   time.Time            `json:"changed_at"`
+	Publication *PublicationResponse `json:"publication,omitempty"`
+}
+
+// ChangesResponse is the response payload of a changes request.
+type ChangesResponse struct {
+	Changes []PublicationChange `json:"changes"`
+	Cursor  string              `json:"cursor"`   // since parameter of the next request
+	HasMore bool                `json:"has_more"` // true if more changes can be requested right away
+}
+
+// Render processes responses before marshalling.
+func (c *ChangesResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,401 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+)
+
+// ListCollections lists all collections present in the database.
+func (h *APIHandler) ListCollections(w http.ResponseWriter, r *http.Request) {
+	collections, err := h.store(r).Collection().ListAll()
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err := render.RenderList(w, r, NewCollectionListResponse(collections)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// ListPublicationCollections lists the collections a publication is a member of.
+func (h *APIHandler) ListPublicationCollections(w http.ResponseWriter, r *http.Request) {
+	publication, err := h.store(r).Publication().Get(chi.URLParam(r, "publicationID"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	collections, err := h.store(r).Collection().FindByPublication(publication.UUID)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err := render.RenderList(w, r, NewCollectionListResponse(collections)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// CreateCollection creates a series or a bundle, without members.
+func (h *APIHandler) CreateCollection(w http.ResponseWriter, r *http.Request) {
+
+	// get the payload
+	data := &CollectionRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	collection := data.Collection
+
+	// db create
+	err := h.store(r).Collection().Create(collection)
+	if err != nil {
+		render.Render(w, r, createError(w, r, err, collection.UUID))
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	if err := render.Render(w, r, NewCollectionResponse(collection)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// GetCollection returns a specific collection
+func (h *APIHandler) GetCollection(w http.ResponseWriter, r *http.Request) {
+
+	collection, err := h.getCollection(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err := render.Render(w, r, NewCollectionResponse(collection)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// UpdateCollection updates the title or the type of a collection.
+func (h *APIHandler) UpdateCollection(w http.ResponseWriter, r *http.Request) {
+
+	// get the payload
+	data := &CollectionRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	collection := data.Collection
+
+	// get the existing collection
+	currentCollection, err := h.getCollection(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if collection.UUID != currentCollection.UUID {
+		render.Render(w, r, ErrInvalidRequest(errors.New("the collection identifier cannot be modified")))
+		return
+	}
+
+	// set the gorm fields
+	collection.ID = currentCollection.ID
+	collection.CreatedAt = currentCollection.CreatedAt
+
+	// db update
+	err = h.store(r).Collection().Update(collection)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	if err := render.Render(w, r, NewCollectionResponse(collection)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// DeleteCollection removes a collection and its members from the database.
+// Its publications, and the licenses issued for a bundle, are not impacted.
+func (h *APIHandler) DeleteCollection(w http.ResponseWriter, r *http.Request) {
+
+	// get the existing collection
+	collection, err := h.getCollection(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	// db delete
+	err = h.store(r).Collection().Delete(collection)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if err := render.Render(w, r, NewCollectionResponse(collection)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// ListCollectionMembers lists the members of a collection, in position order.
+func (h *APIHandler) ListCollectionMembers(w http.ResponseWriter, r *http.Request) {
+
+	collection, err := h.getCollection(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	members, err := h.store(r).Collection().ListMembers(collection.UUID)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err := render.RenderList(w, r, NewCollectionMemberListResponse(members)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// SetCollectionMember adds a publication to a collection, or moves it to another position.
+func (h *APIHandler) SetCollectionMember(w http.ResponseWriter, r *http.Request) {
+
+	collection, err := h.getCollection(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	publication, err := h.store(r).Publication().Get(chi.URLParam(r, "publicationID"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	// get the payload; the collection and the publication are set from the url
+	data := &CollectionMemberRequest{CollectionMember: &stor.CollectionMember{PublicationID: publication.UUID}}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	member := data.CollectionMember
+	member.CollectionID = collection.UUID
+	member.PublicationID = publication.UUID
+
+	// db upsert
+	err = h.store(r).Collection().SetMember(member)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	if err := render.Render(w, r, NewCollectionMemberResponse(member)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// DeleteCollectionMember removes a publication from a collection.
+func (h *APIHandler) DeleteCollectionMember(w http.ResponseWriter, r *http.Request) {
+
+	collection, err := h.getCollection(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	member, err := h.store(r).Collection().GetMember(collection.UUID, chi.URLParam(r, "publicationID"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	// db delete
+	err = h.store(r).Collection().DeleteMember(member)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if err := render.Render(w, r, NewCollectionMemberResponse(member)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// CreateBundleLicenses issues the licenses of a bundle in a single operation: the payload is a license
+// without publication, and a license is created for each member of the bundle, with its own identifier.
+// The licenses are linked by the identifier of the bundle; the result of each member is given as for a batch.
+func (h *APIHandler) CreateBundleLicenses(w http.ResponseWriter, r *http.Request) {
+
+	collection, err := h.getCollection(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if collection.Type != stor.COLLECTION_BUNDLE {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("licenses are issued for bundles, not for a %s", collection.Type)))
+		return
+	}
+	members, err := h.store(r).Collection().ListMembers(collection.UUID)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if len(*members) == 0 {
+		render.Render(w, r, ErrInvalidRequest(errors.New("the bundle has no member")))
+		return
+	}
+
+	// get the payload, common to every license of the bundle
+	var payload json.RawMessage
+	if err := render.DecodeJSON(r.Body, &payload); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	response := &BatchResponse{Results: make([]BatchResult, len(*members))}
+	licenses := make([]*stor.LicenseInfo, 0, len(*members))
+	for i, member := range *members {
+		license := &stor.LicenseInfo{}
+		if err := json.Unmarshal(payload, license); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		license.UUID = uuid.New().String()
+		license.PublicationID = member.PublicationID
+		license.BundleID = collection.UUID
+		h.initLicense(license)
+		// an invalid payload is invalid for every member
+		if err := license.Validate(); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		if err := h.certifyLicenseInfo(license); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		licenses = append(licenses, license)
+		response.Results[i] = BatchResult{Index: i, UUID: license.UUID, Status: http.StatusCreated}
+	}
+
+	// db create
+	errs, err := h.store(r).License().CreateAll(licenses)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	for i, err := range errs {
+		if err != nil {
+			response.Results[i].Status, response.Results[i].Error = http.StatusUnprocessableEntity, err.Error()
+			response.Failed++
+		} else {
+			response.Created++
+		}
+	}
+
+	if err := render.Render(w, r, response); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// getCollection returns the collection identified in the url
+func (h *APIHandler) getCollection(r *http.Request) (*stor.Collection, error) {
+	collectionID := chi.URLParam(r, "collectionID")
+	if collectionID == "" {
+		return nil, errors.New("missing required collection identifier")
+	}
+	return h.store(r).Collection().Get(collectionID)
+}
+
+// --
+// Request and Response payloads for the REST api.
+// --
+
+// CollectionRequest is the request collection payload.
+type CollectionRequest struct {
+	*stor.Collection
+}
+
+// CollectionResponse is the response collection payload.
+type CollectionResponse struct {
+	*stor.Collection
+	ID        omit `json:"ID,omitempty"`
+	CreatedAt omit `json:"CreatedAt,omitempty"`
+	UpdatedAt omit `json:"UpdatedAt,omitempty"`
+	DeletedAt omit `json:"DeletedAt,omitempty"`
+}
+
+// CollectionMemberRequest is the request collection member payload.
+type CollectionMemberRequest struct {
+	*stor.CollectionMember
+}
+
+// CollectionMemberResponse is the response collection member payload.
+type CollectionMemberResponse struct {
+	*stor.CollectionMember
+}
+
+// NewCollectionListResponse creates a rendered list of collections
+func NewCollectionListResponse(collections *[]stor.Collection) []render.Renderer {
+	list := []render.Renderer{}
+	for i := 0; i < len(*collections); i++ {
+		list = append(list, NewCollectionResponse(&(*collections)[i]))
+	}
+	return list
+}
+
+// NewCollectionResponse creates a rendered collection.
+func NewCollectionResponse(collection *stor.Collection) *CollectionResponse {
+	return &CollectionResponse{Collection: collection}
+}
+
+// NewCollectionMemberListResponse creates a rendered list of collection members
+func NewCollectionMemberListResponse(members *[]stor.CollectionMember) []render.Renderer {
+	list := []render.Renderer{}
+	for i := 0; i < len(*members); i++ {
+		list = append(list, NewCollectionMemberResponse(&(*members)[i]))
+	}
+	return list
+}
+
+// NewCollectionMemberResponse creates a rendered collection member.
+func NewCollectionMemberResponse(member *stor.CollectionMember) *CollectionMemberResponse {
+	return &CollectionMemberResponse{CollectionMember: member}
+}
+
+// Bind post-processes requests after unmarshalling.
+func (c *CollectionRequest) Bind(r *http.Request) error {
+	if c.Collection == nil {
+		return errors.New("missing collection payload")
+	}
+	return c.Collection.Validate()
+}
+
+// Bind post-processes requests after unmarshalling.
+func (m *CollectionMemberRequest) Bind(r *http.Request) error {
+	if m.CollectionMember == nil {
+		return errors.New("missing collection member payload")
+	}
+	return m.CollectionMember.Validate()
+}
+
+// Render processes responses before marshalling.
+func (c *CollectionResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Render processes responses before marshalling.
+func (m *CollectionMemberResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,385 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// couponAlphabet excludes the characters easily confused when a code is typed, e.g. 0 and O
+const couponAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// ListCoupons lists all coupons present in the database.
+func (h *APIHandler) ListCoupons(w http.ResponseWriter, r *http.Request) {
+	coupons, err := h.store(r).Coupon().ListAll()
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err := render.RenderList(w, r, NewCouponListResponse(coupons)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// CreateCoupon creates a coupon with a code chosen by the client.
+func (h *APIHandler) CreateCoupon(w http.ResponseWriter, r *http.Request) {
+
+	// get the payload
+	data := &CouponRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	coupon := data.Coupon
+	if err := h.checkCoupon(r, coupon); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// db create
+	err := h.store(r).Coupon().Create(coupon)
+	if err != nil {
+		render.Render(w, r, createError(w, r, err, coupon.Code))
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	if err := render.Render(w, r, NewCouponResponse(coupon)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// GenerateCoupons creates a given number of coupons with random codes, e.g. to be printed on gift cards.
+// The payload is a coupon without code; the coupons are all created, or none is.
+func (h *APIHandler) GenerateCoupons(w http.ResponseWriter, r *http.Request) {
+
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count < 1 || count > MaxBatchSize {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("the count parameter must be from 1 to %d", MaxBatchSize)))
+		return
+	}
+
+	// get the payload, shared by the coupons
+	template := &stor.Coupon{}
+	if err := render.DecodeJSON(r.Body, template); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	template.Uses = 0
+	if err := h.checkCoupon(r, template); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	coupons := make([]*stor.Coupon, count)
+	for i := range coupons {
+		coupon := *template
+		if coupon.Code, err = newCouponCode(); err != nil {
+			render.Render(w, r, ErrRender(err))
+			return
+		}
+		coupons[i] = &coupon
+	}
+	// the coupons differ by their code only
+	if err = coupons[0].Validate(); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// db create
+	if err = h.store(r).Coupon().CreateAll(coupons); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	list := []render.Renderer{}
+	for _, coupon := range coupons {
+		list = append(list, NewCouponResponse(coupon))
+	}
+	render.Status(r, http.StatusCreated)
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// GetCoupon returns a specific coupon, with its number of uses
+func (h *APIHandler) GetCoupon(w http.ResponseWriter, r *http.Request) {
+
+	coupon, err := h.getCoupon(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err := render.Render(w, r, NewCouponResponse(coupon)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// UpdateCoupon updates the rights, the usage limit or the expiry of a coupon.
+// The licenses already issued are not impacted.
+func (h *APIHandler) UpdateCoupon(w http.ResponseWriter, r *http.Request) {
+
+	// get the payload
+	data := &CouponRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	coupon := data.Coupon
+
+	// get the existing coupon
+	currentCoupon, err := h.getCoupon(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if coupon.Code != currentCoupon.Code {
+		render.Render(w, r, ErrInvalidRequest(errors.New("the code of a coupon cannot be modified")))
+		return
+	}
+	if err := h.checkCoupon(r, coupon); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// set the gorm fields, and the uses maintained by the server
+	coupon.ID = currentCoupon.ID
+	coupon.CreatedAt = currentCoupon.CreatedAt
+	coupon.Uses = currentCoupon.Uses
+
+	// db update
+	err = h.store(r).Coupon().Update(coupon)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	if err := render.Render(w, r, NewCouponResponse(coupon)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// DeleteCoupon removes a coupon and its redemptions from the database; the issued licenses are not impacted.
+func (h *APIHandler) DeleteCoupon(w http.ResponseWriter, r *http.Request) {
+
+	// get the existing coupon
+	coupon, err := h.getCoupon(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	// db delete
+	err = h.store(r).Coupon().Delete(coupon)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if err := render.Render(w, r, NewCouponResponse(coupon)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// ListRedemptions lists the licenses issued for a coupon.
+func (h *APIHandler) ListRedemptions(w http.ResponseWriter, r *http.Request) {
+
+	coupon, err := h.getCoupon(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	redemptions, err := h.store(r).Coupon().ListRedemptions(coupon.Code)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	list := []render.Renderer{}
+	for i := range *redemptions {
+		list = append(list, &RedemptionResponse{Redemption: &(*redemptions)[i]})
+	}
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// Redeem exchanges a code for a new license of the publication of the coupon, with the rights of the coupon:
+// the payload gives the user and the passphrase like a license generation, without publication or rights.
+// A code past its expiry or its last use gets a 409 status code.
+func (h *APIHandler) Redeem(w http.ResponseWriter, r *http.Request) {
+
+	// get the payload
+	data := &RedeemRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	coupon, err := h.store(r).Coupon().Get(normalizeCode(data.Code))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	now := h.Clock()
+	if !coupon.Redeemable(now) {
+		render.Render(w, r, ErrConflict(stor.ErrNotRedeemable))
+		return
+	}
+
+	// the publication and the rights are set by the coupon
+	licRequest := &data.LicenseRequest
+	licRequest.PublicationID = coupon.PublicationID
+	licRequest.Template = coupon.Template
+	start := now.Truncate(time.Second)
+	licRequest.Start, licRequest.End = &start, nil
+	if coupon.Days > 0 {
+		end := start.AddDate(0, 0, coupon.Days)
+		licRequest.End = &end
+	}
+	licRequest.Copy, licRequest.Print = coupon.Copy, coupon.Print
+	if err := licRequest.Bind(r); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if err := h.certifyLicenseRequest(licRequest); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// the use of the coupon is recorded with the license
+	h.generateLicense(w, r, licRequest, func(licInfo *stor.LicenseInfo) error {
+		return h.store(r).Coupon().Redeem(coupon.Code, licInfo, now)
+	})
+}
+
+// checkCoupon normalizes the code of a coupon, and checks that its publication and its template exist
+func (h *APIHandler) checkCoupon(r *http.Request, coupon *stor.Coupon) error {
+	coupon.Code = normalizeCode(coupon.Code)
+	if _, err := h.store(r).Publication().Get(coupon.PublicationID); err != nil {
+		return fmt.Errorf("unknown publication %s", coupon.PublicationID)
+	}
+	_, err := h.Config.License.EncryptedFields(coupon.Template)
+	return err
+}
+
+// getCoupon returns the coupon identified in the url
+func (h *APIHandler) getCoupon(r *http.Request) (*stor.Coupon, error) {
+	code := chi.URLParam(r, "code")
+	if code == "" {
+		return nil, errors.New("missing required coupon code")
+	}
+	return h.store(r).Coupon().Get(normalizeCode(code))
+}
+
+// normalizeCode returns a code as stored, so that codes typed by users are not case sensitive
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// newCouponCode returns a random code of 16 characters, in groups of 4, e.g. "7KQM-X2PD-4HNR-W9TB"
+func newCouponCode() (string, error) {
+	var code strings.Builder
+	max := big.NewInt(int64(len(couponAlphabet)))
+	for i := 0; i < 16; i++ {
+		if i > 0 && i%4 == 0 {
+			code.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(couponAlphabet[n.Int64()])
+	}
+	return code.String(), nil
+}
+
+// --
+// Request and Response payloads for the REST api.
+// --
+
+// CouponRequest is the request coupon payload.
+type CouponRequest struct {
+	*stor.Coupon
+}
+
+// CouponResponse is the response coupon payload.
+type CouponResponse struct {
+	*stor.Coupon
+	ID        omit `json:"ID,omitempty"`
+	CreatedAt omit `json:"CreatedAt,omitempty"`
+	UpdatedAt omit `json:"UpdatedAt,omitempty"`
+	DeletedAt omit `json:"DeletedAt,omitempty"`
+}
+
+// RedemptionResponse is the response redemption payload.
+type RedemptionResponse struct {
+	*stor.Redemption
+}
+
+// RedeemRequest is the request payload of a redemption: a code, and the user and passphrase of the license.
+type RedeemRequest struct {
+	Code string `json:"code"`
+	LicenseRequest
+}
+
+// NewCouponListResponse creates a rendered list of coupons
+func NewCouponListResponse(coupons *[]stor.Coupon) []render.Renderer {
+	list := []render.Renderer{}
+	for i := 0; i < len(*coupons); i++ {
+		list = append(list, NewCouponResponse(&(*coupons)[i]))
+	}
+	return list
+}
+
+// NewCouponResponse creates a rendered coupon.
+func NewCouponResponse(coupon *stor.Coupon) *CouponResponse {
+	return &CouponResponse{Coupon: coupon}
+}
+
+// Bind post-processes requests after unmarshalling.
+func (c *CouponRequest) Bind(r *http.Request) error {
+	if c.Coupon == nil {
+		return errors.New("missing coupon payload")
+	}
+	// the uses are maintained by the server
+	c.Coupon.Uses = 0
+	return c.Coupon.Validate()
+}
+
+// Bind post-processes requests after unmarshalling.
+// The license request is validated once completed by the coupon.
+func (rr *RedeemRequest) Bind(r *http.Request) error {
+	if strings.TrimSpace(rr.Code) == "" {
+		return errors.New("missing code")
+	}
+	return nil
+}
+
+// Render processes responses before marshalling.
+func (c *CouponResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Render processes responses before marshalling.
+func (rr *RedemptionResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
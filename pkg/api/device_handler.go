@@ -0,0 +1,98 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/render"
+)
+
+// ListDevices returns the devices which registered with a license, in the order of registration,
+// with the last event of each device, e.g. to diagnose the complaints of users about device limits.
+// Devices registered before the device table existed are found in the events of the license.
+func (h *APIHandler) ListDevices(w http.ResponseWriter, r *http.Request) {
+
+	var licenseID string
+	if licenseID = getLicenseID(w, r); licenseID == "" {
+		return
+	}
+	st := h.store(r)
+	if _, err := st.License().Get(licenseID); err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	devices, err := st.Device().List(licenseID)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	events, err := st.Event().List(licenseID)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err := render.RenderList(w, r, NewDeviceListResponse(devices, events)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// --
+// Request and Response payloads for the REST api.
+// --
+
+// DeviceResponse is the response payload for the devices of a license.
+type DeviceResponse struct {
+	ID        string      `json:"id"`
+	Name      string      `json:"name"`
+	FirstSeen time.Time   `json:"first_seen"`
+	LastEvent *stor.Event `json:"last_event,omitempty"`
+}
+
+// NewDeviceListResponse creates a rendered list of the devices of a license, from the registered devices
+// and the events of the license, in the order of registration.
+func NewDeviceListResponse(devices *[]stor.Device, events *[]stor.Event) []render.Renderer {
+	list := []render.Renderer{}
+	for _, device := range deviceList(devices, events) {
+		list = append(list, device)
+	}
+	return list
+}
+
+// deviceList returns the devices of a license, from the registered devices and the events of the license,
+// in the order of registration.
+func deviceList(devices *[]stor.Device, events *[]stor.Event) []*DeviceResponse {
+	found := []*DeviceResponse{}
+	byID := make(map[string]*DeviceResponse)
+	for _, d := range *devices {
+		device := &DeviceResponse{ID: d.DeviceID, Name: d.Name, FirstSeen: d.Registered}
+		byID[d.DeviceID] = device
+		found = append(found, device)
+	}
+	for i := range *events {
+		event := &(*events)[i]
+		device, ok := byID[event.DeviceID]
+		if !ok {
+			// events of the server, e.g. a revocation, are not related to a device
+			if event.Type != stor.EVENT_REGISTER {
+				continue
+			}
+			device = &DeviceResponse{ID: event.DeviceID, Name: event.DeviceName, FirstSeen: event.Timestamp}
+			byID[event.DeviceID] = device
+			found = append(found, device)
+		}
+		device.LastEvent = event
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].FirstSeen.Before(found[j].FirstSeen) })
+	return found
+}
+
+// Render processes responses before marshalling.
+func (d *DeviceResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,235 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+
+	"github.com/edrlab/lcp-server/pkg/pack"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+)
+
+// default max size of an uploaded cleartext publication, in megabytes
+const defaultMaxUploadSize = 4095
+
+// EncryptPublication encrypts a cleartext publication (EPUB, PDF, audiobook or Divina), sent as the body of
+// the request with its media type, writes the protected file to the storage managed by the server, and records
+// the content key, location and checksum of the publication. An unknown publication is created, with the
+// optional title and author query parameters; a known publication keeps its content key, so that its licenses
+// remain valid.
+func (h *APIHandler) EncryptPublication(w http.ResponseWriter, r *http.Request) {
+
+	publicationID, ok := h.checkUpload(w, r)
+	if !ok {
+		return
+	}
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !pack.IsSupported(contentType) {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("unsupported publication type %q", r.Header.Get("Content-Type"))))
+		return
+	}
+
+	source, err := h.spoolSource(r.Body)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	defer source.remove()
+
+	h.protectPublication(w, r, publicationID, contentType, source, r.URL.Query().Get("title"), r.URL.Query().Get("author"))
+}
+
+// UploadPublication encrypts a cleartext publication sent as a multipart/form-data request, as EncryptPublication.
+// The form holds the "file" to encrypt, and the optional "title", "author" and "content_type" fields. The media
+// type of the file is the content_type field, else the type of the file part, else the type of the extension of
+// its name. The file is streamed to disk, never held in memory.
+func (h *APIHandler) UploadPublication(w http.ResponseWriter, r *http.Request) {
+
+	publicationID, ok := h.checkUpload(w, r)
+	if !ok {
+		return
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	var source *spooledSource
+	var fileType string
+	fields := map[string]string{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		switch name := part.FormName(); name {
+		case "file":
+			if source != nil {
+				render.Render(w, r, ErrInvalidRequest(errors.New("a single file must be uploaded")))
+				return
+			}
+			if source, err = h.spoolSource(part); err != nil {
+				render.Render(w, r, ErrInvalidRequest(err))
+				return
+			}
+			defer source.remove()
+			fileType, _, _ = mime.ParseMediaType(part.Header.Get("Content-Type"))
+			if fileType == "" || fileType == "application/octet-stream" {
+				fileType = pack.ContentTypeByName(part.FileName())
+			}
+		case "title", "author", "content_type":
+			value, err := io.ReadAll(io.LimitReader(part, 256))
+			if err != nil || len(value) > 255 {
+				render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid %s field", name)))
+				return
+			}
+			fields[name] = string(value)
+		}
+		part.Close()
+	}
+	if source == nil {
+		render.Render(w, r, ErrInvalidRequest(errors.New("missing required file")))
+		return
+	}
+	if fields["content_type"] != "" {
+		fileType = fields["content_type"]
+	}
+	if !pack.IsSupported(fileType) {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("unsupported publication type %q", fileType)))
+		return
+	}
+
+	h.protectPublication(w, r, publicationID, fileType, source, fields["title"], fields["author"])
+}
+
+// checkUpload checks that the server manages the storage of publications, and returns the publication identifier
+func (h *APIHandler) checkUpload(w http.ResponseWriter, r *http.Request) (string, bool) {
+
+	if h.Tiering == nil {
+		render.Render(w, r, ErrInvalidRequest(errors.New("no storage is managed by the server")))
+		return "", false
+	}
+	publicationID := chi.URLParam(r, "publicationID")
+	if _, err := uuid.Parse(publicationID); err != nil {
+		render.Render(w, r, ErrInvalidRequest(errors.New("the publication identifier must be a uuid")))
+		return "", false
+	}
+	return publicationID, true
+}
+
+// spooledSource is a cleartext publication spooled to a temp file, as archives are read at random
+type spooledSource struct {
+	*os.File
+	size int64
+}
+
+// remove deletes the temp file
+func (s *spooledSource) remove() {
+	s.Close()
+	os.Remove(s.Name())
+}
+
+// spoolSource copies a cleartext publication to a temp file, up to the max upload size
+func (h *APIHandler) spoolSource(r io.Reader) (*spooledSource, error) {
+
+	maxSize := int64(h.Config.Storage.MaxUploadSize)
+	if maxSize == 0 {
+		maxSize = defaultMaxUploadSize
+	}
+	maxSize <<= 20
+
+	f, err := os.CreateTemp("", "lcp-source-*")
+	if err != nil {
+		return nil, err
+	}
+	source := &spooledSource{File: f}
+	// one more byte tells that the source is too large
+	if source.size, err = io.Copy(f, io.LimitReader(r, maxSize+1)); err == nil && source.size > maxSize {
+		err = fmt.Errorf("the publication exceeds the max upload size of %d MB", maxSize>>20)
+	}
+	if err != nil {
+		source.remove()
+		return nil, err
+	}
+	return source, nil
+}
+
+// protectPublication encrypts and stores a cleartext publication, creates or updates the publication,
+// and renders it
+func (h *APIHandler) protectPublication(w http.ResponseWriter, r *http.Request, publicationID, contentType string, source *spooledSource, title, author string) {
+
+	// get the publication, or a new one, stored in the data residency region of its provider
+	st := h.store(r)
+	publication, err := st.Publication().Get(publicationID)
+	created := err != nil
+	provider := h.provider(r)
+	if created {
+		publication = &stor.Publication{UUID: publicationID}
+	} else {
+		provider = publication.Provider
+	}
+	// an archived publication is restored before its file is replaced in the hot storage
+	tiering := h.tiering(provider)
+	if !created {
+		if err = tiering.Restore(r.Context(), publication); err != nil {
+			render.Render(w, r, ErrUnavailable(err))
+			return
+		}
+	}
+	if title != "" {
+		publication.Title = title
+	}
+	if author != "" {
+		publication.Author = author
+	}
+
+	// encrypt and store
+	previousKey := publication.StorageKey
+	if _, err = pack.Protect(r.Context(), tiering.Hot, contentType, source, source.size, publication); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err = h.validatePublication(publication); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// db create or update
+	if created {
+		err = st.Publication().Create(publication)
+	} else {
+		err = st.Publication().Update(publication)
+	}
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	// the file of another media type is replaced
+	if previousKey != "" && previousKey != publication.StorageKey {
+		if err := tiering.Hot.Delete(r.Context(), previousKey); err != nil {
+			h.logger(r).Errorf("Failed to delete the replaced file %s: %v", previousKey, err)
+		}
+	}
+
+	if created {
+		render.Status(r, http.StatusCreated)
+	}
+	if err := render.Render(w, r, NewPublicationResponse(publication)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
@@ -5,8 +5,14 @@
 package api
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/storage"
 	"github.com/go-chi/render"
 )
 
@@ -31,6 +37,7 @@ Sample of problem details error message:
 type ErrResponse struct {
 	Err            error `json:"-"` // low-level runtime error
 	HTTPStatusCode int   `json:"-"` // http response status code
+	RetryAfter     int   `json:"-"` // delay after which the request should be retried, in seconds, if known
 
 	StatusText string `json:"status"`          // user-level status message
 	AppCode    int64  `json:"code,omitempty"`  // application-specific error code
@@ -39,6 +46,12 @@ type ErrResponse struct {
 
 func (e *ErrResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	render.Status(r, e.HTTPStatusCode)
+	if e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(e.RetryAfter))
+	}
+	if e.Err != nil {
+		setLogError(r.Context(), e.Err)
+	}
 	return nil
 }
 
@@ -60,4 +73,63 @@ func ErrRender(err error) render.Renderer {
 	}
 }
 
+// ErrUnavailable is returned when the server is temporarily saturated, or a publication is being restored from the
+// cold storage; the client should retry later.
+func ErrUnavailable(err error) render.Renderer {
+	e := &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 503,
+		StatusText:     "Service unavailable",
+		ErrorText:      err.Error(),
+	}
+	if errors.Is(err, storage.ErrRehydrating) {
+		e.RetryAfter = int(storage.RehydrationRetry.Seconds())
+	}
+	return e
+}
+
+// ErrTooManyRequests is returned when a client exceeds its rate limit; the client should retry later.
+func ErrTooManyRequests(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 429,
+		StatusText:     "Too many requests",
+		ErrorText:      err.Error(),
+	}
+}
+
+// ErrConflict is returned when an entity is created with an identifier already in use.
+func ErrConflict(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 409,
+		StatusText:     "Conflict",
+		ErrorText:      err.Error(),
+	}
+}
+
+// ErrForbidden is returned when an operation is not allowed on an entity, e.g. a renew beyond the potential end of a license.
+func ErrForbidden(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 403,
+		StatusText:     "Forbidden",
+		ErrorText:      err.Error(),
+	}
+}
+
+// createError returns the error of a failed creation: a conflict if the identifier is already in use,
+// located by the url of the existing entity when its identifier is given.
+func createError(w http.ResponseWriter, r *http.Request, err error, id string) render.Renderer {
+	if !errors.Is(err, stor.ErrDuplicate) {
+		return ErrRender(err)
+	}
+	if id != "" {
+		w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+url.PathEscape(id))
+	}
+	return ErrConflict(err)
+}
+
 var ErrNotFound = &ErrResponse{HTTPStatusCode: 404, StatusText: "Resource not found."}
+
+var ErrUnauthorized = &ErrResponse{HTTPStatusCode: 401, StatusText: "Authentication required."}
@@ -0,0 +1,52 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/render"
+)
+
+// VerifyEvents verifies the hash chain of the events, i.e. the history of the licenses, including their
+// revocations, and reports the first event which was changed or deleted. The events of each data residency
+// region are chained in the database of the region, and verified as well.
+func (h *APIHandler) VerifyEvents(w http.ResponseWriter, r *http.Request) {
+	st := h.store(r)
+	report, err := st.Event().Verify()
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	resp := &ChainReportResponse{ChainReport: report}
+	for _, region := range stor.Regions(st) {
+		regional, err := region.Event().Verify()
+		if err != nil {
+			render.Render(w, r, ErrRender(err))
+			return
+		}
+		resp.Regions = append(resp.Regions, regional)
+	}
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// --
+// Request and Response payloads for the REST api.
+// --
+
+// ChainReportResponse is the response payload of the verification of the events.
+type ChainReportResponse struct {
+	*stor.ChainReport
+	Regions []*stor.ChainReport `json:"regions,omitempty"` // chains of the data residency regions
+}
+
+// Render processes responses before marshalling.
+func (c *ChainReportResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,263 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+)
+
+// default claim period of gifts, in days
+const defaultGiftDays = 30
+
+// ListGifts lists the gifts present in the database, or the gifts bought by the purchaser given as parameter.
+func (h *APIHandler) ListGifts(w http.ResponseWriter, r *http.Request) {
+	var gifts *[]stor.Gift
+	var err error
+	if purchaser := r.URL.Query().Get("purchaser"); purchaser != "" {
+		gifts, err = h.store(r).Gift().FindByPurchaser(purchaser)
+	} else {
+		gifts, err = h.store(r).Gift().ListAll()
+	}
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	now := h.Clock()
+	list := []render.Renderer{}
+	for i := range *gifts {
+		list = append(list, NewGiftResponse(&(*gifts)[i], now))
+	}
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// CreateGift creates a pending gift, bought by a purchaser for a recipient, and returns it with its claim token.
+// The token is returned once, only a hash of it being stored: the purchaser sends it to the recipient, who claims
+// the gift by ClaimGift before its expiry, 30 days after its creation by default.
+func (h *APIHandler) CreateGift(w http.ResponseWriter, r *http.Request) {
+
+	// get the payload
+	data := &GiftRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	gift := data.Gift
+	if _, err := h.store(r).Publication().Get(gift.PublicationID); err != nil {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("unknown publication %s", gift.PublicationID)))
+		return
+	}
+	if _, err := h.Config.License.EncryptedFields(gift.Template); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	now := h.Clock().Truncate(time.Second)
+	if gift.Expires == nil {
+		expires := now.AddDate(0, 0, defaultGiftDays)
+		gift.Expires = &expires
+	} else if !gift.Expires.After(now) {
+		render.Render(w, r, ErrInvalidRequest(errors.New("the expiry of a gift must be in the future")))
+		return
+	}
+
+	// the fields maintained by the server
+	token, err := newClaimToken()
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	gift.UUID = uuid.New().String()
+	gift.TokenHash = claimTokenHash(token)
+	gift.Status = stor.GIFT_PENDING
+	gift.LicenseID, gift.Claimed = "", nil
+
+	// db create
+	if err = h.store(r).Gift().Create(gift); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	response := NewGiftResponse(gift, now)
+	response.Token = token
+	render.Status(r, http.StatusCreated)
+	if err := render.Render(w, r, response); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// GetGift returns a gift, with its status and the license issued to its recipient once claimed.
+func (h *APIHandler) GetGift(w http.ResponseWriter, r *http.Request) {
+
+	gift, err := h.store(r).Gift().Get(chi.URLParam(r, "giftID"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err := render.Render(w, r, NewGiftResponse(gift, h.Clock())); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// CancelGift cancels a gift which is not claimed, e.g. when the purchase is refunded.
+// A claimed or cancelled gift gets a 409 status code.
+func (h *APIHandler) CancelGift(w http.ResponseWriter, r *http.Request) {
+
+	giftID := chi.URLParam(r, "giftID")
+	if _, err := h.store(r).Gift().Get(giftID); err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	err := h.store(r).Gift().Cancel(giftID)
+	if errors.Is(err, stor.ErrNotClaimable) {
+		render.Render(w, r, ErrConflict(err))
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	gift, err := h.store(r).Gift().Get(giftID)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err := render.Render(w, r, NewGiftResponse(gift, h.Clock())); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// ClaimGift exchanges a claim token for the license of the gift, bound to its recipient: the payload gives the
+// token, and the user and the passphrase like a license generation, without publication or rights.
+// A gift which is expired, cancelled or already claimed gets a 409 status code.
+func (h *APIHandler) ClaimGift(w http.ResponseWriter, r *http.Request) {
+
+	// get the payload
+	data := &ClaimRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	gift, err := h.store(r).Gift().GetByToken(claimTokenHash(strings.TrimSpace(data.Token)))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	now := h.Clock()
+	if gift.StatusAt(now) != stor.GIFT_PENDING {
+		render.Render(w, r, ErrConflict(stor.ErrNotClaimable))
+		return
+	}
+
+	// the publication and the rights are set by the gift
+	licRequest := &data.LicenseRequest
+	licRequest.PublicationID = gift.PublicationID
+	licRequest.Template = gift.Template
+	start := now.Truncate(time.Second)
+	licRequest.Start, licRequest.End = &start, nil
+	if gift.Days > 0 {
+		end := start.AddDate(0, 0, gift.Days)
+		licRequest.End = &end
+	}
+	licRequest.Copy, licRequest.Print = gift.Copy, gift.Print
+	if err := licRequest.Bind(r); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if err := h.certifyLicenseRequest(licRequest); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// the gift is bound to the license when it is created
+	h.generateLicense(w, r, licRequest, func(licInfo *stor.LicenseInfo) error {
+		return h.store(r).Gift().Claim(gift.UUID, licInfo, now)
+	})
+}
+
+// newClaimToken returns a random claim token of 43 characters
+func newClaimToken() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// claimTokenHash returns the hash of a claim token, as stored
+func claimTokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// --
+// Request and Response payloads for the REST api.
+// --
+
+// GiftRequest is the request gift payload.
+type GiftRequest struct {
+	*stor.Gift
+}
+
+// GiftResponse is the response gift payload; the claim token is only returned on creation.
+type GiftResponse struct {
+	*stor.Gift
+	Token     string `json:"token,omitempty"`
+	ID        omit   `json:"ID,omitempty"`
+	CreatedAt omit   `json:"CreatedAt,omitempty"`
+	UpdatedAt omit   `json:"UpdatedAt,omitempty"`
+	DeletedAt omit   `json:"DeletedAt,omitempty"`
+}
+
+// ClaimRequest is the request payload of a claim: a claim token, and the user and passphrase of the license.
+type ClaimRequest struct {
+	Token string `json:"token"`
+	LicenseRequest
+}
+
+// NewGiftResponse creates a rendered gift, with its status at a given time.
+func NewGiftResponse(gift *stor.Gift, t time.Time) *GiftResponse {
+	gift.Status = gift.StatusAt(t)
+	return &GiftResponse{Gift: gift}
+}
+
+// Bind post-processes requests after unmarshalling.
+func (g *GiftRequest) Bind(r *http.Request) error {
+	if g.Gift == nil {
+		return errors.New("missing gift payload")
+	}
+	return g.Gift.Validate()
+}
+
+// Bind post-processes requests after unmarshalling.
+// The license request is validated once completed by the gift.
+func (c *ClaimRequest) Bind(r *http.Request) error {
+	if strings.TrimSpace(c.Token) == "" {
+		return errors.New("missing token")
+	}
+	return nil
+}
+
+// Render processes responses before marshalling.
+func (g *GiftResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/storage"
+	"github.com/go-chi/render"
+)
+
+// max time of the probe of a dependency
+const healthTimeout = 2 * time.Second
+
+// status of a dependency, and of the server
+const (
+	HEALTH_OK   = "ok"
+	HEALTH_FAIL = "fail"
+)
+
+// Healthz probes the dependencies of the server: the database, with a ping and a cheap query, and the storages of
+// the publications if they are managed by the server. The probes run concurrently, each within a short timeout.
+// The status code is 200 if every dependency is available, 503 otherwise, so that a load balancer takes the
+// server out of rotation; the causes of the failures are logged, not returned to the unauthenticated caller.
+func (h *APIHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+
+	probes := map[string]func(ctx context.Context) error{
+		"database": func(ctx context.Context) error {
+			return h.Store.WithContext(ctx).Ping()
+		},
+	}
+	if h.Tiering != nil {
+		// the storages of the data residency regions are probed with the hot storage
+		hot := []storage.Storage{h.Tiering.Hot}
+		probed := map[*storage.Tiering]bool{h.Tiering: true}
+		for _, tiering := range h.RegionTiering {
+			if !probed[tiering] {
+				probed[tiering] = true
+				hot = append(hot, tiering.Hot)
+			}
+		}
+		probes["storage"] = func(ctx context.Context) error {
+			for _, st := range hot {
+				if err := storage.Ping(ctx, st); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if cold := h.Tiering.Cold; cold != nil {
+			probes["cold_storage"] = func(ctx context.Context) error {
+				return storage.Ping(ctx, cold)
+			}
+		}
+	}
+	h.probe(w, r, probes)
+}
+
+// Livez tells that the process is alive, for the liveness probe of Kubernetes: it does not depend on the database
+// or the storages, whose failures would not be fixed by a restart of the server.
+func (h *APIHandler) Livez(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := render.Render(w, r, &HealthResponse{Status: HEALTH_OK}); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// Readyz tells whether the server can take traffic, for the readiness probe of Kubernetes: the database is reachable,
+// its schema migrations are applied, the certificate signing the licenses is loaded, and the warmup, if started, is
+// completed. The status code is 503 otherwise, so that no request is routed to the server, e.g. while the migrations
+// of an upgrade are pending.
+func (h *APIHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+
+	probes := map[string]func(ctx context.Context) error{
+		"database": func(ctx context.Context) error {
+			return h.Store.WithContext(ctx).Ping()
+		},
+		"migrations": func(ctx context.Context) error {
+			return h.Store.WithContext(ctx).Migrated()
+		},
+		"certificate": func(ctx context.Context) error {
+			signer, err := h.signer()
+			if err == nil && signer == nil {
+				err = errors.New("no certificate loaded")
+			}
+			return err
+		},
+	}
+	if h.warmup != nil {
+		probes["warmup"] = func(ctx context.Context) error {
+			return h.warmed()
+		}
+	}
+	h.probe(w, r, probes)
+}
+
+// probe runs the probes concurrently, each within a short timeout, and renders their status
+func (h *APIHandler) probe(w http.ResponseWriter, r *http.Request, probes map[string]func(ctx context.Context) error) {
+
+	resp := &HealthResponse{Status: HEALTH_OK, Checks: make(map[string]*HealthCheck, len(probes))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, probe := range probes {
+		wg.Add(1)
+		go func(name string, probe func(ctx context.Context) error) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+			defer cancel()
+			start := time.Now()
+			err := probe(ctx)
+			check := &HealthCheck{Status: HEALTH_OK, Latency: time.Since(start).Milliseconds()}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				h.logger(r).Errorf("Health check of the %s failed: %v", name, err)
+				check.Status = HEALTH_FAIL
+				resp.Status = HEALTH_FAIL
+			}
+			resp.Checks[name] = check
+		}(name, probe)
+	}
+	wg.Wait()
+
+	w.Header().Set("Cache-Control", "no-store")
+	if resp.Status != HEALTH_OK {
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// --
+// Request and Response payloads for the REST api.
+// --
+
+// HealthResponse is the response payload of a health check.
+type HealthResponse struct {
+	Status string                  `json:"status"`
+	Checks map[string]*HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthCheck is the status of a dependency, with the duration of its probe in milliseconds.
+type HealthCheck struct {
+	Status  string `json:"status"`
+	Latency int64  `json:"latency_ms"`
+}
+
+// Render processes responses before marshalling.
+func (hr *HealthResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/render"
+	"golang.org/x/text/language"
+)
+
+//go:embed data/hint.html
+var defaultHintPage string
+
+// parsed html page templates, by path, or by name for the built-in templates
+var pageTemplates sync.Map
+
+// languages of the html pages, the first one being the default
+var pageLanguages = []language.Tag{language.English, language.French, language.German, language.Spanish, language.Italian}
+
+var pageMatcher = language.NewMatcher(pageLanguages)
+
+// localized texts of the hint page, by language
+var hintTexts = map[string]map[string]string{
+	"en": {
+		"title":   "Your passphrase",
+		"intro":   "This book is protected by LCP. Your reading application asks for the passphrase chosen when you got it.",
+		"hint":    "Here is the hint associated with your passphrase:",
+		"no_hint": "Your passphrase is usually the password of your account on the website where you got the book.",
+		"contact": "If you cannot remember it, please contact your bookseller or library.",
+	},
+	"fr": {
+		"title":   "Votre phrase de passe",
+		"intro":   "Ce livre est protégé par LCP. Votre application de lecture demande la phrase de passe choisie lors de son acquisition.",
+		"hint":    "Voici l'indice associé à votre phrase de passe :",
+		"no_hint": "Votre phrase de passe est généralement le mot de passe de votre compte sur le site où vous avez obtenu le livre.",
+		"contact": "Si vous ne vous en souvenez pas, contactez votre libraire ou votre bibliothèque.",
+	},
+	"de": {
+		"title":   "Ihre Passphrase",
+		"intro":   "Dieses Buch ist mit LCP geschützt. Ihre Lese-App fragt nach der Passphrase, die beim Erwerb gewählt wurde.",
+		"hint":    "Hier ist der Hinweis zu Ihrer Passphrase:",
+		"no_hint": "Ihre Passphrase ist in der Regel das Passwort Ihres Kontos auf der Website, auf der Sie das Buch erhalten haben.",
+		"contact": "Wenn Sie sich nicht daran erinnern, wenden Sie sich bitte an Ihre Buchhandlung oder Bibliothek.",
+	},
+	"es": {
+		"title":   "Su frase de contraseña",
+		"intro":   "Este libro está protegido por LCP. Su aplicación de lectura solicita la frase de contraseña elegida al obtenerlo.",
+		"hint":    "Esta es la pista asociada a su frase de contraseña:",
+		"no_hint": "Su frase de contraseña suele ser la contraseña de su cuenta en el sitio web donde obtuvo el libro.",
+		"contact": "Si no la recuerda, póngase en contacto con su librería o biblioteca.",
+	},
+	"it": {
+		"title":   "La sua passphrase",
+		"intro":   "Questo libro è protetto da LCP. La sua applicazione di lettura chiede la passphrase scelta al momento dell'acquisizione.",
+		"hint":    "Ecco l'indizio associato alla sua passphrase:",
+		"no_hint": "La sua passphrase è di solito la password del suo account sul sito dove ha ottenuto il libro.",
+		"contact": "Se non la ricorda, contatti la sua libreria o biblioteca.",
+	},
+}
+
+// HintPageData is the data rendered by a hint page template.
+type HintPageData struct {
+	Lang      string            // language of the page, e.g. "fr"
+	Text      map[string]string // localized texts: title, intro, hint, no_hint and contact
+	LicenseID string
+	Provider  string
+	Hint      string // hint of the passphrase, if generated by the server
+	Title     string // title of the publication
+	Author    string
+}
+
+// HintPage serves the html page to which the hint link of a license points, for the providers
+// which have no website of their own. The page is rendered from the template of the provider of the
+// license, localized from the lang query parameter or the Accept-Language header.
+func (h *APIHandler) HintPage(w http.ResponseWriter, r *http.Request) {
+
+	var licenseID string
+	if licenseID = getLicenseID(w, r); licenseID == "" {
+		return
+	}
+	st := h.store(r)
+	license, err := st.License().Get(licenseID)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	tpl, err := pageTemplate("hint", defaultHintPage, h.Config.HintPage.TemplatePath(license.Provider))
+	if err != nil {
+		h.logger(r).Errorf("Failed to parse the hint page template: %v", err)
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	lang := pageLanguage(r)
+	data := HintPageData{
+		Lang:      lang,
+		Text:      hintTexts[lang],
+		LicenseID: license.UUID,
+		Provider:  license.Provider,
+		Hint:      license.TextHint,
+	}
+	if pub, err := st.Publication().Get(license.PublicationID); err == nil {
+		data.Title = pub.Title
+		data.Author = pub.Author
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", lang)
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Header().Set("Vary", "Accept-Language")
+	if err := tpl.Execute(w, data); err != nil {
+		h.logger(r).Errorf("Failed to render the hint page of license %s: %v", licenseID, err)
+	}
+}
+
+// pageLanguage returns the language of an html page requested by a client.
+func pageLanguage(r *http.Request) string {
+	accept := r.Header.Get("Accept-Language")
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		accept = lang
+	}
+	tags, _, _ := language.ParseAcceptLanguage(accept)
+	_, index, _ := pageMatcher.Match(tags...)
+	base, _ := pageLanguages[index].Base()
+	return base.String()
+}
+
+// pageTemplate returns a parsed html page template, the named built-in template if the path is empty.
+// Templates are parsed once.
+func pageTemplate(name, builtin, path string) (*template.Template, error) {
+	key := path
+	if path == "" {
+		key = "builtin:" + name
+	}
+	if tpl, ok := pageTemplates.Load(key); ok {
+		return tpl.(*template.Template), nil
+	}
+	var tpl *template.Template
+	var err error
+	if path == "" {
+		tpl, err = template.New(name).Parse(builtin)
+	} else {
+		tpl, err = template.ParseFiles(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pageTemplates.Store(key, tpl)
+	return tpl, nil
+}
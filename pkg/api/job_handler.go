@@ -0,0 +1,177 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/job"
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// MaxJobSize is the max number of items of a job.
+const MaxJobSize = 10000
+
+// List of job types
+const (
+	JOB_REVOKE   = "revoke"   // revokes (or cancels) licenses, given by their identifiers
+	JOB_LICENSES = "licenses" // creates licenses, as a batch of licenses but one by one
+)
+
+// CreateJob starts a bulk operation in the background, and returns the job, whose progress is then given by GetJob.
+func (h *APIHandler) CreateJob(w http.ResponseWriter, r *http.Request) {
+
+	// get the payload
+	data := &JobRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	var process job.Processor
+	switch data.Type {
+	case JOB_REVOKE:
+		if data.Actor == "" {
+			data.Actor, _, _ = r.BasicAuth()
+		}
+		process = h.revokeItem(h.requestConfig(r), lic.Revocation{Reason: data.Reason, Actor: data.Actor})
+	case JOB_LICENSES:
+		process = h.createLicenseItem
+	}
+
+	started, err := h.Jobs.Start(data.Type, data.Items, process)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	render.Status(r, http.StatusAccepted)
+	if err := render.Render(w, r, NewJobResponse(started)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// GetJob returns a job, with its progress and the results of the processed items.
+func (h *APIHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+
+	job, err := h.store(r).Job().Get(chi.URLParam(r, "jobID"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err := render.Render(w, r, NewJobResponse(job)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// CancelJob stops a running job after the item in process, and returns the cancelled job with its partial results.
+// A finished job cannot be cancelled.
+func (h *APIHandler) CancelJob(w http.ResponseWriter, r *http.Request) {
+
+	jobID := chi.URLParam(r, "jobID")
+	if _, err := h.store(r).Job().Get(jobID); err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	cancelled, err := h.Jobs.Cancel(jobID)
+	if errors.Is(err, job.ErrNotRunning) {
+		render.Render(w, r, ErrConflict(err))
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err := render.Render(w, r, NewJobResponse(cancelled)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// revokeItem returns the processor revoking a license, given by its identifier
+func (h *APIHandler) revokeItem(cf *conf.Config, revocation lic.Revocation) job.Processor {
+	return func(ctx context.Context, st stor.Store, item json.RawMessage) stor.JobResult {
+		var licenseID string
+		if err := json.Unmarshal(item, &licenseID); err != nil || licenseID == "" {
+			return stor.JobResult{Status: http.StatusBadRequest, Error: "missing required license identifier"}
+		}
+		lh := lic.NewLicenseHandler(cf, st)
+		lh.Clock = h.Clock
+		_, err := lh.Revoke(licenseID, revocation)
+		result := stor.JobResult{UUID: licenseID, Status: http.StatusOK}
+		if errors.Is(err, lic.ErrLicenseNotFound) {
+			result.Status, result.Error = http.StatusNotFound, err.Error()
+		} else if err != nil {
+			result.Status, result.Error = http.StatusBadRequest, err.Error()
+		}
+		return result
+	}
+}
+
+// createLicenseItem creates a license
+func (h *APIHandler) createLicenseItem(ctx context.Context, st stor.Store, item json.RawMessage) stor.JobResult {
+	license, err := h.decodeBatchLicense(item)
+	result := stor.JobResult{UUID: license.UUID, Status: http.StatusCreated}
+	if err != nil {
+		result.Status, result.Error = http.StatusBadRequest, err.Error()
+		return result
+	}
+	if err = st.License().Create(license); err != nil {
+		result.Status, result.Error = http.StatusUnprocessableEntity, err.Error()
+		if errors.Is(err, stor.ErrDuplicate) {
+			result.Status = http.StatusConflict
+		}
+	}
+	return result
+}
+
+// --
+// Request and Response payloads for the REST api.
+// --
+
+// JobRequest is the request payload of a job.
+type JobRequest struct {
+	Type   string            `json:"type"`
+	Items  []json.RawMessage `json:"items"`
+	Reason string            `json:"reason"` // reason of a revocation
+	Actor  string            `json:"actor"`  // actor of a revocation
+}
+
+// JobResponse is the response payload of a job.
+type JobResponse struct {
+	*stor.Job
+	Progress int `json:"progress"` // percentage of processed items
+}
+
+// NewJobResponse creates a rendered job.
+func NewJobResponse(job *stor.Job) *JobResponse {
+	return &JobResponse{Job: job, Progress: job.Progress()}
+}
+
+// Bind post-processes requests after unmarshalling.
+func (j *JobRequest) Bind(r *http.Request) error {
+	if j.Type != JOB_REVOKE && j.Type != JOB_LICENSES {
+		return fmt.Errorf("unknown job type %q, expected %s or %s", j.Type, JOB_REVOKE, JOB_LICENSES)
+	}
+	if len(j.Items) == 0 || len(j.Items) > MaxJobSize {
+		return fmt.Errorf("a job must contain from 1 to %d items", MaxJobSize)
+	}
+	if len(j.Reason) > 255 || len(j.Actor) > 255 {
+		return errors.New("reason and actor must be shorter")
+	}
+	return nil
+}
+
+// Render processes responses before marshalling.
+func (j *JobResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+// freshLicenseHash returns a hash of every parameter of a fresh license.
+// The update time of the license info and publication is part of it, so that
+// a change of rights, status or publication never hits a stale license.
+// The public base url seen by the client and the policy links of the provider are part of it,
+// as they are used in license links.
+// The fingerprint of the signer certificate is part of it, so that a renewed certificate
+// never serves a license signed by the previous one.
+func (h *APIHandler) freshLicenseHash(r *http.Request, pubInfo *stor.Publication, licInfo *stor.LicenseInfo, policy *stor.Provider, userInfo *lic.UserInfo, encryption *lic.Encryption, passhash string) string {
+
+	params := struct {
+		LicenseID  string
+		Updated    time.Time
+		Status     string
+		PubUpdated time.Time
+		User       lic.UserInfo
+		Profile    string
+		TextHint   string
+		PassHash   string
+		HintLink   string
+		BaseURL    string
+		Policy     *stor.Provider
+		Cert       string
+	}{
+		LicenseID:  licInfo.UUID,
+		Updated:    licInfo.UpdatedAt,
+		Status:     licInfo.Status,
+		PubUpdated: pubInfo.UpdatedAt,
+		User:       *userInfo,
+		Profile:    encryption.Profile,
+		TextHint:   encryption.UserKey.TextHint,
+		PassHash:   passhash,
+		HintLink:   h.Config.License.HintLinkTemplate(licInfo.Provider),
+		BaseURL:    lic.NewLinkBuilder(h.requestConfig(r), licInfo.Provider).BaseURL,
+		Policy:     policy,
+		Cert:       h.certFingerprint(),
+	}
+	data, _ := json.Marshal(params)
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// certFingerprint returns the hex SHA-256 fingerprint of the signer certificate, or "" if there is none.
+func (h *APIHandler) certFingerprint() string {
+	if h.Cert == nil || len(h.Cert.Certificate) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(h.Cert.Certificate[0])
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheEnabled tells if fresh licenses are cached. The cache is disabled when the personal data are encrypted,
+// as a cached license holds them in clear.
+func (h *APIHandler) cacheEnabled() bool {
+	return h.Config.License.CacheTTL > 0 && !h.Config.PII.Enabled()
+}
+
+// getCachedLicense returns a cached fresh license, or nil if the cache is disabled or has no entry.
+func (h *APIHandler) getCachedLicense(r *http.Request, hash string) []byte {
+	if !h.cacheEnabled() {
+		return nil
+	}
+	cached, err := h.store(r).LicenseCache().Get(hash, time.Duration(h.Config.License.CacheTTL)*time.Minute)
+	if err != nil {
+		return nil
+	}
+	return cached.Document
+}
+
+// cacheLicense stores a signed fresh license in the cache.
+// A failure is logged, as it doesn't prevent the license from being served.
+func (h *APIHandler) cacheLicense(r *http.Request, hash string, license *lic.License) {
+	if !h.cacheEnabled() {
+		return
+	}
+	doc, err := json.Marshal(license)
+	if err == nil {
+		err = h.store(r).LicenseCache().Set(&stor.CachedLicense{LicenseID: license.UUID, Hash: hash, Document: doc})
+	}
+	if err != nil {
+		h.logger(r).Warningf("Failed to cache the license %s: %v", license.UUID, err)
+	}
+}
+
+// writeLicense writes a signed license as a response, with a given media type.
+func writeLicense(w http.ResponseWriter, contentType string, doc []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Write(doc)
+}
@@ -5,12 +5,18 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/edrlab/lcp-server/pkg/check"
 	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/sign"
+	"github.com/edrlab/lcp-server/pkg/slo"
 	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/tracing"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 	"github.com/go-playground/validator/v10"
@@ -26,12 +32,23 @@ func (h *APIHandler) GenerateLicense(w http.ResponseWriter, r *http.Request) {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
+	if err := h.certifyLicenseRequest(licRequest); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	h.generateLicense(w, r, licRequest, h.store(r).License().Create)
+}
+
+// generateLicense creates the license info of a request with a given function, e.g. storing it
+// with the redemption of a coupon, and returns a fresh license.
+func (h *APIHandler) generateLicense(w http.ResponseWriter, r *http.Request, licRequest *LicenseRequest, create func(*stor.LicenseInfo) error) {
 
 	// get the corresponding publication
 	var pubInfo *stor.Publication
 	var err error
 	if licRequest.PublicationID != "" {
-		pubInfo, err = h.Store.Publication().Get(licRequest.PublicationID)
+		pubInfo, err = h.store(r).Publication().Get(licRequest.PublicationID)
 	} else {
 		render.Render(w, r, ErrInvalidRequest(errors.New("missing required publication identifier in payload")))
 		return
@@ -41,27 +58,57 @@ func (h *APIHandler) GenerateLicense(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// record the fulfillment, and rehydrate an archived publication
+	if err = h.fulfill(r, pubInfo); err != nil {
+		render.Render(w, r, ErrUnavailable(err))
+		return
+	}
+
+	// get the passphrase from the pool of an organization, or generate it
+	passphrase, err := h.setPassphrase(r, licRequest, nil)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
 	// set license info
-	licInfo := newLicenseInfo(h.Config.License.Provider, licRequest)
+	licInfo := newLicenseInfo(h.provider(r), licRequest)
+	slo.SetTenant(r.Context(), licInfo.Provider)
+	if passphrase != "" {
+		// only the hash of a generated passphrase is stored, with its hint
+		licInfo.PassHash = licRequest.PassHash
+		licInfo.TextHint = licRequest.TextHint
+	}
 
 	// store license info
-	err = h.Store.License().Create(licInfo)
+	err = create(licInfo)
+	if errors.Is(err, stor.ErrNotRedeemable) {
+		render.Render(w, r, ErrConflict(err))
+		return
+	}
 	if err != nil {
 		render.Render(w, r, ErrRender(err))
 		return
 	}
 	// get back license info to retrieve gorm data
-	licInfo, err = h.Store.License().Get(licInfo.UUID)
+	licInfo, err = h.store(r).License().Get(licInfo.UUID)
 	if err != nil {
 		render.Render(w, r, ErrNotFound)
 		return
 	}
 
+	// get the list of encrypted user fields
+	encrypted, err := h.encryptedFields(licRequest)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
 	userInfo := lic.UserInfo{
 		ID:        licRequest.UserID,
 		Name:      licRequest.UserName,
 		Email:     licRequest.UserEmail,
-		Encrypted: licRequest.UserEncrypted,
+		Encrypted: encrypted,
 	}
 	encryption := lic.Encryption{
 		Profile: licRequest.Profile,
@@ -70,12 +117,49 @@ func (h *APIHandler) GenerateLicense(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
+	// the parameters are hashed before generation, which modifies them
+	policy := lic.ProviderPolicy(h.store(r), licInfo.Provider)
+	hash := h.freshLicenseHash(r, pubInfo, licInfo, policy, &userInfo, &encryption, licRequest.PassHash)
+
 	// generate the license
-	license, err := lic.NewLicense(h.Config, h.Cert, pubInfo, licInfo, &userInfo, &encryption, licRequest.PassHash)
+	signer, err := h.signer()
 	if err != nil {
 		render.Render(w, r, ErrRender(err))
 		return
 	}
+	_, span := tracing.Start(r.Context(), "generate license")
+	license, err := lic.NewLicense(h.requestConfig(r), signer, pubInfo, licInfo, policy, &userInfo, &encryption, licRequest.PassHash)
+	span.End()
+	if err != nil {
+		render.Render(w, r, licenseError(err))
+		return
+	}
+	if err = h.certifyLicense(license); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err = h.checkSchema(check.LicenseSchema, license); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	if passphrase != "" {
+		// store the key check associated with the generated passphrase
+		licInfo.KeyCheck = license.Encryption.UserKey.Keycheck
+		if err = h.store(r).License().Update(licInfo); err != nil {
+			render.Render(w, r, ErrRender(err))
+			return
+		}
+		// the passphrase is returned once, and never again
+		w.Header().Set("Cache-Control", "no-store")
+		if err = render.Render(w, r, NewGeneratedLicenseResponse(license, passphrase)); err != nil {
+			render.Render(w, r, ErrRender(err))
+		}
+		return
+	}
+
+	// pre-generation: the first fetch of the same license will hit the cache
+	h.cacheLicense(r, hash, license)
 
 	if err = render.Render(w, r, NewLicenseResponse(license)); err != nil {
 		render.Render(w, r, ErrRender(err))
@@ -93,11 +177,15 @@ func (h *APIHandler) GetFreshLicense(w http.ResponseWriter, r *http.Request) {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
+	if err = h.certifyLicenseRequest(licRequest); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
 
 	// get the license
 	var licInfo *stor.LicenseInfo
 	if licenseID := chi.URLParam(r, "licenseID"); licenseID != "" {
-		licInfo, err = h.Store.License().Get(licenseID)
+		licInfo, err = h.store(r).License().Get(licenseID)
 	} else {
 		render.Render(w, r, ErrInvalidRequest(errors.New("missing licenseID parameter")))
 		return
@@ -107,11 +195,47 @@ func (h *APIHandler) GetFreshLicense(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.serveFreshLicense(w, r, licInfo, licRequest, "application/json; charset=utf-8")
+}
+
+// GetLicenseDocument returns a fresh license, with the media type of licenses, e.g. to be downloaded by a reading system.
+// The user key of the license must be known by the server, i.e. its passphrase must have been generated by the server;
+// the license is generated for the user of the license info, with the default encryption profile.
+func (h *APIHandler) GetLicenseDocument(w http.ResponseWriter, r *http.Request) {
+
+	var licenseID string
+	if licenseID = getLicenseID(w, r); licenseID == "" {
+		return
+	}
+	licInfo, err := h.store(r).License().Get(licenseID)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if licInfo.PassHash == "" || licInfo.TextHint == "" {
+		render.Render(w, r, ErrConflict(errors.New("the passphrase of the license is not known by the server, the user key must be given by a POST request")))
+		return
+	}
+	licRequest := &LicenseRequest{
+		PublicationID: licInfo.PublicationID,
+		UserID:        licInfo.UserID,
+		Profile:       h.Config.License.Profile,
+		TextHint:      licInfo.TextHint,
+	}
+	h.serveFreshLicense(w, r, licInfo, licRequest, lic.ContentType_LCP_JSON)
+}
+
+// serveFreshLicense generates, or gets from the cache, the fresh license of a license info and a request,
+// and writes it with a given media type.
+func (h *APIHandler) serveFreshLicense(w http.ResponseWriter, r *http.Request, licInfo *stor.LicenseInfo, licRequest *LicenseRequest, contentType string) {
+	var err error
+	slo.SetTenant(r.Context(), licInfo.Provider)
+
 	// get the corresponding publication
 	var pubInfo *stor.Publication
 
 	if licInfo.PublicationID != "" {
-		pubInfo, err = h.Store.Publication().Get(licInfo.PublicationID)
+		pubInfo, err = h.store(r).Publication().Get(licInfo.PublicationID)
 	} else {
 		render.Render(w, r, ErrInvalidRequest(errors.New("missing required publication identifier in payload")))
 		return
@@ -121,11 +245,30 @@ func (h *APIHandler) GetFreshLicense(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// record the fulfillment, and rehydrate an archived publication
+	if err = h.fulfill(r, pubInfo); err != nil {
+		render.Render(w, r, ErrUnavailable(err))
+		return
+	}
+
+	// get the passphrase from the pool of an organization, or the generated passphrase
+	if _, err = h.setPassphrase(r, licRequest, licInfo); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// get the list of encrypted user fields
+	encrypted, err := h.encryptedFields(licRequest)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
 	userInfo := lic.UserInfo{
 		ID:        licRequest.UserID,
 		Name:      licRequest.UserName,
 		Email:     licRequest.UserEmail,
-		Encrypted: licRequest.UserEncrypted,
+		Encrypted: encrypted,
 	}
 
 	encryption := lic.Encryption{
@@ -135,16 +278,134 @@ func (h *APIHandler) GetFreshLicense(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
+	// serve a cached license if the same license was already generated and signed
+	policy := lic.ProviderPolicy(h.store(r), licInfo.Provider)
+	hash := h.freshLicenseHash(r, pubInfo, licInfo, policy, &userInfo, &encryption, licRequest.PassHash)
+	if doc := h.getCachedLicense(r, hash); doc != nil {
+		h.recordUsage(r, pubInfo.UUID, 1, 0)
+		writeLicense(w, contentType, doc)
+		return
+	}
+
 	// generate the license
-	license, err := lic.NewLicense(h.Config, h.Cert, pubInfo, licInfo, &userInfo, &encryption, licRequest.PassHash)
+	signer, err := h.signer()
 	if err != nil {
 		render.Render(w, r, ErrRender(err))
 		return
 	}
-	if err := render.Render(w, r, NewLicenseResponse(license)); err != nil {
+	_, span := tracing.Start(r.Context(), "generate license")
+	license, err := lic.NewLicense(h.requestConfig(r), signer, pubInfo, licInfo, policy, &userInfo, &encryption, licRequest.PassHash)
+	span.End()
+	if err != nil {
+		render.Render(w, r, licenseError(err))
+		return
+	}
+	if err = h.certifyLicense(license); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err = h.checkSchema(check.LicenseSchema, license); err != nil {
 		render.Render(w, r, ErrRender(err))
 		return
 	}
+	h.cacheLicense(r, hash, license)
+	h.recordUsage(r, pubInfo.UUID, 1, 0)
+
+	doc, err := json.Marshal(license)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	writeLicense(w, contentType, doc)
+}
+
+// fulfill records that a license is served for a publication managed by the server
+func (h *APIHandler) fulfill(r *http.Request, pubInfo *stor.Publication) error {
+	if h.Tiering == nil {
+		return nil
+	}
+	return h.tiering(pubInfo.Provider).Fulfill(r.Context(), pubInfo)
+}
+
+// licenseError maps a license generation error to an error response
+func licenseError(err error) render.Renderer {
+	if errors.Is(err, sign.ErrSaturated) {
+		return ErrUnavailable(err)
+	}
+	return ErrRender(err)
+}
+
+// encryptedFields returns the user fields to encrypt: the list set in the request,
+// or by default the list associated with the license template.
+func (h *APIHandler) encryptedFields(licRequest *LicenseRequest) ([]string, error) {
+	if licRequest.UserEncrypted != nil {
+		return licRequest.UserEncrypted, nil
+	}
+	return h.Config.License.EncryptedFields(licRequest.Template)
+}
+
+// setPassphrase sets the passphrase hash of a request, if not explicitly set. The passphrase is taken
+// from the pool of an organization, selected by its label or by default the first passphrase of the pool;
+// or generated by the server, in which case it is returned; or, for an existing license,
+// taken from the passphrase generated at the creation of the license.
+func (h *APIHandler) setPassphrase(r *http.Request, licRequest *LicenseRequest, licInfo *stor.LicenseInfo) (string, error) {
+	scheme := h.Config.License.PassHashScheme(h.provider(r))
+	switch {
+	case licRequest.PassHash != "":
+		// the hash must match the scheme declared by the CMS
+		return "", lic.ValidatePassHash(scheme, licRequest.PassHash)
+	case licRequest.OrganizationID != "":
+		return "", h.setPoolPassphrase(r, licRequest, licInfo)
+	case licRequest.GeneratePassphrase:
+		if licInfo != nil {
+			return "", errors.New("a passphrase can only be generated with a new license")
+		}
+		if scheme != "" && scheme != lic.PassHash_SHA256 {
+			return "", fmt.Errorf("a passphrase cannot be generated with the %s scheme", scheme)
+		}
+		passphrase, passhash, err := lic.GeneratePassphrase()
+		if err != nil {
+			return "", err
+		}
+		licRequest.PassHash = passhash
+		return passphrase, nil
+	case licInfo != nil && licInfo.PassHash != "":
+		licRequest.PassHash = licInfo.PassHash
+		return "", nil
+	}
+	return "", errors.New("missing passphrase hash")
+}
+
+// setPoolPassphrase sets the text hint and passphrase hash of a request from the passphrase pool
+// of an organization. A fresh license keeps the passphrase its license was issued with, unless a label is given.
+func (h *APIHandler) setPoolPassphrase(r *http.Request, licRequest *LicenseRequest, licInfo *stor.LicenseInfo) error {
+	var passphrase *stor.Passphrase
+	var err error
+	switch {
+	case licRequest.PassphraseLabel != "":
+		passphrase, err = h.store(r).Organization().GetPassphrase(licRequest.OrganizationID, licRequest.PassphraseLabel)
+		if err != nil {
+			return fmt.Errorf("no passphrase %q for organization %s", licRequest.PassphraseLabel, licRequest.OrganizationID)
+		}
+	case licInfo != nil && licInfo.PassphraseID != 0:
+		passphrase, err = h.store(r).Organization().GetPassphraseByID(licInfo.PassphraseID)
+		if err != nil || passphrase.OrganizationID != licRequest.OrganizationID {
+			return fmt.Errorf("the passphrase of the license is not in the pool of organization %s", licRequest.OrganizationID)
+		}
+	default:
+		passphrases, err := h.store(r).Organization().ListPassphrases(licRequest.OrganizationID)
+		if err != nil {
+			return err
+		}
+		if len(*passphrases) == 0 {
+			return fmt.Errorf("empty passphrase pool for organization %s", licRequest.OrganizationID)
+		}
+		passphrase = &(*passphrases)[0]
+	}
+	licRequest.TextHint = passphrase.TextHint
+	licRequest.PassHash = passphrase.PassHash
+	licRequest.passphraseID = passphrase.ID
+	return nil
 }
 
 // newLicenseInfo sets license info from request parameters
@@ -168,6 +429,8 @@ func newLicenseInfo(provider string, licRequest *LicenseRequest) *stor.LicenseIn
 		Copy:          *licRequest.Copy,
 		Print:         *licRequest.Print,
 		Status:        stor.STATUS_READY,
+		OrderRef:      licRequest.OrderRef,
+		PassphraseID:  licRequest.passphraseID,
 	}
 	return &licInfo
 }
@@ -183,19 +446,36 @@ type LicenseRequest struct {
 	UserName      string     `json:"user_name,omitempty"`
 	UserEmail     string     `json:"user_email,omitempty"`
 	UserEncrypted []string   `json:"user_encrypted,omitempty"`
+	Template      string     `json:"template,omitempty"`
 	Start         *time.Time `json:"start,omitempty"`
 	End           *time.Time `json:"end,omitempty"`
 	Copy          *int32     `json:"copy,omitempty"`
 	Print         *int32     `json:"print,omitempty"`
 	Profile       string     `json:"profile" validate:"required"`
-	TextHint      string     `json:"text_hint" validate:"required"`
-	PassHash      string     `json:"pass_hash" validate:"required"`
+	TextHint      string     `json:"text_hint" validate:"required_without=OrganizationID"`
+	PassHash      string     `json:"pass_hash,omitempty"`
+	// the passphrase can be taken from the pool of an organization
+	OrganizationID  string `json:"organization_id,omitempty" validate:"omitempty,uuid"`
+	PassphraseLabel string `json:"passphrase_label,omitempty"`
+	passphraseID    uint   // identifier of the passphrase taken from the pool, recorded with a new license
+	// or generated by the server
+	GeneratePassphrase bool `json:"generate_passphrase,omitempty"`
+	// reference of the storefront order, e.g. to void the license after a refund
+	OrderRef string `json:"order_ref,omitempty" validate:"max=255"`
 }
 
 // Bind post-processes requests after unmarshalling.
 func (l *LicenseRequest) Bind(r *http.Request) error {
 	validate := validator.New()
-	return validate.Struct(l)
+	if err := validate.Struct(l); err != nil {
+		return err
+	}
+	for _, field := range l.UserEncrypted {
+		if !lic.EncryptableFields[field] {
+			return fmt.Errorf("the user field %q cannot be encrypted", field)
+		}
+	}
+	return nil
 }
 
 // LicenseResponse is the response payload for licenses.
@@ -210,6 +490,22 @@ func NewLicenseResponse(license *lic.License) *LicenseResponse {
 	return &LicenseResponse{License: license}
 }
 
+// GeneratedLicenseResponse is the response payload for licenses generated with a one-time passphrase.
+type GeneratedLicenseResponse struct {
+	License    *lic.License `json:"license"`
+	Passphrase string       `json:"passphrase"`
+}
+
+// NewGeneratedLicenseResponse creates a rendered license and its generated passphrase
+func NewGeneratedLicenseResponse(license *lic.License, passphrase string) *GeneratedLicenseResponse {
+	return &GeneratedLicenseResponse{License: license, Passphrase: passphrase}
+}
+
+// Render processes responses before marshalling.
+func (l *GeneratedLicenseResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
 // Render processes responses before marshalling.
 func (l *LicenseResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
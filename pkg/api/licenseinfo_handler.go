@@ -5,75 +5,145 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/edrlab/lcp-server/pkg/stor"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 )
 
-// ListLicenses lists all licenses present in the database.
+// ListLicenses lists a page of the licenses present in the database,
+// including the deleted ones if include_deleted is set.
 func (h *APIHandler) ListLicenses(w http.ResponseWriter, r *http.Request) {
-	licenses, err := h.Store.License().ListAll()
+	page := pageOf(r)
+	order, err := stor.LicenseOrder(r.URL.Query().Get("sort"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	repo := h.store(r).License().Sorted(order)
+	withDeleted, err := includeDeleted(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if withDeleted {
+		repo = repo.WithDeleted()
+	}
+	licenses, err := repo.List(page.Size, page.Num)
 	if err != nil {
 		render.Render(w, r, ErrRender(err))
 		return
 	}
+	total, err := repo.Count()
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	setPageHeaders(w, r, page, total)
 	if err := render.RenderList(w, r, NewLicenseInfoListResponse(licenses)); err != nil {
 		render.Render(w, r, ErrRender(err))
 		return
 	}
 }
 
-// SearchLicenses searches licenses corresponding to a specific criteria.
+// SearchLicenses searches licenses corresponding to a set of criteria, which must all be met.
+// The results are limited, see SearchLimits.
 func (h *APIHandler) SearchLicenses(w http.ResponseWriter, r *http.Request) {
-	var licenses *[]stor.LicenseInfo
-	var err error
-
-	// search by user
-	if userID := r.URL.Query().Get("user"); userID != "" {
-		licenses, err = h.Store.License().FindByUser(userID)
-		// by publication
-	} else if pubID := r.URL.Query().Get("pub"); pubID != "" {
-		licenses, err = h.Store.License().FindByPublication(pubID)
-		// by status
-	} else if status := r.URL.Query().Get("status"); status != "" {
-		licenses, err = h.Store.License().FindByStatus(status)
-		// by count
-	} else if count := r.URL.Query().Get("count"); count != "" {
-		// count is a "min:max" tuple
-		var min, max int
-		parts := strings.Split(count, ":")
-		if len(parts) != 2 {
-			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid count parameter: %s", count)))
-			return
-		}
-		if min, err = strconv.Atoi(parts[0]); err != nil {
-			render.Render(w, r, ErrInvalidRequest(err))
-		}
-		if max, err = strconv.Atoi(parts[1]); err != nil {
-			render.Render(w, r, ErrInvalidRequest(err))
-		}
-		licenses, err = h.Store.License().FindByDeviceCount(min, max)
-	} else {
+	order, err := stor.LicenseOrder(r.URL.Query().Get("sort"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	query, err := licenseQuery(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if query.IsEmpty() {
 		render.Render(w, r, ErrNotFound)
 		return
 	}
+	search, err := h.searchLimits().start(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// one more result tells if the results are truncated
+	query.Limit = search.limit + 1
+	licenses, err := h.store(r).License().Sorted(order).Find(query)
 	if err != nil {
 		render.Render(w, r, ErrRender(err))
 		return
 	}
+	*licenses = (*licenses)[:search.done(w, r, len(*licenses))]
 	if err := render.RenderList(w, r, NewLicenseInfoListResponse(licenses)); err != nil {
 		render.Render(w, r, ErrRender(err))
 		return
 	}
 }
 
-// CreateLicense adds a new license to the database.
+// ExportLicenses streams every license corresponding to the criteria of a search, without limit, as
+// newline-delimited JSON in the order of creation.
+func (h *APIHandler) ExportLicenses(w http.ResponseWriter, r *http.Request) {
+	query, err := licenseQuery(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if query.IsEmpty() {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	out := newNDJSONWriter(w)
+	err = h.store(r).License().Stream(query, func(license *stor.LicenseInfo) error {
+		return out.write(NewLicenseInfoResponse(license))
+	})
+	if err != nil {
+		// the status is already sent, the client gets a truncated stream
+		h.Logger.Errorf("Export of the licenses interrupted after %d licenses: %v", out.written, err)
+	}
+	out.flush()
+}
+
+// licenseQuery returns the criteria of a license search
+func licenseQuery(r *http.Request) (stor.LicenseQuery, error) {
+	query := stor.LicenseQuery{
+		UserID:        r.URL.Query().Get("user"),
+		PublicationID: r.URL.Query().Get("pub"),
+		Status:        r.URL.Query().Get("status"),
+		BundleID:      r.URL.Query().Get("bundle"),
+		OrderRef:      r.URL.Query().Get("order_ref"),
+	}
+	if count := r.URL.Query().Get("count"); count != "" {
+		// count is a "min:max" tuple
+		parts := strings.Split(count, ":")
+		if len(parts) != 2 {
+			return query, fmt.Errorf("invalid count parameter: %s", count)
+		}
+		var rg stor.Range
+		var err error
+		if rg.Min, err = strconv.Atoi(parts[0]); err != nil {
+			return query, err
+		}
+		if rg.Max, err = strconv.Atoi(parts[1]); err != nil {
+			return query, err
+		}
+		query.DeviceCount = &rg
+	}
+	return query, nil
+}
+
+// CreateLicense adds a new license to the database. A license whose uuid is already in use
+// is a conflict, unless the upsert query parameter is set: the existing license is then updated,
+// keeping its status and registered devices, so that an ingestion can be safely retried.
 func (h *APIHandler) CreateLicense(w http.ResponseWriter, r *http.Request) {
 
 	// get the payload
@@ -83,22 +153,35 @@ func (h *APIHandler) CreateLicense(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	license := data.LicenseInfo
-
-	// force the status
-	if license.Status != stor.STATUS_READY {
-		license.Status = stor.STATUS_READY
-	}
-	// set the max end date if there is an end date and the max end date is not set in the input.
-	// the renew max date will be 0 if not set in the configuration
-	if license.End != nil && license.MaxEnd == nil {
-		maxEnd := license.End.AddDate(0, 0, h.Config.Status.RenewMaxDays)
-		license.MaxEnd = &maxEnd
+	h.initLicense(license)
+	if err := h.certifyLicenseInfo(license); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
 	}
 
 	// db create
-	err := h.Store.License().Create(license)
+	err := h.store(r).License().Create(license)
+	if errors.Is(err, stor.ErrDuplicate) && r.URL.Query().Get("upsert") == "true" {
+		var currentLic *stor.LicenseInfo
+		if currentLic, err = h.store(r).License().Get(license.UUID); err == nil {
+			mergeLicense(license, currentLic)
+			license.Status = currentLic.Status
+			license.DeviceCount = currentLic.DeviceCount
+			h.setUpdated(license, currentLic)
+			if err = h.store(r).License().Update(license); err != nil {
+				render.Render(w, r, ErrRender(err))
+				return
+			}
+			if err := render.Render(w, r, NewLicenseInfoResponse(license)); err != nil {
+				render.Render(w, r, ErrRender(err))
+			}
+			return
+		}
+		// a deleted license still holds its uuid
+		err = stor.ErrDuplicate
+	}
 	if err != nil {
-		render.Render(w, r, ErrRender(err))
+		render.Render(w, r, createError(w, r, err, license.UUID))
 		return
 	}
 
@@ -116,7 +199,7 @@ func (h *APIHandler) GetLicense(w http.ResponseWriter, r *http.Request) {
 	var err error
 
 	if licenseID := chi.URLParam(r, "licenseID"); licenseID != "" {
-		license, err = h.Store.License().Get(licenseID)
+		license, err = h.store(r).License().Get(licenseID)
 	} else {
 		render.Render(w, r, ErrInvalidRequest(errors.New("missing required license identifier")))
 		return
@@ -147,7 +230,7 @@ func (h *APIHandler) UpdateLicense(w http.ResponseWriter, r *http.Request) {
 
 	// get the existing license
 	if licenseID := chi.URLParam(r, "licenseID"); licenseID != "" {
-		currentLic, err = h.Store.License().Get(licenseID)
+		currentLic, err = h.store(r).License().Get(licenseID)
 	} else {
 		render.Render(w, r, ErrNotFound)
 		return
@@ -157,28 +240,17 @@ func (h *APIHandler) UpdateLicense(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// set the gorm fields
-	license.ID = currentLic.ID
-	license.CreatedAt = currentLic.CreatedAt
-	//license.UpdatedAt = currentLic.UpdatedAt
-	//license.DeletedAt = currentLic.DeletedAt
+	mergeLicense(license, currentLic)
+	if err = h.certifyLicenseInfo(license); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
 
-	// set the update date only if rights are modified
-	// ** non en fait : il faut passer la bonne valeur de Updated à l'appel **
-	/*
-		if (license.Start != nil && currentLic.Start != nil && !license.Start.Equal(*currentLic.Start)) ||
-			(license.End != nil && currentLic.End != nil && !license.End.Equal(*currentLic.End)) ||
-			(license.Copy != currentLic.Copy) ||
-			(license.Print != currentLic.Print) {
-			now := time.Now()
-			license.Updated = &now
-		} else {
-			license.Updated = currentLic.Updated
-		}
-	*/
+	// the update times are maintained by the server
+	h.setUpdated(license, currentLic)
 
 	// db update
-	err = h.Store.License().Update(license)
+	err = h.store(r).License().Update(license)
 	if err != nil {
 		render.Render(w, r, ErrRender(err))
 		return
@@ -190,6 +262,147 @@ func (h *APIHandler) UpdateLicense(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// MaxBatchSize is the max number of licenses created by a batch.
+const MaxBatchSize = 1000
+
+// CreateLicenses adds a batch of licenses to the database, in a single transaction.
+// Each license is created or rejected on its own, and the result of each is returned,
+// so that the rejected licenses of a partially invalid batch can be retried.
+func (h *APIHandler) CreateLicenses(w http.ResponseWriter, r *http.Request) {
+
+	// get the payload; each item is decoded separately, so that an invalid item does not reject the batch
+	var items []json.RawMessage
+	if err := render.DecodeJSON(r.Body, &items); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if len(items) == 0 || len(items) > MaxBatchSize {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("a batch must contain from 1 to %d licenses", MaxBatchSize)))
+		return
+	}
+
+	response := &BatchResponse{Results: make([]BatchResult, len(items))}
+	var licenses []*stor.LicenseInfo
+	var indexes []int // index of each valid license in the batch
+	for i, item := range items {
+		license, err := h.decodeBatchLicense(item)
+		response.Results[i] = BatchResult{Index: i, UUID: license.UUID, Status: http.StatusCreated}
+		if err != nil {
+			response.Results[i].Status, response.Results[i].Error = http.StatusBadRequest, err.Error()
+			continue
+		}
+		licenses = append(licenses, license)
+		indexes = append(indexes, i)
+	}
+
+	// db create
+	if len(licenses) > 0 {
+		errs, err := h.store(r).License().CreateAll(licenses)
+		if err != nil {
+			render.Render(w, r, ErrRender(err))
+			return
+		}
+		for j, err := range errs {
+			if err == nil {
+				continue
+			}
+			result := &response.Results[indexes[j]]
+			result.Status, result.Error = http.StatusUnprocessableEntity, err.Error()
+			if errors.Is(err, stor.ErrDuplicate) {
+				result.Status = http.StatusConflict
+			}
+		}
+	}
+	for _, result := range response.Results {
+		if result.Status == http.StatusCreated {
+			response.Created++
+		} else {
+			response.Failed++
+		}
+	}
+
+	if err := render.Render(w, r, response); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// decodeBatchLicense decodes, validates and initializes a license of a batch.
+// The license is returned even if it is invalid, as its identifier may be set.
+func (h *APIHandler) decodeBatchLicense(item json.RawMessage) (*stor.LicenseInfo, error) {
+	license := &stor.LicenseInfo{}
+	if err := json.Unmarshal(item, license); err != nil {
+		return license, err
+	}
+	if err := license.Validate(); err != nil {
+		return license, err
+	}
+	h.initLicense(license)
+	return license, h.certifyLicenseInfo(license)
+}
+
+// initLicense sets the fields of a new license which are not set by the client.
+func (h *APIHandler) initLicense(license *stor.LicenseInfo) {
+
+	// force the status
+	if license.Status != stor.STATUS_READY {
+		license.Status = stor.STATUS_READY
+	}
+	// set the max end date if there is an end date and the max end date is not set in the input.
+	// the renew max date will be 0 if not set in the configuration
+	if license.End != nil && license.MaxEnd == nil {
+		maxEnd := license.End.AddDate(0, 0, h.Config.Status.RenewMaxDays)
+		license.MaxEnd = &maxEnd
+	}
+}
+
+// mergeLicense sets the fields of an updated license which are maintained by the database and the server.
+func mergeLicense(license, currentLic *stor.LicenseInfo) {
+
+	// set the gorm fields
+	license.ID = currentLic.ID
+	license.CreatedAt = currentLic.CreatedAt
+	//license.UpdatedAt = currentLic.UpdatedAt
+	//license.DeletedAt = currentLic.DeletedAt
+
+	// keep the generated passphrase hash, which is never exposed
+	license.PassHash = currentLic.PassHash
+	license.KeyCheck = currentLic.KeyCheck
+	license.TextHint = currentLic.TextHint
+
+	// a license stays linked to the bundle it was issued with
+	license.BundleID = currentLic.BundleID
+}
+
+// setUpdated sets the update times of a modified license: the rights are updated only if the start, end, copy
+// or print rights change, which modifies the fresh licenses; the status is updated if the status or the rights
+// change, as the status document reports both. The times given by the payload are ignored.
+func (h *APIHandler) setUpdated(license, currentLic *stor.LicenseInfo) {
+	now := h.Clock().Truncate(time.Second)
+	license.Updated = currentLic.Updated
+	license.StatusUpdated = currentLic.StatusUpdated
+	if rightsChanged(license, currentLic) {
+		license.Updated = &now
+		license.StatusUpdated = &now
+	} else if license.Status != currentLic.Status {
+		license.StatusUpdated = &now
+	}
+}
+
+// rightsChanged tells if the rights of a license differ from its current rights
+func rightsChanged(license, currentLic *stor.LicenseInfo) bool {
+	return !sameTime(license.Start, currentLic.Start) || !sameTime(license.End, currentLic.End) ||
+		license.Copy != currentLic.Copy || license.Print != currentLic.Print
+}
+
+// sameTime tells if two optional times are equal
+func sameTime(t1, t2 *time.Time) bool {
+	if t1 == nil || t2 == nil {
+		return t1 == t2
+	}
+	return t1.Equal(*t2)
+}
+
 // DeleteLicense removes an existing license from the database.
 func (h *APIHandler) DeleteLicense(w http.ResponseWriter, r *http.Request) {
 
@@ -198,7 +411,7 @@ func (h *APIHandler) DeleteLicense(w http.ResponseWriter, r *http.Request) {
 
 	// get the existing license
 	if licenseID := chi.URLParam(r, "licenseID"); licenseID != "" {
-		license, err = h.Store.License().Get(licenseID)
+		license, err = h.store(r).License().Get(licenseID)
 	} else {
 		render.Render(w, r, ErrNotFound)
 		return
@@ -209,7 +422,7 @@ func (h *APIHandler) DeleteLicense(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// db delete
-	err = h.Store.License().Delete(license)
+	err = h.store(r).License().Delete(license)
 	if err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
@@ -222,6 +435,21 @@ func (h *APIHandler) DeleteLicense(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// RestoreLicense undoes the deletion of a license.
+func (h *APIHandler) RestoreLicense(w http.ResponseWriter, r *http.Request) {
+
+	license, err := h.store(r).License().Restore(chi.URLParam(r, "licenseID"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	if err := render.Render(w, r, NewLicenseInfoResponse(license)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
 // --
 // Request and Response payloads for the REST api.
 // --
@@ -264,3 +492,23 @@ func (l *LicenseInfoRequest) Bind(r *http.Request) error {
 func (l *LicenseInfoResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
+
+// BatchResponse is the response payload of a batch, giving the result of each item.
+type BatchResponse struct {
+	Created int           `json:"created"`
+	Failed  int           `json:"failed"`
+	Results []BatchResult `json:"results"`
+}
+
+// BatchResult is the result of an item of a batch.
+type BatchResult struct {
+	Index  int    `json:"index"`          // position of the item in the batch
+	UUID   string `json:"uuid,omitempty"` // identifier of the item, if decoded
+	Status int    `json:"status"`         // status code of the item, as if it was processed alone
+	Error  string `json:"error,omitempty"`
+}
+
+// Render processes responses before marshalling.
+func (b *BatchResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
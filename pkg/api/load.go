@@ -0,0 +1,132 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/render"
+)
+
+// ErrOverloaded is returned when a request could not be processed before the queue deadline.
+var ErrOverloaded = errors.New("server overloaded, the request was not processed in time")
+
+// ErrTimeout is returned when the processing of a request exceeds its timeout.
+var ErrTimeout = errors.New("the request was not processed in time")
+
+// Shedder caps the number of requests processed concurrently. Excess requests are queued,
+// up to a deadline after which they are rejected with a 503 status code, so that bursts
+// degrade gracefully instead of piling up goroutines. Requests are rejected immediately
+// when the queue is full.
+type Shedder struct {
+	slots     chan struct{}
+	maxQueued int64         // max number of queued requests, 0 if unlimited
+	timeout   time.Duration // max time spent in the queue
+
+	inFlight int64
+	queued   int64
+	total    uint64
+	shed     uint64
+}
+
+// ShedderStats are saturation metrics of a shedder.
+type ShedderStats struct {
+	MaxConcurrent int    `json:"max_concurrent"`
+	InFlight      int64  `json:"in_flight"`
+	Queued        int64  `json:"queued"`
+	Total         uint64 `json:"total"`
+	Shed          uint64 `json:"shed"`
+}
+
+// NewShedder creates a shedder allowing maxConcurrent requests at a time, and maxQueued
+// waiting requests (0 for no limit). Queued requests wait at most timeout.
+func NewShedder(maxConcurrent int, maxQueued int, timeout time.Duration) *Shedder {
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	return &Shedder{
+		slots:     make(chan struct{}, maxConcurrent),
+		maxQueued: int64(maxQueued),
+		timeout:   timeout,
+	}
+}
+
+// Handler is the middleware processing the requests through the shedder.
+func (s *Shedder) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.acquire(r) {
+			w.Header().Set("Retry-After", "1")
+			render.Render(w, r, ErrUnavailable(ErrOverloaded))
+			return
+		}
+		defer s.release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire waits for a processing slot; it fails at the queue deadline, or if the client goes away.
+func (s *Shedder) acquire(r *http.Request) bool {
+	queued := atomic.AddInt64(&s.queued, 1)
+	defer atomic.AddInt64(&s.queued, -1)
+	if s.maxQueued > 0 && queued > s.maxQueued {
+		atomic.AddUint64(&s.shed, 1)
+		return false
+	}
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	select {
+	case s.slots <- struct{}{}:
+	case <-timer.C:
+		atomic.AddUint64(&s.shed, 1)
+		return false
+	case <-r.Context().Done():
+		atomic.AddUint64(&s.shed, 1)
+		return false
+	}
+	atomic.AddInt64(&s.inFlight, 1)
+	atomic.AddUint64(&s.total, 1)
+	return true
+}
+
+// release frees a processing slot.
+func (s *Shedder) release() {
+	atomic.AddInt64(&s.inFlight, -1)
+	<-s.slots
+}
+
+// Stats returns the current saturation metrics.
+func (s *Shedder) Stats() ShedderStats {
+	return ShedderStats{
+		MaxConcurrent: cap(s.slots),
+		InFlight:      atomic.LoadInt64(&s.inFlight),
+		Queued:        atomic.LoadInt64(&s.queued),
+		Total:         atomic.LoadUint64(&s.total),
+		Shed:          atomic.LoadUint64(&s.shed),
+	}
+}
+
+// Timeout returns a middleware bounding the processing time of the requests.
+// The context of a request which exceeds it is cancelled, which interrupts its database queries,
+// and the client receives a 503 status code. The response is buffered, therefore this middleware
+// must not be used on streamed content.
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		msg, _ := json.Marshal(ErrUnavailable(ErrTimeout))
+		th := http.TimeoutHandler(next, d, string(msg))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// set on the timeout response; the headers of a processed request replace it
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			th.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// RequestIDHeader is the header of the identifier of a request, set by its caller or by the server.
+const RequestIDHeader = "X-Request-ID"
+
+// identifiers of requests accepted from the callers, which are logged as they are
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestIDKey is the context key of the identifier of a request
+type requestIDKey struct{}
+
+// logKey is the context key of the fields of the access log set during a request
+type logKey struct{}
+
+// logFields are the fields of the access log known once the request is processed, e.g. by the authentication
+// of a subrouter, whose context is not seen by the access log
+type logFields struct {
+	mu     sync.Mutex
+	caller string // name of the authenticated principal
+	err    error  // error rendered to the caller
+}
+
+// RequestID identifies each request by the identifier its caller set in the X-Request-ID header, e.g. a content
+// management system or a reverse proxy, or else by a new identifier. The identifier is returned in the response
+// header and logged with the request, so that a failure is correlated across services.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestIDFromContext returns the identifier of a request, or an empty string if it has none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// AccessLog logs each request once it is processed, with structured fields: its identifier, method, route pattern,
+// path, status, latency, size of the response, caller and client address, and the error rendered if any.
+// Failures of the server are logged as errors, client errors as warnings.
+func AccessLog(logger log.FieldLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fields := &logFields{}
+			r = r.WithContext(context.WithValue(r.Context(), logKey{}, fields))
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			start := time.Now()
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			entry := logger.WithFields(log.Fields{
+				"request_id": RequestIDFromContext(r.Context()),
+				"method":     r.Method,
+				"path":       r.URL.Path,
+				"status":     status,
+				"latency_ms": time.Since(start).Milliseconds(),
+				"bytes":      ww.BytesWritten(),
+				"remote":     clientAddr(r),
+			})
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				entry = entry.WithField("route", rctx.RoutePattern())
+			}
+			fields.mu.Lock()
+			if fields.caller != "" {
+				entry = entry.WithField("caller", fields.caller)
+			}
+			if fields.err != nil {
+				entry = entry.WithError(fields.err)
+			}
+			fields.mu.Unlock()
+
+			switch {
+			case status >= 500:
+				entry.Error("Request failed")
+			case status >= 400:
+				entry.Warn("Request rejected")
+			default:
+				entry.Info("Request processed")
+			}
+		})
+	}
+}
+
+// setLogCaller records the authenticated caller of a request in its access log.
+func setLogCaller(ctx context.Context, caller string) {
+	if f, ok := ctx.Value(logKey{}).(*logFields); ok {
+		f.mu.Lock()
+		f.caller = caller
+		f.mu.Unlock()
+	}
+}
+
+// setLogError records the error rendered to the caller of a request in its access log.
+func setLogError(ctx context.Context, err error) {
+	if f, ok := ctx.Value(logKey{}).(*logFields); ok {
+		f.mu.Lock()
+		f.err = err
+		f.mu.Unlock()
+	}
+}
+
+// logger returns the logger of the events of a request, with its identifier, method and path.
+func (h *APIHandler) logger(r *http.Request) log.FieldLogger {
+	fields := log.Fields{"method": r.Method, "path": r.URL.Path}
+	if id := RequestIDFromContext(r.Context()); id != "" {
+		fields["request_id"] = id
+	}
+	return h.Logger.WithFields(fields)
+}
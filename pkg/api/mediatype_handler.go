@@ -0,0 +1,233 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/edrlab/lcp-server/pkg/pack"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// DefaultMediaTypes are the formats known by every deployment.
+var DefaultMediaTypes = []stor.MediaType{
+	{Format: "epub", ContentType: pack.ContentType_EPUB, Label: "EPUB"},
+	{Format: "lcpdf", ContentType: pack.ContentType_LCPDF, Label: "LCP PDF"},
+	{Format: "pdf", ContentType: pack.ContentType_LCPDF, Label: "LCP PDF"},
+	{Format: "lcpau", ContentType: pack.ContentType_LCPAU, Label: "LCP audiobook"},
+	{Format: "audiobook", ContentType: pack.ContentType_LCPAU, Label: "LCP audiobook"},
+	{Format: "lcpdi", ContentType: pack.ContentType_LCPDI, Label: "LCP Divina"},
+	{Format: "divina", ContentType: pack.ContentType_LCPDI, Label: "LCP Divina"},
+}
+
+// RegisterMediaTypes fills the media type registry with the default formats
+// and the formats declared in the configuration, if they are not registered yet.
+func RegisterMediaTypes(st stor.Store, formats map[string]string) error {
+
+	mediaTypes := append([]stor.MediaType{}, DefaultMediaTypes...)
+	// sorted for a deterministic registration order
+	names := make([]string, 0, len(formats))
+	for format := range formats {
+		names = append(names, format)
+	}
+	sort.Strings(names)
+	for _, format := range names {
+		mt := stor.MediaType{Format: format, ContentType: formats[format]}
+		if err := mt.Validate(); err != nil {
+			return err
+		}
+		mediaTypes = append(mediaTypes, mt)
+	}
+	return st.MediaType().Register(mediaTypes)
+}
+
+// ListMediaTypes lists the media type registry.
+func (h *APIHandler) ListMediaTypes(w http.ResponseWriter, r *http.Request) {
+	mediaTypes, err := h.store(r).MediaType().ListAll()
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err := render.RenderList(w, r, NewMediaTypeListResponse(mediaTypes)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// CreateMediaType registers a new format.
+func (h *APIHandler) CreateMediaType(w http.ResponseWriter, r *http.Request) {
+
+	// get the payload
+	data := &MediaTypeRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	mediaType := data.MediaType
+
+	// db create
+	err := h.store(r).MediaType().Create(mediaType)
+	if err != nil {
+		render.Render(w, r, createError(w, r, err, mediaType.Format))
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	if err := render.Render(w, r, NewMediaTypeResponse(mediaType)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// GetMediaType returns a specific format of the registry.
+func (h *APIHandler) GetMediaType(w http.ResponseWriter, r *http.Request) {
+
+	mediaType, err := h.getMediaType(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err := render.Render(w, r, NewMediaTypeResponse(mediaType)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// UpdateMediaType changes the media type associated with a format.
+func (h *APIHandler) UpdateMediaType(w http.ResponseWriter, r *http.Request) {
+
+	// get the payload
+	data := &MediaTypeRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	mediaType := data.MediaType
+
+	// get the existing format
+	current, err := h.getMediaType(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if mediaType.Format != current.Format {
+		render.Render(w, r, ErrInvalidRequest(errors.New("the format name cannot be modified")))
+		return
+	}
+
+	// set the gorm fields
+	mediaType.ID = current.ID
+	mediaType.CreatedAt = current.CreatedAt
+
+	// db update
+	err = h.store(r).MediaType().Update(mediaType)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	if err := render.Render(w, r, NewMediaTypeResponse(mediaType)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// DeleteMediaType removes a format from the registry.
+// Publications of this media type are not impacted. The formats registered at startup cannot be deleted, as they
+// would be registered again.
+func (h *APIHandler) DeleteMediaType(w http.ResponseWriter, r *http.Request) {
+
+	mediaType, err := h.getMediaType(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if h.builtInFormat(mediaType.Format) {
+		render.Render(w, r, ErrConflict(fmt.Errorf("the format %s is registered at startup and cannot be deleted", mediaType.Format)))
+		return
+	}
+
+	// db delete
+	err = h.store(r).MediaType().Delete(mediaType)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if err := render.Render(w, r, NewMediaTypeResponse(mediaType)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// builtInFormat tells if a format is registered at startup: a default format, or a format of the configuration
+func (h *APIHandler) builtInFormat(format string) bool {
+	for _, mt := range DefaultMediaTypes {
+		if mt.Format == format {
+			return true
+		}
+	}
+	_, ok := h.Config.Formats[format]
+	return ok
+}
+
+// getMediaType returns the registered format identified in the url
+func (h *APIHandler) getMediaType(r *http.Request) (*stor.MediaType, error) {
+	format := chi.URLParam(r, "format")
+	if format == "" {
+		return nil, errors.New("missing required format")
+	}
+	return h.store(r).MediaType().Get(format)
+}
+
+// --
+// Request and Response payloads for the REST api.
+// --
+
+// MediaTypeRequest is the request media type payload.
+type MediaTypeRequest struct {
+	*stor.MediaType
+}
+
+// MediaTypeResponse is the response media type payload.
+type MediaTypeResponse struct {
+	*stor.MediaType
+	ID        omit `json:"ID,omitempty"`
+	CreatedAt omit `json:"CreatedAt,omitempty"`
+	UpdatedAt omit `json:"UpdatedAt,omitempty"`
+	DeletedAt omit `json:"DeletedAt,omitempty"`
+}
+
+// NewMediaTypeListResponse creates a rendered list of media types
+func NewMediaTypeListResponse(mediaTypes *[]stor.MediaType) []render.Renderer {
+	list := []render.Renderer{}
+	for i := 0; i < len(*mediaTypes); i++ {
+		list = append(list, NewMediaTypeResponse(&(*mediaTypes)[i]))
+	}
+	return list
+}
+
+// NewMediaTypeResponse creates a rendered media type.
+func NewMediaTypeResponse(mediaType *stor.MediaType) *MediaTypeResponse {
+	return &MediaTypeResponse{MediaType: mediaType}
+}
+
+// Bind post-processes requests after unmarshalling.
+func (m *MediaTypeRequest) Bind(r *http.Request) error {
+	if m.MediaType == nil {
+		return errors.New("missing media type payload")
+	}
+	return m.MediaType.Validate()
+}
+
+// Render processes responses before marshalling.
+func (m *MediaTypeResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
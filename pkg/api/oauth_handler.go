@@ -0,0 +1,101 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/render"
+)
+
+// max size of a token request
+const maxTokenRequestSize = 4 << 10
+
+// IssueToken is the token endpoint of the OAuth 2.0 client credentials flow. The request is a form with
+// grant_type=client_credentials; the client authenticates with HTTP Basic credentials, or with the client_id and
+// client_secret fields. The response is a bearer token accepted on the private routes until it expires.
+// Errors are returned in the format of RFC 6749, as expected by OAuth clients.
+func (h *APIHandler) IssueToken(w http.ResponseWriter, r *http.Request) {
+
+	if h.OAuth == nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	r.Body = http.MaxBytesReader(w, r.Body, maxTokenRequestSize)
+	if err := r.ParseForm(); err != nil {
+		render.Render(w, r, ErrOAuth(http.StatusBadRequest, "invalid_request", err.Error()))
+		return
+	}
+	switch r.PostForm.Get("grant_type") {
+	case "client_credentials":
+	case "":
+		render.Render(w, r, ErrOAuth(http.StatusBadRequest, "invalid_request", "missing required grant_type"))
+		return
+	default:
+		render.Render(w, r, ErrOAuth(http.StatusBadRequest, "unsupported_grant_type", "only the client_credentials grant is supported"))
+		return
+	}
+
+	// the credentials of the Basic scheme are form-urlencoded
+	clientID, clientSecret, basic := r.BasicAuth()
+	if basic {
+		if r.PostForm.Get("client_id") != "" || r.PostForm.Get("client_secret") != "" {
+			render.Render(w, r, ErrOAuth(http.StatusBadRequest, "invalid_request", "a single client authentication method must be used"))
+			return
+		}
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	token, ttl, err := h.OAuth.Token(clientID, clientSecret)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="restricted"`)
+		render.Render(w, r, ErrOAuth(http.StatusUnauthorized, "invalid_client", err.Error()))
+		return
+	}
+
+	if err := render.Render(w, r, &TokenResponse{AccessToken: token, TokenType: "Bearer", ExpiresIn: int(ttl.Seconds())}); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// --
+// Request and Response payloads for the REST api.
+// --
+
+// TokenResponse is the response payload of the token endpoint.
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"` // lifetime of the token, in seconds
+}
+
+// OAuthErrResponse is an error of the token endpoint.
+type OAuthErrResponse struct {
+	HTTPStatusCode int    `json:"-"`
+	Code           string `json:"error"`
+	Description    string `json:"error_description,omitempty"`
+}
+
+// ErrOAuth returns an error of the token endpoint, with its RFC 6749 error code.
+func ErrOAuth(status int, code, description string) render.Renderer {
+	return &OAuthErrResponse{HTTPStatusCode: status, Code: code, Description: description}
+}
+
+// Render processes responses before marshalling.
+func (t *TokenResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Render sets the status code of the error.
+func (e *OAuthErrResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	render.Status(r, e.HTTPStatusCode)
+	return nil
+}
@@ -0,0 +1,322 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// ListOrganizations lists all organizations present in the database.
+func (h *APIHandler) ListOrganizations(w http.ResponseWriter, r *http.Request) {
+	organizations, err := h.store(r).Organization().ListAll()
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err := render.RenderList(w, r, NewOrganizationListResponse(organizations)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// CreateOrganization adds a new organization to the database.
+func (h *APIHandler) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+
+	// get the payload
+	data := &OrganizationRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	organization := data.Organization
+
+	// db create
+	err := h.store(r).Organization().Create(organization)
+	if err != nil {
+		render.Render(w, r, createError(w, r, err, organization.UUID))
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	if err := render.Render(w, r, NewOrganizationResponse(organization)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// GetOrganization returns a specific organization
+func (h *APIHandler) GetOrganization(w http.ResponseWriter, r *http.Request) {
+
+	organization, err := h.getOrganization(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err := render.Render(w, r, NewOrganizationResponse(organization)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// UpdateOrganization updates an existing organization in the database.
+func (h *APIHandler) UpdateOrganization(w http.ResponseWriter, r *http.Request) {
+
+	// get the payload
+	data := &OrganizationRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	organization := data.Organization
+
+	// get the existing organization
+	currentOrg, err := h.getOrganization(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	// the identifier of an organization is immutable, as passphrases refer to it
+	if organization.UUID != currentOrg.UUID {
+		render.Render(w, r, ErrInvalidRequest(errors.New("the organization identifier cannot be modified")))
+		return
+	}
+
+	// set the gorm fields
+	organization.ID = currentOrg.ID
+	organization.CreatedAt = currentOrg.CreatedAt
+
+	// db update
+	err = h.store(r).Organization().Update(organization)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	if err := render.Render(w, r, NewOrganizationResponse(organization)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// DeleteOrganization removes an existing organization and its passphrase pool from the database.
+func (h *APIHandler) DeleteOrganization(w http.ResponseWriter, r *http.Request) {
+
+	// get the existing organization
+	organization, err := h.getOrganization(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	// db delete
+	err = h.store(r).Organization().Delete(organization)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if err := render.Render(w, r, NewOrganizationResponse(organization)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// ListPassphrases lists the passphrase pool of an organization.
+func (h *APIHandler) ListPassphrases(w http.ResponseWriter, r *http.Request) {
+
+	organization, err := h.getOrganization(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	passphrases, err := h.store(r).Organization().ListPassphrases(organization.UUID)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err := render.RenderList(w, r, NewPassphraseListResponse(passphrases)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// AddPassphrase adds a hashed passphrase to the pool of an organization.
+func (h *APIHandler) AddPassphrase(w http.ResponseWriter, r *http.Request) {
+
+	organization, err := h.getOrganization(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	// get the payload; the organization is set from the url
+	data := &PassphraseRequest{OrganizationID: organization.UUID}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	// the hash must match the scheme declared by the CMS
+	scheme := h.Config.License.PassHashScheme(h.provider(r))
+	if err := lic.ValidatePassHash(scheme, data.PassHash); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	passphrase := &stor.Passphrase{
+		OrganizationID: organization.UUID,
+		Label:          data.Label,
+		TextHint:       data.TextHint,
+		PassHash:       data.PassHash,
+	}
+
+	// db create
+	err = h.store(r).Organization().AddPassphrase(passphrase)
+	if err != nil {
+		render.Render(w, r, createError(w, r, err, ""))
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	if err := render.Render(w, r, NewPassphraseResponse(passphrase)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// DeletePassphrase removes a passphrase from the pool of an organization.
+// Licenses already generated with this passphrase are not impacted.
+func (h *APIHandler) DeletePassphrase(w http.ResponseWriter, r *http.Request) {
+
+	organization, err := h.getOrganization(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	passphrase, err := h.store(r).Organization().GetPassphrase(organization.UUID, chi.URLParam(r, "label"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	// db delete
+	err = h.store(r).Organization().DeletePassphrase(passphrase)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if err := render.Render(w, r, NewPassphraseResponse(passphrase)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// getOrganization returns the organization identified in the url
+func (h *APIHandler) getOrganization(r *http.Request) (*stor.Organization, error) {
+	organizationID := chi.URLParam(r, "organizationID")
+	if organizationID == "" {
+		return nil, errors.New("missing required organization identifier")
+	}
+	return h.store(r).Organization().Get(organizationID)
+}
+
+// --
+// Request and Response payloads for the REST api.
+// --
+
+// OrganizationRequest is the request organization payload.
+type OrganizationRequest struct {
+	*stor.Organization
+}
+
+// OrganizationResponse is the response organization payload.
+type OrganizationResponse struct {
+	*stor.Organization
+	ID        omit `json:"ID,omitempty"`
+	CreatedAt omit `json:"CreatedAt,omitempty"`
+	UpdatedAt omit `json:"UpdatedAt,omitempty"`
+	DeletedAt omit `json:"DeletedAt,omitempty"`
+}
+
+// NewOrganizationListResponse creates a rendered list of organizations
+func NewOrganizationListResponse(organizations *[]stor.Organization) []render.Renderer {
+	list := []render.Renderer{}
+	for i := 0; i < len(*organizations); i++ {
+		list = append(list, NewOrganizationResponse(&(*organizations)[i]))
+	}
+	return list
+}
+
+// NewOrganizationResponse creates a rendered organization.
+func NewOrganizationResponse(org *stor.Organization) *OrganizationResponse {
+	return &OrganizationResponse{Organization: org}
+}
+
+// Bind post-processes requests after unmarshalling.
+func (o *OrganizationRequest) Bind(r *http.Request) error {
+	if o.Organization == nil {
+		return errors.New("missing organization payload")
+	}
+	return o.Organization.Validate()
+}
+
+// Render processes responses before marshalling.
+func (o *OrganizationResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// PassphraseRequest is the request passphrase payload.
+type PassphraseRequest struct {
+	OrganizationID string `json:"-"`
+	Label          string `json:"label"`
+	TextHint       string `json:"text_hint"`
+	PassHash       string `json:"pass_hash"`
+}
+
+// PassphraseResponse is the response passphrase payload.
+// The hash of the passphrase is never returned.
+type PassphraseResponse struct {
+	*stor.Passphrase
+	ID        omit `json:"ID,omitempty"`
+	CreatedAt omit `json:"CreatedAt,omitempty"`
+	UpdatedAt omit `json:"UpdatedAt,omitempty"`
+	DeletedAt omit `json:"DeletedAt,omitempty"`
+	PassHash  omit `json:"pass_hash,omitempty"`
+}
+
+// NewPassphraseListResponse creates a rendered list of passphrases
+func NewPassphraseListResponse(passphrases *[]stor.Passphrase) []render.Renderer {
+	list := []render.Renderer{}
+	for i := 0; i < len(*passphrases); i++ {
+		list = append(list, NewPassphraseResponse(&(*passphrases)[i]))
+	}
+	return list
+}
+
+// NewPassphraseResponse creates a rendered passphrase.
+func NewPassphraseResponse(passphrase *stor.Passphrase) *PassphraseResponse {
+	return &PassphraseResponse{Passphrase: passphrase}
+}
+
+// Bind post-processes requests after unmarshalling.
+func (p *PassphraseRequest) Bind(r *http.Request) error {
+	passphrase := stor.Passphrase{
+		OrganizationID: p.OrganizationID,
+		Label:          p.Label,
+		TextHint:       p.TextHint,
+		PassHash:       p.PassHash,
+	}
+	return passphrase.Validate()
+}
+
+// Render processes responses before marshalling.
+func (p *PassphraseResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
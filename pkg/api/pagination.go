@@ -0,0 +1,108 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/render"
+)
+
+// pagination defaults
+const (
+	DefaultPerPage = 100
+	MaxPerPage     = 1000
+)
+
+// Page is a page of a listing requested via the page and per_page query parameters.
+type Page struct {
+	Num  int // starts at 1
+	Size int
+}
+
+type pageKey struct{}
+
+// Paginate is the middleware parsing the page and per_page query parameters of a listing;
+// invalid values are rejected with a 400 status code.
+func Paginate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, err := parsePage(r)
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), pageKey{}, page)))
+	})
+}
+
+// parsePage returns the page requested, or the first page of default size.
+func parsePage(r *http.Request) (Page, error) {
+	page := Page{Num: 1, Size: DefaultPerPage}
+	var err error
+	if v := r.URL.Query().Get("page"); v != "" {
+		if page.Num, err = strconv.Atoi(v); err != nil || page.Num < 1 {
+			return page, errors.New("page must be a positive integer")
+		}
+	}
+	if v := r.URL.Query().Get("per_page"); v != "" {
+		if page.Size, err = strconv.Atoi(v); err != nil || page.Size < 1 || page.Size > MaxPerPage {
+			return page, fmt.Errorf("per_page must be an integer between 1 and %d", MaxPerPage)
+		}
+	}
+	return page, nil
+}
+
+// includeDeleted tells if a listing includes the deleted entities, as requested by the include_deleted query parameter.
+func includeDeleted(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("include_deleted")
+	if v == "" {
+		return false, nil
+	}
+	include, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.New("include_deleted must be true or false")
+	}
+	return include, nil
+}
+
+// pageOf returns the page set by the Paginate middleware, or the first page if it was not used.
+func pageOf(r *http.Request) Page {
+	if page, ok := r.Context().Value(pageKey{}).(Page); ok {
+		return page
+	}
+	page, _ := parsePage(r)
+	return page
+}
+
+// setPageHeaders sets the total number of items in the X-Total-Count header,
+// and the links to the neighbouring pages in the Link header.
+func setPageHeaders(w http.ResponseWriter, r *http.Request, page Page, total int64) {
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+	last := int((total + int64(page.Size) - 1) / int64(page.Size))
+	if last < 1 {
+		last = 1
+	}
+	link := func(num int, rel string) string {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(num))
+		q.Set("per_page", strconv.Itoa(page.Size))
+		return fmt.Sprintf("<%s?%s>; rel=\"%s\"", r.URL.Path, q.Encode(), rel)
+	}
+	links := []string{link(1, "first")}
+	if page.Num > 1 {
+		links = append(links, link(page.Num-1, "prev"))
+	}
+	if page.Num < last {
+		links = append(links, link(page.Num+1, "next"))
+	}
+	links = append(links, link(last, "last"))
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
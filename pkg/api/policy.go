@@ -0,0 +1,96 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/go-chi/render"
+)
+
+// Principal is the authenticated client of a private route, e.g. a content management system, its role,
+// and the provider it is restricted to, if any, e.g. when several publishers are hosted by the server.
+type Principal struct {
+	Name     string
+	Role     string
+	Provider string // URI of the provider
+}
+
+// levels of the roles, each role being granted the rights of the lower ones
+var roleLevels = map[string]int{conf.ROLE_READER: 1, conf.ROLE_OPERATOR: 2, conf.ROLE_ADMIN: 3}
+
+// principalKey is the context key of the principal of a request
+type principalKey struct{}
+
+// NewPrincipalContext returns a context holding the authenticated principal of a request.
+// The principal is recorded as the caller of the request in the access log.
+func NewPrincipalContext(ctx context.Context, p *Principal) context.Context {
+	if p != nil {
+		setLogCaller(ctx, p.Name)
+	}
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the authenticated principal of a request, or nil if the request was authenticated
+// by a custom middleware.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// HighestRole returns the most privileged known role of a list, or an empty string if none is known.
+func HighestRole(roles []string) string {
+	highest := ""
+	for _, role := range roles {
+		if roleLevels[role] > roleLevels[highest] {
+			highest = role
+		}
+	}
+	return highest
+}
+
+// Authorize enforces the default policy of the private routes: reading requires the reader role, and any change
+// the operator role. Routes reserved to administrators are guarded by RequireRole.
+func Authorize(next http.Handler) http.Handler {
+	reader, operator := RequireRole(conf.ROLE_READER)(next), RequireRole(conf.ROLE_OPERATOR)(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			reader.ServeHTTP(w, r)
+		default:
+			operator.ServeHTTP(w, r)
+		}
+	})
+}
+
+// RequireRole returns a middleware requiring a role, or a more privileged one, from the principal of the request.
+// The requests authenticated by a custom middleware have no principal, and are granted every role.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if p := PrincipalFromContext(r.Context()); p != nil && roleLevels[p.Role] < roleLevels[role] {
+				render.Render(w, r, ErrForbidden(fmt.Errorf("the %s role is required", role)))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Unscoped denies the principals restricted to a provider, on the routes whose resources are shared by every
+// provider, e.g. coupons or the configuration of the server.
+func Unscoped(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p := PrincipalFromContext(r.Context()); p != nil && p.Provider != "" {
+			render.Render(w, r, ErrForbidden(errors.New("the route is shared by every provider")))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
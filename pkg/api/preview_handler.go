@@ -0,0 +1,184 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/pack"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+)
+
+// default max lifetime of preview licenses, in hours
+const defaultPreviewTTL = 48
+
+// SetSample encrypts the sample of a publication, e.g. its first chapter, from the cleartext EPUB file sent as
+// the body of the request. The resources of the sample are listed by resource query parameters, as paths in the
+// EPUB file; a path ending with a slash holds the resources of a folder. The other resources are left out of the
+// sample. The sample is a publication of its own, with its own content key, created on the first call; its
+// preview licenses cannot decrypt the full publication.
+func (h *APIHandler) SetSample(w http.ResponseWriter, r *http.Request) {
+
+	publicationID, ok := h.checkUpload(w, r)
+	if !ok {
+		return
+	}
+	st := h.store(r)
+	publication, err := st.Publication().Get(publicationID)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if publication.SampleOf != "" {
+		render.Render(w, r, ErrInvalidRequest(errors.New("a sample has no sample")))
+		return
+	}
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || contentType != pack.ContentType_EPUB {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("unsupported sample type %q, samples are taken from EPUB files", r.Header.Get("Content-Type"))))
+		return
+	}
+	resources := r.URL.Query()["resource"]
+	if len(resources) == 0 {
+		render.Render(w, r, ErrInvalidRequest(errors.New("missing required resource of the sample")))
+		return
+	}
+
+	source, err := h.spoolSource(r.Body)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	defer source.remove()
+
+	// get the sample, or a new one; a sample keeps its content key, so that its preview licenses remain valid
+	sample, err := st.Publication().GetSample(publication.UUID)
+	created := err != nil
+	if created {
+		sample = &stor.Publication{UUID: uuid.New().String(), SampleOf: publication.UUID}
+	}
+	sample.Title = publication.Title
+	sample.Author = publication.Author
+
+	// encrypt and store
+	if _, err = pack.ProtectSample(r.Context(), h.tiering(publication.Provider).Hot, source, source.size, sample, resources); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err = h.validatePublication(sample); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// db create or update
+	if created {
+		err = st.Publication().Create(sample)
+	} else {
+		err = st.Publication().Update(sample)
+	}
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	if created {
+		render.Status(r, http.StatusCreated)
+	}
+	if err := render.Render(w, r, NewPublicationResponse(sample)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// GetSample returns the sample of a publication.
+func (h *APIHandler) GetSample(w http.ResponseWriter, r *http.Request) {
+
+	sample, err := h.store(r).Publication().GetSample(chi.URLParam(r, "publicationID"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err := render.Render(w, r, NewPublicationResponse(sample)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// DeleteSample removes the sample of a publication; no preview license can be generated until a new sample is set.
+// The preview licenses already issued are not impacted.
+func (h *APIHandler) DeleteSample(w http.ResponseWriter, r *http.Request) {
+
+	sample, err := h.store(r).Publication().GetSample(chi.URLParam(r, "publicationID"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	// db delete
+	err = h.store(r).Publication().Delete(sample)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if err := render.Render(w, r, NewPublicationResponse(sample)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// GeneratePreviewLicense creates a preview license of a publication and returns a fresh license.
+// The payload is a license request without publication: the license is issued for the sample of the
+// publication, starts now and expires after the max lifetime of preview licenses, or before if requested.
+// A preview license cannot be renewed past its end, and allows neither copy nor print.
+func (h *APIHandler) GeneratePreviewLicense(w http.ResponseWriter, r *http.Request) {
+
+	sample, err := h.store(r).Publication().GetSample(chi.URLParam(r, "publicationID"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	// get the payload
+	licRequest := &LicenseRequest{}
+	if err := render.DecodeJSON(r.Body, licRequest); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// the publication is the sample, the rights are set by the server
+	licRequest.PublicationID = sample.UUID
+	ttl := h.Config.License.PreviewTTL
+	if ttl == 0 {
+		ttl = defaultPreviewTTL
+	}
+	start := h.Clock().Truncate(time.Second)
+	end := start.Add(time.Duration(ttl) * time.Hour)
+	if licRequest.End != nil && licRequest.End.After(start) && licRequest.End.Before(end) {
+		end = *licRequest.End
+	}
+	none := int32(0)
+	licRequest.Start, licRequest.End = &start, &end
+	licRequest.Copy, licRequest.Print = &none, &none
+	if err := licRequest.Bind(r); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if err := h.certifyLicenseRequest(licRequest); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	h.generateLicense(w, r, licRequest, func(licInfo *stor.LicenseInfo) error {
+		licInfo.MaxEnd = licInfo.End
+		return h.store(r).License().Create(licInfo)
+	})
+}
@@ -0,0 +1,183 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// ListProviders lists all providers present in the database.
+func (h *APIHandler) ListProviders(w http.ResponseWriter, r *http.Request) {
+	providers, err := h.store(r).Provider().ListAll()
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err := render.RenderList(w, r, NewProviderListResponse(providers)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// CreateProvider declares a provider and its policy links.
+func (h *APIHandler) CreateProvider(w http.ResponseWriter, r *http.Request) {
+
+	// get the payload
+	data := &ProviderRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	provider := data.Provider
+
+	// db create
+	err := h.store(r).Provider().Create(provider)
+	if err != nil {
+		render.Render(w, r, createError(w, r, err, provider.UUID))
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	if err := render.Render(w, r, NewProviderResponse(provider)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// GetProvider returns a specific provider
+func (h *APIHandler) GetProvider(w http.ResponseWriter, r *http.Request) {
+
+	provider, err := h.getProvider(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err := render.Render(w, r, NewProviderResponse(provider)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// UpdateProvider updates the policy links of a provider.
+// Fresh licenses and status documents carry the new links right away.
+func (h *APIHandler) UpdateProvider(w http.ResponseWriter, r *http.Request) {
+
+	// get the payload
+	data := &ProviderRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	provider := data.Provider
+
+	// get the existing provider
+	currentProvider, err := h.getProvider(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if provider.UUID != currentProvider.UUID {
+		render.Render(w, r, ErrInvalidRequest(errors.New("the provider identifier cannot be modified")))
+		return
+	}
+
+	// set the gorm fields
+	provider.ID = currentProvider.ID
+	provider.CreatedAt = currentProvider.CreatedAt
+
+	// db update
+	err = h.store(r).Provider().Update(provider)
+	if err != nil {
+		render.Render(w, r, createError(w, r, err, ""))
+		return
+	}
+
+	if err := render.Render(w, r, NewProviderResponse(provider)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// DeleteProvider removes a provider from the database; its licenses no longer carry policy links.
+func (h *APIHandler) DeleteProvider(w http.ResponseWriter, r *http.Request) {
+
+	// get the existing provider
+	provider, err := h.getProvider(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	// db delete
+	err = h.store(r).Provider().Delete(provider)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if err := render.Render(w, r, NewProviderResponse(provider)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// getProvider returns the provider identified in the url
+func (h *APIHandler) getProvider(r *http.Request) (*stor.Provider, error) {
+	providerID := chi.URLParam(r, "providerID")
+	if providerID == "" {
+		return nil, errors.New("missing required provider identifier")
+	}
+	return h.store(r).Provider().Get(providerID)
+}
+
+// --
+// Request and Response payloads for the REST api.
+// --
+
+// ProviderRequest is the request provider payload.
+type ProviderRequest struct {
+	*stor.Provider
+}
+
+// ProviderResponse is the response provider payload.
+type ProviderResponse struct {
+	*stor.Provider
+	ID        omit `json:"ID,omitempty"`
+	CreatedAt omit `json:"CreatedAt,omitempty"`
+	UpdatedAt omit `json:"UpdatedAt,omitempty"`
+	DeletedAt omit `json:"DeletedAt,omitempty"`
+}
+
+// NewProviderListResponse creates a rendered list of providers
+func NewProviderListResponse(providers *[]stor.Provider) []render.Renderer {
+	list := []render.Renderer{}
+	for i := 0; i < len(*providers); i++ {
+		list = append(list, NewProviderResponse(&(*providers)[i]))
+	}
+	return list
+}
+
+// NewProviderResponse creates a rendered provider.
+func NewProviderResponse(provider *stor.Provider) *ProviderResponse {
+	return &ProviderResponse{Provider: provider}
+}
+
+// Bind post-processes requests after unmarshalling.
+func (p *ProviderRequest) Bind(r *http.Request) error {
+	if p.Provider == nil {
+		return errors.New("missing provider payload")
+	}
+	return p.Provider.Validate()
+}
+
+// Render processes responses before marshalling.
+func (p *ProviderResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
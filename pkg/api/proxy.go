@@ -0,0 +1,261 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+)
+
+type contextKey int
+
+const (
+	baseURLKey contextKey = iota
+	clientAddrKey
+)
+
+// ProxyHeaders returns a middleware which computes the public base url of the server
+// from the Forwarded or X-Forwarded-* headers set by a trusted reverse proxy, as selected by headers.
+// Headers sent by other clients are ignored, as they could be used to inject links in documents,
+// and so are the headers of the other kind, which a proxy passes on from its client.
+// The base path of the server is appended to the path prefix declared by the proxy.
+// The address of the client declared by a trusted proxy is recorded as well.
+func ProxyHeaders(basePath string, trustedProxies []string, headers string) (func(http.Handler) http.Handler, error) {
+
+	trusted := make([]*net.IPNet, 0, len(trustedProxies))
+	for _, p := range trustedProxies {
+		if !strings.Contains(p, "/") {
+			if strings.Contains(p, ":") {
+				p += "/128"
+			} else {
+				p += "/32"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", p, err)
+		}
+		trusted = append(trusted, ipnet)
+	}
+	switch headers {
+	case "":
+		headers = conf.PROXY_X_FORWARDED
+	case conf.PROXY_X_FORWARDED, conf.PROXY_FORWARDED:
+	default:
+		return nil, fmt.Errorf("invalid proxy headers %q", headers)
+	}
+	basePath = strings.TrimSuffix(basePath, "/")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isTrusted(r.RemoteAddr, trusted) {
+				hops := forwardedHops(r, headers)
+				if proto, host, prefix := forwardedHeaders(r, hops, trusted); host != "" {
+					if proto == "" {
+						proto = "http"
+					}
+					baseURL := proto + "://" + host + strings.TrimSuffix(prefix, "/") + basePath
+					r = r.WithContext(context.WithValue(r.Context(), baseURLKey, baseURL))
+				}
+				if addr, _ := forwardedFor(hops, trusted); addr != "" {
+					r = r.WithContext(context.WithValue(r.Context(), clientAddrKey, addr))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// isTrusted indicates if a remote address belongs to a trusted proxy.
+func isTrusted(remoteAddr string, trusted []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return isTrustedIP(net.ParseIP(host), trusted)
+}
+
+// isTrustedIP indicates if an IP address belongs to a trusted proxy.
+func isTrustedIP(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range trusted {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHop is the request received by a proxy of a chain: the address of its client,
+// and the protocol and host requested by this client.
+type forwardedHop struct {
+	addr, proto, host string
+}
+
+// forwardedHops returns the requests received by the proxies of a chain, from the first one.
+// Each proxy appends its hop to the list sent by its client, whose first values are therefore chosen by
+// the client. With X-Forwarded-* headers, the values of the hosts and protocols are matched to the addresses
+// from the right, as a proxy may set them without appending.
+func forwardedHops(r *http.Request, headers string) []forwardedHop {
+
+	var hops []forwardedHop
+	if headers == conf.PROXY_FORWARDED {
+		for _, fwd := range r.Header.Values("Forwarded") {
+			for _, element := range strings.Split(fwd, ",") {
+				var hop forwardedHop
+				for _, pair := range strings.Split(element, ";") {
+					kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+					if len(kv) != 2 {
+						continue
+					}
+					value := strings.Trim(kv[1], `"`)
+					switch strings.ToLower(kv[0]) {
+					case "for":
+						hop.addr = value
+					case "proto":
+						hop.proto = value
+					case "host":
+						hop.host = value
+					}
+				}
+				hops = append(hops, hop)
+			}
+		}
+		return hops
+	}
+
+	addrs := headerValues(r, "X-Forwarded-For")
+	protos := headerValues(r, "X-Forwarded-Proto")
+	hosts := headerValues(r, "X-Forwarded-Host")
+	n := len(addrs)
+	if len(protos) > n {
+		n = len(protos)
+	}
+	if len(hosts) > n {
+		n = len(hosts)
+	}
+	hops = make([]forwardedHop, n)
+	for i := 1; i <= n; i++ {
+		hop := &hops[n-i]
+		if i <= len(addrs) {
+			hop.addr = addrs[len(addrs)-i]
+		}
+		if i <= len(protos) {
+			hop.proto = protos[len(protos)-i]
+		}
+		if i <= len(hosts) {
+			hop.host = hosts[len(hosts)-i]
+		}
+	}
+	return hops
+}
+
+// forwardedHeaders returns the protocol, host and path prefix of the original request.
+// They are those of the hop of the client, or else of the nearest hop to its right, all of them being set by
+// trusted proxies. Path-rewriting proxies declare the stripped prefix in X-Forwarded-Prefix.
+func forwardedHeaders(r *http.Request, hops []forwardedHop, trusted []*net.IPNet) (proto, host, prefix string) {
+
+	_, client := forwardedFor(hops, trusted)
+	for i := client; i >= 0 && i < len(hops); i++ {
+		if hops[i].host != "" {
+			proto, host = hops[i].proto, hops[i].host
+			break
+		}
+	}
+	if values := headerValues(r, "X-Forwarded-Prefix"); len(values) > 0 {
+		prefix = values[len(values)-1]
+	}
+
+	// protect the generated links against malformed values
+	if proto != "http" && proto != "https" {
+		proto = ""
+	}
+	if strings.ContainsAny(host, "/\\ ") || strings.ContainsAny(prefix, "\\ ?#") {
+		return "", "", ""
+	}
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	return proto, host, prefix
+}
+
+// forwardedFor returns the IP address of the client of the original request, or an empty string,
+// and the index of its hop. The hops are walked from the right, skipping the trusted proxies, and the
+// first address which is not a trusted proxy is the client.
+func forwardedFor(hops []forwardedHop, trusted []*net.IPNet) (string, int) {
+
+	client := ""
+	i := len(hops) - 1
+	for ; i >= 0; i-- {
+		addr := hops[i].addr
+		// RFC 7239 quotes IPv6 addresses in brackets, possibly with a port
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+		addr = strings.Trim(addr, "[]")
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			// an address hidden by a proxy, e.g. "unknown": the following ones can't be trusted
+			return "", i
+		}
+		client = addr
+		if !isTrustedIP(ip, trusted) || i == 0 {
+			break
+		}
+	}
+	return client, i
+}
+
+// clientAddr returns the IP address of the client of a request, as declared by a trusted proxy,
+// or the remote address of the request.
+func clientAddr(r *http.Request) string {
+	if addr, ok := r.Context().Value(clientAddrKey).(string); ok {
+		return addr
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// headerValues returns the comma-separated values of every occurrence of a header.
+func headerValues(r *http.Request, name string) []string {
+	var values []string
+	for _, header := range r.Header.Values(name) {
+		for _, value := range strings.Split(header, ",") {
+			values = append(values, strings.TrimSpace(value))
+		}
+	}
+	return values
+}
+
+// publicBaseURL returns the public base url of the server, as seen by the client:
+// the url computed from the headers of a trusted proxy, or the configured url.
+func (h *APIHandler) publicBaseURL(r *http.Request) string {
+	if baseURL, ok := r.Context().Value(baseURLKey).(string); ok {
+		return baseURL
+	}
+	return h.Config.PublicBaseUrl
+}
+
+// requestConfig returns the configuration applicable to a request,
+// i.e. the server configuration with the public base url seen by the client.
+func (h *APIHandler) requestConfig(r *http.Request) *conf.Config {
+	baseURL := h.publicBaseURL(r)
+	if baseURL == h.Config.PublicBaseUrl {
+		return h.Config
+	}
+	c := *h.Config
+	c.PublicBaseUrl = baseURL
+	return &c
+}
@@ -5,65 +5,122 @@
 package api
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/edrlab/lcp-server/pkg/stor"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 )
 
-// ListPublications lists all publications present in the database.
+// ListPublications lists a page of the publications present in the database,
+// including the deleted ones if include_deleted is set.
 func (h *APIHandler) ListPublications(w http.ResponseWriter, r *http.Request) {
-	publications, err := h.Store.Publication().ListAll()
+	page := pageOf(r)
+	order, err := stor.PublicationOrder(r.URL.Query().Get("sort"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	repo := h.store(r).Publication().Sorted(order)
+	withDeleted, err := includeDeleted(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if withDeleted {
+		repo = repo.WithDeleted()
+	}
+	publications, err := repo.List(page.Size, page.Num)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	total, err := repo.Count()
 	if err != nil {
 		render.Render(w, r, ErrRender(err))
 		return
 	}
+	setPageHeaders(w, r, page, total)
 	if err := render.RenderList(w, r, NewPublicationListResponse(publications)); err != nil {
 		render.Render(w, r, ErrRender(err))
 		return
 	}
 }
 
-// SearchPublications searches publications corresponding to a specific criteria.
+// SearchPublications searches publications by format and by text; the criteria set are combined.
+// The results are limited, see SearchLimits.
 func (h *APIHandler) SearchPublications(w http.ResponseWriter, r *http.Request) {
-	var publications *[]stor.Publication
-	var err error
-
-	// by format
-	if format := r.URL.Query().Get("format"); format != "" {
-		var contentType string
-		switch format {
-		case "epub":
-			contentType = "application/epub+zip"
-		case "lcpdf":
-			contentType = "application/pdf+lcp"
-		case "lcpau":
-			contentType = "application/audiobook+lcp"
-		case "lcpdi":
-			contentType = "application/divina+lcp"
-		default:
-			err = errors.New("invalid content type query string parameter")
-		}
-		if contentType != "" {
-			publications, err = h.Store.Publication().FindByType(contentType)
-		}
-	} else {
+	order, err := stor.PublicationOrder(r.URL.Query().Get("sort"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	query, ok := h.publicationQuery(r)
+	if !ok || query.IsEmpty() {
 		render.Render(w, r, ErrNotFound)
 		return
 	}
+	search, err := h.searchLimits().start(r)
 	if err != nil {
-		render.Render(w, r, ErrNotFound)
+		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
+
+	// one more result tells if the results are truncated
+	query.Limit = search.limit + 1
+	publications, err := h.store(r).Publication().Sorted(order).Find(query)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	*publications = (*publications)[:search.done(w, r, len(*publications))]
 	if err := render.RenderList(w, r, NewPublicationListResponse(publications)); err != nil {
 		render.Render(w, r, ErrRender(err))
 		return
 	}
 }
 
-// CreatePublication adds a new Publication to the database.
+// ExportPublications streams every publication corresponding to the criteria of a search, without limit, as
+// newline-delimited JSON in the order of creation.
+func (h *APIHandler) ExportPublications(w http.ResponseWriter, r *http.Request) {
+	query, ok := h.publicationQuery(r)
+	if !ok || query.IsEmpty() {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	out := newNDJSONWriter(w)
+	err := h.store(r).Publication().Stream(query, func(publication *stor.Publication) error {
+		return out.write(NewPublicationResponse(publication))
+	})
+	if err != nil {
+		// the status is already sent, the client gets a truncated stream
+		h.Logger.Errorf("Export of the publications interrupted after %d publications: %v", out.written, err)
+	}
+	out.flush()
+}
+
+// publicationQuery returns the criteria of a publication search; it fails if the format is not declared
+// in the media type registry
+func (h *APIHandler) publicationQuery(r *http.Request) (stor.PublicationQuery, bool) {
+	query := stor.PublicationQuery{Text: strings.TrimSpace(r.URL.Query().Get("q"))}
+	if format := r.URL.Query().Get("format"); format != "" {
+		mediaType, err := h.store(r).MediaType().Get(format)
+		if err != nil {
+			return query, false
+		}
+		query.ContentType = mediaType.ContentType
+	}
+	return query, true
+}
+
+// CreatePublication adds a new Publication to the database. A publication whose uuid is already in use
+// is a conflict, unless the upsert query parameter is set: the existing publication is then updated,
+// so that an ingestion can be safely retried.
 func (h *APIHandler) CreatePublication(w http.ResponseWriter, r *http.Request) {
 
 	// get the payload
@@ -73,11 +130,34 @@ func (h *APIHandler) CreatePublication(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	publication := data.Publication
+	if err := h.certifyPublication(publication); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
 
 	// db create
-	err := h.Store.Publication().Create(publication)
+	err := h.store(r).Publication().Create(publication)
+	if errors.Is(err, stor.ErrDuplicate) && r.URL.Query().Get("upsert") == "true" {
+		var currentPub *stor.Publication
+		if currentPub, err = h.store(r).Publication().Get(publication.UUID); err == nil {
+			if err = mergePublication(publication, currentPub); err != nil {
+				render.Render(w, r, ErrConflict(err))
+				return
+			}
+			if err = h.store(r).Publication().Update(publication); err != nil {
+				render.Render(w, r, ErrRender(err))
+				return
+			}
+			if err := render.Render(w, r, NewPublicationResponse(publication)); err != nil {
+				render.Render(w, r, ErrRender(err))
+			}
+			return
+		}
+		// a deleted publication still holds its uuid
+		err = stor.ErrDuplicate
+	}
 	if err != nil {
-		render.Render(w, r, ErrRender(err))
+		render.Render(w, r, createError(w, r, err, publication.UUID))
 		return
 	}
 
@@ -95,7 +175,7 @@ func (h *APIHandler) GetPublication(w http.ResponseWriter, r *http.Request) {
 	var err error
 
 	if publicationID := chi.URLParam(r, "publicationID"); publicationID != "" {
-		publication, err = h.Store.Publication().Get(publicationID)
+		publication, err = h.store(r).Publication().Get(publicationID)
 	} else {
 		render.Render(w, r, ErrInvalidRequest(errors.New("missing required publication identifier")))
 		return
@@ -110,40 +190,60 @@ func (h *APIHandler) GetPublication(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// UpdatePublication updates an existing Publication in the database.
+// UpdatePublication creates or updates a publication, identified by the uuid of the url, so that a catalog
+// can be synchronized without knowing which publications exist. The fields omitted by the payload keep
+// their current values, and the encryption key of an existing publication cannot change, as the licenses
+// already issued depend on it.
 func (h *APIHandler) UpdatePublication(w http.ResponseWriter, r *http.Request) {
 
-	// get the payload
-	data := &PublicationRequest{}
-	if err := render.Bind(r, data); err != nil {
-		render.Render(w, r, ErrInvalidRequest(err))
+	publicationID := chi.URLParam(r, "publicationID")
+	if publicationID == "" {
+		render.Render(w, r, ErrNotFound)
 		return
 	}
-	publication := data.Publication
 
-	var currentPub *stor.Publication
-	var err error
-
-	// get the existing publication
-	if publicationID := chi.URLParam(r, "publicationID"); publicationID != "" {
-		currentPub, err = h.Store.Publication().Get(publicationID)
-	} else {
-		render.Render(w, r, ErrNotFound)
+	// get the payload, which is validated once merged with the existing publication
+	publication := &stor.Publication{}
+	if err := render.DecodeJSON(r.Body, publication); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
+	if publication.UUID == "" {
+		publication.UUID = publicationID
+	} else if publication.UUID != publicationID {
+		render.Render(w, r, ErrInvalidRequest(errors.New("the uuid of the payload differs from the uuid of the url")))
+		return
+	}
+
+	// get the existing publication, or create it
+	currentPub, err := h.store(r).Publication().Get(publicationID)
 	if err != nil {
-		render.Render(w, r, ErrNotFound)
+		if err := h.validatePublication(publication); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		if err := h.store(r).Publication().Create(publication); err != nil {
+			render.Render(w, r, createError(w, r, err, ""))
+			return
+		}
+		render.Status(r, http.StatusCreated)
+		if err := render.Render(w, r, NewPublicationResponse(publication)); err != nil {
+			render.Render(w, r, ErrRender(err))
+		}
 		return
 	}
 
-	// set the gorm fields
-	publication.ID = currentPub.ID
-	publication.CreatedAt = currentPub.CreatedAt
-	//publication.UpdatedAt = currentPub.UpdatedAt
-	//publication.DeletedAt = currentPub.DeletedAt
+	if err = mergePublication(publication, currentPub); err != nil {
+		render.Render(w, r, ErrConflict(err))
+		return
+	}
+	if err = h.validatePublication(publication); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
 
 	// db update
-	err = h.Store.Publication().Update(publication)
+	err = h.store(r).Publication().Update(publication)
 	if err != nil {
 		render.Render(w, r, ErrRender(err))
 		return
@@ -155,6 +255,59 @@ func (h *APIHandler) UpdatePublication(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// errKeyChange is returned when an update would change the encryption key of a publication
+var errKeyChange = errors.New("the encryption key of a publication cannot change, the licenses already issued depend on it")
+
+// mergePublication sets the fields of an updated publication which are maintained by the database and the server,
+// and the fields omitted by the update. The encryption key of a publication is immutable once set.
+func mergePublication(publication, currentPub *stor.Publication) error {
+
+	// protect the encryption key
+	if len(publication.EncryptionKey) == 0 {
+		publication.EncryptionKey = currentPub.EncryptionKey
+	} else if len(currentPub.EncryptionKey) > 0 && !bytes.Equal(publication.EncryptionKey, currentPub.EncryptionKey) {
+		return errKeyChange
+	}
+
+	// set the gorm fields
+	publication.ID = currentPub.ID
+	publication.CreatedAt = currentPub.CreatedAt
+	//publication.UpdatedAt = currentPub.UpdatedAt
+	//publication.DeletedAt = currentPub.DeletedAt
+
+	// keep the omitted fields
+	if publication.Title == "" {
+		publication.Title = currentPub.Title
+	}
+	if publication.Author == "" {
+		publication.Author = currentPub.Author
+	}
+	if publication.Location == "" {
+		publication.Location = currentPub.Location
+	}
+	if publication.ContentType == "" {
+		publication.ContentType = currentPub.ContentType
+	}
+	if publication.Size == 0 {
+		publication.Size = currentPub.Size
+	}
+	if publication.SourceSize == 0 {
+		publication.SourceSize = currentPub.SourceSize
+	}
+	if publication.Checksum == "" {
+		publication.Checksum = currentPub.Checksum
+	}
+
+	// keep the storage info maintained by the server
+	if publication.StorageKey == "" {
+		publication.StorageKey = currentPub.StorageKey
+	}
+	publication.Tier = currentPub.Tier
+	publication.LastFulfilled = currentPub.LastFulfilled
+	publication.SampleOf = currentPub.SampleOf
+	return nil
+}
+
 // DeletePublication removes an existing Publication from the database.
 func (h *APIHandler) DeletePublication(w http.ResponseWriter, r *http.Request) {
 
@@ -163,7 +316,7 @@ func (h *APIHandler) DeletePublication(w http.ResponseWriter, r *http.Request) {
 
 	// get the existing publication
 	if publicationID := chi.URLParam(r, "publicationID"); publicationID != "" {
-		publication, err = h.Store.Publication().Get(publicationID)
+		publication, err = h.store(r).Publication().Get(publicationID)
 	} else {
 		render.Render(w, r, ErrNotFound)
 		return
@@ -174,7 +327,7 @@ func (h *APIHandler) DeletePublication(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// db delete
-	err = h.Store.Publication().Delete(publication)
+	err = h.store(r).Publication().Delete(publication)
 	if err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
@@ -186,6 +339,40 @@ func (h *APIHandler) DeletePublication(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// RestorePublication undoes the deletion of a publication, so that new licenses can be generated for it.
+// A publication whose stored files were removed in the meantime, e.g. by a garbage collection, is not restored.
+func (h *APIHandler) RestorePublication(w http.ResponseWriter, r *http.Request) {
+
+	publicationID := chi.URLParam(r, "publicationID")
+	deleted, err := h.store(r).Publication().WithDeleted().Get(publicationID)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if deleted.DeletedAt.Valid && h.Tiering != nil {
+		missing, err := h.tiering(deleted.Provider).Missing(r.Context(), deleted)
+		if err != nil {
+			render.Render(w, r, ErrUnavailable(err))
+			return
+		}
+		if len(missing) > 0 {
+			render.Render(w, r, ErrConflict(fmt.Errorf("the stored files of the publication were removed: %s", strings.Join(missing, ", "))))
+			return
+		}
+	}
+
+	publication, err := h.store(r).Publication().Restore(publicationID)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	if err := render.Render(w, r, NewPublicationResponse(publication)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
 // --
 // Request and Response payloads for the REST api.
 // --
@@ -200,10 +387,11 @@ type PublicationRequest struct {
 // PublicationResponse is the response publication payload.
 type PublicationResponse struct {
 	*stor.Publication
-	ID        omit `json:"ID,omitempty"`
-	CreatedAt omit `json:"CreatedAt,omitempty"`
-	UpdatedAt omit `json:"UpdatedAt,omitempty"`
-	DeletedAt omit `json:"DeletedAt,omitempty"`
+	ID        omit       `json:"ID,omitempty"`
+	CreatedAt omit       `json:"CreatedAt,omitempty"`
+	UpdatedAt omit       `json:"UpdatedAt,omitempty"`
+	DeletedAt omit       `json:"DeletedAt,omitempty"`
+	Deleted   *time.Time `json:"deleted_at,omitempty"` // set for deleted publications, listed with include_deleted
 }
 
 // NewPublicationListResponse creates a rendered list of publications
@@ -217,7 +405,11 @@ func NewPublicationListResponse(publications *[]stor.Publication) []render.Rende
 
 // NewPublicationResponse creates a rendered publication.
 func NewPublicationResponse(pub *stor.Publication) *PublicationResponse {
-	return &PublicationResponse{Publication: pub}
+	response := &PublicationResponse{Publication: pub}
+	if pub.DeletedAt.Valid {
+		response.Deleted = &pub.DeletedAt.Time
+	}
+	return response
 }
 
 // Bind post-processes requests after unmarshalling.
@@ -0,0 +1,67 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+)
+
+// ErrRateLimited is returned when a client exceeds the rate limit of a route.
+var ErrRateLimited = errors.New("rate limit exceeded, retry later")
+
+// RateLimiter caps the number of requests of each client address per minute, e.g. on public pages
+// which could be crawled or used to probe license identifiers. Counts are reset every minute.
+type RateLimiter struct {
+	perMinute int
+	clock     func() time.Time
+
+	mu     sync.Mutex
+	window time.Time      // start of the current window
+	counts map[string]int // requests of the current window, by client address
+}
+
+// NewRateLimiter creates a rate limiter allowing perMinute requests per client address.
+func NewRateLimiter(perMinute int) *RateLimiter {
+	return &RateLimiter{
+		perMinute: perMinute,
+		clock:     time.Now,
+		counts:    make(map[string]int),
+	}
+}
+
+// Handler is the middleware rejecting the requests exceeding the rate limit with a 429 status code.
+func (l *RateLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wait := l.allow(clientAddr(r)); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			render.Render(w, r, ErrTooManyRequests(ErrRateLimited))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow counts a request of a client, and returns the time to wait if the client exceeded its limit.
+func (l *RateLimiter) allow(addr string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock()
+	if now.Sub(l.window) >= time.Minute {
+		l.window = now.Truncate(time.Minute)
+		l.counts = make(map[string]int)
+	}
+	if l.counts[addr] >= l.perMinute {
+		return l.window.Add(time.Minute).Sub(now)
+	}
+	l.counts[addr]++
+	return 0
+}
@@ -0,0 +1,49 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/render"
+)
+
+// StorageReport returns the storage used by the publications managed by the server, per provider and tier.
+// A client restricted to a provider gets the storage used by its publications.
+func (h *APIHandler) StorageReport(w http.ResponseWriter, r *http.Request) {
+	usage, err := h.store(r).Publication().StorageUsage()
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err := render.RenderList(w, r, NewStorageUsageListResponse(usage)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// --
+// Request and Response payloads for the REST api.
+// --
+
+// StorageUsageResponse is the response payload for storage usage.
+type StorageUsageResponse struct {
+	*stor.StorageUsage
+}
+
+// NewStorageUsageListResponse creates a rendered list of storage usage
+func NewStorageUsageListResponse(usage *[]stor.StorageUsage) []render.Renderer {
+	list := []render.Renderer{}
+	for i := 0; i < len(*usage); i++ {
+		list = append(list, &StorageUsageResponse{StorageUsage: &(*usage)[i]})
+	}
+	return list
+}
+
+// Render processes responses before marshalling.
+func (u *StorageUsageResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
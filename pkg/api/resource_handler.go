@@ -0,0 +1,240 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/slo"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// SetResources records the resources of a multi-part publication, e.g. the tracks of an audiobook,
+// replacing the previous ones.
+func (h *APIHandler) SetResources(w http.ResponseWriter, r *http.Request) {
+
+	publication, err := h.store(r).Publication().Get(chi.URLParam(r, "publicationID"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	// get the payload
+	data := &ResourceListRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// db update
+	if err = h.store(r).Publication().SetResources(publication.UUID, data.Resources); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	if err := render.RenderList(w, r, NewResourceListResponse(&data.Resources)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// ListResources lists the resources of a publication.
+func (h *APIHandler) ListResources(w http.ResponseWriter, r *http.Request) {
+
+	resources, err := h.store(r).Publication().ListResources(chi.URLParam(r, "publicationID"))
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err := render.RenderList(w, r, NewResourceListResponse(resources)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// GetManifest returns the manifest of a multi-part publication, listing its resources in reading order.
+// Reading apps use it for progressive download.
+func (h *APIHandler) GetManifest(w http.ResponseWriter, r *http.Request) {
+
+	publication, err := h.store(r).Publication().Get(chi.URLParam(r, "publicationID"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	slo.SetTenant(r.Context(), publication.Provider)
+	resources, err := h.store(r).Publication().ListResources(publication.UUID)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if len(*resources) == 0 {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	links := lic.NewLinkBuilder(h.requestConfig(r), publication.Provider)
+	manifest := &ManifestResponse{
+		Context: "https://readium.org/webpub-manifest/context.jsonld",
+		Metadata: ManifestMetadata{
+			Identifier: "urn:uuid:" + publication.UUID,
+			Title:      publication.Title,
+		},
+		ReadingOrder: []ManifestLink{},
+	}
+	for _, res := range *resources {
+		manifest.Metadata.Duration += res.Duration
+		manifest.ReadingOrder = append(manifest.ReadingOrder, ManifestLink{
+			Href:     links.Content(publication.UUID, res.Position),
+			Type:     res.ContentType,
+			Title:    res.Href,
+			Duration: res.Duration,
+			Properties: &ManifestProperties{
+				Size:     res.Size,
+				Checksum: res.Checksum,
+			},
+		})
+	}
+
+	if err := render.Render(w, r, manifest); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// StreamResource serves a resource of a publication, with support of range requests.
+func (h *APIHandler) StreamResource(w http.ResponseWriter, r *http.Request) {
+
+	if h.Tiering == nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	position, err := strconv.Atoi(chi.URLParam(r, "position"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(errors.New("invalid resource position")))
+		return
+	}
+	publication, err := h.store(r).Publication().Get(chi.URLParam(r, "publicationID"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	slo.SetTenant(r.Context(), publication.Provider)
+	resource, err := h.store(r).Publication().GetResource(publication.UUID, position)
+	if err != nil || resource.StorageKey == "" {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	// record the fulfillment, so that the publication is not archived while streamed, and rehydrate an archived
+	// publication
+	if err = h.fulfill(r, publication); err != nil {
+		render.Render(w, r, ErrUnavailable(err))
+		return
+	}
+
+	rc, err := h.tiering(publication.Provider).Hot.Get(r.Context(), resource.StorageKey)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	defer rc.Close()
+	if isDownload(r) {
+		h.recordUsage(r, publication.UUID, 0, 1)
+	}
+
+	w.Header().Set("Content-Type", resource.ContentType)
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", resource.UpdatedAt, rs)
+		return
+	}
+	// storage without random access, no range support
+	w.Header().Set("Content-Length", strconv.FormatInt(resource.Size, 10))
+	io.Copy(w, rc)
+}
+
+// --
+// Request and Response payloads for the REST api.
+// --
+
+// ResourceListRequest is the request payload for the resources of a publication.
+type ResourceListRequest struct {
+	Resources []stor.Resource `json:"resources"`
+}
+
+// Bind post-processes requests after unmarshalling.
+func (l *ResourceListRequest) Bind(r *http.Request) error {
+	positions := make(map[int]bool)
+	for i := range l.Resources {
+		if err := l.Resources[i].Validate(); err != nil {
+			return err
+		}
+		if positions[l.Resources[i].Position] {
+			return fmt.Errorf("duplicate resource position %d", l.Resources[i].Position)
+		}
+		positions[l.Resources[i].Position] = true
+	}
+	return nil
+}
+
+// ResourceResponse is the response payload for a resource.
+type ResourceResponse struct {
+	*stor.Resource
+	ID        omit `json:"ID,omitempty"`
+	CreatedAt omit `json:"CreatedAt,omitempty"`
+	UpdatedAt omit `json:"UpdatedAt,omitempty"`
+	DeletedAt omit `json:"DeletedAt,omitempty"`
+}
+
+// NewResourceListResponse creates a rendered list of resources
+func NewResourceListResponse(resources *[]stor.Resource) []render.Renderer {
+	list := []render.Renderer{}
+	for i := 0; i < len(*resources); i++ {
+		list = append(list, &ResourceResponse{Resource: &(*resources)[i]})
+	}
+	return list
+}
+
+// Render processes responses before marshalling.
+func (res *ResourceResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// ManifestResponse is a Readium Web Publication Manifest listing the resources of a publication.
+type ManifestResponse struct {
+	Context      string           `json:"@context"`
+	Metadata     ManifestMetadata `json:"metadata"`
+	ReadingOrder []ManifestLink   `json:"readingOrder"`
+}
+
+type ManifestMetadata struct {
+	Identifier string  `json:"identifier"`
+	Title      string  `json:"title,omitempty"`
+	Duration   float64 `json:"duration,omitempty"`
+}
+
+type ManifestLink struct {
+	Href       string              `json:"href"`
+	Type       string              `json:"type"`
+	Title      string              `json:"title,omitempty"`
+	Duration   float64             `json:"duration,omitempty"`
+	Properties *ManifestProperties `json:"properties,omitempty"`
+}
+
+type ManifestProperties struct {
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+// Render processes responses before marshalling.
+func (m *ManifestResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
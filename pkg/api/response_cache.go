@@ -0,0 +1,105 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/cache"
+	"github.com/edrlab/lcp-server/pkg/slo"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// maxCachedResponse is the max size of a cached response
+const maxCachedResponse = 256 * 1024
+
+// CacheResponses caches the successful responses of a route for a time, by the resource identified by a url
+// parameter, e.g. the status document of a license. A response is served from the cache to the requests of the
+// same url, seen through the same proxy. The cache is bypassed if it is disabled or fails.
+func (h *APIHandler) CacheResponses(key func(id string) string, param string, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if h.Cache == nil || ttl <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+			k := key(chi.URLParam(r, param))
+			variant := h.publicBaseURL(r) + r.URL.RequestURI()
+			data, err := h.Cache.Get(r.Context(), k)
+			if err != nil && !errors.Is(err, cache.ErrMiss) {
+				h.logger(r).Warningf("Failed to read the response cache: %v", err)
+			}
+			if err == nil {
+				if contentType, tenant, body, ok := decodeResponse(data, variant); ok {
+					slo.SetTenant(r.Context(), tenant)
+					w.Header().Set("Content-Type", contentType)
+					w.Write(body)
+					return
+				}
+			}
+
+			var buf bytes.Buffer
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ww.Tee(&buf)
+			next.ServeHTTP(ww, r)
+			if (ww.Status() != 0 && ww.Status() != http.StatusOK) || buf.Len() == 0 || buf.Len() > maxCachedResponse {
+				return
+			}
+			data = encodeResponse(variant, ww.Header().Get("Content-Type"), slo.TenantFromContext(r.Context()), buf.Bytes())
+			if err = h.Cache.Set(r.Context(), k, data, ttl); err != nil {
+				h.logger(r).Warningf("Failed to write the response cache: %v", err)
+			}
+		})
+	}
+}
+
+// InvalidateCache drops the cached responses of the resource identified by a url parameter once it is changed by
+// a request, whatever its outcome.
+func (h *APIHandler) InvalidateCache(key func(id string) string, param string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if h.Cache == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				return
+			}
+			if id := chi.URLParam(r, param); id != "" {
+				if err := h.Cache.Delete(r.Context(), key(id)); err != nil {
+					h.logger(r).Errorf("Failed to invalidate the response cache of %s: %v", id, err)
+				}
+			}
+		})
+	}
+}
+
+// encodeResponse encodes a cached response: its url, content type and tenant on a line each, then its body
+func encodeResponse(variant, contentType, tenant string, body []byte) []byte {
+	data := make([]byte, 0, len(variant)+len(contentType)+len(tenant)+3+len(body))
+	data = append(data, variant...)
+	data = append(data, '\n')
+	data = append(data, contentType...)
+	data = append(data, '\n')
+	data = append(data, tenant...)
+	data = append(data, '\n')
+	return append(data, body...)
+}
+
+// decodeResponse decodes a cached response, if it was requested at a url
+func decodeResponse(data []byte, variant string) (contentType, tenant string, body []byte, ok bool) {
+	parts := bytes.SplitN(data, []byte{'\n'}, 4)
+	if len(parts) != 4 || string(parts[0]) != variant {
+		return "", "", nil, false
+	}
+	return string(parts[1]), string(parts[2]), parts[3], true
+}
@@ -0,0 +1,310 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/job"
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+)
+
+// JOB_ROSTER creates a license for each student of a roster; such jobs are started by CreateRoster.
+const JOB_ROSTER = "roster"
+
+// max size of a roster file, and of the license template of a multipart roster
+const (
+	maxRosterSize   = 10 << 20
+	maxTemplateSize = 64 << 10
+)
+
+// CreateRoster starts a job creating a license of the same publication for every student of a class roster,
+// and returns the job. The licenses are built from a license template, without identifier nor user.
+// The payload is a JSON roster request, or a multipart/form-data request holding the template as a "license"
+// JSON field and the roster as a "roster" file. The roster file is a CSV file whose first row names the
+// columns, one of them being user_id, or a JSON array of students; the other columns are ignored.
+// The delivery report of the roster is then given by GetRoster.
+func (h *APIHandler) CreateRoster(w http.ResponseWriter, r *http.Request) {
+
+	// get the payload
+	roster := &RosterRequest{}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var err error
+	if contentType == "multipart/form-data" {
+		err = decodeRosterForm(r, roster)
+	} else {
+		err = render.DecodeJSON(r.Body, roster)
+	}
+	if err == nil {
+		err = roster.Bind(r)
+	}
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// check the template, as the license of a placeholder student
+	template := *roster.License
+	if template.Provider == "" {
+		template.Provider = h.provider(r)
+	}
+	template.UUID, template.UserID = uuid.New().String(), roster.Students[0].UserID
+	h.initLicense(&template)
+	if err := template.Validate(); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if _, err := h.store(r).Publication().Get(template.PublicationID); err != nil {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("unknown publication %s", template.PublicationID)))
+		return
+	}
+	if err := h.certifyLicenseInfo(&template); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	items := make([]json.RawMessage, len(roster.Students))
+	for i, student := range roster.Students {
+		items[i], _ = json.Marshal(student)
+	}
+	started, err := h.Jobs.Start(JOB_ROSTER, items, h.rosterItem(h.requestConfig(r), template))
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	render.Status(r, http.StatusAccepted)
+	if err := render.Render(w, r, NewJobResponse(started)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// GetRoster returns the delivery report of a roster: the job, whose results give the license and the links
+// delivered to each processed student. The report is a CSV file, one row per student, if format=csv.
+func (h *APIHandler) GetRoster(w http.ResponseWriter, r *http.Request) {
+
+	job, err := h.store(r).Job().Get(chi.URLParam(r, "jobID"))
+	if err != nil || job.Type != JOB_ROSTER {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="roster-`+job.UUID+`.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"index", "user_id", "status", "license_id", "license", "status_document", "self_service", "error"})
+		for _, res := range job.Results {
+			cw.Write([]string{strconv.Itoa(res.Index), res.UserID, strconv.Itoa(res.Status), res.UUID,
+				res.Links["license"], res.Links["status"], res.Links["self_service"], res.Error})
+		}
+		cw.Flush()
+		return
+	default:
+		render.Render(w, r, ErrInvalidRequest(errors.New("the format must be json or csv")))
+		return
+	}
+	if err := render.Render(w, r, NewJobResponse(job)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// rosterItem returns the processor creating the license of a student from a license template
+func (h *APIHandler) rosterItem(cf *conf.Config, template stor.LicenseInfo) job.Processor {
+	return func(ctx context.Context, st stor.Store, item json.RawMessage) stor.JobResult {
+		var student RosterStudent
+		if err := json.Unmarshal(item, &student); err != nil || student.UserID == "" {
+			return stor.JobResult{Status: http.StatusBadRequest, Error: "missing required user identifier"}
+		}
+		license := template
+		license.UUID, license.UserID = uuid.New().String(), student.UserID
+		result := stor.JobResult{UUID: license.UUID, UserID: student.UserID, Status: http.StatusCreated}
+		if err := license.Validate(); err != nil {
+			result.Status, result.Error = http.StatusBadRequest, err.Error()
+			return result
+		}
+		if err := st.License().Create(&license); err != nil {
+			result.Status, result.Error = http.StatusUnprocessableEntity, err.Error()
+			if errors.Is(err, stor.ErrDuplicate) {
+				result.Status = http.StatusConflict
+			}
+			return result
+		}
+		result.Links, result.Error = deliveryLinks(cf, &license)
+		return result
+	}
+}
+
+// deliveryLinks returns the urls delivered to the user of a license: its status document, its fresh license
+// if managed by the provider, and its self-service page if enabled
+func deliveryLinks(cf *conf.Config, license *stor.LicenseInfo) (map[string]string, string) {
+	lb := lic.NewLinkBuilder(cf, license.Provider)
+	links := map[string]string{"status": lb.Status(license.UUID)}
+	licenseLink, err := lb.License(license.UUID)
+	if err != nil {
+		return links, err.Error()
+	}
+	if licenseLink != "" {
+		links["license"] = licenseLink
+	}
+	if selfService := lb.SelfService(license.UUID); selfService != "" {
+		links["self_service"] = selfService
+	}
+	return links, ""
+}
+
+// decodeRosterForm decodes a multipart roster request
+func decodeRosterForm(r *http.Request, roster *RosterRequest) error {
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+	found := false
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch part.FormName() {
+		case "license":
+			data, err := io.ReadAll(io.LimitReader(part, maxTemplateSize+1))
+			if err != nil || len(data) > maxTemplateSize {
+				return errors.New("invalid license field")
+			}
+			if err = json.Unmarshal(data, &roster.License); err != nil {
+				return fmt.Errorf("invalid license field: %w", err)
+			}
+		case "roster":
+			if found {
+				return errors.New("a single roster must be uploaded")
+			}
+			found = true
+			data, err := io.ReadAll(io.LimitReader(part, maxRosterSize+1))
+			if err != nil {
+				return err
+			}
+			if len(data) > maxRosterSize {
+				return fmt.Errorf("the roster must be smaller than %d bytes", maxRosterSize)
+			}
+			fileType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			if fileType != "text/csv" && fileType != "application/json" {
+				if path.Ext(strings.ToLower(part.FileName())) == ".json" {
+					fileType = "application/json"
+				} else {
+					fileType = "text/csv"
+				}
+			}
+			if fileType == "application/json" {
+				err = json.Unmarshal(data, &roster.Students)
+			} else {
+				roster.Students, err = parseRosterCSV(data)
+			}
+			if err != nil {
+				return fmt.Errorf("invalid roster: %w", err)
+			}
+		}
+		part.Close()
+	}
+	if !found {
+		return errors.New("missing required roster")
+	}
+	return nil
+}
+
+// parseRosterCSV returns the students of a CSV roster, whose first row names the columns
+func parseRosterCSV(data []byte) ([]RosterStudent, error) {
+
+	cr := csv.NewReader(strings.NewReader(strings.TrimPrefix(string(data), "\ufeff")))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	column := -1
+	for i, name := range header {
+		if strings.EqualFold(strings.TrimSpace(name), "user_id") {
+			column = i
+		}
+	}
+	if column < 0 {
+		return nil, errors.New("missing required user_id column")
+	}
+	var students []RosterStudent
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		student := RosterStudent{}
+		if column < len(record) {
+			student.UserID = strings.TrimSpace(record[column])
+		}
+		students = append(students, student)
+	}
+	return students, nil
+}
+
+// --
+// Request payloads for the REST api.
+// --
+
+// RosterRequest is the request payload of a roster.
+type RosterRequest struct {
+	License  *stor.LicenseInfo `json:"license"` // template of the licenses, without identifier nor user
+	Students []RosterStudent   `json:"students"`
+}
+
+// RosterStudent is a student of a roster.
+type RosterStudent struct {
+	UserID string `json:"user_id"`
+}
+
+// Bind post-processes requests after unmarshalling.
+func (ro *RosterRequest) Bind(r *http.Request) error {
+	if ro.License == nil {
+		return errors.New("missing required license template")
+	}
+	if len(ro.Students) == 0 || len(ro.Students) > MaxJobSize {
+		return fmt.Errorf("a roster must contain from 1 to %d students", MaxJobSize)
+	}
+	users := make(map[string]int, len(ro.Students))
+	for i, student := range ro.Students {
+		if student.UserID == "" || len(student.UserID) > 255 {
+			return fmt.Errorf("invalid user identifier of the student %d", i+1)
+		}
+		if j, ok := users[student.UserID]; ok {
+			return fmt.Errorf("the students %d and %d have the same user identifier", j+1, i+1)
+		}
+		users[student.UserID] = i
+	}
+	return nil
+}
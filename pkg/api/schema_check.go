@@ -0,0 +1,73 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/edrlab/lcp-server/pkg/check"
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/slo"
+	"github.com/go-chi/render"
+)
+
+// checkSchema validates a document sent to readers against the embedded JSON schema of the specification,
+// so that a regression of the generators is caught before readers get broken documents.
+// A nonconforming document is only logged in "log" mode, and rejected in "strict" mode.
+func (h *APIHandler) checkSchema(schema string, doc interface{}) error {
+	if h.Config.SchemaCheck == "" {
+		return nil
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return h.checkSchemaData(schema, data)
+}
+
+// checkSchemaData validates an encoded document against its schema, as checkSchema.
+func (h *APIHandler) checkSchemaData(schema string, data []byte) error {
+	if h.Config.SchemaCheck == "" {
+		return nil
+	}
+	err := check.ValidateSchema(schema, data)
+	if err == nil {
+		return nil
+	}
+	if h.Config.SchemaCheck == "strict" {
+		return err
+	}
+	h.Logger.Errorf("Sending a nonconforming document: %v", err)
+	return nil
+}
+
+// statusBuffers are the buffers the status documents are encoded in, reused across requests
+var statusBuffers = sync.Pool{New: func() interface{} {
+	b := make([]byte, 0, 2048)
+	return &b
+}}
+
+// renderStatusDoc checks a status document against its schema, and writes it with the media type of status documents.
+// Status documents are most of the traffic: they are encoded without reflection, in a pooled buffer.
+func (h *APIHandler) renderStatusDoc(w http.ResponseWriter, r *http.Request, statusDoc *lic.StatusDoc) {
+	slo.SetTenant(r.Context(), statusDoc.Provider)
+	buf := statusBuffers.Get().(*[]byte)
+	data := statusDoc.AppendJSON((*buf)[:0])
+	defer func() {
+		// the buffers of the documents of many events are not kept
+		if cap(data) <= 64*1024 {
+			*buf = data
+			statusBuffers.Put(buf)
+		}
+	}()
+	if err := h.checkSchemaData(check.StatusSchema, data); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	w.Header().Set("Content-Type", lic.ContentType_LSD_JSON)
+	w.Write(data)
+}
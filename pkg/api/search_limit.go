@@ -0,0 +1,177 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+)
+
+// minSearchLimit is the lowest default limit of the searches reached by the adaptation.
+const minSearchLimit = 100
+
+// SearchLimits caps the results of the searches: a search gets the results it requested with the limit query
+// parameter, up to the max of the configuration, or else the default limit. If a target duration is configured,
+// the default limit adapts to the measured duration of the searches: it is halved after a search slower than the
+// target, down to 100 results, and grows back by a tenth after a search faster than half the target, so that a
+// loaded database is not asked for results the clients didn't request. A search which has more results than its
+// limit is truncated, which is told by the X-Result-Truncated header: the export of the search streams them all.
+type SearchLimits struct {
+	Config conf.Search
+
+	mu        sync.Mutex
+	current   int // current default limit
+	searches  uint64
+	truncated uint64
+	slow      uint64
+}
+
+// SearchStats are the metrics of the searches.
+type SearchStats struct {
+	DefaultLimit int    `json:"default_limit"` // current default limit
+	Searches     uint64 `json:"searches"`
+	Truncated    uint64 `json:"truncated"` // searches with more results than their limit
+	Slow         uint64 `json:"slow"`      // searches slower than the target duration
+}
+
+// NewSearchLimits creates the search limits of a configuration.
+func NewSearchLimits(c conf.Search) *SearchLimits {
+	return &SearchLimits{Config: c, current: c.DefaultResults()}
+}
+
+// search is a search in progress
+type search struct {
+	limits    *SearchLimits
+	limit     int
+	requested bool // the limit was requested by the client
+	start     time.Time
+}
+
+// start returns the search of a request, whose limit is requested or else the current default limit.
+func (l *SearchLimits) start(r *http.Request) (*search, error) {
+	s := &search{limits: l, start: time.Now()}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > l.Config.MaxResults() {
+			return nil, fmt.Errorf("limit must be an integer between 1 and %d", l.Config.MaxResults())
+		}
+		s.limit, s.requested = limit, true
+		return s, nil
+	}
+	l.mu.Lock()
+	s.limit = l.current
+	l.mu.Unlock()
+	return s, nil
+}
+
+// done records a search which found a number of results, up to its limit plus one, and sets the headers of its
+// limit; it returns the number of results kept.
+func (s *search) done(w http.ResponseWriter, r *http.Request, results int) int {
+	truncated := results > s.limit
+	s.limits.observe(s.requested, truncated, time.Since(s.start))
+	w.Header().Set("X-Result-Limit", strconv.Itoa(s.limit))
+	if !truncated {
+		return results
+	}
+	w.Header().Set("X-Result-Truncated", "true")
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"export\"", exportURL(r)))
+	return s.limit
+}
+
+// observe adapts the default limit to the duration of a search
+func (l *SearchLimits) observe(requested, truncated bool, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.searches++
+	if truncated {
+		l.truncated++
+	}
+	target := time.Duration(l.Config.TargetDuration) * time.Millisecond
+	if target <= 0 {
+		return
+	}
+	if d > target {
+		l.slow++
+	}
+	// only the searches with the default limit tell if it fits the database
+	if requested {
+		return
+	}
+	min := minSearchLimit
+	if max := l.Config.DefaultResults(); min > max {
+		min = max
+	}
+	switch {
+	case d > target && l.current > min:
+		l.current /= 2
+		if l.current < min {
+			l.current = min
+		}
+	case d < target/2 && truncated && l.current < l.Config.DefaultResults():
+		l.current += l.current/10 + 1
+		if max := l.Config.DefaultResults(); l.current > max {
+			l.current = max
+		}
+	}
+}
+
+// Stats returns the metrics of the searches.
+func (l *SearchLimits) Stats() SearchStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return SearchStats{DefaultLimit: l.current, Searches: l.searches, Truncated: l.truncated, Slow: l.slow}
+}
+
+// exportURL returns the url of the export of a search, with its criteria
+func exportURL(r *http.Request) string {
+	q := r.URL.Query()
+	q.Del("limit")
+	q.Del("page")
+	q.Del("per_page")
+	q.Del("sort")
+	u := url.URL{Path: strings.TrimSuffix(r.URL.Path, "/search") + "/export", RawQuery: q.Encode()}
+	return u.String()
+}
+
+// ndjsonWriter streams the items of an export as newline-delimited JSON, flushed at each batch
+type ndjsonWriter struct {
+	w       http.ResponseWriter
+	enc     *json.Encoder
+	written int
+}
+
+// newNDJSONWriter starts the response of an export
+func newNDJSONWriter(w http.ResponseWriter) *ndjsonWriter {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	return &ndjsonWriter{w: w, enc: json.NewEncoder(w)}
+}
+
+// write writes an item of the export
+func (n *ndjsonWriter) write(item interface{}) error {
+	if err := n.enc.Encode(item); err != nil {
+		return err
+	}
+	n.written++
+	if n.written%100 == 0 {
+		n.flush()
+	}
+	return nil
+}
+
+// flush sends the buffered items to the client
+func (n *ndjsonWriter) flush() {
+	if f, ok := n.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	_ "embed"
+	"net/http"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/render"
+)
+
+//go:embed data/selfservice.html
+var defaultSelfServicePage string
+
+// localized texts of the self-service page, by language
+var selfServiceTexts = map[string]map[string]string{
+	"en": {
+		"title":            "Your book",
+		"status":           "Status:",
+		"status_ready":     "ready to be opened",
+		"status_active":    "active",
+		"status_expired":   "expired",
+		"status_returned":  "returned",
+		"status_revoked":   "revoked",
+		"status_cancelled": "cancelled",
+		"end":              "Available until:",
+		"devices":          "Your devices",
+		"no_devices":       "The book has not been opened on any device yet.",
+		"return_info":      "Once returned, the book cannot be read on any of your devices.",
+		"return":           "Return the book",
+	},
+	"fr": {
+		"title":            "Votre livre",
+		"status":           "Statut :",
+		"status_ready":     "prêt à être ouvert",
+		"status_active":    "actif",
+		"status_expired":   "expiré",
+		"status_returned":  "rendu",
+		"status_revoked":   "révoqué",
+		"status_cancelled": "annulé",
+		"end":              "Disponible jusqu'au :",
+		"devices":          "Vos appareils",
+		"no_devices":       "Le livre n'a encore été ouvert sur aucun appareil.",
+		"return_info":      "Une fois rendu, le livre ne peut plus être lu sur aucun de vos appareils.",
+		"return":           "Rendre le livre",
+	},
+	"de": {
+		"title":            "Ihr Buch",
+		"status":           "Status:",
+		"status_ready":     "bereit zum Öffnen",
+		"status_active":    "aktiv",
+		"status_expired":   "abgelaufen",
+		"status_returned":  "zurückgegeben",
+		"status_revoked":   "widerrufen",
+		"status_cancelled": "storniert",
+		"end":              "Verfügbar bis:",
+		"devices":          "Ihre Geräte",
+		"no_devices":       "Das Buch wurde noch auf keinem Gerät geöffnet.",
+		"return_info":      "Nach der Rückgabe kann das Buch auf keinem Ihrer Geräte mehr gelesen werden.",
+		"return":           "Buch zurückgeben",
+	},
+	"es": {
+		"title":            "Su libro",
+		"status":           "Estado:",
+		"status_ready":     "listo para abrir",
+		"status_active":    "activo",
+		"status_expired":   "caducado",
+		"status_returned":  "devuelto",
+		"status_revoked":   "revocado",
+		"status_cancelled": "cancelado",
+		"end":              "Disponible hasta:",
+		"devices":          "Sus dispositivos",
+		"no_devices":       "El libro aún no se ha abierto en ningún dispositivo.",
+		"return_info":      "Una vez devuelto, el libro no se puede leer en ninguno de sus dispositivos.",
+		"return":           "Devolver el libro",
+	},
+	"it": {
+		"title":            "Il suo libro",
+		"status":           "Stato:",
+		"status_ready":     "pronto per l'apertura",
+		"status_active":    "attivo",
+		"status_expired":   "scaduto",
+		"status_returned":  "restituito",
+		"status_revoked":   "revocato",
+		"status_cancelled": "annullato",
+		"end":              "Disponibile fino al:",
+		"devices":          "I suoi dispositivi",
+		"no_devices":       "Il libro non è ancora stato aperto su nessun dispositivo.",
+		"return_info":      "Una volta restituito, il libro non può più essere letto su nessuno dei suoi dispositivi.",
+		"return":           "Restituire il libro",
+	},
+}
+
+// device recorded in the events of a license returned from its self-service page
+var selfServiceDevice = &lic.DeviceInfo{ID: "self-service", Name: "self-service page"}
+
+// SelfServicePageData is the data rendered by a self-service page template.
+type SelfServicePageData struct {
+	Lang      string            // language of the page, e.g. "fr"
+	Text      map[string]string // localized texts, see the built-in template
+	LicenseID string
+	Provider  string
+	Token     string // token authorizing the access to the page
+	Title     string // title of the publication
+	Author    string
+	Status    string     // status of the license, as in its status document
+	End       *time.Time // end of the license, if any
+	Devices   []*DeviceResponse
+	ReturnURL string // url of the return form, if the license can be returned
+}
+
+// SelfService serves the read-only html page on which the user of a license sees its status, its end date
+// and the devices which registered it, and may return it. The page is reached from the support link of the
+// license, signed by a token; an invalid token is processed as an unknown license.
+func (h *APIHandler) SelfService(w http.ResponseWriter, r *http.Request) {
+
+	license, token := h.selfServiceLicense(w, r)
+	if license == nil {
+		return
+	}
+	st := h.store(r)
+	lh := h.licenseHandler(r)
+
+	tpl, err := pageTemplate("selfservice", defaultSelfServicePage, h.Config.SelfService.TemplatePath(license.Provider))
+	if err != nil {
+		h.logger(r).Errorf("Failed to parse the self-service page template: %v", err)
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	devices, err := st.Device().List(license.UUID)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	events, err := st.Event().List(license.UUID)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	lang := pageLanguage(r)
+	data := SelfServicePageData{
+		Lang:      lang,
+		Text:      selfServiceTexts[lang],
+		LicenseID: license.UUID,
+		Provider:  license.Provider,
+		Token:     token,
+		Status:    lh.NewStatusDoc(license).Status,
+		End:       license.End,
+		Devices:   deviceList(devices, events),
+	}
+	if pub, err := st.Publication().Get(license.PublicationID); err == nil {
+		data.Title = pub.Title
+		data.Author = pub.Author
+	}
+	// only an active license can be returned
+	if data.Status == stor.STATUS_ACTIVE {
+		data.ReturnURL = license.UUID + "/return" // relative to the page
+
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", lang)
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tpl.Execute(w, data); err != nil {
+		h.logger(r).Errorf("Failed to render the self-service page of license %s: %v", license.UUID, err)
+	}
+}
+
+// SelfServiceReturn returns a license from its self-service page, then redirects the user to the page.
+// The token is sent as a form field.
+func (h *APIHandler) SelfServiceReturn(w http.ResponseWriter, r *http.Request) {
+
+	license, _ := h.selfServiceLicense(w, r)
+	if license == nil {
+		return
+	}
+	lh := h.licenseHandler(r)
+	if _, err := lh.Return(license.UUID, selfServiceDevice); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	links := lic.NewLinkBuilder(h.requestConfig(r), license.Provider)
+	http.Redirect(w, r, links.SelfService(license.UUID), http.StatusSeeOther)
+}
+
+// selfServiceLicense returns the license of a self-service request and its token,
+// or nil if the page is disabled, the license unknown or the token invalid.
+func (h *APIHandler) selfServiceLicense(w http.ResponseWriter, r *http.Request) (*stor.LicenseInfo, string) {
+
+	var licenseID string
+	if licenseID = getLicenseID(w, r); licenseID == "" {
+		return nil, ""
+	}
+	token := r.FormValue("token")
+	if !lic.ValidSelfServiceToken(h.Config.SelfService.Secret, licenseID, token) {
+		render.Render(w, r, ErrNotFound)
+		return nil, ""
+	}
+	license, err := h.store(r).License().Get(licenseID)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return nil, ""
+	}
+	return license, token
+}
@@ -6,15 +6,18 @@ package api
 
 import (
 	"errors"
+	"io"
 	"net/http"
 	"time"
 
 	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 )
 
-// Status returns a status document for the input license.
+// StatusDoc returns the status document of a license, as defined by the LSD specification:
+// its status, update times, potential rights and the links to register a device, renew and return the license.
 func (h *APIHandler) StatusDoc(w http.ResponseWriter, r *http.Request) {
 
 	// check the presence of the required params
@@ -23,22 +26,22 @@ func (h *APIHandler) StatusDoc(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	lh := lic.NewLicenseHandler(h.Config, h.Store)
+	lh := h.licenseHandler(r)
 
 	// get license info
 	license, err := lh.Store.License().Get(licenseID)
 	if err != nil {
-		render.Render(w, r, ErrInvalidRequest(err))
+		render.Render(w, r, ErrNotFound)
+		return
 	}
 
 	// generate a status document
 	statusDoc := lh.NewStatusDoc(license)
-	if err := render.Render(w, r, NewStatusDocResponse(statusDoc)); err != nil {
-		render.Render(w, r, ErrRender(err))
-	}
+	h.renderStatusDoc(w, r, statusDoc)
 }
 
 // Register records a new device using the license and returns a status document.
+// A device is counted once per license, whatever the number of its registrations.
 func (h *APIHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 	// check the presence of the required params
@@ -51,19 +54,24 @@ func (h *APIHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	lh := lic.NewLicenseHandler(h.Config, h.Store)
+	lh := h.licenseHandler(r)
 
 	// register
 	statusDoc, err := lh.Register(licenseID, deviceInfo)
+	if errors.Is(err, lic.ErrLicenseNotFound) {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
 	if err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
+		return
 	}
-	if err := render.Render(w, r, NewStatusDocResponse(statusDoc)); err != nil {
-		render.Render(w, r, ErrRender(err))
-	}
+	h.renderStatusDoc(w, r, statusDoc)
 }
 
 // Renew extends the lifetime of a license and returns a status document.
+// The new end date is set by the end query parameter, by default the license is extended by the default
+// number of days of the configuration; an end date beyond the potential end of the license is forbidden.
 func (h *APIHandler) Renew(w http.ResponseWriter, r *http.Request) {
 
 	// check the presence of the required params
@@ -81,19 +89,27 @@ func (h *APIHandler) Renew(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	lh := lic.NewLicenseHandler(h.Config, h.Store)
+	lh := h.licenseHandler(r)
 
 	// renew
 	statusDoc, err := lh.Renew(licenseID, deviceInfo, newEnd)
+	if errors.Is(err, lic.ErrLicenseNotFound) {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if errors.Is(err, lic.ErrRenewRejected) {
+		render.Render(w, r, ErrForbidden(err))
+		return
+	}
 	if err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
+		return
 	}
-	if err := render.Render(w, r, NewStatusDocResponse(statusDoc)); err != nil {
-		render.Render(w, r, ErrRender(err))
-	}
+	h.renderStatusDoc(w, r, statusDoc)
 }
 
 // Return forces the expiration of a license and returns a status document.
+// An expired, revoked or already returned license cannot be returned.
 func (h *APIHandler) Return(w http.ResponseWriter, r *http.Request) {
 
 	// check the presence of the required params
@@ -106,20 +122,25 @@ func (h *APIHandler) Return(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	lh := lic.NewLicenseHandler(h.Config, h.Store)
+	lh := h.licenseHandler(r)
 
-	// renew
+	// return
 	statusDoc, err := lh.Return(licenseID, deviceInfo)
+	if errors.Is(err, lic.ErrLicenseNotFound) {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
 	if err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
+		return
 	}
-	if err := render.Render(w, r, NewStatusDocResponse(statusDoc)); err != nil {
-		render.Render(w, r, ErrRender(err))
-	}
+	h.renderStatusDoc(w, r, statusDoc)
 
 }
 
 // Revoke forces the expiration of a license and returns a status document.
+// The optional payload gives the reason of the revocation, displayed to the user,
+// and the actor who requested it, by default the authenticated user.
 func (h *APIHandler) Revoke(w http.ResponseWriter, r *http.Request) {
 
 	// check the presence of the required params
@@ -127,18 +148,103 @@ func (h *APIHandler) Revoke(w http.ResponseWriter, r *http.Request) {
 	if licenseID = getLicenseID(w, r); licenseID == "" {
 		return
 	}
+	revocation := &RevokeRequest{}
+	if r.Body != nil {
+		if err := render.DecodeJSON(r.Body, revocation); err != nil && !errors.Is(err, io.EOF) {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+	}
+	if len(revocation.Reason) > 255 || len(revocation.Actor) > 255 {
+		render.Render(w, r, ErrInvalidRequest(errors.New("reason and actor must be shorter")))
+		return
+	}
+	if revocation.Actor == "" {
+		revocation.Actor, _, _ = r.BasicAuth()
+	}
 
-	lh := lic.NewLicenseHandler(h.Config, h.Store)
+	lh := h.licenseHandler(r)
 
 	// revoke
-	statusDoc, err := lh.Revoke(licenseID)
+	statusDoc, err := lh.Revoke(licenseID, lic.Revocation{Reason: revocation.Reason, Actor: revocation.Actor})
+	if errors.Is(err, lic.ErrLicenseNotFound) {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
 	if err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
+		return
 	}
-	if err := render.Render(w, r, NewStatusDocResponse(statusDoc)); err != nil {
+	h.renderStatusDoc(w, r, statusDoc)
+
+}
+
+// Void cancels a license sold by an order refunded by the storefront, e.g. a purchase by mistake, and returns
+// a status document. The payload gives the reason of the void, displayed to the user, the actor who requested it,
+// by default the authenticated client, and the reference of the refunded order. A license which is out of its
+// refund window, too used, or sold by another order gets a 409 status code.
+func (h *APIHandler) Void(w http.ResponseWriter, r *http.Request) {
+
+	// check the presence of the required params
+	var licenseID string
+	if licenseID = getLicenseID(w, r); licenseID == "" {
+		return
+	}
+	if !h.Config.Void.Enabled() {
+		render.Render(w, r, ErrForbidden(errors.New("licenses cannot be voided, as no refund window is configured")))
+		return
+	}
+	data := &VoidRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if data.Actor == "" {
+		if p := PrincipalFromContext(r.Context()); p != nil {
+			data.Actor = p.Name
+		}
+	}
+
+	// void
+	statusDoc, err := h.licenseHandler(r).Void(licenseID, lic.Void{Reason: data.Reason, Actor: data.Actor, OrderRef: data.OrderRef})
+	if errors.Is(err, lic.ErrLicenseNotFound) {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if errors.Is(err, lic.ErrVoidRejected) {
+		render.Render(w, r, ErrConflict(err))
+		return
+	}
+	if err != nil {
 		render.Render(w, r, ErrRender(err))
+		return
 	}
+	h.renderStatusDoc(w, r, statusDoc)
+}
+
+// GetRevocation returns the progress of the propagation of the revocation of a license, per channel.
+// The license is revoking until every channel confirmed the revocation; a license revoked without
+// propagation has no channel.
+func (h *APIHandler) GetRevocation(w http.ResponseWriter, r *http.Request) {
 
+	var licenseID string
+	if licenseID = getLicenseID(w, r); licenseID == "" {
+		return
+	}
+	st := h.store(r)
+	license, err := st.License().Get(licenseID)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	propagations, err := st.Propagation().List(licenseID)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err := render.Render(w, r, &RevocationResponse{Status: license.Status, Channels: *propagations}); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
 }
 
 // --
@@ -194,17 +300,37 @@ func getNewEnd(w http.ResponseWriter, r *http.Request) (*time.Time, error) {
 // Request and Response payloads for the REST api.
 // --
 
-// LicenseResponse is the response payload for licenses.
-type StatusDocResponse struct {
-	*lic.StatusDoc
+// RevokeRequest is the request payload of a revocation.
+type RevokeRequest struct {
+	Reason string `json:"reason"`
+	Actor  string `json:"actor"`
+}
+
+// VoidRequest is the request payload of a void.
+type VoidRequest struct {
+	Reason   string `json:"reason"`
+	Actor    string `json:"actor"`
+	OrderRef string `json:"order_ref"` // required if the license was issued with an order reference
+}
+
+// Bind post-processes requests after unmarshalling.
+func (v *VoidRequest) Bind(r *http.Request) error {
+	if v.Reason == "" {
+		return errors.New("missing reason")
+	}
+	if len(v.Reason) > 255 || len(v.Actor) > 255 || len(v.OrderRef) > 255 {
+		return errors.New("reason, actor and order reference must be shorter")
+	}
+	return nil
 }
 
-// NewLicenseResponse creates a rendered license
-func NewStatusDocResponse(statusDoc *lic.StatusDoc) *StatusDocResponse {
-	return &StatusDocResponse{StatusDoc: statusDoc}
+// RevocationResponse is the response payload of the propagation of a revocation.
+type RevocationResponse struct {
+	Status   string             `json:"status"`
+	Channels []stor.Propagation `json:"channels"`
 }
 
 // Render processes responses before marshalling.
-func (s *StatusDocResponse) Render(w http.ResponseWriter, r *http.Request) error {
+func (rr *RevocationResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
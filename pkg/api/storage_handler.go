@@ -0,0 +1,77 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/storage"
+	"github.com/go-chi/render"
+)
+
+// default grace period of the garbage collection
+const defaultGCGrace = 24 * time.Hour
+
+// CollectOrphans reports, and optionally deletes, stored objects no publication refers to.
+// Query parameters: dry_run (default true) and grace, a duration like "48h" (default 24h).
+func (h *APIHandler) CollectOrphans(w http.ResponseWriter, r *http.Request) {
+
+	if h.Tiering == nil {
+		render.Render(w, r, ErrInvalidRequest(errors.New("no storage is managed by the server")))
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dry_run") != "false"
+	grace := defaultGCGrace
+	if g := r.URL.Query().Get("grace"); g != "" {
+		var err error
+		if grace, err = time.ParseDuration(g); err != nil || grace < 0 {
+			render.Render(w, r, ErrInvalidRequest(errors.New("invalid grace query parameter")))
+			return
+		}
+	}
+
+	report, err := h.Tiering.CollectOrphans(r.Context(), grace, dryRun)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	// the storages of the data residency regions are collected as well
+	collected := map[*storage.Tiering]bool{h.Tiering: true}
+	for _, tiering := range h.RegionTiering {
+		if collected[tiering] {
+			continue
+		}
+		collected[tiering] = true
+		regional, err := tiering.CollectOrphans(r.Context(), grace, dryRun)
+		if err != nil {
+			render.Render(w, r, ErrRender(err))
+			return
+		}
+		report.Checked += regional.Checked
+		report.Orphans = append(report.Orphans, regional.Orphans...)
+		report.Size += regional.Size
+	}
+	if err := render.Render(w, r, &GCReportResponse{GCReport: report}); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// --
+// Request and Response payloads for the REST api.
+// --
+
+// GCReportResponse is the response payload of a garbage collection.
+type GCReportResponse struct {
+	*storage.GCReport
+}
+
+// Render processes responses before marshalling.
+func (g *GCReportResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
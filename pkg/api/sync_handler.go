@@ -0,0 +1,148 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/render"
+)
+
+// MaxSyncSize is the max number of publications synchronized by a request, i.e. the max size of a source catalog.
+const MaxSyncSize = 10000
+
+// SyncPublications reconciles the publications of a source, e.g. the catalog of a publisher system, with
+// the catalog sent: publications unknown to the server are created, known ones are updated, and the
+// publications of the source missing from the catalog are deleted. As a safeguard, nothing is deleted
+// if an item of the catalog is rejected, since the rejected item may describe an existing publication.
+func (h *APIHandler) SyncPublications(w http.ResponseWriter, r *http.Request) {
+
+	// get the payload; each item is decoded separately, so that an invalid item does not reject the catalog
+	data := &SyncRequest{}
+	if err := render.DecodeJSON(r.Body, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if data.Source == "" {
+		render.Render(w, r, ErrInvalidRequest(errors.New("missing source")))
+		return
+	}
+	// an empty catalog, e.g. a failed export, would delete every publication of the source
+	if len(data.Publications) == 0 || len(data.Publications) > MaxSyncSize {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("a catalog must contain from 1 to %d publications", MaxSyncSize)))
+		return
+	}
+
+	response := &SyncResponse{Source: data.Source, Deleted: []string{}, Results: make([]BatchResult, len(data.Publications))}
+	synced := make(map[string]bool)
+	for i, item := range data.Publications {
+		publication := &stor.Publication{}
+		err := json.Unmarshal(item, publication)
+		result := BatchResult{Index: i, UUID: publication.UUID}
+		if err == nil {
+			result.Status, err = h.syncPublication(r, data.Source, publication)
+		} else {
+			result.Status = http.StatusBadRequest
+		}
+		if err != nil {
+			result.Error = err.Error()
+			response.Failed++
+		} else if result.Status == http.StatusCreated {
+			response.Created++
+		} else {
+			response.Updated++
+		}
+		response.Results[i] = result
+		synced[publication.UUID] = true
+	}
+
+	// delete the publications of the source missing from the catalog
+	if response.Failed == 0 {
+		uuids, err := h.store(r).Publication().ListSourceUUIDs(data.Source)
+		if err != nil {
+			render.Render(w, r, ErrRender(err))
+			return
+		}
+		for _, uuid := range uuids {
+			if synced[uuid] {
+				continue
+			}
+			publication, err := h.store(r).Publication().Get(uuid)
+			if err == nil {
+				err = h.store(r).Publication().Delete(publication)
+			}
+			if err != nil {
+				render.Render(w, r, ErrRender(err))
+				return
+			}
+			response.Deleted = append(response.Deleted, uuid)
+		}
+	}
+
+	if err := render.Render(w, r, response); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// syncPublication creates or updates a publication of a source, and returns the status code of the operation.
+// A publication created without source is adopted by the source, but a publication of another source is not.
+func (h *APIHandler) syncPublication(r *http.Request, source string, publication *stor.Publication) (int, error) {
+
+	currentPub, err := h.store(r).Publication().Get(publication.UUID)
+	if err != nil {
+		publication.Source = source
+		if err = h.validatePublication(publication); err != nil {
+			return http.StatusBadRequest, err
+		}
+		if err = h.store(r).Publication().Create(publication); err != nil {
+			if errors.Is(err, stor.ErrDuplicate) {
+				return http.StatusConflict, err
+			}
+			return http.StatusUnprocessableEntity, err
+		}
+		return http.StatusCreated, nil
+	}
+
+	if currentPub.Source != "" && currentPub.Source != source {
+		return http.StatusConflict, fmt.Errorf("the publication is synchronized from the source %q", currentPub.Source)
+	}
+	if err = mergePublication(publication, currentPub); err != nil {
+		return http.StatusConflict, err
+	}
+	publication.Source = source
+	if err = h.validatePublication(publication); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err = h.store(r).Publication().Update(publication); err != nil {
+		return http.StatusUnprocessableEntity, err
+	}
+	return http.StatusOK, nil
+}
+
+// SyncRequest is the request payload of a synchronization: the complete catalog of a source.
+type SyncRequest struct {
+	Source       string            `json:"source"`
+	Publications []json.RawMessage `json:"publications"`
+}
+
+// SyncResponse is the response payload of a synchronization.
+type SyncResponse struct {
+	Source  string        `json:"source"`
+	Created int           `json:"created"`
+	Updated int           `json:"updated"`
+	Failed  int           `json:"failed"`
+	Deleted []string      `json:"deleted"` // uuids of the deleted publications
+	Results []BatchResult `json:"results"` // results of the publications of the catalog
+}
+
+// Render processes responses before marshalling.
+func (s *SyncResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
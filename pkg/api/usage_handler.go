@@ -0,0 +1,121 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// usage periods, in days
+const (
+	DefaultUsageDays = 30
+	MaxUsageDays     = 366
+)
+
+// GetPublicationUsage returns the daily usage of a publication over a period, i.e. the fresh licenses
+// fetched and the resources downloaded, along with the number of licenses of the publication,
+// so that the actual consumption can be compared to the licenses sold. The period is set by the from
+// and to query parameters, e.g. ?from=2023-05-01&to=2023-05-31, and defaults to the last 30 days.
+// The usage of a deleted publication is still available.
+func (h *APIHandler) GetPublicationUsage(w http.ResponseWriter, r *http.Request) {
+
+	publication, err := h.store(r).Publication().WithDeleted().Get(chi.URLParam(r, "publicationID"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	from, to, err := parsePeriod(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	days, err := h.store(r).Usage().List(publication.UUID, from, to)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	licenses, err := h.store(r).License().CountByPublication(publication.UUID)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	response := &UsageResponse{
+		PublicationID: publication.UUID,
+		From:          from.Format(stor.DayFormat),
+		To:            to.Format(stor.DayFormat),
+		Licenses:      licenses,
+		Days:          *days,
+	}
+	for _, day := range *days {
+		response.LicenseFetches += day.LicenseFetches
+		response.Downloads += day.Downloads
+	}
+	if err := render.Render(w, r, response); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// parsePeriod returns the days of the from and to query parameters, by default the last 30 days.
+func parsePeriod(r *http.Request) (time.Time, time.Time, error) {
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	var err error
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.Parse(stor.DayFormat, v); err != nil {
+			return to, to, errors.New("to must be a day formatted as YYYY-MM-DD")
+		}
+	}
+	from := to.AddDate(0, 0, 1-DefaultUsageDays)
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.Parse(stor.DayFormat, v); err != nil {
+			return from, to, errors.New("from must be a day formatted as YYYY-MM-DD")
+		}
+	}
+	if from.After(to) || to.Sub(from) >= MaxUsageDays*24*time.Hour {
+		return from, to, fmt.Errorf("the period must run forward, over %d days at most", MaxUsageDays)
+	}
+	return from, to, nil
+}
+
+// recordUsage adds license fetches and downloads to the usage of a publication.
+// A failure is logged, as it doesn't prevent the license or the resource from being served.
+func (h *APIHandler) recordUsage(r *http.Request, publicationID string, licenseFetches, downloads int64) {
+	if err := h.store(r).Usage().Record(publicationID, time.Now(), licenseFetches, downloads); err != nil {
+		h.logger(r).Warningf("Failed to record the usage of the publication %s: %v", publicationID, err)
+	}
+}
+
+// isDownload tells if a request of a resource starts a download, i.e. requests the whole resource or its
+// first bytes: players stream a resource by successive ranges, which must not count as several downloads.
+func isDownload(r *http.Request) bool {
+	rng := r.Header.Get("Range")
+	return rng == "" || strings.HasPrefix(rng, "bytes=0-")
+}
+
+// UsageResponse is the response payload of the usage of a publication.
+type UsageResponse struct {
+	PublicationID  string                  `json:"publication_id"`
+	From           string                  `json:"from"`
+	To             string                  `json:"to"`
+	Licenses       int64                   `json:"licenses"`        // licenses of the publication, whatever the period
+	LicenseFetches int64                   `json:"license_fetches"` // over the period
+	Downloads      int64                   `json:"downloads"`       // over the period
+	Days           []stor.PublicationUsage `json:"days"`            // days with some usage
+}
+
+// Render processes responses before marshalling.
+func (u *UsageResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/sign"
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+// warmup is the state of the warmup of a handler
+type warmup struct {
+	done chan struct{} // closed once the warmup is completed
+	err  error         // failure of the warmup, set before done is closed
+}
+
+// errWarmingUp fails the readiness of a handler during its warmup
+var errWarmingUp = errors.New("warmup in progress")
+
+// Prewarm warms the handler up in the background, so that its first license generations are not slowed by cold
+// connections and caches: the signer is verified by a test signature, and the store is warmed up as configured.
+// Readyz fails until the warmup is completed, and keeps failing if it failed. It returns a channel closed once
+// the warmup is completed; it must be called once, before the routes are served.
+func (h *APIHandler) Prewarm(ctx context.Context) <-chan struct{} {
+	h.warmup = &warmup{done: make(chan struct{})}
+	go func() {
+		defer close(h.warmup.done)
+		start := time.Now()
+		if h.warmup.err = h.prewarm(ctx); h.warmup.err != nil {
+			h.Logger.Errorf("Warmup failed: %v", h.warmup.err)
+			return
+		}
+		h.Logger.Infof("Warmup completed in %s", time.Since(start).Round(time.Millisecond))
+	}()
+	return h.warmup.done
+}
+
+func (h *APIHandler) prewarm(ctx context.Context) error {
+
+	// a signer which would fail is detected before the first license generation
+	signer, err := h.signer()
+	if err != nil {
+		return err
+	}
+	if signer != nil {
+		sample := map[string]string{"warmup": time.Now().UTC().Format(time.RFC3339)}
+		sig, err := signer.Sign(sample)
+		if err != nil {
+			return fmt.Errorf("failed to sign a test document: %w", err)
+		}
+		checker, err := sign.NewSignChecker(sig.Certificate, sig.Algorithm)
+		if err == nil {
+			err = checker.Check(sample, sig.Value)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to verify the test signature: %w", err)
+		}
+	}
+
+	c := h.Config.Warmup
+	return stor.Warmup(ctx, h.Store, c.Conns(), c.Pubs())
+}
+
+// warmed returns the failure of the warmup, or errWarmingUp until it is completed.
+func (h *APIHandler) warmed() error {
+	select {
+	case <-h.warmup.done:
+		return h.warmup.err
+	default:
+		return errWarmingUp
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/render"
+)
+
+// ListDeadLetters returns the notifications dropped after their last attempt, the latest first, so that an
+// operator replays them once the webhook, the message bus or the status callback is fixed. The target parameter
+// selects the letters of a target, e.g. "cms" or "callback:status".
+func (h *APIHandler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+
+	limit := DefaultPerPage
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > MaxPerPage {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("limit must be an integer between 1 and %d", MaxPerPage)))
+			return
+		}
+	}
+	letters, err := h.store(r).Webhook().DeadLetters(r.URL.Query().Get("target"), limit)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	if err := render.RenderList(w, r, NewDeadLetterListResponse(letters)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// --
+// Request and Response payloads for the REST api.
+// --
+
+// DeadLetterResponse is the response payload of a dead letter.
+type DeadLetterResponse struct {
+	*stor.DeadLetter
+}
+
+// NewDeadLetterListResponse creates a rendered list of dead letters.
+func NewDeadLetterListResponse(letters *[]stor.DeadLetter) []render.Renderer {
+	list := []render.Renderer{}
+	for i := 0; i < len(*letters); i++ {
+		list = append(list, NewDeadLetterResponse(&(*letters)[i]))
+	}
+	return list
+}
+
+// NewDeadLetterResponse creates a rendered dead letter.
+func NewDeadLetterResponse(letter *stor.DeadLetter) *DeadLetterResponse {
+	return &DeadLetterResponse{DeadLetter: letter}
+}
+
+// Render processes responses before marshalling.
+func (d *DeadLetterResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
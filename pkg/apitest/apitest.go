@@ -0,0 +1,276 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package apitest replays a scripted sequence of API calls against a server, and compares the shape
+// of each JSON response to a golden file, so that accidental changes of the payloads sent to content
+// management systems are caught. The shape of a payload keeps its fields and the types of their values,
+// not the values themselves: the same golden files are therefore valid for a test server and for a live one.
+package apitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Interaction is a request of the script, and the status code expected in response.
+type Interaction struct {
+	Name    string // name of the golden file of the response
+	Method  string
+	Path    string            // may reference captured variables, e.g. /status/{license}
+	Body    interface{}       // sent as JSON, if not nil
+	Status  int               // expected status code
+	Capture map[string]string // variables set from the fields of the response, e.g. "license": "id"
+}
+
+// Runner replays a script against a server.
+type Runner struct {
+	BaseURL   string // url of the server, including its base path
+	User      string // admin login of the private routes
+	Password  string
+	Client    *http.Client // http.DefaultClient if nil
+	GoldenDir string       // directory of the golden files
+	Update    bool         // if set, the golden files are rewritten from the responses
+}
+
+// Run sends the requests of the script in order, each as a subtest. A request following
+// a failed one is still sent, unless it references a variable which was not captured.
+func (rn *Runner) Run(t *testing.T, script []Interaction) {
+	vars := make(map[string]string)
+	for _, it := range script {
+		it := it
+		t.Run(it.Name, func(t *testing.T) {
+			path, err := expand(it.Path, vars)
+			if err != nil {
+				t.Skip(err)
+			}
+			status, body, err := rn.send(it.Method, path, it.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != it.Status {
+				t.Fatalf("%s %s: expected status %d, got %d: %s", it.Method, path, it.Status, status, body)
+			}
+			if body == nil {
+				return
+			}
+			for name, field := range it.Capture {
+				v, ok := lookup(body, field)
+				if !ok {
+					t.Fatalf("No field %s in the response", field)
+				}
+				vars[name] = fmt.Sprint(v)
+			}
+			rn.compare(t, it.Name, Shape(body))
+		})
+	}
+}
+
+// send returns the status code of the response, and its body if it is JSON.
+func (rn *Runner) send(method, path string, payload interface{}) (int, interface{}, error) {
+	var reader io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return 0, nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(rn.BaseURL, "/")+path, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if rn.User != "" {
+		req.SetBasicAuth(rn.User, rn.Password)
+	}
+	client := rn.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !isJSON(resp.Header.Get("Content-Type")) {
+		return resp.StatusCode, nil, nil
+	}
+	var body interface{}
+	if err = json.Unmarshal(data, &body); err != nil {
+		return resp.StatusCode, nil, fmt.Errorf("invalid JSON response: %w", err)
+	}
+	return resp.StatusCode, body, nil
+}
+
+// compare checks a shape against its golden file, or rewrites the file in update mode.
+func (rn *Runner) compare(t *testing.T, name string, shape interface{}) {
+	file := filepath.Join(rn.GoldenDir, name+".json")
+	if rn.Update {
+		data, err := json.MarshalIndent(shape, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(file, append(data, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read the golden file: %v", err)
+	}
+	var golden interface{}
+	if err = json.Unmarshal(data, &golden); err != nil {
+		t.Fatalf("Invalid golden file %s: %v", file, err)
+	}
+	for _, diff := range Diff(golden, shape) {
+		t.Errorf("The response differs from %s: %s", file, diff)
+	}
+}
+
+// Shape returns the shape of a decoded JSON value: objects keep their fields, values are replaced
+// by the name of their type, and arrays keep the shape of their first item.
+func Shape(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		shape := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			shape[k] = Shape(e)
+		}
+		return shape
+	case []interface{}:
+		if len(x) == 0 {
+			return []interface{}{}
+		}
+		return []interface{}{Shape(x[0])}
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return nil
+}
+
+// Diff lists the differences between an expected shape and the shape of a response.
+// An empty array matches any array, as the items of a listing depend on the data of the server.
+func Diff(want, got interface{}) []string {
+	return diff("$", want, got)
+}
+
+func diff(path string, want, got interface{}) []string {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected an object, got %s", path, describe(got))}
+		}
+		var diffs []string
+		for _, k := range keys(w, g) {
+			we, inWant := w[k]
+			ge, inGot := g[k]
+			switch {
+			case !inGot:
+				diffs = append(diffs, fmt.Sprintf("%s.%s: missing", path, k))
+			case !inWant:
+				diffs = append(diffs, fmt.Sprintf("%s.%s: unexpected field", path, k))
+			default:
+				diffs = append(diffs, diff(path+"."+k, we, ge)...)
+			}
+		}
+		return diffs
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected an array, got %s", path, describe(got))}
+		}
+		if len(w) == 0 || len(g) == 0 {
+			return nil
+		}
+		return diff(path+"[0]", w[0], g[0])
+	}
+	if want != got {
+		return []string{fmt.Sprintf("%s: expected %s, got %s", path, describe(want), describe(got))}
+	}
+	return nil
+}
+
+func describe(shape interface{}) string {
+	switch shape.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprint(shape)
+}
+
+// keys returns the sorted union of the keys of two objects.
+func keys(a, b map[string]interface{}) []string {
+	var ks []string
+	for k := range a {
+		ks = append(ks, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			ks = append(ks, k)
+		}
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// lookup returns the value of a field of a decoded JSON object, by its dotted path.
+func lookup(v interface{}, field string) (interface{}, bool) {
+	for _, k := range strings.Split(field, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// expand replaces the variables referenced by a path.
+func expand(path string, vars map[string]string) (string, error) {
+	var missing []string
+	expanded := os.Expand(strings.NewReplacer("{", "${").Replace(path), func(name string) string {
+		v, ok := vars[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("variables %s not captured", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// isJSON tells if a media type is JSON, e.g. application/json or application/vnd.readium.license.status.v1.0+json.
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
@@ -0,0 +1,51 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package apitest
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestShapeDiff(t *testing.T) {
+
+	decode := func(s string) interface{} {
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	golden := Shape(decode(`{"id": "a", "size": 1, "links": [{"rel": "x"}], "events": []}`))
+
+	// values and additional items do not matter
+	if diffs := Diff(golden, Shape(decode(`{"id": "b", "size": 2, "links": [{"rel": "y"}, {"rel": "z", "type": "t"}], "events": [{"id": "1"}]}`))); len(diffs) != 0 {
+		t.Errorf("Expected no difference, got %v", diffs)
+	}
+
+	// shape changes are reported
+	got := Shape(decode(`{"id": 1, "links": [{"rel": "x", "href": "h"}], "events": [], "extra": true}`))
+	expected := []string{
+		"$.extra: unexpected field",
+		"$.id: expected string, got number",
+		"$.links[0].href: unexpected field",
+		"$.size: missing",
+	}
+	if diffs := Diff(golden, got); !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("Expected %v, got %v", expected, diffs)
+	}
+}
+
+func TestExpand(t *testing.T) {
+
+	vars := map[string]string{"license": "123"}
+	if path, err := expand("/status/{license}?id=1", vars); err != nil || path != "/status/123?id=1" {
+		t.Errorf("Unexpected expansion %s, %v", path, err)
+	}
+	if _, err := expand("/revoke/{revocable}", vars); err == nil {
+		t.Error("Expected an error for a variable not captured")
+	}
+}
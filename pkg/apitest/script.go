@@ -0,0 +1,194 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package apitest
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// passhash of the passphrases of the script
+const passhash = "FAEB00CA518BEA7CB11A7EF31FB6183B489B1B6EADB792BEC64A03B3F6FF80A8"
+
+// Script returns interactions calling every endpoint of the API, except the debug variables.
+// The entities created by the script have fresh identifiers, and are deleted at the end,
+// so that the script can be replayed against a live server. The server must manage a storage
+// of publications, for the garbage collection to be available.
+func Script() []Interaction {
+
+	id := strings.ReplaceAll(uuid.New().String(), "-", "")
+	format := "golden" + id[:8]
+	contentType := "application/x-" + format + "+zip"
+	pub := uuid.New().String()
+	putPub := uuid.New().String()
+	user := "golden-" + id
+	org := uuid.New().String()
+	rawLicense := uuid.New().String()
+	batchLicense := uuid.New().String()
+
+	publication := map[string]interface{}{
+		"uuid":           pub,
+		"title":          "Golden publication",
+		"author":         "Golden author",
+		"encryption_key": "ZW5jcnlwdGlvbl9rZXkgeCBlbmNyeXB0aW9uX2tleQ==",
+		"location":       "https://example.com/golden.zip",
+		"content_type":   contentType,
+		"size":           1000,
+		"checksum":       "YWJj",
+	}
+	putPublication := map[string]interface{}{"uuid": putPub}
+	for k, v := range publication {
+		if k != "uuid" {
+			putPublication[k] = v
+		}
+	}
+	start := time.Now().Truncate(time.Second).UTC()
+	end := start.AddDate(0, 0, 10)
+	licenseRequest := map[string]interface{}{
+		"publication_id": pub,
+		"user_id":        user,
+		"user_name":      "Golden user",
+		"user_email":     "golden@example.com",
+		"user_encrypted": []string{"email"},
+		"start":          start,
+		"end":            end,
+		"profile":        "http://readium.org/lcp/basic-profile",
+		"text_hint":      "The golden hint",
+		"pass_hash":      passhash,
+	}
+	generatedRequest := map[string]interface{}{"generate_passphrase": true}
+	for k, v := range licenseRequest {
+		if k != "pass_hash" {
+			generatedRequest[k] = v
+		}
+	}
+	licenseInfo := map[string]interface{}{
+		"uuid":           rawLicense,
+		"user_id":        user,
+		"publication_id": pub,
+		"provider":       "https://provider.example.com",
+		"start":          start,
+		"end":            end,
+		"copy":           100,
+		"print":          10,
+		"status":         "ready",
+	}
+	batch := []map[string]interface{}{{}, {"user_id": user}}
+	for k, v := range licenseInfo {
+		batch[0][k] = v
+	}
+	batch[0]["uuid"] = batchLicense
+	device := "?id=golden-device&name=golden"
+
+	return []Interaction{
+		{Name: "heartbeat", Method: "GET", Path: "/", Status: http.StatusOK},
+
+		// media type registry
+		{Name: "mediatype-create", Method: "POST", Path: "/mediatypes/", Status: http.StatusCreated,
+			Body: map[string]string{"format": format, "content_type": contentType, "label": "Golden"}},
+		{Name: "mediatype-list", Method: "GET", Path: "/mediatypes/", Status: http.StatusOK},
+		{Name: "mediatype-get", Method: "GET", Path: "/mediatypes/" + format, Status: http.StatusOK},
+		{Name: "mediatype-update", Method: "PUT", Path: "/mediatypes/" + format, Status: http.StatusOK,
+			Body: map[string]string{"format": format, "content_type": contentType, "label": "Golden format"}},
+
+		// publications
+		{Name: "publication-create", Method: "POST", Path: "/publications/", Body: publication, Status: http.StatusCreated},
+		{Name: "publication-duplicate", Method: "POST", Path: "/publications/", Body: publication, Status: http.StatusConflict},
+		{Name: "publication-upsert", Method: "POST", Path: "/publications/?upsert=true", Body: publication, Status: http.StatusOK},
+		{Name: "publication-get", Method: "GET", Path: "/publications/" + pub, Status: http.StatusOK},
+		{Name: "publication-update", Method: "PUT", Path: "/publications/" + pub, Body: publication, Status: http.StatusOK},
+		{Name: "publication-put-create", Method: "PUT", Path: "/publications/" + putPub, Body: putPublication, Status: http.StatusCreated},
+		{Name: "publication-put-merge", Method: "PUT", Path: "/publications/" + putPub, Status: http.StatusOK,
+			Body: map[string]string{"title": "Golden merged publication"}},
+		{Name: "publication-sync", Method: "POST", Path: "/publications/sync", Status: http.StatusOK,
+			Body: map[string]interface{}{"source": "golden-" + id, "publications": []interface{}{putPublication}}},
+		{Name: "publication-put-delete", Method: "DELETE", Path: "/publications/" + putPub, Status: http.StatusOK},
+		{Name: "publication-restore", Method: "POST", Path: "/publications/" + putPub + "/restore", Status: http.StatusOK},
+		{Name: "publication-delete-restored", Method: "DELETE", Path: "/publications/" + putPub, Status: http.StatusOK},
+		{Name: "publication-changes", Method: "GET", Path: "/publications/changes?limit=1&since=" + start.Format(time.RFC3339), Status: http.StatusOK},
+		{Name: "publication-list", Method: "GET", Path: "/publications/?per_page=1&sort=-created_at", Status: http.StatusOK},
+		{Name: "publication-search", Method: "GET", Path: "/publications/search?format=" + format, Status: http.StatusOK},
+		{Name: "publication-search-text", Method: "GET", Path: "/publications/search?q=" + pub, Status: http.StatusOK},
+		{Name: "publication-not-found", Method: "GET", Path: "/publications/" + uuid.New().String(), Status: http.StatusNotFound},
+
+		// multi-part publications
+		{Name: "resources-set", Method: "PUT", Path: "/publications/" + pub + "/resources", Status: http.StatusOK,
+			Body: map[string]interface{}{"resources": []map[string]interface{}{
+				{"position": 1, "href": "track1.mp3", "content_type": "audio/mpeg", "size": 1000, "checksum": "YQ==", "duration": 62.5},
+			}}},
+		{Name: "resources-list", Method: "GET", Path: "/publications/" + pub + "/resources", Status: http.StatusOK},
+		{Name: "content-manifest", Method: "GET", Path: "/content/" + pub + "/manifest", Status: http.StatusOK},
+		{Name: "content-unmanaged", Method: "GET", Path: "/content/" + pub + "/1", Status: http.StatusNotFound},
+
+		// license generation and status documents
+		{Name: "license-generate", Method: "POST", Path: "/licenses/", Body: licenseRequest, Status: http.StatusOK,
+			Capture: map[string]string{"license": "id"}},
+		{Name: "license-fresh", Method: "POST", Path: "/licenses/{license}", Body: licenseRequest, Status: http.StatusOK},
+		{Name: "license-document-unknown-key", Method: "GET", Path: "/licenses/{license}/document", Status: http.StatusConflict},
+		{Name: "license-generate-passphrase", Method: "POST", Path: "/licenses/", Body: generatedRequest, Status: http.StatusOK,
+			Capture: map[string]string{"generated": "license.id"}},
+		{Name: "license-document", Method: "GET", Path: "/licenses/{generated}/document", Status: http.StatusOK},
+		{Name: "publication-usage", Method: "GET", Path: "/publications/" + pub + "/usage", Status: http.StatusOK},
+		{Name: "status", Method: "GET", Path: "/status/{license}", Status: http.StatusOK},
+		{Name: "status-unknown", Method: "GET", Path: "/status/unknown", Status: http.StatusNotFound},
+		{Name: "status-register", Method: "POST", Path: "/register/{license}" + device, Status: http.StatusOK},
+		{Name: "status-renew", Method: "PUT", Path: "/renew/{license}" + device, Status: http.StatusOK},
+		{Name: "license-renew", Method: "PUT", Path: "/licenses/{license}/renew" + device, Status: http.StatusOK},
+		{Name: "license-renew-beyond", Method: "PUT", Path: "/licenses/{license}/renew" + device + "&end=2100-01-01T00:00:00Z",
+			Status: http.StatusForbidden},
+		{Name: "status-return", Method: "PUT", Path: "/return/{license}" + device, Status: http.StatusOK},
+		{Name: "license-generate-returnable", Method: "POST", Path: "/licenses/", Body: licenseRequest, Status: http.StatusOK,
+			Capture: map[string]string{"returnable": "id"}},
+		{Name: "license-register", Method: "POST", Path: "/licenses/{returnable}/register" + device, Status: http.StatusOK},
+		{Name: "license-return", Method: "PUT", Path: "/licenses/{returnable}/return" + device, Status: http.StatusOK},
+		{Name: "license-devices", Method: "GET", Path: "/licenses/{returnable}/devices", Status: http.StatusOK},
+		{Name: "license-return-again", Method: "PUT", Path: "/licenses/{returnable}/return" + device, Status: http.StatusBadRequest},
+		{Name: "license-generate-revocable", Method: "POST", Path: "/licenses/", Body: licenseRequest, Status: http.StatusOK,
+			Capture: map[string]string{"revocable": "id"}},
+		{Name: "status-revoke", Method: "PUT", Path: "/licenses/{revocable}/revoke", Status: http.StatusOK,
+			Body: map[string]string{"reason": "Golden revocation"}},
+		{Name: "license-revocation", Method: "GET", Path: "/licenses/{revocable}/revocation", Status: http.StatusOK},
+
+		// license information
+		{Name: "licenseinfo-get", Method: "GET", Path: "/licenseinfo/{license}", Status: http.StatusOK},
+		{Name: "licenseinfo-list", Method: "GET", Path: "/licenseinfo/?per_page=1&sort=-created_at", Status: http.StatusOK},
+		{Name: "licenseinfo-search", Method: "GET", Path: "/licenseinfo/search?user=" + user, Status: http.StatusOK},
+		{Name: "licenseinfo-create", Method: "POST", Path: "/licenseinfo/", Body: licenseInfo, Status: http.StatusCreated},
+		{Name: "licenseinfo-duplicate", Method: "POST", Path: "/licenseinfo/", Body: licenseInfo, Status: http.StatusConflict},
+		{Name: "licenseinfo-upsert", Method: "POST", Path: "/licenseinfo/?upsert=true", Body: licenseInfo, Status: http.StatusOK},
+		{Name: "licenseinfo-update", Method: "PUT", Path: "/licenseinfo/" + rawLicense, Body: licenseInfo, Status: http.StatusOK},
+		{Name: "licenseinfo-delete", Method: "DELETE", Path: "/licenseinfo/" + rawLicense, Status: http.StatusOK},
+		{Name: "license-restore", Method: "POST", Path: "/licenses/" + rawLicense + "/restore", Status: http.StatusOK},
+		{Name: "licenseinfo-delete-restored", Method: "DELETE", Path: "/licenseinfo/" + rawLicense, Status: http.StatusOK},
+		{Name: "licenses-batch", Method: "POST", Path: "/licenses/batch", Body: batch, Status: http.StatusOK},
+		{Name: "licenseinfo-delete-batch", Method: "DELETE", Path: "/licenseinfo/" + batchLicense, Status: http.StatusOK},
+
+		// organizations and their passphrase pools
+		{Name: "organization-create", Method: "POST", Path: "/organizations/", Status: http.StatusCreated,
+			Body: map[string]string{"uuid": org, "name": "Golden school"}},
+		{Name: "organization-list", Method: "GET", Path: "/organizations/", Status: http.StatusOK},
+		{Name: "organization-get", Method: "GET", Path: "/organizations/" + org, Status: http.StatusOK},
+		{Name: "organization-update", Method: "PUT", Path: "/organizations/" + org, Status: http.StatusOK,
+			Body: map[string]string{"uuid": org, "name": "Golden high school"}},
+		{Name: "passphrase-add", Method: "POST", Path: "/organizations/" + org + "/passphrases/", Status: http.StatusCreated,
+			Body: map[string]string{"label": "teachers", "text_hint": "Passphrase of the teachers", "pass_hash": passhash}},
+		{Name: "passphrase-list", Method: "GET", Path: "/organizations/" + org + "/passphrases/", Status: http.StatusOK},
+		{Name: "passphrase-delete", Method: "DELETE", Path: "/organizations/" + org + "/passphrases/teachers", Status: http.StatusOK},
+		{Name: "organization-delete", Method: "DELETE", Path: "/organizations/" + org, Status: http.StatusOK},
+
+		// storage
+		{Name: "storage-gc", Method: "POST", Path: "/storage/gc?dry_run=true", Status: http.StatusOK},
+		{Name: "report-storage", Method: "GET", Path: "/reports/storage", Status: http.StatusOK},
+
+		// cleanup
+		{Name: "license-delete", Method: "DELETE", Path: "/licenseinfo/{license}", Status: http.StatusOK},
+		{Name: "license-delete-revocable", Method: "DELETE", Path: "/licenseinfo/{revocable}", Status: http.StatusOK},
+		{Name: "publication-delete", Method: "DELETE", Path: "/publications/" + pub, Status: http.StatusOK},
+		{Name: "mediatype-delete", Method: "DELETE", Path: "/mediatypes/" + format, Status: http.StatusOK},
+	}
+}
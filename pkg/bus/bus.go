@@ -0,0 +1,87 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package bus publishes the events of the licenses to a message bus, NATS or Kafka, for the integrations whose
+// volume exceeds webhooks. The bus is a target of the notifications of the webhook package: the events are
+// published in order, in the JSON payload of the webhooks, and retried with the same backoff. The clients speak
+// the few messages of the NATS and Kafka protocols needed to publish.
+package bus
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/webhook"
+)
+
+// Publisher publishes messages to a message bus.
+type Publisher interface {
+	// Publish publishes a message to a topic, or a subject, and returns once the bus received it.
+	// The messages of a key are kept in order.
+	Publish(ctx context.Context, topic, key string, value []byte) error
+	Close() error
+}
+
+// New returns the publisher of a configuration; it connects on the first publication.
+func New(c conf.Bus) (Publisher, error) {
+	switch c.Kind {
+	case "nats":
+		return NewNATS(c), nil
+	case "kafka":
+		return NewKafka(c), nil
+	}
+	return nil, fmt.Errorf("unknown message bus %q", c.Kind)
+}
+
+// Target returns the target of the notifications publishing them to a bus: with NATS, to the subject of each
+// event under the prefix of the configuration, e.g. "lcp.license.revoked"; with Kafka, to the topic of the
+// configuration, keyed by license so that the events of a license stay in order in its partition.
+func Target(p Publisher, c conf.Bus) webhook.Target {
+	return webhook.Target{
+		Events: c.Events,
+		Send: func(ctx context.Context, n *webhook.Notification) error {
+			data, err := json.Marshal(n)
+			if err != nil {
+				return err
+			}
+			topic := c.TopicName()
+			if c.Kind == "nats" {
+				topic += "." + n.Type
+			}
+			return p.Publish(ctx, topic, n.LicenseID, data)
+		},
+	}
+}
+
+// TargetName returns the name of the target of a bus, under which its cursors are stored.
+func TargetName(c conf.Bus) string {
+	return "bus:" + c.Kind
+}
+
+// dial connects to a server, with TLS if required
+func dial(ctx context.Context, addr string, useTLS bool, dl time.Time) (net.Conn, error) {
+	d := net.Dialer{Deadline: dl}
+	if !useTLS {
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	td := tls.Dialer{NetDialer: &d, Config: &tls.Config{ServerName: host}}
+	return td.DialContext(ctx, "tcp", addr)
+}
+
+// deadline returns the deadline of an exchange with a server
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	if d, ok := ctx.Deadline(); ok {
+		return d
+	}
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return time.Now().Add(timeout)
+}
@@ -0,0 +1,321 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package bus
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/webhook"
+)
+
+// message is a message received by a fake server
+type message struct {
+	topic, key, value string
+	partition         int32
+}
+
+func TestNATS(t *testing.T) {
+
+	var mu sync.Mutex
+	var messages []message
+	addr := fakeNATS(t, "secret", func(m message) {
+		mu.Lock()
+		messages = append(messages, m)
+		mu.Unlock()
+	})
+	c := conf.Bus{Kind: "nats", Servers: []string{addr}, Token: "secret"}
+	p, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// the events are published to their subject
+	target := Target(p, c)
+	n := &webhook.Notification{ID: "audit-1", Type: conf.WEBHOOK_LICENSE_REVOKED, LicenseID: "123"}
+	if err = target.Send(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	if err = target.Send(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(messages) != 2 || messages[0].topic != "lcp.license.revoked" {
+		t.Fatalf("Unexpected messages %+v", messages)
+	}
+	var received webhook.Notification
+	if err = json.Unmarshal([]byte(messages[0].value), &received); err != nil || received.ID != n.ID {
+		t.Errorf("Unexpected payload %s", messages[0].value)
+	}
+	mu.Unlock()
+
+	// a rejected token fails the publication
+	p = NewNATS(conf.Bus{Kind: "nats", Servers: []string{addr}, Token: "wrong"})
+	if err = p.Publish(context.Background(), "lcp.test", "", []byte("{}")); err == nil {
+		t.Error("Expected an authorization error")
+	}
+}
+
+func TestKafka(t *testing.T) {
+
+	var mu sync.Mutex
+	var messages []message
+	addr := fakeKafka(t, "lcp-events", 4, func(m message) {
+		mu.Lock()
+		messages = append(messages, m)
+		mu.Unlock()
+	})
+	c := conf.Bus{Kind: "kafka", Servers: []string{addr}, Topic: "lcp-events"}
+	p, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// the events of a license are published to the partition of its key
+	target := Target(p, c)
+	for _, licenseID := range []string{"123", "456", "123"} {
+		n := &webhook.Notification{ID: "audit-1", Type: conf.WEBHOOK_LICENSE_CREATED, LicenseID: licenseID}
+		if err = target.Send(context.Background(), n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mu.Lock()
+	if len(messages) != 3 || messages[0].topic != "lcp-events" || messages[0].key != "123" {
+		t.Fatalf("Unexpected messages %+v", messages)
+	}
+	if messages[0].partition != messages[2].partition || messages[0].partition != int32(murmur2([]byte("123"))&0x7fffffff)%4 {
+		t.Errorf("Expected the events of a license in its partition, got %+v", messages)
+	}
+	var received webhook.Notification
+	if err = json.Unmarshal([]byte(messages[1].value), &received); err != nil || received.LicenseID != "456" {
+		t.Errorf("Unexpected payload %s", messages[1].value)
+	}
+	mu.Unlock()
+
+	// an unknown topic fails the publication
+	if err = p.Publish(context.Background(), "unknown", "123", []byte("{}")); err == nil {
+		t.Error("Expected an unknown topic")
+	}
+}
+
+func TestMurmur2(t *testing.T) {
+
+	// hashes of the default partitioner of the Java client
+	for key, hash := range map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	} {
+		if h := murmur2([]byte(key)); h != hash {
+			t.Errorf("Expected the hash %d of %s, got %d", hash, key, h)
+		}
+	}
+}
+
+// fakeNATS accepts the publications authenticated by a token, and returns its address
+func fakeNATS(t *testing.T, token string, received func(message)) string {
+	return serve(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		io.WriteString(conn, "INFO {\"server_id\":\"fake\",\"auth_required\":true}\r\n")
+		authenticated := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 0:
+			case fields[0] == "CONNECT":
+				var options natsConnect
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &options)
+				if options.Token != token {
+					io.WriteString(conn, "-ERR 'Authorization Violation'\r\n")
+					return
+				}
+				authenticated = true
+			case fields[0] == "PING":
+				io.WriteString(conn, "PONG\r\n")
+			case fields[0] == "PUB" && authenticated && len(fields) == 3:
+				size, _ := strconv.Atoi(fields[2])
+				payload := make([]byte, size+2)
+				if _, err = io.ReadFull(r, payload); err != nil {
+					return
+				}
+				received(message{topic: fields[1], value: string(payload[:size])})
+			default:
+				io.WriteString(conn, "-ERR 'Unknown Protocol Operation'\r\n")
+				return
+			}
+		}
+	})
+}
+
+// fakeKafka is a broker leading the partitions of a topic, which accepts the records produced to them,
+// and returns its address
+func fakeKafka(t *testing.T, topic string, partitions int32, received func(message)) string {
+	var addr string
+	addr = serve(t, func(conn net.Conn) {
+		for {
+			var size [4]byte
+			if _, err := io.ReadFull(conn, size[:]); err != nil {
+				return
+			}
+			req := make([]byte, binary.BigEndian.Uint32(size[:]))
+			if _, err := io.ReadFull(conn, req); err != nil {
+				return
+			}
+			d := &kafkaDecoder{b: req}
+			apiKey, version, correlation := d.int16(), d.int16(), d.int32()
+			d.string() // client id
+			resp := appendInt32(nil, correlation)
+			switch {
+			case apiKey == kafkaMetadata && version == kafkaMetadataVersion:
+				host, portStr, _ := net.SplitHostPort(addr)
+				port, _ := strconv.Atoi(portStr)
+				resp = appendInt32(resp, 1)
+				resp = appendInt32(resp, 1) // node id
+				resp = appendString(resp, host)
+				resp = appendInt32(resp, int32(port))
+				resp = appendNullableString(resp, nil)
+				resp = appendInt32(resp, 1) // controller
+				d.int32()
+				name := d.string()
+				resp = appendInt32(resp, 1)
+				if name != topic {
+					resp = appendInt16(resp, 3) // unknown topic or partition
+					resp = appendString(resp, name)
+					resp = append(resp, 0)
+					resp = appendInt32(resp, 0)
+					break
+				}
+				resp = appendInt16(resp, 0)
+				resp = appendString(resp, name)
+				resp = append(resp, 0)
+				resp = appendInt32(resp, partitions)
+				for p := int32(0); p < partitions; p++ {
+					resp = appendInt16(resp, 0)
+					resp = appendInt32(resp, p)
+					resp = appendInt32(resp, 1)                 // leader
+					resp = appendInt32(appendInt32(resp, 1), 1) // replicas
+					resp = appendInt32(appendInt32(resp, 1), 1) // in-sync replicas
+				}
+			case apiKey == kafkaProduce && version == kafkaProduceVersion:
+				d.nullableString()
+				if acks := d.int16(); acks != -1 {
+					t.Errorf("Expected the acks of every replica, got %d", acks)
+				}
+				d.int32()
+				d.int32()
+				name := d.string()
+				d.int32()
+				partition := d.int32()
+				batch := d.next(int(d.int32()))
+				key, value, err := decodeBatch(batch)
+				code := int16(0)
+				if err != nil {
+					t.Error(err)
+					code = 2 // corrupt message
+				} else {
+					received(message{topic: name, key: key, value: value, partition: partition})
+				}
+				resp = appendInt32(resp, 1)
+				resp = appendString(resp, name)
+				resp = appendInt32(resp, 1)
+				resp = appendInt32(resp, partition)
+				resp = appendInt16(resp, code)
+				resp = appendInt64(appendInt64(resp, 0), -1)
+				resp = appendInt32(resp, 0) // throttle time
+			default:
+				t.Errorf("Unexpected request %d v%d", apiKey, version)
+				return
+			}
+			conn.Write(append(appendInt32(nil, int32(len(resp))), resp...))
+		}
+	})
+	return addr
+}
+
+// decodeBatch decodes the record of a batch, checking its checksum
+func decodeBatch(batch []byte) (key, value string, err error) {
+	d := &kafkaDecoder{b: batch}
+	d.int64() // base offset
+	if length := d.int32(); int(length) != len(d.b) {
+		return "", "", fmt.Errorf("invalid batch length %d", length)
+	}
+	d.int32() // leader epoch
+	if magic := d.int8(); magic != 2 {
+		return "", "", fmt.Errorf("invalid magic %d", magic)
+	}
+	if crc := uint32(d.int32()); crc != crc32.Checksum(d.b, crc32.MakeTable(crc32.Castagnoli)) {
+		return "", "", errors.New("invalid checksum")
+	}
+	d.next(2 + 4 + 8 + 8 + 8 + 2 + 4) // attributes to base sequence
+	if records := d.int32(); records != 1 {
+		return "", "", fmt.Errorf("expected a record, got %d", records)
+	}
+	r := &varintReader{b: d.b}
+	r.varint()    // length
+	r.b = r.b[1:] // attributes
+	r.varint()    // timestamp delta
+	r.varint()    // offset delta
+	key = string(r.next(int(r.varint())))
+	value = string(r.next(int(r.varint())))
+	return key, value, d.err
+}
+
+// varintReader reads the varints of a record
+type varintReader struct{ b []byte }
+
+func (r *varintReader) varint() int64 {
+	v, n := binary.Varint(r.b)
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *varintReader) next(n int) []byte {
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+// serve serves the connections of a fake server, and returns its address
+func serve(t *testing.T, handle func(conn net.Conn)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
@@ -0,0 +1,426 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package bus
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+)
+
+// Kafka publishes messages to the topics of a Kafka cluster. A message is sent to the leader of the partition
+// of its key, chosen as by the default partitioner of the Java client, and acknowledged by every in-sync replica.
+type Kafka struct {
+	Config  conf.Bus
+	Timeout time.Duration // max time of a publication without deadline in its context; 10s if 0
+
+	mu          sync.Mutex
+	correlation int32
+	brokers     map[int32]string      // addresses of the brokers, by node id
+	leaders     map[string][]int32    // leaders of the partitions, by topic
+	conns       map[string]*kafkaConn // connections, by broker address
+	next        int                   // index of the next bootstrap broker tried
+}
+
+type kafkaConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Kafka API keys and versions
+const (
+	kafkaProduce  = 0
+	kafkaMetadata = 3
+
+	kafkaProduceVersion  = 3
+	kafkaMetadataVersion = 1
+)
+
+// kafkaError is an error code of a Kafka response
+type kafkaError int16
+
+func (e kafkaError) Error() string {
+	return "kafka: error code " + strconv.Itoa(int(e))
+}
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// NewKafka creates a publisher to Kafka.
+func NewKafka(c conf.Bus) *Kafka {
+	return &Kafka{Config: c, conns: make(map[string]*kafkaConn)}
+}
+
+// Publish publishes a message to a topic, in the partition of its key.
+func (k *Kafka) Publish(ctx context.Context, topic, key string, value []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	dl := deadline(ctx, k.Timeout)
+	err := k.publish(ctx, topic, key, value, dl)
+	if err != nil {
+		// the metadata may be stale, e.g. after the election of a new leader
+		k.reset()
+	}
+	return err
+}
+
+// Close closes the connections to the brokers.
+func (k *Kafka) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.reset()
+	return nil
+}
+
+// publish sends a message to the leader of its partition
+func (k *Kafka) publish(ctx context.Context, topic, key string, value []byte, dl time.Time) error {
+	leaders, err := k.partitions(ctx, topic, dl)
+	if err != nil {
+		return err
+	}
+	partition := int32(murmur2([]byte(key))&0x7fffffff) % int32(len(leaders))
+	addr, ok := k.brokers[leaders[partition]]
+	if !ok {
+		return fmt.Errorf("kafka: no leader of the partition %d of %s", partition, topic)
+	}
+	conn, err := k.conn(ctx, addr, dl)
+	if err != nil {
+		return err
+	}
+
+	req := appendNullableString(nil, nil) // transactional id
+	req = appendInt16(req, -1)            // acks of every in-sync replica
+	req = appendInt32(req, int32(k.timeout(dl).Milliseconds()))
+	req = appendInt32(req, 1)
+	req = appendString(req, topic)
+	req = appendInt32(req, 1)
+	req = appendInt32(req, partition)
+	batch := recordBatch([]byte(key), value, time.Now())
+	req = appendInt32(req, int32(len(batch)))
+	req = append(req, batch...)
+	resp, err := k.roundTrip(conn, kafkaProduce, kafkaProduceVersion, req)
+	if err != nil {
+		return err
+	}
+
+	d := &kafkaDecoder{b: resp}
+	for topics := d.int32(); topics > 0; topics-- {
+		d.string()
+		for partitions := d.int32(); partitions > 0; partitions-- {
+			d.int32()
+			if code := d.int16(); code != 0 && d.err == nil {
+				return kafkaError(code)
+			}
+			d.int64() // base offset
+			d.int64() // log append time
+		}
+	}
+	return d.err
+}
+
+// partitions returns the leaders of the partitions of a topic, requesting the metadata of the topic if unknown
+func (k *Kafka) partitions(ctx context.Context, topic string, dl time.Time) ([]int32, error) {
+	if leaders, ok := k.leaders[topic]; ok {
+		return leaders, nil
+	}
+	if len(k.Config.Servers) == 0 {
+		return nil, errors.New("kafka: no bootstrap broker")
+	}
+	addr := k.Config.Servers[k.next%len(k.Config.Servers)]
+	k.next++
+	conn, err := k.conn(ctx, addr, dl)
+	if err != nil {
+		return nil, err
+	}
+	req := appendInt32(nil, 1)
+	req = appendString(req, topic)
+	resp, err := k.roundTrip(conn, kafkaMetadata, kafkaMetadataVersion, req)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &kafkaDecoder{b: resp}
+	brokers := make(map[int32]string)
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		node := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.nullableString() // rack
+		brokers[node] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller
+	var leaders []int32
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		code := d.int16()
+		name := d.string()
+		d.int8() // internal
+		if name == topic && code != 0 {
+			return nil, kafkaError(code)
+		}
+		for p := d.int32(); p > 0 && d.err == nil; p-- {
+			d.int16() // error code of the partition, which has no leader if it is offline
+			index := d.int32()
+			leader := d.int32()
+			d.int32s() // replicas
+			d.int32s() // in-sync replicas
+			if name != topic {
+				continue
+			}
+			for int(index) >= len(leaders) {
+				leaders = append(leaders, -1)
+			}
+			leaders[index] = leader
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(leaders) == 0 {
+		return nil, fmt.Errorf("kafka: unknown topic %s", topic)
+	}
+	if k.brokers == nil {
+		k.brokers = make(map[int32]string)
+	}
+	for node, addr := range brokers {
+		k.brokers[node] = addr
+	}
+	if k.leaders == nil {
+		k.leaders = make(map[string][]int32)
+	}
+	k.leaders[topic] = leaders
+	return leaders, nil
+}
+
+// conn returns the connection to a broker, or opens it
+func (k *Kafka) conn(ctx context.Context, addr string, dl time.Time) (*kafkaConn, error) {
+	if conn, ok := k.conns[addr]; ok {
+		conn.SetDeadline(dl)
+		return conn, nil
+	}
+	nc, err := dial(ctx, addr, k.Config.TLS, dl)
+	if err != nil {
+		return nil, err
+	}
+	nc.SetDeadline(dl)
+	conn := &kafkaConn{Conn: nc, r: bufio.NewReader(nc)}
+	k.conns[addr] = conn
+	return conn, nil
+}
+
+// roundTrip sends a request to a broker and returns the body of its response
+func (k *Kafka) roundTrip(conn *kafkaConn, apiKey, version int16, body []byte) ([]byte, error) {
+	k.correlation++
+	header := appendInt16(nil, apiKey)
+	header = appendInt16(header, version)
+	header = appendInt32(header, k.correlation)
+	header = appendString(header, "lcp-server")
+	req := appendInt32(nil, int32(len(header)+len(body)))
+	req = append(append(req, header...), body...)
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(conn.r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n < 4 || n > 64*1024*1024 {
+		return nil, fmt.Errorf("kafka: invalid response size %d", n)
+	}
+	resp := make([]byte, n)
+	if _, err := io.ReadFull(conn.r, resp); err != nil {
+		return nil, err
+	}
+	if correlation := int32(binary.BigEndian.Uint32(resp)); correlation != k.correlation {
+		return nil, fmt.Errorf("kafka: unexpected correlation id %d", correlation)
+	}
+	return resp[4:], nil
+}
+
+// timeout returns the time left before a deadline
+func (k *Kafka) timeout(dl time.Time) time.Duration {
+	if t := time.Until(dl); t > 0 {
+		return t
+	}
+	return time.Millisecond
+}
+
+// reset closes the connections and forgets the metadata
+func (k *Kafka) reset() {
+	for addr, conn := range k.conns {
+		conn.Close()
+		delete(k.conns, addr)
+	}
+	k.brokers, k.leaders = nil, nil
+}
+
+// recordBatch encodes a batch of one record, in the format of the version 2 of the messages
+func recordBatch(key, value []byte, t time.Time) []byte {
+	record := []byte{0}              // attributes
+	record = appendVarint(record, 0) // timestamp delta
+	record = appendVarint(record, 0) // offset delta
+	record = appendVarint(record, int64(len(key)))
+	record = append(record, key...)
+	record = appendVarint(record, int64(len(value)))
+	record = append(record, value...)
+	record = appendVarint(record, 0) // headers
+
+	ms := t.UnixNano() / int64(time.Millisecond)
+	batch := appendInt16(nil, 0)   // attributes: no compression, create time
+	batch = appendInt32(batch, 0)  // last offset delta
+	batch = appendInt64(batch, ms) // first timestamp
+	batch = appendInt64(batch, ms) // max timestamp
+	batch = appendInt64(batch, -1) // producer id
+	batch = appendInt16(batch, -1) // producer epoch
+	batch = appendInt32(batch, -1) // base sequence
+	batch = appendInt32(batch, 1)  // records
+	batch = appendVarint(batch, int64(len(record)))
+	batch = append(batch, record...)
+
+	b := appendInt64(nil, 0)                // base offset
+	b = appendInt32(b, int32(9+len(batch))) // length, from the leader epoch
+	b = appendInt32(b, -1)                  // partition leader epoch
+	b = append(b, 2)                        // magic
+	b = appendInt32(b, int32(crc32.Checksum(batch, crc32c)))
+	return append(b, batch...)
+}
+
+// murmur2 is the hash of the keys of the default partitioner of Kafka
+func murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// encoding of the primitive types of the Kafka protocol
+
+func appendInt16(b []byte, v int16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendInt32(b []byte, v int32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendInt64(b []byte, v int64) []byte {
+	return appendInt32(appendInt32(b, int32(v>>32)), int32(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendInt16(b, int16(len(s))), s...)
+}
+
+func appendNullableString(b []byte, s *string) []byte {
+	if s == nil {
+		return appendInt16(b, -1)
+	}
+	return appendString(b, *s)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+// kafkaDecoder decodes the primitive types of a response, recording the first error
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errors.New("kafka: truncated response")
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if v := d.next(1); v != nil {
+		return int8(v[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if v := d.next(2); v != nil {
+		return int16(binary.BigEndian.Uint16(v))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if v := d.next(4); v != nil {
+		return int32(binary.BigEndian.Uint32(v))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if v := d.next(8); v != nil {
+		return int64(binary.BigEndian.Uint64(v))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) string() string {
+	return string(d.next(int(d.int16())))
+}
+
+func (d *kafkaDecoder) nullableString() {
+	if n := d.int16(); n > 0 {
+		d.next(int(n))
+	}
+}
+
+func (d *kafkaDecoder) int32s() {
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		d.int32()
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package bus
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+)
+
+// NATS publishes messages to the subjects of a NATS server. Each publication is confirmed by a PING, so that it
+// returns once the server processed the message, e.g. stored it in a JetStream stream bound to the subject.
+type NATS struct {
+	Config  conf.Bus
+	Timeout time.Duration // max time of a publication without deadline in its context; 10s if 0
+
+	mu   sync.Mutex
+	conn net.Conn // nil until the first publication, or after a failure
+	r    *bufio.Reader
+	w    *bufio.Writer
+	next int // index of the next server tried
+}
+
+// natsConnect are the options sent by the client on connection
+type natsConnect struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	TLS      bool   `json:"tls_required"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+	Name     string `json:"name"`
+	Lang     string `json:"lang"`
+	Version  string `json:"version"`
+	Protocol int    `json:"protocol"`
+}
+
+// NewNATS creates a publisher to NATS.
+func NewNATS(c conf.Bus) *NATS {
+	return &NATS{Config: c}
+}
+
+// Publish publishes a message to a subject; NATS keeps the messages of a connection in order, whatever their key.
+func (n *NATS) Publish(ctx context.Context, subject, key string, value []byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		if err := n.connect(ctx); err != nil {
+			return err
+		}
+	}
+	n.conn.SetDeadline(deadline(ctx, n.Timeout))
+	fmt.Fprintf(n.w, "PUB %s %d\r\n", subject, len(value))
+	n.w.Write(value)
+	n.w.WriteString("\r\nPING\r\n")
+	err := n.w.Flush()
+	if err == nil {
+		err = n.pong()
+	}
+	if err != nil {
+		n.reset()
+	}
+	return err
+}
+
+// Close closes the connection.
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.reset()
+	return nil
+}
+
+// connect connects to the next server, announced by its INFO, and authenticates
+func (n *NATS) connect(ctx context.Context) error {
+	if len(n.Config.Servers) == 0 {
+		return errors.New("nats: no server")
+	}
+	addr := n.Config.Servers[n.next%len(n.Config.Servers)]
+	n.next++
+	dl := deadline(ctx, n.Timeout)
+	conn, err := dial(ctx, addr, false, dl)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(dl)
+	n.conn, n.r = conn, bufio.NewReader(conn)
+	line, err := n.r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		n.reset()
+		return fmt.Errorf("nats: no INFO from %s: %v", addr, err)
+	}
+
+	// the TLS handshake follows the INFO of the server
+	if n.Config.TLS {
+		host, _, _ := net.SplitHostPort(addr)
+		tc := tls.Client(conn, &tls.Config{ServerName: host})
+		if err = tc.HandshakeContext(ctx); err != nil {
+			n.reset()
+			return err
+		}
+		n.conn, n.r = tc, bufio.NewReader(tc)
+	}
+	n.w = bufio.NewWriter(n.conn)
+
+	options, _ := json.Marshal(natsConnect{
+		TLS:      n.Config.TLS,
+		User:     n.Config.User,
+		Pass:     n.Config.Password,
+		Token:    n.Config.Token,
+		Name:     "lcp-server",
+		Lang:     "go",
+		Version:  "1.0",
+		Protocol: 1,
+	})
+	fmt.Fprintf(n.w, "CONNECT %s\r\nPING\r\n", options)
+	if err = n.w.Flush(); err == nil {
+		err = n.pong()
+	}
+	if err != nil {
+		n.reset()
+	}
+	return err
+}
+
+// pong waits for the PONG answering a PING, answering the PINGs of the server meanwhile
+func (n *NATS) pong() error {
+	for {
+		line, err := n.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			n.w.WriteString("PONG\r\n")
+			if err = n.w.Flush(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("nats: " + strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// +OK and the INFO updates of the cluster are ignored
+	}
+}
+
+// reset closes the connection, which is reopened by the next publication
+func (n *NATS) reset() {
+	if n.conn != nil {
+		n.conn.Close()
+	}
+	n.conn, n.r, n.w = nil, nil, nil
+}
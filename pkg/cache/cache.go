@@ -0,0 +1,50 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package cache keeps the responses of the public routes for a time, in memory or in a Redis server shared by the
+// instances of the server, so that the repeated requests of reading systems don't reach the database. The cached
+// responses of a license or a publication are invalidated by its changes: by the routes which change it, and by
+// an Invalidator following the audit log for the changes made by the background tasks or by other instances.
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+)
+
+// ErrMiss is returned when a key is not in the cache, or has expired.
+var ErrMiss = errors.New("cache miss")
+
+// Cache keeps values for a time.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, error) // ErrMiss if the key is not cached
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	Close() error
+}
+
+// New returns the cache of a configuration.
+func New(c conf.ResponseCache) (Cache, error) {
+	switch c.Backend {
+	case "memory":
+		return NewMemory(c.Entries()), nil
+	case "redis":
+		return NewRedis(c.Redis), nil
+	}
+	return nil, fmt.Errorf("unknown cache backend %q", c.Backend)
+}
+
+// StatusKey returns the key of the cached status document of a license.
+func StatusKey(licenseID string) string {
+	return "lcp:status:" + licenseID
+}
+
+// ManifestKey returns the key of the cached manifest of a publication.
+func ManifestKey(publicationID string) string {
+	return "lcp:manifest:" + publicationID
+}
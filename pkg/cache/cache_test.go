@@ -0,0 +1,223 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/stortest"
+)
+
+// testCache checks the operations of a cache
+func testCache(t *testing.T, c Cache) {
+	ctx := context.Background()
+	if _, err := c.Get(ctx, "a"); !errors.Is(err, ErrMiss) {
+		t.Errorf("Expected a miss, got %v", err)
+	}
+	if err := c.Set(ctx, "a", []byte("1\r\n2"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(ctx, "b", []byte{}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := c.Get(ctx, "a"); err != nil || string(value) != "1\r\n2" {
+		t.Errorf("Expected the cached value, got %q %v", value, err)
+	}
+	if value, err := c.Get(ctx, "b"); err != nil || len(value) != 0 {
+		t.Errorf("Expected an empty value, got %q %v", value, err)
+	}
+	if err := c.Delete(ctx, "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "a"); !errors.Is(err, ErrMiss) {
+		t.Errorf("Expected a miss, got %v", err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+
+	m := NewMemory(2)
+	testCache(t, m)
+
+	// the values expire, and the least recently used ones are evicted
+	ctx := context.Background()
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	m.Set(ctx, "a", []byte("1"), time.Second)
+	m.Set(ctx, "b", []byte("2"), time.Minute)
+	m.Get(ctx, "a")
+	m.Set(ctx, "c", []byte("3"), time.Minute)
+	if _, err := m.Get(ctx, "b"); !errors.Is(err, ErrMiss) {
+		t.Errorf("Expected the least recently used value to be evicted, got %v", err)
+	}
+	now = now.Add(2 * time.Second)
+	if _, err := m.Get(ctx, "a"); !errors.Is(err, ErrMiss) {
+		t.Errorf("Expected the value to expire, got %v", err)
+	}
+	if value, err := m.Get(ctx, "c"); err != nil || string(value) != "3" {
+		t.Errorf("Expected the cached value, got %q %v", value, err)
+	}
+}
+
+func TestRedis(t *testing.T) {
+
+	addr := fakeRedis(t, "secret")
+	c, err := New(conf.ResponseCache{Backend: "redis", Redis: conf.Redis{Addr: addr, Password: "secret", DB: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	testCache(t, c)
+
+	// a rejected password fails the commands
+	c = NewRedis(conf.Redis{Addr: addr, Password: "wrong"})
+	if _, err = c.Get(context.Background(), "a"); err == nil || errors.Is(err, ErrMiss) {
+		t.Errorf("Expected an authentication error, got %v", err)
+	}
+}
+
+func TestInvalidator(t *testing.T) {
+
+	st := stortest.SQLite()(t)
+	pub := stortest.CreatePublications(t, st, 1, "application/epub+zip")[0]
+	licenses := stortest.CreateLicenses(t, st, 2, pub.UUID, "user1")
+	c := NewMemory(10)
+	ctx := context.Background()
+	for _, l := range licenses {
+		c.Set(ctx, StatusKey(l.UUID), []byte("{}"), time.Minute)
+	}
+
+	// the licenses changed before the first round are ignored, the following ones are invalidated
+	i := NewInvalidator(c, st)
+	if err := i.Invalidate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	licenses[0].Status = stor.STATUS_REVOKED
+	if err := st.License().Update(licenses[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Invalidate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, StatusKey(licenses[0].UUID)); !errors.Is(err, ErrMiss) {
+		t.Errorf("Expected the status document of the revoked license to be invalidated, got %v", err)
+	}
+	if _, err := c.Get(ctx, StatusKey(licenses[1].UUID)); err != nil {
+		t.Errorf("Expected the status document of the other license to be cached, got %v", err)
+	}
+
+	// a recent change is invalidated again by the following rounds
+	c.Set(ctx, StatusKey(licenses[0].UUID), []byte("{}"), time.Minute)
+	if err := i.Invalidate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, StatusKey(licenses[0].UUID)); !errors.Is(err, ErrMiss) {
+		t.Errorf("Expected the status document to be invalidated again, got %v", err)
+	}
+}
+
+// fakeRedis serves the commands of the cache, requiring a password, and returns its address
+func fakeRedis(t *testing.T, password string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	var mu sync.Mutex
+	values := make(map[string]string)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				authenticated := false
+				for {
+					args, err := readCommand(r)
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					switch cmd := strings.ToUpper(args[0]); {
+					case cmd == "AUTH" && args[1] == password:
+						authenticated = true
+						io.WriteString(conn, "+OK\r\n")
+					case cmd == "AUTH":
+						io.WriteString(conn, "-WRONGPASS invalid password\r\n")
+					case !authenticated:
+						io.WriteString(conn, "-NOAUTH Authentication required\r\n")
+					case cmd == "SELECT":
+						io.WriteString(conn, "+OK\r\n")
+					case cmd == "GET":
+						if value, ok := values[args[1]]; ok {
+							fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value), value)
+						} else {
+							io.WriteString(conn, "$-1\r\n")
+						}
+					case cmd == "SET" && len(args) == 5 && args[3] == "PX":
+						values[args[1]] = args[2]
+						io.WriteString(conn, "+OK\r\n")
+					case cmd == "DEL":
+						n := 0
+						for _, key := range args[1:] {
+							if _, ok := values[key]; ok {
+								delete(values, key)
+								n++
+							}
+						}
+						fmt.Fprintf(conn, ":%d\r\n", n)
+					default:
+						io.WriteString(conn, "-ERR unknown command\r\n")
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// readCommand reads a command of the RESP protocol
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || line[0] != '*' || n < 1 {
+		return nil, errors.New("malformed command")
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	log "github.com/sirupsen/logrus"
+)
+
+// Settle is the time during which the changes of the audit log are invalidated again at each round, so that a
+// change committed meanwhile by a concurrent transaction, with a lower identifier, is not skipped, and a response
+// cached by a request racing with a change is dropped.
+const Settle = 5 * time.Second
+
+// Invalidator drops the cached status documents of the licenses changed out of the routes which invalidate them,
+// e.g. by a scheduled revocation, a bulk job or another instance of the server, by following the audit log.
+type Invalidator struct {
+	Cache    Cache
+	Store    stor.Store
+	Interval time.Duration // time between the checks of the audit log; 1s if 0
+
+	cursor  uint // last audit record older than Settle
+	started bool
+}
+
+// NewInvalidator creates an invalidator following the audit log of a store.
+func NewInvalidator(c Cache, st stor.Store) *Invalidator {
+	return &Invalidator{Cache: c, Store: st, Interval: time.Second}
+}
+
+// Run invalidates the cached responses of the changed licenses at each interval, until the context is cancelled.
+func (i *Invalidator) Run(ctx context.Context) {
+	interval := i.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := i.Invalidate(ctx); err != nil {
+				log.Errorf("Cache invalidation failed: %v", err)
+			}
+		}
+	}
+}
+
+// Invalidate drops the cached responses of the licenses changed since the previous call. The first call starts
+// following the audit log, as nothing was cached before.
+func (i *Invalidator) Invalidate(ctx context.Context) error {
+	st := i.Store.WithContext(ctx)
+	if !i.started {
+		last, err := st.Audit().Last()
+		if err != nil {
+			return err
+		}
+		i.cursor, i.started = last, true
+		return nil
+	}
+	now := time.Now()
+	records, err := st.Audit().Since(i.cursor, now.Add(time.Hour), 1000)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(*records))
+	settled := true
+	for _, r := range *records {
+		keys = append(keys, StatusKey(r.LicenseID))
+		if settled && r.Timestamp.Before(now.Add(-Settle)) {
+			i.cursor = r.ID
+		} else {
+			settled = false
+		}
+	}
+	return i.Cache.Delete(ctx, keys...)
+}
@@ -0,0 +1,213 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+)
+
+// Redis is a cache in a Redis server, shared by the instances of the server. It speaks the few commands of the
+// RESP protocol it needs over a pool of connections.
+type Redis struct {
+	Config  conf.Redis
+	Timeout time.Duration // max time of a command without deadline in its context; 1s if 0
+
+	mu     sync.Mutex
+	idle   []*redisConn // idle connections, reused by the next commands
+	closed bool
+}
+
+// maxIdle is the max number of idle connections kept in the pool
+const maxIdle = 16
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// redisError is an error reply of the server, which leaves the connection usable
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// NewRedis creates a cache in a Redis server; the connections are opened by the first commands.
+func NewRedis(c conf.Redis) *Redis {
+	return &Redis{Config: c}
+}
+
+// Get returns a cached value.
+func (c *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := c.do(ctx, "GET", key)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, ErrMiss
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected reply %v to GET", reply)
+	}
+	return value, nil
+}
+
+// Set caches a value for a time.
+func (c *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := c.do(ctx, "SET", key, string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// Delete removes cached values.
+func (c *Redis) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := c.do(ctx, append([]string{"DEL"}, keys...)...)
+	return err
+}
+
+// Close closes the idle connections; the connections in use are closed once released.
+func (c *Redis) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for _, conn := range c.idle {
+		conn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+// do sends a command and reads its reply: a string, an integer, a byte slice, or nil for a missing value
+func (c *Redis) do(ctx context.Context, args ...string) (interface{}, error) {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(c.deadline(ctx))
+	reply, err := conn.do(args...)
+	var rerr redisError
+	if err != nil && !errors.As(err, &rerr) {
+		conn.Close()
+		return nil, err
+	}
+	c.release(conn)
+	return reply, err
+}
+
+// deadline returns the deadline of a command
+func (c *Redis) deadline(ctx context.Context) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	return time.Now().Add(timeout)
+}
+
+// conn returns an idle connection, or opens one
+func (c *Redis) conn(ctx context.Context) (*redisConn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return conn, nil
+	}
+	c.mu.Unlock()
+
+	d := net.Dialer{Deadline: c.deadline(ctx)}
+	nc, err := d.DialContext(ctx, "tcp", c.Config.Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	conn.SetDeadline(c.deadline(ctx))
+	if c.Config.Password != "" {
+		if _, err = conn.do("AUTH", c.Config.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.Config.DB != 0 {
+		if _, err = conn.do("SELECT", strconv.Itoa(c.Config.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// release returns a connection to the pool
+func (c *Redis) release(conn *redisConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || len(c.idle) >= maxIdle {
+		conn.Close()
+		return
+	}
+	c.idle = append(c.idle, conn)
+}
+
+// do sends a command on a connection and reads its reply
+func (conn *redisConn) do(args ...string) (interface{}, error) {
+	fmt.Fprintf(conn.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(conn.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := conn.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(conn.r)
+}
+
+// readReply reads a reply of the RESP protocol; arrays are not used by the commands of the cache
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	}
+	return nil, fmt.Errorf("redis: unsupported reply type %q", kind)
+}
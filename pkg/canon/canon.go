@@ -0,0 +1,56 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package canon computes the canonical form of JSON documents, i.e. the exact
+// sequence of bytes which is signed in an LCP license.
+//
+// The canonical form is a compact serialization where:
+// - object keys are sorted in lexicographic order,
+// - numbers are kept as they were serialized,
+// - characters are not escaped, except those which must be escaped in a json string.
+package canon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// Marshal returns the canonical form of any structure serializable in json.
+func Marshal(in interface{}) ([]byte, error) {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	return Transform(b)
+}
+
+// Transform returns the canonical form of a json document.
+func Transform(data []byte) ([]byte, error) {
+
+	// the easiest way to canonicalize is to reify the document as maps,
+	// which are sorted by the encoder
+	var jsonObj interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&jsonObj); err != nil {
+		return nil, err
+	}
+	// a single json value is expected
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid data after the json document")
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// do not escape characters
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(jsonObj); err != nil {
+		return nil, err
+	}
+	// remove the trailing newline, added by encode
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
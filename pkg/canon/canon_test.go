@@ -0,0 +1,104 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package canon
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestTransform(t *testing.T) {
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		// keys are sorted, whitespace is removed
+		{`{"b": 1, "a": {"d": [3, 2], "c": null}}`, `{"a":{"c":null,"d":[3,2]},"b":1}`},
+		// numbers are kept as serialized
+		{`{"n": 1.50, "e": 1e3, "i": -0}`, `{"e":1e3,"i":-0,"n":1.50}`},
+		// html characters and non-ascii characters are not escaped
+		{`{"href": "https://x.org/?a=1&b=<2>", "title": "L'été \u00e0 Paris"}`, `{"href":"https://x.org/?a=1&b=<2>","title":"L'été à Paris"}`},
+		// control characters and quotes are escaped
+		{`{"s": "a\"b\\c\n\u0001"}`, `{"s":"a\"b\\c\n\u0001"}`},
+	}
+	for _, tt := range tests {
+		got, err := Transform([]byte(tt.in))
+		if err != nil {
+			t.Errorf("Failed to canonicalize %s: %v", tt.in, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("Canonical form of %s:\ngot  %s\nwant %s", tt.in, got, tt.want)
+		}
+	}
+
+	// invalid documents
+	for _, in := range []string{``, `{"a":1`, `{"a":1} {"b":2}`, `[1,2]]`} {
+		if _, err := Transform([]byte(in)); err == nil {
+			t.Errorf("Invalid document accepted: %s", in)
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+
+	in := struct {
+		Z string `json:"z"`
+		A []int  `json:"a,omitempty"`
+		M string `json:"m"`
+	}{Z: "<z>", M: "m"}
+
+	got, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `{"m":"m","z":"<z>"}` {
+		t.Errorf("Unexpected canonical form: %s", got)
+	}
+}
+
+// FuzzTransform checks that the canonical form is a stable, lossless serialization.
+// The seed corpus is in testdata/fuzz/FuzzTransform; run the fuzzer with
+// "go test ./pkg/canon -fuzz FuzzTransform".
+func FuzzTransform(f *testing.F) {
+
+	if license, err := os.ReadFile("../../test/license1.lcpl"); err == nil {
+		f.Add(license)
+	}
+	f.Add([]byte(`{"provider":"https://edrlab.org","id":"1","rights":{"print":10,"copy":2048}}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		canonical, err := Transform(data)
+		if err != nil {
+			return
+		}
+		// the canonical form is a fixed point
+		again, err := Transform(canonical)
+		if err != nil {
+			t.Fatalf("Failed to canonicalize a canonical form %q: %v", canonical, err)
+		}
+		if !bytes.Equal(canonical, again) {
+			t.Fatalf("Unstable canonical form:\n%q\n%q", canonical, again)
+		}
+		// the canonical form holds the same data as the input
+		if !reflect.DeepEqual(decode(t, data), decode(t, canonical)) {
+			t.Fatalf("The canonical form %q differs from the input %q", canonical, data)
+		}
+	})
+}
+
+func decode(t *testing.T, data []byte) interface{} {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
@@ -15,7 +15,6 @@ import (
 
 	"github.com/edrlab/lcp-server/pkg/lic"
 	log "github.com/sirupsen/logrus"
-	jsonschema "github.com/xeipuuv/gojsonschema"
 )
 
 // LicenseChecker is the structure passed to every checker method
@@ -138,43 +137,18 @@ func Checker(bytes []byte, passphrase string, level uint) error {
 // Check the validity of the license using the JSON schema
 func validateLicense(bytes []byte) error {
 
-	licenseSchema, err := jsfs.ReadFile("data/license.schema.json")
-	if err != nil {
-		return err
-	}
-	linkSchema, err := jsfs.ReadFile("data/link.schema.json")
-	if err != nil {
-		return err
-	}
-
-	sl := jsonschema.NewSchemaLoader()
-	linkLoader := jsonschema.NewStringLoader(string(linkSchema))
-	err = sl.AddSchemas(linkLoader)
-	if err != nil {
-		return err
-	}
-	licenseLoader := jsonschema.NewStringLoader(string(licenseSchema))
-	schema, err := sl.Compile(licenseLoader)
-	if err != nil {
-		return err
-	}
-
-	documentLoader := jsonschema.NewStringLoader(string(bytes[:]))
-
-	result, err := schema.Validate(documentLoader)
-	if err != nil {
-		return err
-	}
-
-	if result.Valid() {
-		log.Info("The license is valid vs the json schema")
-	} else {
-		for _, desc := range result.Errors() {
+	err := ValidateSchema(LicenseSchema, bytes)
+	var schemaErr *SchemaError
+	if errors.As(err, &schemaErr) {
+		for _, desc := range schemaErr.Errors {
 			fmt.Printf("- %s\n", desc)
 		}
 		return errors.New("invalid license") // stop checking
 	}
-
+	if err != nil {
+		return err
+	}
+	log.Info("The license is valid vs the json schema")
 	return nil
 }
 
@@ -12,7 +12,6 @@ import (
 	"regexp"
 
 	log "github.com/sirupsen/logrus"
-	jsonschema "github.com/xeipuuv/gojsonschema"
 )
 
 // Check the license status document
@@ -80,52 +79,25 @@ func (c *LicenseChecker) ValidateStatusDoc() error {
 		return err
 	}
 
-	// load the embedded schema
-	statusDocSchema, err := jsfs.ReadFile("data/status.schema.json")
-	if err != nil {
-		return err
-	}
-	linkSchema, err := jsfs.ReadFile("data/link.schema.json")
-	if err != nil {
-		return err
-	}
-	sl := jsonschema.NewSchemaLoader()
-	linkLoader := jsonschema.NewStringLoader(string(linkSchema))
-	err = sl.AddSchemas(linkLoader)
-	if err != nil {
-		return err
-	}
-	statusDocLoader := jsonschema.NewStringLoader(string(statusDocSchema))
-	schema, err := sl.Compile(statusDocLoader)
-	if err != nil {
-		return err
-	}
-
-	//docStr := string(bytes)
-	//fmt.Println(docStr)
-
-	documentLoader := jsonschema.NewStringLoader(string(bytes))
-
 	// validate the status doc
 	// TODO: it appears that the uri-template format used in links is not properly validated
 	// using the current json schema package. We had to modify the link model in the schema
 	// to get status documents validated. This json schema package is not maintained anymore,
 	// threrefore we'll have to find a solution and propose a PR
 	// to a maintained fork (https://github.com/gojsonschema/gojsonschema)
-	result, err := schema.Validate(documentLoader)
-	if err != nil {
-		return err
-	}
-
-	if result.Valid() {
-		log.Info("The status doc is valid vs the json schema")
-	} else {
+	err = ValidateSchema(StatusSchema, bytes)
+	var schemaErr *SchemaError
+	if errors.As(err, &schemaErr) {
 		log.Error("The status doc is invalid vs the json schema")
-		for _, desc := range result.Errors() {
+		for _, desc := range schemaErr.Errors {
 			fmt.Printf("- %s\n", desc)
 		}
 		return errors.New("invalid status doc") // stop checking
 	}
+	if err != nil {
+		return err
+	}
+	log.Info("The status doc is valid vs the json schema")
 	return nil
 }
 
@@ -0,0 +1,84 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package check
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	jsonschema "github.com/xeipuuv/gojsonschema"
+)
+
+// Embedded JSON schemas of the LCP and LSD specifications
+const (
+	LicenseSchema = "data/license.schema.json"
+	StatusSchema  = "data/status.schema.json"
+)
+
+// SchemaError lists the properties of a document which do not conform to a JSON schema.
+type SchemaError struct {
+	Schema string
+	Errors []string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("the document does not conform to %s: %s", e.Schema, strings.Join(e.Errors, "; "))
+}
+
+// compiled schemas, by file name
+var (
+	schemasMu sync.Mutex
+	schemas   = map[string]*jsonschema.Schema{}
+)
+
+// loadSchema compiles an embedded schema, along with the schema of links it references, once.
+func loadSchema(name string) (*jsonschema.Schema, error) {
+	schemasMu.Lock()
+	defer schemasMu.Unlock()
+	if schema, ok := schemas[name]; ok {
+		return schema, nil
+	}
+
+	docSchema, err := jsfs.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	linkSchema, err := jsfs.ReadFile("data/link.schema.json")
+	if err != nil {
+		return nil, err
+	}
+	sl := jsonschema.NewSchemaLoader()
+	if err = sl.AddSchemas(jsonschema.NewStringLoader(string(linkSchema))); err != nil {
+		return nil, err
+	}
+	schema, err := sl.Compile(jsonschema.NewStringLoader(string(docSchema)))
+	if err != nil {
+		return nil, err
+	}
+	schemas[name] = schema
+	return schema, nil
+}
+
+// ValidateSchema validates a JSON document against an embedded schema, e.g. LicenseSchema.
+// A nonconforming document returns a SchemaError.
+func ValidateSchema(name string, data []byte) error {
+	schema, err := loadSchema(name)
+	if err != nil {
+		return err
+	}
+	result, err := schema.Validate(jsonschema.NewBytesLoader(data))
+	if err != nil {
+		return err
+	}
+	if result.Valid() {
+		return nil
+	}
+	schemaErr := &SchemaError{Schema: name}
+	for _, desc := range result.Errors() {
+		schemaErr.Errors = append(schemaErr.Errors, desc.String())
+	}
+	return schemaErr
+}
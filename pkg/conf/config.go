@@ -5,27 +5,172 @@
 package conf
 
 import (
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 // LCP Server configuration
 type Config struct {
-	PublicBaseUrl string `yaml:"public_base_url"`
-	Port          int    `yaml:"port"`
-	Dsn           string `yaml:"dsn"`
-	Login         `yaml:"login"`
-	Certificate   `yaml:"certificate"`
-	License       `yaml:"license"`
-	Status        `yaml:"status"`
+	PublicBaseUrl   string           `yaml:"public_base_url"`
+	BasePath        string           `yaml:"base_path"`       // path prefix of every route, e.g. "/lcp"
+	TrustedProxies  []string         `yaml:"trusted_proxies"` // IP addresses or CIDR ranges of reverse proxies whose forwarded headers are trusted
+	ProxyHeaders    string           `yaml:"proxy_headers"`   // headers set by the trusted proxies, x-forwarded if empty
+	Listener        `yaml:",inline"` // public listener
+	ShutdownTimeout int              `yaml:"shutdown_timeout"` // max time in-flight requests are drained at shutdown, in seconds; 30 if 0
+	Dsn             string           `yaml:"dsn"`
+	ManualMigrate   bool             `yaml:"manual_migrate"` // the schema is migrated with the migrate command, not at startup
+	Login           `yaml:"login"`
+	Admin           `yaml:"admin"`
+	Certificate     `yaml:"certificate"`
+	License         `yaml:"license"`
+	HintPage        `yaml:"hint_page"`
+	SelfService     `yaml:"self_service"`
+	Status          `yaml:"status"`
+	Signer          `yaml:"signer"`
+	Load            `yaml:"load"`
+	Warmup          `yaml:"warmup"`
+	ResponseCache   `yaml:"response_cache"`
+	Search          `yaml:"search"`
+	SLO             `yaml:"slo"`
+	Metrics         `yaml:"metrics"`
+	Tracing         `yaml:"tracing"`
+	Log             `yaml:"log"`
+	Storage         `yaml:"storage"`
+	Regions         map[string]Region `yaml:"regions"` // data residency regions, by name
+	PII             `yaml:"pii"`
+	Faults          `yaml:"faults"`
+	Reporting       `yaml:"reporting"`
+	Export          `yaml:"export"`
+	Revocation      `yaml:"revocation"`
+	Webhooks        `yaml:"webhooks"`
+	Bus             Bus `yaml:"bus"` // message bus the events are published to, if any
+	Schedule        `yaml:"schedule"`
+	Void            `yaml:"void"`
+	LicenseArchive  `yaml:"license_archive"`
+	Formats         map[string]string `yaml:"formats"`       // additional media types, by format name used in publication searches
+	Certification   bool              `yaml:"certification"` // enforces the requirements of the LCP and LSD specifications on ingested data
+	SchemaCheck     string            `yaml:"schema_check"`  // checks the documents sent to readers against the JSON schemas: "log" or "strict"; no check if empty
+	Profile         string            `yaml:"-"`             // profile providing the defaults, if any
 }
 
 type Login struct {
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
+	Role     string `yaml:"role"`     // role of the login on the private routes; admin if empty
+	Provider string `yaml:"provider"` // URI of the provider the login is restricted to, e.g. a hosted publisher; none if empty
+}
+
+// List of roles on the private routes, each role being granted the rights of the following ones
+const (
+	ROLE_ADMIN    = "admin"    // every route, including the configuration of the server, e.g. providers and passphrase pools
+	ROLE_OPERATOR = "operator" // every change of publications, licenses and their related entities
+	ROLE_READER   = "reader"   // read-only access
+)
+
+// Roles lists the roles, from the most privileged.
+var Roles = []string{ROLE_ADMIN, ROLE_OPERATOR, ROLE_READER}
+
+// Headers set by the trusted proxies; the others are ignored, as they are passed on from the client
+const (
+	PROXY_X_FORWARDED = "x-forwarded" // X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host
+	PROXY_FORWARDED   = "forwarded"   // Forwarded (RFC 7239)
+)
+
+// Listener is a network address on which routes are served.
+type Listener struct {
+	Host   string `yaml:"host"` // interface, e.g. "127.0.0.1"; every interface if empty
+	Port   int    `yaml:"port"`
+	Socket string `yaml:"socket"` // path of a Unix domain socket, which replaces host and port
+	TLS    TLS    `yaml:"tls"`    // optional; the listener serves plain http if no certificate is set
+}
+
+type TLS struct {
+	Cert       string `yaml:"cert"`
+	PrivateKey string `yaml:"private_key"`
+	ACME       ACME   `yaml:"acme"` // certificates obtained automatically, instead of cert and private_key
+}
+
+// ACME obtains and renews the certificates of a listener from an ACME certificate authority, e.g. Let's Encrypt.
+// The authority validates each domain by a TLS-ALPN-01 challenge on the listener, which must then be reachable
+// on port 443, or by an HTTP-01 challenge on port 80 if an http port is set.
+type ACME struct {
+	Domains      []string `yaml:"domains"`       // names of the server, the only ones certificates are requested for
+	Email        string   `yaml:"email"`         // optional contact of the account, notified of certificate problems
+	CacheDir     string   `yaml:"cache_dir"`     // directory keeping the account key and the certificates across restarts
+	DirectoryURL string   `yaml:"directory_url"` // directory of the authority; Let's Encrypt if empty
+	HTTPPort     int      `yaml:"http_port"`     // optional port answering HTTP-01 challenges, and redirecting other requests to https
+}
+
+// Enabled tells if the certificates are obtained by ACME.
+func (a *ACME) Enabled() bool {
+	return len(a.Domains) != 0
+}
+
+// Admin serves the private routes on a separate listener, e.g. firewalled to the internal network.
+type Admin struct {
+	Listener `yaml:",inline"` // if no port is set, private routes are served by the public listener
+	Login    Login            `yaml:"login"` // replaces the admin login, if set
+	Users    []Login          `yaml:"users"` // additional logins of the private routes, e.g. one per content management system
+	JWT      JWT              `yaml:"jwt"`   // bearer tokens accepted on the private routes
+	OAuth    OAuth            `yaml:"oauth"` // bearer tokens issued by the server to machine clients
+}
+
+// JWT accepts the bearer tokens issued by an identity provider on the private routes, e.g. with the OAuth 2.0
+// client credentials flow, as an alternative to the logins. Tokens are signed with a key published by the provider.
+type JWT struct {
+	Issuer        string `yaml:"issuer"`         // expected iss claim
+	Audience      string `yaml:"audience"`       // expected in the aud claim
+	JWKSURL       string `yaml:"jwks_url"`       // JSON Web Key Set of the provider
+	Leeway        int    `yaml:"leeway"`         // tolerated clock skew on exp and nbf, in seconds
+	BearerOnly    bool   `yaml:"bearer_only"`    // the logins are no longer accepted on the private routes, only bearer tokens
+	RoleClaim     string `yaml:"role_claim"`     // claim holding the roles of a token, a string or an array; roles if empty
+	Role          string `yaml:"role"`           // role of the tokens without role claim; none if empty, so that they are denied
+	ProviderClaim string `yaml:"provider_claim"` // claim holding the URI of the provider a token is restricted to, required if set
+}
+
+// Enabled tells if bearer tokens are accepted.
+func (j *JWT) Enabled() bool {
+	return j.JWKSURL != ""
+}
+
+// OAuth enables the token endpoint of the OAuth 2.0 client credentials flow: machine clients, e.g. content management
+// systems, exchange their client id and secret for short-lived bearer tokens accepted on the private routes.
+type OAuth struct {
+	Clients   []OAuthClient `yaml:"clients"`
+	Secret    string        `yaml:"secret"`     // signs the tokens; shared by every instance of the server
+	TokenTTL  int           `yaml:"token_ttl"`  // lifetime of the tokens, in seconds; one hour if 0
+	RateLimit int           `yaml:"rate_limit"` // max number of token requests per minute of a client address; 60 by default
+}
+
+// OAuthClient is a machine client of the token endpoint.
+type OAuthClient struct {
+	ID       string `yaml:"client_id"`
+	Secret   string `yaml:"client_secret"`
+	Role     string `yaml:"role"`     // role of the tokens of the client; admin if empty
+	Provider string `yaml:"provider"` // URI of the provider the client is restricted to; none if empty
+}
+
+// Enabled tells if the token endpoint is served.
+func (o *OAuth) Enabled() bool {
+	return len(o.Clients) > 0
+}
+
+// Logins returns every login accepted on the private routes: the admin login, by default the login
+// of the configuration, and the additional users.
+func (a *Admin) Logins(defaultLogin Login) []Login {
+	login := a.Login
+	if login.User == "" {
+		login = defaultLogin
+	}
+	return append([]Login{login}, a.Users...)
 }
 
 type Certificate struct {
@@ -34,35 +179,639 @@ type Certificate struct {
 }
 
 type License struct {
-	Provider string `yaml:"provider"` // URI
-	Profile  string `yaml:"profile"`  // "http://readium.org/lcp/basic-profile" || "http://readium.org/lcp/profile-1.0" || ...
-	HintLink string `yaml:"hint_links"`
+	Provider      string                     `yaml:"provider"`                  // URI
+	Profile       string                     `yaml:"profile"`                   // "http://readium.org/lcp/basic-profile" || "http://readium.org/lcp/profile-1.0" || ...
+	HintLink      string                     `yaml:"hint_link"`                 // default hint link template
+	FormerHint    string                     `yaml:"hint_links"`                // former name of hint_link, still accepted
+	HintLinks     map[string]string          `yaml:"provider_hint_links"`       // hint link templates, by provider URI
+	BaseUrls      map[string]string          `yaml:"provider_base_urls"`        // public base urls of the server, by provider URI
+	UserEncrypted []string                   `yaml:"user_encrypted"`            // user fields encrypted by default, e.g. ["email", "name"]
+	Templates     map[string]LicenseTemplate `yaml:"templates"`                 // license templates, by name
+	HashScheme    string                     `yaml:"passhash_scheme"`           // passphrase hashing scheme used by the CMS: "sha256" (default), "argon2id" or "scrypt"
+	HashSchemes   map[string]string          `yaml:"provider_passhash_schemes"` // passphrase hashing schemes, by provider URI
+	CacheTTL      int                        `yaml:"cache_ttl"`                 // lifetime of cached fresh licenses, in minutes; 0 disables the cache
+	PreviewTTL    int                        `yaml:"preview_ttl"`               // max lifetime of preview licenses, in hours; 48 if not set
+}
+
+// PassHashScheme returns the passphrase hashing scheme declared for a provider,
+// or the default scheme if the provider has none.
+func (l *License) PassHashScheme(provider string) string {
+	if s, ok := l.HashSchemes[provider]; ok {
+		return s
+	}
+	return l.HashScheme
+}
+
+// PublicBaseURL returns the public base url of the server declared for a provider,
+// or the default base url if the provider has none.
+func (l *License) PublicBaseURL(provider string, defaultURL string) string {
+	if u, ok := l.BaseUrls[provider]; ok {
+		return u
+	}
+	return defaultURL
+}
+
+// HintLinkTemplate returns the hint link template associated with a provider,
+// or the default template if the provider has none.
+func (l *License) HintLinkTemplate(provider string) string {
+	if t, ok := l.HintLinks[provider]; ok {
+		return t
+	}
+	return l.HintLink
+}
+
+// HintPage is the license hint page served by the server, for the providers which have no website of their own.
+// The page is served at /hint/{license_id}, the url to set as hint link.
+type HintPage struct {
+	Template  string            `yaml:"template"`           // path of the html template of the page; a built-in page if empty
+	Templates map[string]string `yaml:"provider_templates"` // paths of html templates, by provider URI
+	RateLimit int               `yaml:"rate_limit"`         // max number of pages served per minute to a client address; 60 by default
+}
+
+// TemplatePath returns the path of the hint page template associated with a provider,
+// or the default template if the provider has none.
+func (p *HintPage) TemplatePath(provider string) string {
+	if t, ok := p.Templates[provider]; ok {
+		return t
+	}
+	return p.Template
+}
+
+// SelfService is the status page of a license offered to its user, from a signed support link set in the license.
+// The user sees the status, the end date and the devices of the license, and may return it.
+type SelfService struct {
+	Secret    string            `yaml:"secret"`             // key signing the links to the page; the page is disabled if empty
+	Template  string            `yaml:"template"`           // path of the html template of the page; a built-in page if empty
+	Templates map[string]string `yaml:"provider_templates"` // paths of html templates, by provider URI
+}
+
+// TemplatePath returns the path of the self-service page template associated with a provider,
+// or the default template if the provider has none.
+func (p *SelfService) TemplatePath(provider string) string {
+	if t, ok := p.Templates[provider]; ok {
+		return t
+	}
+	return p.Template
+}
+
+// LicenseTemplate gathers the options shared by a category of licenses.
+// A template is selected by name when a license is generated.
+type LicenseTemplate struct {
+	UserEncrypted []string `yaml:"user_encrypted"` // overrides the default list of encrypted user fields
+}
+
+// EncryptedFields returns the user fields to encrypt for a given template.
+// An empty template name selects the default configuration.
+func (l *License) EncryptedFields(template string) ([]string, error) {
+	if template == "" {
+		return l.UserEncrypted, nil
+	}
+	t, ok := l.Templates[template]
+	if !ok {
+		return nil, fmt.Errorf("unknown license template %q", template)
+	}
+	if t.UserEncrypted == nil {
+		return l.UserEncrypted, nil
+	}
+	return t.UserEncrypted, nil
+}
+
+// Signer limits the signature operations, e.g. to respect the throttling of an HSM.
+type Signer struct {
+	MaxConcurrent int     `yaml:"max_concurrent"` // max number of concurrent signatures; 0 for no limit
+	MaxRate       float64 `yaml:"max_rate"`       // max number of signatures per second; 0 for no limit
+	QueueTimeout  int     `yaml:"queue_timeout"`  // max time a signature waits in the queue, in milliseconds
+}
+
+// Load sheds the requests exceeding the capacity of the server, and bounds their processing time.
+type Load struct {
+	Budget   `yaml:",inline"`  // default budget, shared by the route groups which have none
+	Budgets  map[string]Budget `yaml:"budgets"`  // budgets by route group: "status", "content", "licenses" or "admin"
+	Timeout  int               `yaml:"timeout"`  // default max processing time of a request, in milliseconds; 0 for no limit
+	Timeouts map[string]int    `yaml:"timeouts"` // max processing times by route group: "status", "licenses" or "admin"
+}
+
+// Budget caps the requests processed concurrently, and the requests waiting for processing.
+type Budget struct {
+	MaxConcurrent int `yaml:"max_concurrent"` // max number of requests processed concurrently; 0 for no limit
+	MaxQueued     int `yaml:"max_queued"`     // max number of requests waiting for processing; 0 for no limit
+	QueueTimeout  int `yaml:"queue_timeout"`  // max time a request waits for processing, in milliseconds
+}
+
+// RouteTimeout returns the max processing time of the requests of a route group,
+// or the default timeout if the group has none.
+func (l *Load) RouteTimeout(group string) time.Duration {
+	if t, ok := l.Timeouts[group]; ok {
+		return time.Duration(t) * time.Millisecond
+	}
+	return time.Duration(l.Timeout) * time.Millisecond
+}
+
+// Warmup prepares the server at startup, before it reports ready, so that the first requests are not slowed
+// by cold connections and caches.
+type Warmup struct {
+	Connections  int `yaml:"connections"`  // database connections opened at startup, and kept idle; 4 by default, -1 for none
+	Publications int `yaml:"publications"` // publications most recently fulfilled, loaded at startup; 100 by default, -1 for none
+}
+
+// Conns returns the number of database connections opened at startup.
+func (w Warmup) Conns() int {
+	switch {
+	case w.Connections < 0:
+		return 0
+	case w.Connections == 0:
+		return 4
+	}
+	return w.Connections
+}
+
+// Pubs returns the number of publications loaded at startup.
+func (w Warmup) Pubs() int {
+	switch {
+	case w.Publications < 0:
+		return 0
+	case w.Publications == 0:
+		return 100
+	}
+	return w.Publications
+}
+
+// ResponseCache caches the responses of the public routes, so that the repeated requests of reading systems don't
+// reach the database. A cached response is invalidated by the changes of its license or publication. The private
+// routes are never cached.
+type ResponseCache struct {
+	Backend    string         `yaml:"backend"`     // "memory", or "redis" to share the cache between the instances; no cache if empty
+	MaxEntries int            `yaml:"max_entries"` // max number of responses kept in memory; 10000 by default
+	TTLs       map[string]int `yaml:"ttls"`        // times responses are cached by route group, in seconds: "status" (5 by default) or "content" (300 by default); 0 disables the group
+	Redis      Redis          `yaml:"redis"`
+}
+
+// Search caps the results of the license and publication searches, so that a broad search, e.g. of the ready
+// licenses, doesn't load millions of rows in memory; the exports stream the results without limit.
+type Search struct {
+	DefaultLimit   int `yaml:"default_limit"`   // results of a search without limit; 1000 by default
+	MaxLimit       int `yaml:"max_limit"`       // max limit of a search; 10000 by default
+	TargetDuration int `yaml:"target_duration"` // in milliseconds; if set, the default limit is lowered while the searches last longer
+}
+
+// DefaultResults returns the number of results of a search without limit, before any adaptation.
+func (s Search) DefaultResults() int {
+	if s.DefaultLimit <= 0 {
+		return 1000
+	}
+	return s.DefaultLimit
+}
+
+// MaxResults returns the max limit of a search.
+func (s Search) MaxResults() int {
+	if s.MaxLimit <= 0 {
+		return 10000
+	}
+	return s.MaxLimit
+}
+
+// Redis locates a Redis server.
+type Redis struct {
+	Addr     string `yaml:"addr"` // host and port, e.g. "redis:6379"
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+}
+
+// Enabled tells if responses are cached.
+func (c *ResponseCache) Enabled() bool {
+	return c.Backend != ""
+}
+
+// Entries returns the max number of responses kept in memory.
+func (c ResponseCache) Entries() int {
+	if c.MaxEntries <= 0 {
+		return 10000
+	}
+	return c.MaxEntries
+}
+
+// RouteTTL returns the time the responses of a route group are cached, 0 if they are not.
+func (c *ResponseCache) RouteTTL(group string) time.Duration {
+	if ttl, ok := c.TTLs[group]; ok {
+		return time.Duration(ttl) * time.Second
+	}
+	switch group {
+	case "status":
+		return 5 * time.Second
+	case "content":
+		return 300 * time.Second
+	}
+	return 0
+}
+
+// SLO sets the service level objectives of the server, from which the alerting rules are generated.
+type SLO struct {
+	IssuanceSuccess float64 `yaml:"issuance_success"` // min ratio of license generations which do not fail on the server; 0.999 by default
+	StatusLatency   int     `yaml:"status_latency"`   // max p99 latency of the status route group, in milliseconds; 500 by default
+	BacklogAge      int     `yaml:"backlog_age"`      // max age of an unconfirmed revocation notification, in minutes; 60 by default
+}
+
+// Objectives returns the objectives, with their default values.
+func (s SLO) Objectives() SLO {
+	if s.IssuanceSuccess == 0 {
+		s.IssuanceSuccess = 0.999
+	}
+	if s.StatusLatency == 0 {
+		s.StatusLatency = 500
+	}
+	if s.BacklogAge == 0 {
+		s.BacklogAge = 60
+	}
+	return s
+}
+
+// Metrics sets the labels of the metrics by tenant, i.e. by provider, whose number is capped
+// so that the metrics of a server hosting many publishers stay small.
+type Metrics struct {
+	MaxTenants int      `yaml:"max_tenants"` // max number of tenants labeled, the others being counted as "other"; 50 by default, -1 for none
+	Tenants    []string `yaml:"tenants"`     // tenants always labeled, e.g. the largest publishers, counted in max_tenants
+}
+
+// TenantLimit returns the max number of tenants labeled in the metrics.
+func (m Metrics) TenantLimit() int {
+	switch {
+	case m.MaxTenants < 0:
+		return 0
+	case m.MaxTenants == 0:
+		return 50
+	}
+	return m.MaxTenants
+}
+
+// Tracing exports the traces of the requests, down to the SQL statements they run, to an OpenTelemetry collector
+// over OTLP/HTTP.
+type Tracing struct {
+	Endpoint    string  `yaml:"endpoint"`     // url of the collector, e.g. "http://otel-collector:4318"; no tracing if empty
+	SampleRatio float64 `yaml:"sample_ratio"` // ratio of the traces sampled, unless the caller decided it; 1 by default
+	ServiceName string  `yaml:"service_name"` // name of the service in the traces; "lcp-server" by default
+}
+
+// Enabled tells if the requests are traced.
+func (t *Tracing) Enabled() bool {
+	return t.Endpoint != ""
+}
+
+// Ratio returns the ratio of the traces sampled.
+func (t Tracing) Ratio() float64 {
+	if t.SampleRatio == 0 {
+		return 1
+	}
+	return t.SampleRatio
+}
+
+// Name returns the name of the service in the traces.
+func (t Tracing) Name() string {
+	if t.ServiceName == "" {
+		return "lcp-server"
+	}
+	return t.ServiceName
+}
+
+// Log sets the format and the level of the logs of the server. Every request is logged once completed, with
+// structured fields: its identifier, method, route, status, latency and caller.
+type Log struct {
+	Format string `yaml:"format"` // "text" (default) or "json", e.g. for a log aggregator
+	Level  string `yaml:"level"`  // "debug", "info" (default), "warn" or "error"
+}
+
+// Storage of the protected publications managed by the server.
+type Storage struct {
+	FileStorage      `yaml:",inline"` // hot storage, from which publications are served
+	Cold             FileStorage      `yaml:"cold"`               // optional cold storage, for rarely fulfilled publications
+	ArchiveAfterDays int              `yaml:"archive_after_days"` // publications not fulfilled for this number of days are archived
+	MaxUploadSize    int              `yaml:"max_upload_size"`    // max size of an uploaded cleartext publication, in megabytes; 4095 by default
+}
+
+// Region is a data residency region, e.g. "eu": the publications and licenses of its providers are kept in the
+// database and the storage of the region, so that their data never leaves the infrastructure of the region.
+type Region struct {
+	Dsn       string      `yaml:"dsn"`
+	Storage   FileStorage `yaml:"storage"`   // storage of the publications of the region's providers
+	Providers []string    `yaml:"providers"` // URIs of the providers whose data resides in the region
+}
+
+// PII is the encryption at rest of the personal data, e.g. the user identifier and the passphrase hint of the
+// licenses, by data keys themselves encrypted by the master key.
+type PII struct {
+	MasterKey string `yaml:"master_key"` // base64 encoding of a 32 bytes key; no encryption if empty
+}
+
+// Enabled tells if the personal data are encrypted.
+func (p *PII) Enabled() bool {
+	return p.MasterKey != ""
+}
+
+// Key returns the decoded master key.
+func (p *PII) Key() ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(p.MasterKey)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("the master key must be the base64 encoding of 32 bytes")
+	}
+	return key, nil
+}
+
+// FileStorage is a directory of the file system, or an S3 bucket if a bucket is set.
+type FileStorage struct {
+	Path    string `yaml:"path"`
+	BaseURL string `yaml:"base_url"` // url the storage is served at, from which publications are downloaded
+	S3      S3     `yaml:"s3"`
+}
+
+// Enabled tells if a storage is configured.
+func (f *FileStorage) Enabled() bool {
+	return f.Path != "" || f.S3.Bucket != ""
+}
+
+// S3 is a bucket on AWS S3 or on a compatible service, e.g. MinIO.
+type S3 struct {
+	Endpoint  string `yaml:"endpoint"` // e.g. "https://s3.eu-west-3.amazonaws.com"
+	Region    string `yaml:"region"`   // "us-east-1" by default
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"` // key prefix of the objects, e.g. "publications/"
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	PathStyle bool   `yaml:"path_style"` // path-style urls, required by most compatible services
+}
+
+// Faults injects latency and failures in the subsystems of the server at random, to verify
+// that retries and circuit breakers work as expected. Rates are ratios of operations, from 0 to 1.
+// Fault injection is a test setting, rejected by the production profile.
+type Faults struct {
+	DBLatency        int     `yaml:"db_latency"`         // latency added to the delayed database operations, in milliseconds
+	DBLatencyRate    float64 `yaml:"db_latency_rate"`    // rate of delayed database operations
+	SignerErrorRate  float64 `yaml:"signer_error_rate"`  // rate of failed signatures
+	StorageErrorRate float64 `yaml:"storage_error_rate"` // rate of failed operations of the publication storage
+	Seed             int64   `yaml:"seed"`               // seed of the random faults, for reproducible runs; random if 0
+}
+
+// Enabled tells if any fault is injected.
+func (f *Faults) Enabled() bool {
+	return f.DBLatencyRate > 0 || f.SignerErrorRate > 0 || f.StorageErrorRate > 0
+}
+
+// Reporting posts anonymous aggregate statistics of the licenses, e.g. to the reporting endpoint
+// of a certification body. Reporting is opt-in: nothing is sent unless an endpoint is set.
+type Reporting struct {
+	Endpoint  `yaml:",inline"`    // default endpoint, for the providers which have none
+	Providers map[string]Endpoint `yaml:"providers"` // endpoints by provider URI; an empty url opts a provider out
+	Interval  int                 `yaml:"interval"`  // period of a report, in hours; 24 by default
+}
+
+// Export copies the events of the licenses, i.e. their history including revocations, at each interval to a
+// write-once storage, an S3 bucket with object lock, in signed batches which auditors can verify.
+type Export struct {
+	Storage       FileStorage `yaml:"storage"`        // S3 bucket, with object lock enabled
+	RetentionDays int         `yaml:"retention_days"` // time the exported batches can be neither changed nor deleted
+	Mode          string      `yaml:"mode"`           // object lock mode: COMPLIANCE (default) or GOVERNANCE
+	Interval      int         `yaml:"interval"`       // time between exports, in minutes; 60 by default
+}
+
+// Enabled tells if the events are exported.
+func (e *Export) Enabled() bool {
+	return e.Storage.Enabled()
+}
+
+// LockMode returns the object lock mode of the exported batches.
+func (e *Export) LockMode() string {
+	if e.Mode == "" {
+		return "COMPLIANCE"
+	}
+	return e.Mode
+}
+
+// Endpoint is a url to which reports are posted.
+type Endpoint struct {
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"` // sent as a bearer token, if set
+}
+
+// Enabled tells if any report is posted.
+func (r *Reporting) Enabled() bool {
+	if r.URL != "" {
+		return true
+	}
+	for _, e := range r.Providers {
+		if e.URL != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ProviderEndpoint returns the endpoint of the reports of a provider, or the default endpoint if the provider
+// has none. A provider is not reported if the url of its endpoint is empty.
+func (r *Reporting) ProviderEndpoint(provider string) Endpoint {
+	if e, ok := r.Providers[provider]; ok {
+		return e
+	}
+	return r.Endpoint
+}
+
+// Revocation propagates the revocations of licenses to channels, e.g. the callback of a provider or the service
+// publishing a revocation list: a revoked license stays "revoking" until every channel confirmed the revocation.
+type Revocation struct {
+	Channels map[string]Endpoint `yaml:"channels"` // endpoints notified of each revocation, by channel name
+	Interval int                 `yaml:"interval"` // time between the attempts of propagation, in seconds; 60 by default
+}
+
+// Enabled tells if revocations are propagated.
+func (r *Revocation) Enabled() bool {
+	return len(r.Channels) > 0
+}
+
+// ChannelNames returns the names of the channels, sorted.
+func (r *Revocation) ChannelNames() []string {
+	names := make([]string, 0, len(r.Channels))
+	for name := range r.Channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Webhooks notifies endpoints of the changes of the licenses, e.g. so that a content management system reacts to
+// the revocation of a license without polling the server. Each notification is posted in JSON, and retried with an
+// exponential backoff until the endpoint confirms it by a 2xx response.
+type Webhooks struct {
+	Endpoints   map[string]WebhookEndpoint `yaml:"endpoints"`    // endpoints, by name
+	Interval    int                        `yaml:"interval"`     // time between the checks of the changes, in seconds; 10 by default
+	MaxAttempts int                        `yaml:"max_attempts"` // attempts of a notification before it is dropped; 10 by default
+}
+
+// WebhookEndpoint is an endpoint notified of the events it subscribed to.
+type WebhookEndpoint struct {
+	Endpoint `yaml:",inline"`
+	Events   []string `yaml:"events"` // events notified; every event if empty
+}
+
+// List of webhook events
+const (
+	WEBHOOK_LICENSE_CREATED   = "license.created"
+	WEBHOOK_LICENSE_REVOKED   = "license.revoked"
+	WEBHOOK_STATUS_CHANGED    = "status.changed"
+	WEBHOOK_DEVICE_REGISTERED = "device.registered"
+	WEBHOOK_READER_ACTION     = "reader.action" // registration, renewal or return of a license by a reader
+)
+
+// WebhookEvents lists the webhook events.
+var WebhookEvents = []string{WEBHOOK_LICENSE_CREATED, WEBHOOK_LICENSE_REVOKED, WEBHOOK_STATUS_CHANGED, WEBHOOK_DEVICE_REGISTERED, WEBHOOK_READER_ACTION}
+
+// Enabled tells if any endpoint is notified.
+func (w *Webhooks) Enabled() bool {
+	return len(w.Endpoints) > 0
+}
+
+// Attempts returns the number of attempts of a notification.
+func (w Webhooks) Attempts() int {
+	if w.MaxAttempts <= 0 {
+		return 10
+	}
+	return w.MaxAttempts
+}
+
+// Subscribed tells if an endpoint is notified of an event.
+func (e WebhookEndpoint) Subscribed(event string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, subscribed := range e.Events {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Bus publishes the events of the licenses to a message bus, NATS or Kafka, for the integrations whose volume
+// exceeds webhooks. The events are the events of the webhooks, published in the same JSON payload, alongside or
+// instead of the webhooks; they are published at the interval and with the attempts of the webhooks.
+type Bus struct {
+	Kind     string   `yaml:"kind"`    // "nats" or "kafka"; no publication if empty
+	Servers  []string `yaml:"servers"` // addresses of the NATS servers or of the Kafka bootstrap brokers, e.g. "nats:4222"
+	Topic    string   `yaml:"topic"`   // Kafka topic, or prefix of the NATS subjects, followed by the event, e.g. "lcp.license.revoked"; "lcp" by default
+	Events   []string `yaml:"events"`  // events published; every event if empty
+	TLS      bool     `yaml:"tls"`     // connects with TLS
+	User     string   `yaml:"user"`    // NATS credentials, if required
+	Password string   `yaml:"password"`
+	Token    string   `yaml:"token"` // NATS authentication token, if required
+}
+
+// Enabled tells if the events are published to a message bus.
+func (b *Bus) Enabled() bool {
+	return b.Kind != ""
+}
+
+// TopicName returns the Kafka topic, or the prefix of the NATS subjects.
+func (b Bus) TopicName() string {
+	if b.Topic == "" {
+		return "lcp"
+	}
+	return b.Topic
+}
+
+// Schedule executes the status changes scheduled on licenses, e.g. a revocation at the end of a promotion.
+type Schedule struct {
+	Interval int `yaml:"interval"` // time between the checks of the due actions, in seconds; 60 by default
+}
+
+// Void lets storefronts void the licenses of refunded orders: a license voided within the refund window, and barely
+// used, is cancelled, and its cancellation is propagated to the revocation channels, e.g. the callback of the provider.
+type Void struct {
+	Window     int `yaml:"window"`      // refund window, in days after the issue of a license; licenses cannot be voided if 0
+	MaxDevices int `yaml:"max_devices"` // max number of devices registered by a license which is voided
+	MaxEvents  int `yaml:"max_events"`  // max number of events (registrations, renewals, returns) of a license which is voided
+}
+
+// Enabled tells if licenses can be voided.
+func (v *Void) Enabled() bool {
+	return v.Window > 0
+}
+
+// LicenseArchive moves the licenses terminated long ago, i.e. returned, revoked, cancelled or expired, to an archive
+// table, which keeps the table of the licenses small and their lookups fast; archived licenses are still found by id.
+type LicenseArchive struct {
+	AfterMonths int `yaml:"after_months"` // licenses terminated for this number of months are archived; none if 0
+	BatchSize   int `yaml:"batch_size"`   // number of licenses archived by transaction; 1000 by default
+}
+
+// Enabled tells if licenses are archived.
+func (a *LicenseArchive) Enabled() bool {
+	return a.AfterMonths > 0
 }
 
 type Status struct {
-	RenewDefaultDays int    `yaml:"renew_default_days"`
-	RenewMaxDays     int    `yaml:"renew_max_days"`
-	RenewLink        string `yaml:"renew_link"`
+	RenewDefaultDays int            `yaml:"renew_default_days"`
+	RenewMaxDays     int            `yaml:"renew_max_days"`
+	RenewLink        string         `yaml:"renew_link"`
+	LicenseLink      string         `yaml:"license_link"` // fresh license url managed by the provider, templated using {license_id}
+	Callback         StatusCallback `yaml:"callback"`     // notified of the registrations, renewals and returns, if set
+}
+
+// StatusCallback is the callback of the content management system notified of the changes of the status documents
+// made by the readers: the registrations, renewals and returns of the licenses. Each notification is signed with
+// the secret, and retried like the webhooks; it is kept as a dead letter after its last attempt.
+type StatusCallback struct {
+	Endpoint `yaml:",inline"`
+	Secret   string `yaml:"secret"` // key of the HMAC-SHA256 signature of the notifications
+}
+
+// Enabled tells if the status callback is notified.
+func (c *StatusCallback) Enabled() bool {
+	return c.URL != ""
 }
 
+// ReadConfig reads a configuration file, and validates it: unknown keys, missing settings and inconsistent
+// options are reported together, located by their path.
 func ReadConfig(configFile string) (*Config, error) {
+	return ReadProfileConfig("", configFile)
+}
 
-	var c Config
+// ReadProfileConfig reads a configuration file applied on the defaults of a profile, and validates it.
+// Either the profile or the file may be omitted.
+func ReadProfileConfig(profile string, configFile string) (*Config, error) {
 
+	c := &Config{}
+	if profile != "" {
+		var err error
+		if c, err = NewProfile(profile); err != nil {
+			return nil, err
+		}
+	} else if configFile == "" {
+		return nil, errors.New("failed to find the configuration file")
+	}
+
+	var errs ValidationError
 	if configFile != "" {
 		f, _ := filepath.Abs(configFile)
 		yamlData, err := ioutil.ReadFile(f)
 		if err != nil {
 			return nil, err
 		}
-		err = yaml.Unmarshal(yamlData, &c)
+		err = yaml.Unmarshal(yamlData, c)
 		if err != nil {
 			return nil, err
 		}
-
-	} else {
-		return nil, errors.New("failed to find the configuration file")
+		if c.License.FormerHint != "" {
+			c.License.HintLink, c.License.FormerHint = c.License.FormerHint, ""
+		}
+		var node interface{}
+		if err = yaml.Unmarshal(yamlData, &node); err != nil {
+			return nil, err
+		}
+		errs = unknownKeys(node, reflect.TypeOf(c), "")
 	}
 
-	return &c, nil
+	if err, ok := c.Validate().(ValidationError); ok {
+		errs = append(errs, err...)
+	}
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+		return nil, errs
+	}
+	return c, nil
 }
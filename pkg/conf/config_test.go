@@ -0,0 +1,33 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package conf
+
+import (
+	"testing"
+)
+
+func TestEncryptedFields(t *testing.T) {
+
+	config := License{
+		UserEncrypted: []string{"email", "name"},
+		Templates: map[string]LicenseTemplate{
+			"clear": {UserEncrypted: []string{}},
+			"loan":  {},
+		},
+	}
+
+	if fields, _ := config.EncryptedFields(""); len(fields) != 2 {
+		t.Errorf("Expected the default list of fields, got %v", fields)
+	}
+	if fields, _ := config.EncryptedFields("loan"); len(fields) != 2 {
+		t.Errorf("Expected the default list of fields, got %v", fields)
+	}
+	if fields, _ := config.EncryptedFields("clear"); len(fields) != 0 {
+		t.Errorf("Expected no encrypted field, got %v", fields)
+	}
+	if _, err := config.EncryptedFields("unknown"); err == nil {
+		t.Error("An unknown template must be rejected")
+	}
+}
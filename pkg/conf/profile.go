@@ -0,0 +1,81 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package conf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// profiles are named sets of defaults, on which a configuration file is applied.
+var profiles = map[string]func() *Config{
+	"dev":        devProfile,
+	"test":       testProfile,
+	"production": productionProfile,
+}
+
+// ProfileNames returns the names of the configuration profiles.
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewProfile returns the defaults of a configuration profile.
+func NewProfile(name string) (*Config, error) {
+	profile, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown configuration profile %q, expected one of %s", name, strings.Join(ProfileNames(), ", "))
+	}
+	c := profile()
+	c.Profile = name
+	return c, nil
+}
+
+// devProfile runs a local instance out of the box: in-memory database and test certificate,
+// relative to the root of the repository.
+func devProfile() *Config {
+	return &Config{
+		PublicBaseUrl: "http://localhost:8081",
+		Listener:      Listener{Port: 8081},
+		Dsn:           "sqlite3://file::memory:?cache=shared",
+		Login:         Login{User: "admin", Password: "admin"},
+		Certificate: Certificate{
+			Cert:       "pkg/test/cert/cert-edrlab-test.pem",
+			PrivateKey: "pkg/test/cert/privkey-edrlab-test.pem",
+		},
+		// the hint page is the built-in page of the server
+		License: License{Provider: "http://localhost:8081", HintLink: "http://localhost:8081/hint/{license_id}"},
+		Status:  Status{RenewDefaultDays: 7, RenewMaxDays: 40},
+	}
+}
+
+// testProfile is the dev profile with an isolated database, for automated tests.
+func testProfile() *Config {
+	c := devProfile()
+	c.Dsn = "sqlite3://file:lcptest?mode=memory&cache=shared"
+	c.Login = Login{User: "test", Password: "test"}
+	return c
+}
+
+// productionProfile sets limits protecting the server; the database, certificate and admin login
+// must be set by the configuration file, and are checked more strictly.
+func productionProfile() *Config {
+	return &Config{
+		Listener: Listener{Port: 8081},
+		License:  License{CacheTTL: 60},
+		Status:   Status{RenewDefaultDays: 7, RenewMaxDays: 40},
+		Signer:   Signer{QueueTimeout: 5000},
+		Load: Load{
+			Budget:   Budget{MaxConcurrent: 200, QueueTimeout: 1000},
+			Timeout:  30000,
+			Timeouts: map[string]int{"status": 2000, "licenses": 10000},
+		},
+	}
+}
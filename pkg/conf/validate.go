@@ -0,0 +1,722 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package conf
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// FieldError is a configuration error, located by the path of the offending key, e.g. "load.budgets.admin".
+type FieldError struct {
+	Path string
+	Msg  string
+}
+
+func (e FieldError) Error() string {
+	return e.Path + ": " + e.Msg
+}
+
+// ValidationError gathers the errors of a configuration.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return "invalid configuration:\n  " + strings.Join(msgs, "\n  ")
+}
+
+// route groups of the server, see Load
+var (
+	budgetGroups  = []string{"status", "content", "licenses", "admin"}
+	timeoutGroups = []string{"status", "licenses", "admin"}
+)
+
+// route groups whose responses are cached, see ResponseCache; the private routes are never cached
+var cacheGroups = []string{"status", "content"}
+
+// backends of the response cache, see the cache package
+var cacheBackends = []string{"", "memory", "redis"}
+
+// names of the destinations of the message bus, see Bus
+var (
+	validSubject = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*$`)
+	validTopic   = regexp.MustCompile(`^[A-Za-z0-9._-]{1,249}$`)
+)
+
+// database types, see the stor package
+var databaseTypes = []string{"sqlite3", "sqlite", "postgres", "postgresql", "mysql"}
+
+// passphrase hashing schemes, see the lic package
+var hashSchemes = []string{"", "sha256", "argon2id", "scrypt"}
+
+// checks of the documents sent to readers: logged or rejected if nonconforming, see the api package
+var schemaChecks = []string{"", "log", "strict"}
+
+// formats and levels of the logs, see the server package
+var (
+	logFormats = []string{"text", "json"}
+	logLevels  = []string{"debug", "info", "warn", "error"}
+)
+
+// Validate checks the required settings and the consistency of the configuration,
+// and returns a ValidationError listing every problem found.
+func (c *Config) Validate() error {
+	var errs ValidationError
+	add := func(path, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Path: path, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	// required settings
+	if c.PublicBaseUrl == "" {
+		add("public_base_url", "required")
+	} else if u, err := url.Parse(c.PublicBaseUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("public_base_url", "must be an absolute http(s) url")
+	}
+	if c.Dsn == "" {
+		add("dsn", "required")
+	} else if !contains(databaseTypes, strings.SplitN(c.Dsn, "://", 2)[0]) || !strings.Contains(c.Dsn, "://") {
+		add("dsn", "must be prefixed by the database type: sqlite3://, postgres:// or mysql://")
+	}
+	if c.ManualMigrate && (strings.Contains(c.Dsn, ":memory:") || strings.Contains(c.Dsn, "mode=memory")) {
+		add("manual_migrate", "an in-memory database can only be migrated at startup")
+	}
+	if (c.Login.User == "" || c.Login.Password == "") && !c.Admin.JWT.BearerOnly {
+		add("login", "user and password required")
+	}
+	for path, login := range map[string]Login{"login": c.Login, "admin.login": c.Admin.Login} {
+		if !contains(Roles, login.Role) && login.Role != "" {
+			add(path+".role", "unknown role %q", login.Role)
+		}
+		if !isAbsoluteURI(login.Provider) && login.Provider != "" {
+			add(path+".provider", "must be an absolute uri")
+		}
+	}
+	users := map[string]bool{c.Admin.Logins(c.Login)[0].User: true}
+	for i, login := range c.Admin.Users {
+		if login.User == "" || login.Password == "" {
+			add(fmt.Sprintf("admin.users.%d", i), "user and password required")
+		} else if users[login.User] {
+			add(fmt.Sprintf("admin.users.%d", i), "duplicate user %s", login.User)
+		}
+		users[login.User] = true
+		if !contains(Roles, login.Role) && login.Role != "" {
+			add(fmt.Sprintf("admin.users.%d.role", i), "unknown role %q", login.Role)
+		}
+		if !isAbsoluteURI(login.Provider) && login.Provider != "" {
+			add(fmt.Sprintf("admin.users.%d.provider", i), "must be an absolute uri")
+		}
+	}
+	if c.Certificate.Cert == "" {
+		add("certificate.cert", "required")
+	}
+	if c.Certificate.PrivateKey == "" {
+		add("certificate.private_key", "required")
+	}
+
+	// listeners
+	if c.BasePath != "" && !strings.HasPrefix(c.BasePath, "/") {
+		add("base_path", "must start with /")
+	}
+	for i, p := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+			add(fmt.Sprintf("trusted_proxies[%d]", i), "invalid IP address or CIDR range %q", p)
+		}
+	}
+	if c.ProxyHeaders != "" && c.ProxyHeaders != PROXY_X_FORWARDED && c.ProxyHeaders != PROXY_FORWARDED {
+		add("proxy_headers", "must be %s or %s", PROXY_X_FORWARDED, PROXY_FORWARDED)
+	}
+	validateListener(add, "", c.Listener)
+	if c.ShutdownTimeout < 0 {
+		add("shutdown_timeout", "must be positive")
+	}
+	if c.Admin.Port != 0 || c.Admin.Socket != "" {
+		validateListener(add, "admin.", c.Admin.Listener)
+		if c.Admin.Port != 0 && c.Admin.Port == c.Port && c.Admin.Host == c.Host {
+			add("admin.port", "must differ from the public port")
+		}
+	} else if c.Admin.Host != "" || c.Admin.TLS.Cert != "" || c.Admin.TLS.ACME.Enabled() {
+		add("admin", "port or socket required")
+	}
+
+	// bearer tokens
+	if jwt := c.Admin.JWT; jwt.Enabled() {
+		if u, err := url.Parse(jwt.JWKSURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("admin.jwt.jwks_url", "must be an absolute http(s) url")
+		}
+		if jwt.Issuer == "" {
+			add("admin.jwt.issuer", "required")
+		}
+		if jwt.Audience == "" {
+			add("admin.jwt.audience", "required")
+		}
+		if jwt.Leeway < 0 {
+			add("admin.jwt.leeway", "must be positive")
+		}
+		if !contains(Roles, jwt.Role) && jwt.Role != "" {
+			add("admin.jwt.role", "unknown role %q", jwt.Role)
+		}
+	} else if jwt.Issuer != "" || jwt.Audience != "" || (jwt.BearerOnly && !c.Admin.OAuth.Enabled()) {
+		add("admin.jwt.jwks_url", "required")
+	}
+	if oauth := c.Admin.OAuth; oauth.Enabled() {
+		if len(oauth.Secret) < 32 {
+			add("admin.oauth.secret", "must have at least 32 characters")
+		}
+		if oauth.TokenTTL < 0 {
+			add("admin.oauth.token_ttl", "must be positive")
+		}
+		if oauth.RateLimit < 0 {
+			add("admin.oauth.rate_limit", "must be positive")
+		}
+		clients := map[string]bool{}
+		for i, client := range oauth.Clients {
+			if client.ID == "" || client.Secret == "" {
+				add(fmt.Sprintf("admin.oauth.clients.%d", i), "client_id and client_secret required")
+			} else if clients[client.ID] {
+				add(fmt.Sprintf("admin.oauth.clients.%d", i), "duplicate client %s", client.ID)
+			}
+			clients[client.ID] = true
+			if !contains(Roles, client.Role) && client.Role != "" {
+				add(fmt.Sprintf("admin.oauth.clients.%d.role", i), "unknown role %q", client.Role)
+			}
+			if !isAbsoluteURI(client.Provider) && client.Provider != "" {
+				add(fmt.Sprintf("admin.oauth.clients.%d.provider", i), "must be an absolute uri")
+			}
+		}
+	} else if c.Admin.OAuth.Secret != "" || c.Admin.OAuth.TokenTTL != 0 {
+		add("admin.oauth.clients", "required")
+	}
+
+	// license and status
+	if !contains(hashSchemes, c.License.HashScheme) {
+		add("license.passhash_scheme", "unknown scheme %q", c.License.HashScheme)
+	}
+	for provider, scheme := range c.License.HashSchemes {
+		if !contains(hashSchemes, scheme) {
+			add("license.provider_passhash_schemes."+provider, "unknown scheme %q", scheme)
+		}
+	}
+	if c.License.CacheTTL < 0 {
+		add("license.cache_ttl", "must be positive")
+	}
+	if c.License.PreviewTTL < 0 {
+		add("license.preview_ttl", "must be positive")
+	}
+	if !contains(schemaChecks, c.SchemaCheck) {
+		add("schema_check", "unknown check %q, expected log or strict", c.SchemaCheck)
+	}
+	// a license without hint link is rejected by the schema of the specification
+	if c.SchemaCheck == "strict" && !c.Certification {
+		if c.License.HintLink == "" {
+			add("license.hint_link", "required by the strict schema check")
+		}
+		for provider, hint := range c.License.HintLinks {
+			if hint == "" {
+				add("license.provider_hint_links."+provider, "required by the strict schema check")
+			}
+		}
+	}
+	if c.HintPage.RateLimit < 0 {
+		add("hint_page.rate_limit", "must be positive")
+	}
+	if c.SelfService.Secret != "" && len(c.SelfService.Secret) < 16 {
+		add("self_service.secret", "must be at least 16 characters long")
+	}
+	if c.Status.RenewMaxDays > 0 && c.Status.RenewDefaultDays > c.Status.RenewMaxDays {
+		add("status.renew_default_days", "must not exceed renew_max_days")
+	}
+	if cb := c.Status.Callback; cb.Enabled() {
+		if u, err := url.Parse(cb.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("status.callback.url", "must be an absolute http(s) url")
+		} else if c.Profile == "production" && u.Scheme == "http" {
+			add("status.callback.url", "must use https in production")
+		}
+		if len(cb.Secret) < 16 {
+			add("status.callback.secret", "must be at least 16 characters long, to sign the notifications")
+		}
+	} else if cb.Secret != "" || cb.Token != "" {
+		add("status.callback.url", "required by the secret and the token of the callback")
+	}
+
+	// limits
+	if c.Signer.MaxConcurrent < 0 || c.Signer.MaxRate < 0 || c.Signer.QueueTimeout < 0 {
+		add("signer", "limits must be positive")
+	}
+	for group := range c.Load.Budgets {
+		if !contains(budgetGroups, group) {
+			add("load.budgets."+group, "unknown route group, expected one of %s", strings.Join(budgetGroups, ", "))
+		}
+	}
+	for group := range c.Load.Timeouts {
+		if !contains(timeoutGroups, group) {
+			add("load.timeouts."+group, "unknown route group, expected one of %s", strings.Join(timeoutGroups, ", "))
+		}
+	}
+
+	// warmup
+	for path, value := range map[string]int{"warmup.connections": c.Warmup.Connections, "warmup.publications": c.Warmup.Publications} {
+		if value < -1 {
+			add(path, "must be positive, or -1 for none")
+		}
+	}
+
+	// response cache
+	if !contains(cacheBackends, c.ResponseCache.Backend) {
+		add("response_cache.backend", "unknown backend, expected memory or redis")
+	}
+	if c.ResponseCache.Backend == "redis" && c.ResponseCache.Redis.Addr == "" {
+		add("response_cache.redis.addr", "required by the redis backend")
+	}
+	if c.ResponseCache.MaxEntries < 0 {
+		add("response_cache.max_entries", "must be positive")
+	}
+	for group, ttl := range c.ResponseCache.TTLs {
+		if !contains(cacheGroups, group) {
+			add("response_cache.ttls."+group, "not cacheable, expected one of %s", strings.Join(cacheGroups, ", "))
+		} else if ttl < 0 {
+			add("response_cache.ttls."+group, "must be positive")
+		}
+	}
+
+	// search limits
+	for path, value := range map[string]int{"search.default_limit": c.Search.DefaultLimit, "search.max_limit": c.Search.MaxLimit, "search.target_duration": c.Search.TargetDuration} {
+		if value < 0 {
+			add(path, "must be positive")
+		}
+	}
+	if c.Search.DefaultResults() > c.Search.MaxResults() {
+		add("search.default_limit", "must not exceed the max limit %d", c.Search.MaxResults())
+	}
+
+	// service level objectives
+	if c.SLO.IssuanceSuccess < 0 || c.SLO.IssuanceSuccess >= 1 {
+		add("slo.issuance_success", "must be a ratio lower than 1, e.g. 0.999")
+	}
+	if c.SLO.StatusLatency < 0 {
+		add("slo.status_latency", "must be positive")
+	}
+	if c.SLO.BacklogAge < 0 {
+		add("slo.backlog_age", "must be positive")
+	}
+	if len(c.Metrics.Tenants) > c.Metrics.TenantLimit() {
+		add("metrics.tenants", "more tenants than max_tenants")
+	}
+	if c.Tracing.Enabled() {
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("tracing.endpoint", "must be an absolute http(s) url")
+		}
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("tracing.sample_ratio", "must be a ratio between 0 and 1")
+	}
+	if c.Log.Format != "" && !contains(logFormats, c.Log.Format) {
+		add("log.format", "unknown format, expected one of %s", strings.Join(logFormats, ", "))
+	}
+	if c.Log.Level != "" && !contains(logLevels, c.Log.Level) {
+		add("log.level", "unknown level, expected one of %s", strings.Join(logLevels, ", "))
+	}
+
+	// storage
+	validateStorage(add, "storage.", c.Storage.FileStorage)
+	validateStorage(add, "storage.cold.", c.Storage.Cold)
+	if !c.Storage.Enabled() && c.Storage.Cold.Enabled() {
+		add("storage.cold", "requires a hot storage")
+	}
+	if c.Storage.ArchiveAfterDays > 0 && !c.Storage.Cold.Enabled() {
+		add("storage.archive_after_days", "requires a cold storage")
+	}
+	if c.Storage.MaxUploadSize < 0 {
+		add("storage.max_upload_size", "must be positive")
+	}
+
+	// encryption of the personal data
+	if c.PII.Enabled() {
+		if _, err := c.PII.Key(); err != nil {
+			add("pii.master_key", "must be the base64 encoding of 32 bytes")
+		}
+	}
+
+	// data residency regions
+	// regions are checked in the order of their names, so that a provider is reported in the same region each time
+	names := make([]string, 0, len(c.Regions))
+	for name := range c.Regions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	residency := make(map[string]string)
+	for _, name := range names {
+		region := c.Regions[name]
+		prefix := "regions." + name + "."
+		if region.Dsn == "" {
+			add(prefix+"dsn", "required")
+		} else if !contains(databaseTypes, strings.SplitN(region.Dsn, "://", 2)[0]) || !strings.Contains(region.Dsn, "://") {
+			add(prefix+"dsn", "must be prefixed by the database type: sqlite3://, postgres:// or mysql://")
+		} else if region.Dsn == c.Dsn {
+			add(prefix+"dsn", "must differ from the main database")
+		}
+		validateStorage(add, prefix+"storage.", region.Storage)
+		if c.Storage.Enabled() && !region.Storage.Enabled() {
+			add(prefix+"storage", "required, as the server stores the publications")
+		} else if !c.Storage.Enabled() && region.Storage.Enabled() {
+			add(prefix+"storage", "requires the storage of the server")
+		}
+		if len(region.Providers) == 0 {
+			add(prefix+"providers", "at least one provider required")
+		}
+		for _, provider := range region.Providers {
+			switch {
+			case !isAbsoluteURI(provider):
+				add(prefix+"providers", "%q must be an absolute uri", provider)
+			case provider == c.License.Provider:
+				add(prefix+"providers", "%q is the provider of the configuration, whose data resides in the main database", provider)
+			case residency[provider] != "":
+				add(prefix+"providers", "%q already resides in the region %s", provider, residency[provider])
+			}
+			residency[provider] = name
+		}
+	}
+
+	// fault injection
+	for path, rate := range map[string]float64{"faults.db_latency_rate": c.Faults.DBLatencyRate,
+		"faults.signer_error_rate": c.Faults.SignerErrorRate, "faults.storage_error_rate": c.Faults.StorageErrorRate} {
+		if rate < 0 || rate > 1 {
+			add(path, "must be between 0 and 1")
+		}
+	}
+	if c.Faults.DBLatency < 0 {
+		add("faults.db_latency", "must be positive")
+	} else if c.Faults.DBLatencyRate > 0 && c.Faults.DBLatency == 0 {
+		add("faults.db_latency", "required by db_latency_rate")
+	}
+
+	// reporting
+	endpoints := map[string]Endpoint{"reporting.url": c.Reporting.Endpoint}
+	for provider, e := range c.Reporting.Providers {
+		endpoints["reporting.providers."+provider+".url"] = e
+	}
+	for path, e := range endpoints {
+		if e.URL == "" {
+			continue
+		}
+		if u, err := url.Parse(e.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(path, "must be an absolute http(s) url")
+		} else if c.Profile == "production" && u.Scheme == "http" {
+			add(path, "must use https in production")
+		}
+	}
+	if c.Reporting.Interval < 0 {
+		add("reporting.interval", "must be positive")
+	}
+
+	// export of the events to a write-once storage
+	if c.Export.Enabled() {
+		if c.Export.Storage.S3.Bucket == "" {
+			add("export.storage.s3.bucket", "required: the events are exported to a bucket with object lock")
+		}
+		validateStorage(add, "export.storage.", c.Export.Storage)
+		if c.Export.RetentionDays <= 0 {
+			add("export.retention_days", "required")
+		}
+		if m := c.Export.Mode; m != "" && m != "COMPLIANCE" && m != "GOVERNANCE" {
+			add("export.mode", "must be COMPLIANCE or GOVERNANCE")
+		}
+		if c.Export.Interval < 0 {
+			add("export.interval", "must be positive")
+		}
+	}
+
+	// revocation
+	for name, e := range c.Revocation.Channels {
+		path := "revocation.channels." + name + ".url"
+		if u, err := url.Parse(e.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(path, "must be an absolute http(s) url")
+		} else if c.Profile == "production" && u.Scheme == "http" {
+			add(path, "must use https in production")
+		}
+	}
+	if c.Revocation.Interval < 0 {
+		add("revocation.interval", "must be positive")
+	}
+
+	// webhooks
+	for name, e := range c.Webhooks.Endpoints {
+		path := "webhooks.endpoints." + name
+		if strings.Contains(name, ":") {
+			add(path, "must not contain a colon, which is reserved to the message bus and the status callback")
+		}
+		if u, err := url.Parse(e.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(path+".url", "must be an absolute http(s) url")
+		} else if c.Profile == "production" && u.Scheme == "http" {
+			add(path+".url", "must use https in production")
+		}
+		for i, event := range e.Events {
+			if !contains(WebhookEvents, event) {
+				add(fmt.Sprintf("%s.events.%d", path, i), "unknown event, expected one of %s", strings.Join(WebhookEvents, ", "))
+			}
+		}
+	}
+	if c.Webhooks.Interval < 0 {
+		add("webhooks.interval", "must be positive")
+	}
+	if c.Webhooks.MaxAttempts < 0 {
+		add("webhooks.max_attempts", "must be positive")
+	}
+
+	// message bus
+	switch c.Bus.Kind {
+	case "":
+	case "nats":
+		if !validSubject.MatchString(c.Bus.TopicName()) {
+			add("bus.topic", "must be a NATS subject without wildcards, e.g. lcp.events")
+		}
+	case "kafka":
+		if !validTopic.MatchString(c.Bus.TopicName()) {
+			add("bus.topic", "must be a Kafka topic, e.g. lcp-events")
+		}
+		if c.Bus.User != "" || c.Bus.Password != "" || c.Bus.Token != "" {
+			add("bus", "credentials are not supported by kafka")
+		}
+	default:
+		add("bus.kind", "unknown kind, expected nats or kafka")
+	}
+	if c.Bus.Enabled() && len(c.Bus.Servers) == 0 {
+		add("bus.servers", "required by the message bus")
+	}
+	for i, server := range c.Bus.Servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			add(fmt.Sprintf("bus.servers.%d", i), "must be a host and a port, e.g. nats:4222")
+		}
+	}
+	for i, event := range c.Bus.Events {
+		if !contains(WebhookEvents, event) {
+			add(fmt.Sprintf("bus.events.%d", i), "unknown event, expected one of %s", strings.Join(WebhookEvents, ", "))
+		}
+	}
+
+	if c.Schedule.Interval < 0 {
+		add("schedule.interval", "must be positive")
+	}
+	for path, value := range map[string]int{"void.window": c.Void.Window, "void.max_devices": c.Void.MaxDevices, "void.max_events": c.Void.MaxEvents} {
+		if value < 0 {
+			add(path, "must be positive")
+		}
+	}
+	for path, value := range map[string]int{"license_archive.after_months": c.LicenseArchive.AfterMonths, "license_archive.batch_size": c.LicenseArchive.BatchSize} {
+		if value < 0 {
+			add(path, "must be positive")
+		}
+	}
+
+	// production settings
+	if c.Profile == "production" {
+		if c.Faults.Enabled() {
+			add("faults", "fault injection is not allowed in production")
+		}
+		if u, err := url.Parse(c.PublicBaseUrl); err == nil && u.Scheme == "http" {
+			add("public_base_url", "must use https in production")
+		}
+		if strings.Contains(c.Dsn, ":memory:") || strings.Contains(c.Dsn, "mode=memory") {
+			add("dsn", "an in-memory database loses every license on restart")
+		}
+		logins := map[string]Login{"login": c.Login, "admin.login": c.Admin.Login}
+		for i, login := range c.Admin.Users {
+			logins[fmt.Sprintf("admin.users.%d", i)] = login
+		}
+		for path, login := range logins {
+			if login.Password != "" && (len(login.Password) < 12 || login.Password == login.User) {
+				add(path, "the password must have at least 12 characters, and differ from the user")
+			}
+		}
+	}
+
+	// certification mode: the settings required by the LCP and LSD specifications
+	if c.Certification {
+		if !isAbsoluteURI(c.License.Provider) {
+			add("license.provider", "must be an absolute uri in certification mode")
+		}
+		if c.License.HintLink == "" {
+			add("license.hint_link", "required in certification mode")
+		}
+		for provider, hint := range c.License.HintLinks {
+			if hint == "" {
+				add("license.provider_hint_links."+provider, "required in certification mode")
+			}
+		}
+		// the user key is the SHA-256 hash of the passphrase
+		if c.License.HashScheme != "" && c.License.HashScheme != "sha256" {
+			add("license.passhash_scheme", "must be sha256 in certification mode")
+		}
+		for provider, scheme := range c.License.HashSchemes {
+			if scheme != "" && scheme != "sha256" {
+				add("license.provider_passhash_schemes."+provider, "must be sha256 in certification mode")
+			}
+		}
+		// status documents must link to the fresh license
+		if c.Status.LicenseLink == "" {
+			add("status.license_link", "required in certification mode")
+		}
+		if c.Faults.Enabled() {
+			add("faults", "fault injection is not allowed in certification mode")
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+	return errs
+}
+
+// validateListener checks the options of a listener, which are partly mutually exclusive
+func validateListener(add func(path, format string, args ...interface{}), prefix string, l Listener) {
+	if l.Socket != "" && (l.Host != "" || l.Port != 0) {
+		add(prefix+"socket", "mutually exclusive with host and port")
+	}
+	if l.Port < 0 || l.Port > 65535 {
+		add(prefix+"port", "invalid port %d", l.Port)
+	}
+	if (l.TLS.Cert == "") != (l.TLS.PrivateKey == "") {
+		add(prefix+"tls", "cert and private_key must be set together")
+	}
+	if acme := l.TLS.ACME; acme.Enabled() {
+		if l.TLS.Cert != "" {
+			add(prefix+"tls.acme", "mutually exclusive with cert and private_key")
+		}
+		for i, d := range acme.Domains {
+			if d == "" || strings.ContainsAny(d, ":/ ") {
+				add(fmt.Sprintf("%stls.acme.domains[%d]", prefix, i), "invalid domain name %q", d)
+			}
+		}
+		if acme.CacheDir == "" {
+			add(prefix+"tls.acme.cache_dir", "required")
+		}
+		if acme.DirectoryURL != "" {
+			if u, err := url.Parse(acme.DirectoryURL); err != nil || u.Scheme != "https" || u.Host == "" {
+				add(prefix+"tls.acme.directory_url", "must be an absolute https url")
+			}
+		}
+		if acme.HTTPPort < 0 || acme.HTTPPort > 65535 || (acme.HTTPPort != 0 && acme.HTTPPort == l.Port) {
+			add(prefix+"tls.acme.http_port", "invalid port %d", acme.HTTPPort)
+		}
+	} else if acme.CacheDir != "" || acme.Email != "" || acme.HTTPPort != 0 {
+		add(prefix+"tls.acme.domains", "required")
+	}
+}
+
+// validateStorage checks that a storage is either a directory or a bucket, and that a bucket can be reached.
+func validateStorage(add func(path, format string, args ...interface{}), prefix string, f FileStorage) {
+	if f.S3.Bucket == "" {
+		return
+	}
+	if f.Path != "" {
+		add(prefix+"s3", "mutually exclusive with path")
+	}
+	if u, err := url.Parse(f.S3.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add(prefix+"s3.endpoint", "must be an absolute http(s) url")
+	}
+	if f.S3.AccessKey == "" || f.S3.SecretKey == "" {
+		add(prefix+"s3", "access_key and secret_key required")
+	}
+}
+
+// unknownKeys returns the keys of a decoded yaml node which match no field of a type.
+func unknownKeys(node interface{}, t reflect.Type, path string) ValidationError {
+	var errs ValidationError
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := node.(map[interface{}]interface{})
+		if !ok {
+			// type mismatches are reported by the decoder
+			return nil
+		}
+		fields := yamlFields(t)
+		for k, v := range m {
+			key := joinPath(path, fmt.Sprint(k))
+			ft, ok := fields[fmt.Sprint(k)]
+			if !ok {
+				errs = append(errs, FieldError{Path: key, Msg: "unknown key"})
+				continue
+			}
+			errs = append(errs, unknownKeys(v, ft, key)...)
+		}
+	case reflect.Map:
+		m, ok := node.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		for k, v := range m {
+			errs = append(errs, unknownKeys(v, t.Elem(), joinPath(path, fmt.Sprint(k)))...)
+		}
+	case reflect.Slice:
+		items, ok := node.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, v := range items {
+			errs = append(errs, unknownKeys(v, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return errs
+}
+
+// yamlFields returns the types of the fields of a struct, by yaml key; inlined structs are flattened.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(f.Tag.Get("yaml"), ",")
+		name := tag[0]
+		if name == "-" {
+			continue
+		}
+		if contains(tag[1:], "inline") {
+			for k, v := range yamlFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isAbsoluteURI tells if a string is an absolute uri, e.g. the identifier of a provider
+func isAbsoluteURI(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.IsAbs()
+}
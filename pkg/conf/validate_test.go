@@ -0,0 +1,516 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package conf
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const validConfig = `
+public_base_url: "https://lcp.example.com"
+dsn: "sqlite3://file::memory:?cache=shared"
+login:
+  user: "user"
+  password: "password"
+certificate:
+  cert: "cert.pem"
+  private_key: "privkey.pem"
+storage:
+  path: "/var/lcp"
+  base_url: "https://cdn.example.com"
+admin:
+  port: 8082
+  login:
+    user: "admin"
+    password: "secret"
+`
+
+func writeConfig(t *testing.T, yaml string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfig(t *testing.T) {
+
+	c, err := ReadConfig(writeConfig(t, validConfig))
+	if err != nil {
+		t.Fatalf("Failed to read a valid configuration: %v", err)
+	}
+	if c.Storage.Path != "/var/lcp" || c.Admin.Login.User != "admin" {
+		t.Errorf("Unexpected configuration %+v", c)
+	}
+
+	// the former name of the hint link key is still accepted
+	c, err = ReadConfig(writeConfig(t, validConfig+`
+license:
+  hint_links: "https://lcp.example.com/hint/{license_id}"
+`))
+	if err != nil || c.License.HintLink != "https://lcp.example.com/hint/{license_id}" {
+		t.Errorf("Expected the hint link of the former key, got %v", err)
+	}
+
+	// every error is reported with its path
+	c, err = ReadConfig(writeConfig(t, validConfig+`
+socket: "/run/lcp.sock"
+port: 8081
+status:
+  renew_default_days: 10
+  renew_max_days: 5
+  renew_lnk: "https://example.com"
+load:
+  budgets:
+    reports:
+      max_concurrent: 2
+`))
+	var verr ValidationError
+	if c != nil || !errors.As(err, &verr) {
+		t.Fatalf("Expected a validation error, got %v", err)
+	}
+	expected := []string{"load.budgets.reports", "socket", "status.renew_default_days", "status.renew_lnk"}
+	if len(verr) != len(expected) {
+		t.Fatalf("Expected %d errors, got %v", len(expected), verr)
+	}
+	for i, path := range expected {
+		if verr[i].Path != path {
+			t.Errorf("Expected an error on %s, got %v", path, verr[i])
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	c := &Config{}
+	var verr ValidationError
+	if !errors.As(c.Validate(), &verr) {
+		t.Fatal("Expected a validation error")
+	}
+	missing := map[string]bool{}
+	for _, fe := range verr {
+		missing[fe.Path] = true
+	}
+	for _, path := range []string{"public_base_url", "dsn", "login", "certificate.cert", "certificate.private_key"} {
+		if !missing[path] {
+			t.Errorf("Expected %s to be required", path)
+		}
+	}
+
+	// mutually exclusive options
+	c = &Config{
+		PublicBaseUrl: "https://lcp.example.com",
+		Dsn:           "sqlite3://file::memory:",
+		Login:         Login{User: "user", Password: "password"},
+		Certificate:   Certificate{Cert: "cert.pem", PrivateKey: "privkey.pem"},
+		Admin:         Admin{Listener: Listener{Socket: "/run/admin.sock", Port: 8082, TLS: TLS{Cert: "admin.pem"}}},
+	}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 2 || verr[0].Path != "admin.socket" || verr[1].Path != "admin.tls" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+
+	// fault rates
+	c.Admin = Admin{}
+	c.Faults = Faults{DBLatencyRate: 0.5, SignerErrorRate: 1.5}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 2 || verr[0].Path != "faults.db_latency" || verr[1].Path != "faults.signer_error_rate" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Faults = Faults{DBLatency: 100, DBLatencyRate: 0.5, SignerErrorRate: 1}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.Profile = "production"
+	rejected := false
+	if errors.As(c.Validate(), &verr) {
+		for _, fe := range verr {
+			rejected = rejected || fe.Path == "faults"
+		}
+	}
+	if !rejected {
+		t.Errorf("Expected fault injection to be rejected in production, got %v", verr)
+	}
+
+	// reporting endpoints
+	c.Profile = ""
+	c.Faults = Faults{}
+	c.Reporting = Reporting{
+		Endpoint:  Endpoint{URL: "http://reports.example.com"},
+		Providers: map[string]Endpoint{"https://provider.example.com": {URL: "reports"}, "https://other.example.com": {}},
+	}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 1 || verr[0].Path != "reporting.providers.https://provider.example.com.url" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	if e := c.Reporting.ProviderEndpoint("https://other.example.com"); e.URL != "" {
+		t.Errorf("Expected the provider to be opted out, got %v", e)
+	}
+	if e := c.Reporting.ProviderEndpoint("https://unknown.example.com"); e.URL != "http://reports.example.com" {
+		t.Errorf("Expected the default endpoint, got %v", e)
+	}
+
+	// certification mode
+	c.Reporting = Reporting{}
+	c.Certification = true
+	c.License = License{Provider: "edrlab", HashScheme: "argon2id"}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 4 || verr[0].Path != "license.hint_link" || verr[1].Path != "license.passhash_scheme" ||
+		verr[2].Path != "license.provider" || verr[3].Path != "status.license_link" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.License = License{Provider: "https://provider.example.com", HintLink: "https://provider.example.com/hint"}
+	c.Status.LicenseLink = "https://provider.example.com/licenses/{license_id}"
+	if err := c.Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	// schema checks
+	c.SchemaCheck = "fail"
+	if !errors.As(c.Validate(), &verr) || len(verr) != 1 || verr[0].Path != "schema_check" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.SchemaCheck = "strict"
+	if err := c.Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.Certification = false
+	c.License.HintLinks = map[string]string{"https://other.example.com": ""}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 1 || verr[0].Path != "license.provider_hint_links.https://other.example.com" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.License.HintLinks = nil
+	c.Certification = true
+
+	// refund window
+	c.Void = Void{Window: 14, MaxDevices: -1, MaxEvents: -1}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 2 || verr[0].Path != "void.max_devices" || verr[1].Path != "void.max_events" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Void = Void{Window: 14, MaxDevices: 1}
+	if err := c.Validate(); err != nil || !c.Void.Enabled() {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	// revocation channels
+	c.Revocation = Revocation{Channels: map[string]Endpoint{"provider": {URL: "https://provider.example.com/revocations"}, "crl": {URL: "crl"}}}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 1 || verr[0].Path != "revocation.channels.crl.url" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	if names := c.Revocation.ChannelNames(); len(names) != 2 || names[0] != "crl" {
+		t.Errorf("Expected sorted channel names, got %v", names)
+	}
+
+	// webhooks
+	c.Revocation = Revocation{}
+	c.Webhooks = Webhooks{Endpoints: map[string]WebhookEndpoint{"cms": {Endpoint: Endpoint{URL: "cms"}, Events: []string{WEBHOOK_LICENSE_REVOKED, "license.deleted"}}}, Interval: -1}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 3 || verr[0].Path != "webhooks.endpoints.cms.events.1" || verr[1].Path != "webhooks.endpoints.cms.url" ||
+		verr[2].Path != "webhooks.interval" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Webhooks = Webhooks{Endpoints: map[string]WebhookEndpoint{"cms": {Endpoint: Endpoint{URL: "https://cms.example.com/webhooks"}}}}
+	if err := c.Validate(); err != nil || !c.Webhooks.Enabled() || c.Webhooks.Attempts() != 10 || !c.Webhooks.Endpoints["cms"].Subscribed(WEBHOOK_STATUS_CHANGED) {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	// status callback
+	c.Webhooks = Webhooks{}
+	c.Status.Callback = StatusCallback{Endpoint: Endpoint{URL: "cms.example.com"}, Secret: "short"}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 2 || verr[0].Path != "status.callback.secret" || verr[1].Path != "status.callback.url" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Status.Callback = StatusCallback{Secret: "0123456789abcdef"}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 1 || verr[0].Path != "status.callback.url" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Status.Callback.URL = "https://cms.example.com/lcp/status"
+	if err := c.Validate(); err != nil || !c.Status.Callback.Enabled() {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.Status.Callback = StatusCallback{}
+
+	// message bus
+	c.Bus = Bus{Kind: "kafka", Servers: []string{"kafka"}, Topic: "lcp events", Events: []string{"license.deleted"}, User: "lcp"}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 4 || verr[0].Path != "bus" || verr[1].Path != "bus.events.0" ||
+		verr[2].Path != "bus.servers.0" || verr[3].Path != "bus.topic" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Bus = Bus{Kind: "nats", Topic: "lcp.*"}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 2 || verr[0].Path != "bus.servers" || verr[1].Path != "bus.topic" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Bus = Bus{Kind: "nats", Servers: []string{"nats:4222"}, Token: "secret"}
+	if err := c.Validate(); err != nil || !c.Bus.Enabled() || c.Bus.TopicName() != "lcp" {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.Webhooks = Webhooks{Endpoints: map[string]WebhookEndpoint{"bus:nats": {Endpoint: Endpoint{URL: "https://cms.example.com/webhooks"}}}}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 1 || verr[0].Path != "webhooks.endpoints.bus:nats" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+
+	// s3 storage
+	c.Webhooks = Webhooks{}
+	c.Bus = Bus{}
+	c.Storage = Storage{FileStorage: FileStorage{S3: S3{Bucket: "publications", Endpoint: "minio:9000", AccessKey: "key"}}, ArchiveAfterDays: 30}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 3 || verr[0].Path != "storage.archive_after_days" || verr[1].Path != "storage.s3" ||
+		verr[2].Path != "storage.s3.endpoint" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Storage.S3 = S3{Bucket: "publications", Endpoint: "http://minio:9000", AccessKey: "key", SecretKey: "secret", PathStyle: true}
+	c.Storage.Cold = FileStorage{Path: "/mnt/archive"}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.Storage = Storage{}
+
+	// data residency regions
+	c.Storage = Storage{FileStorage: FileStorage{Path: "/var/lcp/publications"}}
+	c.Regions = map[string]Region{
+		"eu": {Dsn: "postgres://lcp@db.eu.example.com/lcp", Storage: FileStorage{Path: "/mnt/eu"}, Providers: []string{"https://eu.example.com"}},
+		"us": {Dsn: "lcp.db", Providers: []string{"https://eu.example.com", "us"}},
+	}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 4 || verr[0].Path != "regions.us.dsn" || verr[1].Path != "regions.us.providers" ||
+		verr[2].Path != "regions.us.providers" || verr[3].Path != "regions.us.storage" || !strings.Contains(verr[1].Error(), "already resides in the region eu") {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Regions["us"] = Region{Dsn: "mysql://lcp@db.us.example.com/lcp", Storage: FileStorage{Path: "/mnt/us"}, Providers: []string{"https://us.example.com"}}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.Storage = Storage{}
+	c.Regions = nil
+
+	// graceful shutdown
+	c.ShutdownTimeout = -1
+	if !errors.As(c.Validate(), &verr) || len(verr) != 1 || verr[0].Path != "shutdown_timeout" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.ShutdownTimeout = 0
+
+	// certificates obtained by ACME
+	c.TLS = TLS{Cert: "cert.pem", PrivateKey: "key.pem", ACME: ACME{Domains: []string{"lcp.example.com", "https://lcp.example.com"}, HTTPPort: 70000}}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 4 || verr[0].Path != "tls.acme" || verr[1].Path != "tls.acme.cache_dir" ||
+		verr[2].Path != "tls.acme.domains[1]" || verr[3].Path != "tls.acme.http_port" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.TLS = TLS{ACME: ACME{Domains: []string{"lcp.example.com"}, CacheDir: "/var/lib/lcp/acme", HTTPPort: 80}}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.TLS = TLS{}
+
+	// service level objectives
+	c.SLO = SLO{IssuanceSuccess: 1, StatusLatency: -1}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 2 || verr[0].Path != "slo.issuance_success" || verr[1].Path != "slo.status_latency" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.SLO = SLO{IssuanceSuccess: 0.995, StatusLatency: 300, BacklogAge: 15}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.SLO = SLO{}
+
+	// warmup
+	c.Warmup = Warmup{Connections: -2, Publications: -2}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 2 || verr[0].Path != "warmup.connections" || verr[1].Path != "warmup.publications" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Warmup = Warmup{Connections: -1, Publications: -1}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.Warmup = Warmup{}
+
+	// response cache
+	c.ResponseCache = ResponseCache{Backend: "redis", TTLs: map[string]int{"status": -1, "admin": 60}}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 3 || verr[0].Path != "response_cache.redis.addr" || verr[1].Path != "response_cache.ttls.admin" ||
+		verr[2].Path != "response_cache.ttls.status" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.ResponseCache = ResponseCache{Backend: "memory", TTLs: map[string]int{"content": 0}}
+	if err := c.Validate(); err != nil || c.ResponseCache.RouteTTL("status") != 5*time.Second || c.ResponseCache.RouteTTL("content") != 0 ||
+		c.ResponseCache.RouteTTL("admin") != 0 {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.ResponseCache = ResponseCache{}
+
+	// search limits
+	c.Search = Search{DefaultLimit: 20000, TargetDuration: -1}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 2 || verr[0].Path != "search.default_limit" || verr[1].Path != "search.target_duration" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Search = Search{MaxLimit: 500, DefaultLimit: 100}
+	if err := c.Validate(); err != nil || c.Search.DefaultResults() != 100 || (Search{}).MaxResults() != 10000 {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.Search = Search{}
+
+	// metrics by tenant
+	c.Metrics = Metrics{MaxTenants: 1, Tenants: []string{"provider1", "provider2"}}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 1 || verr[0].Path != "metrics.tenants" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Metrics = Metrics{Tenants: []string{"provider1", "provider2"}}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.Metrics = Metrics{}
+
+	// tracing
+	c.Tracing = Tracing{Endpoint: "otel-collector:4318", SampleRatio: 2}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 2 || verr[0].Path != "tracing.endpoint" || verr[1].Path != "tracing.sample_ratio" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Tracing = Tracing{Endpoint: "http://otel-collector:4318", SampleRatio: 0.1}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.Tracing = Tracing{}
+
+	// logs
+	c.Log = Log{Format: "xml", Level: "trace"}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 2 || verr[0].Path != "log.format" || verr[1].Path != "log.level" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Log = Log{Format: "json", Level: "warn"}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.Log = Log{}
+
+	// archiving of the licenses
+	c.LicenseArchive = LicenseArchive{AfterMonths: -1, BatchSize: -1}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 2 || verr[0].Path != "license_archive.after_months" || verr[1].Path != "license_archive.batch_size" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.LicenseArchive = LicenseArchive{}
+
+	// export of the events
+	c.Export = Export{Storage: FileStorage{Path: "/var/lcp/audit"}, Mode: "LEGAL_HOLD", Interval: -1}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 4 || verr[0].Path != "export.interval" || verr[1].Path != "export.mode" ||
+		verr[2].Path != "export.retention_days" || verr[3].Path != "export.storage.s3.bucket" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Export = Export{Storage: FileStorage{S3: S3{Endpoint: "https://s3.eu-west-3.amazonaws.com", Bucket: "lcp-audit", Region: "eu-west-3", AccessKey: "AKIA", SecretKey: "secret"}}, RetentionDays: 2555}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.Export = Export{}
+
+	// encryption of personal data
+	c.PII = PII{MasterKey: "c2hvcnQ="}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 1 || verr[0].Path != "pii.master_key" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.PII = PII{MasterKey: "ZmFrZS1rZXktZm9yLWRvY3VtZW50YXRpb24tb25seSE="}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.PII = PII{}
+
+	// self-service page
+	c.Revocation = Revocation{}
+	c.SelfService = SelfService{Secret: "short"}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 1 || verr[0].Path != "self_service.secret" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	// migrations of an in-memory database
+	c.SelfService = SelfService{}
+	c.ManualMigrate = true
+	if !errors.As(c.Validate(), &verr) || len(verr) != 1 || verr[0].Path != "manual_migrate" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.ManualMigrate = false
+
+	// additional users of the private routes
+	c.Admin.Users = []Login{{User: "cms", Password: "cms-password"}, {User: "cms", Password: "other"}, {User: "reports"}}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 2 || verr[0].Path != "admin.users.1" || verr[1].Path != "admin.users.2" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	if logins := c.Admin.Logins(c.Login); len(logins) != 4 || logins[0] != c.Login {
+		t.Errorf("Unexpected logins %v", logins)
+	}
+	c.Admin.Users = nil
+
+	// roles of the clients of the private routes
+	c.Login.Role = "editor"
+	c.Admin.Users = []Login{{User: "cms", Password: "cms-password", Role: ROLE_OPERATOR}, {User: "reports", Password: "reports-password", Role: "viewer"}}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 2 || verr[0].Path != "admin.users.1.role" || verr[1].Path != "login.role" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Login.Role = ""
+	c.Admin.Users = []Login{{User: "cms", Password: "cms-password", Provider: "https://publisher.example.com"}, {User: "reports", Password: "reports-password", Provider: "publisher"}}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 1 || verr[0].Path != "admin.users.1.provider" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Admin.Users = nil
+
+	// bearer tokens, which may replace the logins
+	c.Admin.JWT = JWT{JWKSURL: "idp.example.com/keys", Issuer: "https://idp.example.com/", Leeway: -1}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 3 || verr[0].Path != "admin.jwt.audience" || verr[1].Path != "admin.jwt.jwks_url" ||
+		verr[2].Path != "admin.jwt.leeway" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Admin.JWT = JWT{JWKSURL: "https://idp.example.com/keys", Issuer: "https://idp.example.com/", Audience: "lcp", BearerOnly: true}
+	c.Login = Login{}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.Admin.JWT = JWT{BearerOnly: true}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 1 || verr[0].Path != "admin.jwt.jwks_url" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+
+	// bearer tokens issued by the server to machine clients
+	c.Admin.OAuth = OAuth{Secret: "short", TokenTTL: -1, Clients: []OAuthClient{{ID: "cms", Secret: "secret"}, {ID: "cms", Secret: "other"}, {ID: "erp"}}}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 4 || verr[0].Path != "admin.oauth.clients.1" || verr[1].Path != "admin.oauth.clients.2" ||
+		verr[2].Path != "admin.oauth.secret" || verr[3].Path != "admin.oauth.token_ttl" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Admin.OAuth = OAuth{Secret: strings.Repeat("s", 32), Clients: []OAuthClient{{ID: "cms", Secret: "secret", Role: "owner"}}}
+	if !errors.As(c.Validate(), &verr) || len(verr) != 1 || verr[0].Path != "admin.oauth.clients.0.role" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+	c.Admin.OAuth.Clients[0].Role = ROLE_READER
+	if err := c.Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.Admin.OAuth.Clients = nil
+	if !errors.As(c.Validate(), &verr) || len(verr) != 2 || verr[0].Path != "admin.jwt.jwks_url" || verr[1].Path != "admin.oauth.clients" {
+		t.Errorf("Unexpected errors %v", verr)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+
+	// the dev profile runs without configuration file
+	c, err := ReadProfileConfig("dev", "")
+	if err != nil {
+		t.Fatalf("Failed to read the dev profile: %v", err)
+	}
+	if c.Profile != "dev" || c.Dsn == "" || c.Certificate.Cert == "" || c.License.HintLinkTemplate(c.License.Provider) == "" {
+		t.Errorf("Unexpected dev configuration %+v", c)
+	}
+
+	// a configuration file overrides the defaults of the profile
+	c, err = ReadProfileConfig("test", writeConfig(t, "port: 9000\n"))
+	if err != nil {
+		t.Fatalf("Failed to read the test profile: %v", err)
+	}
+	if c.Port != 9000 || c.Status.RenewMaxDays != 40 {
+		t.Errorf("Unexpected test configuration %+v", c)
+	}
+
+	// production is checked more strictly
+	_, err = ReadProfileConfig("production", writeConfig(t, validConfig))
+	var verr ValidationError
+	if !errors.As(err, &verr) || len(verr) != 3 || verr[0].Path != "admin.login" || verr[1].Path != "dsn" || verr[2].Path != "login" {
+		t.Errorf("Expected database and password errors, got %v", err)
+	}
+	if _, err = ReadProfileConfig("staging", ""); err == nil {
+		t.Error("Expected an error for an unknown profile")
+	}
+}
@@ -34,10 +34,13 @@ import (
 	"io"
 )
 
-type cbcEncrypter struct{}
+type cbcEncrypter struct {
+	rand io.Reader // source of IVs and padding bytes; if nil, the default sources are used
+}
 
 const (
-	aes256keyLength = 32 // 256 bits
+	aes256keyLength = 32  // 256 bits
+	chunkBlocks     = 256 // number of blocks encrypted at once
 )
 
 func (e cbcEncrypter) Signature() string {
@@ -52,7 +55,13 @@ func (e cbcEncrypter) GenerateKey() (ContentKey, error) {
 
 func (e cbcEncrypter) Encrypt(key ContentKey, r io.Reader, w io.Writer) error {
 
-	r = PaddedReader(r, aes.BlockSize, false)
+	rnd := e.rand
+	if rnd == nil {
+		r = PaddedReader(r, aes.BlockSize, false)
+		rnd = rand.Reader
+	} else {
+		r = newPaddedReader(r, aes.BlockSize, false, rnd)
+	}
 
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -61,7 +70,7 @@ func (e cbcEncrypter) Encrypt(key ContentKey, r io.Reader, w io.Writer) error {
 
 	// generate the IV
 	iv := make([]byte, aes.BlockSize)
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+	if _, err := io.ReadFull(rnd, iv); err != nil {
 		return err
 	}
 
@@ -70,21 +79,29 @@ func (e cbcEncrypter) Encrypt(key ContentKey, r io.Reader, w io.Writer) error {
 		return err
 	}
 
+	// the input is processed by chunks, which bounds the memory used
+	// whatever the size of the input
 	mode := cipher.NewCBCEncrypter(block, iv)
-	buffer := make([]byte, aes.BlockSize)
-	for _, err = io.ReadFull(r, buffer); err == nil; _, err = io.ReadFull(r, buffer) {
-		mode.CryptBlocks(buffer, buffer)
-		_, wErr := w.Write(buffer)
-		if wErr != nil {
-			return wErr
+	buffer := make([]byte, aes.BlockSize*chunkBlocks)
+	for {
+		n, err := io.ReadFull(r, buffer)
+		// a read error is reported as such, rather than as a misaligned input
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if n%aes.BlockSize != 0 {
+			return errors.New("padded input is not a multiple of the block size")
+		}
+		if n > 0 {
+			mode.CryptBlocks(buffer[:n], buffer[:n])
+			if _, wErr := w.Write(buffer[:n]); wErr != nil {
+				return wErr
+			}
+		}
+		if err != nil {
+			return nil
 		}
 	}
-
-	if err == nil || err == io.EOF {
-		return nil
-	}
-
-	return err
 }
 
 func (c cbcEncrypter) Decrypt(key ContentKey, r io.Reader, w io.Writer) error {
@@ -123,5 +140,11 @@ func (c cbcEncrypter) Decrypt(key ContentKey, r io.Reader, w io.Writer) error {
 }
 
 func NewAESCBCEncrypter() Encrypter {
-	return cbcEncrypter(struct{}{})
+	return cbcEncrypter{}
+}
+
+// NewAESCBCEncrypterWithRand returns an encrypter which takes its IVs and padding bytes from rnd.
+// With a deterministic source, the output is reproducible: this must only be used for test vectors.
+func NewAESCBCEncrypterWithRand(rnd io.Reader) Encrypter {
+	return cbcEncrypter{rand: rnd}
 }
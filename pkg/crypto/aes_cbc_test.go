@@ -29,6 +29,7 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/sha256"
+	"io"
 	"testing"
 )
 
@@ -93,6 +94,14 @@ func TestFailingReaderForEncryption(t *testing.T) {
 	if err == nil {
 		t.Error("expected an error from the reader")
 	}
+
+	// the error of the reader prevails over a misaligned input
+	output.Reset()
+	err = cbc.Encrypt(key[:], io.MultiReader(bytes.NewReader(make([]byte, 5)), failingReader{}), &output)
+
+	if err != io.ErrShortBuffer {
+		t.Errorf("expected the error of the reader, got %v", err)
+	}
 }
 
 func TestDecrypt(t *testing.T) {
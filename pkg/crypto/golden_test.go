@@ -0,0 +1,79 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// run "go test ./pkg/crypto -update" to regenerate the golden files,
+// only when a change of the encrypted output is intended.
+var update = flag.Bool("update", false, "update the golden files")
+
+const goldenDir = "../test/golden"
+
+// checkGolden compares some output with the content of a golden file
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join(goldenDir, name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read the golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Output differs from the golden file %s:\ngot  %x\nwant %x", name, got, want)
+	}
+}
+
+// fixed test vectors
+var (
+	goldenKey, _ = hex.DecodeString("0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20")
+	goldenKEK, _ = hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	goldenText   = []byte("Down the Rabbit-Hole. Alice was beginning to get very tired of sitting by her sister on the bank.")
+)
+
+func TestGoldenCBC(t *testing.T) {
+
+	encrypter := NewAESCBCEncrypterWithRand(rand.New(rand.NewSource(42)))
+
+	var out bytes.Buffer
+	if err := encrypter.Encrypt(goldenKey, bytes.NewReader(goldenText), &out); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "aes256-cbc.bin", out.Bytes())
+
+	// the golden output must decrypt to the input
+	var clear bytes.Buffer
+	if err := encrypter.(Decrypter).Decrypt(goldenKey, bytes.NewReader(out.Bytes()), &clear); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(clear.Bytes(), goldenText) {
+		t.Error("Failed to decrypt the golden output")
+	}
+}
+
+func TestGoldenKeyWrap(t *testing.T) {
+
+	// test vector from RFC 3394, section 4.1
+	key, _ := hex.DecodeString("00112233445566778899aabbccddeeff")
+	want, _ := hex.DecodeString("1fa68b0a8112b447aef34bd8fb5a7b829d3e862371d2cfe5")
+	got := KeyWrap(goldenKEK, key)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Invalid key wrap, got %x", got)
+	}
+	checkGolden(t, "keywrap.bin", got)
+}
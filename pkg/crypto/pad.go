@@ -38,6 +38,7 @@ type paddedReader struct {
 	left  byte
 	done  bool
 	insertPadLengthAll bool
+	rand  io.Reader // source of the random padding bytes
 }
 
 func (r *paddedReader) Read(buf []byte) (int, error) {
@@ -77,7 +78,7 @@ func (r *paddedReader) Read(buf []byte) (int, error) {
 func (r *paddedReader) pad(buf []byte) (i int, err error) {
 	capacity := cap(buf)
 
-	src := rand.New(rand.NewSource(time.Now().UnixNano()))
+	rnd := make([]byte, 1)
 
 	for i = 0; capacity > 0 && r.left > 0; i++ {
 
@@ -87,7 +88,10 @@ func (r *paddedReader) pad(buf []byte) (i int, err error) {
 			if r.left == 1 { //capacity == 1 && 
 				buf[i] = r.count
 			} else {
-				buf[i] = byte(src.Intn(254) + 1)
+				if _, err = io.ReadFull(r.rand, rnd); err != nil {
+					return
+				}
+				buf[i] = rnd[0]%254 + 1
 			}
 		}
 
@@ -106,5 +110,10 @@ func (r *paddedReader) pad(buf []byte) (i int, err error) {
 // insertPadLengthAll = true means PKCS#7 (padding length inserted in each padding slot),
 // otherwise false means padding length inserted only in the last slot (the rest is random bytes)
 func PaddedReader(r io.Reader, blockSize byte, insertPadLengthAll bool) io.Reader {
-	return &paddedReader{Reader: r, size: blockSize, count: 0, left: 0, done: false, insertPadLengthAll: insertPadLengthAll}
+	return newPaddedReader(r, blockSize, insertPadLengthAll, rand.New(rand.NewSource(time.Now().UnixNano())))
+}
+
+// newPaddedReader takes random padding bytes from rnd
+func newPaddedReader(r io.Reader, blockSize byte, insertPadLengthAll bool, rnd io.Reader) io.Reader {
+	return &paddedReader{Reader: r, size: blockSize, count: 0, left: 0, done: false, insertPadLengthAll: insertPadLengthAll, rand: rnd}
 }
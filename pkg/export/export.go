@@ -0,0 +1,259 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package export copies the events of the licenses, i.e. their registrations, returns, renewals and revocations,
+// to a write-once storage, an S3 bucket with object lock, so that the history of the licenses is retained for
+// the required time even if the database is lost or rewritten. The events are exported in batches signed by the
+// certificate of the server, which auditors verify with Verify.
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/sign"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+// BatchSize is the max number of events of a batch.
+const BatchSize = 5000
+
+// prefix of the keys of the batches, followed by the identifiers of their first and last events
+const keyPrefix = "events/"
+
+// Batch is an exported object: consecutive events of the licenses, signed by the server.
+// The first chained event of a batch is linked to the last chained event of the previous batch.
+type Batch struct {
+	Server    string          `json:"server"`
+	Exported  time.Time       `json:"exported"`
+	First     uint            `json:"first"` // identifier of the first event
+	Last      uint            `json:"last"`  // identifier of the last event
+	Events    []Record        `json:"events"`
+	Signature *sign.Signature `json:"signature,omitempty"`
+}
+
+// Record is an exported event.
+type Record struct {
+	ID         uint      `json:"id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Type       string    `json:"type"`
+	DeviceName string    `json:"device_name"`
+	DeviceID   string    `json:"device_id"`
+	LicenseID  string    `json:"license_id"`
+	Reason     string    `json:"reason,omitempty"`
+	Actor      string    `json:"actor,omitempty"`
+	Previous   string    `json:"previous,omitempty"` // digest of the previous event of the chain
+	Digest     string    `json:"digest,omitempty"`   // empty for the events recorded before the chaining
+}
+
+// Exporter exports the new events at each interval.
+type Exporter struct {
+	Config  conf.Export
+	Store   stor.Store
+	Storage storage.Storage // write-once storage, implementing storage.Locker
+	Signer  sign.Signer
+	Server  string           // identifier of the server in the batches, e.g. its public base url
+	Now     func() time.Time // time.Now if nil
+
+	last *uint // identifier of the last exported event, once known
+}
+
+// NewExporter creates an exporter of the events of a store to a write-once storage.
+func NewExporter(c conf.Export, st stor.Store, s storage.Storage, signer sign.Signer, server string) (*Exporter, error) {
+	if _, ok := s.(storage.Locker); !ok {
+		return nil, errors.New("the storage of the exported events must be write-once")
+	}
+	return &Exporter{Config: c, Store: st, Storage: s, Signer: signer, Server: server}, nil
+}
+
+// Interval returns the time between exports.
+func (ex *Exporter) Interval() time.Duration {
+	if ex.Config.Interval <= 0 {
+		return time.Hour
+	}
+	return time.Duration(ex.Config.Interval) * time.Minute
+}
+
+func (ex *Exporter) now() time.Time {
+	if ex.Now == nil {
+		return time.Now()
+	}
+	return ex.Now()
+}
+
+// Run exports the new events at each interval, until the context is cancelled.
+func (ex *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(ex.Interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := ex.Export(ctx); err != nil {
+				log.Errorf("Export of the events failed: %v", err)
+			} else if n > 0 {
+				log.Infof("%d events exported", n)
+			}
+		}
+	}
+}
+
+// Export writes the events recorded since the last export, in batches locked for the retention time,
+// and returns the number of exported events. The events of the last minute are left to the next export,
+// so that an event being recorded is not skipped.
+func (ex *Exporter) Export(ctx context.Context) (int, error) {
+	locker, ok := ex.Storage.(storage.Locker)
+	if !ok {
+		return 0, errors.New("the storage of the exported events must be write-once")
+	}
+	last, err := ex.lastExported(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find the last exported event: %w", err)
+	}
+	now := ex.now()
+	total := 0
+	for {
+		events, err := ex.Store.WithContext(ctx).Event().Since(last, now.Add(-time.Minute), BatchSize)
+		if err != nil {
+			return total, err
+		}
+		if len(*events) == 0 {
+			return total, nil
+		}
+		batch := &Batch{Server: ex.Server, Exported: now.UTC(), Events: make([]Record, len(*events))}
+		for i, e := range *events {
+			batch.Events[i] = Record{
+				ID:         e.ID,
+				Timestamp:  e.Timestamp.UTC(),
+				Type:       e.Type,
+				DeviceName: e.DeviceName,
+				DeviceID:   e.DeviceID,
+				LicenseID:  e.LicenseID,
+				Reason:     e.Reason,
+				Actor:      e.Actor,
+				Previous:   e.Previous,
+				Digest:     e.Digest,
+			}
+		}
+		batch.First, batch.Last = batch.Events[0].ID, batch.Events[len(batch.Events)-1].ID
+		signature, err := ex.Signer.Sign(batch)
+		if err != nil {
+			return total, fmt.Errorf("failed to sign the batch: %w", err)
+		}
+		batch.Signature = &signature
+		data, err := json.Marshal(batch)
+		if err != nil {
+			return total, err
+		}
+		until := now.AddDate(0, 0, ex.Config.RetentionDays)
+		if err = locker.PutLocked(ctx, Key(batch.First, batch.Last), data, ex.Config.LockMode(), until); err != nil {
+			return total, fmt.Errorf("failed to write the batch: %w", err)
+		}
+		last = batch.Last
+		ex.last = &last
+		total += len(batch.Events)
+	}
+}
+
+// Key returns the key of the batch of the events from first to last.
+func Key(first, last uint) string {
+	return fmt.Sprintf("%s%020d-%020d.json", keyPrefix, first, last)
+}
+
+// lastExported returns the identifier of the last exported event, found in the keys of the batches at the first export
+func (ex *Exporter) lastExported(ctx context.Context) (uint, error) {
+	if ex.last != nil {
+		return *ex.last, nil
+	}
+	objects, err := ex.Storage.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var last uint
+	for _, o := range objects {
+		var first, l uint
+		if !strings.HasPrefix(o.Key, keyPrefix) {
+			continue
+		}
+		if _, err := fmt.Sscanf(strings.TrimPrefix(o.Key, keyPrefix), "%d-%d.json", &first, &l); err == nil && l > last {
+			last = l
+		}
+	}
+	ex.last = &last
+	return last, nil
+}
+
+// Verify checks an exported batch: its signature, the digest of each chained event, and the links between
+// the chained events of the batch. The certificate of the signature must be compared to the certificate of
+// the server, and the first chained event of the batch linked to the last one of the previous batch.
+func Verify(data []byte) (*Batch, error) {
+	var batch Batch
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, fmt.Errorf("invalid batch: %w", err)
+	}
+	if batch.Signature == nil {
+		return nil, errors.New("the batch is not signed")
+	}
+	signature := batch.Signature
+	batch.Signature = nil
+	checker, err := sign.NewSignChecker(signature.Certificate, signature.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if err = checker.Check(batch, signature.Value); err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	batch.Signature = signature
+
+	if len(batch.Events) == 0 || batch.Events[0].ID != batch.First || batch.Events[len(batch.Events)-1].ID != batch.Last ||
+		!sort.SliceIsSorted(batch.Events, func(i, j int) bool { return batch.Events[i].ID < batch.Events[j].ID }) {
+		return nil, errors.New("the events of the batch are not in order")
+	}
+	previous := ""
+	for _, r := range batch.Events {
+		if r.Digest == "" {
+			continue
+		}
+		e := stor.Event{Timestamp: r.Timestamp, Type: r.Type, DeviceName: r.DeviceName, DeviceID: r.DeviceID,
+			LicenseID: r.LicenseID, Reason: r.Reason, Actor: r.Actor, Previous: r.Previous, Digest: r.Digest}
+		if !e.Intact() {
+			return nil, fmt.Errorf("the event %d does not match its digest", r.ID)
+		}
+		if previous != "" && r.Previous != previous {
+			return nil, fmt.Errorf("the event %d is not linked to the previous event", r.ID)
+		}
+		previous = r.Digest
+	}
+	return &batch, nil
+}
+
+// Head returns the digest of the last chained event of a batch, to which the next batch is linked.
+func (b *Batch) Head() string {
+	for i := len(b.Events) - 1; i >= 0; i-- {
+		if b.Events[i].Digest != "" {
+			return b.Events[i].Digest
+		}
+	}
+	return ""
+}
+
+// Linked tells if the first chained event of a batch follows the head of the previous batch.
+func (b *Batch) Linked(previous *Batch) bool {
+	head := previous.Head()
+	for _, r := range b.Events {
+		if r.Digest != "" {
+			return head == "" || r.Previous == head
+		}
+	}
+	return true
+}
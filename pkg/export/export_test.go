@@ -0,0 +1,120 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/sign"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/storage"
+	"github.com/edrlab/lcp-server/pkg/stortest"
+	"github.com/edrlab/lcp-server/pkg/testca"
+)
+
+// lockedStorage is a file storage recording the retention dates of its locked objects
+type lockedStorage struct {
+	*storage.FileStorage
+	until map[string]time.Time
+}
+
+func (s *lockedStorage) PutLocked(ctx context.Context, key string, data []byte, mode string, until time.Time) error {
+	if _, ok := s.until[key]; ok {
+		return storage.ErrInvalidKey
+	}
+	s.until[key] = until
+	_, err := s.Put(ctx, key, bytes.NewReader(data))
+	return err
+}
+
+func TestExport(t *testing.T) {
+
+	st := stortest.SQLite()(t)
+	pub := stortest.CreatePublications(t, st, 1, "application/epub+zip")[0]
+	license := stortest.CreateLicenses(t, st, 1, pub.UUID, "user1")[0]
+	now := time.Now()
+	for _, e := range []*stor.Event{
+		{Timestamp: now.Add(-time.Hour), Type: stor.EVENT_REGISTER, DeviceID: "d1", DeviceName: "device 1", LicenseID: license.UUID},
+		{Timestamp: now.Add(-time.Hour), Type: stor.EVENT_REVOKE, LicenseID: license.UUID, Reason: "refund", Actor: "admin"},
+		{Timestamp: now, Type: stor.EVENT_REGISTER, DeviceID: "d2", DeviceName: "device 2", LicenseID: license.UUID},
+	} {
+		if err := st.Event().Create(e); err != nil {
+			t.Fatalf("Failed to create an event: %v", err)
+		}
+	}
+
+	fs, err := storage.NewFileStorage(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewExporter(conf.Export{}, st, fs, nil, ""); err == nil {
+		t.Error("Expected a storage which is not write-once to be rejected")
+	}
+	locked := &lockedStorage{FileStorage: fs, until: make(map[string]time.Time)}
+	ca, err := testca.New("LCP export CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := sign.NewSigner(ca.TLSCertificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex, err := NewExporter(conf.Export{RetentionDays: 30}, st, locked, signer, "https://lcp.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the events of the last minute are left to the next export
+	ctx := context.Background()
+	if n, err := ex.Export(ctx); err != nil || n != 2 {
+		t.Fatalf("Expected 2 exported events, got %d, %v", n, err)
+	}
+	key := Key(1, 2)
+	if until := locked.until[key]; until.Before(now.AddDate(0, 0, 29)) {
+		t.Errorf("Expected the batch to be locked for the retention time, got %v", until)
+	}
+	r, err := fs.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Expected the batch %s, got %v", key, err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	first, err := Verify(data)
+	if err != nil {
+		t.Fatalf("Expected a valid batch, got %v", err)
+	}
+	if len(first.Events) != 2 || first.Events[1].Reason != "refund" || first.Server != "https://lcp.example.com" {
+		t.Errorf("Unexpected batch %+v", first)
+	}
+
+	// a later export, e.g. by a restarted server, continues after the last exported event
+	ex = &Exporter{Config: ex.Config, Store: st, Storage: locked, Signer: signer, Now: func() time.Time { return now.Add(time.Hour) }}
+	if n, err := ex.Export(ctx); err != nil || n != 1 {
+		t.Fatalf("Expected 1 exported event, got %d, %v", n, err)
+	}
+	r, err = fs.Get(ctx, Key(3, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = io.ReadAll(r)
+	r.Close()
+	second, err := Verify(data)
+	if err != nil || !second.Linked(first) {
+		t.Errorf("Expected a valid batch linked to the previous one, got %v", err)
+	}
+
+	// a batch altered after its export is detected
+	if _, err = Verify(bytes.Replace(data, []byte("device 2"), []byte("device 3"), 1)); err == nil {
+		t.Error("Expected an altered batch to be rejected")
+	}
+	if n, err := ex.Export(ctx); err != nil || n != 0 {
+		t.Errorf("Expected no new event, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package fault injects latency and failures in the database, the signer and the storage of publications,
+// at configurable rates, so that the behavior of the server and of its clients under partial outages
+// can be tested before an incident.
+package fault
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/sign"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/storage"
+)
+
+// ErrInjected is the error of every injected failure.
+var ErrInjected = errors.New("injected fault")
+
+// Injector decides at random which operations are delayed or failed.
+type Injector struct {
+	conf conf.Faults
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+
+	dbDelayed     uint64
+	signerFailed  uint64
+	storageFailed uint64
+}
+
+// Stats count the injected faults.
+type Stats struct {
+	DBDelayed     uint64 `json:"db_delayed"`
+	SignerFailed  uint64 `json:"signer_failed"`
+	StorageFailed uint64 `json:"storage_failed"`
+}
+
+// NewInjector creates an injector applying the rates of a configuration.
+func NewInjector(c conf.Faults) *Injector {
+	seed := c.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Injector{conf: c, rnd: rand.New(rand.NewSource(seed))}
+}
+
+// hit tells if an operation is affected by a fault injected at a rate.
+func (i *Injector) hit(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.rnd.Float64() < rate
+}
+
+// Stats returns the number of faults injected so far.
+func (i *Injector) Stats() Stats {
+	return Stats{
+		DBDelayed:     atomic.LoadUint64(&i.dbDelayed),
+		SignerFailed:  atomic.LoadUint64(&i.signerFailed),
+		StorageFailed: atomic.LoadUint64(&i.storageFailed),
+	}
+}
+
+// DBHook delays database operations. The delay is cut short if the operation is cancelled.
+func (i *Injector) DBHook(ctx context.Context) error {
+	if !i.hit(i.conf.DBLatencyRate) {
+		return nil
+	}
+	atomic.AddUint64(&i.dbDelayed, 1)
+	timer := time.NewTimer(time.Duration(i.conf.DBLatency) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Store registers the database hook of the injector on a store set up by stor.DBSetup.
+func (i *Injector) Store(st stor.Store) error {
+	if i.conf.DBLatencyRate <= 0 {
+		return nil
+	}
+	return stor.AddHook(st, "faults", i.DBHook)
+}
+
+// faultySigner fails signatures at random.
+type faultySigner struct {
+	sign.Signer
+	injector *Injector
+}
+
+func (s *faultySigner) Sign(in interface{}) (sign.Signature, error) {
+	if s.injector.hit(s.injector.conf.SignerErrorRate) {
+		atomic.AddUint64(&s.injector.signerFailed, 1)
+		return sign.Signature{}, ErrInjected
+	}
+	return s.Signer.Sign(in)
+}
+
+// Signer returns a signer failing at the signer error rate, or the signer itself if no signer fault is configured.
+func (i *Injector) Signer(signer sign.Signer) sign.Signer {
+	if i.conf.SignerErrorRate <= 0 {
+		return signer
+	}
+	return &faultySigner{Signer: signer, injector: i}
+}
+
+// faultyStorage fails storage operations at random; urls are not affected.
+type faultyStorage struct {
+	storage.Storage
+	injector *Injector
+}
+
+func (s *faultyStorage) fail() bool {
+	if s.injector.hit(s.injector.conf.StorageErrorRate) {
+		atomic.AddUint64(&s.injector.storageFailed, 1)
+		return true
+	}
+	return false
+}
+
+func (s *faultyStorage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if s.fail() {
+		return 0, ErrInjected
+	}
+	return s.Storage.Put(ctx, key, r)
+}
+
+func (s *faultyStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if s.fail() {
+		return nil, ErrInjected
+	}
+	return s.Storage.Get(ctx, key)
+}
+
+func (s *faultyStorage) Delete(ctx context.Context, key string) error {
+	if s.fail() {
+		return ErrInjected
+	}
+	return s.Storage.Delete(ctx, key)
+}
+
+func (s *faultyStorage) List(ctx context.Context) ([]storage.Object, error) {
+	if s.fail() {
+		return nil, ErrInjected
+	}
+	return s.Storage.List(ctx)
+}
+
+func (s *faultyStorage) Ping(ctx context.Context) error {
+	if s.fail() {
+		return ErrInjected
+	}
+	return storage.Ping(ctx, s.Storage)
+}
+
+// Storage returns a storage failing at the storage error rate, or the storage itself if no storage fault is configured.
+func (i *Injector) Storage(st storage.Storage) storage.Storage {
+	if i.conf.StorageErrorRate <= 0 || st == nil {
+		return st
+	}
+	return &faultyStorage{Storage: st, injector: i}
+}
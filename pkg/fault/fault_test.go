@@ -0,0 +1,110 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package fault
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/sign"
+	"github.com/edrlab/lcp-server/pkg/storage"
+)
+
+type stubSigner struct{}
+
+func (stubSigner) Sign(interface{}) (sign.Signature, error) {
+	return sign.Signature{Algorithm: "stub"}, nil
+}
+
+func TestRates(t *testing.T) {
+
+	// the same seed injects the same faults
+	runs := make([][]bool, 2)
+	for r := range runs {
+		i := NewInjector(conf.Faults{SignerErrorRate: 0.3, Seed: 42})
+		signer := i.Signer(stubSigner{})
+		for n := 0; n < 1000; n++ {
+			_, err := signer.Sign(nil)
+			if err != nil && !errors.Is(err, ErrInjected) {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			runs[r] = append(runs[r], err != nil)
+		}
+		failed := i.Stats().SignerFailed
+		if failed < 200 || failed > 400 {
+			t.Errorf("Expected about 300 failures, got %d", failed)
+		}
+	}
+	for n := range runs[0] {
+		if runs[0][n] != runs[1][n] {
+			t.Fatal("Expected seeded runs to inject the same faults")
+		}
+	}
+
+	// no fault, no wrapper
+	i := NewInjector(conf.Faults{})
+	if _, ok := i.Signer(stubSigner{}).(stubSigner); !ok {
+		t.Error("Expected the signer to be left unchanged")
+	}
+	if i.Storage(nil) != nil {
+		t.Error("Expected a nil storage to be left unchanged")
+	}
+}
+
+func TestStorage(t *testing.T) {
+
+	hot, err := storage.NewFileStorage(t.TempDir(), "https://cdn.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := NewInjector(conf.Faults{StorageErrorRate: 1})
+	st := i.Storage(hot)
+	ctx := context.Background()
+	if _, err = st.Put(ctx, "a.epub", strings.NewReader("a")); !errors.Is(err, ErrInjected) {
+		t.Errorf("Expected an injected fault, got %v", err)
+	}
+	if _, err = st.Get(ctx, "a.epub"); !errors.Is(err, ErrInjected) {
+		t.Errorf("Expected an injected fault, got %v", err)
+	}
+	if err = st.Delete(ctx, "a.epub"); !errors.Is(err, ErrInjected) {
+		t.Errorf("Expected an injected fault, got %v", err)
+	}
+	if _, err = st.List(ctx); !errors.Is(err, ErrInjected) {
+		t.Errorf("Expected an injected fault, got %v", err)
+	}
+	if st.URL("a.epub") != hot.URL("a.epub") {
+		t.Error("Expected urls not to be affected")
+	}
+	if failed := i.Stats().StorageFailed; failed != 4 {
+		t.Errorf("Expected 4 failures, got %d", failed)
+	}
+}
+
+func TestDBHook(t *testing.T) {
+
+	i := NewInjector(conf.Faults{DBLatency: 50, DBLatencyRate: 1})
+	start := time.Now()
+	if err := i.DBHook(context.Background()); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("Expected the operation to be delayed")
+	}
+
+	// the delay is cut short by a cancellation
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	i = NewInjector(conf.Faults{DBLatency: 10000, DBLatencyRate: 1})
+	if err := i.DBHook(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to be exceeded, got %v", err)
+	}
+	if delayed := i.Stats().DBDelayed; delayed != 1 {
+		t.Errorf("Expected 1 delayed operation, got %d", delayed)
+	}
+}
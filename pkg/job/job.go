@@ -0,0 +1,158 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package job executes the bulk operations of the server in the background, e.g. the revocation of a list of
+// licenses. The items of a job are processed in order; its progress and the results of the processed items are
+// saved regularly, and a cancelled job stops after the item in process, keeping its partial results.
+// Jobs are executed by the instance which started them: a job interrupted by a restart is failed.
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// SaveInterval is the number of items processed between two saves of the progress of a job.
+const SaveInterval = 20
+
+// ErrNotRunning is returned when a job which is not running is cancelled.
+var ErrNotRunning = errors.New("the job is not running")
+
+// Processor processes an item of a job, and returns its result; the index of the result is set by the runner.
+// The context is done when the job is cancelled: the item in process may be completed nevertheless,
+// as the store is not bound to the context.
+type Processor func(ctx context.Context, st stor.Store, item json.RawMessage) stor.JobResult
+
+// Runner executes jobs, each in its own goroutine.
+type Runner struct {
+	Store stor.Store
+	Clock func() time.Time // returns the current time; time.Now if nil
+
+	mu      sync.Mutex
+	running map[string]*execution
+}
+
+// execution is a running job
+type execution struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewRunner creates a job runner.
+func NewRunner(st stor.Store) *Runner {
+	return &Runner{
+		Store:   st,
+		Clock:   time.Now,
+		running: make(map[string]*execution),
+	}
+}
+
+// Start records a job and starts processing its items, and returns the job.
+func (r *Runner) Start(jobType string, items []json.RawMessage, process Processor) (*stor.Job, error) {
+	job := &stor.Job{
+		UUID:    uuid.New().String(),
+		Type:    jobType,
+		Status:  stor.JOB_RUNNING,
+		Total:   len(items),
+		Results: []stor.JobResult{},
+	}
+	if err := r.Store.Job().Create(job); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &execution{cancel: cancel, done: make(chan struct{})}
+	r.mu.Lock()
+	r.running[job.UUID] = e
+	r.mu.Unlock()
+
+	running := *job
+	go r.run(ctx, &running, items, process, e)
+	return job, nil
+}
+
+// Cancel stops a running job after the item in process, and returns the cancelled job.
+func (r *Runner) Cancel(uuid string) (*stor.Job, error) {
+	r.mu.Lock()
+	e, ok := r.running[uuid]
+	r.mu.Unlock()
+	if !ok {
+		return nil, ErrNotRunning
+	}
+	e.cancel()
+	<-e.done
+	return r.Store.Job().Get(uuid)
+}
+
+// Stop cancels every running job, e.g. when the server shuts down, and waits for the items in process.
+// The cancelled jobs keep their partial results.
+func (r *Runner) Stop() {
+	r.mu.Lock()
+	running := make([]*execution, 0, len(r.running))
+	for _, e := range r.running {
+		running = append(running, e)
+	}
+	r.mu.Unlock()
+	for _, e := range running {
+		e.cancel()
+		<-e.done
+	}
+}
+
+// Wait waits for the end of a job, if it is running.
+func (r *Runner) Wait(uuid string) {
+	r.mu.Lock()
+	e, ok := r.running[uuid]
+	r.mu.Unlock()
+	if ok {
+		<-e.done
+	}
+}
+
+// run processes the items of a job, until the end or the cancellation of the job
+func (r *Runner) run(ctx context.Context, job *stor.Job, items []json.RawMessage, process Processor, e *execution) {
+	defer func() {
+		r.mu.Lock()
+		delete(r.running, job.UUID)
+		r.mu.Unlock()
+		close(e.done)
+	}()
+
+	for i, item := range items {
+		if ctx.Err() != nil {
+			job.Status = stor.JOB_CANCELLED
+			break
+		}
+		result := process(ctx, r.Store, item)
+		result.Index = i
+		job.Results = append(job.Results, result)
+		job.Processed++
+		if result.Status >= 300 {
+			job.Failed++
+		}
+		if job.Processed%SaveInterval == 0 {
+			r.save(job)
+		}
+	}
+	if job.Status == stor.JOB_RUNNING {
+		job.Status = stor.JOB_COMPLETED
+	}
+	now := stor.Now(r.Clock)
+	job.Finished = &now
+	r.save(job)
+	log.Infof("Job %s %s: %d items processed, %d failed", job.UUID, job.Status, job.Processed, job.Failed)
+}
+
+// save records the progress of a job
+func (r *Runner) save(job *stor.Job) {
+	if err := r.Store.Job().Update(job); err != nil {
+		log.Errorf("Failed to save the progress of the job %s: %v", job.UUID, err)
+	}
+}
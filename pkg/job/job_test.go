@@ -0,0 +1,94 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/stortest"
+)
+
+func TestRun(t *testing.T) {
+
+	st := stortest.SQLite()(t)
+	r := NewRunner(st)
+
+	// odd items fail
+	items := make([]json.RawMessage, 2*SaveInterval+1)
+	for i := range items {
+		items[i], _ = json.Marshal(i)
+	}
+	process := func(ctx context.Context, st stor.Store, item json.RawMessage) stor.JobResult {
+		var i int
+		json.Unmarshal(item, &i)
+		if i%2 == 1 {
+			return stor.JobResult{Status: http.StatusBadRequest, Error: "odd"}
+		}
+		return stor.JobResult{Status: http.StatusOK}
+	}
+	job, err := r.Start("test", items, process)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Wait(job.UUID)
+
+	job, err = st.Job().Get(job.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != stor.JOB_COMPLETED || job.Processed != len(items) || job.Failed != SaveInterval || job.Progress() != 100 ||
+		job.Finished == nil || len(job.Results) != len(items) || job.Results[1].Index != 1 || job.Results[1].Error != "odd" {
+		t.Errorf("Unexpected job %+v", job)
+	}
+	if _, err = r.Cancel(job.UUID); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Expected a job which is not running, got %v", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+
+	st := stortest.SQLite()(t)
+	r := NewRunner(st)
+
+	// the second item is processed until the job is cancelled
+	items := []json.RawMessage{[]byte("0"), []byte("1"), []byte("2")}
+	started := make(chan struct{})
+	process := func(ctx context.Context, st stor.Store, item json.RawMessage) stor.JobResult {
+		if string(item) == "1" {
+			close(started)
+			<-ctx.Done()
+		}
+		return stor.JobResult{Status: http.StatusOK}
+	}
+	job, err := r.Start("test", items, process)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	job, err = r.Cancel(job.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != stor.JOB_CANCELLED || job.Processed != 2 || job.Progress() != 66 || len(job.Results) != 2 || job.Finished == nil {
+		t.Errorf("Unexpected job %+v", job)
+	}
+
+	// jobs left running by a restart are failed
+	running := &stor.Job{UUID: "running", Status: stor.JOB_RUNNING, Total: 1}
+	if err = st.Job().Create(running); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := st.Job().Interrupt(stor.Now(r.Clock)); err != nil || n != 1 {
+		t.Errorf("Expected an interrupted job, got %d, %v", n, err)
+	}
+	if job, _ = st.Job().Get("running"); job.Status != stor.JOB_FAILED || job.Error == "" {
+		t.Errorf("Expected a failed job, got %+v", job)
+	}
+}
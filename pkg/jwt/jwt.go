@@ -0,0 +1,265 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package jwt validates the JSON Web Tokens issued by an identity provider, e.g. as OAuth 2.0 access tokens.
+// Tokens are signed with an asymmetric key (RS, PS, ES or EdDSA algorithms) published in the JSON Web Key Set
+// of the provider; shared secrets (HS algorithms) and unsigned tokens are rejected.
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256" // hash functions of the signature algorithms
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+)
+
+// ErrInvalidToken is returned for a token which is malformed, badly signed, expired or not intended for the server.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the registered claims of a token, and every claim in Raw.
+type Claims struct {
+	Issuer    string                 `json:"iss"`
+	Subject   string                 `json:"sub"`
+	Audience  Audience               `json:"aud"`
+	Expires   float64                `json:"exp"`
+	NotBefore float64                `json:"nbf"`
+	IssuedAt  float64                `json:"iat"`
+	Raw       map[string]interface{} `json:"-"`
+	Roles     []string               `json:"-"` // roles granted to the token, set by the validator
+	Provider  string                 `json:"-"` // URI of the provider the token is restricted to, if any, set by the validator
+}
+
+// Audience is the aud claim, a string or an array of strings.
+type Audience []string
+
+// UnmarshalJSON accepts a single audience as a string.
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Contains tells if an audience is part of the claim.
+func (a Audience) Contains(aud string) bool {
+	for _, s := range a {
+		if s == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// Validator validates the tokens of an identity provider.
+type Validator struct {
+	Issuer        string
+	Audience      string
+	Leeway        time.Duration    // tolerated clock skew on exp and nbf
+	Keys          *KeySet          // signature keys of the provider
+	Clock         func() time.Time // returns the current time; time.Now if nil
+	RoleClaim     string           // claim holding the roles of a token, a string or an array of strings
+	Role          string           // role of the tokens without role claim, if any
+	ProviderClaim string           // claim holding the provider a token is restricted to, if any
+}
+
+// NewValidator creates a validator from the configuration.
+func NewValidator(c conf.JWT) *Validator {
+	v := &Validator{
+		Issuer:        c.Issuer,
+		Audience:      c.Audience,
+		Leeway:        time.Duration(c.Leeway) * time.Second,
+		Keys:          NewKeySet(c.JWKSURL),
+		Clock:         time.Now,
+		RoleClaim:     c.RoleClaim,
+		Role:          c.Role,
+		ProviderClaim: c.ProviderClaim,
+	}
+	if v.RoleClaim == "" {
+		v.RoleClaim = "roles"
+	}
+	return v
+}
+
+// header is the JOSE header of a token
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// Validate checks the signature and the claims of a token, and returns its claims.
+// The errors wrap ErrInvalidToken, except when the keys of the provider cannot be fetched.
+func (v *Validator) Validate(ctx context.Context, token string) (*Claims, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	// the signature
+	key, err := v.Keys.Get(ctx, h.Kid, h.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err = verify(h.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	// the claims
+	var claims Claims
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	if err = decodeSegment(parts[1], &claims.Raw); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	clock := v.Clock
+	if clock == nil {
+		clock = time.Now
+	}
+	now := clock()
+	switch {
+	case claims.Issuer != v.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, claims.Issuer)
+	case !claims.Audience.Contains(v.Audience):
+		return nil, fmt.Errorf("%w: unexpected audience %v", ErrInvalidToken, claims.Audience)
+	case claims.Expires == 0:
+		return nil, fmt.Errorf("%w: missing expiration", ErrInvalidToken)
+	case now.After(numericDate(claims.Expires).Add(v.Leeway)):
+		return nil, fmt.Errorf("%w: expired token", ErrInvalidToken)
+	case claims.NotBefore != 0 && now.Add(v.Leeway).Before(numericDate(claims.NotBefore)):
+		return nil, fmt.Errorf("%w: token not yet valid", ErrInvalidToken)
+	}
+	claims.Roles = stringList(claims.Raw[v.RoleClaim])
+	if len(claims.Roles) == 0 && v.Role != "" {
+		claims.Roles = []string{v.Role}
+	}
+	if v.ProviderClaim != "" {
+		// a token without provider would not be restricted to any
+		provider, _ := claims.Raw[v.ProviderClaim].(string)
+		if provider == "" {
+			return nil, fmt.Errorf("%w: missing provider claim %q", ErrInvalidToken, v.ProviderClaim)
+		}
+		claims.Provider = provider
+	}
+	return &claims, nil
+}
+
+// stringList returns the strings of a claim, a single string or an array
+func stringList(claim interface{}) []string {
+	switch c := claim.(type) {
+	case string:
+		return []string{c}
+	case []interface{}:
+		list := make([]string, 0, len(c))
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// numericDate converts a number of seconds since the epoch to a time
+func numericDate(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a token
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// hashes of the signature algorithms, by name
+var hashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// verify checks the signature of a token with the public key of its algorithm
+func verify(alg string, key crypto.PublicKey, signed, sig []byte) error {
+
+	if alg == "EdDSA" {
+		k, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(k, signed, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	hash, ok := hashes[alg]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	hasher := hash.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] == 'R' {
+			return rsa.VerifyPKCS1v15(k, hash, digest, sig)
+		}
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(k, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+	case *ecdsa.PublicKey:
+		// the signature is the concatenation of r and s, each of the size of the curve
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[0] == 'E' && len(sig) == 2*size {
+			r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+			if ecdsa.Verify(k, digest, r, s) {
+				return nil
+			}
+		}
+	}
+	return errors.New("invalid signature")
+}
+
+// claimsKey is the context key of the claims of a request
+type claimsKey struct{}
+
+// NewContext returns a context holding the claims of the token of a request.
+func NewContext(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// FromContext returns the claims of the token of a request, or nil if the request had no token.
+func FromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(claimsKey{}).(*Claims)
+	return claims
+}
@@ -0,0 +1,199 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var b64 = base64.RawURLEncoding
+
+// sign builds a token signed with a private key
+func sign(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+	if alg == "EdDSA" {
+		sig, _ := key.Sign(rand.Reader, []byte(signed), crypto.Hash(0))
+		return signed + "." + b64.EncodeToString(sig)
+	}
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signed))
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest.Sum(nil))
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest.Sum(nil))
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+// publicJWK returns the jwk of the public part of a key
+func publicJWK(kid string, key crypto.Signer) map[string]string {
+	switch k := key.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "n": b64.EncodeToString(k.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64.EncodeToString(k.X.FillBytes(make([]byte, 32))), "y": b64.EncodeToString(k.Y.FillBytes(make([]byte, 32)))}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": b64.EncodeToString(k)}
+	}
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rotated, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	// the key set, with an unsupported key
+	keys := []map[string]string{publicJWK("rsa", rsaKey), publicJWK("ec", ecKey), publicJWK("ed", edKey), {"kty": "oct", "kid": "secret", "k": "c2VjcmV0"}}
+	var fetches int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer ts.Close()
+
+	now := time.Unix(1700000000, 0)
+	v := &Validator{Issuer: "https://idp.example.com/", Audience: "lcp", Leeway: 30 * time.Second, Keys: NewKeySet(ts.URL), Clock: func() time.Time { return now }}
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"iss": "https://idp.example.com/", "sub": "cms", "aud": []string{"other", "lcp"}, "exp": now.Unix() + 60, "scope": "licenses"}
+		for k, v := range changes {
+			c[k] = v
+		}
+		return c
+	}
+
+	// every supported key type
+	for _, token := range []string{
+		sign(t, "RS256", "rsa", rsaKey, claims(nil)),
+		sign(t, "ES256", "ec", ecKey, claims(nil)),
+		sign(t, "EdDSA", "ed", edKey, claims(map[string]interface{}{"aud": "lcp"})),
+	} {
+		c, err := v.Validate(context.Background(), token)
+		if err != nil {
+			t.Fatalf("Failed to validate a token: %v", err)
+		}
+		if c.Subject != "cms" || c.Raw["scope"] != "licenses" || len(c.Roles) != 0 {
+			t.Errorf("Unexpected claims %+v", c)
+		}
+	}
+
+	// the roles of a token, or the default role
+	v.RoleClaim, v.Role = "groups", "reader"
+	for _, test := range []struct {
+		groups interface{}
+		roles  string
+	}{
+		{nil, "reader"},
+		{"operator", "operator"},
+		{[]string{"lcp-admins", "admin"}, "lcp-admins admin"},
+	} {
+		c, err := v.Validate(context.Background(), sign(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"groups": test.groups})))
+		if err != nil || strings.Join(c.Roles, " ") != test.roles {
+			t.Errorf("Expected the roles %s, got %+v, %v", test.roles, c, err)
+		}
+	}
+	v.RoleClaim, v.Role = "", ""
+
+	// the provider a token is restricted to, which the token must hold
+	v.ProviderClaim = "publisher"
+	c, err := v.Validate(context.Background(), sign(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"publisher": "https://a.example.com"})))
+	if err != nil || c.Provider != "https://a.example.com" {
+		t.Errorf("Expected the provider https://a.example.com, got %+v, %v", c, err)
+	}
+	for _, claim := range []interface{}{nil, "", 12} {
+		if _, err = v.Validate(context.Background(), sign(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"publisher": claim}))); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Expected an invalid token error for the provider claim %v, got %v", claim, err)
+		}
+	}
+	v.ProviderClaim = ""
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("Expected the keys to be fetched once, got %d", n)
+	}
+
+	// invalid tokens
+	valid := sign(t, "RS256", "rsa", rsaKey, claims(nil))
+	unsigned := b64.EncodeToString([]byte(`{"alg":"none"}`)) + "." + b64.EncodeToString([]byte(`{"iss":"https://idp.example.com/"}`)) + "."
+	for name, token := range map[string]string{
+		"malformed":     "not.a-token",
+		"unsigned":      unsigned,
+		"bad signature": valid[:len(valid)-4] + "AAAA",
+		"wrong key":     sign(t, "RS256", "rsa", rotated, claims(nil)),
+		"wrong alg":     sign(t, "ES256", "rsa", ecKey, claims(nil)),
+		"issuer":        sign(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"iss": "https://other.example.com/"})),
+		"audience":      sign(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"aud": "other"})),
+		"no expiration": sign(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"exp": nil})),
+		"expired":       sign(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"exp": now.Unix() - 31})),
+		"not yet valid": sign(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"nbf": now.Unix() + 31})),
+	} {
+		if _, err := v.Validate(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Expected an invalid token error for %s, got %v", name, err)
+		}
+	}
+
+	// within the leeway
+	if _, err := v.Validate(context.Background(), sign(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"exp": now.Unix() - 29}))); err != nil {
+		t.Errorf("Expected a token expired within the leeway to be valid, got %v", err)
+	}
+
+	// a rotated key is fetched, at most once per period
+	keys = append(keys, publicJWK("rotated", rotated))
+	v.Keys.tried = time.Time{}
+	if _, err := v.Validate(context.Background(), sign(t, "RS256", "rotated", rotated, claims(nil))); err != nil {
+		t.Errorf("Failed to validate a token signed with a rotated key: %v", err)
+	}
+	v.Validate(context.Background(), sign(t, "RS256", "unknown", rotated, claims(nil)))
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("Expected the keys to be fetched twice, got %d", n)
+	}
+
+	// an unavailable key set is not an invalid token
+	ts.Close()
+	v.Keys = NewKeySet(ts.URL)
+	if _, err := v.Validate(context.Background(), valid); err == nil || errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected an unavailable key set, got %v", err)
+	}
+}
+
+func TestContext(t *testing.T) {
+
+	if FromContext(context.Background()) != nil {
+		t.Error("Expected no claims")
+	}
+	claims := &Claims{Subject: "cms"}
+	if FromContext(NewContext(context.Background(), claims)) != claims {
+		t.Error("Expected the claims of the context")
+	}
+}
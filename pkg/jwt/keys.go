@@ -0,0 +1,231 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaults of a key set
+const (
+	defaultKeysTTL   = time.Hour   // keys are fetched again after this lifetime
+	minRefreshPeriod = time.Minute // an unknown key id triggers a fetch at most once per period
+)
+
+// curves of the ES algorithms
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// curves expected by the ES algorithms
+var algCurves = map[string]string{"ES256": "P-256", "ES384": "P-384", "ES512": "P-521"}
+
+// jwk is a JSON Web Key, as published in a key set
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+
+	key crypto.PublicKey
+}
+
+// KeySet is the JSON Web Key Set of an identity provider, fetched on first use and cached.
+// The keys are fetched again after their lifetime, or when a token is signed with an unknown key,
+// e.g. after the rotation of the keys of the provider.
+type KeySet struct {
+	URL    string
+	Client *http.Client  // a client with a 10s timeout if nil
+	TTL    time.Duration // lifetime of the keys; one hour if 0
+
+	mu      sync.Mutex
+	keys    []jwk
+	fetched time.Time
+	tried   time.Time
+}
+
+// NewKeySet creates the key set published at a url.
+func NewKeySet(url string) *KeySet {
+	return &KeySet{URL: url}
+}
+
+// Get returns the public key of a key id and an algorithm. If the token has no key id,
+// the key set must hold a single key of the algorithm.
+func (ks *KeySet) Get(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ttl := ks.TTL
+	if ttl == 0 {
+		ttl = defaultKeysTTL
+	}
+	now := time.Now()
+	stale := now.Sub(ks.fetched) > ttl
+	key, found := ks.find(kid, alg)
+	if (stale || !found) && now.Sub(ks.tried) > minRefreshPeriod {
+		ks.tried = now
+		if err := ks.fetch(ctx); err != nil {
+			// stale keys are still used while the provider is unreachable
+			if ks.keys == nil {
+				return nil, err
+			}
+			log.Errorf("Failed to refresh the keys of %s: %v", ks.URL, err)
+		} else {
+			ks.fetched = now
+			key, found = ks.find(kid, alg)
+		}
+	}
+	if ks.keys == nil {
+		return nil, fmt.Errorf("the key set %s is not available", ks.URL)
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: no key %q for the algorithm %s", ErrInvalidToken, kid, alg)
+	}
+	return key, nil
+}
+
+// find returns the key matching a key id and an algorithm
+func (ks *KeySet) find(kid, alg string) (crypto.PublicKey, bool) {
+	var key crypto.PublicKey
+	count := 0
+	for _, k := range ks.keys {
+		if (kid == "" || k.Kid == kid) && k.accepts(alg) {
+			key = k.key
+			count++
+		}
+	}
+	return key, count == 1
+}
+
+// accepts tells if a key can verify the signatures of an algorithm
+func (k *jwk) accepts(alg string) bool {
+	if (k.Alg != "" && k.Alg != alg) || (k.Use != "" && k.Use != "sig") {
+		return false
+	}
+	switch key := k.key.(type) {
+	case *rsa.PublicKey:
+		return len(alg) == 5 && (alg[:2] == "RS" || alg[:2] == "PS")
+	case *ecdsa.PublicKey:
+		return algCurves[alg] != "" && curves[algCurves[alg]] == key.Curve
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	}
+	return false
+}
+
+// fetch gets the keys of the set; keys of an unsupported type are ignored
+func (ks *KeySet) fetch(ctx context.Context) error {
+
+	req, err := http.NewRequestWithContext(ctx, "GET", ks.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	client := ks.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the key set %s returned the status %d", ks.URL, resp.StatusCode)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("invalid key set %s: %w", ks.URL, err)
+	}
+
+	keys := make([]jwk, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.key, err = k.publicKey(); err != nil {
+			log.Warnf("Ignored key %q of %s: %v", k.Kid, ks.URL, err)
+			continue
+		}
+		keys = append(keys, k)
+	}
+	ks.keys = keys
+	return nil
+}
+
+// publicKey decodes the public key of a jwk
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("the point is not on the curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// decodeInt decodes a base64url encoded big-endian integer
+func decodeInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package lic
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/crypto"
+	"github.com/edrlab/lcp-server/pkg/sign"
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+// run "go test ./pkg/lic -update" to regenerate the golden files,
+// only when a change of the generated licenses is intended.
+var update = flag.Bool("update", false, "update the golden files")
+
+const goldenLicense = "../test/golden/license.lcpl"
+
+// TestGoldenLicense generates a license from fixed data and deterministic encrypters,
+// and checks that it is byte-identical to the golden license.
+func TestGoldenLicense(t *testing.T) {
+
+	// replace the encrypters by deterministic ones
+	rnd := rand.New(rand.NewSource(1))
+	deterministic := func() crypto.Encrypter { return crypto.NewAESCBCEncrypterWithRand(rnd) }
+	defer func(ck, kc, f func() crypto.Encrypter) {
+		newContentKeyEncrypter, newUserKeyCheckEncrypter, newFieldsEncrypter = ck, kc, f
+	}(newContentKeyEncrypter, newUserKeyCheckEncrypter, newFieldsEncrypter)
+	newContentKeyEncrypter, newUserKeyCheckEncrypter, newFieldsEncrypter = deterministic, deterministic, deterministic
+
+	cert, err := tls.LoadX509KeyPair(LicHandler.Config.Certificate.Cert, LicHandler.Config.Certificate.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issued := time.Date(2023, time.March, 1, 10, 0, 0, 0, time.UTC)
+	end := issued.AddDate(0, 1, 0)
+	key, _ := hex.DecodeString("0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20")
+
+	pub := stor.Publication{
+		UUID:          "8d9e7c5a-1b2c-4d3e-8f40-5a6b7c8d9e0f",
+		Title:         "Alice's Adventures in Wonderland",
+		EncryptionKey: key,
+		Location:      "https://example.com/files/alice.epub",
+		ContentType:   "application/epub+zip",
+		Size:          123456,
+		Checksum:      "qjDlXaJmCCfCQbOEZTDUjPESH3prqd4s4UGaCPRAwrU=",
+	}
+	licInfo := stor.LicenseInfo{
+		UUID:          "3a5b7c9d-2e4f-4a6b-8c0d-1e2f3a4b5c6d",
+		Provider:      "https://edrlab.org",
+		Start:         &issued,
+		End:           &end,
+		Print:         10,
+		Copy:          -1,
+		PublicationID: pub.UUID,
+	}
+	licInfo.CreatedAt = issued
+	userInfo := UserInfo{
+		ID:        "user-1",
+		Email:     "alice@example.com",
+		Name:      "Alice Liddell",
+		Encrypted: []string{"email", "name"},
+	}
+	encryption := Encryption{
+		Profile: LCP_Basic_Profile,
+		UserKey: UserKey{TextHint: "The name of the cat"},
+	}
+	passhash := "FAEB00CA518BEA7CB11A7EF31FB6183B489B1B6EADB792BEC64A03B3F6FF80A8"
+
+	signer, err := sign.NewSigner(&cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	license, err := NewLicense(LicHandler.Config, signer, &pub, &licInfo, nil, &userInfo, &encryption, passhash)
+	if err != nil {
+		t.Fatalf("Failed to generate the license: %v", err)
+	}
+	got, err := json.MarshalIndent(license, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *update {
+		if err = os.WriteFile(goldenLicense, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(goldenLicense)
+	if err != nil {
+		t.Fatalf("Failed to read the golden license: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("The generated license differs from the golden license:\n%s", got)
+	}
+
+	// the golden license must still be verifiable
+	if err = license.CheckSignature(); err != nil {
+		t.Errorf("Failed to check the signature of the golden license: %v", err)
+	}
+}
@@ -6,16 +6,19 @@ package lic
 
 import (
 	"bytes"
-	"crypto/tls"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"log"
 	"reflect"
-
+	"strings"
+	"text/template"
+	"text/template/parse"
 	"time"
 
 	"github.com/edrlab/lcp-server/pkg/conf"
 	"github.com/edrlab/lcp-server/pkg/crypto"
+	"github.com/edrlab/lcp-server/pkg/pack"
 	"github.com/edrlab/lcp-server/pkg/sign"
 	"github.com/edrlab/lcp-server/pkg/stor"
 	"github.com/jtacoma/uritemplates"
@@ -99,8 +102,17 @@ type UserKey struct {
 
 const SHA256_URI string = "http://www.w3.org/2001/04/xmlenc#sha256"
 
-// NewLicense generates a license from db info, request data and config data
-func NewLicense(config *conf.Config, cert *tls.Certificate, pubInfo *stor.Publication, licInfo *stor.LicenseInfo, userInfo *UserInfo, encryption *Encryption, passhash string) (*License, error) {
+// encrypter constructors; tests replace them by deterministic encrypters
+// when generating test vectors.
+var (
+	newContentKeyEncrypter   = crypto.NewAESEncrypter_CONTENT_KEY
+	newUserKeyCheckEncrypter = crypto.NewAESEncrypter_USER_KEY_CHECK
+	newFieldsEncrypter       = crypto.NewAESEncrypter_FIELDS
+)
+
+// NewLicense generates a license from db info, request data and config data.
+// The policy links of the provider are set in the license, if it declared any.
+func NewLicense(config *conf.Config, signer sign.Signer, pubInfo *stor.Publication, licInfo *stor.LicenseInfo, policy *stor.Provider, userInfo *UserInfo, encryption *Encryption, passhash string) (*License, error) {
 
 	l := &License{
 		UUID:     licInfo.UUID,
@@ -111,13 +123,18 @@ func NewLicense(config *conf.Config, cert *tls.Certificate, pubInfo *stor.Public
 
 	log.Printf("License %s generated on %s", l.UUID, l.Issued.Format(time.RFC822))
 
-	userKey, err := setEncryption(config.Profile, l, pubInfo, encryption, passhash)
+	userKey, err := setEncryption(config.License.Profile, l, pubInfo, encryption, passhash)
 	if err != nil {
 		return nil, err
 	}
 
 	// links
-	setLinks(config.PublicBaseUrl, config.License.HintLink, l, pubInfo)
+	links := NewLinkBuilder(config, licInfo.Provider)
+	links.Policy = policy
+	err = setLinks(links, l, pubInfo, licInfo)
+	if err != nil {
+		return nil, err
+	}
 
 	// user
 	err = setUser(l, userInfo, userKey)
@@ -132,7 +149,7 @@ func NewLicense(config *conf.Config, cert *tls.Certificate, pubInfo *stor.Public
 	}
 
 	// signature
-	err = setSignature(l, cert)
+	err = setSignature(l, signer)
 	if err != nil {
 		return nil, err
 	}
@@ -158,13 +175,13 @@ func setEncryption(profile string, l *License, pub *stor.Publication, encryption
 	}
 
 	// encrypt the content key with the user key
-	contentKeyEncrypter := crypto.NewAESEncrypter_CONTENT_KEY()
+	contentKeyEncrypter := newContentKeyEncrypter()
 	encryption.ContentKey.Algorithm = contentKeyEncrypter.Signature()
 	encryption.ContentKey.Value = encryptKey(contentKeyEncrypter, pub.EncryptionKey, userKey[:])
 
 	// build the key check
 	encryption.UserKey.Algorithm = SHA256_URI
-	userKeyCheckEncrypter := crypto.NewAESEncrypter_USER_KEY_CHECK()
+	userKeyCheckEncrypter := newUserKeyCheckEncrypter()
 	encryption.UserKey.Keycheck, err = buildKeyCheck(l.UUID, userKeyCheckEncrypter, userKey[:])
 	if err != nil {
 		return nil, err
@@ -194,15 +211,16 @@ func buildKeyCheck(licenseID string, encrypter crypto.Encrypter, key []byte) ([]
 }
 
 // setLinks sets the links structure in the license
-func setLinks(publicBaseUrl string, hintTemplate string, l *License, pub *stor.Publication) {
+func setLinks(links *LinkBuilder, l *License, pub *stor.Publication, licInfo *stor.LicenseInfo) error {
 
-	// set the publication link
+	// set the publication link; the media type is the one of the protected publication,
+	// even if the publication was registered with the media type of its source
 	pubLink := Link{
 		Rel:      "publication",
-		Href:     pub.Location,
-		Type:     pub.ContentType,
+		Href:     links.Publication(pub),
+		Type:     pack.ProtectedContentType(pub.ContentType),
 		Title:    pub.Title,
-		Size:     int64(pub.Size),
+		Size:     pub.Size,
 		Checksum: pub.Checksum,
 	}
 	l.Links = append(l.Links, pubLink)
@@ -210,35 +228,100 @@ func setLinks(publicBaseUrl string, hintTemplate string, l *License, pub *stor.P
 	// set the status link
 	statusLink := Link{
 		Rel:  "status",
-		Href: publicBaseUrl + "/status/" + l.UUID,
+		Href: links.Status(l.UUID),
 		Type: ContentType_LSD_JSON,
 	}
 	l.Links = append(l.Links, statusLink)
 
-	// expand the link template
-	template, _ := uritemplates.Parse(hintTemplate)
-	values := make(map[string]interface{})
-	values["license_id"] = l.UUID
-	expanded, err := template.Expand(values)
+	// expand the hint link template
+	expanded, err := links.Hint(licInfo)
 	if err != nil {
-		log.Printf("failed to expand the hint link: %s", template)
+		log.Printf("failed to expand the hint link: %s", links.HintTemplate)
+		return err
 	}
 
-	// set the hint link
-	hintLink := Link{
-		Rel:  "hint",
-		Href: expanded,
-		Type: ContentType_TEXT_HTML,
+	// set the hint link, unless there is no template
+	if expanded != "" {
+		hintLink := Link{
+			Rel:  "hint",
+			Href: expanded,
+			Type: ContentType_TEXT_HTML,
+		}
+		l.Links = append(l.Links, hintLink)
 	}
-	l.Links = append(l.Links, hintLink)
 
+	// set the policy links of the provider and the link to the self-service page, if any
+	l.Links = append(l.Links, links.Policies(l.UUID)...)
+	return nil
+}
+
+// expandHintLink renders a hint link template for a given license.
+// Two syntaxes are supported: Go templates using the license info as data,
+// e.g. "https://publisher.example/hint?lic={{.UUID}}",
+// and URI templates using license_id as parameter, e.g. "https://publisher.example/hint/{license_id}".
+// The values substituted are URL-escaped. Without template, the hint link is empty and omitted from the license.
+func expandHintLink(hintTemplate string, licInfo *stor.LicenseInfo) (string, error) {
+
+	if hintTemplate == "" {
+		return "", nil
+	}
+
+	if strings.Contains(hintTemplate, "{{") {
+		tpl, err := template.New("hint").Option("missingkey=error").Parse(hintTemplate)
+		if err != nil {
+			return "", err
+		}
+		escapeActions(tpl.Tree, tpl.Tree.Root)
+		var buf bytes.Buffer
+		if err = tpl.Execute(&buf, licInfo); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+
+	tpl, err := uritemplates.Parse(hintTemplate)
+	if err != nil {
+		return "", err
+	}
+	values := make(map[string]interface{})
+	values["license_id"] = licInfo.UUID
+	return tpl.Expand(values)
+}
+
+// escapeActions pipes the value printed by each action of a template tree to urlquery
+func escapeActions(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			escapeActions(tree, child)
+		}
+	case *parse.ActionNode:
+		// a variable declaration prints nothing
+		if len(n.Pipe.Decl) == 0 {
+			cmd := &parse.CommandNode{NodeType: parse.NodeCommand, Pos: n.Pos}
+			cmd.Args = []parse.Node{parse.NewIdentifier("urlquery").SetTree(tree).SetPos(n.Pos)}
+			n.Pipe.Cmds = append(n.Pipe.Cmds, cmd)
+		}
+	case *parse.IfNode:
+		escapeActions(tree, n.List)
+		escapeActions(tree, n.ElseList)
+	case *parse.RangeNode:
+		escapeActions(tree, n.List)
+		escapeActions(tree, n.ElseList)
+	case *parse.WithNode:
+		escapeActions(tree, n.List)
+		escapeActions(tree, n.ElseList)
+	}
 }
 
 // setUser sets the user structure in the license
 func setUser(l *License, userInfo *UserInfo, userKey []byte) error {
 
 	// encrypt user info fields
-	fieldsEncrypter := crypto.NewAESEncrypter_FIELDS()
+	fieldsEncrypter := newFieldsEncrypter()
 	err := encryptFields(fieldsEncrypter, userInfo, userKey[:])
 	if err != nil {
 		return err
@@ -248,17 +331,38 @@ func setUser(l *License, userInfo *UserInfo, userKey []byte) error {
 	return nil
 }
 
+// EncryptableFields lists the user fields which may be encrypted in a license.
+// The user identifier must stay in clear.
+var EncryptableFields = map[string]bool{
+	"email": true,
+	"name":  true,
+}
+
 func encryptFields(encrypter crypto.Encrypter, userInfo *UserInfo, key []byte) error {
 
+	// check the list of fields before encrypting any of them
+	for _, toEncrypt := range userInfo.Encrypted {
+		if !EncryptableFields[toEncrypt] {
+			return fmt.Errorf("the user field %q cannot be encrypted", toEncrypt)
+		}
+	}
+
+	// empty fields are left out
+	var encrypted []string
 	for _, toEncrypt := range userInfo.Encrypted {
 		var out bytes.Buffer
 		field := getField(userInfo, toEncrypt)
+		if field.String() == "" {
+			continue
+		}
 		err := encrypter.Encrypt(key[:], bytes.NewBufferString(field.String()), &out)
 		if err != nil {
 			return err
 		}
 		field.Set(reflect.ValueOf(base64.StdEncoding.EncodeToString(out.Bytes())))
+		encrypted = append(encrypted, toEncrypt)
 	}
+	userInfo.Encrypted = encrypted
 	return nil
 }
 
@@ -284,16 +388,12 @@ func setRights(l *License, licInfo *stor.LicenseInfo) error {
 }
 
 // setSignature sets the signature of the license
-func setSignature(l *License, cert *tls.Certificate) error {
+func setSignature(l *License, signer sign.Signer) error {
 
-	if cert == nil {
-		return errors.New("failed to sign the license, cert not set")
-	}
-	sig, err := sign.NewSigner(cert)
-	if err != nil {
-		return err
+	if signer == nil {
+		return errors.New("failed to sign the license, signer not set")
 	}
-	res, err := sig.Sign(l)
+	res, err := signer.Sign(l)
 	if err != nil {
 		return err
 	}
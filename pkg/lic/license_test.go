@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/sign"
 	"github.com/edrlab/lcp-server/pkg/stor"
 	"github.com/google/uuid"
 	"syreclabs.com/go/faker"
@@ -54,7 +55,7 @@ func TestMain(m *testing.M) {
 	rand.Read(Pub.EncryptionKey)
 	Pub.Location = faker.Internet().Url()
 	Pub.ContentType = "application/epub+zip"
-	Pub.Size = uint32(faker.Number().NumberInt(5))
+	Pub.Size = int64(faker.Number().NumberInt(5))
 	Pub.Checksum = faker.Lorem().Characters(16)
 
 	// store the publication in the db
@@ -108,7 +109,11 @@ func TestLicense(t *testing.T) {
 
 	passhash := "FAEB00CA518BEA7CB11A7EF31FB6183B489B1B6EADB792BEC64A03B3F6FF80A8"
 
-	license, err := NewLicense(LicHandler.Config, &cert, &Pub, &LicInfo, &userInfo, &encryption, passhash)
+	signer, err := sign.NewSigner(&cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	license, err := NewLicense(LicHandler.Config, signer, &Pub, &LicInfo, nil, &userInfo, &encryption, passhash)
 
 	if err != nil {
 		t.Log(err)
@@ -131,3 +136,101 @@ func TestLicense(t *testing.T) {
 	*/
 
 }
+
+func TestLicenseWithoutHintLink(t *testing.T) {
+
+	cert, err := tls.LoadX509KeyPair(LicHandler.Config.Certificate.Cert, LicHandler.Config.Certificate.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := sign.NewSigner(&cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a license is issued without hint link template
+	config := setConfig()
+	config.License.HintLink = ""
+	userInfo := UserInfo{ID: uuid.New().String()}
+	encryption := Encryption{Profile: LCP_Basic_Profile, UserKey: UserKey{TextHint: "A textual hint for your passphrase."}}
+	passhash := "FAEB00CA518BEA7CB11A7EF31FB6183B489B1B6EADB792BEC64A03B3F6FF80A8"
+	license, err := NewLicense(config, signer, &Pub, &LicInfo, nil, &userInfo, &encryption, passhash)
+	if err != nil {
+		t.Fatalf("Failed to generate a license without hint link: %v", err)
+	}
+	for _, link := range license.Links {
+		if link.Rel == "hint" {
+			t.Errorf("Expected no hint link, got %q", link.Href)
+		}
+	}
+}
+
+func TestEncryptFields(t *testing.T) {
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	encrypter := newFieldsEncrypter()
+
+	// the user id cannot be encrypted
+	userInfo := UserInfo{ID: "id", Email: "john@doe.com", Encrypted: []string{"email", "id"}}
+	if err := encryptFields(encrypter, &userInfo, key); err == nil {
+		t.Error("The encryption of the user id must be disallowed")
+	}
+	if userInfo.Email != "john@doe.com" {
+		t.Error("No field should be encrypted if the list of fields is invalid")
+	}
+
+	// empty fields are not encrypted
+	userInfo = UserInfo{ID: "id", Email: "john@doe.com", Encrypted: []string{"email", "name"}}
+	if err := encryptFields(encrypter, &userInfo, key); err != nil {
+		t.Fatal(err)
+	}
+	if userInfo.Email == "john@doe.com" || userInfo.Name != "" {
+		t.Error("Failed to encrypt the email only")
+	}
+	if len(userInfo.Encrypted) != 1 || userInfo.Encrypted[0] != "email" {
+		t.Errorf("Invalid list of encrypted fields: %v", userInfo.Encrypted)
+	}
+}
+
+func TestExpandHintLink(t *testing.T) {
+
+	licInfo := &stor.LicenseInfo{UUID: "123", Provider: "https://publisher.example", UserID: "a&b=c"}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"https://publisher.example/hint?lic={{.UUID}}", "https://publisher.example/hint?lic=123"},
+		{"https://lcp.example/hint/{license_id}", "https://lcp.example/hint/123"},
+		{"https://lcp.example/hint", "https://lcp.example/hint"},
+		{"https://publisher.example/hint?user={{.UserID}}{{if .UUID}}&lic={{.UUID}}{{end}}", "https://publisher.example/hint?user=a%26b%3Dc&lic=123"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := expandHintLink(tt.template, licInfo)
+		if err != nil {
+			t.Errorf("Failed to expand %s: %v", tt.template, err)
+		} else if got != tt.want {
+			t.Errorf("Expanded %s as %s, expected %s", tt.template, got, tt.want)
+		}
+	}
+
+	for _, template := range []string{"https://publisher.example/hint?lic={{.Unknown}}"} {
+		if _, err := expandHintLink(template, licInfo); err == nil {
+			t.Errorf("Invalid template %q accepted", template)
+		}
+	}
+
+	// hint link templates per provider
+	config := conf.License{
+		HintLink:  "https://lcp.example/hint/{license_id}",
+		HintLinks: map[string]string{"https://publisher.example": "https://publisher.example/hint?lic={{.UUID}}"},
+	}
+	if config.HintLinkTemplate("https://publisher.example") != config.HintLinks["https://publisher.example"] {
+		t.Error("Failed to get the hint link template of a provider")
+	}
+	if config.HintLinkTemplate("https://other.example") != config.HintLink {
+		t.Error("Failed to get the default hint link template")
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package lic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/jtacoma/uritemplates"
+)
+
+// LinkBuilder generates every absolute url set in licenses, status documents and manifests.
+// Urls never depend on the Host header of the incoming request, which is rewritten by CDNs.
+type LinkBuilder struct {
+	BaseURL        string         // public base url of the server, for the provider
+	HintTemplate   string         // hint link template, for the provider
+	RenewLink      string         // renew url managed by the provider, if any
+	LicenseLink    string         // fresh license url managed by the provider, if any
+	SelfServiceKey string         // secret signing the links to the self-service page, if enabled
+	Policy         *stor.Provider // policy links declared by the provider, if any
+}
+
+// NewLinkBuilder returns the link builder applicable to a provider.
+// The public base url declared for the provider takes precedence over the server base url.
+func NewLinkBuilder(c *conf.Config, provider string) *LinkBuilder {
+	return &LinkBuilder{
+		BaseURL:        strings.TrimSuffix(c.License.PublicBaseURL(provider, c.PublicBaseUrl), "/"),
+		HintTemplate:   c.License.HintLinkTemplate(provider),
+		RenewLink:      c.Status.RenewLink,
+		LicenseLink:    c.Status.LicenseLink,
+		SelfServiceKey: c.SelfService.Secret,
+	}
+}
+
+// Status returns the url of the status document of a license.
+func (b *LinkBuilder) Status(licenseID string) string {
+	return b.BaseURL + "/status/" + licenseID
+}
+
+// Register returns the templated url used by devices for registering a license.
+func (b *LinkBuilder) Register(licenseID string) string {
+	return b.BaseURL + "/register/" + licenseID + "{?id,name}"
+}
+
+// Renew returns the templated url used by devices for renewing a license.
+// The provider can manage his own renew url and take care of calling the license status server.
+func (b *LinkBuilder) Renew(licenseID string) string {
+	if b.RenewLink != "" {
+		return b.RenewLink + "{?end,id,name}"
+	}
+	return b.BaseURL + "/renew/" + licenseID + "{?end,id,name}"
+}
+
+// Return returns the templated url used by devices for returning a license.
+func (b *LinkBuilder) Return(licenseID string) string {
+	return b.BaseURL + "/return/" + licenseID + "{?id,name}"
+}
+
+// License returns the url of the fresh license managed by the provider, or an empty string.
+func (b *LinkBuilder) License(licenseID string) (string, error) {
+	if b.LicenseLink == "" {
+		return "", nil
+	}
+	tpl, err := uritemplates.Parse(b.LicenseLink)
+	if err != nil {
+		return "", err
+	}
+	return tpl.Expand(map[string]interface{}{"license_id": licenseID})
+}
+
+// Hint returns the url of the hint page of a license.
+func (b *LinkBuilder) Hint(licInfo *stor.LicenseInfo) (string, error) {
+	return expandHintLink(b.HintTemplate, licInfo)
+}
+
+// SelfService returns the signed url of the self-service page of a license, or an empty string if the page is disabled.
+func (b *LinkBuilder) SelfService(licenseID string) string {
+	if b.SelfServiceKey == "" {
+		return ""
+	}
+	return b.BaseURL + "/self-service/" + licenseID + "?token=" + SelfServiceToken(b.SelfServiceKey, licenseID)
+}
+
+// Policies returns the terms of use, privacy policy and support links declared by the provider.
+// The support page of the provider takes precedence over the self-service page.
+func (b *LinkBuilder) Policies(licenseID string) []Link {
+	var links []Link
+	if b.Policy != nil && b.Policy.TermsLink != "" {
+		links = append(links, Link{Rel: "terms-of-service", Href: b.Policy.TermsLink, Type: ContentType_TEXT_HTML})
+	}
+	if b.Policy != nil && b.Policy.PrivacyLink != "" {
+		links = append(links, Link{Rel: "privacy-policy", Href: b.Policy.PrivacyLink, Type: ContentType_TEXT_HTML})
+	}
+	if b.Policy != nil && b.Policy.SupportLink != "" {
+		links = append(links, Link{Rel: "support", Href: b.Policy.SupportLink, Type: ContentType_TEXT_HTML})
+	} else if href := b.SelfService(licenseID); href != "" {
+		links = append(links, Link{Rel: "support", Href: href, Type: ContentType_TEXT_HTML})
+	}
+	return links
+}
+
+// ProviderPolicy returns the policy links declared by a provider, or nil if it declared none.
+func ProviderPolicy(st stor.Store, provider string) *stor.Provider {
+	policy, err := st.Provider().GetByURI(provider)
+	if err != nil {
+		return nil
+	}
+	return policy
+}
+
+// Publication returns the url of a protected publication.
+func (b *LinkBuilder) Publication(pub *stor.Publication) string {
+	return pub.Location
+}
+
+// Content returns the url of a resource of a multi-part publication.
+func (b *LinkBuilder) Content(publicationID string, position int) string {
+	return fmt.Sprintf("%s/content/%s/%d", b.BaseURL, publicationID, position)
+}
+
+// Manifest returns the url of the manifest of a multi-part publication.
+func (b *LinkBuilder) Manifest(publicationID string) string {
+	return b.BaseURL + "/content/" + publicationID + "/manifest"
+}
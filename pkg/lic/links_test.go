@@ -0,0 +1,82 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package lic
+
+import (
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+func TestLinkBuilder(t *testing.T) {
+
+	config := &conf.Config{
+		PublicBaseUrl: "https://lcp.example/",
+		License: conf.License{
+			HintLink:  "https://lcp.example/hint/{license_id}",
+			HintLinks: map[string]string{"https://publisher.example": "https://publisher.example/hint?lic={{.UUID}}"},
+			BaseUrls:  map[string]string{"https://publisher.example": "https://drm.publisher.example"},
+		},
+		Status: conf.Status{LicenseLink: "https://publisher.example/licenses/{license_id}"},
+	}
+	licInfo := &stor.LicenseInfo{UUID: "1234"}
+
+	// default provider
+	links := NewLinkBuilder(config, "https://other.example")
+	if got := links.Status("1234"); got != "https://lcp.example/status/1234" {
+		t.Errorf("Invalid status link %s", got)
+	}
+	if got := links.Renew("1234"); got != "https://lcp.example/renew/1234{?end,id,name}" {
+		t.Errorf("Invalid renew link %s", got)
+	}
+	if got, _ := links.Hint(licInfo); got != "https://lcp.example/hint/1234" {
+		t.Errorf("Invalid hint link %s", got)
+	}
+
+	// the base url and hint link of a provider take precedence
+	links = NewLinkBuilder(config, "https://publisher.example")
+	if got := links.Register("1234"); got != "https://drm.publisher.example/register/1234{?id,name}" {
+		t.Errorf("Invalid register link %s", got)
+	}
+	if got := links.Content("abcd", 2); got != "https://drm.publisher.example/content/abcd/2" {
+		t.Errorf("Invalid content link %s", got)
+	}
+	if got, _ := links.Hint(licInfo); got != "https://publisher.example/hint?lic=1234" {
+		t.Errorf("Invalid hint link %s", got)
+	}
+	if got, _ := links.License("1234"); got != "https://publisher.example/licenses/1234" {
+		t.Errorf("Invalid license link %s", got)
+	}
+
+	// a renew url managed by the provider
+	config.Status.RenewLink = "https://publisher.example/renew"
+	links = NewLinkBuilder(config, "https://publisher.example")
+	if got := links.Renew("1234"); got != "https://publisher.example/renew{?end,id,name}" {
+		t.Errorf("Invalid renew link %s", got)
+	}
+
+	// the self-service page is signed, if enabled
+	if got := links.SelfService("1234"); got != "" {
+		t.Errorf("Unexpected self-service link %s", got)
+	}
+	config.SelfService.Secret = "a self-service secret"
+	links = NewLinkBuilder(config, "https://publisher.example")
+	token := SelfServiceToken(config.SelfService.Secret, "1234")
+	if got := links.SelfService("1234"); got != "https://drm.publisher.example/self-service/1234?token="+token {
+		t.Errorf("Invalid self-service link %s", got)
+	}
+	if !ValidSelfServiceToken(config.SelfService.Secret, "1234", token) || ValidSelfServiceToken(config.SelfService.Secret, "1235", token) ||
+		ValidSelfServiceToken("", "1234", token) {
+		t.Error("Unexpected self-service token validation")
+	}
+
+	// the support page of the provider takes precedence over the self-service page
+	links.Policy = &stor.Provider{TermsLink: "https://publisher.example/terms", SupportLink: "https://publisher.example/support"}
+	policies := links.Policies("1234")
+	if len(policies) != 2 || policies[0].Rel != "terms-of-service" || policies[1].Rel != "support" || policies[1].Href != "https://publisher.example/support" {
+		t.Errorf("Invalid policy links %+v", policies)
+	}
+}
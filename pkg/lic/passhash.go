@@ -0,0 +1,76 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package lic
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Passphrase hashing schemes a CMS can declare.
+// SHA256 is the scheme defined by the LCP basic profile; the KDF schemes are only
+// usable in internal flows, with reading software which derives the user key the same way.
+const (
+	PassHash_SHA256   = "sha256"
+	PassHash_Argon2id = "argon2id"
+	PassHash_Scrypt   = "scrypt"
+)
+
+// user keys are AES-256 keys
+const userKeyLength = 32
+
+// PHC string formats, see https://github.com/P-H-C/phc-string-format
+var passHashFormats = map[string]*regexp.Regexp{
+	PassHash_SHA256:   regexp.MustCompile(`^[0-9a-fA-F]{64}$`),
+	PassHash_Argon2id: regexp.MustCompile(`^\$argon2id\$v=19\$m=\d+,t=\d+,p=\d+\$[A-Za-z0-9+/]+\$[A-Za-z0-9+/]+$`),
+	PassHash_Scrypt:   regexp.MustCompile(`^\$scrypt\$ln=\d+,r=\d+,p=\d+\$[A-Za-z0-9+/]+\$[A-Za-z0-9+/]+$`),
+}
+
+// ValidatePassHash checks that a hashed passphrase matches the format of a hashing scheme.
+// An empty scheme is the sha256 scheme.
+func ValidatePassHash(scheme, passhash string) error {
+	if scheme == "" {
+		scheme = PassHash_SHA256
+	}
+	format, ok := passHashFormats[scheme]
+	if !ok {
+		return fmt.Errorf("unknown passphrase hashing scheme %q", scheme)
+	}
+	if !format.MatchString(passhash) {
+		return fmt.Errorf("the passphrase hash does not match the %s scheme", scheme)
+	}
+	_, err := decodePassHash(passhash)
+	return err
+}
+
+// IsPassHashScheme indicates if a hashing scheme is supported.
+func IsPassHashScheme(scheme string) bool {
+	_, ok := passHashFormats[scheme]
+	return ok
+}
+
+// decodePassHash returns the user key held by a hashed passphrase:
+// a hex encoded sha256 value, or the hash part of a PHC string.
+func decodePassHash(passhash string) ([]byte, error) {
+	var value []byte
+	var err error
+	if strings.HasPrefix(passhash, "$") {
+		parts := strings.Split(passhash, "$")
+		value, err = base64.RawStdEncoding.DecodeString(parts[len(parts)-1])
+	} else {
+		value, err = hex.DecodeString(passhash)
+	}
+	if err != nil {
+		return nil, errors.New("failed to decode the user passphrase")
+	}
+	if len(value) != userKeyLength {
+		return nil, fmt.Errorf("invalid user key length %d, expected %d", len(value), userKeyLength)
+	}
+	return value, nil
+}
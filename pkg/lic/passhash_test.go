@@ -0,0 +1,57 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package lic
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestValidatePassHash(t *testing.T) {
+
+	const (
+		sha     = "FAEB00CA518BEA7CB11A7EF31FB6183B489B1B6EADB792BEC64A03B3F6FF80A8"
+		argon2  = "$argon2id$v=19$m=65536,t=3,p=4$c29tZXNhbHRzb21lc2FsdA$AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8"
+		scrypt  = "$scrypt$ln=15,r=8,p=1$c29tZXNhbHRzb21lc2FsdA$AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8"
+		short   = "$argon2id$v=19$m=65536,t=3,p=4$c29tZXNhbHRzb21lc2FsdA$AAECAwQFBgcICQoLDA0ODw"
+		invalid = "1234"
+	)
+
+	cases := []struct {
+		scheme   string
+		passhash string
+		valid    bool
+	}{
+		{"", sha, true},
+		{PassHash_SHA256, sha, true},
+		{PassHash_SHA256, argon2, false},
+		{PassHash_SHA256, invalid, false},
+		{PassHash_Argon2id, argon2, true},
+		{PassHash_Argon2id, sha, false},
+		{PassHash_Argon2id, scrypt, false},
+		{PassHash_Argon2id, short, false}, // 16 bytes is not a valid user key
+		{PassHash_Scrypt, scrypt, true},
+		{"bcrypt", sha, false},
+	}
+	for _, c := range cases {
+		err := ValidatePassHash(c.scheme, c.passhash)
+		if (err == nil) != c.valid {
+			t.Errorf("ValidatePassHash(%q, %q): got %v", c.scheme, c.passhash, err)
+		}
+	}
+
+	// the user key is the derived key held by the PHC string
+	key, err := GenerateUserKey(LCP_Basic_Profile, argon2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, 32)
+	for i := range want {
+		want[i] = byte(i)
+	}
+	if !bytes.Equal(key, want) {
+		t.Errorf("Unexpected user key %x", key)
+	}
+}
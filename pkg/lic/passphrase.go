@@ -0,0 +1,42 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package lic
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"strings"
+)
+
+// passphrase alphabet, without easily confused characters (0/O, 1/I/L)
+const passphraseAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+// GeneratePassphrase returns a random passphrase made of 4 groups of 4 characters,
+// e.g. "7KQM-3XPA-Z9TD-HW4R" (about 79 bits of entropy), and its hash.
+func GeneratePassphrase() (passphrase, passhash string, err error) {
+
+	var sb strings.Builder
+	max := big.NewInt(int64(len(passphraseAlphabet)))
+	for i := 0; i < 16; i++ {
+		if i > 0 && i%4 == 0 {
+			sb.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", "", err
+		}
+		sb.WriteByte(passphraseAlphabet[n.Int64()])
+	}
+	passphrase = sb.String()
+	return passphrase, HashPassphrase(passphrase), nil
+}
+
+// HashPassphrase returns the hex encoded hash of a passphrase, as defined by the LCP basic profile.
+func HashPassphrase(passphrase string) string {
+	hash := sha256.Sum256([]byte(passphrase))
+	return hex.EncodeToString(hash[:])
+}
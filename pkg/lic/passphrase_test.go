@@ -0,0 +1,33 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package lic
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGeneratePassphrase(t *testing.T) {
+
+	passphrase, passhash, err := GeneratePassphrase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^([2-9A-HJKMNP-Z]{4}-){3}[2-9A-HJKMNP-Z]{4}$`).MatchString(passphrase) {
+		t.Errorf("Unexpected passphrase format %q", passphrase)
+	}
+	if passhash != HashPassphrase(passphrase) {
+		t.Error("Invalid passphrase hash")
+	}
+	other, _, _ := GeneratePassphrase()
+	if other == passphrase {
+		t.Error("Two generated passphrases should differ")
+	}
+
+	// sha256 of "test"
+	if HashPassphrase("test") != "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" {
+		t.Error("Invalid hash of the passphrase")
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package lic
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// SelfServiceToken returns the token authorizing the user of a license to access its self-service page:
+// a truncated HMAC-SHA256 of the license identifier, keyed by the self-service secret.
+func SelfServiceToken(secret, licenseID string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(licenseID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// ValidSelfServiceToken tells if a token authorizes the access to the self-service page of a license.
+func ValidSelfServiceToken(secret, licenseID, token string) bool {
+	if secret == "" {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(SelfServiceToken(secret, licenseID)))
+}
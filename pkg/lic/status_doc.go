@@ -6,6 +6,7 @@ package lic
 
 import (
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/edrlab/lcp-server/pkg/conf"
@@ -23,6 +24,7 @@ type (
 		Links           []Link           `json:"links"`
 		PotentialRights *PotentialRights `json:"potential_rights,omitempty"`
 		Events          []stor.Event     `json:"events,omitempty"`
+		Provider        string           `json:"-"` // provider of the license
 	}
 
 	Updated struct {
@@ -45,18 +47,42 @@ type (
 	LicenseHandler struct {
 		*conf.Config // TODO: change for an interface (dependency)
 		stor.Store
+		Clock func() time.Time // returns the current time; time.Now if nil
 	}
 
 	DeviceInfo struct {
 		ID   string
 		Name string
 	}
+
+	// Revocation gives the reason of a revocation, displayed to the user, and who requested it.
+	Revocation struct {
+		Reason string
+		Actor  string
+	}
+
+	// Void gives the reason of a void, displayed to the user, who requested it, and the refunded order.
+	Void struct {
+		Reason   string
+		Actor    string
+		OrderRef string
+	}
 )
 
+// ErrLicenseNotFound is returned when the license of a status operation does not exist.
+var ErrLicenseNotFound = errors.New("failed to get license info")
+
+// ErrRenewRejected is returned when a license would be extended beyond its potential end.
+var ErrRenewRejected = errors.New("the license cannot be extended beyond its potential end")
+
+// ErrVoidRejected is returned when a license cannot be voided, e.g. after its refund window.
+var ErrVoidRejected = errors.New("the license cannot be voided")
+
 func NewLicenseHandler(cf *conf.Config, st stor.Store) *LicenseHandler {
 	return &LicenseHandler{
 		Config: cf,
 		Store:  st,
+		Clock:  time.Now,
 	}
 }
 
@@ -78,20 +104,27 @@ func (lh *LicenseHandler) NewStatusDoc(license *stor.LicenseInfo) *StatusDoc {
 		statUpdated = licUpdated
 	}
 
+	// a license being revoked is already revoked for reading systems
+	status := license.Status
+	if status == stor.STATUS_REVOKING {
+		status = stor.STATUS_REVOKED
+	}
+
 	// set the status document
 	statusDoc := &StatusDoc{
 		ID:      license.UUID,
-		Status:  license.Status,
-		Message: "The license is in " + license.Status + " state", // TODO: flexible, localize
+		Status:  status,
+		Message: statusMessage(status), // TODO: flexible, localize
 		Updated: Updated{
 			License: licUpdated,
 			Status:  statUpdated,
 		},
+		Provider: license.Provider,
 	}
 
-	// check if the license has expired
-	now := time.Now().Truncate(time.Second)
-	if (license.Status == stor.STATUS_READY || license.Status == stor.STATUS_ACTIVE) && now.After(*license.End) {
+	// check if the license has expired; a license without end date never expires
+	now := stor.Now(lh.Clock)
+	if (license.Status == stor.STATUS_READY || license.Status == stor.STATUS_ACTIVE) && license.End != nil && now.After(*license.End) {
 		statusDoc.Status = stor.STATUS_EXPIRED
 		statusDoc.Message = "The license has expired on " + license.End.Format(time.RFC822)
 	}
@@ -110,37 +143,50 @@ func (lh *LicenseHandler) NewStatusDoc(license *stor.LicenseInfo) *StatusDoc {
 	}
 
 	// set links
-	setStatusLinks(lh.Config.PublicBaseUrl, lh.Config.Status.RenewLink, statusDoc)
+	links := NewLinkBuilder(lh.Config, license.Provider)
+	links.Policy = ProviderPolicy(lh.Store, license.Provider)
+	setStatusLinks(links, statusDoc)
 
 	// set events
 	setEvents(lh.Store, statusDoc)
 
+	// the reason of a revocation is given to the user
+	if status == stor.STATUS_REVOKED || status == stor.STATUS_CANCELLED {
+		for i := len(statusDoc.Events) - 1; i >= 0; i-- {
+			event := statusDoc.Events[i]
+			if event.Type == stor.EVENT_REVOKE || event.Type == stor.EVENT_CANCEL {
+				if event.Reason != "" {
+					statusDoc.Message = "The license has been " + status + ": " + event.Reason
+				}
+				break
+			}
+		}
+	}
+
 	return statusDoc
 }
 
 // Set status links
-func setStatusLinks(publicBaseUrl string, renewLink string, statusDoc *StatusDoc) error {
-	var links []Link
-	actions := [3]string{"register", "renew", "return"}
-
-	for _, action := range actions {
-		var href string
-		//the provider can manage his own renew URL and take care of calling the license status server
-		if action == "renew" {
-			if renewLink != "" {
-				href = renewLink + "{?end,id,name}"
-			} else {
-				href = publicBaseUrl + "/" + action + "/" + statusDoc.ID + "{?end,id,name}"
-			}
-		} else {
-			href = publicBaseUrl + "/" + action + "/" + statusDoc.ID + "{?id,name}"
-		}
-		link := Link{Href: href, Rel: action, Type: ContentType_LSD_JSON, Templated: true}
-		links = append(links, link)
+func setStatusLinks(links *LinkBuilder, statusDoc *StatusDoc) error {
+
+	// the fresh license is served by the provider, if he declared its url
+	licenseLink, err := links.License(statusDoc.ID)
+	if err != nil {
+		return err
+	}
+	if licenseLink != "" {
+		statusDoc.Links = append(statusDoc.Links, Link{Href: licenseLink, Rel: "license", Type: ContentType_LCP_JSON})
 	}
 
-	// add the structure to the status document
-	statusDoc.Links = links
+	statusDoc.Links = append(statusDoc.Links,
+		Link{Href: links.Register(statusDoc.ID), Rel: "register", Type: ContentType_LSD_JSON, Templated: true},
+		Link{Href: links.Renew(statusDoc.ID), Rel: "renew", Type: ContentType_LSD_JSON, Templated: true},
+		Link{Href: links.Return(statusDoc.ID), Rel: "return", Type: ContentType_LSD_JSON, Templated: true},
+	)
+	// the policy links of the provider are set in status documents too, which are fetched at every opening
+	if links.Policy != nil {
+		statusDoc.Links = append(statusDoc.Links, links.Policies(statusDoc.ID)...)
+	}
 	return nil
 }
 
@@ -156,12 +202,13 @@ func setEvents(store stor.Store, statusDoc *StatusDoc) error {
 }
 
 // Register records that a new device is using a license
+// A device already registered with the license gets a status document, but is not counted again.
 func (lh *LicenseHandler) Register(licenseID string, device *DeviceInfo) (*StatusDoc, error) {
 
 	// Get license info
 	license, err := lh.Store.License().Get(licenseID)
 	if err != nil {
-		return nil, errors.New("failed to get license info")
+		return nil, ErrLicenseNotFound
 	}
 
 	// check that the license is in ready or active status
@@ -169,7 +216,8 @@ func (lh *LicenseHandler) Register(licenseID string, device *DeviceInfo) (*Statu
 		return nil, errors.New("registering a device on an license that is neither ready nor active is not allowed")
 	}
 
-	// check that the device has not already been registered for this license
+	// check that the device has not already been registered for this license;
+	// devices registered before the device table are known by their register event
 	_, err = lh.Store.Event().GetByDevice(license.UUID, device.ID)
 	if err == nil {
 		log.Warningf("Failed to register; the device %s is already registered", device.ID)
@@ -177,14 +225,34 @@ func (lh *LicenseHandler) Register(licenseID string, device *DeviceInfo) (*Statu
 		return statusDoc, nil
 	}
 
+	// record the device, which increments the device count of the license
+	now := stor.Now(lh.Clock)
+	err = lh.Store.Device().Register(&stor.Device{
+		LicenseID:  license.UUID,
+		DeviceID:   device.ID,
+		Name:       device.Name,
+		Registered: now,
+	})
+	if errors.Is(err, stor.ErrDuplicate) {
+		// registered concurrently
+		log.Warningf("Failed to register; the device %s is already registered", device.ID)
+		return lh.NewStatusDoc(license), nil
+	}
+	if err != nil {
+		log.Errorf("Failed to register a device: %v", err)
+		return nil, err
+	}
+	license.DeviceCount++
+
 	// update the status document in the db
 	if license.Status == stor.STATUS_READY {
 		license.Status = stor.STATUS_ACTIVE
 	}
-	license.DeviceCount++
-	now := time.Now().Truncate(time.Second)
 	license.StatusUpdated = &now
-	lh.Store.License().Update(license)
+	if err = lh.Store.License().Update(license); err != nil {
+		log.Errorf("Failed to update the license: %v", err)
+		return nil, err
+	}
 
 	// create an event
 	event := &stor.Event{
@@ -205,42 +273,66 @@ func (lh *LicenseHandler) Register(licenseID string, device *DeviceInfo) (*Statu
 	return statusDoc, nil
 }
 
-// Renew extends the end date of  a license
+// Renew extends the end date of a license, up to its potential end, and returns a status document.
+// Without an explicit end date, the license is extended by the default number of days of the configuration,
+// bounded by the potential end. A license created without potential end gets one from the max number
+// of days of extension of the configuration, if any.
 func (lh *LicenseHandler) Renew(licenseID string, device *DeviceInfo, newEnd *time.Time) (*StatusDoc, error) {
 
 	// Get license info
 	license, err := lh.Store.License().Get(licenseID)
 	if err != nil {
-		return nil, errors.New("failed to get license info")
+		return nil, ErrLicenseNotFound
 	}
 
 	// check that the license is in active status
 	if license.Status != stor.STATUS_ACTIVE {
 		return nil, errors.New("requesting a renew on a non-active license is prohibited")
 	}
+	if license.End == nil {
+		return nil, errors.New("requesting a renew on a license without end date is prohibited")
+	}
+
+	// set the potential end date
+	if license.MaxEnd == nil && lh.Config.Status.RenewMaxDays > 0 {
+		maxEnd := license.End.AddDate(0, 0, lh.Config.Status.RenewMaxDays)
+		license.MaxEnd = &maxEnd
+	}
 
 	// set the new end date
 	if newEnd != nil {
 		// consider an explicit end date
+		if !newEnd.After(*license.End) {
+			return nil, errors.New("the new end date must be after the current end date")
+		}
 		if license.MaxEnd != nil && newEnd.After(*license.MaxEnd) {
-			license.End = license.MaxEnd
-			log.Println("License extension; it is not possible to extend the end date after ", license.End.Format(time.RFC822))
-		} else {
-			license.End = newEnd
+			return nil, ErrRenewRejected
 		}
-		// consider a default end date set in the configuration file
-	} else if lh.Config.Status.RenewDefaultDays != 0 {
-		*license.End = license.End.AddDate(0, 0, lh.Config.Status.RenewDefaultDays)
-		// the default is 7 days
 	} else {
-		*license.End = license.End.AddDate(0, 0, 7)
+		// consider a default end date set in the configuration file, the default is 7 days
+		days := lh.Config.Status.RenewDefaultDays
+		if days == 0 {
+			days = 7
+		}
+		end := license.End.AddDate(0, 0, days)
+		if license.MaxEnd != nil && end.After(*license.MaxEnd) {
+			if !license.MaxEnd.After(*license.End) {
+				return nil, ErrRenewRejected
+			}
+			end = *license.MaxEnd
+		}
+		newEnd = &end
 	}
+	license.End = newEnd
 	log.Println("License extension; the new end date is ", license.End.Format(time.RFC822))
 
 	// update the license in the db
-	now := time.Now().Truncate(time.Second)
+	now := stor.Now(lh.Clock)
 	license.Updated = &now
-	lh.Store.License().Update(license)
+	if err = lh.Store.License().Update(license); err != nil {
+		log.Errorf("Failed to update the license: %v", err)
+		return nil, err
+	}
 
 	// create an event
 	event := &stor.Event{
@@ -262,21 +354,25 @@ func (lh *LicenseHandler) Renew(licenseID string, device *DeviceInfo, newEnd *ti
 }
 
 // Return forces the expiration of a license and returns a status document.
+// Only an active license which has not expired can be returned.
 func (lh *LicenseHandler) Return(licenseID string, device *DeviceInfo) (*StatusDoc, error) {
 
 	// Get license info
 	license, err := lh.Store.License().Get(licenseID)
 	if err != nil {
-		return nil, errors.New("failed to get license info")
+		return nil, ErrLicenseNotFound
 	}
 
 	// check that the license is in active status
 	if license.Status != stor.STATUS_ACTIVE {
 		return nil, errors.New("requesting a return on a non-active license is prohibited")
 	}
+	now := stor.Now(lh.Clock)
+	if license.End != nil && now.After(*license.End) {
+		return nil, errors.New("requesting a return on an expired license is prohibited")
+	}
 
 	// set the new end date
-	now := time.Now().Truncate(time.Second)
 	license.End = &now
 
 	log.Println("License returned; the new end date is ", license.End.Format(time.RFC822))
@@ -285,7 +381,10 @@ func (lh *LicenseHandler) Return(licenseID string, device *DeviceInfo) (*StatusD
 	license.Updated = &now
 	license.Status = stor.STATUS_RETURNED
 	license.StatusUpdated = &now
-	lh.Store.License().Update(license)
+	if err = lh.Store.License().Update(license); err != nil {
+		log.Errorf("Failed to update the license: %v", err)
+		return nil, err
+	}
 
 	// create an event
 	event := &stor.Event{
@@ -307,35 +406,50 @@ func (lh *LicenseHandler) Return(licenseID string, device *DeviceInfo) (*StatusD
 }
 
 // Revoke forces the expiration of a license and returns a status document.
-func (lh *LicenseHandler) Revoke(licenseID string) (*StatusDoc, error) {
+// A ready license is cancelled, an active one is revoked; other licenses cannot be revoked.
+func (lh *LicenseHandler) Revoke(licenseID string, revocation Revocation) (*StatusDoc, error) {
 
 	// Get license info
 	license, err := lh.Store.License().Get(licenseID)
 	if err != nil {
-		return nil, errors.New("failed to get license info")
+		return nil, ErrLicenseNotFound
 	}
 
-	// check that the license is in active status
+	// check that the license is in ready or active status
+	if (license.Status != stor.STATUS_ACTIVE) && (license.Status != stor.STATUS_READY) {
+		return nil, errors.New("revoking a license that is neither ready nor active is not allowed")
+	}
 	cancel := false
 	if license.Status == stor.STATUS_READY {
 		cancel = true
 	}
 
 	// set the new end date
-	now := time.Now().Truncate(time.Second)
+	now := stor.Now(lh.Clock)
 	license.End = &now
 
 	log.Println("License revoked or cancelled; the new end date is ", license.End.Format(time.RFC822))
 
 	// update the license and status document in the db
+	// a revocation propagated to channels is confirmed by each of them before the license is revoked
+	propagate := !cancel && lh.Config.Revocation.Enabled()
 	license.Updated = &now
 	if cancel {
 		license.Status = stor.STATUS_CANCELLED
+	} else if propagate {
+		license.Status = stor.STATUS_REVOKING
 	} else {
 		license.Status = stor.STATUS_REVOKED
 	}
 	license.StatusUpdated = &now
-	lh.Store.License().Update(license)
+	if err = lh.Store.License().Update(license); err != nil {
+		return nil, err
+	}
+	if propagate {
+		if err = lh.Store.Propagation().Start(licenseID, lh.Config.Revocation.ChannelNames()); err != nil {
+			return nil, err
+		}
+	}
 
 	// create an event
 	event := &stor.Event{
@@ -344,6 +458,8 @@ func (lh *LicenseHandler) Revoke(licenseID string) (*StatusDoc, error) {
 		DeviceID:   "admin",
 		DeviceName: "system",
 		LicenseID:  licenseID,
+		Reason:     revocation.Reason,
+		Actor:      revocation.Actor,
 	}
 	if cancel {
 		event.Type = stor.EVENT_CANCEL
@@ -360,3 +476,71 @@ func (lh *LicenseHandler) Revoke(licenseID string) (*StatusDoc, error) {
 	statusDoc := lh.NewStatusDoc(license)
 	return statusDoc, nil
 }
+
+// Void cancels a license sold by a refunded order, and returns a status document. The license must be ready or
+// active, issued within the refund window, barely used, and sold by the refunded order if its order is known.
+// The cancellation is propagated to the revocation channels, e.g. the callback of the provider.
+func (lh *LicenseHandler) Void(licenseID string, void Void) (*StatusDoc, error) {
+
+	// Get license info
+	license, err := lh.Store.License().Get(licenseID)
+	if err != nil {
+		return nil, ErrLicenseNotFound
+	}
+
+	// check the status, the order, the refund window and the usage of the license
+	now := stor.Now(lh.Clock)
+	c := lh.Config.Void
+	switch {
+	case license.Status != stor.STATUS_ACTIVE && license.Status != stor.STATUS_READY:
+		return nil, fmt.Errorf("%w: its status is %s", ErrVoidRejected, license.Status)
+	case license.OrderRef != "" && license.OrderRef != void.OrderRef:
+		return nil, fmt.Errorf("%w: it was sold by another order", ErrVoidRejected)
+	case now.After(license.CreatedAt.AddDate(0, 0, c.Window)):
+		return nil, fmt.Errorf("%w: its refund window of %d days is over", ErrVoidRejected, c.Window)
+	case license.DeviceCount > c.MaxDevices:
+		return nil, fmt.Errorf("%w: %d devices are registered", ErrVoidRejected, license.DeviceCount)
+	}
+	events, err := lh.Store.Event().Count(licenseID)
+	if err != nil {
+		return nil, err
+	}
+	if events > int64(c.MaxEvents) {
+		return nil, fmt.Errorf("%w: %d events are recorded", ErrVoidRejected, events)
+	}
+
+	// cancel the license, whose order is recorded
+	license.End = &now
+	license.Updated = &now
+	license.Status = stor.STATUS_CANCELLED
+	license.StatusUpdated = &now
+	if license.OrderRef == "" {
+		license.OrderRef = void.OrderRef
+	}
+	if err = lh.Store.License().Update(license); err != nil {
+		return nil, err
+	}
+	if lh.Config.Revocation.Enabled() {
+		if err = lh.Store.Propagation().Start(licenseID, lh.Config.Revocation.ChannelNames()); err != nil {
+			return nil, err
+		}
+	}
+	log.Infof("License %s voided, order %q", licenseID, license.OrderRef)
+
+	// create an event
+	event := &stor.Event{
+		Timestamp:  now,
+		Type:       stor.EVENT_CANCEL,
+		DeviceID:   "admin",
+		DeviceName: "system",
+		LicenseID:  licenseID,
+		Reason:     void.Reason,
+		Actor:      void.Actor,
+	}
+	if err = lh.Store.Event().Create(event); err != nil {
+		log.Errorf("Failed to create an event: %v", err)
+		return nil, err
+	}
+
+	return lh.NewStatusDoc(license), nil
+}
@@ -5,11 +5,43 @@
 package lic
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/google/uuid"
 )
 
+func TestNewStatusDoc(t *testing.T) {
+
+	// a license without end date never expires, and has no potential end
+	license := LicInfo
+	license.End = nil
+	license.MaxEnd = nil
+	statusDoc := LicHandler.NewStatusDoc(&license)
+	if statusDoc.Status != stor.STATUS_READY || statusDoc.PotentialRights != nil {
+		t.Errorf("expected a ready license without potential rights, got %+v", statusDoc)
+	}
+	if !statusDoc.Updated.License.Equal(license.CreatedAt) || !statusDoc.Updated.Status.Equal(license.CreatedAt) {
+		t.Errorf("expected the license to be updated on its creation, got %+v", statusDoc.Updated)
+	}
+	rels := []string{}
+	for _, link := range statusDoc.Links {
+		rels = append(rels, link.Rel)
+	}
+	if strings.Join(rels, ",") != "register,renew,return" {
+		t.Errorf("unexpected links %v", rels)
+	}
+
+	// a license past its end date is expired
+	past := time.Now().Add(-time.Hour)
+	license.End = &past
+	if statusDoc = LicHandler.NewStatusDoc(&license); statusDoc.Status != stor.STATUS_EXPIRED {
+		t.Errorf("expected an expired license, got %s", statusDoc.Status)
+	}
+}
+
 func TestRegister(t *testing.T) {
 
 	deviceInfo := &DeviceInfo{
@@ -59,6 +91,54 @@ func TestRenew(t *testing.T) {
 
 }
 
+func TestRenewPotentialEnd(t *testing.T) {
+
+	// a license which can be extended by 10 days
+	end := time.Now().AddDate(0, 0, 10).Truncate(time.Second)
+	maxEnd := end.AddDate(0, 0, 10)
+	license := &stor.LicenseInfo{
+		UUID:          uuid.New().String(),
+		Provider:      "https://edrlab.org",
+		Status:        stor.STATUS_ACTIVE,
+		End:           &end,
+		MaxEnd:        &maxEnd,
+		PublicationID: Pub.UUID,
+	}
+	if err := LicHandler.Store.License().Create(license); err != nil {
+		t.Fatal(err)
+	}
+	deviceInfo := &DeviceInfo{ID: "1", Name: "device1"}
+
+	// an explicit end date beyond the potential end is rejected
+	beyond := maxEnd.Add(time.Hour)
+	if _, err := LicHandler.Renew(license.UUID, deviceInfo, &beyond); err != ErrRenewRejected {
+		t.Errorf("expected a rejected renew, got %v", err)
+	}
+	// an explicit end date before the current end date is invalid
+	before := end.Add(-time.Hour)
+	if _, err := LicHandler.Renew(license.UUID, deviceInfo, &before); err == nil {
+		t.Error("expected an error renewing before the end date")
+	}
+
+	// the default extension of 7 days, then up to the potential end
+	for _, expected := range []time.Time{end.AddDate(0, 0, 7), maxEnd} {
+		if _, err := LicHandler.Renew(license.UUID, deviceInfo, nil); err != nil {
+			t.Fatal(err)
+		}
+		updated, err := LicHandler.Store.License().Get(license.UUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !updated.End.Equal(expected) {
+			t.Errorf("expected the end date %s, got %s", expected, updated.End)
+		}
+	}
+	// the license cannot be extended anymore
+	if _, err := LicHandler.Renew(license.UUID, deviceInfo, nil); err != ErrRenewRejected {
+		t.Errorf("expected a rejected renew, got %v", err)
+	}
+}
+
 func TestRevoke(t *testing.T) {
 
 	deviceInfo := &DeviceInfo{
@@ -76,7 +156,7 @@ func TestRevoke(t *testing.T) {
 		t.Errorf("expected an active status, got %s", statusDoc.Status)
 	}
 
-	statusDoc, err = LicHandler.Revoke(LicInfo.UUID)
+	statusDoc, err = LicHandler.Revoke(LicInfo.UUID, Revocation{Reason: "refunded", Actor: "support"})
 	if err != nil {
 		t.Log(err)
 		t.Fatal("failed to revoke a license.")
@@ -85,5 +165,20 @@ func TestRevoke(t *testing.T) {
 	if statusDoc.Status != stor.STATUS_REVOKED {
 		t.Errorf("expected a revoked status, got %s", statusDoc.Status)
 	}
+	if statusDoc.Message != "The license has been revoked: refunded" {
+		t.Errorf("expected the reason of the revocation, got %q", statusDoc.Message)
+	}
+	event := statusDoc.Events[len(statusDoc.Events)-1]
+	if event.Type != stor.EVENT_REVOKE || event.Actor != "support" {
+		t.Errorf("expected a revocation event by support, got %+v", event)
+	}
+
+	// a revoked license cannot be revoked again
+	if _, err = LicHandler.Revoke(LicInfo.UUID, Revocation{}); err == nil {
+		t.Error("expected an error revoking a revoked license")
+	}
+	if _, err = LicHandler.Revoke("unknown", Revocation{}); err != ErrLicenseNotFound {
+		t.Errorf("expected a license not found error, got %v", err)
+	}
 
 }
@@ -0,0 +1,179 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package lic
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+// Status documents are fetched by reading systems each time a publication is opened, which makes them most of the
+// traffic of the server. They are encoded without reflection, in the caller's buffer, and their static parts are
+// marshalled once: the encoding of a status document allocates nothing once the buffer is large enough.
+// The encoding is byte-identical to encoding/json.
+
+// statusStates are the states of the status documents
+var statusStates = []string{stor.STATUS_READY, stor.STATUS_ACTIVE, stor.STATUS_REVOKED, stor.STATUS_RETURNED, stor.STATUS_CANCELLED, stor.STATUS_EXPIRED}
+
+// statusMessages are the default messages of the status documents, by state
+var statusMessages = make(map[string]string, len(statusStates))
+
+// statusFragments are the status and default message of the status documents, marshalled by state
+var statusFragments = make(map[string][]byte, len(statusStates))
+
+// lsdLinkTail ends the templated links of the status documents to the status routes: register, renew and return
+var lsdLinkTail = []byte(`,"type":"` + ContentType_LSD_JSON + `","templated":true}`)
+
+func init() {
+	for _, status := range statusStates {
+		statusMessages[status] = "The license is in " + status + " state"
+		b := append([]byte(`"status":`), marshalString(status)...)
+		b = append(b, `,"message":`...)
+		statusFragments[status] = append(b, marshalString(statusMessages[status])...)
+	}
+}
+
+// statusMessage returns the default message of a status document in a state.
+func statusMessage(status string) string {
+	if message, ok := statusMessages[status]; ok {
+		return message
+	}
+	return "The license is in " + status + " state"
+}
+
+// AppendJSON appends the JSON encoding of a status document to a buffer, and returns the extended buffer.
+func (s *StatusDoc) AppendJSON(b []byte) []byte {
+	b = append(b, `{"id":`...)
+	b = appendString(b, s.ID)
+	b = append(b, ',')
+	if fragment, ok := statusFragments[s.Status]; ok && s.Message == statusMessages[s.Status] {
+		b = append(b, fragment...)
+	} else {
+		b = append(b, `"status":`...)
+		b = appendString(b, s.Status)
+		b = append(b, `,"message":`...)
+		b = appendString(b, s.Message)
+	}
+	b = append(b, `,"updated":{"license":`...)
+	b = appendTime(b, s.Updated.License)
+	b = append(b, `,"status":`...)
+	b = appendTime(b, s.Updated.Status)
+	b = append(b, `},"links":`...)
+	if s.Links == nil {
+		b = append(b, "null"...)
+	} else {
+		b = append(b, '[')
+		for i := range s.Links {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendLink(b, &s.Links[i])
+		}
+		b = append(b, ']')
+	}
+	if s.PotentialRights != nil {
+		b = append(b, `,"potential_rights":{`...)
+		if s.PotentialRights.End != nil {
+			b = append(b, `"end":`...)
+			b = appendTime(b, *s.PotentialRights.End)
+		}
+		b = append(b, '}')
+	}
+	if len(s.Events) > 0 {
+		b = append(b, `,"events":[`...)
+		for i := range s.Events {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			e := &s.Events[i]
+			b = append(b, `{"timestamp":`...)
+			b = appendTime(b, e.Timestamp)
+			b = append(b, `,"type":`...)
+			b = appendString(b, e.Type)
+			b = append(b, `,"name":`...)
+			b = appendString(b, e.DeviceName)
+			b = append(b, `,"id":`...)
+			b = appendString(b, e.DeviceID)
+			b = append(b, '}')
+		}
+		b = append(b, ']')
+	}
+	return append(b, '}')
+}
+
+// appendLink appends the JSON encoding of a link
+func appendLink(b []byte, l *Link) []byte {
+	b = append(b, `{"rel":`...)
+	b = appendString(b, l.Rel)
+	b = append(b, `,"href":`...)
+	b = appendString(b, l.Href)
+	if l.Type == ContentType_LSD_JSON && l.Templated && l.Title == "" && l.Profile == "" && l.Size == 0 && l.Checksum == "" {
+		return append(b, lsdLinkTail...)
+	}
+	if l.Type != "" {
+		b = append(b, `,"type":`...)
+		b = appendString(b, l.Type)
+	}
+	if l.Title != "" {
+		b = append(b, `,"title":`...)
+		b = appendString(b, l.Title)
+	}
+	if l.Profile != "" {
+		b = append(b, `,"profile":`...)
+		b = appendString(b, l.Profile)
+	}
+	if l.Templated {
+		b = append(b, `,"templated":true`...)
+	}
+	if l.Size != 0 {
+		b = append(b, `,"length":`...)
+		b = strconv.AppendInt(b, l.Size, 10)
+	}
+	if l.Checksum != "" {
+		b = append(b, `,"hash":`...)
+		b = appendString(b, l.Checksum)
+	}
+	return append(b, '}')
+}
+
+// appendTime appends the JSON encoding of a time, as time.Time.MarshalJSON
+func appendTime(b []byte, t time.Time) []byte {
+	b = append(b, '"')
+	b = t.AppendFormat(b, time.RFC3339Nano)
+	return append(b, '"')
+}
+
+// appendString appends the JSON encoding of a string. Strings without any character escaped by encoding/json,
+// e.g. identifiers, urls and most device names, are copied as they are; the others are marshalled by encoding/json.
+func appendString(b []byte, s string) []byte {
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c < 0x20 || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+				return append(b, marshalString(s)...)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if (r == utf8.RuneError && size == 1) || r == '\u2028' || r == '\u2029' {
+			return append(b, marshalString(s)...)
+		}
+		i += size
+	}
+	b = append(b, '"')
+	b = append(b, s...)
+	return append(b, '"')
+}
+
+// marshalString returns the JSON encoding of a string by encoding/json
+func marshalString(s string) []byte {
+	data, _ := json.Marshal(s)
+	return data
+}
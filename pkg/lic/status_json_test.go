@@ -0,0 +1,52 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package lic
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+func TestStatusDocJSON(t *testing.T) {
+
+	updated := time.Date(2023, time.March, 1, 10, 0, 0, 123456789, time.UTC)
+	end := updated.In(time.FixedZone("CET", 3600)).AddDate(0, 1, 0)
+	links := []Link{
+		{Rel: "license", Href: "https://cms.example.com/licenses/123", Type: ContentType_LCP_JSON},
+		{Rel: "register", Href: "https://lsd.example.com/register/123{?id,name}", Type: ContentType_LSD_JSON, Templated: true},
+		{Rel: "support", Href: "https://cms.example.com/support?a=1&b=<2>", Type: ContentType_TEXT_HTML, Title: "Support", Size: 12},
+	}
+	events := []stor.Event{
+		{Timestamp: updated, Type: stor.EVENT_REGISTER, DeviceName: "Liseuse d'Élodie", DeviceID: "d1", Reason: "not encoded"},
+		{Timestamp: end, Type: stor.EVENT_REVOKE, DeviceName: "\"quoted\"\b ", DeviceID: "d2"},
+	}
+	docs := map[string]StatusDoc{
+		"minimal": {ID: "123", Status: stor.STATUS_READY, Message: statusMessage(stor.STATUS_READY)},
+		"active":  {ID: "123", Status: stor.STATUS_ACTIVE, Message: statusMessage(stor.STATUS_ACTIVE), Updated: Updated{License: updated, Status: end}, Links: links, PotentialRights: &PotentialRights{End: &end}, Events: events},
+		"revoked": {ID: "123", Status: stor.STATUS_REVOKED, Message: "The license has been revoked: <fraud> & abuse\u2028\xff", Links: []Link{}, PotentialRights: &PotentialRights{}},
+		"unknown": {ID: "123", Status: "suspended", Message: statusMessage("suspended"), Provider: "not encoded"},
+	}
+	for name, doc := range docs {
+		expected, err := json.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := doc.AppendJSON(nil); string(got) != string(expected) {
+			t.Errorf("Unexpected encoding of the %s document:\n%s\nexpected:\n%s", name, got, expected)
+		}
+	}
+
+	// the encoding of a usual document allocates nothing in a large enough buffer
+	doc := docs["active"]
+	doc.Events = events[:1]
+	doc.Links = links[:2]
+	buf := make([]byte, 0, 4096)
+	if allocs := testing.AllocsPerRun(100, func() { buf = doc.AppendJSON(buf[:0]) }); allocs != 0 {
+		t.Errorf("Expected no allocation, got %v", allocs)
+	}
+}
@@ -5,7 +5,6 @@
 package lic
 
 import (
-	"encoding/hex"
 	"errors"
 )
 
@@ -16,9 +15,5 @@ func GenerateUserKey(profile, passhash string) ([]byte, error) {
 		return nil, errors.New("this version can only process LCP basic profile; failed to decode the user passphrase")
 	}
 	// compute a byte array from a string
-	value, err := hex.DecodeString(passhash)
-	if err != nil {
-		return nil, errors.New("failed to decode the user passphrase")
-	}
-	return value, nil
+	return decodePassHash(passhash)
 }
@@ -0,0 +1,161 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package oauth issues the access tokens of the OAuth 2.0 client credentials flow (RFC 6749, section 4.4):
+// machine clients, e.g. content management systems, exchange their client id and secret for short-lived bearer
+// tokens accepted on the private routes. Tokens are stateless JWTs signed with HMAC-SHA256 by a secret shared by
+// every instance of the server; a token is rejected as soon as its client is removed from the configuration,
+// and has the role and provider currently configured for its client.
+package oauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/jwt"
+	"github.com/google/uuid"
+)
+
+// default lifetime of the tokens
+const defaultTokenTTL = time.Hour
+
+// header of the tokens, as an access token profile of JWT (RFC 9068)
+const tokenHeader = `{"alg":"HS256","typ":"at+jwt"}`
+
+// ErrInvalidClient is returned when a client is unknown or its secret does not match.
+var ErrInvalidClient = errors.New("invalid client")
+
+// Server issues and validates the tokens of the machine clients.
+type Server struct {
+	Issuer  string // iss claim, the public base url of the server
+	Secret  []byte
+	TTL     time.Duration
+	Clock   func() time.Time // returns the current time; time.Now if nil
+	clients map[string]client
+}
+
+// client is a machine client, with the hash of its secret
+type client struct {
+	secret   [sha256.Size]byte
+	role     string
+	provider string
+}
+
+// NewServer creates a token server from the configuration.
+func NewServer(c conf.OAuth, issuer string) *Server {
+	s := &Server{
+		Issuer:  issuer,
+		Secret:  []byte(c.Secret),
+		TTL:     time.Duration(c.TokenTTL) * time.Second,
+		Clock:   time.Now,
+		clients: make(map[string]client, len(c.Clients)),
+	}
+	if s.TTL == 0 {
+		s.TTL = defaultTokenTTL
+	}
+	for _, cl := range c.Clients {
+		if cl.ID != "" && cl.Secret != "" {
+			role := cl.Role
+			if role == "" {
+				role = conf.ROLE_ADMIN
+			}
+			s.clients[cl.ID] = client{secret: sha256.Sum256([]byte(cl.Secret)), role: role, provider: cl.Provider}
+		}
+	}
+	return s
+}
+
+// now returns the current time
+func (s *Server) now() time.Time {
+	if s.Clock == nil {
+		return time.Now()
+	}
+	return s.Clock()
+}
+
+// Token authenticates a client by its secret, and returns a new token and its lifetime.
+// The secret is compared in constant time.
+func (s *Server) Token(clientID, clientSecret string) (string, time.Duration, error) {
+
+	expected, found := s.clients[clientID]
+	hash := sha256.Sum256([]byte(clientSecret))
+	if subtle.ConstantTimeCompare(hash[:], expected.secret[:]) != 1 || !found {
+		return "", 0, ErrInvalidClient
+	}
+
+	now := s.now().Truncate(time.Second)
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":       s.Issuer,
+		"sub":       clientID,
+		"client_id": clientID,
+		"iat":       now.Unix(),
+		"exp":       now.Add(s.TTL).Unix(),
+		"jti":       uuid.New().String(),
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	signed := encode([]byte(tokenHeader)) + "." + encode(claims)
+	return signed + "." + encode(s.sign(signed)), s.TTL, nil
+}
+
+// Validate checks a token issued by the server, and returns its claims. The errors wrap jwt.ErrInvalidToken,
+// so that the tokens of another issuer can be checked next.
+func (s *Server) Validate(ctx context.Context, token string) (*jwt.Claims, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != encode([]byte(tokenHeader)) {
+		return nil, fmt.Errorf("%w: not a token of the server", jwt.ErrInvalidToken)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, s.sign(parts[0]+"."+parts[1])) {
+		return nil, fmt.Errorf("%w: invalid signature", jwt.ErrInvalidToken)
+	}
+
+	var claims jwt.Claims
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err == nil {
+		err = json.Unmarshal(data, &claims)
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &claims.Raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", jwt.ErrInvalidToken)
+	}
+	switch cl, known := s.clients[claims.Subject]; {
+	case claims.Issuer != s.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %q", jwt.ErrInvalidToken, claims.Issuer)
+	case !known:
+		return nil, fmt.Errorf("%w: unknown client %q", jwt.ErrInvalidToken, claims.Subject)
+	case !s.now().Before(time.Unix(int64(claims.Expires), 0)):
+		return nil, fmt.Errorf("%w: expired token", jwt.ErrInvalidToken)
+	default:
+		// the role and provider of the client are the current ones, not the ones it had when the token was issued
+		claims.Roles = []string{cl.role}
+		claims.Provider = cl.provider
+	}
+	return &claims, nil
+}
+
+// sign returns the signature of the header and the claims of a token
+func (s *Server) sign(signed string) []byte {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+// encode encodes a segment of a token
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,75 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package oauth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/jwt"
+)
+
+func TestToken(t *testing.T) {
+
+	c := conf.OAuth{
+		Secret:   strings.Repeat("s", 32),
+		TokenTTL: 600,
+		Clients:  []conf.OAuthClient{{ID: "cms", Secret: "cms-secret"}, {ID: "erp", Secret: "erp-secret", Role: "reader", Provider: "https://erp.example.com"}},
+	}
+	now := time.Unix(1700000000, 0)
+	s := NewServer(c, "https://lcp.example.com")
+	s.Clock = func() time.Time { return now }
+
+	// clients are authenticated by their own secret
+	for _, creds := range [][2]string{{"cms", "erp-secret"}, {"unknown", ""}, {"", ""}} {
+		if _, _, err := s.Token(creds[0], creds[1]); !errors.Is(err, ErrInvalidClient) {
+			t.Errorf("Expected an invalid client for %v, got %v", creds, err)
+		}
+	}
+	token, ttl, err := s.Token("cms", "cms-secret")
+	if err != nil || ttl != 10*time.Minute {
+		t.Fatalf("Failed to issue a token: %v", err)
+	}
+	claims, err := s.Validate(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Failed to validate a token: %v", err)
+	}
+	if claims.Subject != "cms" || claims.Issuer != "https://lcp.example.com" || claims.Raw["client_id"] != "cms" ||
+		len(claims.Roles) != 1 || claims.Roles[0] != "admin" || claims.Provider != "" {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+	erp, _, _ := s.Token("erp", "erp-secret")
+	if claims, err = s.Validate(context.Background(), erp); err != nil || claims.Roles[0] != "reader" || claims.Provider != "https://erp.example.com" {
+		t.Errorf("Expected the role and provider of the client, got %+v, %v", claims, err)
+	}
+
+	// invalid tokens
+	other := NewServer(conf.OAuth{Secret: strings.Repeat("o", 32), Clients: c.Clients}, "https://lcp.example.com")
+	foreign, _, _ := other.Token("cms", "cms-secret")
+	removed := NewServer(conf.OAuth{Secret: c.Secret, Clients: c.Clients[1:]}, "https://lcp.example.com")
+	elsewhere := NewServer(c, "https://other.example.com")
+	for name, test := range map[string]struct {
+		s     *Server
+		token string
+	}{
+		"malformed":      {s, "not.a-token"},
+		"bad signature":  {s, token[:len(token)-4] + "AAAA"},
+		"other secret":   {s, foreign},
+		"removed client": {removed, token},
+		"other issuer":   {elsewhere, token},
+	} {
+		if _, err := test.s.Validate(context.Background(), test.token); !errors.Is(err, jwt.ErrInvalidToken) {
+			t.Errorf("Expected an invalid token error for %s, got %v", name, err)
+		}
+	}
+	now = now.Add(10 * time.Minute)
+	if _, err := s.Validate(context.Background(), token); !errors.Is(err, jwt.ErrInvalidToken) {
+		t.Errorf("Expected an expired token, got %v", err)
+	}
+}
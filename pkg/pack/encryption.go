@@ -0,0 +1,128 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package pack
+
+import (
+	"archive/zip"
+	"encoding/xml"
+)
+
+// Compression methods, as zip methods
+const (
+	MethodStore   = 0
+	MethodDeflate = 8
+)
+
+// Encryption is the root of META-INF/encryption.xml
+type Encryption struct {
+	XMLName xml.Name        `xml:"urn:oasis:names:tc:opendocument:xmlns:container encryption"`
+	Data    []EncryptedData `xml:"http://www.w3.org/2001/04/xmlenc# EncryptedData"`
+}
+
+type EncryptedData struct {
+	EncryptionMethod struct {
+		Algorithm string `xml:"Algorithm,attr"`
+	} `xml:"http://www.w3.org/2001/04/xmlenc# EncryptionMethod"`
+	KeyInfo    *KeyInfo `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo,omitempty"`
+	CipherData struct {
+		CipherReference struct {
+			URI string `xml:"URI,attr"`
+		} `xml:"http://www.w3.org/2001/04/xmlenc# CipherReference"`
+	} `xml:"http://www.w3.org/2001/04/xmlenc# CipherData"`
+	Properties *EncryptionProperties `xml:"http://www.w3.org/2001/04/xmlenc# EncryptionProperties,omitempty"`
+}
+
+type KeyInfo struct {
+	RetrievalMethod struct {
+		URI  string `xml:"URI,attr"`
+		Type string `xml:"Type,attr"`
+	} `xml:"http://www.w3.org/2000/09/xmldsig# RetrievalMethod"`
+}
+
+type EncryptionProperties struct {
+	Properties []EncryptionProperty `xml:"http://www.w3.org/2001/04/xmlenc# EncryptionProperty"`
+}
+
+type EncryptionProperty struct {
+	Compression Compression `xml:"http://www.idpf.org/2016/encryption#compression Compression"`
+}
+
+type Compression struct {
+	Method         int    `xml:"Method,attr"`
+	OriginalLength uint64 `xml:"OriginalLength,attr"`
+}
+
+// add declares a resource encrypted with the LCP content key
+func (e *Encryption) add(uri, algorithm string, deflated bool, originalLength uint64) {
+
+	var data EncryptedData
+	data.EncryptionMethod.Algorithm = algorithm
+	data.KeyInfo = &KeyInfo{}
+	data.KeyInfo.RetrievalMethod.URI = ContentKeyURI
+	data.KeyInfo.RetrievalMethod.Type = ContentKeyType
+	data.CipherData.CipherReference.URI = uri
+
+	method := MethodStore
+	if deflated {
+		method = MethodDeflate
+	}
+	data.Properties = &EncryptionProperties{
+		Properties: []EncryptionProperty{
+			{Compression: Compression{Method: method, OriginalLength: originalLength}},
+		},
+	}
+	e.Data = append(e.Data, data)
+}
+
+// Find returns the encryption info related to a resource, or nil
+func (e *Encryption) Find(uri string) *EncryptedData {
+	for i := range e.Data {
+		if e.Data[i].CipherData.CipherReference.URI == uri {
+			return &e.Data[i]
+		}
+	}
+	return nil
+}
+
+// Compression returns the compression info of an encrypted resource, or nil
+func (d *EncryptedData) Compression() *Compression {
+	if d.Properties == nil || len(d.Properties.Properties) == 0 {
+		return nil
+	}
+	return &d.Properties.Properties[0].Compression
+}
+
+// getEncryption reads the encryption file of an EPUB, if any
+func getEncryption(zr *zip.Reader) (*Encryption, error) {
+
+	enc := &Encryption{}
+	f := findFile(zr, EncryptionFile)
+	if f == nil {
+		return enc, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	err = xml.NewDecoder(rc).Decode(enc)
+	return enc, err
+}
+
+// writeEncryption adds the encryption file to an EPUB
+func writeEncryption(zw *zip.Writer, enc *Encryption) error {
+
+	w, err := zw.Create(EncryptionFile)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write([]byte(xml.Header)); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(enc)
+}
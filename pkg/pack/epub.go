@@ -0,0 +1,304 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package pack encrypts publications following the LCP specification.
+package pack
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"hash"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/edrlab/lcp-server/pkg/crypto"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ContentType_EPUB = "application/epub+zip"
+
+	EncryptionFile = "META-INF/encryption.xml"
+	ContainerFile  = "META-INF/container.xml"
+	MimetypeFile   = "mimetype"
+
+	// LCP retrieval method, see the LCP specification
+	ContentKeyURI  = "license.lcpl#/encryption/content_key"
+	ContentKeyType = "http://readium.org/2014/01/lcp#EncryptedContentKey"
+)
+
+// Stats holds size metrics collected while packaging a publication.
+type Stats struct {
+	SourceSize     int64 // size of the source file
+	Size           int64 // size of the protected file
+	Checksum       string
+	EncryptedCount int    // number of encrypted resources
+	DeflatedCount  int    // number of encrypted resources deflated before encryption
+	ContentType    string // media type of the protected file
+}
+
+// Record sets the size metrics, checksum and media type of the protected file in a publication.
+func (s *Stats) Record(pub *stor.Publication) {
+	pub.Size = s.Size
+	pub.SourceSize = s.SourceSize
+	pub.Checksum = s.Checksum
+	if s.ContentType != "" {
+		pub.ContentType = s.ContentType
+	}
+}
+
+// EncryptEPUB encrypts the resources of an EPUB file and writes the protected file to w.
+// Resources are deflated before encryption, except if they are already compressed (images,
+// audio, video, fonts); the decision is recorded in META-INF/encryption.xml.
+func EncryptEPUB(r io.ReaderAt, size int64, w io.Writer, encrypter crypto.Encrypter, key crypto.ContentKey) (*Stats, error) {
+	return encryptEPUB(r, size, w, encrypter, key, nil)
+}
+
+// EncryptEPUBSample encrypts a sample of an EPUB file, e.g. its first chapter, and writes the protected file to w.
+// The resources of the sample are encrypted as by EncryptEPUB; the other resources which would be encrypted
+// are left out of the protected file, so that the sample never holds them, even encrypted. A resource is
+// in the sample if its path is in the list, or if it starts with a path of the list ending with a slash.
+func EncryptEPUBSample(r io.ReaderAt, size int64, w io.Writer, encrypter crypto.Encrypter, key crypto.ContentKey, resources []string) (*Stats, error) {
+	if len(resources) == 0 {
+		return nil, errors.New("a sample must hold at least one resource")
+	}
+	return encryptEPUB(r, size, w, encrypter, key, func(name string) bool {
+		for _, res := range resources {
+			if name == res || (strings.HasSuffix(res, "/") && strings.HasPrefix(name, res)) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// encryptEPUB encrypts the resources of an EPUB file which are kept, or every resource if keep is nil.
+func encryptEPUB(r io.ReaderAt, size int64, w io.Writer, encrypter crypto.Encrypter, key crypto.ContentKey, keep func(name string) bool) (*Stats, error) {
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// files which must not be encrypted
+	exclusions, err := getExclusions(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	// previous encryption info (e.g. obfuscated fonts) is kept as is
+	enc, err := getEncryption(zr)
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range enc.Data {
+		exclusions[data.CipherData.CipherReference.URI] = true
+	}
+
+	// measure and hash the protected file
+	hw := newHashWriter(w)
+	zw := zip.NewWriter(hw)
+
+	stats := &Stats{SourceSize: size, ContentType: ContentType_EPUB}
+
+	// the mimetype file must be the first in the archive
+	if f := findFile(zr, MimetypeFile); f != nil {
+		if err = copyRaw(zw, f); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range zr.File {
+		if f.Name == MimetypeFile || f.Name == EncryptionFile || f.FileInfo().IsDir() {
+			continue
+		}
+		if exclusions[f.Name] {
+			if err = copyRaw(zw, f); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if keep != nil && !keep(f.Name) {
+			continue
+		}
+		deflate := shouldDeflate(f.Name)
+		if err = encryptFile(zw, f, encrypter, key, deflate); err != nil {
+			return nil, err
+		}
+		enc.add(f.Name, encrypter.Signature(), deflate, f.UncompressedSize64)
+		stats.EncryptedCount++
+		if deflate {
+			stats.DeflatedCount++
+		}
+	}
+
+	if keep != nil && stats.EncryptedCount == 0 {
+		return nil, errors.New("no resource of the sample found in the publication")
+	}
+
+	// write the encryption file
+	if err = writeEncryption(zw, enc); err != nil {
+		return nil, err
+	}
+
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+
+	stats.Size = hw.size
+	stats.Checksum = base64.StdEncoding.EncodeToString(hw.hash.Sum(nil))
+
+	log.Infof("EPUB encrypted: %d resources (%d deflated), %d -> %d bytes",
+		stats.EncryptedCount, stats.DeflatedCount, stats.SourceSize, stats.Size)
+	return stats, nil
+}
+
+// encryptFile encrypts a resource, after deflating it if required.
+// Encrypted data is stored in the archive, as it would not gain from compression.
+// The resource is streamed from the source archive to the destination archive,
+// therefore the memory used does not depend on the size of the resource.
+func encryptFile(zw *zip.Writer, f *zip.File, encrypter crypto.Encrypter, key crypto.ContentKey, deflate bool) error {
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	var src io.Reader = rc
+	if deflate {
+		pr, pw := io.Pipe()
+		// unblock the deflate goroutine if the encryption fails
+		defer pr.Close()
+		go func() {
+			pw.CloseWithError(deflateTo(pw, rc))
+		}()
+		src = pr
+	}
+
+	header := &zip.FileHeader{
+		Name:     f.Name,
+		Method:   zip.Store,
+		Modified: f.Modified,
+	}
+	fw, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	return encrypter.Encrypt(key, src, fw)
+}
+
+// deflateTo writes a raw deflate stream (no zlib header) of r to w.
+func deflateTo(w io.Writer, r io.Reader) error {
+
+	fw, err := flate.NewWriter(w, flate.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(fw, r); err != nil {
+		return err
+	}
+	return fw.Close()
+}
+
+// copyRaw copies a file to the archive without recompressing it.
+func copyRaw(zw *zip.Writer, f *zip.File) error {
+
+	rc, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+	header := f.FileHeader
+	fw, err := zw.CreateRaw(&header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, rc)
+	return err
+}
+
+func findFile(zr *zip.Reader, name string) *zip.File {
+	for _, f := range zr.File {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// getExclusions returns the set of files which must stay in clear:
+// every file in META-INF and the package documents.
+func getExclusions(zr *zip.Reader) (map[string]bool, error) {
+
+	exclusions := make(map[string]bool)
+	for _, f := range zr.File {
+		if strings.HasPrefix(f.Name, "META-INF/") {
+			exclusions[f.Name] = true
+		}
+	}
+
+	f := findFile(zr, ContainerFile)
+	if f == nil {
+		return nil, errors.New("invalid EPUB file, missing " + ContainerFile)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var container struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err = xml.NewDecoder(rc).Decode(&container); err != nil {
+		return nil, err
+	}
+	if len(container.Rootfiles) == 0 {
+		return nil, errors.New("invalid EPUB file, no package document")
+	}
+	for _, rf := range container.Rootfiles {
+		exclusions[rf.FullPath] = true
+	}
+	return exclusions, nil
+}
+
+// noDeflate lists file extensions of resources which are already compressed.
+var noDeflate = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".avif": true,
+	".mp3": true, ".m4a": true, ".m4b": true, ".aac": true, ".ogg": true, ".opus": true,
+	".mp4": true, ".m4v": true, ".webm": true,
+	".woff": true, ".woff2": true,
+	".zip": true, ".pdf": true,
+}
+
+// shouldDeflate indicates if a resource must be deflated before encryption.
+func shouldDeflate(name string) bool {
+	return !noDeflate[strings.ToLower(path.Ext(name))]
+}
+
+// hashWriter measures and hashes what is written through it.
+type hashWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	size int64
+}
+
+func newHashWriter(w io.Writer) *hashWriter {
+	return &hashWriter{w: w, hash: sha256.New()}
+}
+
+func (hw *hashWriter) Write(p []byte) (int, error) {
+	n, err := hw.w.Write(p)
+	hw.hash.Write(p[:n])
+	hw.size += int64(n)
+	return n, err
+}
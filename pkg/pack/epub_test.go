@@ -0,0 +1,192 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package pack
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/crypto"
+	"github.com/edrlab/lcp-server/pkg/stor"
+)
+
+const testChapter = "<html><body><p>It was a bright cold day in April, and the clocks were striking thirteen.</p></body></html>"
+
+// newTestEPUB builds a minimal EPUB file in memory
+func newTestEPUB(t *testing.T) []byte {
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	files := []struct {
+		name    string
+		content string
+	}{
+		{MimetypeFile, ContentType_EPUB},
+		{ContainerFile, `<?xml version="1.0"?><container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container"><rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`},
+		{"OEBPS/content.opf", `<?xml version="1.0"?><package xmlns="http://www.idpf.org/2007/opf" version="3.0"></package>`},
+		{"OEBPS/chapter1.xhtml", strings.Repeat(testChapter, 500)},
+		{"OEBPS/images/cover.jpg", "\xff\xd8\xff\xe0 not really a jpeg"},
+	}
+	for _, f := range files {
+		method := zip.Deflate
+		if f.name == MimetypeFile {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(f.content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readFile(t *testing.T, f *zip.File) []byte {
+	rc, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestEncryptEPUB(t *testing.T) {
+
+	src := newTestEPUB(t)
+
+	encrypter := crypto.NewAESEncrypter_PUBLICATION_RESOURCES()
+	key, err := encrypter.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	stats, err := EncryptEPUB(bytes.NewReader(src), int64(len(src)), &out, encrypter, key)
+	if err != nil {
+		t.Fatalf("Failed to encrypt the EPUB: %v", err)
+	}
+	if stats.SourceSize != int64(len(src)) || stats.Size != int64(out.Len()) {
+		t.Errorf("Invalid size metrics: %d -> %d", stats.SourceSize, stats.Size)
+	}
+	if stats.EncryptedCount != 2 || stats.DeflatedCount != 1 {
+		t.Errorf("Expected 2 encrypted resources, 1 deflated; got %d, %d", stats.EncryptedCount, stats.DeflatedCount)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zr.File[0].Name != MimetypeFile || zr.File[0].Method != zip.Store {
+		t.Error("The mimetype file must be stored first")
+	}
+
+	// check the encryption file
+	enc, err := getEncryption(zr)
+	if err != nil {
+		t.Fatalf("Failed to parse the encryption file: %v", err)
+	}
+	if enc.Find("OEBPS/content.opf") != nil {
+		t.Error("The package document must not be encrypted")
+	}
+	img := enc.Find("OEBPS/images/cover.jpg")
+	if img == nil || img.Compression().Method != MethodStore {
+		t.Error("The image must be encrypted without compression")
+	}
+	chap := enc.Find("OEBPS/chapter1.xhtml")
+	if chap == nil || chap.Compression().Method != MethodDeflate {
+		t.Fatal("The chapter must be deflated then encrypted")
+	}
+
+	// decrypt and inflate the chapter
+	var decrypted bytes.Buffer
+	decrypter := encrypter.(crypto.Decrypter)
+	if err = decrypter.Decrypt(key, bytes.NewReader(readFile(t, findFile(zr, "OEBPS/chapter1.xhtml"))), &decrypted); err != nil {
+		t.Fatal(err)
+	}
+	inflated, err := ioutil.ReadAll(flate.NewReader(&decrypted))
+	if err != nil && err != io.ErrUnexpectedEOF {
+		t.Fatal(err)
+	}
+	if string(inflated) != strings.Repeat(testChapter, 500) {
+		t.Error("Failed to get back the chapter content")
+	}
+	if uint64(len(inflated)) != chap.Compression().OriginalLength {
+		t.Errorf("Invalid original length %d", chap.Compression().OriginalLength)
+	}
+}
+
+func TestEncryptEPUBSample(t *testing.T) {
+
+	src := newTestEPUB(t)
+
+	encrypter := crypto.NewAESEncrypter_PUBLICATION_RESOURCES()
+	key, err := encrypter.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	stats, err := EncryptEPUBSample(bytes.NewReader(src), int64(len(src)), &out, encrypter, key, []string{"OEBPS/chapter1.xhtml"})
+	if err != nil {
+		t.Fatalf("Failed to encrypt the sample: %v", err)
+	}
+	if stats.EncryptedCount != 1 {
+		t.Errorf("Expected 1 encrypted resource, got %d", stats.EncryptedCount)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if findFile(zr, "OEBPS/images/cover.jpg") != nil {
+		t.Error("A resource out of the sample must be left out")
+	}
+	if findFile(zr, "OEBPS/content.opf") == nil || findFile(zr, ContainerFile) == nil {
+		t.Error("The package documents must be kept")
+	}
+	enc, err := getEncryption(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc.Find("OEBPS/chapter1.xhtml") == nil || enc.Find("OEBPS/images/cover.jpg") != nil {
+		t.Error("Only the resources of the sample must be declared encrypted")
+	}
+
+	// a folder holds its resources
+	out.Reset()
+	if stats, err = EncryptEPUBSample(bytes.NewReader(src), int64(len(src)), &out, encrypter, key, []string{"OEBPS/images/"}); err != nil || stats.EncryptedCount != 1 {
+		t.Errorf("Failed to encrypt a folder of resources: %v", err)
+	}
+
+	// a sample holds resources of the publication
+	out.Reset()
+	if _, err = EncryptEPUBSample(bytes.NewReader(src), int64(len(src)), &out, encrypter, key, []string{"OEBPS/chapter9.xhtml"}); err == nil {
+		t.Error("Expected an error for a sample without resource")
+	}
+}
+
+func TestStatsRecord(t *testing.T) {
+
+	// sizes beyond 4 GB are not truncated
+	stats := &Stats{SourceSize: 5 << 30, Size: 5<<30 + 1024, Checksum: "YQ==", ContentType: ContentType_LCPAU}
+	var pub stor.Publication
+	stats.Record(&pub)
+	if pub.Size != 5<<30+1024 || pub.SourceSize != 5<<30 || pub.ContentType != ContentType_LCPAU {
+		t.Errorf("Unexpected recorded publication %+v", pub)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package pack
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+)
+
+// convertW3CManifest converts a W3C publication manifest (audiobook profile) into a Readium manifest.
+// See https://www.w3.org/TR/audiobooks/ and https://readium.org/webpub-manifest/.
+func convertW3CManifest(w3c map[string]interface{}) (map[string]interface{}, error) {
+
+	metadata := map[string]interface{}{
+		"@type":      "http://schema.org/Audiobook",
+		"conformsTo": "https://readium.org/webpub-manifest/profiles/audiobook",
+	}
+	if id, ok := w3c["id"].(string); ok {
+		metadata["identifier"] = id
+	}
+	if title := localizedString(w3c["name"]); title != "" {
+		metadata["title"] = title
+	}
+	if lang := localizedString(w3c["inLanguage"]); lang != "" {
+		metadata["language"] = lang
+	}
+	if published, ok := w3c["datePublished"].(string); ok {
+		metadata["published"] = published
+	}
+	if modified, ok := w3c["dateModified"].(string); ok {
+		metadata["modified"] = modified
+	}
+	for w3cRole, role := range map[string]string{"author": "author", "readBy": "narrator", "publisher": "publisher"} {
+		if names := contributors(w3c[w3cRole]); len(names) > 0 {
+			metadata[role] = names
+		}
+	}
+	if duration, ok := parseDuration(w3c["duration"]); ok {
+		metadata["duration"] = duration
+	}
+
+	readingOrder := convertW3CLinks(w3c["readingOrder"])
+	if len(readingOrder) == 0 {
+		return nil, errors.New("invalid W3C manifest, empty reading order")
+	}
+	manifest := map[string]interface{}{
+		"@context":     "https://readium.org/webpub-manifest/context.jsonld",
+		"metadata":     metadata,
+		"readingOrder": readingOrder,
+	}
+	if resources := convertW3CLinks(w3c["resources"]); len(resources) > 0 {
+		manifest["resources"] = resources
+	}
+	return manifest, nil
+}
+
+// convertW3CLinks converts W3C linked resources into Readium links.
+// A linked resource may be expressed as a simple url.
+func convertW3CLinks(v interface{}) []interface{} {
+
+	list, _ := v.([]interface{})
+	links := make([]interface{}, 0, len(list))
+	for _, item := range list {
+		var link map[string]interface{}
+		switch res := item.(type) {
+		case string:
+			link = map[string]interface{}{"href": res}
+		case map[string]interface{}:
+			href, _ := res["url"].(string)
+			if href == "" {
+				continue
+			}
+			link = map[string]interface{}{"href": href}
+			if t, ok := res["encodingFormat"].(string); ok {
+				link["type"] = t
+			}
+			if title := localizedString(res["name"]); title != "" {
+				link["title"] = title
+			}
+			if rel, ok := res["rel"]; ok {
+				link["rel"] = rel
+			}
+			if duration, ok := parseDuration(res["duration"]); ok {
+				link["duration"] = duration
+			}
+		default:
+			continue
+		}
+		links = append(links, link)
+	}
+	return links
+}
+
+// localizedString returns the first value of a W3C localizable string,
+// expressed as a string, a localized object or an array of these.
+func localizedString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case map[string]interface{}:
+		value, _ := s["value"].(string)
+		return value
+	case []interface{}:
+		if len(s) > 0 {
+			return localizedString(s[0])
+		}
+	}
+	return ""
+}
+
+// contributors returns the names of W3C creators, expressed as strings or entities.
+func contributors(v interface{}) []string {
+
+	list, ok := v.([]interface{})
+	if !ok {
+		list = []interface{}{v}
+	}
+	names := []string{}
+	for _, item := range list {
+		name := localizedString(item)
+		if entity, ok := item.(map[string]interface{}); ok {
+			name = localizedString(entity["name"])
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+var isoDuration = regexp.MustCompile(`^PT(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?$`)
+
+// parseDuration converts an ISO 8601 duration (e.g. PT1H2M3.5S) into seconds.
+func parseDuration(v interface{}) (float64, bool) {
+
+	s, ok := v.(string)
+	if !ok || s == "PT" {
+		return 0, false
+	}
+	m := isoDuration.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+	var seconds float64
+	for i, unit := range []float64{3600, 60, 1} {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(m[i+1], 64)
+		if err != nil {
+			return 0, false
+		}
+		seconds += n * unit
+	}
+	return seconds, true
+}
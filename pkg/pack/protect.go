@@ -0,0 +1,119 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package pack
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/edrlab/lcp-server/pkg/crypto"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/storage"
+)
+
+// extensions of the protected files, by media type
+var extensions = map[string]string{
+	ContentType_EPUB:  ".epub",
+	ContentType_LCPDF: ".lcpdf",
+	ContentType_LCPAU: ".lcpau",
+	ContentType_LCPDI: ".lcpdi",
+}
+
+// media types of the cleartext publications, by file extension
+var sourceTypes = map[string]string{
+	".epub":      ContentType_EPUB,
+	".pdf":       ContentType_PDF,
+	".audiobook": ContentType_Audiobook,
+	".divina":    ContentType_Divina,
+	".lpf":       ContentType_LPF,
+}
+
+// ContentTypeByName returns the media type of a cleartext publication from its file name,
+// or an empty string if the extension is unknown.
+func ContentTypeByName(name string) string {
+	return sourceTypes[strings.ToLower(path.Ext(name))]
+}
+
+// IsSupported tells if publications of a media type can be encrypted.
+func IsSupported(contentType string) bool {
+	_, ok := extensions[ProtectedContentType(contentType)]
+	return ok
+}
+
+// StorageKey returns the storage key of the protected file of a publication.
+func StorageKey(publicationID, contentType string) string {
+	return publicationID + extensions[ProtectedContentType(contentType)]
+}
+
+// Protect encrypts a cleartext publication with its content key, generated if the publication has none,
+// and writes the protected file to a storage. The protected file is streamed to the storage while it is
+// encrypted. The content key, the location and storage key of the protected file, its size metrics, checksum
+// and media type are recorded in the publication, which is not saved.
+func Protect(ctx context.Context, st storage.Storage, contentType string, r io.ReaderAt, size int64, pub *stor.Publication) (*Stats, error) {
+
+	if !IsSupported(contentType) {
+		return nil, errors.New("unsupported publication type " + contentType)
+	}
+	return protect(ctx, st, contentType, pub, func(w io.Writer, encrypter crypto.Encrypter, key crypto.ContentKey) (*Stats, error) {
+		return Encrypt(contentType, r, size, w, encrypter, key)
+	})
+}
+
+// ProtectSample encrypts a sample of a cleartext EPUB file, holding the listed resources, as Protect.
+// The sample is a publication of its own, with its own content key: the licenses of the sample
+// cannot decrypt the full publication.
+func ProtectSample(ctx context.Context, st storage.Storage, r io.ReaderAt, size int64, pub *stor.Publication, resources []string) (*Stats, error) {
+	return protect(ctx, st, ContentType_EPUB, pub, func(w io.Writer, encrypter crypto.Encrypter, key crypto.ContentKey) (*Stats, error) {
+		return EncryptEPUBSample(r, size, w, encrypter, key, resources)
+	})
+}
+
+// protect streams the protected file written by an encryption function to a storage, and records it in the publication
+func protect(ctx context.Context, st storage.Storage, contentType string, pub *stor.Publication, encrypt func(io.Writer, crypto.Encrypter, crypto.ContentKey) (*Stats, error)) (*Stats, error) {
+
+	key := StorageKey(pub.UUID, contentType)
+	location := st.URL(key)
+	if location == "" {
+		return nil, errors.New("the storage has no base url, from which publications are downloaded")
+	}
+
+	encrypter := crypto.NewAESEncrypter_PUBLICATION_RESOURCES()
+	contentKey := crypto.ContentKey(pub.EncryptionKey)
+	if len(contentKey) == 0 {
+		var err error
+		if contentKey, err = encrypter.GenerateKey(); err != nil {
+			return nil, err
+		}
+	}
+
+	// the encrypter writes to the pipe read by the storage
+	pr, pw := io.Pipe()
+	var stats *Stats
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		stats, err = encrypt(pw, encrypter, contentKey)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	_, err := st.Put(ctx, key, pr)
+	pr.CloseWithError(err) // stops the encrypter if the storage failed
+	encErr := <-done
+	if err != nil {
+		return nil, err
+	}
+	if encErr != nil {
+		return nil, encErr
+	}
+
+	stats.Record(pub)
+	pub.EncryptionKey = contentKey
+	pub.Location = location
+	pub.StorageKey = key
+	return stats, nil
+}
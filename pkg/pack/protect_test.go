@@ -0,0 +1,72 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package pack
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/storage"
+)
+
+func TestProtect(t *testing.T) {
+
+	st, err := storage.NewFileStorage(t.TempDir(), "https://cdn.example.com/publications")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := newTestEPUB(t)
+	pub := &stor.Publication{UUID: "b44e9df0-b6c7-41aa-9a91-1ae148f86b29"}
+
+	stats, err := Protect(context.Background(), st, ContentType_EPUB, bytes.NewReader(src), int64(len(src)), pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pub.EncryptionKey) != 32 || pub.StorageKey != pub.UUID+".epub" || pub.Location != "https://cdn.example.com/publications/"+pub.UUID+".epub" ||
+		pub.ContentType != ContentType_EPUB || int(pub.SourceSize) != len(src) || stats.EncryptedCount == 0 {
+		t.Errorf("Unexpected protected publication %+v, %+v", pub, stats)
+	}
+
+	// the stored file is the protected file
+	rc, err := st.Get(context.Background(), pub.StorageKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256(data)
+	if len(data) != int(pub.Size) || base64.StdEncoding.EncodeToString(hash[:]) != pub.Checksum {
+		t.Errorf("The stored file doesn't match the size and checksum of the publication")
+	}
+
+	// a publication keeps its content key
+	key := pub.EncryptionKey
+	if _, err = Protect(context.Background(), st, ContentType_EPUB, bytes.NewReader(src), int64(len(src)), pub); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, pub.EncryptionKey) {
+		t.Error("Expected the content key to be kept")
+	}
+
+	// an invalid file is not stored
+	other := &stor.Publication{UUID: "c02100bc-3631-4301-9afc-8272793d1fb5"}
+	if _, err = Protect(context.Background(), st, ContentType_EPUB, bytes.NewReader([]byte("not a zip")), 9, other); err == nil {
+		t.Error("Expected an error for an invalid file")
+	}
+	if _, err = st.Get(context.Background(), StorageKey(other.UUID, ContentType_EPUB)); err == nil {
+		t.Error("Expected no stored file")
+	}
+	if _, err = Protect(context.Background(), st, "text/plain", bytes.NewReader(src), int64(len(src)), other); err == nil {
+		t.Error("Expected an error for an unsupported type")
+	}
+}
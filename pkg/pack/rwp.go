@@ -0,0 +1,309 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package pack
+
+import (
+	"archive/zip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/edrlab/lcp-server/pkg/crypto"
+	log "github.com/sirupsen/logrus"
+)
+
+// Media types of Readium packages, clear and protected
+const (
+	ContentType_PDF       = "application/pdf"
+	ContentType_LCPDF     = "application/pdf+lcp"
+	ContentType_Audiobook = "application/audiobook+zip"
+	ContentType_LCPAU     = "application/audiobook+lcp"
+	ContentType_Divina    = "application/divina+zip"
+	ContentType_LCPDI     = "application/divina+lcp"
+	ContentType_LPF       = "application/lpf+zip"
+
+	ManifestFile = "manifest.json"
+	LPFManifest  = "publication.json"
+
+	// LCP encryption scheme and profile, declared in Readium manifests
+	SchemeLCP   = "http://readium.org/2014/01/lcp"
+	ProfileLCP  = "http://readium.org/lcp/basic-profile"
+	pdfResource = "publication.pdf"
+)
+
+// protectedTypes maps the media type of a publication to the media type of its protected form.
+var protectedTypes = map[string]string{
+	ContentType_PDF:       ContentType_LCPDF,
+	ContentType_Audiobook: ContentType_LCPAU,
+	ContentType_LPF:       ContentType_LCPAU,
+	ContentType_Divina:    ContentType_LCPDI,
+}
+
+// ProtectedContentType returns the media type of a publication once protected by LCP.
+// EPUB keeps its media type; unknown or already protected types are returned unchanged.
+func ProtectedContentType(contentType string) string {
+	if protected, ok := protectedTypes[contentType]; ok {
+		return protected
+	}
+	return contentType
+}
+
+// Encrypt encrypts a publication of any supported media type and writes the protected file to w.
+// The media type of the protected file is returned in the stats.
+func Encrypt(contentType string, r io.ReaderAt, size int64, w io.Writer, encrypter crypto.Encrypter, key crypto.ContentKey) (*Stats, error) {
+	switch contentType {
+	case ContentType_EPUB:
+		return EncryptEPUB(r, size, w, encrypter, key)
+	case ContentType_Audiobook, ContentType_Divina:
+		return EncryptRWP(contentType, r, size, w, encrypter, key)
+	case ContentType_LPF:
+		return EncryptLPF(r, size, w, encrypter, key)
+	case ContentType_PDF:
+		return EncryptPDF(r, size, w, encrypter, key)
+	default:
+		return nil, fmt.Errorf("unsupported publication type %q", contentType)
+	}
+}
+
+// EncryptRWP encrypts the resources of a Readium package (audiobook, Divina) and writes
+// the protected package to w. Every resource listed in the reading order or resources of
+// the manifest is encrypted; the encryption is declared in the properties of its link.
+func EncryptRWP(contentType string, r io.ReaderAt, size int64, w io.Writer, encrypter crypto.Encrypter, key crypto.ContentKey) (*Stats, error) {
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readManifest(zr, ManifestFile)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := encryptPackage(zr, manifest, w, encrypter, key)
+	if err != nil {
+		return nil, err
+	}
+	stats.SourceSize = size
+	stats.ContentType = ProtectedContentType(contentType)
+	return stats, nil
+}
+
+// EncryptLPF converts a W3C audiobook (Lightweight Packaging Format) into a Readium audiobook
+// and encrypts it. The W3C manifest is replaced by a Readium manifest.
+func EncryptLPF(r io.ReaderAt, size int64, w io.Writer, encrypter crypto.Encrypter, key crypto.ContentKey) (*Stats, error) {
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	w3c, err := readManifest(zr, LPFManifest)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := convertW3CManifest(w3c)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := encryptPackage(zr, manifest, w, encrypter, key)
+	if err != nil {
+		return nil, err
+	}
+	stats.SourceSize = size
+	stats.ContentType = ContentType_LCPAU
+	return stats, nil
+}
+
+// EncryptPDF wraps a PDF file in a Readium package and encrypts it.
+// PDF files are already compressed, therefore they are not deflated before encryption.
+func EncryptPDF(r io.ReaderAt, size int64, w io.Writer, encrypter crypto.Encrypter, key crypto.ContentKey) (*Stats, error) {
+
+	manifest := map[string]interface{}{
+		"@context": "https://readium.org/webpub-manifest/context.jsonld",
+		"metadata": map[string]interface{}{"conformsTo": "https://readium.org/webpub-manifest/profiles/pdf"},
+		"readingOrder": []interface{}{
+			map[string]interface{}{
+				"href": pdfResource,
+				"type": ContentType_PDF,
+				"properties": map[string]interface{}{
+					"encrypted": encryptedProperty(encrypter, false, uint64(size)),
+				},
+			},
+		},
+	}
+
+	hw := newHashWriter(w)
+	zw := zip.NewWriter(hw)
+
+	if err := writeManifest(zw, manifest); err != nil {
+		return nil, err
+	}
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: pdfResource, Method: zip.Store})
+	if err != nil {
+		return nil, err
+	}
+	if err = encrypter.Encrypt(key, io.NewSectionReader(r, 0, size), fw); err != nil {
+		return nil, err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{
+		SourceSize:     size,
+		Size:           hw.size,
+		Checksum:       base64.StdEncoding.EncodeToString(hw.hash.Sum(nil)),
+		EncryptedCount: 1,
+		ContentType:    ContentType_LCPDF,
+	}
+	log.Infof("PDF encrypted: %d -> %d bytes", stats.SourceSize, stats.Size)
+	return stats, nil
+}
+
+// readManifest decodes a JSON manifest found in a package.
+// The manifest is kept as a generic structure, so that unknown properties are preserved.
+func readManifest(zr *zip.Reader, name string) (map[string]interface{}, error) {
+
+	f := findFile(zr, name)
+	if f == nil {
+		return nil, errors.New("invalid package, missing " + name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	manifest := make(map[string]interface{})
+	if err = json.NewDecoder(rc).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return manifest, nil
+}
+
+// encryptPackage encrypts the resources referenced by a Readium manifest and writes the
+// protected package, with the updated manifest, to w.
+func encryptPackage(zr *zip.Reader, manifest map[string]interface{}, w io.Writer, encrypter crypto.Encrypter, key crypto.ContentKey) (*Stats, error) {
+
+	// links to the resources to encrypt, indexed by their path in the package
+	links := make(map[string]map[string]interface{})
+	for _, collection := range []string{"readingOrder", "resources"} {
+		list, _ := manifest[collection].([]interface{})
+		for _, item := range list {
+			link, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			href, _ := link["href"].(string)
+			if name, ok := packagePath(href); ok && findFile(zr, name) != nil {
+				links[name] = link
+			}
+		}
+	}
+	if len(links) == 0 {
+		return nil, errors.New("invalid package, no resource to encrypt")
+	}
+
+	// the encryption properties are known before encryption, which lets us
+	// write the manifest first
+	for _, f := range zr.File {
+		link, ok := links[f.Name]
+		if !ok {
+			continue
+		}
+		properties, _ := link["properties"].(map[string]interface{})
+		if properties == nil {
+			properties = make(map[string]interface{})
+			link["properties"] = properties
+		}
+		properties["encrypted"] = encryptedProperty(encrypter, shouldDeflate(f.Name), f.UncompressedSize64)
+	}
+
+	hw := newHashWriter(w)
+	zw := zip.NewWriter(hw)
+
+	if err := writeManifest(zw, manifest); err != nil {
+		return nil, err
+	}
+
+	var err error
+	stats := &Stats{}
+	for _, f := range zr.File {
+		if f.Name == ManifestFile || f.Name == LPFManifest || f.FileInfo().IsDir() {
+			continue
+		}
+		if _, ok := links[f.Name]; !ok {
+			if err = copyRaw(zw, f); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		deflate := shouldDeflate(f.Name)
+		if err = encryptFile(zw, f, encrypter, key, deflate); err != nil {
+			return nil, err
+		}
+		stats.EncryptedCount++
+		if deflate {
+			stats.DeflatedCount++
+		}
+	}
+
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+
+	stats.Size = hw.size
+	stats.Checksum = base64.StdEncoding.EncodeToString(hw.hash.Sum(nil))
+
+	log.Infof("Package encrypted: %d resources (%d deflated), %d bytes",
+		stats.EncryptedCount, stats.DeflatedCount, stats.Size)
+	return stats, nil
+}
+
+// encryptedProperty returns the encryption property of a link in a Readium manifest.
+func encryptedProperty(encrypter crypto.Encrypter, deflated bool, originalLength uint64) map[string]interface{} {
+	compression := "none"
+	if deflated {
+		compression = "deflate"
+	}
+	return map[string]interface{}{
+		"scheme":         SchemeLCP,
+		"profile":        ProfileLCP,
+		"algorithm":      encrypter.Signature(),
+		"compression":    compression,
+		"originalLength": originalLength,
+	}
+}
+
+// writeManifest adds a Readium manifest to a package.
+func writeManifest(zw *zip.Writer, manifest map[string]interface{}) error {
+
+	w, err := zw.Create(ManifestFile)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(manifest)
+}
+
+// packagePath returns the path in the package of a resource referenced by a relative href.
+// Absolute URLs reference remote resources, which are not encrypted.
+func packagePath(href string) (string, bool) {
+
+	u, err := url.Parse(href)
+	if err != nil || u.IsAbs() || u.Host != "" || u.Path == "" {
+		return "", false
+	}
+	name := path.Clean(strings.TrimPrefix(u.Path, "/"))
+	if strings.HasPrefix(name, "../") {
+		return "", false
+	}
+	return name, true
+}
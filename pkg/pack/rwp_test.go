@@ -0,0 +1,202 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package pack
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/crypto"
+)
+
+// newTestPackage builds a zip package in memory
+func newTestPackage(t *testing.T, files map[string]string) []byte {
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// encryptTestPackage encrypts a package and returns the resulting manifest
+func encryptTestPackage(t *testing.T, contentType string, src []byte) (*Stats, *zip.Reader, map[string]interface{}) {
+
+	encrypter := crypto.NewAESEncrypter_PUBLICATION_RESOURCES()
+	key, err := encrypter.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	stats, err := Encrypt(contentType, bytes.NewReader(src), int64(len(src)), &out, encrypter, key)
+	if err != nil {
+		t.Fatalf("Failed to encrypt the package: %v", err)
+	}
+	if stats.Size != int64(out.Len()) {
+		t.Errorf("Invalid size metrics: %d", stats.Size)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zr.File[0].Name != ManifestFile {
+		t.Error("The manifest must be the first file of the package")
+	}
+	manifest := make(map[string]interface{})
+	if err = json.Unmarshal(readFile(t, zr.File[0]), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	return stats, zr, manifest
+}
+
+// encryptedLink returns the encryption property of the n-th link of a collection
+func encryptedLink(manifest map[string]interface{}, collection string, n int) map[string]interface{} {
+	link := manifest[collection].([]interface{})[n].(map[string]interface{})
+	properties, _ := link["properties"].(map[string]interface{})
+	encrypted, _ := properties["encrypted"].(map[string]interface{})
+	return encrypted
+}
+
+func TestEncryptAudiobook(t *testing.T) {
+
+	src := newTestPackage(t, map[string]string{
+		ManifestFile: `{"metadata":{"title":"Moby Dick","duration":120},
+			"readingOrder":[{"href":"audio/track1.mp3","type":"audio/mpeg","duration":60},{"href":"audio/track2.mp3","type":"audio/mpeg","duration":60}],
+			"resources":[{"href":"toc.html","type":"text/html"},{"href":"https://example.com/cover.jpg","type":"image/jpeg"}]}`,
+		"audio/track1.mp3": "ID3 track 1",
+		"audio/track2.mp3": "ID3 track 2",
+		"toc.html":         testChapter,
+	})
+
+	stats, zr, manifest := encryptTestPackage(t, ContentType_Audiobook, src)
+	if stats.ContentType != ContentType_LCPAU {
+		t.Errorf("Expected %s, got %s", ContentType_LCPAU, stats.ContentType)
+	}
+	if stats.EncryptedCount != 3 || stats.DeflatedCount != 1 {
+		t.Errorf("Expected 3 encrypted resources, 1 deflated; got %d, %d", stats.EncryptedCount, stats.DeflatedCount)
+	}
+	if manifest["metadata"].(map[string]interface{})["title"] != "Moby Dick" {
+		t.Error("The metadata must be preserved")
+	}
+	track := encryptedLink(manifest, "readingOrder", 0)
+	if track["scheme"] != SchemeLCP || track["compression"] != "none" || track["originalLength"] != float64(len("ID3 track 1")) {
+		t.Errorf("Invalid encryption property %v", track)
+	}
+	if toc := encryptedLink(manifest, "resources", 0); toc["compression"] != "deflate" {
+		t.Errorf("Invalid encryption property %v", toc)
+	}
+	if cover := encryptedLink(manifest, "resources", 1); cover != nil {
+		t.Error("A remote resource must not be encrypted")
+	}
+	if bytes.Equal(readFile(t, findFile(zr, "audio/track1.mp3")), []byte("ID3 track 1")) {
+		t.Error("The track must be encrypted")
+	}
+}
+
+func TestEncryptLPF(t *testing.T) {
+
+	src := newTestPackage(t, map[string]string{
+		LPFManifest: `{"@context":["https://schema.org","https://www.w3.org/ns/pub-context"],"conformsTo":"https://www.w3.org/TR/audiobooks/",
+			"id":"urn:isbn:9780000000001","name":[{"value":"Flatland","language":"en"}],"author":["Edwin Abbott"],"readBy":{"type":"Person","name":"Ray Porter"},
+			"duration":"PT1H30M","readingOrder":[{"url":"part1.mp3","encodingFormat":"audio/mpeg","duration":"PT45M"},"part2.mp3"]}`,
+		"part1.mp3":  "ID3 part 1",
+		"part2.mp3":  "ID3 part 2",
+		"index.html": testChapter,
+	})
+
+	stats, zr, manifest := encryptTestPackage(t, ContentType_LPF, src)
+	if stats.ContentType != ContentType_LCPAU || stats.EncryptedCount != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if findFile(zr, LPFManifest) != nil {
+		t.Error("The W3C manifest must be replaced")
+	}
+	metadata := manifest["metadata"].(map[string]interface{})
+	if metadata["title"] != "Flatland" || metadata["duration"] != float64(5400) || metadata["identifier"] != "urn:isbn:9780000000001" {
+		t.Errorf("Invalid metadata %v", metadata)
+	}
+	if narrators, _ := metadata["narrator"].([]interface{}); len(narrators) != 1 || narrators[0] != "Ray Porter" {
+		t.Errorf("Invalid narrator %v", metadata["narrator"])
+	}
+	first := manifest["readingOrder"].([]interface{})[0].(map[string]interface{})
+	if first["href"] != "part1.mp3" || first["type"] != "audio/mpeg" || first["duration"] != float64(2700) {
+		t.Errorf("Invalid reading order item %v", first)
+	}
+	if encryptedLink(manifest, "readingOrder", 1) == nil {
+		t.Error("The second part must be encrypted")
+	}
+	if !bytes.Equal(readFile(t, findFile(zr, "index.html")), []byte(testChapter)) {
+		t.Error("A resource absent from the manifest must stay in clear")
+	}
+}
+
+func TestEncryptPDF(t *testing.T) {
+
+	src := []byte("%PDF-1.7 not really a pdf")
+	stats, zr, manifest := encryptTestPackage(t, ContentType_PDF, src)
+	if stats.ContentType != ContentType_LCPDF || stats.SourceSize != int64(len(src)) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if findFile(zr, pdfResource) == nil {
+		t.Fatal("Missing PDF file in the package")
+	}
+	if enc := encryptedLink(manifest, "readingOrder", 0); enc == nil || enc["originalLength"] != float64(len(src)) {
+		t.Errorf("Invalid encryption property %v", enc)
+	}
+}
+
+func TestEncryptUnsupported(t *testing.T) {
+
+	src := newTestPackage(t, map[string]string{"a.txt": "a"})
+	var out bytes.Buffer
+	encrypter := crypto.NewAESEncrypter_PUBLICATION_RESOURCES()
+	if _, err := Encrypt("text/plain", bytes.NewReader(src), int64(len(src)), &out, encrypter, nil); err == nil {
+		t.Error("Expected an error for an unsupported media type")
+	}
+	// a package without manifest
+	if _, err := Encrypt(ContentType_Divina, bytes.NewReader(src), int64(len(src)), &out, encrypter, nil); err == nil {
+		t.Error("Expected an error for a package without manifest")
+	}
+}
+
+func TestProtectedContentType(t *testing.T) {
+
+	for in, out := range map[string]string{
+		ContentType_EPUB:      ContentType_EPUB,
+		ContentType_PDF:       ContentType_LCPDF,
+		ContentType_LPF:       ContentType_LCPAU,
+		ContentType_Divina:    ContentType_LCPDI,
+		ContentType_LCPAU:     ContentType_LCPAU,
+		"application/unknown": "application/unknown",
+	} {
+		if got := ProtectedContentType(in); got != out {
+			t.Errorf("%s: expected %s, got %s", in, out, got)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+
+	for in, out := range map[string]float64{"PT1H": 3600, "PT2M30S": 150, "PT1.5S": 1.5} {
+		if d, ok := parseDuration(in); !ok || d != out {
+			t.Errorf("%s: expected %v, got %v", in, out, d)
+		}
+	}
+	for _, in := range []string{"PT", "P1D", "1H"} {
+		if _, ok := parseDuration(in); ok {
+			t.Errorf("%s: expected an invalid duration", in)
+		}
+	}
+}
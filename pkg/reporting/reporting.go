@@ -0,0 +1,159 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package reporting posts anonymous aggregate statistics of the licenses to reporting endpoints,
+// e.g. the endpoint of a certification body, per provider. Reports hold counts only: no user,
+// license or device identifier leaves the server.
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	log "github.com/sirupsen/logrus"
+)
+
+// Software is the name of the software sending the reports.
+const Software = "lcp-server"
+
+// Report is the payload posted to a reporting endpoint: the statistics of the licenses of a provider
+// over a period, from its start included to its end excluded.
+type Report struct {
+	Software string    `json:"software"`
+	Server   string    `json:"server"` // anonymous identifier of the server, stable across reports
+	Provider string    `json:"provider"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	stor.Statistics
+}
+
+// Reporter posts the reports of every provider at the end of each period.
+type Reporter struct {
+	Config conf.Reporting
+	Store  stor.Store
+	Client *http.Client // a client with a 30s timeout if nil
+
+	server string
+
+	mu    sync.Mutex
+	start map[string]time.Time // start of the next report, by provider
+}
+
+// NewReporter creates a reporter; the server is identified by a hash of its public base url.
+func NewReporter(c conf.Reporting, st stor.Store, publicBaseURL string) *Reporter {
+	hash := sha256.Sum256([]byte(publicBaseURL))
+	return &Reporter{
+		Config: c,
+		Store:  st,
+		server: hex.EncodeToString(hash[:8]),
+		start:  make(map[string]time.Time),
+	}
+}
+
+// Interval returns the period of a report.
+func (rp *Reporter) Interval() time.Duration {
+	if rp.Config.Interval <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(rp.Config.Interval) * time.Hour
+}
+
+// Run posts a report per provider at the end of each period, until the context is cancelled.
+func (rp *Reporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(rp.Interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := rp.Report(ctx, now); err != nil {
+				log.Errorf("Reporting failed: %v", err)
+			}
+		}
+	}
+}
+
+// Report posts the reports of the providers for the period ending at a given time.
+// The report of a provider starts at the end of its last report which was delivered, so that a period is
+// reported again after a failure; the first report of a provider covers the last interval.
+// A failure on a provider doesn't stop the reports of the others, the last error is returned.
+func (rp *Reporter) Report(ctx context.Context, end time.Time) error {
+	providers, err := rp.Store.WithContext(ctx).License().ListProviders()
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, provider := range providers {
+		endpoint := rp.Config.ProviderEndpoint(provider)
+		if endpoint.URL == "" {
+			continue
+		}
+		rp.mu.Lock()
+		start, ok := rp.start[provider]
+		rp.mu.Unlock()
+		if !ok {
+			start = end.Add(-rp.Interval())
+		}
+		if err := rp.post(ctx, endpoint, provider, start, end); err != nil {
+			log.Errorf("Failed to report the statistics of %s: %v", provider, err)
+			lastErr = err
+			continue
+		}
+		rp.mu.Lock()
+		rp.start[provider] = end
+		rp.mu.Unlock()
+	}
+	return lastErr
+}
+
+// post sends the report of a provider to an endpoint
+func (rp *Reporter) post(ctx context.Context, endpoint conf.Endpoint, provider string, start, end time.Time) error {
+	stats, err := rp.Store.WithContext(ctx).License().Statistics(provider, start, end)
+	if err != nil {
+		return err
+	}
+	report := &Report{
+		Software:   Software,
+		Server:     rp.server,
+		Provider:   provider,
+		Start:      start.UTC(),
+		End:        end.UTC(),
+		Statistics: *stats,
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if endpoint.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+endpoint.Token)
+	}
+	client := rp.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the endpoint %s returned the status %d", endpoint.URL, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package reporting
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/stortest"
+)
+
+func TestReport(t *testing.T) {
+
+	st := stortest.SQLite()(t)
+	pub := stortest.CreatePublications(t, st, 1, "application/epub+zip")[0]
+	licenses := stortest.CreateLicenses(t, st, 2, pub.UUID, "user1")
+	other := stortest.NewLicense(pub.UUID, "user2")
+	other.Provider = "https://optout.example.com"
+	if err := st.License().Create(other); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var reports []Report
+	var body string
+	status := http.StatusOK
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		var report Report
+		if err := json.Unmarshal(data, &report); err != nil {
+			t.Error(err)
+		}
+		body = string(data)
+		reports = append(reports, report)
+		w.WriteHeader(status)
+	}))
+	defer endpoint.Close()
+
+	c := conf.Reporting{
+		Endpoint:  conf.Endpoint{URL: endpoint.URL, Token: "secret"},
+		Providers: map[string]conf.Endpoint{"https://optout.example.com": {}},
+	}
+	rp := NewReporter(c, st, "https://lcp.example.com")
+	end := time.Now().Add(time.Minute)
+	if err := rp.Report(context.Background(), end); err != nil {
+		t.Fatal(err)
+	}
+
+	// a report of the licenses of the provider which did not opt out
+	if len(reports) != 1 {
+		t.Fatalf("Expected 1 report, got %d", len(reports))
+	}
+	report := reports[0]
+	if report.Provider != licenses[0].Provider || report.Licenses[stor.STATUS_READY] != 2 || report.Software != Software || report.Server == "" {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if !report.End.Equal(end) || report.End.Sub(report.Start) != 24*time.Hour {
+		t.Errorf("Expected a report over the last day, got %v - %v", report.Start, report.End)
+	}
+	if strings.Contains(body, licenses[0].UUID) || strings.Contains(body, "user1") {
+		t.Errorf("Expected an anonymous report, got %s", body)
+	}
+
+	// a failed report is covered by the next one
+	status = http.StatusServiceUnavailable
+	if err := rp.Report(context.Background(), end.Add(time.Hour)); err == nil {
+		t.Error("Expected an error")
+	}
+	status = http.StatusOK
+	if err := rp.Report(context.Background(), end.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if last := reports[len(reports)-1]; !last.Start.Equal(report.End) {
+		t.Errorf("Expected the report to start at %v, got %v", report.End, last.Start)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package revocation propagates the revocations of licenses to the channels of the configuration, e.g. the
+// callback of a provider or the service publishing a revocation list. A revoked license stays "revoking"
+// until every channel confirmed the revocation, by a 2xx response; failed propagations are retried.
+// The cancellation of a voided license, e.g. after a refund, is propagated the same way, the license being
+// cancelled at once.
+package revocation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	log "github.com/sirupsen/logrus"
+)
+
+// BatchSize is the max number of propagations attempted per round.
+const BatchSize = 100
+
+// Notice is the payload posted to a channel.
+type Notice struct {
+	LicenseID string     `json:"license_id"`
+	Provider  string     `json:"provider"`
+	Status    string     `json:"status"`              // revoked, or cancelled for a voided license
+	Revoked   *time.Time `json:"revoked"`             // end of the license
+	OrderRef  string     `json:"order_ref,omitempty"` // reference of the order of the license, e.g. a refunded order
+}
+
+// Propagator posts the pending revocations to their channels at each interval.
+type Propagator struct {
+	Config conf.Revocation
+	Store  stor.Store
+	Client *http.Client     // a client with a 30s timeout if nil
+	Clock  func() time.Time // returns the current time; time.Now if nil
+}
+
+// NewPropagator creates a propagator.
+func NewPropagator(c conf.Revocation, st stor.Store) *Propagator {
+	return &Propagator{
+		Config: c,
+		Store:  st,
+		Clock:  time.Now,
+	}
+}
+
+// Interval returns the time between two rounds of propagation.
+func (p *Propagator) Interval() time.Duration {
+	if p.Config.Interval <= 0 {
+		return time.Minute
+	}
+	return time.Duration(p.Config.Interval) * time.Second
+}
+
+// Run propagates the pending revocations at each interval, until the context is cancelled.
+func (p *Propagator) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.Propagate(ctx); err != nil {
+				log.Errorf("Revocation propagation failed: %v", err)
+			}
+		}
+	}
+}
+
+// Propagate posts the pending revocations to their channels, and records the confirmations and failures.
+// A failure on a channel doesn't stop the other propagations, the last error is returned.
+func (p *Propagator) Propagate(ctx context.Context) error {
+	st := p.Store.WithContext(ctx)
+	pending, err := st.Propagation().Pending(BatchSize)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for i := range *pending {
+		propagation := &(*pending)[i]
+		err := p.post(ctx, propagation)
+		if err != nil {
+			log.Errorf("Failed to propagate the revocation of %s to %s: %v", propagation.LicenseID, propagation.Channel, err)
+			lastErr = err
+			if err = st.Propagation().Fail(propagation, err); err != nil {
+				return err
+			}
+			continue
+		}
+		done, err := st.Propagation().Confirm(propagation, stor.Now(p.Clock))
+		if err != nil {
+			return err
+		}
+		if done {
+			log.Infof("License %s revoked; the revocation is propagated to every channel", propagation.LicenseID)
+		}
+	}
+	return lastErr
+}
+
+// post notifies a channel of a revocation
+func (p *Propagator) post(ctx context.Context, propagation *stor.Propagation) error {
+	endpoint, ok := p.Config.Channels[propagation.Channel]
+	if !ok {
+		return fmt.Errorf("unknown channel %s", propagation.Channel)
+	}
+	license, err := p.Store.WithContext(ctx).License().Get(propagation.LicenseID)
+	if err != nil {
+		return err
+	}
+	// a revoking license is revoked once the revocation is propagated, a voided license is cancelled
+	status := stor.STATUS_REVOKED
+	if license.Status == stor.STATUS_CANCELLED {
+		status = stor.STATUS_CANCELLED
+	}
+	data, err := json.Marshal(&Notice{
+		LicenseID: license.UUID,
+		Provider:  license.Provider,
+		Status:    status,
+		Revoked:   license.End,
+		OrderRef:  license.OrderRef,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if endpoint.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+endpoint.Token)
+	}
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the channel %s returned the status %d", endpoint.URL, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package revocation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/stortest"
+)
+
+func TestPropagate(t *testing.T) {
+
+	st := stortest.SQLite()(t)
+	pub := stortest.CreatePublications(t, st, 1, "application/epub+zip")[0]
+	license := stortest.CreateLicenses(t, st, 1, pub.UUID, "user1")[0]
+	license.Status = stor.STATUS_ACTIVE
+	if err := st.License().Update(license); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var notices []Notice
+	status := http.StatusServiceUnavailable
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var notice Notice
+		if err := json.NewDecoder(r.Body).Decode(&notice); err != nil {
+			t.Error(err)
+		}
+		notices = append(notices, notice)
+		w.WriteHeader(status)
+	}))
+	defer provider.Close()
+	crl := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer crl.Close()
+
+	c := conf.Revocation{Channels: map[string]conf.Endpoint{
+		"provider": {URL: provider.URL, Token: "secret"},
+		"crl":      {URL: crl.URL},
+	}}
+
+	// the revoked license is revoking, but already revoked for reading systems
+	lh := lic.NewLicenseHandler(&conf.Config{Revocation: c}, st)
+	statusDoc, err := lh.Revoke(license.UUID, lic.Revocation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := st.License().Get(license.UUID); l.Status != stor.STATUS_REVOKING || statusDoc.Status != stor.STATUS_REVOKED {
+		t.Errorf("Expected a revoking license, got %s and a %s status document", l.Status, statusDoc.Status)
+	}
+
+	// the license is revoked once every channel confirmed the revocation
+	p := NewPropagator(c, st)
+	if err = p.Propagate(context.Background()); err == nil {
+		t.Error("Expected an error")
+	}
+	if l, _ := st.License().Get(license.UUID); l.Status != stor.STATUS_REVOKING {
+		t.Errorf("Expected a revoking license, got %s", l.Status)
+	}
+	status = http.StatusOK
+	if err = p.Propagate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := st.License().Get(license.UUID); l.Status != stor.STATUS_REVOKED {
+		t.Errorf("Expected a revoked license, got %s", l.Status)
+	}
+	if len(notices) != 2 || notices[1].LicenseID != license.UUID || notices[1].Status != stor.STATUS_REVOKED || notices[1].Revoked == nil {
+		t.Errorf("Unexpected notices %+v", notices)
+	}
+	propagations, _ := st.Propagation().List(license.UUID)
+	if len(*propagations) != 2 || (*propagations)[0].Attempts != 1 || (*propagations)[1].Attempts != 2 {
+		t.Errorf("Unexpected propagations %+v", *propagations)
+	}
+
+	// nothing is left to propagate
+	if err = p.Propagate(context.Background()); err != nil || len(notices) != 2 {
+		t.Errorf("Expected no propagation, got %v, %d notices", err, len(notices))
+	}
+}
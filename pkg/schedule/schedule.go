@@ -0,0 +1,103 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package schedule executes the status changes scheduled on licenses, e.g. the revocation ending a promotion
+// at midnight, without anybody online. An action is executed once, at the first check following its time;
+// a failed action, e.g. the revocation of a license returned meanwhile, records its error and is not retried.
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/lic"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	log "github.com/sirupsen/logrus"
+)
+
+// BatchSize is the max number of actions executed per round.
+const BatchSize = 100
+
+// Scheduler executes the due actions at each interval.
+type Scheduler struct {
+	Config *conf.Config
+	Store  stor.Store
+	Clock  func() time.Time // returns the current time; time.Now if nil
+}
+
+// NewScheduler creates a scheduler.
+func NewScheduler(c *conf.Config, st stor.Store) *Scheduler {
+	return &Scheduler{
+		Config: c,
+		Store:  st,
+		Clock:  time.Now,
+	}
+}
+
+// Interval returns the time between two checks of the due actions.
+func (s *Scheduler) Interval() time.Duration {
+	if s.Config.Schedule.Interval <= 0 {
+		return time.Minute
+	}
+	return time.Duration(s.Config.Schedule.Interval) * time.Second
+}
+
+// Run executes the due actions at each interval, until the context is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Execute(ctx); err != nil {
+				log.Errorf("Scheduled actions failed: %v", err)
+			}
+		}
+	}
+}
+
+// Execute executes the due actions, and records their execution. The failure of an action doesn't stop
+// the other actions; an error is returned only if an execution cannot be recorded.
+func (s *Scheduler) Execute(ctx context.Context) error {
+	st := s.Store.WithContext(ctx)
+	now := stor.Now(s.Clock)
+	due, err := st.Action().Due(now, BatchSize)
+	if err != nil {
+		return err
+	}
+	for i := range *due {
+		action := &(*due)[i]
+		// the change is audited as made by the actor who scheduled it
+		lh := lic.NewLicenseHandler(s.Config, s.Store.WithContext(stor.WithActor(ctx, action.Actor)))
+		lh.Clock = s.Clock
+		err := execute(lh, action)
+		if err != nil {
+			log.Errorf("Failed to execute the scheduled %s of %s: %v", action.Type, action.LicenseID, err)
+		} else {
+			log.Infof("Scheduled %s of %s executed", action.Type, action.LicenseID)
+		}
+		if err = st.Action().Done(action, now, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execute changes the status of a license as scheduled
+func execute(lh *lic.LicenseHandler, action *stor.Action) error {
+	var err error
+	switch action.Type {
+	case stor.ACTION_REVOKE:
+		_, err = lh.Revoke(action.LicenseID, lic.Revocation{Reason: action.Reason, Actor: action.Actor})
+	case stor.ACTION_RENEW:
+		_, err = lh.Renew(action.LicenseID, &lic.DeviceInfo{ID: "admin", Name: "system"}, action.End)
+	default:
+		err = fmt.Errorf("unknown action %s", action.Type)
+	}
+	return err
+}
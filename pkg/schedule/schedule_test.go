@@ -0,0 +1,80 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package schedule
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/stortest"
+)
+
+func TestExecute(t *testing.T) {
+
+	st := stortest.SQLite()(t)
+	pub := stortest.CreatePublications(t, st, 1, "application/epub+zip")[0]
+	licenses := stortest.CreateLicenses(t, st, 2, pub.UUID, "user1")
+	licenses[0].Status = stor.STATUS_ACTIVE
+	if err := st.License().Update(licenses[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2023, 6, 30, 22, 0, 0, 0, time.UTC)
+	midnight := now.Add(2 * time.Hour)
+	actions := []stor.Action{
+		{LicenseID: licenses[0].UUID, Type: stor.ACTION_REVOKE, At: midnight, Reason: "End of the promotion"},
+		{LicenseID: licenses[1].UUID, Type: stor.ACTION_RENEW, At: midnight}, // a ready license cannot be renewed
+		{LicenseID: licenses[1].UUID, Type: stor.ACTION_REVOKE, At: midnight.Add(time.Hour)},
+	}
+	for i := range actions {
+		if err := st.Action().Create(&actions[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := NewScheduler(&conf.Config{}, st)
+	s.Clock = func() time.Time { return now }
+
+	// nothing is due before its time
+	if err := s.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := st.License().Get(licenses[0].UUID); l.Status != stor.STATUS_ACTIVE {
+		t.Errorf("Expected an active license, got %s", l.Status)
+	}
+
+	// the due actions are executed once, the failed ones record their error
+	now = midnight
+	if err := s.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := st.License().Get(licenses[0].UUID); l.Status != stor.STATUS_REVOKED || l.End == nil || !l.End.Equal(midnight) {
+		t.Errorf("Expected a license revoked at midnight, got %+v", l)
+	}
+	if events, _ := st.Event().List(licenses[0].UUID); len(*events) != 1 || (*events)[0].Reason != "End of the promotion" {
+		t.Errorf("Expected a revocation event, got %+v", events)
+	}
+	if a, _ := st.Action().Get(licenses[1].UUID, actions[1].ID); a.Executed == nil || a.Error == "" {
+		t.Errorf("Expected a failed renew, got %+v", a)
+	}
+	if a, _ := st.Action().Get(licenses[1].UUID, actions[2].ID); a.Executed != nil {
+		t.Errorf("Expected a pending revocation, got %+v", a)
+	}
+
+	// a ready license is cancelled
+	now = midnight.Add(time.Hour)
+	if err := s.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := st.License().Get(licenses[1].UUID); l.Status != stor.STATUS_CANCELLED {
+		t.Errorf("Expected a cancelled license, got %s", l.Status)
+	}
+	if due, _ := st.Action().Due(now, BatchSize); len(*due) != 0 {
+		t.Errorf("Expected no due action, got %+v", *due)
+	}
+}
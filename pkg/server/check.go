@@ -0,0 +1,155 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/sign"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/storage"
+)
+
+// CheckResult is the outcome of a startup check.
+type CheckResult struct {
+	Name string
+	Err  error // nil if the check passed
+}
+
+// Check verifies that the server can run with a configuration: required settings, database connectivity
+// and schema, certificate validity, access to the storage of publications and signature of a document.
+// Every check is run, except those depending on a failed one.
+func Check(c *conf.Config) []CheckResult {
+	var results []CheckResult
+	add := func(name string, err error) bool {
+		results = append(results, CheckResult{Name: name, Err: err})
+		return err == nil
+	}
+
+	add("configuration", c.Validate())
+
+	if st, err := openStore(c); add("database", err) {
+		add("database schema", st.Check())
+	}
+
+	if cert, err := checkCertificate(c.Certificate); add("certificate", err) {
+		add("signer", checkSigner(cert))
+	}
+
+	if c.Storage.Enabled() {
+		add("storage", checkStorage(c.Storage.FileStorage))
+	}
+	if c.Storage.Cold.Enabled() {
+		add("cold storage", checkStorage(c.Storage.Cold))
+	}
+	for name, region := range c.Regions {
+		if region.Storage.Enabled() {
+			add("storage of the region "+name, checkStorage(region.Storage))
+		}
+	}
+	return results
+}
+
+// checkCertificate loads the certificate and verifies that it is currently valid
+func checkCertificate(c conf.Certificate) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if now.Before(leaf.NotBefore) {
+		return nil, fmt.Errorf("the certificate is not valid before %s", leaf.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(leaf.NotAfter) {
+		return nil, fmt.Errorf("the certificate expired on %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	return &cert, nil
+}
+
+// checkSigner signs a document and verifies the signature
+func checkSigner(cert *tls.Certificate) error {
+	signer, err := sign.NewSigner(cert)
+	if err != nil {
+		return err
+	}
+	doc := map[string]string{"check": "lcpserver"}
+	sig, err := signer.Sign(doc)
+	if err != nil {
+		return err
+	}
+	checker, err := sign.NewSignChecker(sig.Certificate, sig.Algorithm)
+	if err != nil {
+		return err
+	}
+	return checker.Check(doc, sig.Value)
+}
+
+// checkStorage writes, reads and deletes a file in a storage
+func checkStorage(c conf.FileStorage) error {
+	st, err := storage.Open(c)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	key := ".lcpserver-check"
+	if _, err = st.Put(ctx, key, strings.NewReader("check")); err != nil {
+		return err
+	}
+	defer st.Delete(ctx, key)
+	rc, err := st.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+	if string(data) != "check" {
+		return errors.New("the content read differs from the content written")
+	}
+	return nil
+}
+
+// openStore opens the database, and the databases of the data residency regions, and applies the pending schema
+// migrations unless they are run with the migrate command
+func openStore(c *conf.Config) (stor.Store, error) {
+	st, err := openDatabase(c, c.Dsn)
+	if err != nil || len(c.Regions) == 0 {
+		return st, err
+	}
+	regions := make(map[string]stor.Store)
+	for name, region := range c.Regions {
+		rs, err := openDatabase(c, region.Dsn)
+		if err != nil {
+			return nil, fmt.Errorf("database of the region %s: %w", name, err)
+		}
+		for _, provider := range region.Providers {
+			regions[provider] = rs
+		}
+	}
+	return stor.NewShardedStore(st, regions), nil
+}
+
+// openDatabase opens a database, and applies its pending schema migrations unless they are run
+// with the migrate command
+func openDatabase(c *conf.Config, dsn string) (stor.Store, error) {
+	if c.ManualMigrate {
+		return stor.DBOpen(dsn)
+	}
+	return stor.DBSetup(dsn)
+}
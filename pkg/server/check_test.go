@@ -0,0 +1,65 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package server
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/testca"
+)
+
+// writeCertificate writes a test provider certificate valid until notAfter, and its private key
+func writeCertificate(t *testing.T, notAfter time.Time) conf.Certificate {
+	ca, err := testca.New("LCP check CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := ca.Provider("https://provider.example.com", notAfter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	c := conf.Certificate{Cert: filepath.Join(dir, "cert.pem"), PrivateKey: filepath.Join(dir, "key.pem")}
+	if err = provider.WriteFiles(c.Cert, c.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCheck(t *testing.T) {
+
+	c := testConfig()
+	c.Dsn = "sqlite3://file:server-check?mode=memory&cache=shared"
+	c.Certificate = writeCertificate(t, time.Now().AddDate(1, 0, 0))
+	c.Storage.Path = t.TempDir()
+
+	results := Check(c)
+	if len(results) != 6 {
+		t.Errorf("Expected 6 checks, got %v", results)
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("The %s check failed: %v", r.Name, r.Err)
+		}
+	}
+
+	// an expired certificate fails, and the signer is not checked
+	c.Certificate = writeCertificate(t, time.Now().AddDate(0, 0, -1))
+	c.Storage.Path = ""
+	c.PublicBaseUrl = ""
+	failed := make(map[string]bool)
+	for _, r := range Check(c) {
+		failed[r.Name] = r.Err != nil
+	}
+	if !failed["configuration"] || !failed["certificate"] || failed["database"] {
+		t.Errorf("Unexpected results %v", failed)
+	}
+	if _, ok := failed["signer"]; ok {
+		t.Error("The signer must not be checked without a valid certificate")
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package server
+
+import (
+	"flag"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/edrlab/lcp-server/pkg/apitest"
+	"github.com/edrlab/lcp-server/pkg/conf"
+)
+
+// run "go test ./pkg/server -run TestGoldenAPI -update" to regenerate the golden responses,
+// only when a change of the API payloads is intended; add "-target <url> -user <user> -password <password>"
+// to replay the script against a live server instead of a test one.
+var (
+	update   = flag.Bool("update", false, "update the golden API responses")
+	target   = flag.String("target", "", "url of a live server replaying the API script")
+	user     = flag.String("user", "", "admin user of the live server")
+	password = flag.String("password", "", "admin password of the live server")
+)
+
+const goldenAPI = "../test/golden/api"
+
+func TestGoldenAPI(t *testing.T) {
+
+	runner := &apitest.Runner{BaseURL: *target, User: *user, Password: *password, GoldenDir: goldenAPI}
+	if *target == "" {
+		c := testConfig()
+		c.Dsn = "sqlite3://file:server-golden?mode=memory&cache=shared"
+		c.Storage.Path = t.TempDir()
+		c.License = conf.License{Provider: "https://provider.example.com", HintLink: "https://provider.example.com/hint", Profile: "http://readium.org/lcp/basic-profile"}
+		c.Status = conf.Status{RenewDefaultDays: 7, RenewMaxDays: 40}
+		s, err := New(c)
+		if err != nil {
+			t.Fatalf("Failed to create the server: %v", err)
+		}
+		ts := httptest.NewServer(s.Router)
+		defer ts.Close()
+		runner.BaseURL, runner.User, runner.Password = ts.URL, c.Login.User, c.Login.Password
+		runner.Update = *update
+	}
+	runner.Run(t, apitest.Script())
+}
@@ -0,0 +1,261 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Serve starts the listeners of the configuration: the public listener, and the admin listener
+// if the admin routes are served separately. Sockets passed by systemd socket activation take
+// precedence over the configuration, and systemd is notified once the server is ready.
+// It returns as soon as a listener fails, or nil once Shutdown is called.
+func (s *Server) Serve() error {
+
+	activated, err := systemdListeners()
+	if err != nil {
+		return err
+	}
+
+	public := s.Config.Listener
+	if public.Port == 0 {
+		public.Port = 8081
+	}
+	type binding struct {
+		config  conf.Listener
+		handler http.Handler
+		l       net.Listener
+	}
+	bindings := map[string]*binding{"public": {config: public, handler: s.Router}}
+	if s.AdminRouter != nil {
+		bindings["admin"] = &binding{config: s.Config.Admin.Listener, handler: s.AdminRouter}
+	}
+	for name, b := range bindings {
+		if l, ok := activated[name]; ok {
+			b.l = l
+		} else if b.l, err = listener(b.config); err != nil {
+			return err
+		}
+	}
+
+	// the servers are registered for Shutdown, unless it was called meanwhile
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		for _, b := range bindings {
+			b.l.Close()
+		}
+		return nil
+	}
+	errc := make(chan error, 2*len(bindings))
+	for _, b := range bindings {
+		if acme := b.config.TLS.ACME; acme.Enabled() {
+			m := acmeManager(acme)
+			b.l = tls.NewListener(b.l, m.TLSConfig())
+			if acme.HTTPPort != 0 {
+				// HTTP-01 challenges, and redirection of the other requests to https
+				challenges := &http.Server{Addr: net.JoinHostPort(b.config.Host, strconv.Itoa(acme.HTTPPort)), Handler: m.HTTPHandler(nil)}
+				s.servers = append(s.servers, challenges)
+				go func() { errc <- challenges.ListenAndServe() }()
+			}
+		}
+		srv := &http.Server{Handler: b.handler}
+		s.servers = append(s.servers, srv)
+		go func(b *binding) {
+			if b.config.TLS.Cert != "" {
+				errc <- srv.ServeTLS(b.l, b.config.TLS.Cert, b.config.TLS.PrivateKey)
+				return
+			}
+			errc <- srv.Serve(b.l)
+		}(b)
+	}
+	s.mu.Unlock()
+
+	// systemd is notified once the warmup is completed
+	if s.warmed != nil {
+		select {
+		case <-s.warmed:
+		case err = <-errc:
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return err
+		}
+	}
+	if err = notify("READY=1"); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+	if err = <-errc; errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops the server gracefully, e.g. during a rolling deploy: the listeners stop accepting connections,
+// the requests in process are completed, e.g. license creations, then the background tasks and the bulk jobs are
+// stopped, and the connections to the database are closed, unless the store was provided by WithStore.
+// If the context expires before the requests are completed, the remaining connections are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	servers := s.servers
+	s.mu.Unlock()
+	if err := notify("STOPPING=1"); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+
+	// drain the requests in process
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			err := srv.Shutdown(ctx)
+			if err != nil {
+				srv.Close()
+			}
+			errs <- err
+		}(srv)
+	}
+	var err error
+	for range servers {
+		if e := <-errs; e != nil && err == nil {
+			err = fmt.Errorf("failed to drain the requests in process: %w", e)
+		}
+	}
+
+	// stop the background tasks and the jobs, which use the database
+	if s.stop != nil {
+		s.stop()
+	}
+	s.tasks.Wait()
+	if s.warmed != nil {
+		<-s.warmed
+	}
+	if s.jobs != nil {
+		s.jobs.Stop()
+	}
+	if s.responses != nil {
+		s.responses.Close()
+	}
+	if s.publisher != nil {
+		s.publisher.Close()
+	}
+	if s.ownStore {
+		if e := stor.Close(s.Store); e != nil && err == nil {
+			err = e
+		}
+	}
+	if s.flushTraces != nil {
+		if e := s.flushTraces(ctx); e != nil && err == nil {
+			err = fmt.Errorf("failed to export the traces: %w", e)
+		}
+	}
+	return err
+}
+
+// acmeManager returns the manager of the certificates of a listener obtained by ACME. The certificates are
+// requested at the first TLS handshake for one of the domains, kept in the cache directory, and renewed
+// before they expire.
+func acmeManager(c conf.ACME) *autocert.Manager {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(c.Domains...),
+		Cache:      autocert.DirCache(c.CacheDir),
+		Email:      c.Email,
+	}
+	if c.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: c.DirectoryURL}
+	}
+	return m
+}
+
+// listener opens the network socket of a listener: a Unix domain socket if a path is set,
+// otherwise a tcp socket.
+func listener(l conf.Listener) (net.Listener, error) {
+	if l.Socket == "" {
+		return net.Listen("tcp", net.JoinHostPort(l.Host, strconv.Itoa(l.Port)))
+	}
+	// a socket file left by a previous process prevents binding
+	if fi, err := os.Stat(l.Socket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(l.Socket)
+	}
+	return net.Listen("unix", l.Socket)
+}
+
+// systemdListeners returns the sockets passed by systemd socket activation, by name: "public" or "admin",
+// set as FileDescriptorName in the socket unit. Sockets without one of these names are named by position,
+// the first one being the public socket. It returns nil if the server was not activated by systemd.
+func systemdListeners() (map[string]net.Listener, error) {
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	// sockets are not passed to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	positions := []string{"public", "admin"}
+	listeners := make(map[string]net.Listener)
+	for i := 0; i < n; i++ {
+		name := ""
+		if i < len(names) && (names[i] == "public" || names[i] == "admin") {
+			name = names[i]
+		} else if i < len(positions) {
+			name = positions[i]
+		} else {
+			return nil, fmt.Errorf("unexpected socket %d passed by systemd", i)
+		}
+		// passed file descriptors start at 3
+		f := os.NewFile(uintptr(3+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s socket passed by systemd: %w", name, err)
+		}
+		listeners[name] = l
+	}
+	return listeners, nil
+}
+
+// notify sends a state to systemd, e.g. "READY=1", if the server runs as a notify service.
+func notify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	// abstract socket
+	if strings.HasPrefix(addr, "@") {
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return errors.New("failed to write to the notification socket")
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+)
+
+func TestUnixSocket(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "lcp.sock")
+	l, err := listener(conf.Listener{Socket: path})
+	if err != nil {
+		t.Fatalf("Failed to listen on a Unix socket: %v", err)
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to connect to the Unix socket: %v", err)
+	}
+	conn.Close()
+
+	// a socket file left by a previous process is replaced
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	if l, err = listener(conf.Listener{Socket: path}); err != nil {
+		t.Fatalf("Failed to replace a stale Unix socket: %v", err)
+	}
+	l.Close()
+}
+
+func TestNotify(t *testing.T) {
+
+	// not run by systemd
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := notify("READY=1"); err != nil {
+		t.Error(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+	if err = notify("READY=1"); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+	buf := make([]byte, 64)
+	n, _ := conn.Read(buf)
+	if string(buf[:n]) != "READY=1" {
+		t.Errorf("Unexpected notification %q", buf[:n])
+	}
+}
+
+func TestNotActivated(t *testing.T) {
+
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "2")
+	listeners, err := systemdListeners()
+	if err != nil || listeners != nil {
+		t.Errorf("Sockets passed to another process must be ignored, got %v, %v", listeners, err)
+	}
+}
+
+func TestACMEManager(t *testing.T) {
+
+	m := acmeManager(conf.ACME{Domains: []string{"lcp.example.com"}, CacheDir: t.TempDir(),
+		DirectoryURL: "https://acme-staging-v02.api.letsencrypt.org/directory"})
+	if m.Client == nil || m.Client.DirectoryURL != "https://acme-staging-v02.api.letsencrypt.org/directory" {
+		t.Error("Expected the directory of the configuration")
+	}
+
+	// certificates are only requested for the configured domains
+	if err := m.HostPolicy(context.Background(), "lcp.example.com"); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if err := m.HostPolicy(context.Background(), "other.example.com"); err == nil {
+		t.Error("Expected an unknown domain to be rejected")
+	}
+
+	// requests which are not challenges are redirected to https
+	rr := httptest.NewRecorder()
+	m.HTTPHandler(nil).ServeHTTP(rr, httptest.NewRequest("GET", "http://lcp.example.com/licenses/123/status", nil))
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "https://lcp.example.com/licenses/123/status" {
+		t.Errorf("Expected a redirection to https, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+}
+
+func TestShutdown(t *testing.T) {
+
+	c := testConfig()
+	c.Dsn = "sqlite3://file:server-shutdown?mode=memory&cache=shared"
+	c.Socket = filepath.Join(t.TempDir(), "lcp.sock")
+	started, release := make(chan struct{}), make(chan struct{})
+	slow := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("slow") != "" {
+				close(started)
+				<-release
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	s, err := New(c, WithMiddleware(slow))
+	if err != nil {
+		t.Fatalf("Failed to create the server: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve() }()
+
+	client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return net.Dial("unix", c.Socket)
+	}}}
+	for i := 0; ; i++ {
+		if conn, err := net.Dial("unix", c.Socket); err == nil {
+			conn.Close()
+			break
+		} else if i == 100 {
+			t.Fatalf("The server is not listening: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a request in process is completed before the server stops
+	codes := make(chan int, 1)
+	go func() {
+		resp, err := client.Get("http://lcp/?slow=1")
+		if err != nil {
+			codes <- 0
+			return
+		}
+		resp.Body.Close()
+		codes <- resp.StatusCode
+	}()
+	<-started
+	stopped := make(chan error, 1)
+	go func() { stopped <- s.Shutdown(context.Background()) }()
+	select {
+	case <-stopped:
+		t.Fatal("The server stopped before the request in process was completed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if code := <-codes; code != http.StatusOK {
+		t.Errorf("Expected the request in process to succeed, got %d", code)
+	}
+	if err = <-stopped; err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if err = <-served; err != nil {
+		t.Errorf("Expected Serve to return nil, got %v", err)
+	}
+
+	// new connections are refused, and the database is closed
+	if _, err = net.Dial("unix", c.Socket); err == nil {
+		t.Error("Expected the listener to be closed")
+	}
+	if err = s.Store.Check(); err == nil {
+		t.Error("Expected the database to be closed")
+	}
+}
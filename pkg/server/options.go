@@ -0,0 +1,60 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/edrlab/lcp-server/pkg/sign"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/storage"
+)
+
+// Option customizes a server created by New.
+type Option func(*Server)
+
+// WithMiddleware adds middlewares to every route, after the default middlewares
+// (logger, recoverer, proxy headers).
+func WithMiddleware(middlewares ...func(http.Handler) http.Handler) Option {
+	return func(s *Server) {
+		s.middlewares = append(s.middlewares, middlewares...)
+	}
+}
+
+// WithAuth replaces the basic authentication of the private routes.
+func WithAuth(auth func(http.Handler) http.Handler) Option {
+	return func(s *Server) {
+		s.auth = auth
+	}
+}
+
+// WithStore replaces the database set up from the configuration.
+func WithStore(st stor.Store) Option {
+	return func(s *Server) {
+		s.Store = st
+	}
+}
+
+// WithCertificate replaces the certificate loaded from the configuration.
+func WithCertificate(cert *tls.Certificate) Option {
+	return func(s *Server) {
+		s.Cert = cert
+	}
+}
+
+// WithSigner replaces the signer of licenses, e.g. by a signer delegating to an HSM.
+func WithSigner(signer sign.Signer) Option {
+	return func(s *Server) {
+		s.Signer = signer
+	}
+}
+
+// WithTiering replaces the storage of publications set up from the configuration.
+func WithTiering(t *storage.Tiering) Option {
+	return func(s *Server) {
+		s.Tiering = t
+	}
+}
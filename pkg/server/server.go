@@ -0,0 +1,957 @@
+// Copyright 2022 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package server assembles the LCP Server: database, signer, storage and routes.
+// Applications embedding the server customize it via options.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/sirupsen/logrus"
+
+	"github.com/edrlab/lcp-server/pkg/api"
+	"github.com/edrlab/lcp-server/pkg/bus"
+	"github.com/edrlab/lcp-server/pkg/cache"
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/export"
+	"github.com/edrlab/lcp-server/pkg/fault"
+	"github.com/edrlab/lcp-server/pkg/job"
+	"github.com/edrlab/lcp-server/pkg/jwt"
+	"github.com/edrlab/lcp-server/pkg/oauth"
+	"github.com/edrlab/lcp-server/pkg/reporting"
+	"github.com/edrlab/lcp-server/pkg/revocation"
+	"github.com/edrlab/lcp-server/pkg/schedule"
+	"github.com/edrlab/lcp-server/pkg/sign"
+	"github.com/edrlab/lcp-server/pkg/slo"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/storage"
+	"github.com/edrlab/lcp-server/pkg/tracing"
+	"github.com/edrlab/lcp-server/pkg/webhook"
+)
+
+// Server context
+type Server struct {
+	*conf.Config
+	stor.Store
+	Cert        *tls.Certificate
+	Signer      sign.Signer // signs licenses; created from the certificate if nil, then capped by the signer limits
+	Tiering     *storage.Tiering
+	Regions     map[string]*storage.Tiering // storage of the publications of the data residency regions, by provider URI
+	Router      *chi.Mux                    // public routes, and admin routes unless they are served separately
+	AdminRouter *chi.Mux                    // admin routes, if they are served by a separate listener
+
+	middlewares []func(http.Handler) http.Handler // added to the default middlewares
+	auth        func(http.Handler) http.Handler   // protects the private routes
+
+	indicators  *slo.Indicators                 // service level indicators of the routes
+	responses   cache.Cache                     // caches the responses of the public routes, if enabled
+	publisher   bus.Publisher                   // publishes the events of the licenses to a message bus, if enabled
+	flushTraces func(ctx context.Context) error // exports the pending spans at shutdown, if the requests are traced
+
+	ownStore bool               // the store was opened by the server, which closes it at shutdown
+	warmed   <-chan struct{}    // closed once the warmup is completed
+	jobs     *job.Runner        // executes the bulk operations of the routes
+	ctx      context.Context    // context of the background tasks, cancelled at shutdown
+	stop     context.CancelFunc // stops the background tasks
+	tasks    sync.WaitGroup     // running background tasks
+
+	mu      sync.Mutex
+	servers []*http.Server // http servers started by Serve
+	closing bool           // Shutdown was called
+}
+
+// New returns a server initialized from a configuration.
+// Subsystems which are not replaced by options are set up from the configuration.
+func New(c *conf.Config, opts ...Option) (*Server, error) {
+	var err error
+
+	s := &Server{Config: c}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.ctx, s.stop = context.WithCancel(context.Background())
+
+	// Setup the format and the level of the logs
+	setLogging(s.Config.Log)
+
+	// Setup the database
+	if s.Store == nil {
+		s.Store, err = openStore(s.Config)
+		if errors.Is(err, stor.ErrPendingMigrations) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, errors.New("database setup failed")
+		}
+		s.ownStore = true
+	}
+
+	// Encrypt the personal data of the licenses at rest
+	if s.Config.PII.Enabled() {
+		key, err := s.Config.PII.Key()
+		if err != nil {
+			return nil, err
+		}
+		if err = stor.EnablePIIEncryption(s.Store, key); err != nil {
+			return nil, err
+		}
+	}
+
+	// Trace the requests, down to the statements of the database
+	if s.Config.Tracing.Enabled() {
+		if s.flushTraces, err = tracing.Setup(s.Config.Tracing); err != nil {
+			return nil, err
+		}
+		if err = stor.EnableTracing(s.Store); err != nil {
+			return nil, err
+		}
+	}
+
+	// Fail the jobs interrupted by a restart
+	if n, err := s.Store.Job().Interrupt(time.Now()); err != nil {
+		return nil, err
+	} else if n > 0 {
+		log.Printf("%d jobs interrupted by the restart are failed", n)
+	}
+
+	// Setup the media type registry
+	if err = api.RegisterMediaTypes(s.Store, s.Config.Formats); err != nil {
+		return nil, err
+	}
+
+	// Setup the X509 certificate
+	if s.Cert == nil && s.Signer == nil {
+		var certFile, privKeyFile string
+		if certFile = s.Config.Certificate.Cert; certFile == "" {
+			return nil, errors.New("must specify a certificate")
+		}
+		if privKeyFile = s.Config.Certificate.PrivateKey; privKeyFile == "" {
+			return nil, errors.New("must specify a private key")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, privKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		s.Cert = &cert
+	}
+
+	// Setup the signer, and its signature limits
+	if err = s.setSigner(); err != nil {
+		return nil, err
+	}
+
+	// Setup the storage of publications
+	if s.Tiering == nil {
+		if err = s.setStorage(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Setup the fault injection, for resilience tests
+	if s.Config.Faults.Enabled() {
+		if err = s.setFaults(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Setup the reporting of anonymous statistics, if opted in
+	if s.Config.Reporting.Enabled() {
+		s.setReporting()
+	}
+
+	// Setup the propagation of revocations, if channels are configured
+	if s.Config.Revocation.Enabled() {
+		s.setRevocation()
+	}
+
+	// Setup the cache of the responses of the public routes
+	if s.Config.ResponseCache.Enabled() {
+		if err = s.setResponseCache(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Setup the notifications of the webhooks, of the message bus and of the status callback, if configured
+	if s.Config.Webhooks.Enabled() || s.Config.Bus.Enabled() || s.Config.Status.Callback.Enabled() {
+		if err = s.setWebhooks(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Setup the export of the events to a write-once storage
+	if s.Config.Export.Enabled() {
+		if err = s.setExport(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Setup the execution of the status changes scheduled on licenses
+	s.setSchedule()
+
+	// Setup the archiving of the licenses terminated long ago
+	if s.Config.LicenseArchive.Enabled() {
+		s.setLicenseArchive()
+	}
+
+	// Setup the purge of the expired fresh licenses
+	if s.Config.License.CacheTTL > 0 {
+		s.setLicenseCache()
+	}
+
+	// Setup the routes
+	if err = s.setRoutes(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// setLogging sets the format and the level of the logs of the process. In json format, the lines of the standard
+// logger are also written as json entries, so that a log aggregator parses every line.
+func setLogging(c conf.Log) {
+	if level, err := logrus.ParseLevel(c.Level); err == nil {
+		logrus.SetLevel(level)
+	}
+	if c.Format == "json" {
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+		log.SetFlags(0)
+		log.SetOutput(logrus.StandardLogger().Writer())
+	}
+}
+
+// setSigner creates the signer of the licenses from the certificate, unless one is given, caps its signature
+// operations, and publishes the saturation metrics of the signer
+func (s *Server) setSigner() error {
+	if s.Signer == nil {
+		signer, err := sign.NewSigner(s.Cert)
+		if err != nil {
+			return err
+		}
+		s.Signer = signer
+	}
+	c := s.Config.Signer
+	if c.MaxConcurrent == 0 && c.MaxRate == 0 {
+		return nil
+	}
+	if c.MaxConcurrent == 0 {
+		c.MaxConcurrent = runtime.NumCPU()
+	}
+	if c.QueueTimeout == 0 {
+		c.QueueTimeout = 5000
+	}
+	limiter := sign.NewLimiter(c.MaxConcurrent, c.MaxRate, time.Duration(c.QueueTimeout)*time.Millisecond)
+	s.Signer = sign.NewLimitedSigner(s.Signer, limiter)
+
+	// expvar names are global to the process, which may run several servers: the metrics of their signers add up
+	signerLimiters.Lock()
+	defer signerLimiters.Unlock()
+	signerLimiters.all = append(signerLimiters.all, limiter)
+	if expvar.Get("signer") == nil {
+		expvar.Publish("signer", expvar.Func(func() interface{} {
+			signerLimiters.Lock()
+			defer signerLimiters.Unlock()
+			return sign.SumStats(signerLimiters.all...)
+		}))
+	}
+	return nil
+}
+
+// signerLimiters are the signature limiters of the servers of the process
+var signerLimiters struct {
+	sync.Mutex
+	all []*sign.Limiter
+}
+
+// setFaults injects latency and failures in the database, the signer and the storage of publications,
+// and publishes the number of injected faults
+func (s *Server) setFaults() error {
+	injector := fault.NewInjector(s.Config.Faults)
+	if err := injector.Store(s.Store); err != nil {
+		return fmt.Errorf("failed to inject database faults: %w", err)
+	}
+	s.Signer = injector.Signer(s.Signer)
+	if s.Tiering != nil {
+		tiering := *s.Tiering
+		tiering.Hot = injector.Storage(tiering.Hot)
+		tiering.Cold = injector.Storage(tiering.Cold)
+		s.Tiering = &tiering
+	}
+	if expvar.Get("faults") == nil {
+		expvar.Publish("faults", expvar.Func(func() interface{} { return injector.Stats() }))
+	}
+	log.Printf("Fault injection enabled: %+v", s.Config.Faults)
+	return nil
+}
+
+// setIndicators returns the service level indicators of the routes, and publishes them as metrics;
+// the backlog of revocation notifications is measured in the database of each data residency region.
+// The requests of a client restricted to a provider are counted for this tenant.
+func (s *Server) setIndicators() *slo.Indicators {
+	indicators := slo.NewIndicators(s.Config.SLO, append([]stor.Store{s.Store}, stor.Regions(s.Store)...)...)
+	indicators.Tenants = slo.NewTenants(s.Config.Metrics)
+	indicators.Tenants.Resolve = func(r *http.Request) string {
+		if p := api.PrincipalFromContext(r.Context()); p != nil {
+			return p.Provider
+		}
+		return ""
+	}
+	if expvar.Get("slo") == nil {
+		expvar.Publish("slo", expvar.Func(func() interface{} { return indicators.Stats() }))
+	}
+	return indicators
+}
+
+// loadShedding returns a function giving the load shedding middleware of a route group.
+// A group with its own budget is never starved by the traffic of the other groups,
+// which share the default budget. The saturation metrics are published by group.
+func (s *Server) loadShedding() func(group string) func(http.Handler) http.Handler {
+	c := s.Config.Load
+	shedders := make(map[string]*api.Shedder)
+	if c.MaxConcurrent > 0 {
+		shedders["default"] = newShedder(c.Budget)
+	}
+	for group, budget := range c.Budgets {
+		if budget.MaxConcurrent > 0 {
+			shedders[group] = newShedder(budget)
+		}
+	}
+	if len(shedders) > 0 && expvar.Get("load") == nil {
+		expvar.Publish("load", expvar.Func(func() interface{} {
+			stats := make(map[string]api.ShedderStats)
+			for group, shedder := range shedders {
+				stats[group] = shedder.Stats()
+			}
+			return stats
+		}))
+	}
+
+	return func(group string) func(http.Handler) http.Handler {
+		shedder, ok := shedders[group]
+		if !ok {
+			shedder, ok = shedders["default"]
+		}
+		if !ok {
+			return func(next http.Handler) http.Handler { return next }
+		}
+		return shedder.Handler
+	}
+}
+
+// newShedder returns a load shedder applying a budget
+func newShedder(b conf.Budget) *api.Shedder {
+	if b.QueueTimeout == 0 {
+		b.QueueTimeout = 1000
+	}
+	return api.NewShedder(b.MaxConcurrent, b.MaxQueued, time.Duration(b.QueueTimeout)*time.Millisecond)
+}
+
+// setReporting posts anonymous statistics of the licenses to the reporting endpoints at the end of each period
+func (s *Server) setReporting() {
+	reporter := reporting.NewReporter(s.Config.Reporting, s.Store, s.Config.PublicBaseUrl)
+	s.background(reporter.Run)
+}
+
+// setRevocation propagates the revocations of licenses to their channels at each interval,
+// in the database of each data residency region
+func (s *Server) setRevocation() {
+	for _, st := range append([]stor.Store{s.Store}, stor.Regions(s.Store)...) {
+		propagator := revocation.NewPropagator(s.Config.Revocation, st)
+		s.background(propagator.Run)
+	}
+}
+
+// setResponseCache caches the responses of the public routes, and invalidates the cached status documents
+// of the licenses changed in the database of each data residency region
+func (s *Server) setResponseCache() error {
+	c, err := cache.New(s.Config.ResponseCache)
+	if err != nil {
+		return err
+	}
+	s.responses = c
+	for _, st := range append([]stor.Store{s.Store}, stor.Regions(s.Store)...) {
+		invalidator := cache.NewInvalidator(c, st)
+		s.background(invalidator.Run)
+	}
+	return nil
+}
+
+// setWebhooks notifies the webhooks, the message bus and the status callback of the changes of the licenses at
+// each interval, from the database of each data residency region
+func (s *Server) setWebhooks() error {
+	if s.Config.Bus.Enabled() {
+		p, err := bus.New(s.Config.Bus)
+		if err != nil {
+			return err
+		}
+		s.publisher = p
+	}
+	for _, st := range append([]stor.Store{s.Store}, stor.Regions(s.Store)...) {
+		dispatcher := webhook.NewDispatcher(s.Config.Webhooks, st)
+		if s.publisher != nil {
+			dispatcher.Targets[bus.TargetName(s.Config.Bus)] = bus.Target(s.publisher, s.Config.Bus)
+		}
+		if s.Config.Status.Callback.Enabled() {
+			dispatcher.Targets[webhook.CallbackTarget] = dispatcher.Callback(s.Config.Status.Callback)
+		}
+		s.background(dispatcher.Run)
+	}
+	return nil
+}
+
+// setSchedule executes the status changes scheduled on licenses at each interval,
+// in the database of each data residency region
+func (s *Server) setSchedule() {
+	for _, st := range append([]stor.Store{s.Store}, stor.Regions(s.Store)...) {
+		scheduler := schedule.NewScheduler(s.Config, st)
+		s.background(scheduler.Run)
+	}
+}
+
+// setLicenseArchive moves the licenses terminated long ago to the archive table of each database once a day,
+// by batches so that the table of the licenses is not locked for long
+func (s *Server) setLicenseArchive() {
+	c := s.Config.LicenseArchive
+	batch := c.BatchSize
+	if batch == 0 {
+		batch = 1000
+	}
+	for _, st := range append([]stor.Store{s.Store}, stor.Regions(s.Store)...) {
+		st := st
+		s.background(func(ctx context.Context) {
+			for {
+				before := time.Now().AddDate(0, -c.AfterMonths, 0)
+				var archived int64
+				for ctx.Err() == nil {
+					n, err := st.WithContext(ctx).License().Archive(before, batch)
+					if err != nil {
+						log.Printf("License archiving failed: %v", err)
+						break
+					}
+					archived += n
+					if n < int64(batch) {
+						break
+					}
+				}
+				if archived > 0 {
+					log.Printf("%d licenses archived", archived)
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(24 * time.Hour):
+				}
+			}
+		})
+	}
+}
+
+// setLicenseCache purges the cached fresh licenses which have expired from each database at each lifetime of the cache
+func (s *Server) setLicenseCache() {
+	ttl := time.Duration(s.Config.License.CacheTTL) * time.Minute
+	for _, st := range append([]stor.Store{s.Store}, stor.Regions(s.Store)...) {
+		st := st
+		s.background(func(ctx context.Context) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(ttl):
+				}
+				if _, err := st.WithContext(ctx).LicenseCache().Purge(ttl); err != nil {
+					log.Printf("License cache purge failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// setExport exports the events of the licenses of the main database to a write-once storage at each interval,
+// in batches signed by the certificate of the server
+func (s *Server) setExport() error {
+	st, err := storage.Open(s.Config.Export.Storage)
+	if err != nil {
+		return fmt.Errorf("storage of the exported events: %w", err)
+	}
+	exporter, err := export.NewExporter(s.Config.Export, s.Store, st, s.Signer, s.Config.PublicBaseUrl)
+	if err != nil {
+		return err
+	}
+	s.background(exporter.Run)
+	return nil
+}
+
+// background runs a task until the server shuts down
+func (s *Server) background(task func(ctx context.Context)) {
+	s.tasks.Add(1)
+	go func() {
+		defer s.tasks.Done()
+		task(s.ctx)
+	}()
+}
+
+// setStorage sets the storage of the publications managed by the server,
+// and starts archiving rarely fulfilled publications if a cold storage is configured
+func (s *Server) setStorage() error {
+	c := s.Config.Storage
+	if !c.Enabled() {
+		return nil
+	}
+	hot, err := storage.Open(c.FileStorage)
+	if err != nil {
+		return err
+	}
+	s.Tiering = &storage.Tiering{Hot: hot, Store: s.Store}
+
+	// the publications of the providers of a data residency region are stored in the storage of the region
+	if len(s.Config.Regions) > 0 {
+		s.Regions = make(map[string]*storage.Tiering)
+	}
+	for name, region := range s.Config.Regions {
+		if len(region.Providers) == 0 {
+			continue
+		}
+		regionHot, err := storage.Open(region.Storage)
+		if err != nil {
+			return fmt.Errorf("storage of the region %s: %w", name, err)
+		}
+		// the store of the region, not restricted to a provider, refers to every object of the storage
+		tiering := &storage.Tiering{Hot: regionHot, Store: s.Store.ForProvider(region.Providers[0]).ForProvider("")}
+		for _, provider := range region.Providers {
+			s.Regions[provider] = tiering
+		}
+	}
+
+	if !c.Cold.Enabled() || c.ArchiveAfterDays <= 0 {
+		return nil
+	}
+	s.Tiering.Cold, err = storage.Open(c.Cold)
+	if err != nil {
+		return err
+	}
+	s.background(func(ctx context.Context) {
+		for {
+			before := time.Now().AddDate(0, 0, -c.ArchiveAfterDays)
+			if _, err := s.Tiering.RunLifecycle(ctx, before); err != nil {
+				log.Printf("Storage lifecycle failed: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(24 * time.Hour):
+			}
+		}
+	})
+	return nil
+}
+
+// setRoutes sets the routers of the server. The admin routes are served with the public routes,
+// unless a separate admin listener is configured.
+func (s *Server) setRoutes() error {
+
+	// Set a context for handlers
+	h := api.NewAPIHandler(s.Config, s.Store, s.Cert)
+	h.Signer = s.Signer
+	h.Tiering = s.Tiering
+	h.RegionTiering = s.Regions
+	h.Cache = s.responses
+	if c := s.Config.Admin.OAuth; c.Enabled() {
+		h.OAuth = oauth.NewServer(c, s.Config.PublicBaseUrl)
+		h.OAuth.Clock = h.Clock
+	}
+	s.jobs = h.Jobs
+	if expvar.Get("search") == nil {
+		searches := h.Searches
+		expvar.Publish("search", expvar.Func(func() interface{} { return searches.Stats() }))
+	}
+
+	// Warm the connections, the caches and the signer up before the server reports ready
+	s.warmed = h.Prewarm(s.ctx)
+
+	// Public base url seen through a reverse proxy
+	proxyHeaders, err := api.ProxyHeaders(s.Config.BasePath, s.Config.TrustedProxies, s.Config.ProxyHeaders)
+	if err != nil {
+		return err
+	}
+
+	// Load shedding, by route group
+	shed := s.loadShedding()
+
+	// Service level indicators
+	s.indicators = s.setIndicators()
+
+	newRouter := func() *chi.Mux {
+		r := chi.NewRouter()
+
+		if s.Config.Tracing.Enabled() {
+			r.Use(tracing.Middleware)
+		}
+		r.Use(api.RequestID)
+		r.Use(proxyHeaders)
+		r.Use(api.AccessLog(h.Logger))
+		r.Use(middleware.Recoverer)
+		//r.Use(middleware.URLFormat)
+
+		// Middlewares added by the application embedding the server
+		r.Use(s.middlewares...)
+
+		// Heartbeat
+		r.Group(func(r chi.Router) {
+			r.Use(shed("default"))
+			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("This is the LCP Server running!"))
+			})
+		})
+
+		// Health check of the dependencies, for load balancers: never shed, as an overloaded server is still healthy
+		r.Get("/healthz", h.Healthz)
+
+		// Liveness and readiness probes of Kubernetes, never shed either
+		r.Get("/livez", h.Livez)
+		r.Get("/readyz", h.Readyz)
+		return r
+	}
+
+	r := newRouter()
+	s.publicRoutes(r, h, shed)
+	if s.Config.Admin.Port == 0 {
+		s.adminRoutes(r, h, shed)
+		s.Router = s.mount(r)
+		return nil
+	}
+	admin := newRouter()
+	s.adminRoutes(admin, h, shed)
+	s.Router = s.mount(r)
+	s.AdminRouter = s.mount(admin)
+	return nil
+}
+
+// publicRoutes sets the routes used by reading applications
+func (s *Server) publicRoutes(r chi.Router, h *api.APIHandler, shed func(string) func(http.Handler) http.Handler) {
+
+	// Cached responses, invalidated by the changes of their license or publication
+	cacheStatus := h.CacheResponses(cache.StatusKey, "licenseID", s.Config.ResponseCache.RouteTTL("status"))
+	invalidateStatus := h.InvalidateCache(cache.StatusKey, "licenseID")
+	cacheManifest := h.CacheResponses(cache.ManifestKey, "publicationID", s.Config.ResponseCache.RouteTTL("content"))
+
+	// Status document management
+	r.Group(func(r chi.Router) {
+		r.Use(render.SetContentType(render.ContentTypeJSON))
+		r.Use(shed("status"))
+		r.Use(s.indicators.Tenants.Measure("status"))
+		r.Use(api.Timeout(s.Config.Load.RouteTimeout("status")))
+		r.Use(s.indicators.Status)
+		r.With(cacheStatus).Get("/status/{licenseID}", h.StatusDoc)        // Get /status/123
+		r.With(invalidateStatus).Post("/register/{licenseID}", h.Register) // POST /register/123
+		r.With(invalidateStatus).Put("/renew/{licenseID}", h.Renew)        // PUT /renew/123
+		r.With(invalidateStatus).Put("/return/{licenseID}", h.Return)      // PUT /return/123
+	})
+
+	// Html pages for users: license hint and self-service, rate limited by client address
+	r.Group(func(r chi.Router) {
+		perMinute := s.Config.HintPage.RateLimit
+		if perMinute == 0 {
+			perMinute = 60
+		}
+		r.Use(shed("default"))
+		r.Use(api.NewRateLimiter(perMinute).Handler)
+		r.Get("/hint/{licenseID}", h.HintPage)                                                 // GET /hint/123{?lang}
+		r.Get("/self-service/{licenseID}", h.SelfService)                                      // GET /self-service/123{?token,lang}
+		r.With(invalidateStatus).Post("/self-service/{licenseID}/return", h.SelfServiceReturn) // POST /self-service/123/return
+	})
+
+	// Multi-part publications, streamed therefore not bounded by a timeout
+	r.Group(func(r chi.Router) {
+		r.Use(shed("content"))
+		r.Use(s.indicators.Tenants.Measure(slo.GROUP_CONTENT))
+		r.With(cacheManifest).Get("/content/{publicationID}/manifest", h.GetManifest) // GET /content/123/manifest
+		r.Get("/content/{publicationID}/{position}", h.StreamResource)                // GET /content/123/1
+	})
+}
+
+// adminRoutes sets the private routes used by content management systems and administrators
+func (s *Server) adminRoutes(r chi.Router, h *api.APIHandler, shed func(string) func(http.Handler) http.Handler) {
+
+	// Require Authentication, by default the admin logins of the configuration, and the bearer tokens
+	// of an identity provider or of the token endpoint if configured
+	auth := s.auth
+	if auth == nil {
+		auth = api.BasicAuth("restricted", s.Config.Admin.Logins(s.Config.Login))
+		var validators []api.TokenValidator
+		if h.OAuth != nil {
+			validators = append(validators, h.OAuth)
+		}
+		if c := s.Config.Admin.JWT; c.Enabled() {
+			validators = append(validators, jwt.NewValidator(c))
+		}
+		if len(validators) > 0 {
+			fallback := auth
+			if s.Config.Admin.JWT.BearerOnly {
+				fallback = nil
+			}
+			auth = api.BearerAuth("restricted", fallback, validators...)
+		}
+	}
+
+	// Token endpoint of the machine clients, authenticated by their own credentials and rate limited by client
+	// address, so that their secrets cannot be guessed
+	if h.OAuth != nil {
+		perMinute := s.Config.Admin.OAuth.RateLimit
+		if perMinute == 0 {
+			perMinute = 60
+		}
+		r.With(shed("admin"), api.NewRateLimiter(perMinute).Handler, render.SetContentType(render.ContentTypeJSON)).Post("/oauth/token", h.IssueToken)
+	}
+
+	// Reading requires the reader role, changes the operator role, and the configuration of the server the admin role;
+	// the clients restricted to a provider only reach its publications and licenses
+	r.Group(func(r chi.Router) {
+		r.Use(auth)
+		r.Use(api.Authorize)
+		r.Use(s.indicators.Tenants.Measure("admin"))
+		r.Use(render.SetContentType(render.ContentTypeJSON))
+
+		// License generation
+		r.Route("/licenses/", func(r chi.Router) {
+			r.Use(shed("licenses"))
+			r.Use(s.indicators.Tenants.Measure("licenses"))
+			r.Use(api.Timeout(s.Config.Load.RouteTimeout("licenses")))
+			r.With(s.indicators.Issuance).Post("/", h.GenerateLicense) // POST /licenses
+
+			r.Post("/batch", h.CreateLicenses) // POST /licenses/batch
+
+			r.Route("/{licenseID}", func(r chi.Router) {
+				r.Use(h.InvalidateCache(cache.StatusKey, "licenseID"))
+				r.With(s.indicators.Issuance).Post("/", h.GetFreshLicense) // POST /licenses/123
+
+				r.Get("/document", h.GetLicenseDocument)        // GET /licenses/123/document
+				r.Post("/restore", h.RestoreLicense)            // POST /licenses/123/restore
+				r.Put("/revoke", h.Revoke)                      // PUT /licenses/123/revoke
+				r.Get("/revocation", h.GetRevocation)           // GET /licenses/123/revocation
+				r.Post("/void", h.Void)                         // POST /licenses/123/void
+				r.Post("/register", h.Register)                 // POST /licenses/123/register{?id,name}
+				r.Put("/renew", h.Renew)                        // PUT /licenses/123/renew{?end,id,name}
+				r.Put("/return", h.Return)                      // PUT /licenses/123/return{?id,name}
+				r.Get("/devices", h.ListDevices)                // GET /licenses/123/devices
+				r.Get("/actions", h.ListActions)                // GET /licenses/123/actions
+				r.Get("/audit", h.ListAudit)                    // GET /licenses/123/audit
+				r.Post("/actions", h.ScheduleAction)            // POST /licenses/123/actions
+				r.Delete("/actions/{actionID}", h.CancelAction) // DELETE /licenses/123/actions/1
+			})
+		})
+
+		// Redemption of coupons, generating a license
+		r.With(shed("licenses"), api.Timeout(s.Config.Load.RouteTimeout("licenses"))).Post("/redeem", h.Redeem) // POST /redeem
+
+		// Claim of gifts, generating the license of the recipient
+		r.With(shed("licenses"), api.Timeout(s.Config.Load.RouteTimeout("licenses"))).Post("/claim", h.ClaimGift) // POST /claim
+
+		// Administration
+		r.Group(func(r chi.Router) {
+			r.Use(shed("admin"))
+			r.Use(api.Timeout(s.Config.Load.RouteTimeout("admin")))
+
+			// Publications, CRUD
+			r.Route("/publications", func(r chi.Router) {
+				r.With(api.Paginate).Get("/", h.ListPublications)
+				r.With(api.Paginate).Get("/search", h.SearchPublications) // GET /publication/search{?format,q,limit}
+				r.Post("/", h.CreatePublication)                          // POST /publications
+				r.Post("/sync", h.SyncPublications)                       // POST /publications/sync
+				r.Get("/changes", h.ListPublicationChanges)               // GET /publications/changes{?since,limit}
+
+				r.Route("/{publicationID}", func(r chi.Router) {
+					r.Use(h.InvalidateCache(cache.ManifestKey, "publicationID"))
+					r.Get("/", h.GetPublication)                        // GET /publications/123
+					r.Put("/", h.UpdatePublication)                     // PUT /publications/123
+					r.Delete("/", h.DeletePublication)                  // DELETE /publications/123
+					r.Get("/resources", h.ListResources)                // GET /publications/123/resources
+					r.Put("/resources", h.SetResources)                 // PUT /publications/123/resources
+					r.Post("/restore", h.RestorePublication)            // POST /publications/123/restore
+					r.Put("/file", h.EncryptPublication)                // PUT /publications/123/file
+					r.Post("/file", h.UploadPublication)                // POST /publications/123/file
+					r.Get("/usage", h.GetPublicationUsage)              // GET /publications/123/usage{?from,to}
+					r.Get("/collections", h.ListPublicationCollections) // GET /publications/123/collections
+					r.Get("/sample", h.GetSample)                       // GET /publications/123/sample
+					r.Put("/sample", h.SetSample)                       // PUT /publications/123/sample{?resource}
+					r.Delete("/sample", h.DeleteSample)                 // DELETE /publications/123/sample
+
+					// preview licenses are generated from the sample
+					r.With(shed("licenses"), api.Timeout(s.Config.Load.RouteTimeout("licenses"))).Post("/preview", h.GeneratePreviewLicense) // POST /publications/123/preview
+				})
+			})
+
+			// LicenseInfo, CRUD
+			r.Route("/licenseinfo", func(r chi.Router) {
+				r.With(api.Paginate).Get("/", h.ListLicenses)
+				r.With(api.Paginate).Get("/search", h.SearchLicenses) // GET /licenses/search{?pub,user,status,count,bundle,order_ref,limit}
+				r.Post("/", h.CreateLicense)                          // POST /licenses
+
+				r.Route("/{licenseID}", func(r chi.Router) {
+					r.Use(h.InvalidateCache(cache.StatusKey, "licenseID"))
+					r.Get("/", h.GetLicense)       // GET /licenses/123
+					r.Put("/", h.UpdateLicense)    // PUT /licenses/123
+					r.Delete("/", h.DeleteLicense) // DELETE /licenses/123
+				})
+			})
+
+			// Bulk operations
+			r.Route("/jobs", func(r chi.Router) {
+				r.Use(api.Unscoped)
+				r.Post("/", h.CreateJob)          // POST /jobs
+				r.Get("/{jobID}", h.GetJob)       // GET /jobs/123
+				r.Delete("/{jobID}", h.CancelJob) // DELETE /jobs/123
+			})
+
+			// Class rosters
+			r.Route("/rosters", func(r chi.Router) {
+				r.Use(api.Unscoped)
+				r.Post("/", h.CreateRoster)    // POST /rosters
+				r.Get("/{jobID}", h.GetRoster) // GET /rosters/123{?format}
+			})
+
+			// Media type registry
+			r.Route("/mediatypes", func(r chi.Router) {
+				r.Use(api.RequireRole(conf.ROLE_ADMIN), api.Unscoped)
+				r.Get("/", h.ListMediaTypes)
+				r.Post("/", h.CreateMediaType) // POST /mediatypes
+
+				r.Route("/{format}", func(r chi.Router) {
+					r.Get("/", h.GetMediaType)       // GET /mediatypes/epub
+					r.Put("/", h.UpdateMediaType)    // PUT /mediatypes/epub
+					r.Delete("/", h.DeleteMediaType) // DELETE /mediatypes/epub
+				})
+			})
+
+			// Organizations and their passphrase pools
+			r.Route("/organizations", func(r chi.Router) {
+				r.Use(api.RequireRole(conf.ROLE_ADMIN), api.Unscoped)
+				r.Get("/", h.ListOrganizations)
+				r.Post("/", h.CreateOrganization) // POST /organizations
+
+				r.Route("/{organizationID}", func(r chi.Router) {
+					r.Get("/", h.GetOrganization)       // GET /organizations/123
+					r.Put("/", h.UpdateOrganization)    // PUT /organizations/123
+					r.Delete("/", h.DeleteOrganization) // DELETE /organizations/123
+
+					r.Route("/passphrases", func(r chi.Router) {
+						r.Get("/", h.ListPassphrases)            // GET /organizations/123/passphrases
+						r.Post("/", h.AddPassphrase)             // POST /organizations/123/passphrases
+						r.Delete("/{label}", h.DeletePassphrase) // DELETE /organizations/123/passphrases/teachers
+					})
+				})
+			})
+
+			// Providers and their policy links
+			r.Route("/providers", func(r chi.Router) {
+				r.Use(api.RequireRole(conf.ROLE_ADMIN), api.Unscoped)
+				r.Get("/", h.ListProviders)
+				r.Post("/", h.CreateProvider) // POST /providers
+
+				r.Route("/{providerID}", func(r chi.Router) {
+					r.Get("/", h.GetProvider)       // GET /providers/123
+					r.Put("/", h.UpdateProvider)    // PUT /providers/123
+					r.Delete("/", h.DeleteProvider) // DELETE /providers/123
+				})
+			})
+
+			// Collections of publications: series and bundles
+			r.Route("/collections", func(r chi.Router) {
+				r.Use(api.Unscoped)
+				r.Get("/", h.ListCollections)
+				r.Post("/", h.CreateCollection) // POST /collections
+
+				r.Route("/{collectionID}", func(r chi.Router) {
+					r.Get("/", h.GetCollection)                                    // GET /collections/123
+					r.Put("/", h.UpdateCollection)                                 // PUT /collections/123
+					r.Delete("/", h.DeleteCollection)                              // DELETE /collections/123
+					r.Get("/members", h.ListCollectionMembers)                     // GET /collections/123/members
+					r.Put("/members/{publicationID}", h.SetCollectionMember)       // PUT /collections/123/members/456
+					r.Delete("/members/{publicationID}", h.DeleteCollectionMember) // DELETE /collections/123/members/456
+					r.Post("/licenses", h.CreateBundleLicenses)                    // POST /collections/123/licenses
+				})
+			})
+
+			// Coupons, exchanged for licenses by POST /redeem
+			r.Route("/coupons", func(r chi.Router) {
+				r.Use(api.Unscoped)
+				r.Get("/", h.ListCoupons)
+				r.Post("/", h.CreateCoupon)            // POST /coupons
+				r.Post("/generate", h.GenerateCoupons) // POST /coupons/generate{?count}
+
+				r.Route("/{code}", func(r chi.Router) {
+					r.Get("/", h.GetCoupon)                  // GET /coupons/ABCD-EFGH
+					r.Put("/", h.UpdateCoupon)               // PUT /coupons/ABCD-EFGH
+					r.Delete("/", h.DeleteCoupon)            // DELETE /coupons/ABCD-EFGH
+					r.Get("/redemptions", h.ListRedemptions) // GET /coupons/ABCD-EFGH/redemptions
+				})
+			})
+
+			// Gifts, claimed by their recipient by POST /claim
+			r.Route("/gifts", func(r chi.Router) {
+				r.Use(api.Unscoped)
+				r.Get("/", h.ListGifts)   // GET /gifts{?purchaser}
+				r.Post("/", h.CreateGift) // POST /gifts
+
+				r.Route("/{giftID}", func(r chi.Router) {
+					r.Get("/", h.GetGift)       // GET /gifts/123
+					r.Delete("/", h.CancelGift) // DELETE /gifts/123
+				})
+			})
+
+			// Storage maintenance
+			r.With(api.RequireRole(conf.ROLE_ADMIN), api.Unscoped).Post("/storage/gc", h.CollectOrphans) // POST /storage/gc{?dry_run,grace}
+
+			// Reports, restricted to the publications of a provider
+			r.Get("/reports/storage", h.StorageReport) // GET /reports/storage
+
+			// Verification of the history of the licenses
+			r.With(api.Unscoped).Get("/events/verify", h.VerifyEvents) // GET /events/verify
+
+			// Notifications dropped after their last attempt
+			r.With(api.RequireRole(conf.ROLE_ADMIN), api.Unscoped).Get("/webhooks/dead-letters", h.ListDeadLetters) // GET /webhooks/dead-letters{?target,limit}
+
+			// License revocation
+			r.Put("/revoke/{licenseID}", h.Revoke) // PUT /revoke/123, kept for compatibility
+
+			// Metrics, e.g. signer saturation, and service level indicators in the Prometheus format
+			r.With(api.RequireRole(conf.ROLE_ADMIN), api.Unscoped).Handle("/debug/vars", expvar.Handler()) // GET /debug/vars
+			r.With(api.RequireRole(conf.ROLE_ADMIN), api.Unscoped).Handle("/metrics", s.indicators)        // GET /metrics
+		})
+
+		// Exports of the searches, streamed therefore not bounded by a timeout
+		r.Group(func(r chi.Router) {
+			r.Use(shed("admin"))
+			r.Get("/publications/export", h.ExportPublications) // GET /publications/export{?format,q}
+			r.Get("/licenseinfo/export", h.ExportLicenses)      // GET /licenseinfo/export{?pub,user,status,count,bundle,order_ref}
+		})
+	})
+}
+
+// mount serves the routes of a router under the base path, if any
+func (s *Server) mount(r *chi.Mux) *chi.Mux {
+	basePath := strings.TrimSuffix(s.Config.BasePath, "/")
+	if basePath == "" {
+		return r
+	}
+	root := chi.NewRouter()
+	root.Mount(basePath, r)
+	return root
+}
+
+// Run starts the server on a single address, serving the routes of the main router
+func (s *Server) Run(addr string) error {
+	return http.ListenAndServe(addr, s.Router)
+}
@@ -0,0 +1,613 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/sign"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/stortest"
+)
+
+func testConfig() *conf.Config {
+	return &conf.Config{
+		PublicBaseUrl: "http://localhost:8081",
+		Dsn:           "sqlite3://file:server?mode=memory&cache=shared",
+		Login:         conf.Login{User: "user", Password: "password"},
+		Certificate: conf.Certificate{
+			Cert:       "../test/cert/cert-edrlab-test.pem",
+			PrivateKey: "../test/cert/privkey-edrlab-test.pem",
+		},
+	}
+}
+
+func serve(s *Server, req *http.Request) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	s.Router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestDefaultServer(t *testing.T) {
+
+	s, err := New(testConfig())
+	if err != nil {
+		t.Fatalf("Failed to create the server: %v", err)
+	}
+
+	// private routes require the admin login
+	req := httptest.NewRequest("GET", "/publications/", nil)
+	if rr := serve(s, req); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %d", rr.Code)
+	}
+	req.SetBasicAuth("user", "password")
+	if rr := serve(s, req); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rr.Code)
+	}
+
+	// every response carries the identifier of its request
+	req.Header.Set("X-Request-ID", "cms-42")
+	if rr := serve(s, req); rr.Header().Get("X-Request-ID") != "cms-42" {
+		t.Errorf("Expected the request id of the caller, got %q", rr.Header().Get("X-Request-ID"))
+	}
+
+	// the searches are limited, and their exports streamed
+	for path, contentType := range map[string]string{"/publications/search?q=x": "application/json", "/publications/export?q=x": "application/x-ndjson"} {
+		req = httptest.NewRequest("GET", path, nil)
+		req.SetBasicAuth("user", "password")
+		if rr := serve(s, req); rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), contentType) {
+			t.Errorf("Expected %s on %s, got %d %s", contentType, path, rr.Code, rr.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestAdminUsers(t *testing.T) {
+
+	c := testConfig()
+	c.Dsn = "sqlite3://file:server-users?mode=memory&cache=shared"
+	c.Admin.Users = []conf.Login{{User: "cms", Password: "cms-password"}}
+	s, err := New(c)
+	if err != nil {
+		t.Fatalf("Failed to create the server: %v", err)
+	}
+
+	// every configured user reaches the private routes, with its own password
+	for _, path := range []string{"/publications/", "/licenses/"} {
+		req := httptest.NewRequest("POST", path, nil)
+		req.SetBasicAuth("cms", "password")
+		if rr := serve(s, req); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 on %s, got %d", path, rr.Code)
+		}
+	}
+	for _, login := range []conf.Login{c.Login, c.Admin.Users[0]} {
+		req := httptest.NewRequest("GET", "/publications/", nil)
+		req.SetBasicAuth(login.User, login.Password)
+		if rr := serve(s, req); rr.Code != http.StatusOK {
+			t.Errorf("Expected 200 for %s, got %d", login.User, rr.Code)
+		}
+	}
+}
+
+func TestRoles(t *testing.T) {
+
+	c := testConfig()
+	c.Dsn = "sqlite3://file:server-roles?mode=memory&cache=shared"
+	c.Admin.Users = []conf.Login{{User: "viewer", Password: "viewer-password", Role: conf.ROLE_READER},
+		{User: "ops", Password: "ops-password", Role: conf.ROLE_OPERATOR}}
+	s, err := New(c)
+	if err != nil {
+		t.Fatalf("Failed to create the server: %v", err)
+	}
+
+	// readers list, operators change the licenses and publications, admins configure the server
+	for _, test := range []struct {
+		user, method, path string
+		code               int
+	}{
+		{"viewer", "GET", "/publications/", http.StatusOK},
+		{"viewer", "GET", "/licenseinfo/", http.StatusOK},
+		{"viewer", "DELETE", "/publications/123", http.StatusForbidden},
+		{"viewer", "PUT", "/licenseinfo/123", http.StatusForbidden},
+		{"ops", "DELETE", "/publications/123", http.StatusNotFound},
+		{"ops", "GET", "/organizations/", http.StatusForbidden},
+		{"ops", "POST", "/storage/gc", http.StatusForbidden},
+		{"user", "GET", "/organizations/", http.StatusOK},
+	} {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req.SetBasicAuth(test.user, test.user+"-password")
+		if test.user == "user" {
+			req.SetBasicAuth(c.Login.User, c.Login.Password)
+		}
+		if rr := serve(s, req); rr.Code != test.code {
+			t.Errorf("Expected %d for %s %s by %s, got %d", test.code, test.method, test.path, test.user, rr.Code)
+		}
+	}
+}
+
+func TestProviderScope(t *testing.T) {
+
+	c := testConfig()
+	c.Dsn = "sqlite3://file:server-providers?mode=memory&cache=shared"
+	c.Admin.Users = []conf.Login{{User: "a", Password: "a-password", Provider: "https://a.example.com"},
+		{User: "b", Password: "b-password", Provider: "https://b.example.com"}}
+	s, err := New(c)
+	if err != nil {
+		t.Fatalf("Failed to create the server: %v", err)
+	}
+	pub := stortest.NewPublication("application/epub+zip")
+	if err := s.Store.ForProvider("https://a.example.com").Publication().Create(pub); err != nil {
+		t.Fatalf("Failed to create a publication: %v", err)
+	}
+	license := stortest.NewLicense(pub.UUID, "user1")
+	if err := s.Store.ForProvider("https://a.example.com").License().Create(license); err != nil {
+		t.Fatalf("Failed to create a license: %v", err)
+	}
+
+	// a provider only reaches its publications and licenses, and no route shared by every provider
+	for _, test := range []struct {
+		user, method, path string
+		code               int
+	}{
+		{"a", "GET", "/publications/" + pub.UUID, http.StatusOK},
+		{"b", "GET", "/publications/" + pub.UUID, http.StatusNotFound},
+		{"b", "GET", "/licenseinfo/" + license.UUID, http.StatusNotFound},
+		{"b", "PUT", "/licenses/" + license.UUID + "/revoke", http.StatusNotFound},
+		{"b", "GET", "/coupons/", http.StatusForbidden},
+		{"b", "GET", "/reports/storage", http.StatusOK},
+		{"user", "GET", "/licenseinfo/" + license.UUID, http.StatusOK},
+	} {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req.SetBasicAuth(test.user, test.user+"-password")
+		if test.user == "user" {
+			req.SetBasicAuth(c.Login.User, c.Login.Password)
+		}
+		if rr := serve(s, req); rr.Code != test.code {
+			t.Errorf("Expected %d for %s %s by %s, got %d", test.code, test.method, test.path, test.user, rr.Code)
+		}
+	}
+	for user, count := range map[string]int{"a": 1, "b": 0} {
+		req := httptest.NewRequest("GET", "/licenseinfo/", nil)
+		req.SetBasicAuth(user, user+"-password")
+		var licenses []stor.LicenseInfo
+		if err := json.Unmarshal(serve(s, req).Body.Bytes(), &licenses); err != nil || len(licenses) != count {
+			t.Errorf("Expected %d licenses for %s, got %d, %v", count, user, len(licenses), err)
+		}
+	}
+}
+
+func TestRegions(t *testing.T) {
+
+	c := testConfig()
+	c.Dsn = "sqlite3://file:server-main?mode=memory&cache=shared"
+	c.Regions = map[string]conf.Region{"eu": {Dsn: "sqlite3://file:server-region-eu?mode=memory&cache=shared", Providers: []string{"https://eu.example.com"}}}
+	c.Admin.Users = []conf.Login{{User: "eu", Password: "eu-password", Provider: "https://eu.example.com"}}
+	s, err := New(c)
+	if err != nil {
+		t.Fatalf("Failed to create the server: %v", err)
+	}
+	pub := stortest.NewPublication("application/epub+zip")
+	if err := s.Store.ForProvider("https://eu.example.com").Publication().Create(pub); err != nil {
+		t.Fatalf("Failed to create a publication: %v", err)
+	}
+	license := stortest.NewLicense(pub.UUID, "user1")
+	if err := s.Store.ForProvider("https://eu.example.com").License().Create(license); err != nil {
+		t.Fatalf("Failed to create a license: %v", err)
+	}
+
+	// the publications and licenses of the provider are kept in the database of its region
+	regions := stor.Regions(s.Store)
+	if len(regions) != 1 {
+		t.Fatalf("Expected a region, got %d", len(regions))
+	}
+	if _, err := s.Store.License().Get(license.UUID); err == nil {
+		t.Error("Expected the license to be absent from the main database")
+	}
+	if _, err := regions[0].License().Get(license.UUID); err != nil {
+		t.Errorf("Expected the license in the database of the region, got %v", err)
+	}
+
+	// and are found by the provider, and by the routes of a license or publication
+	for _, test := range []struct {
+		user, method, path string
+		code               int
+	}{
+		{"eu", "GET", "/publications/" + pub.UUID, http.StatusOK},
+		{"eu", "GET", "/licenseinfo/" + license.UUID, http.StatusOK},
+		{"user", "GET", "/licenseinfo/" + license.UUID, http.StatusOK},
+		{"", "GET", "/status/" + license.UUID, http.StatusOK},
+		{"", "GET", "/status/unknown", http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		switch test.user {
+		case "eu":
+			req.SetBasicAuth("eu", "eu-password")
+		case "user":
+			req.SetBasicAuth(c.Login.User, c.Login.Password)
+		}
+		if rr := serve(s, req); rr.Code != test.code {
+			t.Errorf("Expected %d for %s %s by %s, got %d", test.code, test.method, test.path, test.user, rr.Code)
+		}
+	}
+	req := httptest.NewRequest("GET", "/licenseinfo/", nil)
+	req.SetBasicAuth("eu", "eu-password")
+	var licenses []stor.LicenseInfo
+	if err := json.Unmarshal(serve(s, req).Body.Bytes(), &licenses); err != nil || len(licenses) != 1 {
+		t.Errorf("Expected the license of the region, got %d, %v", len(licenses), err)
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+
+	// an identity provider publishing an Ed25519 key
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	b64 := base64.RawURLEncoding
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"k1","x":"%s"}]}`, b64.EncodeToString(pub))
+	}))
+	defer idp.Close()
+	token := func(aud, role string) string {
+		claims := fmt.Sprintf(`{"iss":"https://idp.example.com/","aud":"%s","exp":%d,"roles":"%s"}`, aud, time.Now().Add(time.Minute).Unix(), role)
+		signed := b64.EncodeToString([]byte(`{"alg":"EdDSA","kid":"k1"}`)) + "." + b64.EncodeToString([]byte(claims))
+		return signed + "." + b64.EncodeToString(ed25519.Sign(priv, []byte(signed)))
+	}
+
+	c := testConfig()
+	c.Dsn = "sqlite3://file:server-jwt?mode=memory&cache=shared"
+	c.Admin.JWT = conf.JWT{Issuer: "https://idp.example.com/", Audience: "lcp", JWKSURL: idp.URL}
+	s, err := New(c)
+	if err != nil {
+		t.Fatalf("Failed to create the server: %v", err)
+	}
+
+	// bearer tokens and logins are accepted, tokens without role being denied
+	for _, test := range []struct {
+		authz string
+		code  int
+	}{
+		{"Bearer " + token("lcp", "reader"), http.StatusOK},
+		{"Bearer " + token("lcp", ""), http.StatusForbidden},
+		{"Bearer " + token("other", "reader"), http.StatusUnauthorized},
+		{"Basic " + base64.StdEncoding.EncodeToString([]byte("user:password")), http.StatusOK},
+		{"", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("GET", "/publications/", nil)
+		req.Header.Set("Authorization", test.authz)
+		if rr := serve(s, req); rr.Code != test.code {
+			t.Errorf("Expected %d for %q, got %d", test.code, test.authz, rr.Code)
+		}
+	}
+
+	// only bearer tokens are accepted
+	c.Admin.JWT.BearerOnly = true
+	if s, err = New(c); err != nil {
+		t.Fatalf("Failed to create the server: %v", err)
+	}
+	req := httptest.NewRequest("GET", "/publications/", nil)
+	req.SetBasicAuth("user", "password")
+	if rr := serve(s, req); rr.Code != http.StatusUnauthorized || !strings.HasPrefix(rr.Header().Get("WWW-Authenticate"), "Bearer") {
+		t.Errorf("Expected a bearer challenge, got %d %v", rr.Code, rr.Header())
+	}
+}
+
+func TestOAuthToken(t *testing.T) {
+
+	c := testConfig()
+	c.Dsn = "sqlite3://file:server-oauth?mode=memory&cache=shared"
+	c.Admin.OAuth = conf.OAuth{Secret: strings.Repeat("s", 32), Clients: []conf.OAuthClient{{ID: "cms", Secret: "cms-secret"}}}
+	s, err := New(c)
+	if err != nil {
+		t.Fatalf("Failed to create the server: %v", err)
+	}
+	tokenRequest := func(form url.Values, user, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		return serve(s, req)
+	}
+
+	// the client authenticates with its own credentials
+	for _, test := range []struct {
+		form           url.Values
+		user, password string
+		code           int
+		err            string
+	}{
+		{url.Values{"grant_type": {"password"}}, "cms", "cms-secret", http.StatusBadRequest, "unsupported_grant_type"},
+		{url.Values{"grant_type": {"client_credentials"}}, "cms", "password", http.StatusUnauthorized, "invalid_client"},
+		{url.Values{"grant_type": {"client_credentials"}, "client_id": {"cms"}, "client_secret": {"cms-secret"}}, "cms", "cms-secret", http.StatusBadRequest, "invalid_request"},
+		{url.Values{"grant_type": {"client_credentials"}, "client_id": {"cms"}, "client_secret": {"cms-secret"}}, "", "", http.StatusOK, ""},
+	} {
+		rr := tokenRequest(test.form, test.user, test.password)
+		if rr.Code != test.code || !strings.Contains(rr.Body.String(), test.err) {
+			t.Errorf("Expected %d %s for %v, got %d %s", test.code, test.err, test.form, rr.Code, rr.Body)
+		}
+	}
+
+	// the token is accepted on the private routes, along with the logins
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	rr := tokenRequest(url.Values{"grant_type": {"client_credentials"}}, "cms", "cms-secret")
+	if err := json.Unmarshal(rr.Body.Bytes(), &token); err != nil || token.TokenType != "Bearer" || token.ExpiresIn != 3600 ||
+		rr.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("Unexpected token response %s, %v", rr.Body, err)
+	}
+	for _, test := range []struct {
+		authz string
+		code  int
+	}{
+		{"Bearer " + token.AccessToken, http.StatusOK},
+		{"Bearer " + token.AccessToken[:len(token.AccessToken)-4] + "AAAA", http.StatusUnauthorized},
+		{"Basic " + base64.StdEncoding.EncodeToString([]byte("user:password")), http.StatusOK},
+	} {
+		req := httptest.NewRequest("GET", "/publications/", nil)
+		req.Header.Set("Authorization", test.authz)
+		if rr := serve(s, req); rr.Code != test.code {
+			t.Errorf("Expected %d for %q, got %d", test.code, test.authz, rr.Code)
+		}
+	}
+
+	// the token requests of a client address are rate limited, so that the secrets cannot be guessed
+	c.Admin.OAuth.RateLimit = 2
+	if s, err = New(c); err != nil {
+		t.Fatalf("Failed to create the server: %v", err)
+	}
+	for i, code := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		if rr := tokenRequest(url.Values{"grant_type": {"client_credentials"}}, "cms", "guess"); rr.Code != code {
+			t.Errorf("Expected %d for the token request %d, got %d", code, i, rr.Code)
+		}
+	}
+}
+
+func TestServerOptions(t *testing.T) {
+
+	st, err := stor.DBSetup("sqlite3://file:server-options?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tagged := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Embedded", "yes")
+			next.ServeHTTP(w, r)
+		})
+	}
+	tokenAuth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	c := testConfig()
+	c.BasePath = "/lcp"
+	s, err := New(c, WithStore(st), WithMiddleware(tagged), WithAuth(tokenAuth))
+	if err != nil {
+		t.Fatalf("Failed to create the server: %v", err)
+	}
+	if s.Store != st {
+		t.Error("The store must be the one passed as an option")
+	}
+
+	// the middleware is applied to public routes
+	rr := serve(s, httptest.NewRequest("GET", "/lcp/", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("X-Embedded") != "yes" {
+		t.Errorf("Expected the embedded middleware to run, got %d %v", rr.Code, rr.Header())
+	}
+
+	// the authentication replaces the basic authentication
+	req := httptest.NewRequest("GET", "/lcp/publications/", nil)
+	req.SetBasicAuth("user", "password")
+	if rr = serve(s, req); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403, got %d", rr.Code)
+	}
+	req = httptest.NewRequest("GET", "/lcp/publications/", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	if rr = serve(s, req); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rr.Code)
+	}
+}
+
+// countingSigner is a signer injected by an embedding application
+type countingSigner struct {
+	signed int32
+}
+
+func (s *countingSigner) Sign(in interface{}) (sign.Signature, error) {
+	atomic.AddInt32(&s.signed, 1)
+	return sign.Signature{Algorithm: "test"}, nil
+}
+
+func TestSignerLimits(t *testing.T) {
+
+	signerStats := func() sign.LimiterStats {
+		var stats sign.LimiterStats
+		if v := expvar.Get("signer"); v != nil {
+			json.Unmarshal([]byte(v.String()), &stats)
+		}
+		return stats
+	}
+	before := signerStats()
+
+	// an injected signer is capped by the signature limits, and counted in the metrics
+	injected := &countingSigner{}
+	c := testConfig()
+	c.Dsn = "sqlite3://file:server-signer?mode=memory&cache=shared"
+	c.Signer = conf.Signer{MaxConcurrent: 1}
+	s, err := New(c, WithSigner(injected))
+	if err != nil {
+		t.Fatalf("Failed to create the server: %v", err)
+	}
+	if s.Signer == sign.Signer(injected) {
+		t.Fatal("Expected the injected signer to be limited")
+	}
+	// the warmup may sign a test document meanwhile
+	if _, err = s.Signer.Sign(nil); err != nil || atomic.LoadInt32(&injected.signed) < 1 {
+		t.Fatalf("Expected a signature by the injected signer, got %v", err)
+	}
+	if stats := signerStats(); stats.Total < before.Total+1 || stats.MaxConcurrent != before.MaxConcurrent+1 {
+		t.Errorf("Expected the signature to be counted, got %+v", stats)
+	}
+
+	// the limits of every server of the process add up
+	c = testConfig()
+	c.Dsn = "sqlite3://file:server-signer2?mode=memory&cache=shared"
+	c.Signer = conf.Signer{MaxConcurrent: 2}
+	if _, err = New(c); err != nil {
+		t.Fatalf("Failed to create the server: %v", err)
+	}
+	if stats := signerStats(); stats.MaxConcurrent != before.MaxConcurrent+3 {
+		t.Errorf("Expected the limits of both servers, got %+v", stats)
+	}
+}
+
+func TestLoadBudgets(t *testing.T) {
+
+	s := &Server{Config: testConfig()}
+	s.Config.Load = conf.Load{
+		Budgets: map[string]conf.Budget{
+			"admin":  {MaxConcurrent: 1, MaxQueued: 1, QueueTimeout: 10},
+			"status": {MaxConcurrent: 1, QueueTimeout: 10},
+		},
+	}
+	shed := s.loadShedding()
+
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	blocking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-unblock
+	})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	// a heavy admin request takes the whole admin budget
+	done := make(chan struct{})
+	go func() {
+		shed("admin")(blocking).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		close(done)
+	}()
+	<-started
+
+	rr := httptest.NewRecorder()
+	shed("admin")(ok).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 for a saturated group, got %d", rr.Code)
+	}
+	// status traffic is not starved, and a group without budget is not limited
+	for _, group := range []string{"status", "content"} {
+		rr = httptest.NewRecorder()
+		shed(group)(ok).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected 200 for the %s group, got %d", group, rr.Code)
+		}
+	}
+	close(unblock)
+	<-done
+}
+
+func TestAdminListener(t *testing.T) {
+
+	st, err := stor.DBSetup("sqlite3://file:server-admin?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := testConfig()
+	c.Admin = conf.Admin{
+		Listener: conf.Listener{Host: "127.0.0.1", Port: 8082},
+		Login:    conf.Login{User: "admin", Password: "secret"},
+	}
+	s, err := New(c, WithStore(st))
+	if err != nil {
+		t.Fatalf("Failed to create the server: %v", err)
+	}
+	if s.AdminRouter == nil {
+		t.Fatal("The admin routes must be served separately")
+	}
+
+	// private routes are not served by the public listener
+	req := httptest.NewRequest("GET", "/publications/", nil)
+	req.SetBasicAuth("user", "password")
+	if rr := serve(s, req); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rr.Code)
+	}
+
+	// the admin listener has its own login
+	rr := httptest.NewRecorder()
+	s.AdminRouter.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %d", rr.Code)
+	}
+	req.SetBasicAuth("admin", "secret")
+	rr = httptest.NewRecorder()
+	s.AdminRouter.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rr.Code)
+	}
+
+	// public routes are not served by the admin listener
+	rr = httptest.NewRecorder()
+	s.AdminRouter.ServeHTTP(rr, httptest.NewRequest("GET", "/status/123", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rr.Code)
+	}
+}
+
+func TestFaults(t *testing.T) {
+
+	c := testConfig()
+	c.Dsn = "sqlite3://file:server-faults?mode=memory&cache=shared"
+	c.License = conf.License{Provider: "https://provider.example.com", HintLink: "https://provider.example.com/hint"}
+	c.Faults = conf.Faults{SignerErrorRate: 1}
+	s, err := New(c)
+	if err != nil {
+		t.Fatalf("Failed to create the server: %v", err)
+	}
+
+	pub := `{"uuid":"0c3f0a0e-7b2e-4c7e-9a3b-2f4e6b1d8c55","title":"Faults","encryption_key":"ZW5jcnlwdGlvbl9rZXkgeCBlbmNyeXB0aW9uX2tleQ==",
+		"location":"https://example.com/faults.epub","content_type":"application/epub+zip","size":10,"checksum":"YWJj"}`
+	req := httptest.NewRequest("POST", "/publications/", strings.NewReader(pub))
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("user", "password")
+	if rr := serve(s, req); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body)
+	}
+
+	// every signature fails
+	lic := `{"publication_id":"0c3f0a0e-7b2e-4c7e-9a3b-2f4e6b1d8c55","user_id":"faults","profile":"http://readium.org/lcp/basic-profile",
+		"text_hint":"hint","pass_hash":"FAEB00CA518BEA7CB11A7EF31FB6183B489B1B6EADB792BEC64A03B3F6FF80A8"}`
+	req = httptest.NewRequest("POST", "/licenses/", strings.NewReader(lic))
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("user", "password")
+	if rr := serve(s, req); rr.Code == http.StatusOK || !strings.Contains(rr.Body.String(), "injected fault") {
+		t.Errorf("Expected an injected signer fault, got %d: %s", rr.Code, rr.Body)
+	}
+	// the test document of the warmup is signed as well
+	var stats struct {
+		SignerFailed int `json:"signer_failed"`
+	}
+	if v := expvar.Get("faults"); v == nil || json.Unmarshal([]byte(v.String()), &stats) != nil || stats.SignerFailed < 1 {
+		t.Errorf("Expected the injected fault to be counted, got %v", v)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package sign
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSaturated is returned when a signature could not start before the queue deadline.
+var ErrSaturated = errors.New("signer saturated, the signature was not processed in time")
+
+// Limiter caps the number of concurrent signature operations and their rate,
+// e.g. to stay under the throttling threshold of an HSM partition. Excess operations
+// are queued, up to a deadline after which they fail with ErrSaturated.
+type Limiter struct {
+	slots    chan struct{}
+	interval time.Duration // min interval between two signatures, 0 if unlimited
+	timeout  time.Duration // max time spent in the queue
+
+	mu   sync.Mutex
+	next time.Time // earliest start of the next signature
+
+	inFlight int64
+	queued   int64
+	total    uint64
+	rejected uint64
+	waitNano int64 // cumulated wait time
+}
+
+// LimiterStats are saturation metrics of a limiter.
+type LimiterStats struct {
+	MaxConcurrent int     `json:"max_concurrent"`
+	InFlight      int64   `json:"in_flight"`
+	Queued        int64   `json:"queued"`
+	Total         uint64  `json:"total"`
+	Rejected      uint64  `json:"rejected"`
+	AvgWaitMs     float64 `json:"avg_wait_ms"`
+}
+
+// NewLimiter creates a limiter allowing maxConcurrent signatures at a time and maxRate signatures per second
+// (0 for no rate limit). Queued signatures wait at most timeout.
+func NewLimiter(maxConcurrent int, maxRate float64, timeout time.Duration) *Limiter {
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	l := &Limiter{
+		slots:   make(chan struct{}, maxConcurrent),
+		timeout: timeout,
+	}
+	if maxRate > 0 {
+		l.interval = time.Duration(float64(time.Second) / maxRate)
+	}
+	return l
+}
+
+// acquire waits for a signature slot, and returns a function releasing it.
+func (l *Limiter) acquire() (func(), error) {
+
+	start := time.Now()
+	deadline := start.Add(l.timeout)
+	atomic.AddInt64(&l.queued, 1)
+	defer atomic.AddInt64(&l.queued, -1)
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+	case <-timer.C:
+		atomic.AddUint64(&l.rejected, 1)
+		return nil, ErrSaturated
+	}
+	release := func() {
+		atomic.AddInt64(&l.inFlight, -1)
+		<-l.slots
+	}
+
+	// rate limit: reserve the next start time
+	if l.interval > 0 {
+		l.mu.Lock()
+		now := time.Now()
+		at := l.next
+		if at.Before(now) {
+			at = now
+		}
+		if at.After(deadline) {
+			l.mu.Unlock()
+			<-l.slots
+			atomic.AddUint64(&l.rejected, 1)
+			return nil, ErrSaturated
+		}
+		l.next = at.Add(l.interval)
+		l.mu.Unlock()
+		time.Sleep(time.Until(at))
+	}
+
+	atomic.AddInt64(&l.inFlight, 1)
+	atomic.AddUint64(&l.total, 1)
+	atomic.AddInt64(&l.waitNano, int64(time.Since(start)))
+	return release, nil
+}
+
+// Stats returns the current saturation metrics.
+func (l *Limiter) Stats() LimiterStats {
+	stats := LimiterStats{
+		MaxConcurrent: cap(l.slots),
+		InFlight:      atomic.LoadInt64(&l.inFlight),
+		Queued:        atomic.LoadInt64(&l.queued),
+		Total:         atomic.LoadUint64(&l.total),
+		Rejected:      atomic.LoadUint64(&l.rejected),
+	}
+	if stats.Total > 0 {
+		stats.AvgWaitMs = float64(atomic.LoadInt64(&l.waitNano)) / float64(stats.Total) / float64(time.Millisecond)
+	}
+	return stats
+}
+
+// SumStats returns the saturation metrics of several limiters taken together,
+// e.g. those of the servers of a process.
+func SumStats(limiters ...*Limiter) LimiterStats {
+	var sum LimiterStats
+	var wait float64
+	for _, l := range limiters {
+		stats := l.Stats()
+		sum.MaxConcurrent += stats.MaxConcurrent
+		sum.InFlight += stats.InFlight
+		sum.Queued += stats.Queued
+		sum.Total += stats.Total
+		sum.Rejected += stats.Rejected
+		wait += stats.AvgWaitMs * float64(stats.Total)
+	}
+	if sum.Total > 0 {
+		sum.AvgWaitMs = wait / float64(sum.Total)
+	}
+	return sum
+}
+
+// limitedSigner is a signer front-end going through a limiter.
+type limitedSigner struct {
+	signer  Signer
+	limiter *Limiter
+}
+
+// Sign waits for a slot of the limiter, then signs.
+func (ls *limitedSigner) Sign(in interface{}) (Signature, error) {
+	release, err := ls.limiter.acquire()
+	if err != nil {
+		return Signature{}, err
+	}
+	defer release()
+	return ls.signer.Sign(in)
+}
+
+// NewLimitedSigner returns a signer whose operations go through a limiter.
+func NewLimitedSigner(signer Signer, limiter *Limiter) Signer {
+	return &limitedSigner{signer: signer, limiter: limiter}
+}
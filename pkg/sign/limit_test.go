@@ -0,0 +1,112 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package sign
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowSigner simulates an HSM, and records the max number of concurrent calls
+type slowSigner struct {
+	delay   time.Duration
+	current int32
+	max     int32
+}
+
+func (s *slowSigner) Sign(in interface{}) (Signature, error) {
+	n := atomic.AddInt32(&s.current, 1)
+	for {
+		m := atomic.LoadInt32(&s.max)
+		if n <= m || atomic.CompareAndSwapInt32(&s.max, m, n) {
+			break
+		}
+	}
+	time.Sleep(s.delay)
+	atomic.AddInt32(&s.current, -1)
+	return Signature{Algorithm: "test"}, nil
+}
+
+func TestLimiterConcurrency(t *testing.T) {
+
+	fake := &slowSigner{delay: 10 * time.Millisecond}
+	limiter := NewLimiter(3, 0, time.Second)
+	signer := NewLimitedSigner(fake, limiter)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := signer.Sign(nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if fake.max > 3 {
+		t.Errorf("Expected at most 3 concurrent signatures, got %d", fake.max)
+	}
+	stats := limiter.Stats()
+	if stats.Total != 12 || stats.InFlight != 0 || stats.Queued != 0 || stats.Rejected != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestLimiterDeadline(t *testing.T) {
+
+	fake := &slowSigner{delay: 100 * time.Millisecond}
+	limiter := NewLimiter(1, 0, 20*time.Millisecond)
+	signer := NewLimitedSigner(fake, limiter)
+
+	go signer.Sign(nil)
+	time.Sleep(5 * time.Millisecond)
+
+	// the slot is taken for longer than the queue deadline
+	if _, err := signer.Sign(nil); !errors.Is(err, ErrSaturated) {
+		t.Errorf("Expected ErrSaturated, got %v", err)
+	}
+	if limiter.Stats().Rejected != 1 {
+		t.Error("The rejected signature should be counted")
+	}
+}
+
+func TestLimiterRate(t *testing.T) {
+
+	fake := &slowSigner{}
+	limiter := NewLimiter(10, 100, time.Second) // one signature every 10ms
+	signer := NewLimitedSigner(fake, limiter)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := signer.Sign(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("5 signatures at 100/s should take at least 40ms, took %v", elapsed)
+	}
+}
+
+func TestSumStats(t *testing.T) {
+
+	a, b := NewLimiter(2, 0, time.Second), NewLimiter(3, 0, time.Second)
+	for i := 0; i < 3; i++ {
+		NewLimitedSigner(&slowSigner{}, a).Sign(nil)
+	}
+	NewLimitedSigner(&slowSigner{}, b).Sign(nil)
+
+	stats := SumStats(a, b)
+	if stats.MaxConcurrent != 5 || stats.Total != 4 || stats.InFlight != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if stats = SumStats(); stats.Total != 0 || stats.AvgWaitMs != 0 {
+		t.Errorf("Expected empty stats, got %+v", stats)
+	}
+}
@@ -15,6 +15,8 @@ import (
 	"errors"
 	"math"
 	"math/big"
+
+	"github.com/edrlab/lcp-server/pkg/canon"
 )
 
 type Signature struct {
@@ -78,12 +80,12 @@ func copyWithLeftPad(dest, src []byte) {
 // Sign signs any json structure
 func (signer *ecdsaSigner) Sign(in interface{}) (sig Signature, err error) {
 
-	canon, err := Canon(in)
+	canonical, err := canon.Marshal(in)
 	if err != nil {
 		return
 	}
 
-	hash := sha256.Sum256(canon)
+	hash := sha256.Sum256(canonical)
 	r, s, err := ecdsa.Sign(rand.Reader, signer.key, hash[:])
 	if err != nil {
 		return
@@ -111,12 +113,12 @@ type rsaSigner struct {
 // Sign returns a signature for the provided json
 func (signer *rsaSigner) Sign(in interface{}) (sig Signature, err error) {
 
-	canon, err := Canon(in)
+	canonical, err := canon.Marshal(in)
 	if err != nil {
 		return
 	}
 
-	hash := sha256.Sum256(canon)
+	hash := sha256.Sum256(canonical)
 	sig.Value, err = rsa.SignPKCS1v15(rand.Reader, signer.key, crypto.SHA256, hash[:])
 	if err != nil {
 		return
@@ -176,13 +178,13 @@ type ecdsaSignChecker struct {
 func (checker *ecdsaSignChecker) Check(in interface{}, signature []byte) (err error) {
 
 	// make the structure canonical
-	canon, err := Canon(in)
+	canonical, err := canon.Marshal(in)
 	if err != nil {
 		return
 	}
 
 	// generate a hash
-	hash := sha256.Sum256(canon)
+	hash := sha256.Sum256(canonical)
 
 	// retrieve the signature vectors
 	r := new(big.Int).SetBytes(signature[:len(signature)/2])
@@ -204,14 +206,12 @@ type rsaSignChecker struct {
 func (checker *rsaSignChecker) Check(in interface{}, signature []byte) (err error) {
 
 	// make the structure canonical
-	canon, err := Canon(in)
+	canonical, err := canon.Marshal(in)
 	if err != nil {
 		return
 	}
 
-	//fmt.Println(string(canon))
-
-	hash := sha256.Sum256(canon)
+	hash := sha256.Sum256(canonical)
 
 	// check the hash vs the public key and signature
 	err = rsa.VerifyPKCS1v15(checker.key, crypto.SHA256, hash[:], signature)
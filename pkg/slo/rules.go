@@ -0,0 +1,109 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package slo
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"gopkg.in/yaml.v2"
+)
+
+// RuleGroups is a Prometheus rule file.
+type RuleGroups struct {
+	Groups []RuleGroup `yaml:"groups"`
+}
+
+// RuleGroup is a group of Prometheus recording and alerting rules.
+type RuleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule is a Prometheus recording rule, if Record is set, or alerting rule.
+type Rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// windows of the error ratios of the license generations
+var burnWindows = []string{"5m", "30m", "1h", "6h"}
+
+// Rules returns the Prometheus rules of the service level objectives, in YAML. Recording rules compute the error
+// ratio of the license generations over several windows, and the p99 latency of the status route group, from the
+// metrics of every instance of the server. The error budget of the license generations is watched with multiwindow
+// burn rates: a fast burn, which would consume 2% of a 30 days budget in an hour, pages; a slow burn, 5% in 6 hours,
+// opens a ticket.
+func Rules(c conf.SLO) ([]byte, error) {
+	c = c.Objectives()
+	budget := round(1 - c.IssuanceSuccess)
+	latency := round(float64(c.StatusLatency) / 1000)
+
+	var rules []Rule
+	for _, window := range burnWindows {
+		rules = append(rules, Rule{
+			Record: "lcp:license_issuance_errors:ratio_rate" + window,
+			Expr: fmt.Sprintf(`sum(rate(lcp_license_issuance_total{outcome="error"}[%s])) / sum(rate(lcp_license_issuance_total{outcome=~"success|error"}[%s]))`,
+				window, window),
+		})
+	}
+	rules = append(rules,
+		Rule{
+			Record: "lcp:status_request_duration_seconds:p99_5m",
+			Expr:   "histogram_quantile(0.99, sum by (le) (rate(lcp_status_request_duration_seconds_bucket[5m])))",
+		},
+		Rule{
+			Alert: "LCPLicenseIssuanceFastBurn",
+			Expr: fmt.Sprintf("lcp:license_issuance_errors:ratio_rate1h > (14.4 * %s) and lcp:license_issuance_errors:ratio_rate5m > (14.4 * %s)",
+				formatFloat(budget), formatFloat(budget)),
+			Labels: map[string]string{"severity": "page"},
+			Annotations: map[string]string{
+				"summary":     "License generations are failing fast",
+				"description": fmt.Sprintf("The error budget of the license generations (objective %s) would be consumed in 2 days.", formatFloat(c.IssuanceSuccess)),
+			},
+		},
+		Rule{
+			Alert: "LCPLicenseIssuanceSlowBurn",
+			Expr: fmt.Sprintf("lcp:license_issuance_errors:ratio_rate6h > (6 * %s) and lcp:license_issuance_errors:ratio_rate30m > (6 * %s)",
+				formatFloat(budget), formatFloat(budget)),
+			Labels: map[string]string{"severity": "ticket"},
+			Annotations: map[string]string{
+				"summary":     "License generations are failing",
+				"description": fmt.Sprintf("The error budget of the license generations (objective %s) would be consumed in 5 days.", formatFloat(c.IssuanceSuccess)),
+			},
+		},
+		Rule{
+			Alert:  "LCPStatusLatencyHigh",
+			Expr:   fmt.Sprintf("lcp:status_request_duration_seconds:p99_5m > %s", formatFloat(latency)),
+			For:    "10m",
+			Labels: map[string]string{"severity": "page"},
+			Annotations: map[string]string{
+				"summary":     "The status documents are slow",
+				"description": fmt.Sprintf("The p99 latency of the status route group exceeds %d ms.", c.StatusLatency),
+			},
+		},
+		Rule{
+			Alert:  "LCPNotificationBacklogOld",
+			Expr:   fmt.Sprintf("max(lcp_notification_backlog_age_seconds) > %d", c.BacklogAge*60),
+			For:    "5m",
+			Labels: map[string]string{"severity": "ticket"},
+			Annotations: map[string]string{
+				"summary":     "Revocations are not confirmed by their channels",
+				"description": fmt.Sprintf("A revocation notification has not been confirmed for more than %d minutes.", c.BacklogAge),
+			},
+		},
+	)
+	return yaml.Marshal(RuleGroups{Groups: []RuleGroup{{Name: "lcp-server-slo", Rules: rules}}})
+}
+
+// round removes the floating point noise of a computed objective, e.g. 1 - 0.999
+func round(f float64) float64 {
+	return math.Round(f*1e9) / 1e9
+}
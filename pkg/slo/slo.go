@@ -0,0 +1,230 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package slo measures the service level indicators of the server: the outcome of the license generations, the
+// latency of the status route group, and the age of the backlog of revocation notifications. They are exposed in
+// the Prometheus text format, and Rules generates the alerting rules of the service level objectives.
+package slo
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/go-chi/chi/v5/middleware"
+	log "github.com/sirupsen/logrus"
+)
+
+// outcomes of a license generation
+const (
+	OUTCOME_SUCCESS      = "success"      // the license was generated
+	OUTCOME_CLIENT_ERROR = "client_error" // the request was invalid, e.g. an unknown publication: not counted by the objective
+	OUTCOME_ERROR        = "error"        // the server failed
+)
+
+// upper bounds of the buckets of the latency histogram, in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Indicators measures the service level indicators of the server.
+type Indicators struct {
+	Objectives conf.SLO
+	Stores     []stor.Store // stores whose backlog of revocation notifications is measured, e.g. of each region
+	Tenants    *Tenants     // metrics by tenant, if any
+
+	generated, clientErrors, failed uint64
+	latency                         *histogram
+}
+
+// Stats are the service level indicators since the start of the server.
+type Stats struct {
+	IssuanceSuccess float64 `json:"issuance_success"` // ratio of the license generations which did not fail; 1 if none
+	StatusP99       float64 `json:"status_p99_ms"`    // estimated p99 latency of the status route group, in milliseconds
+}
+
+// NewIndicators creates the indicators of a server; the objective of the status latency is a bucket of the histogram.
+func NewIndicators(c conf.SLO, stores ...stor.Store) *Indicators {
+	c = c.Objectives()
+	bounds := append([]float64{}, latencyBuckets...)
+	objective := float64(c.StatusLatency) / 1000
+	if i := sort.SearchFloat64s(bounds, objective); i == len(bounds) || bounds[i] != objective {
+		bounds = append(bounds, objective)
+		sort.Float64s(bounds)
+	}
+	return &Indicators{Objectives: c, Stores: stores, latency: newHistogram(bounds)}
+}
+
+// Issuance counts the outcomes of the license generations of the routes it wraps. The requests shed before
+// reaching the routes are counted by the metrics of the load shedding.
+func (in *Indicators) Issuance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		switch status := ww.Status(); {
+		case status >= 500:
+			atomic.AddUint64(&in.failed, 1)
+		case status >= 400:
+			atomic.AddUint64(&in.clientErrors, 1)
+		default:
+			atomic.AddUint64(&in.generated, 1)
+			setIssued(r.Context())
+		}
+	})
+}
+
+// Status measures the latency of the requests of the status route group.
+func (in *Indicators) Status(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		in.latency.observe(time.Since(start).Seconds())
+	})
+}
+
+// Stats returns the indicators since the start of the server.
+func (in *Indicators) Stats() Stats {
+	stats := Stats{IssuanceSuccess: 1}
+	generated, failed := atomic.LoadUint64(&in.generated), atomic.LoadUint64(&in.failed)
+	if generated+failed > 0 {
+		stats.IssuanceSuccess = float64(generated) / float64(generated+failed)
+	}
+	stats.StatusP99 = math.Round(in.latency.quantile(0.99) * 1000)
+	return stats
+}
+
+// ServeHTTP writes the indicators in the Prometheus text format. The age of the backlog of revocation
+// notifications is measured at each scrape, and left out if a database can't be reached.
+func (in *Indicators) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	fmt.Fprintln(w, "# HELP lcp_license_issuance_total License generations, by outcome.")
+	fmt.Fprintln(w, "# TYPE lcp_license_issuance_total counter")
+	for _, c := range []struct {
+		outcome string
+		n       *uint64
+	}{{OUTCOME_SUCCESS, &in.generated}, {OUTCOME_CLIENT_ERROR, &in.clientErrors}, {OUTCOME_ERROR, &in.failed}} {
+		fmt.Fprintf(w, "lcp_license_issuance_total{outcome=%q} %d\n", c.outcome, atomic.LoadUint64(c.n))
+	}
+
+	fmt.Fprintln(w, "# HELP lcp_status_request_duration_seconds Latency of the requests of the status route group.")
+	fmt.Fprintln(w, "# TYPE lcp_status_request_duration_seconds histogram")
+	in.latency.write(w, "lcp_status_request_duration_seconds")
+
+	if in.Tenants != nil {
+		in.Tenants.write(w)
+	}
+
+	age, err := in.backlogAge(r)
+	if err != nil {
+		log.Errorf("Failed to measure the backlog of revocation notifications: %v", err)
+		return
+	}
+	fmt.Fprintln(w, "# HELP lcp_notification_backlog_age_seconds Age of the oldest unconfirmed revocation notification, 0 if none.")
+	fmt.Fprintln(w, "# TYPE lcp_notification_backlog_age_seconds gauge")
+	fmt.Fprintf(w, "lcp_notification_backlog_age_seconds %s\n", formatFloat(math.Round(age.Seconds())))
+}
+
+// backlogAge returns the age of the oldest unconfirmed revocation notification of the stores
+func (in *Indicators) backlogAge(r *http.Request) (time.Duration, error) {
+	var age time.Duration
+	for _, st := range in.Stores {
+		pending, err := st.WithContext(r.Context()).Propagation().Pending(1)
+		if err != nil {
+			return 0, err
+		}
+		if len(*pending) > 0 {
+			if a := time.Since((*pending)[0].CreatedAt); a > age {
+				age = a
+			}
+		}
+	}
+	return age, nil
+}
+
+// histogram counts observations in buckets, as a Prometheus histogram
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64 // upper bounds of the buckets, sorted
+	counts []uint64  // observations by bucket, the last one being unbounded
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += v
+}
+
+// quantile estimates a quantile by linear interpolation in its bucket, as histogram_quantile does;
+// a quantile beyond the last bound is estimated to the last bound
+func (h *histogram) quantile(q float64) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var total uint64
+	for _, n := range h.counts {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := q * float64(total)
+	var cumulated uint64
+	for i, n := range h.counts {
+		if float64(cumulated+n) < rank || n == 0 {
+			cumulated += n
+			continue
+		}
+		if i == len(h.bounds) {
+			return h.bounds[len(h.bounds)-1]
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = h.bounds[i-1]
+		}
+		return lower + (h.bounds[i]-lower)*(rank-float64(cumulated))/float64(n)
+	}
+	return h.bounds[len(h.bounds)-1]
+}
+
+// write writes the cumulative buckets, the sum and the count of the histogram
+func (h *histogram) write(w io.Writer, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var cumulated uint64
+	for i, n := range h.counts {
+		cumulated += n
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = formatFloat(h.bounds[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, le, cumulated)
+	}
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, cumulated)
+}
+
+// labelValue escapes the value of a label
+func labelValue(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package slo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/stortest"
+)
+
+func TestIndicators(t *testing.T) {
+
+	st := stortest.SQLite()(t)
+	pub := stortest.CreatePublications(t, st, 1, "application/epub+zip")[0]
+	license := stortest.CreateLicenses(t, st, 1, pub.UUID, "user1")[0]
+	if err := st.Propagation().Start(license.UUID, []string{"provider"}); err != nil {
+		t.Fatal(err)
+	}
+	in := NewIndicators(conf.SLO{StatusLatency: 300}, st)
+
+	// license generations, by outcome
+	for _, status := range []int{http.StatusCreated, http.StatusCreated, http.StatusCreated, http.StatusNotFound, http.StatusInternalServerError} {
+		handler := in.Issuance(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(status) }))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/licenses/", nil))
+	}
+	// latencies of the status documents
+	for i := 0; i < 100; i++ {
+		in.latency.observe(0.02)
+	}
+	in.latency.observe(2)
+	in.Status(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/status/123", nil))
+
+	if stats := in.Stats(); stats.IssuanceSuccess != 0.75 || stats.StatusP99 < 10 || stats.StatusP99 > 25 {
+		t.Errorf("Unexpected indicators %+v", stats)
+	}
+
+	rr := httptest.NewRecorder()
+	in.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body := rr.Body.String()
+	for _, line := range []string{
+		`lcp_license_issuance_total{outcome="success"} 3`,
+		`lcp_license_issuance_total{outcome="client_error"} 1`,
+		`lcp_license_issuance_total{outcome="error"} 1`,
+		`lcp_status_request_duration_seconds_bucket{le="0.3"} 101`,
+		`lcp_status_request_duration_seconds_bucket{le="+Inf"} 102`,
+		`lcp_status_request_duration_seconds_count 102`,
+		"lcp_notification_backlog_age_seconds ",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("Expected %s in the metrics, got %s", line, body)
+		}
+	}
+	if age, err := in.backlogAge(httptest.NewRequest("GET", "/metrics", nil)); err != nil || age < 0 || age > time.Minute {
+		t.Errorf("Expected a recent unconfirmed notification, got %v, %v", age, err)
+	}
+}
+
+func TestTenants(t *testing.T) {
+
+	in := NewIndicators(conf.SLO{})
+	in.Tenants = NewTenants(conf.Metrics{MaxTenants: 2, Tenants: []string{"big"}})
+	in.Tenants.Resolve = func(r *http.Request) string { return r.Header.Get("X-Provider") }
+
+	// the handler sets the tenant of the request, or it is resolved from the request
+	serve := func(group, provider, resolved string, status int, body string) {
+		handler := in.Tenants.Measure(group)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if provider != "" {
+				SetTenant(r.Context(), provider)
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}))
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Provider", resolved)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	serve(GROUP_CONTENT, "small", "", http.StatusOK, "0123456789")
+	serve(GROUP_CONTENT, "small", "", http.StatusNotFound, "not found")
+	serve("status", "big", "", http.StatusInternalServerError, "")
+	serve("status", "", "", http.StatusNotFound, "")
+	serve("admin", "", "tiny", http.StatusOK, "")
+
+	// a nested measure sets the group of the license generations
+	issue := in.Tenants.Measure("admin")(in.Tenants.Measure("licenses")(in.Issuance(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetTenant(r.Context(), "big")
+		w.WriteHeader(http.StatusCreated)
+	}))))
+	issue.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/licenses/", nil))
+
+	rr := httptest.NewRecorder()
+	in.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body := rr.Body.String()
+	for _, line := range []string{
+		`lcp_tenant_downloads_total{tenant="small",group="content"} 1`,
+		`lcp_tenant_downloaded_bytes_total{tenant="small",group="content"} 10`,
+		`lcp_tenant_requests_total{tenant="small",group="content"} 2`,
+		`lcp_tenant_errors_total{tenant="big",group="status"} 1`,
+		`lcp_tenant_requests_total{tenant="none",group="status"} 1`,
+		`lcp_tenant_requests_total{tenant="other",group="admin"} 1`,
+		`lcp_tenant_licenses_issued_total{tenant="big",group="licenses"} 1`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("Expected %s in the metrics, got %s", line, body)
+		}
+	}
+	if strings.Contains(body, `tenant="tiny"`) {
+		t.Errorf("Expected the tenants beyond the max number to be counted as other, got %s", body)
+	}
+	if strings.Contains(body, `{tenant="big",group="admin"}`) {
+		t.Errorf("Expected the license generation to be measured in its nested group only, got %s", body)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package slo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// labels of the tenants which are not labeled by their provider
+const (
+	TENANT_NONE  = "none"  // the tenant of the request is unknown, e.g. a missing license
+	TENANT_OTHER = "other" // beyond the max number of labeled tenants
+)
+
+// route group whose successful responses are counted as downloads
+const GROUP_CONTENT = "content"
+
+// Tenants measures the requests of each tenant, i.e. provider, by route group: their number, errors and duration,
+// the licenses generated and the content downloaded. The tenant of a request is set by its handler with SetTenant,
+// or given by Resolve, e.g. from the provider a client is restricted to. Tenants are labeled by order of appearance,
+// after the tenants of the configuration, up to a max number; the following ones are counted as "other".
+type Tenants struct {
+	Config  conf.Metrics
+	Resolve func(r *http.Request) string // tenant of a request whose handler set none; none if nil
+
+	mu       sync.Mutex
+	labels   map[string]bool                 // labeled tenants
+	counters map[tenantGroup]*tenantCounters // by tenant label and route group
+}
+
+type tenantGroup struct {
+	tenant, group string
+}
+
+type tenantCounters struct {
+	requests, errors      uint64
+	duration              float64 // total, in seconds
+	issued                uint64  // generated licenses
+	downloads, downloaded uint64  // successful downloads and their bytes
+}
+
+// tenantKey is the key of the tenant of a request in its context
+type tenantKey struct{}
+
+// tenantHolder is set by the handler of a request, which may run in another goroutine, e.g. with a timeout
+type tenantHolder struct {
+	mu       sync.Mutex
+	provider string
+	group    string // route group set by a nested Measure, if any
+	issued   bool
+}
+
+// NewTenants creates the per-tenant metrics of a configuration.
+func NewTenants(c conf.Metrics) *Tenants {
+	t := &Tenants{Config: c, labels: make(map[string]bool), counters: make(map[tenantGroup]*tenantCounters)}
+	for _, tenant := range c.Tenants {
+		t.labels[tenant] = true
+	}
+	return t
+}
+
+// SetTenant sets the tenant of a request measured by Tenants, once its provider is known.
+func SetTenant(ctx context.Context, provider string) {
+	if h, ok := ctx.Value(tenantKey{}).(*tenantHolder); ok {
+		h.mu.Lock()
+		h.provider = provider
+		h.mu.Unlock()
+	}
+}
+
+// TenantFromContext returns the tenant set on a request measured by Tenants, or an empty string.
+func TenantFromContext(ctx context.Context) string {
+	if h, ok := ctx.Value(tenantKey{}).(*tenantHolder); ok {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return h.provider
+	}
+	return ""
+}
+
+// setIssued records that a license was generated by a request
+func setIssued(ctx context.Context) {
+	if h, ok := ctx.Value(tenantKey{}).(*tenantHolder); ok {
+		h.mu.Lock()
+		h.issued = true
+		h.mu.Unlock()
+	}
+}
+
+// Measure measures the requests of a route group by tenant. A nested Measure sets the route group of the requests
+// of its routes, which are measured once.
+func (t *Tenants) Measure(group string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if outer, ok := r.Context().Value(tenantKey{}).(*tenantHolder); ok {
+				outer.mu.Lock()
+				outer.group = group
+				outer.mu.Unlock()
+				next.ServeHTTP(w, r)
+				return
+			}
+			holder := &tenantHolder{}
+			r = r.WithContext(context.WithValue(r.Context(), tenantKey{}, holder))
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			start := time.Now()
+			next.ServeHTTP(ww, r)
+
+			holder.mu.Lock()
+			tenant, issued, inner := holder.provider, holder.issued, holder.group
+			holder.mu.Unlock()
+			if inner != "" {
+				group = inner
+			}
+			if tenant == "" && t.Resolve != nil {
+				tenant = t.Resolve(r)
+			}
+			t.record(tenant, group, ww.Status(), uint64(ww.BytesWritten()), time.Since(start), issued)
+		})
+	}
+}
+
+// label returns the label of a tenant, labeling it if the max number of tenants is not reached
+func (t *Tenants) label(tenant string) string {
+	switch {
+	case tenant == "":
+		return TENANT_NONE
+	case t.labels[tenant]:
+		return tenant
+	case len(t.labels) < t.Config.TenantLimit():
+		t.labels[tenant] = true
+		return tenant
+	}
+	return TENANT_OTHER
+}
+
+func (t *Tenants) record(tenant, group string, status int, bytes uint64, d time.Duration, issued bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := tenantGroup{t.label(tenant), group}
+	c, ok := t.counters[key]
+	if !ok {
+		c = &tenantCounters{}
+		t.counters[key] = c
+	}
+	c.requests++
+	c.duration += d.Seconds()
+	if status >= 500 {
+		c.errors++
+	}
+	if issued {
+		c.issued++
+	}
+	if group == GROUP_CONTENT && status >= 200 && status < 300 {
+		c.downloads++
+		c.downloaded += bytes
+	}
+}
+
+// write writes the metrics of the tenants in the Prometheus text format
+func (t *Tenants) write(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys := make([]tenantGroup, 0, len(t.counters))
+	for key := range t.counters {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].tenant != keys[j].tenant {
+			return keys[i].tenant < keys[j].tenant
+		}
+		return keys[i].group < keys[j].group
+	})
+
+	for _, m := range []struct {
+		name, typ, help string
+		value           func(c *tenantCounters) string
+	}{
+		{"lcp_tenant_requests_total", "counter", "Requests, by tenant and route group.",
+			func(c *tenantCounters) string { return fmt.Sprint(c.requests) }},
+		{"lcp_tenant_errors_total", "counter", "Requests failed on the server, by tenant and route group.",
+			func(c *tenantCounters) string { return fmt.Sprint(c.errors) }},
+		{"lcp_tenant_request_duration_seconds_total", "counter", "Total duration of the requests, by tenant and route group.",
+			func(c *tenantCounters) string { return formatFloat(c.duration) }},
+		{"lcp_tenant_licenses_issued_total", "counter", "Generated licenses, by tenant.",
+			func(c *tenantCounters) string { return fmt.Sprint(c.issued) }},
+		{"lcp_tenant_downloads_total", "counter", "Successful downloads of content, by tenant.",
+			func(c *tenantCounters) string { return fmt.Sprint(c.downloads) }},
+		{"lcp_tenant_downloaded_bytes_total", "counter", "Bytes of the downloaded content, by tenant.",
+			func(c *tenantCounters) string { return fmt.Sprint(c.downloaded) }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s{tenant=\"%s\",group=\"%s\"} %s\n", m.name, labelValue(key.tenant), labelValue(key.group), m.value(t.counters[key]))
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+// Action data model
+// An action is a status change of a license scheduled at a future time, e.g. the revocation ending a promotion
+// at midnight. Pending actions are executed by the scheduler, and can be cancelled until then.
+type Action struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time  `json:"created"`
+	LicenseID string     `json:"-" gorm:"size:36;index"`
+	Type      string     `json:"type" validate:"required,oneof=revoke renew"`
+	At        time.Time  `json:"at" validate:"required"`
+	End       *time.Time `json:"end,omitempty"` // new end date of a renew; by default from the configuration
+	Reason    string     `json:"reason,omitempty" validate:"max=255"`
+	Actor     string     `json:"actor,omitempty" validate:"max=255"`
+	Executed  *time.Time `json:"executed,omitempty" gorm:"index"`
+	Error     string     `json:"error,omitempty"` // cause of a failed execution
+}
+
+// List of action types
+const (
+	ACTION_REVOKE = "revoke" // revokes an active license, or cancels a ready one
+	ACTION_RENEW  = "renew"  // extends a license
+)
+
+// Validate checks required fields and values
+func (a *Action) Validate() error {
+
+	validate := validator.New()
+	return validate.Struct(a)
+}
+
+// List returns the actions scheduled on a license, in the order of execution.
+func (s actionStore) List(licenseID string) (*[]Action, error) {
+	actions := []Action{}
+	return &actions, s.db.Where("license_id = ?", licenseID).Order("at ASC, id ASC").Find(&actions).Error
+}
+
+func (s actionStore) Get(licenseID string, id uint) (*Action, error) {
+	var action Action
+	return &action, s.db.Where("license_id = ? AND id = ?", licenseID, id).First(&action).Error
+}
+
+func (s actionStore) Create(newAction *Action) error {
+	return s.db.Create(newAction).Error
+}
+
+// Delete cancels a pending action; an executed action is not found.
+func (s actionStore) Delete(a *Action) error {
+	result := s.db.Where("executed IS NULL").Delete(a)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Due returns the pending actions scheduled at or before a time, the earliest first.
+func (s actionStore) Due(t time.Time, limit int) (*[]Action, error) {
+	actions := []Action{}
+	return &actions, s.db.Limit(limit).Where("executed IS NULL AND at <= ?", t).Order("at ASC, id ASC").Find(&actions).Error
+}
+
+// Done records the execution of an action at a time, and the cause of its failure, if any.
+// A failed action is not executed again.
+func (s actionStore) Done(a *Action, t time.Time, cause error) error {
+	a.Executed = &t
+	a.Error = ""
+	if cause != nil {
+		a.Error = cause.Error()
+		if len(a.Error) > 255 {
+			a.Error = a.Error[:255]
+		}
+	}
+	return s.db.Save(a).Error
+}
@@ -0,0 +1,176 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// QueryPlan is the execution plan of a hot query of the repositories, as explained by the database.
+type QueryPlan struct {
+	Query    string   // repository method running the query
+	SQL      string   // query, with sample values
+	Plan     []string // lines of the plan
+	Scans    []string // tables read by a sequential scan, which an index would avoid
+	Filesort bool     // the rows are sorted without an index
+}
+
+// Ok tells whether the query is served by indexes.
+func (p QueryPlan) Ok() bool {
+	return len(p.Scans) == 0 && !p.Filesort
+}
+
+// hotQueries are the queries run at each request of the reading applications and of the license generations,
+// or by the background tasks, built as the repositories build them, with sample values
+var hotQueries = []struct {
+	query string
+	build func(tx *gorm.DB) *gorm.DB
+}{
+	{"License().Get", func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("uuid = ?", "license").First(&LicenseInfo{})
+	}},
+	{"License().Get, archived", func(tx *gorm.DB) *gorm.DB {
+		return tx.Table(archiveTable).Where("uuid = ?", "license").First(&LicenseInfo{})
+	}},
+	{"License().FindByUser", func(tx *gorm.DB) *gorm.DB {
+		return tx.Limit(1000).Where("user_id IN ?", []string{"user"}).Order("id ASC").Find(&[]LicenseInfo{})
+	}},
+	{"License().FindByPublication", func(tx *gorm.DB) *gorm.DB {
+		return tx.Limit(1000).Where("publication_id = ?", "publication").Order("id ASC").Find(&[]LicenseInfo{})
+	}},
+	{"License().FindByStatus", func(tx *gorm.DB) *gorm.DB {
+		return tx.Limit(1000).Where("status = ?", STATUS_REVOKED).Order("id ASC").Find(&[]LicenseInfo{})
+	}},
+	{"Publication().Get", func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("uuid = ?", "publication").First(&Publication{})
+	}},
+	{"Publication().GetSample", func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("sample_of = ?", "publication").First(&Publication{})
+	}},
+	{"Event().List", func(tx *gorm.DB) *gorm.DB {
+		return tx.Limit(500).Where("license_id= ?", "license").Order("id ASC").Find(&[]Event{})
+	}},
+	{"Event().GetByDevice", func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("license_id= ? and device_id= ?", "license", "device").First(&Event{})
+	}},
+	{"Device().Get", func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("license_id = ? AND device_id = ?", "license", "device").First(&Device{})
+	}},
+	{"Propagation().Pending", func(tx *gorm.DB) *gorm.DB {
+		return tx.Limit(100).Where("confirmed IS NULL").Order("id ASC").Find(&[]Propagation{})
+	}},
+	{"LicenseCache().Get", func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("hash = ? AND created_at > ?", "hash", time.Now().Add(-time.Hour)).First(&CachedLicense{})
+	}},
+	{"Usage().List", func(tx *gorm.DB) *gorm.DB {
+		day := time.Now().UTC().Format(DayFormat)
+		return tx.Where("publication_id = ? AND day >= ? AND day <= ?", "publication", day, day).Order("day ASC").Find(&[]PublicationUsage{})
+	}},
+}
+
+var (
+	sqliteScan   = regexp.MustCompile(`^(?:SCAN|SEARCH) (?:TABLE )?(\w+)`)
+	postgresScan = regexp.MustCompile(`(?:Seq Scan|Index Scan using \w+) on (\w+)`)
+	// the index of the soft deletes matches every row which is not deleted: a query served by it reads the whole table
+	softDeleteIndex = regexp.MustCompile(`idx_\w+_deleted_at`)
+)
+
+// Analyze explains the hot queries of the repositories on the database identified by a data source name, and
+// reports the sequential scans and the sorts without index. The plans depend on the statistics of the database:
+// on a small database, a sequential scan may be cheaper than an index and chosen by the planner.
+func Analyze(dsn string) ([]QueryPlan, error) {
+	db, err := openDB(dsn)
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	defer sqlDB.Close()
+
+	explain := "EXPLAIN "
+	if db.Dialector.Name() == "sqlite" {
+		explain = "EXPLAIN QUERY PLAN "
+	}
+	plans := make([]QueryPlan, 0, len(hotQueries))
+	for _, q := range hotQueries {
+		// the query is explained with its parameters, as the sample values inlined by ToSQL may be misquoted
+		stmt := q.build(db.Session(&gorm.Session{DryRun: true})).Statement
+		plan := QueryPlan{Query: q.query, SQL: db.Dialector.Explain(stmt.SQL.String(), stmt.Vars...)}
+		rows, err := explainRows(db, explain+stmt.SQL.String(), stmt.Vars...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to explain %s: %w", q.query, err)
+		}
+		for _, row := range rows {
+			switch db.Dialector.Name() {
+			case "sqlite":
+				detail := row["detail"]
+				plan.Plan = append(plan.Plan, detail)
+				if m := sqliteScan.FindStringSubmatch(detail); m != nil && (!strings.Contains(detail, " USING ") || softDeleteIndex.MatchString(detail)) {
+					plan.Scans = append(plan.Scans, m[1])
+				}
+				plan.Filesort = plan.Filesort || strings.HasPrefix(detail, "USE TEMP B-TREE")
+			case "postgres":
+				line := row["QUERY PLAN"]
+				plan.Plan = append(plan.Plan, line)
+				if m := postgresScan.FindStringSubmatch(line); m != nil && (strings.Contains(line, "Seq Scan") || softDeleteIndex.MatchString(line)) {
+					plan.Scans = append(plan.Scans, m[1])
+				}
+				plan.Filesort = plan.Filesort || strings.HasPrefix(strings.TrimLeft(line, " ->"), "Sort ")
+			case "mysql":
+				plan.Plan = append(plan.Plan, fmt.Sprintf("table=%s type=%s key=%s rows=%s %s",
+					row["table"], row["type"], row["key"], row["rows"], row["Extra"]))
+				if row["type"] == "ALL" || softDeleteIndex.MatchString(row["key"]) {
+					plan.Scans = append(plan.Scans, row["table"])
+				}
+				plan.Filesort = plan.Filesort || strings.Contains(row["Extra"], "Using filesort")
+			}
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+// explainRows returns the rows of a plan, by column name
+func explainRows(db *gorm.DB, query string, vars ...interface{}) ([]map[string]string, error) {
+	rows, err := db.Raw(query, vars...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result []map[string]string
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		for i := range values {
+			values[i] = new(interface{})
+		}
+		if err = rows.Scan(values...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(columns))
+		for i, column := range columns {
+			switch v := (*values[i].(*interface{})).(type) {
+			case nil:
+				row[column] = ""
+			case []byte:
+				row[column] = string(v)
+			default:
+				row[column] = fmt.Sprint(v)
+			}
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
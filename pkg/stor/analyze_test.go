@@ -0,0 +1,48 @@
+package stor
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestAnalyze(t *testing.T) {
+
+	dsn := "sqlite3://file:" + uuid.New().String() + "?mode=memory&cache=shared"
+	m, err := NewMigrator(dsn)
+	if err != nil {
+		t.Fatalf("Failed to open the database: %v", err)
+	}
+	defer m.Close()
+	if _, err = m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	plans, err := Analyze(dsn)
+	if err != nil {
+		t.Fatalf("Failed to analyze the queries: %v", err)
+	}
+	if len(plans) != len(hotQueries) {
+		t.Fatalf("Expected %d plans, got %d", len(hotQueries), len(plans))
+	}
+	for _, p := range plans {
+		if len(p.Plan) == 0 || p.SQL == "" {
+			t.Errorf("Expected the plan of %s, got %+v", p.Query, p)
+		}
+		switch p.Query {
+		case "License().Get", "Publication().Get", "Device().Get", "LicenseCache().Get":
+			if !p.Ok() {
+				t.Errorf("Expected %s to be served by an index, got %v", p.Query, p.Plan)
+			}
+		case "License().FindByPublication":
+			// the licenses are not indexed by publication, and the index of the soft deletes does not help
+			if len(p.Scans) != 1 || p.Scans[0] != "license_infos" {
+				t.Errorf("Expected a scan of the licenses, got %v", p.Plan)
+			}
+		}
+	}
+
+	if _, err = Analyze("unknown://db"); err == nil {
+		t.Error("Expected an error for an unsupported database")
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// table of the archived licenses, whose columns are those of the licenses
+const archiveTable = "archived_licenses"
+
+// Archive moves to the archive table up to a number of licenses terminated before a time: returned, revoked
+// or cancelled since then, or expired then. Their events and devices are kept, and they are still found by Get.
+// It returns the number of archived licenses.
+func (s licenseStore) Archive(before time.Time, limit int) (int64, error) {
+	var archived int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var uuids []string
+		err := (&dbStore{db: tx, provider: s.provider}).scoped("license_infos").Model(&LicenseInfo{}).
+			Where(clause.Or(
+				clause.And(
+					clause.IN{Column: clause.Column{Name: "status"}, Values: []interface{}{STATUS_RETURNED, STATUS_REVOKED, STATUS_CANCELLED}},
+					clause.Expr{SQL: "COALESCE(status_updated, updated_at) < ?", Vars: []interface{}{before}},
+				),
+				clause.And(
+					clause.IN{Column: clause.Column{Name: "status"}, Values: []interface{}{STATUS_READY, STATUS_ACTIVE}},
+					clause.Lt{Column: clause.Column{Name: "end"}, Value: before},
+				),
+			)).
+			Order("id ASC").Limit(limit).Pluck("uuid", &uuids).Error
+		if err != nil || len(uuids) == 0 {
+			return err
+		}
+
+		// the rows are copied as is, e.g. with their encrypted personal data
+		stmt := &gorm.Statement{DB: tx}
+		if err = stmt.Parse(&LicenseInfo{}); err != nil {
+			return err
+		}
+		columns := make([]string, len(stmt.Schema.DBNames))
+		for i, name := range stmt.Schema.DBNames {
+			columns[i] = stmt.Quote(name)
+		}
+		list := strings.Join(columns, ",")
+		err = tx.Exec("INSERT INTO "+stmt.Quote(archiveTable)+" ("+list+") SELECT "+list+" FROM "+stmt.Quote("license_infos")+" WHERE uuid IN ?", uuids).Error
+		if err != nil {
+			return err
+		}
+		result := tx.Unscoped().Where("uuid IN ?", uuids).Delete(&LicenseInfo{})
+		if result.Error != nil {
+			return result.Error
+		}
+		archived = result.RowsAffected
+		return tx.Where("license_id IN ?", uuids).Delete(&CachedLicense{}).Error
+	})
+	return archived, err
+}
+
+// getArchived returns an archived license
+func (s licenseStore) getArchived(uuid string) (*LicenseInfo, error) {
+	var license LicenseInfo
+	if err := (*dbStore)(&s).scoped(archiveTable).Table(archiveTable).Where("uuid = ?", uuid).First(&license).Error; err != nil {
+		return nil, err
+	}
+	license.Archived = true
+	return &license, nil
+}
@@ -0,0 +1,187 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuditRecord data model
+// An audit record is a change of a license: its creation, the change of its rights, status or dates, its deletion.
+// It is recorded with the change, in the same transaction, and tells who made the change and which values changed.
+type AuditRecord struct {
+	ID        uint                   `json:"-" gorm:"primaryKey"`
+	Timestamp time.Time              `json:"timestamp"`
+	LicenseID string                 `json:"-" gorm:"size:36;index"`
+	Actor     string                 `json:"actor,omitempty" gorm:"size:255"` // empty for the changes made by the server itself
+	Action    string                 `json:"action" gorm:"size:16"`
+	Changes   map[string]AuditChange `json:"changes,omitempty" gorm:"serializer:json"` // changed values, by field name
+}
+
+// AuditChange is the change of a value of a license. The personal data of the user and the passphrase are
+// never recorded.
+type AuditChange struct {
+	From json.RawMessage `json:"from"`
+	To   json.RawMessage `json:"to"`
+}
+
+// List of audit actions
+const (
+	AUDIT_CREATE   = "create"
+	AUDIT_UPDATE   = "update"
+	AUDIT_DELETE   = "delete"
+	AUDIT_RESTORE  = "restore"
+	AUDIT_REGISTER = "register"
+	AUDIT_RENEW    = "renew"
+	AUDIT_RETURN   = "return"
+	AUDIT_REVOKE   = "revoke"
+	AUDIT_CANCEL   = "cancel"
+)
+
+// actorKey is the context key of the actor of the changes
+type actorKey struct{}
+
+// WithActor returns a context holding the actor of the changes made by a store bound to it, e.g. the client
+// of a request, which is recorded in the audit log of the licenses.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor of the changes, or an empty string for the server itself.
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// List returns the audit log of a license, in the order of the changes.
+func (s auditStore) List(licenseID string) (*[]AuditRecord, error) {
+	records := []AuditRecord{}
+	// security: limited to 1000 results
+	return &records, s.db.Limit(1000).Where("license_id = ?", licenseID).Order("id ASC").Find(&records).Error
+}
+
+// Since returns the audit records following a record, in the order of the changes, up to a limit. Only the records
+// of the changes made before a time are returned, so that a record of a transaction committed meanwhile with a lower
+// identifier is not skipped.
+func (s auditStore) Since(id uint, before time.Time, limit int) (*[]AuditRecord, error) {
+	records := []AuditRecord{}
+	return &records, s.db.Where("id > ? AND timestamp < ?", id, before).Order("id ASC").Limit(limit).Find(&records).Error
+}
+
+// Last returns the identifier of the last audit record, 0 if there is none.
+func (s auditStore) Last() (uint, error) {
+	var id uint
+	return id, s.db.Model(&AuditRecord{}).Select("COALESCE(MAX(id), 0)").Scan(&id).Error
+}
+
+// audit records a change of a license in a transaction, by the actor of the context of the transaction.
+// A license whose audited values have not changed is not recorded, unless it is deleted or restored.
+func audit(tx *gorm.DB, action string, before, after *LicenseInfo) error {
+	changes := auditChanges(before, after)
+	if action == "" {
+		action = auditAction(before, after, changes)
+	}
+	if len(changes) == 0 && action != AUDIT_DELETE && action != AUDIT_RESTORE {
+		return nil
+	}
+	licenseID := after.UUID
+	if licenseID == "" && before != nil {
+		licenseID = before.UUID
+	}
+	return tx.Create(&AuditRecord{
+		Timestamp: time.Now(),
+		LicenseID: licenseID,
+		Actor:     ActorFromContext(tx.Statement.Context),
+		Action:    action,
+		Changes:   changes,
+	}).Error
+}
+
+// auditAction tells the action of a change from the changed values, e.g. a new end date is a renew.
+func auditAction(before, after *LicenseInfo, changes map[string]AuditChange) string {
+	if before == nil {
+		return AUDIT_CREATE
+	}
+	if _, ok := changes["status"]; ok {
+		switch after.Status {
+		case STATUS_REVOKED, STATUS_REVOKING:
+			return AUDIT_REVOKE
+		case STATUS_CANCELLED:
+			return AUDIT_CANCEL
+		case STATUS_RETURNED:
+			return AUDIT_RETURN
+		case STATUS_ACTIVE:
+			if before.Status == STATUS_READY {
+				return AUDIT_REGISTER
+			}
+		}
+		return AUDIT_UPDATE
+	}
+	if _, ok := changes["end"]; ok && before.End != nil && after.End != nil && after.End.After(*before.End) {
+		return AUDIT_RENEW
+	}
+	if _, ok := changes["status_updated"]; ok && len(changes) == 1 {
+		// a device was registered with an active license
+		return AUDIT_REGISTER
+	}
+	return AUDIT_UPDATE
+}
+
+// auditChanges returns the audited values which differ between two versions of a license, never nil; before
+// is nil for a created license. Times are compared to the millisecond, the precision of some databases.
+func auditChanges(before, after *LicenseInfo) map[string]AuditChange {
+	if before == nil {
+		before = &LicenseInfo{}
+	}
+	prev, next := auditValues(before), auditValues(after)
+	changes := make(map[string]AuditChange)
+	for field, to := range next {
+		from := prev[field]
+		if same(from, to) {
+			continue
+		}
+		fromJSON, _ := json.Marshal(from)
+		toJSON, _ := json.Marshal(to)
+		changes[field] = AuditChange{From: fromJSON, To: toJSON}
+	}
+	return changes
+}
+
+// auditValues returns the audited values of a license, by field name: the personal data of the user and the
+// passphrase are left out.
+func auditValues(l *LicenseInfo) map[string]interface{} {
+	return map[string]interface{}{
+		"provider":       l.Provider,
+		"publication_id": l.PublicationID,
+		"start":          l.Start,
+		"end":            l.End,
+		"max_end":        l.MaxEnd,
+		"copy":           l.Copy,
+		"print":          l.Print,
+		"status":         l.Status,
+		"status_updated": l.StatusUpdated,
+		"device_count":   l.DeviceCount,
+		"bundle_id":      l.BundleID,
+		"order_ref":      l.OrderRef,
+	}
+}
+
+// same tells if two audited values are equal
+func same(a, b interface{}) bool {
+	ta, ok := a.(*time.Time)
+	if !ok {
+		return a == b
+	}
+	tb := b.(*time.Time)
+	if ta == nil || tb == nil {
+		return ta == nil && tb == nil
+	}
+	d := ta.Sub(*tb)
+	return d > -time.Millisecond && d < time.Millisecond
+}
@@ -0,0 +1,113 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// List of collection types
+const (
+	COLLECTION_SERIES = "series" // volumes of a series, ordered by position
+	COLLECTION_BUNDLE = "bundle" // publications licensed together
+)
+
+// Collection data model
+// A collection relates publications: the volumes of a series, or the publications of a bundle,
+// whose members are licensed together by a single license operation.
+type Collection struct {
+	gorm.Model
+	UUID  string `json:"uuid" validate:"required,uuid" gorm:"size:36;uniqueIndex"`
+	Type  string `json:"type" validate:"oneof=series bundle" gorm:"size:16"`
+	Title string `json:"title" validate:"required"`
+}
+
+// CollectionMember data model
+// A publication is a member of a collection once, at a given position.
+type CollectionMember struct {
+	ID            uint        `json:"-" gorm:"primaryKey"`
+	CollectionID  string      `json:"-" gorm:"size:36;uniqueIndex:idx_collection_publication"`                                             // implicit foreign key to the related collection
+	PublicationID string      `json:"publication_id" validate:"required,uuid" gorm:"size:36;uniqueIndex:idx_collection_publication;index"` // implicit foreign key to the related publication
+	Position      int         `json:"position"`
+	Collection    Collection  `json:"-" gorm:"references:UUID" validate:"-"` // the member belongs to the collection
+	Publication   Publication `json:"-" gorm:"references:UUID" validate:"-"`
+}
+
+// Validate checks required fields and values
+func (c *Collection) Validate() error {
+
+	validate := validator.New()
+	return validate.Struct(c)
+}
+
+// Validate checks required fields and values
+func (m *CollectionMember) Validate() error {
+
+	validate := validator.New()
+	return validate.Struct(m)
+}
+
+func (s collectionStore) ListAll() (*[]Collection, error) {
+	collections := []Collection{}
+	// security: limited to 1000 results
+	return &collections, s.db.Limit(1000).Order("id ASC").Find(&collections).Error
+}
+
+// FindByPublication returns the collections a publication is a member of.
+func (s collectionStore) FindByPublication(publicationID string) (*[]Collection, error) {
+	collections := []Collection{}
+	return &collections, s.db.Limit(1000).Where("uuid IN (?)",
+		s.db.Model(&CollectionMember{}).Select("collection_id").Where("publication_id = ?", publicationID)).Order("id ASC").Find(&collections).Error
+}
+
+func (s collectionStore) Get(uuid string) (*Collection, error) {
+	var collection Collection
+	return &collection, s.db.Where("uuid = ?", uuid).First(&collection).Error
+}
+
+func (s collectionStore) Create(newCollection *Collection) error {
+	return translateError(s.db.Create(newCollection).Error)
+}
+
+func (s collectionStore) Update(changedCollection *Collection) error {
+	return s.db.Save(changedCollection).Error
+}
+
+func (s collectionStore) Delete(deletedCollection *Collection) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// the members are removed with the collection, not the publications
+		if err := tx.Where("collection_id = ?", deletedCollection.UUID).Delete(&CollectionMember{}).Error; err != nil {
+			return err
+		}
+		// a hard delete allows the identifier to be reused
+		return tx.Unscoped().Delete(deletedCollection).Error
+	})
+}
+
+// ListMembers returns the members of a collection, in position order.
+func (s collectionStore) ListMembers(collectionID string) (*[]CollectionMember, error) {
+	members := []CollectionMember{}
+	// security: limited to 1000 results
+	return &members, s.db.Limit(1000).Where("collection_id = ?", collectionID).Order("position ASC, id ASC").Find(&members).Error
+}
+
+// SetMember adds a publication to a collection, or moves it if it is already a member.
+func (s collectionStore) SetMember(member *CollectionMember) error {
+	return translateError(s.db.Omit("Collection", "Publication").Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "collection_id"}, {Name: "publication_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"position"}),
+	}).Create(member).Error)
+}
+
+func (s collectionStore) GetMember(collectionID string, publicationID string) (*CollectionMember, error) {
+	var member CollectionMember
+	return &member, s.db.Where("collection_id = ? AND publication_id = ?", collectionID, publicationID).First(&member).Error
+}
+
+func (s collectionStore) DeleteMember(deletedMember *CollectionMember) error {
+	return s.db.Delete(deletedMember).Error
+}
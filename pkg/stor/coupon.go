@@ -0,0 +1,121 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+// ErrNotRedeemable is returned when a coupon is redeemed after its expiry or its last use.
+var ErrNotRedeemable = errors.New("the code is expired or has no use left")
+
+// Coupon data model
+// A coupon is an entitlement code, e.g. printed on a promo or gift card, exchanged for a license of a publication.
+// The licenses issued on redemption get the rights of the coupon; a coupon is redeemed at most max_uses times.
+type Coupon struct {
+	gorm.Model
+	Code          string      `json:"code" validate:"required,max=64" gorm:"size:64;uniqueIndex"`
+	PublicationID string      `json:"publication_id" validate:"required,uuid" gorm:"size:36;index"` // implicit foreign key to the related publication
+	Template      string      `json:"template,omitempty"`                                           // license template of the issued licenses
+	Days          int         `json:"days,omitempty" validate:"min=0"`                              // duration of the issued licenses from their redemption; no end if 0
+	Copy          *int32      `json:"copy,omitempty"`                                               // no limit if not set
+	Print         *int32      `json:"print,omitempty"`                                              // no limit if not set
+	MaxUses       int         `json:"max_uses" validate:"min=1"`
+	Uses          int         `json:"uses"`              // maintained by the server
+	Expires       *time.Time  `json:"expires,omitempty"` // end of the redemption period
+	Publication   Publication `json:"-" gorm:"references:UUID" validate:"-"`
+}
+
+// Redemption data model
+// A redemption records the license issued for a use of a coupon.
+type Redemption struct {
+	ID         uint      `json:"-" gorm:"primaryKey"`
+	CouponCode string    `json:"-" gorm:"size:64;index"`
+	LicenseID  string    `json:"license_id" gorm:"size:36"`
+	UserID     string    `json:"user_id" gorm:"size:255"`
+	Redeemed   time.Time `json:"redeemed"`
+	PII        []byte    `json:"-"` // encrypted user identifier, if enabled; see EnablePIIEncryption
+}
+
+// Validate checks required fields and values
+func (c *Coupon) Validate() error {
+
+	validate := validator.New()
+	return validate.Struct(c)
+}
+
+// Redeemable tells if a coupon can be redeemed at a given time.
+func (c *Coupon) Redeemable(t time.Time) bool {
+	return c.Uses < c.MaxUses && (c.Expires == nil || t.Before(*c.Expires))
+}
+
+func (s couponStore) ListAll() (*[]Coupon, error) {
+	coupons := []Coupon{}
+	// security: limited to 1000 results
+	return &coupons, s.db.Limit(1000).Order("id ASC").Find(&coupons).Error
+}
+
+func (s couponStore) Get(code string) (*Coupon, error) {
+	var coupon Coupon
+	return &coupon, s.db.Where("code = ?", code).First(&coupon).Error
+}
+
+func (s couponStore) Create(newCoupon *Coupon) error {
+	return translateError(s.db.Omit("Publication").Create(newCoupon).Error)
+}
+
+// CreateAll creates coupons in a single transaction: if one of them cannot be created, none is.
+func (s couponStore) CreateAll(newCoupons []*Coupon) error {
+	return translateError(s.db.Omit("Publication").Create(newCoupons).Error)
+}
+
+func (s couponStore) Update(changedCoupon *Coupon) error {
+	return s.db.Omit("Publication").Save(changedCoupon).Error
+}
+
+func (s couponStore) Delete(deletedCoupon *Coupon) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// the redemptions are deleted with the coupon, not the issued licenses
+		if err := tx.Where("coupon_code = ?", deletedCoupon.Code).Delete(&Redemption{}).Error; err != nil {
+			return err
+		}
+		// a hard delete allows the code to be reused
+		return tx.Unscoped().Delete(deletedCoupon).Error
+	})
+}
+
+// Redeem uses a coupon, and creates the issued license and its redemption record in the same transaction.
+// ErrNotRedeemable is returned if the coupon is expired or has no use left.
+func (s couponStore) Redeem(code string, license *LicenseInfo, t time.Time) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// the condition prevents concurrent redemptions from exceeding the limit
+		result := tx.Model(&Coupon{}).Where("code = ? AND uses < max_uses AND (expires IS NULL OR expires > ?)", code, t).
+			UpdateColumn("uses", gorm.Expr("uses + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotRedeemable
+		}
+		if err := tx.Create(license).Error; err != nil {
+			return err
+		}
+		if err := audit(tx, AUDIT_CREATE, nil, license); err != nil {
+			return err
+		}
+		return tx.Create(&Redemption{CouponCode: code, LicenseID: license.UUID, UserID: license.UserID, Redeemed: t}).Error
+	})
+}
+
+// ListRedemptions returns the redemptions of a coupon, in the order of redemption.
+func (s couponStore) ListRedemptions(code string) (*[]Redemption, error) {
+	redemptions := []Redemption{}
+	// security: limited to 1000 results
+	return &redemptions, s.db.Limit(1000).Where("coupon_code = ?", code).Order("id ASC").Find(&redemptions).Error
+}
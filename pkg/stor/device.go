@@ -0,0 +1,47 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Device data model
+// A device is registered once per license: the device count of a license is the number of its devices,
+// whereas the events of a license record every interaction of the devices.
+type Device struct {
+	ID         uint        `json:"-" gorm:"primaryKey"`
+	LicenseID  string      `json:"-" gorm:"size:36;uniqueIndex:idx_license_device"` // implicit foreign key to the related license
+	DeviceID   string      `json:"id" gorm:"size:255;uniqueIndex:idx_license_device"`
+	Name       string      `json:"name"`
+	Registered time.Time   `json:"registered"`
+	License    LicenseInfo `json:"-" gorm:"references:UUID"` // the device belongs to the license
+}
+
+// List returns the devices registered with a license, in the order of registration.
+func (s deviceStore) List(licenseID string) (*[]Device, error) {
+	devices := []Device{}
+	// security: limited to 1000 results
+	return &devices, s.db.Limit(1000).Where("license_id = ?", licenseID).Order("id ASC").Find(&devices).Error
+}
+
+func (s deviceStore) Get(licenseID string, deviceID string) (*Device, error) {
+	var device Device
+	return &device, s.db.Where("license_id = ? AND device_id = ?", licenseID, deviceID).First(&device).Error
+}
+
+// Register records a device with a license, and increments the device count of the license in the same transaction.
+// A device already registered with the license returns ErrDuplicate.
+func (s deviceStore) Register(device *Device) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("License").Create(device).Error; err != nil {
+			return translateError(err)
+		}
+		return tx.Model(&LicenseInfo{}).Where("uuid = ?", device.LicenseID).
+			UpdateColumn("device_count", gorm.Expr("device_count + 1")).Error
+	})
+}
@@ -0,0 +1,36 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrDuplicate is returned when an entity is created with an identifier already in use.
+var ErrDuplicate = errors.New("duplicate identifier")
+
+// uniqueViolations are the messages of the uniqueness violations reported by the database drivers
+var uniqueViolations = []string{
+	"UNIQUE constraint failed",    // sqlite
+	"duplicate key value",         // postgres
+	"Duplicate entry",             // mysql
+	"Cannot insert duplicate key", // sql server
+}
+
+// translateError wraps the uniqueness violations of the database into ErrDuplicate,
+// keeping the message of the driver.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, msg := range uniqueViolations {
+		if strings.Contains(err.Error(), msg) {
+			return fmt.Errorf("%w: %v", ErrDuplicate, err)
+		}
+	}
+	return err
+}
@@ -5,7 +5,15 @@
 package stor
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Event data model
@@ -15,9 +23,56 @@ type Event struct {
 	Timestamp  time.Time   `json:"timestamp"`
 	Type       string      `json:"type"`
 	DeviceName string      `json:"name"`
-	DeviceID   string      `json:"id" gorm:"index"`
-	LicenseID  string      `json:"-"  gorm:"index"`          // implicit foreign key to the related license
-	License    LicenseInfo `json:"-" gorm:"references:UUID"` // the event belongs to the license
+	DeviceID   string      `json:"id" gorm:"size:255;index"`
+	LicenseID  string      `json:"-"  gorm:"size:36;index"`      // implicit foreign key to the related license
+	License    LicenseInfo `json:"-" gorm:"references:UUID"`     // the event belongs to the license
+	Reason     string      `json:"-"`                            // reason of a revocation, given to the user by the status message
+	Actor      string      `json:"-"`                            // who requested a revocation
+	Previous   string      `json:"-" gorm:"size:64;uniqueIndex"` // digest of the previous event of the chain, empty for the first one
+	Digest     string      `json:"-" gorm:"size:64"`             // digest of the event, chained to the previous one; empty for events recorded before the chaining
+}
+
+// ChainHead is the head of the chain of the events, a single row locked by each append so that concurrent events
+// are chained one after the other.
+type ChainHead struct {
+	ID     uint   `gorm:"primaryKey"`
+	Digest string `gorm:"size:64"` // digest of the latest chained event, empty if there is none
+}
+
+// identifier of the single row of the chain head
+const chainHeadID = 1
+
+// ChainReport is the result of the verification of the hash chain of the events.
+type ChainReport struct {
+	Checked   int64  `json:"checked"`              // chained events
+	Unchained int64  `json:"unchained"`            // events recorded before the chaining, not covered by the verification
+	Head      string `json:"head,omitempty"`       // digest of the latest event, which auditors record to detect a later truncation
+	Valid     bool   `json:"valid"`                // the chain has not been rewritten
+	BrokenAt  uint   `json:"broken_at,omitempty"`  // identifier of the first event which does not match the chain
+	LicenseID string `json:"license_id,omitempty"` // license of this event
+	Reason    string `json:"reason,omitempty"`     // why the event does not match the chain
+}
+
+// Intact tells if the digest of a chained event matches its content and its link to the previous event.
+func (e *Event) Intact() bool {
+	return e.Digest != "" && e.Digest == e.digest()
+}
+
+// digest returns the digest of an event, which covers its previous digest: changing or removing an event
+// invalidates every following event.
+func (e *Event) digest() string {
+	data, _ := json.Marshal(struct {
+		Previous   string `json:"previous"`
+		Timestamp  int64  `json:"timestamp"`
+		Type       string `json:"type"`
+		DeviceName string `json:"device_name"`
+		DeviceID   string `json:"device_id"`
+		LicenseID  string `json:"license_id"`
+		Reason     string `json:"reason"`
+		Actor      string `json:"actor"`
+	}{e.Previous, e.Timestamp.UnixMilli(), e.Type, e.DeviceName, e.DeviceID, e.LicenseID, e.Reason, e.Actor})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
 }
 
 func (s eventStore) List(licenseID string) (*[]Event, error) {
@@ -26,14 +81,21 @@ func (s eventStore) List(licenseID string) (*[]Event, error) {
 	return &events, s.db.Limit(500).Where("license_id= ?", licenseID).Order("id ASC").Find(&events).Error
 }
 
+// Since returns the events following an event, in the order of the chain, up to a limit. Only the events
+// recorded before a time are returned, so that an event recorded meanwhile with a lower identifier is not skipped.
+func (s eventStore) Since(id uint, before time.Time, limit int) (*[]Event, error) {
+	events := []Event{}
+	return &events, s.db.Where("id > ? AND timestamp < ?", id, before).Order("id ASC").Limit(limit).Find(&events).Error
+}
+
 func (s eventStore) GetByDevice(licenseID string, deviceID string) (*Event, error) {
 	var event Event
-	return &event, s.db.First(&event).Where("license_id= ? and device_id= ?", licenseID, deviceID).Error
+	return &event, s.db.Where("license_id= ? and device_id= ?", licenseID, deviceID).First(&event).Error
 }
 
 func (s eventStore) Count(licenseID string) (int64, error) {
 	var count int64
-	return count, s.db.Model(Event{}).Count(&count).Error
+	return count, s.db.Model(Event{}).Where("license_id= ?", licenseID).Count(&count).Error
 }
 
 func (s eventStore) Get(id uint) (*Event, error) {
@@ -41,14 +103,70 @@ func (s eventStore) Get(id uint) (*Event, error) {
 	return &event, s.db.Where("id = ?", id).First(&event).Error
 }
 
+// Create appends an event to the chain of the events of the database. The head of the chain is locked until the
+// event is recorded, so that concurrent events wait for each other instead of forking the chain; the unique previous
+// digest is a safeguard. The head is locked by a first write, as SQLite ignores row locks.
+// The timestamp is truncated to the millisecond, the precision of every database, so that the digest can be verified.
 func (s eventStore) Create(newEvent *Event) error {
-	return s.db.Create(newEvent).Error
+	newEvent.Timestamp = newEvent.Timestamp.Truncate(time.Millisecond)
+	return translateError(s.db.Transaction(func(tx *gorm.DB) error {
+		var head ChainHead
+		err := tx.Model(&head).Where("id = ?", chainHeadID).UpdateColumn("digest", gorm.Expr("digest")).Error
+		if err == nil {
+			err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&head, chainHeadID).Error
+		}
+		if err != nil {
+			return fmt.Errorf("failed to lock the head of the chain of events: %w", err)
+		}
+		newEvent.Previous = head.Digest
+		newEvent.Digest = newEvent.digest()
+		if err := tx.Create(newEvent).Error; err != nil {
+			return err
+		}
+		return tx.Model(&head).UpdateColumn("digest", newEvent.Digest).Error
+	}))
 }
 
+// Update changes an event, which keeps its place in the chain: the change is reported by Verify.
 func (s eventStore) Update(changedEvent *Event) error {
-	return s.db.Omit("License").Save(changedEvent).Error
+	return s.db.Omit("License", "Previous", "Digest").Save(changedEvent).Error
+}
+
+// Verify walks the chain of the events of the database, from the first one, and reports the first event
+// whose digest or link to the previous event does not match, e.g. because an event was changed or deleted.
+func (s eventStore) Verify() (*ChainReport, error) {
+	report := &ChainReport{Valid: true}
+	if err := s.db.Model(&Event{}).Where("digest IS NULL OR digest = ''").Count(&report.Unchained).Error; err != nil {
+		return nil, err
+	}
+	var batch []Event
+	err := s.db.Where("digest <> ''").FindInBatches(&batch, 500, func(tx *gorm.DB, n int) error {
+		for i := range batch {
+			e := &batch[i]
+			switch {
+			case e.Previous != report.Head:
+				report.Reason = "the previous event of the chain was changed or deleted"
+			case e.Digest != e.digest():
+				report.Reason = "the event was changed"
+			default:
+				report.Checked++
+				report.Head = e.Digest
+				continue
+			}
+			report.Valid, report.BrokenAt, report.LicenseID = false, e.ID, e.LicenseID
+			return errChainBroken
+		}
+		return nil
+	}).Error
+	if err != nil && !errors.Is(err, errChainBroken) {
+		return nil, fmt.Errorf("failed to verify the chain of events: %w", err)
+	}
+	return report, nil
 }
 
+// errChainBroken stops the verification of the chain at the first mismatch
+var errChainBroken = errors.New("broken chain")
+
 func (s eventStore) Delete(deletedEvent *Event) error {
 	return s.db.Delete(deletedEvent).Error
 }
@@ -1,8 +1,13 @@
 package stor
 
 import (
+	"fmt"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 func TestEvent(t *testing.T) {
@@ -22,6 +27,10 @@ func TestEvent(t *testing.T) {
 	if !found {
 		t.Fatalf("Failed to get the publication associated with a license: %v", err)
 	}
+	// fresh uuids, as the shared fixtures are stored by other tests
+	p.UUID = uuid.New().String()
+	l.UUID = uuid.New().String()
+	l.PublicationID = p.UUID
 	err = St.Publication().Create(&p)
 	if err != nil {
 		t.Fatalf("Failed to store a publication: %v", err)
@@ -127,3 +136,97 @@ func TestEvent(t *testing.T) {
 	}
 
 }
+
+func TestEventChain(t *testing.T) {
+
+	st, err := DBSetup("sqlite3://file:" + uuid.New().String() + "?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("Failed to set up the database: %v", err)
+	}
+	pub := &Publication{UUID: uuid.New().String(), Title: "Chain", ContentType: "application/epub+zip"}
+	if err = st.Publication().Create(pub); err != nil {
+		t.Fatalf("Failed to create a publication: %v", err)
+	}
+	license := &LicenseInfo{UUID: uuid.New().String(), UserID: "user1", PublicationID: pub.UUID, Status: STATUS_READY}
+	if err = st.License().Create(license); err != nil {
+		t.Fatalf("Failed to create a license: %v", err)
+	}
+
+	// an event recorded before the chaining is not covered
+	db := st.(*dbStore).db
+	if err = db.Omit("Previous", "Digest").Create(&Event{Timestamp: time.Now(), Type: EVENT_REGISTER, DeviceID: "d0", LicenseID: license.UUID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var events []*Event
+	for i, typ := range []string{EVENT_REGISTER, EVENT_RENEW, EVENT_REVOKE} {
+		e := &Event{Timestamp: time.Now(), Type: typ, DeviceID: fmt.Sprintf("d%d", i), LicenseID: license.UUID, Reason: "test"}
+		if err = st.Event().Create(e); err != nil {
+			t.Fatalf("Failed to create an event: %v", err)
+		}
+		events = append(events, e)
+	}
+	if events[0].Previous != "" || events[1].Previous != events[0].Digest || events[2].Previous != events[1].Digest {
+		t.Error("Expected the events to be chained")
+	}
+	report, err := st.Event().Verify()
+	if err != nil || !report.Valid || report.Checked != 3 || report.Unchained != 1 || report.Head != events[2].Digest {
+		t.Errorf("Expected a valid chain, got %+v, %v", report, err)
+	}
+
+	// a rewritten revocation breaks the chain
+	events[2].Reason = "rewritten"
+	if err = st.Event().Update(events[2]); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = st.Event().Verify(); err != nil || report.Valid || report.BrokenAt != events[2].ID || report.Reason != "the event was changed" {
+		t.Errorf("Expected a changed event, got %+v, %v", report, err)
+	}
+
+	// as well as a deleted event
+	if err = st.Event().Delete(events[1]); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = st.Event().Verify(); err != nil || report.Valid || report.BrokenAt != events[2].ID || report.Checked != 1 {
+		t.Errorf("Expected a deleted event, got %+v, %v", report, err)
+	}
+}
+
+func TestEventChainConcurrency(t *testing.T) {
+
+	st, err := DBSetup("sqlite3://" + filepath.Join(t.TempDir(), "chain.sqlite"))
+	if err != nil {
+		t.Fatalf("Failed to set up the database: %v", err)
+	}
+	defer Close(st)
+	pub := &Publication{UUID: uuid.New().String(), Title: "Chain", ContentType: "application/epub+zip"}
+	if err = st.Publication().Create(pub); err != nil {
+		t.Fatalf("Failed to create a publication: %v", err)
+	}
+	license := &LicenseInfo{UUID: uuid.New().String(), UserID: "user1", PublicationID: pub.UUID, Status: STATUS_READY}
+	if err = st.License().Create(license); err != nil {
+		t.Fatalf("Failed to create a license: %v", err)
+	}
+
+	// concurrent events are appended one after the other
+	const count = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- st.Event().Create(&Event{Timestamp: time.Now(), Type: EVENT_REGISTER, DeviceID: fmt.Sprintf("d%d", i), LicenseID: license.UUID})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Failed to create an event: %v", err)
+		}
+	}
+	report, err := st.Event().Verify()
+	if err != nil || !report.Valid || report.Checked != count {
+		t.Errorf("Expected a valid chain of %d events, got %+v, %v", count, report, err)
+	}
+}
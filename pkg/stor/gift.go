@@ -0,0 +1,119 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+// ErrNotClaimable is returned when a gift is claimed or cancelled after its expiry, its claim or its cancellation.
+var ErrNotClaimable = errors.New("the gift is expired, cancelled or already claimed")
+
+// List of gift status values; a pending gift is expired after its claim period
+const (
+	GIFT_PENDING   = "pending"
+	GIFT_CLAIMED   = "claimed"
+	GIFT_CANCELLED = "cancelled"
+	GIFT_EXPIRED   = "expired"
+)
+
+// Gift data model
+// A gift is a license bought by a purchaser for a recipient, pending until the recipient claims it with the claim
+// token given by the purchaser: the license is only generated then, with the passphrase of the recipient.
+// Only a hash of the claim token is stored.
+type Gift struct {
+	gorm.Model
+	UUID          string      `json:"uuid" gorm:"size:36;uniqueIndex"`
+	TokenHash     string      `json:"-" gorm:"size:64;uniqueIndex"`
+	PublicationID string      `json:"publication_id" validate:"required,uuid" gorm:"size:36;index"` // implicit foreign key to the related publication
+	Template      string      `json:"template,omitempty"`                                           // license template of the issued license
+	Days          int         `json:"days,omitempty" validate:"min=0"`                              // duration of the issued license from its claim; no end if 0
+	Copy          *int32      `json:"copy,omitempty"`                                               // no limit if not set
+	Print         *int32      `json:"print,omitempty"`                                              // no limit if not set
+	Purchaser     string      `json:"purchaser" validate:"required,max=255" gorm:"size:255;index"`  // user identifier of the purchaser
+	Status        string      `json:"status" gorm:"size:16"`                                        // maintained by the server
+	Expires       *time.Time  `json:"expires,omitempty"`                                            // end of the claim period
+	LicenseID     string      `json:"license_id,omitempty" gorm:"size:36"`                          // license issued to the recipient
+	Claimed       *time.Time  `json:"claimed,omitempty"`
+	PII           []byte      `json:"-"` // encrypted purchaser, if enabled; see EnablePIIEncryption
+	Publication   Publication `json:"-" gorm:"references:UUID" validate:"-"`
+}
+
+// Validate checks required fields and values
+func (g *Gift) Validate() error {
+
+	validate := validator.New()
+	return validate.Struct(g)
+}
+
+// StatusAt returns the status of a gift at a given time: a pending gift past its claim period is expired.
+func (g *Gift) StatusAt(t time.Time) string {
+	if g.Status == GIFT_PENDING && g.Expires != nil && !t.Before(*g.Expires) {
+		return GIFT_EXPIRED
+	}
+	return g.Status
+}
+
+func (s giftStore) ListAll() (*[]Gift, error) {
+	gifts := []Gift{}
+	// security: limited to 1000 results
+	return &gifts, s.db.Limit(1000).Order("id ASC").Find(&gifts).Error
+}
+
+func (s giftStore) FindByPurchaser(userID string) (*[]Gift, error) {
+	gifts := []Gift{}
+	// security: limited to 1000 results
+	return &gifts, s.db.Limit(1000).Where("purchaser IN ?", userIDs(s.db, userID)).Order("id ASC").Find(&gifts).Error
+}
+
+func (s giftStore) Get(uuid string) (*Gift, error) {
+	var gift Gift
+	return &gift, s.db.Where("uuid = ?", uuid).First(&gift).Error
+}
+
+func (s giftStore) GetByToken(tokenHash string) (*Gift, error) {
+	var gift Gift
+	return &gift, s.db.Where("token_hash = ?", tokenHash).First(&gift).Error
+}
+
+func (s giftStore) Create(newGift *Gift) error {
+	return translateError(s.db.Omit("Publication").Create(newGift).Error)
+}
+
+// Cancel cancels a pending gift, even expired; ErrNotClaimable is returned if the gift was claimed or cancelled.
+func (s giftStore) Cancel(uuid string) error {
+	result := s.db.Model(&Gift{}).Where("uuid = ? AND status = ?", uuid, GIFT_PENDING).UpdateColumn("status", GIFT_CANCELLED)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotClaimable
+	}
+	return nil
+}
+
+// Claim binds a pending gift to the license issued to its recipient, created in the same transaction.
+// ErrNotClaimable is returned if the gift is expired, cancelled or already claimed.
+func (s giftStore) Claim(uuid string, license *LicenseInfo, t time.Time) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// the condition prevents concurrent claims of the same gift
+		result := tx.Model(&Gift{}).Where("uuid = ? AND status = ? AND (expires IS NULL OR expires > ?)", uuid, GIFT_PENDING, t).
+			UpdateColumns(map[string]interface{}{"status": GIFT_CLAIMED, "license_id": license.UUID, "claimed": t})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotClaimable
+		}
+		if err := tx.Create(license).Error; err != nil {
+			return err
+		}
+		return audit(tx, AUDIT_CREATE, nil, license)
+	})
+}
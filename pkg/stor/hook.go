@@ -0,0 +1,56 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// Hook is called before every database operation, with the context of the operation.
+// An error returned by the hook fails the operation.
+type Hook func(ctx context.Context) error
+
+// AddHook registers a hook on a store set up by DBSetup, e.g. to inject faults in the database layer.
+// The hook of a sharded store is registered on the database of each region.
+func AddHook(st Store, name string, hook Hook) error {
+	if sharded, ok := st.(*shardedStore); ok {
+		for _, shard := range append([]Store{sharded.Store}, Regions(sharded)...) {
+			if rs, ok := shard.(*regionStore); ok {
+				shard = rs.Store
+			}
+			if err := AddHook(shard, name, hook); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	s, ok := st.(*dbStore)
+	if !ok {
+		return errors.New("hooks are only supported by database stores")
+	}
+	callback := func(db *gorm.DB) {
+		if err := hook(db.Statement.Context); err != nil {
+			db.AddError(err)
+		}
+	}
+	cb := s.db.Callback()
+	name = "lcp:" + name
+	for _, err := range []error{
+		cb.Create().Before("*").Register(name, callback),
+		cb.Query().Before("*").Register(name, callback),
+		cb.Update().Before("*").Register(name, callback),
+		cb.Delete().Before("*").Register(name, callback),
+		cb.Row().Before("*").Register(name, callback),
+		cb.Raw().Before("*").Register(name, callback),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
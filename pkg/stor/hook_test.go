@@ -0,0 +1,48 @@
+package stor
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAddHook(t *testing.T) {
+
+	st, err := DBSetup("sqlite3://file:hooktest?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("Failed to set up the database: %v", err)
+	}
+
+	failure := errors.New("hook failure")
+	type key struct{}
+	calls := 0
+	hook := func(ctx context.Context) error {
+		calls++
+		if ctx.Value(key{}) != nil {
+			return failure
+		}
+		return nil
+	}
+	if err = AddHook(st, "test", hook); err != nil {
+		t.Fatalf("Failed to add a hook: %v", err)
+	}
+
+	if _, err = st.Publication().Count(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected the hook to be called once, got %d calls", calls)
+	}
+
+	ctx := context.WithValue(context.Background(), key{}, true)
+	if _, err = st.WithContext(ctx).Publication().Count(); !errors.Is(err, failure) {
+		t.Errorf("Expected the hook to fail the operation, got %v", err)
+	}
+	pub := Publication{UUID: "hooktest", Title: "Hook"}
+	if err = st.WithContext(ctx).Publication().Create(&pub); !errors.Is(err, failure) {
+		t.Errorf("Expected the hook to fail the creation, got %v", err)
+	}
+	if _, err = st.Publication().Get(pub.UUID); err == nil {
+		t.Error("Expected the creation to be aborted")
+	}
+}
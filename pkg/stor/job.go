@@ -0,0 +1,75 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"time"
+)
+
+// Job data model
+// A job is a bulk operation executed in the background, e.g. the revocation of a list of licenses; its items
+// are processed in order, and the result of each processed item is kept, so that the failed ones can be retried.
+type Job struct {
+	ID        uint        `json:"-" gorm:"primaryKey"`
+	CreatedAt time.Time   `json:"created"`
+	UpdatedAt time.Time   `json:"updated"`
+	UUID      string      `json:"id" gorm:"size:36;uniqueIndex"`
+	Type      string      `json:"type"`
+	Status    string      `json:"status" gorm:"size:16;index"`
+	Total     int         `json:"total"`     // number of items
+	Processed int         `json:"processed"` // number of processed items
+	Failed    int         `json:"failed"`    // number of failed items
+	Error     string      `json:"error,omitempty"`
+	Finished  *time.Time  `json:"finished,omitempty"`
+	Results   []JobResult `json:"results" gorm:"serializer:json"`
+	PII       []byte      `json:"-"` // encrypted user identifiers of the results, if enabled; see EnablePIIEncryption
+}
+
+// JobResult is the result of an item of a job.
+type JobResult struct {
+	Index  int    `json:"index"`          // position of the item in the job
+	UUID   string `json:"uuid,omitempty"` // identifier of the item, if decoded
+	Status int    `json:"status"`         // status code of the item, as if it was processed alone
+	Error  string `json:"error,omitempty"`
+	// delivery of a license created for a user, e.g. by a roster import
+	UserID string            `json:"user_id,omitempty"`
+	Links  map[string]string `json:"links,omitempty"` // urls delivered to the user, by relation
+}
+
+// List of job status values
+const (
+	JOB_RUNNING   = "running"
+	JOB_COMPLETED = "completed"
+	JOB_CANCELLED = "cancelled"
+	JOB_FAILED    = "failed"
+)
+
+// Progress returns the percentage of processed items.
+func (j *Job) Progress() int {
+	if j.Total == 0 {
+		return 100
+	}
+	return j.Processed * 100 / j.Total
+}
+
+func (s jobStore) Get(uuid string) (*Job, error) {
+	var job Job
+	return &job, s.db.Where("uuid = ?", uuid).First(&job).Error
+}
+
+func (s jobStore) Create(newJob *Job) error {
+	return translateError(s.db.Create(newJob).Error)
+}
+
+func (s jobStore) Update(changedJob *Job) error {
+	return s.db.Save(changedJob).Error
+}
+
+// Interrupt fails the running jobs, e.g. those left by a restart of the server, and returns their number.
+func (s jobStore) Interrupt(t time.Time) (int64, error) {
+	result := s.db.Model(&Job{}).Where("status = ?", JOB_RUNNING).
+		Updates(map[string]interface{}{"status": JOB_FAILED, "error": "interrupted", "finished": t})
+	return result.RowsAffected, result.Error
+}
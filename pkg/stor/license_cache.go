@@ -0,0 +1,45 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"time"
+)
+
+// CachedLicense data model
+// A cached license is a signed fresh license, stored for the set of parameters which
+// produced it. The cached licenses of a license are invalidated when the license info is updated, revoked,
+// archived or deleted; a change of another parameter, e.g. of the publication, leaves a stale entry
+// which is never hit again. Entries older than the lifetime of the cache are purged by the server.
+type CachedLicense struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	LicenseID string `gorm:"size:36;index"`
+	Hash      string `gorm:"size:64;uniqueIndex"` // hash of the generation parameters
+	Document  []byte // serialized license
+}
+
+func (s licenseCacheStore) Get(hash string, maxAge time.Duration) (*CachedLicense, error) {
+	var cached CachedLicense
+	return &cached, s.db.Where("hash = ? AND created_at > ?", hash, time.Now().Add(-maxAge)).First(&cached).Error
+}
+
+func (s licenseCacheStore) Set(cached *CachedLicense) error {
+	// replace a stale entry with the same key
+	if err := s.db.Where("hash = ?", cached.Hash).Delete(&CachedLicense{}).Error; err != nil {
+		return err
+	}
+	return s.db.Create(cached).Error
+}
+
+func (s licenseCacheStore) Invalidate(licenseID string) error {
+	return s.db.Where("license_id = ?", licenseID).Delete(&CachedLicense{}).Error
+}
+
+// Purge deletes the entries older than a max age, which can no longer be served, and returns their number.
+func (s licenseCacheStore) Purge(maxAge time.Duration) (int64, error) {
+	result := s.db.Where("created_at < ?", time.Now().Add(-maxAge)).Delete(&CachedLicense{})
+	return result.RowsAffected, result.Error
+}
@@ -5,10 +5,13 @@
 package stor
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/go-playground/validator/v10"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // LicenseInfo data model
@@ -18,19 +21,27 @@ import (
 type LicenseInfo struct {
 	gorm.Model
 	Updated       *time.Time  `json:"updated,omitempty"` // see comment above
-	UUID          string      `json:"uuid" validate:"required,uuid" gorm:"uniqueIndex"`
-	Provider      string      `json:"provider" validate:"required,url"`
-	UserID        string      `json:"user_id,omitempty" validate:"required" gorm:"index"`
+	UUID          string      `json:"uuid" validate:"required,uuid" gorm:"size:36;uniqueIndex"`
+	Provider      string      `json:"provider" validate:"required,url" gorm:"size:255;index"`
+	UserID        string      `json:"user_id,omitempty" validate:"required,max=255" gorm:"size:255;index"`
 	Start         *time.Time  `json:"start,omitempty"`
 	End           *time.Time  `json:"end,omitempty"`
 	MaxEnd        *time.Time  `json:"max_end,omitempty"`
 	Copy          int32       `json:"copy,omitempty"`
 	Print         int32       `json:"print,omitempty"`
-	Status        string      `json:"status" validate:"oneof=ready active expired cancelled revoked" gorm:"index"`
+	Status        string      `json:"status" validate:"oneof=ready active expired cancelled revoked revoking" gorm:"size:16;index"`
 	StatusUpdated *time.Time  `json:"status_updated,omitempty"`
 	DeviceCount   int         `json:"device_count"`
-	PublicationID string      `json:"publication_id" validate:"required,uuid"` // implicit foreign key to the related publication
-	Publication   Publication `gorm:"references:UUID" validate:"-"`            // the license belongs to the publication
+	PublicationID string      `json:"publication_id" validate:"required,uuid" gorm:"size:36"` // implicit foreign key to the related publication
+	Publication   Publication `gorm:"references:UUID" validate:"-"`                           // the license belongs to the publication
+	BundleID      string      `json:"bundle_id,omitempty" gorm:"size:36;index"`               // set on the licenses issued together for the members of a bundle
+	OrderRef      string      `json:"order_ref,omitempty" gorm:"size:255;index"`              // reference of the storefront order the license was sold by, if any
+	PassHash      string      `json:"-"`                                                      // set only if the passphrase was generated by the server
+	KeyCheck      []byte      `json:"-"`                                                      // key check associated with the generated passphrase
+	TextHint      string      `json:"-"`                                                      // hint of the generated passphrase
+	PassphraseID  uint        `json:"-"`                                                      // passphrase of the pool of an organization the license was issued with, if any
+	PII           []byte      `json:"-"`                                                      // encrypted personal data, if enabled; see EnablePIIEncryption
+	Archived      bool        `json:"archived,omitempty" gorm:"-"`                            // the license was moved to the archive table
 }
 
 // Validate checks required fields and values
@@ -40,57 +51,251 @@ func (l *LicenseInfo) Validate() error {
 	return validate.Struct(l)
 }
 
+// scoped returns the session of the store, restricted to the licenses of its provider, if any
+func (s licenseStore) scoped() *gorm.DB {
+	return (*dbStore)(&s).scoped("license_infos")
+}
+
 func (s licenseStore) ListAll() (*[]LicenseInfo, error) {
 	licenses := []LicenseInfo{}
-	// security: limited to 1000 results
-	return &licenses, s.db.Limit(1000).Order("id ASC").Find(&licenses).Error
+	// security: limited results
+	return &licenses, s.scoped().Limit(DefaultLimit).Order("id ASC").Find(&licenses).Error
 }
 
 func (s licenseStore) List(pageSize, pageNum int) (*[]LicenseInfo, error) {
 	licenses := []LicenseInfo{}
 	// pageNum starts at 1
 	// result sorted to assure the same order for each request
-	return &licenses, s.db.Offset((pageNum - 1) * pageSize).Limit(pageSize).Order("id ASC").Find(&licenses).Error
+	return &licenses, s.scoped().Offset((pageNum - 1) * pageSize).Limit(pageSize).Order("id ASC").Find(&licenses).Error
 }
 
-func (s licenseStore) FindByUser(userID string) (*[]LicenseInfo, error) {
+// LicenseQuery gathers the criteria of a license search. Empty criteria are ignored,
+// the others must all be met.
+type LicenseQuery struct {
+	UserID        string
+	PublicationID string
+	Status        string
+	DeviceCount   *Range // inclusive range of registered devices
+	BundleID      string
+	OrderRef      string
+	Limit         int // max number of results; DefaultLimit if 0
+}
+
+// Range is an inclusive range of values.
+type Range struct {
+	Min int
+	Max int
+}
+
+// IsEmpty indicates that a query has no criteria.
+func (q LicenseQuery) IsEmpty() bool {
+	return q.UserID == "" && q.PublicationID == "" && q.Status == "" && q.DeviceCount == nil && q.BundleID == "" && q.OrderRef == ""
+}
+
+// scopes returns a condition per criteria, combined by the query.
+func (q LicenseQuery) scopes() []func(*gorm.DB) *gorm.DB {
+	var scopes []func(*gorm.DB) *gorm.DB
+	where := func(query string, args ...interface{}) {
+		scopes = append(scopes, func(db *gorm.DB) *gorm.DB { return db.Where(query, args...) })
+	}
+	if q.UserID != "" {
+		scopes = append(scopes, func(db *gorm.DB) *gorm.DB { return db.Where("user_id IN ?", userIDs(db, q.UserID)) })
+	}
+	if q.PublicationID != "" {
+		where("publication_id= ?", q.PublicationID)
+	}
+	if q.Status != "" {
+		where("status= ?", q.Status)
+	}
+	if q.BundleID != "" {
+		where("bundle_id= ?", q.BundleID)
+	}
+	if q.OrderRef != "" {
+		where("order_ref= ?", q.OrderRef)
+	}
+	if q.DeviceCount != nil {
+		where("device_count >= ? AND device_count <= ?", q.DeviceCount.Min, q.DeviceCount.Max)
+	}
+	return scopes
+}
+
+// Find returns the licenses meeting every criteria of a query.
+func (s licenseStore) Find(q LicenseQuery) (*[]LicenseInfo, error) {
 	licenses := []LicenseInfo{}
-	return &licenses, s.db.Limit(1000).Where("user_id= ?", userID).Find(&licenses).Error
+	// security: limited results
+	return &licenses, s.scoped().Limit(limitOf(q.Limit)).Scopes(q.scopes()...).Order("id ASC").Find(&licenses).Error
+}
+
+// Stream calls a function with each license meeting every criteria of a query, without limit, in the order of
+// creation whatever the order of the repository: the licenses are read by batches following their id, so that
+// millions of them are never loaded at once. It stops at the first error of the function.
+func (s licenseStore) Stream(q LicenseQuery, fn func(*LicenseInfo) error) error {
+	var last uint
+	for {
+		licenses := []LicenseInfo{}
+		err := s.scoped().Scopes(q.scopes()...).Where("license_infos.id > ?", last).
+			Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}, Reorder: true}).Limit(StreamBatch).Find(&licenses).Error
+		if err != nil {
+			return err
+		}
+		for i := range licenses {
+			if err = fn(&licenses[i]); err != nil {
+				return err
+			}
+		}
+		if len(licenses) < StreamBatch {
+			return nil
+		}
+		last = licenses[len(licenses)-1].ID
+	}
+}
+
+func (s licenseStore) FindByUser(userID string) (*[]LicenseInfo, error) {
+	return s.Find(LicenseQuery{UserID: userID})
 }
 
 func (s licenseStore) FindByPublication(publicationID string) (*[]LicenseInfo, error) {
-	licenses := []LicenseInfo{}
-	return &licenses, s.db.Limit(1000).Where("publication_id= ?", publicationID).Find(&licenses).Error
+	return s.Find(LicenseQuery{PublicationID: publicationID})
 }
 
 func (s licenseStore) FindByStatus(status string) (*[]LicenseInfo, error) {
-	licenses := []LicenseInfo{}
-	return &licenses, s.db.Limit(1000).Where("status= ?", status).Find(&licenses).Error
+	return s.Find(LicenseQuery{Status: status})
 }
 
 func (s licenseStore) FindByDeviceCount(min int, max int) (*[]LicenseInfo, error) {
-	licenses := []LicenseInfo{}
-	return &licenses, s.db.Limit(1000).Where("device_count >= ? AND device_count <= ?", min, max).Find(&licenses).Error
+	return s.Find(LicenseQuery{DeviceCount: &Range{Min: min, Max: max}})
 }
 
 func (s licenseStore) Count() (int64, error) {
 	var count int64
-	return count, s.db.Model(LicenseInfo{}).Count(&count).Error
+	return count, s.scoped().Model(LicenseInfo{}).Count(&count).Error
 }
 
+// CountByPublication returns the number of licenses of a publication.
+func (s licenseStore) CountByPublication(publicationID string) (int64, error) {
+	var count int64
+	return count, s.scoped().Model(LicenseInfo{}).Where("publication_id = ?", publicationID).Count(&count).Error
+}
+
+// Get returns a license, looked up in the archive if it is not found.
 func (s licenseStore) Get(uuid string) (*LicenseInfo, error) {
 	var license LicenseInfo
-	return &license, s.db.Where("uuid = ?", uuid).First(&license).Error
+	err := s.scoped().Where("uuid = ?", uuid).First(&license).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if archived, err := s.getArchived(uuid); err == nil {
+			return archived, nil
+		}
+	}
+	return &license, err
 }
 
 func (s licenseStore) Create(newLicense *LicenseInfo) error {
-	return s.db.Create(newLicense).Error
+	if s.provider != "" {
+		newLicense.Provider = s.provider
+	}
+	return translateError(s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(newLicense).Error; err != nil {
+			return err
+		}
+		return audit(tx, AUDIT_CREATE, nil, newLicense)
+	}))
+}
+
+// CreateAll creates licenses in a single transaction. A license which cannot be created does not abort
+// the others: its error is returned at its index, the errors of created licenses being nil.
+// If the transaction itself fails, no license is created and the error is returned.
+func (s licenseStore) CreateAll(newLicenses []*LicenseInfo) ([]error, error) {
+	errs := make([]error, len(newLicenses))
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for i, l := range newLicenses {
+			if s.provider != "" {
+				l.Provider = s.provider
+			}
+			savepoint := fmt.Sprintf("license%d", i)
+			if err := tx.SavePoint(savepoint).Error; err != nil {
+				return err
+			}
+			err := tx.Create(l).Error
+			if err == nil {
+				err = audit(tx, AUDIT_CREATE, nil, l)
+			}
+			if err != nil {
+				errs[i] = translateError(err)
+				if err = tx.RollbackTo(savepoint).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return errs, err
 }
 
 func (s licenseStore) Update(changedLicense *LicenseInfo) error {
-	return s.db.Omit("Publication").Save(changedLicense).Error
+	if s.provider != "" {
+		changedLicense.Provider = s.provider
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		table := func() *gorm.DB {
+			if changedLicense.Archived {
+				return tx.Table(archiveTable)
+			}
+			return tx
+		}
+		// the previous version, whose changes are audited
+		var before *LicenseInfo
+		var current LicenseInfo
+		err := table().Where("uuid = ?", changedLicense.UUID).First(&current).Error
+		if err == nil {
+			before = &current
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err = table().Omit("Publication").Save(changedLicense).Error; err != nil {
+			return err
+		}
+		if err = audit(tx, "", before, changedLicense); err != nil {
+			return err
+		}
+		// rights or status may have changed
+		return licenseCacheStore{db: tx}.Invalidate(changedLicense.UUID)
+	})
 }
 
 func (s licenseStore) Delete(deletedLicense *LicenseInfo) error {
-	return s.db.Delete(deletedLicense).Error
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		db := tx
+		if deletedLicense.Archived {
+			db = db.Table(archiveTable)
+		}
+		if err := db.Delete(deletedLicense).Error; err != nil {
+			return err
+		}
+		if err := audit(tx, AUDIT_DELETE, deletedLicense, deletedLicense); err != nil {
+			return err
+		}
+		return licenseCacheStore{db: tx}.Invalidate(deletedLicense.UUID)
+	})
+}
+
+// Restore undoes the deletion of a license, and returns the restored license.
+// Restoring a license which is not deleted has no effect.
+func (s licenseStore) Restore(uuid string) (*LicenseInfo, error) {
+	var license LicenseInfo
+	if err := s.scoped().Unscoped().Where("uuid = ?", uuid).First(&license).Error; err != nil {
+		return nil, err
+	}
+	if !license.DeletedAt.Valid {
+		return &license, nil
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&license).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return audit(tx, AUDIT_RESTORE, &license, &license)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(uuid)
 }
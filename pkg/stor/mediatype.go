@@ -0,0 +1,74 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"errors"
+
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+// MediaType data model
+// The media type registry maps the short format names used in queries (e.g. "epub")
+// to the media types of publications (e.g. "application/epub+zip").
+type MediaType struct {
+	gorm.Model
+	Format      string `json:"format" validate:"required,alphanum" gorm:"size:64;uniqueIndex"`
+	ContentType string `json:"content_type" validate:"required"`
+	Label       string `json:"label,omitempty"`
+}
+
+// Validate checks required fields and values
+func (m *MediaType) Validate() error {
+
+	validate := validator.New()
+	return validate.Struct(m)
+}
+
+func (s mediaTypeStore) ListAll() (*[]MediaType, error) {
+	mediaTypes := []MediaType{}
+	// security: limited to 1000 results
+	return &mediaTypes, s.db.Limit(1000).Order("format ASC").Find(&mediaTypes).Error
+}
+
+func (s mediaTypeStore) Get(format string) (*MediaType, error) {
+	var mediaType MediaType
+	return &mediaType, s.db.Where("format = ?", format).First(&mediaType).Error
+}
+
+func (s mediaTypeStore) Create(newMediaType *MediaType) error {
+	return translateError(s.db.Create(newMediaType).Error)
+}
+
+func (s mediaTypeStore) Update(changedMediaType *MediaType) error {
+	return s.db.Save(changedMediaType).Error
+}
+
+func (s mediaTypeStore) Delete(deletedMediaType *MediaType) error {
+	// a hard delete allows the format to be registered again
+	return s.db.Unscoped().Delete(deletedMediaType).Error
+}
+
+// Register adds the media types which are not yet in the registry.
+// Media types already registered, possibly modified via the API, are left unchanged.
+func (s mediaTypeStore) Register(mediaTypes []MediaType) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for i := range mediaTypes {
+			var current MediaType
+			err := tx.Where("format = ?", mediaTypes[i].Format).First(&current).Error
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			if err = tx.Create(&mediaTypes[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,49 @@
+package stor
+
+import (
+	"testing"
+)
+
+func TestMediaType(t *testing.T) {
+	var err error
+
+	mediaTypes := []MediaType{
+		{Format: "zzepub", ContentType: "application/epub+zip"},
+		{Format: "zzcbz", ContentType: "application/vnd.comicbook+zip"},
+	}
+	if err = St.MediaType().Register(mediaTypes); err != nil {
+		t.Fatalf("Failed to register media types: %v", err)
+	}
+
+	// a registered format is left unchanged by a new registration
+	mt, err := St.MediaType().Get("zzcbz")
+	if err != nil {
+		t.Fatalf("Failed to get a media type: %v", err)
+	}
+	mt.ContentType = "application/x-cbz"
+	if err = St.MediaType().Update(mt); err != nil {
+		t.Fatalf("Failed to update a media type: %v", err)
+	}
+	if err = St.MediaType().Register(mediaTypes); err != nil {
+		t.Fatalf("Failed to register media types: %v", err)
+	}
+	if mt, _ = St.MediaType().Get("zzcbz"); mt.ContentType != "application/x-cbz" {
+		t.Errorf("A registered media type must not be overwritten, got %s", mt.ContentType)
+	}
+
+	list, err := St.MediaType().ListAll()
+	if err != nil {
+		t.Fatalf("Failed to list media types: %v", err)
+	}
+	if len(*list) != 2 {
+		t.Errorf("Expected 2 media types, got %d", len(*list))
+	}
+
+	// a deleted format can be registered again
+	if err = St.MediaType().Delete(mt); err != nil {
+		t.Fatalf("Failed to delete a media type: %v", err)
+	}
+	if err = St.MediaType().Create(&MediaType{Format: "zzcbz", ContentType: "application/vnd.comicbook+zip"}); err != nil {
+		t.Errorf("Failed to register a deleted format again: %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrationFiles holds the SQL scripts of every schema migration, by database type:
+// migrations/<dialect>/<version>_<name>.up.sql applies a migration, and the .down.sql script reverts it.
+// A schema change adds a migration for every database type, and never modifies a released one.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationTable records the applied migrations
+const migrationTable = "schema_migrations"
+
+// ErrPendingMigrations is returned when the database schema is older than the server.
+var ErrPendingMigrations = errors.New("pending schema migrations, run: lcpserver migrate up")
+
+// Migration is a versioned schema change.
+type Migration struct {
+	Version int
+	Name    string
+	Applied *time.Time // nil if the migration is pending
+	up      string
+	down    string
+}
+
+// Migrator applies and reverts the schema migrations of a database.
+type Migrator struct {
+	db *gorm.DB
+}
+
+// NewMigrator returns the migrator of the database identified by a data source name.
+func NewMigrator(dsn string) (*Migrator, error) {
+	db, err := openDB(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db}, nil
+}
+
+// Close closes the connection to the database.
+func (m *Migrator) Close() error {
+	sqlDB, err := m.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// Status returns every migration known by the server, in version order, with its application time.
+func (m *Migrator) Status() ([]Migration, error) {
+	migrations, err := loadMigrations(m.db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	if err = m.init(); err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Version   int
+		AppliedAt time.Time
+	}
+	if err = m.db.Raw("SELECT version, applied_at FROM " + migrationTable).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		applied := row.AppliedAt
+		for i := range migrations {
+			if migrations[i].Version == row.Version {
+				migrations[i].Applied = &applied
+			}
+		}
+	}
+	return migrations, nil
+}
+
+// Pending returns the migrations which are not applied yet.
+func (m *Migrator) Pending() ([]Migration, error) {
+	migrations, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, migration := range migrations {
+		if migration.Applied == nil {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies the pending migrations in version order, and returns them.
+// Each migration is applied in a transaction, with its record; note that MySQL commits schema changes immediately.
+func (m *Migrator) Up() ([]Migration, error) {
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+	for i, migration := range pending {
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := execScript(tx, migration.up); err != nil {
+				return err
+			}
+			return tx.Exec("INSERT INTO "+migrationTable+" (version, name, applied_at) VALUES (?, ?, ?)",
+				migration.Version, migration.Name, time.Now().UTC()).Error
+		})
+		if err != nil {
+			return pending[:i], fmt.Errorf("failed to apply the migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		log.Printf("Applied the schema migration %04d_%s", migration.Version, migration.Name)
+	}
+	return pending, nil
+}
+
+// Down reverts the given number of applied migrations, latest first, and returns them.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	migrations, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+	var reverted []Migration
+	for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		migration := migrations[i]
+		if migration.Applied == nil {
+			continue
+		}
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := execScript(tx, migration.down); err != nil {
+				return err
+			}
+			return tx.Exec("DELETE FROM "+migrationTable+" WHERE version = ?", migration.Version).Error
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("failed to revert the migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		log.Printf("Reverted the schema migration %04d_%s", migration.Version, migration.Name)
+		reverted = append(reverted, migration)
+	}
+	return reverted, nil
+}
+
+// init creates the table of applied migrations. A database created before versioned migrations,
+// by the automatic migration of previous releases, holds the initial schema: its first migration
+// is recorded as applied.
+func (m *Migrator) init() error {
+	if m.db.Migrator().HasTable(migrationTable) {
+		return nil
+	}
+	err := m.db.Exec("CREATE TABLE " + migrationTable + " (version integer NOT NULL, name varchar(255) NOT NULL, applied_at timestamp NULL, PRIMARY KEY (version))").Error
+	if err != nil {
+		return err
+	}
+	if m.db.Migrator().HasTable(&Publication{}) {
+		log.Printf("Existing database: the initial schema migration is recorded as applied")
+		return m.db.Exec("INSERT INTO "+migrationTable+" (version, name, applied_at) VALUES (?, ?, ?)", 1, "initial", time.Now().UTC()).Error
+	}
+	return nil
+}
+
+// loadMigrations returns the migrations of a database type, in version order
+func loadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no schema migrations for the database type %s", dialect)
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		base, direction := strings.TrimSuffix(name, ".sql"), ""
+		if strings.HasSuffix(base, ".up") {
+			base, direction = strings.TrimSuffix(base, ".up"), "up"
+		} else if strings.HasSuffix(base, ".down") {
+			base, direction = strings.TrimSuffix(base, ".down"), "down"
+		}
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if direction == "" || len(parts) != 2 || err != nil {
+			return nil, fmt.Errorf("invalid migration file name %s", name)
+		}
+		script, err := migrationFiles.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = migration
+		}
+		if direction == "up" {
+			migration.up = string(script)
+		} else {
+			migration.down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.up == "" || migration.down == "" {
+			return nil, fmt.Errorf("the migration %04d_%s must have an up and a down script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// execScript executes the statements of a SQL script, each one ending with a semicolon at the end of a line.
+// Comment lines are skipped.
+func execScript(tx *gorm.DB, script string) error {
+	var stmt strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		stmt.WriteString(line)
+		stmt.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			if err := tx.Exec(strings.TrimSuffix(strings.TrimSpace(stmt.String()), ";")).Error; err != nil {
+				return err
+			}
+			stmt.Reset()
+		}
+	}
+	if strings.TrimSpace(stmt.String()) != "" {
+		return errors.New("the last statement of the script must end with a semicolon")
+	}
+	return nil
+}
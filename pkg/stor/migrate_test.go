@@ -0,0 +1,104 @@
+package stor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMigrator(t *testing.T) {
+
+	dsn := "sqlite3://file:" + uuid.New().String() + "?mode=memory&cache=shared"
+	m, err := NewMigrator(dsn)
+	if err != nil {
+		t.Fatalf("Failed to open the database: %v", err)
+	}
+	defer m.Close()
+
+	// a new database has every migration pending, and cannot be opened as is
+	pending, err := m.Pending()
+	if err != nil || len(pending) == 0 || pending[0].Version != 1 {
+		t.Fatalf("Expected the pending initial migration, got %v, %v", pending, err)
+	}
+	if _, err = DBOpen(dsn); !errors.Is(err, ErrPendingMigrations) {
+		t.Errorf("Expected pending migrations, got %v", err)
+	}
+
+	applied, err := m.Up()
+	if err != nil || len(applied) != len(pending) {
+		t.Fatalf("Failed to apply the migrations: %v", err)
+	}
+	st, err := DBOpen(dsn)
+	if err != nil {
+		t.Fatalf("Failed to open a migrated database: %v", err)
+	}
+	if err = st.Check(); err != nil {
+		t.Errorf("The migrated schema doesn't match the entities: %v", err)
+	}
+	if applied, _ = m.Up(); len(applied) != 0 {
+		t.Errorf("Expected no migration to apply, got %d", len(applied))
+	}
+
+	// every migration is reverted
+	reverted, err := m.Down(len(pending))
+	if err != nil || len(reverted) != len(pending) || reverted[len(reverted)-1].Version != 1 {
+		t.Fatalf("Failed to revert the migrations: %v", err)
+	}
+	if m.db.Migrator().HasTable(&Publication{}) {
+		t.Error("Expected the tables to be dropped")
+	}
+	if _, err = m.Up(); err != nil {
+		t.Errorf("Failed to apply the migrations again: %v", err)
+	}
+}
+
+func TestMigratorExistingDatabase(t *testing.T) {
+
+	// a database created by the automatic migration of previous releases
+	dsn := "sqlite3://file:" + uuid.New().String() + "?mode=memory&cache=shared"
+	m, err := NewMigrator(dsn)
+	if err != nil {
+		t.Fatalf("Failed to open the database: %v", err)
+	}
+	defer m.Close()
+	migrations, err := loadMigrations("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = execScript(m.db, migrations[0].up); err != nil {
+		t.Fatal(err)
+	}
+
+	// holds the initial schema
+	migrations, err = m.Status()
+	if err != nil || migrations[0].Applied == nil {
+		t.Fatalf("Expected the initial migration to be applied, got %v", err)
+	}
+	if _, err = m.Up(); err != nil {
+		t.Errorf("Failed to apply the migrations to an existing database: %v", err)
+	}
+}
+
+func TestMigrationFiles(t *testing.T) {
+
+	// every database type has the same migrations
+	sqlite, err := loadMigrations("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dialect := range []string{"postgres", "mysql"} {
+		migrations, err := loadMigrations(dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(migrations) != len(sqlite) {
+			t.Fatalf("Expected %d %s migrations, got %d", len(sqlite), dialect, len(migrations))
+		}
+		for i := range migrations {
+			if migrations[i].Version != sqlite[i].Version || migrations[i].Name != sqlite[i].Name {
+				t.Errorf("Unexpected %s migration %04d_%s", dialect, migrations[i].Version, migrations[i].Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,29 @@
+-- initial schema, as created by the releases preceding versioned migrations
+
+CREATE TABLE `publications` (`id` bigint unsigned AUTO_INCREMENT,`created_at` datetime(3) NULL,`updated_at` datetime(3) NULL,`deleted_at` datetime(3) NULL,`uuid` varchar(36),`title` longtext,`author` longtext,`encryption_key` longblob,`location` longtext,`content_type` longtext,`size` int unsigned,`source_size` int unsigned,`checksum` longtext,`source` varchar(255),`storage_key` longtext,`tier` varchar(16) DEFAULT 'hot',`last_fulfilled` datetime(3) NULL,PRIMARY KEY (`id`),INDEX `idx_publications_deleted_at` (`deleted_at`),UNIQUE INDEX `idx_publications_uuid` (`uuid`),INDEX `idx_publications_source` (`source`),INDEX `idx_publications_tier` (`tier`));
+
+CREATE TABLE `license_infos` (`id` bigint unsigned AUTO_INCREMENT,`created_at` datetime(3) NULL,`updated_at` datetime(3) NULL,`deleted_at` datetime(3) NULL,`updated` datetime(3) NULL,`uuid` varchar(36),`provider` longtext,`user_id` varchar(255),`start` datetime(3) NULL,`end` datetime(3) NULL,`max_end` datetime(3) NULL,`copy` int,`print` int,`status` varchar(16),`status_updated` datetime(3) NULL,`device_count` bigint,`publication_id` varchar(36),`pass_hash` longtext,`key_check` longblob,`text_hint` longtext,PRIMARY KEY (`id`),INDEX `idx_license_infos_deleted_at` (`deleted_at`),UNIQUE INDEX `idx_license_infos_uuid` (`uuid`),INDEX `idx_license_infos_user_id` (`user_id`),INDEX `idx_license_infos_status` (`status`),CONSTRAINT `fk_license_infos_publication` FOREIGN KEY (`publication_id`) REFERENCES `publications`(`uuid`));
+
+CREATE TABLE `events` (`id` bigint unsigned AUTO_INCREMENT,`timestamp` datetime(3) NULL,`type` longtext,`device_name` longtext,`device_id` varchar(255),`license_id` varchar(36),`reason` longtext,`actor` longtext,PRIMARY KEY (`id`),INDEX `idx_events_device_id` (`device_id`),INDEX `idx_events_license_id` (`license_id`),CONSTRAINT `fk_events_license` FOREIGN KEY (`license_id`) REFERENCES `license_infos`(`uuid`));
+
+CREATE TABLE `organizations` (`id` bigint unsigned AUTO_INCREMENT,`created_at` datetime(3) NULL,`updated_at` datetime(3) NULL,`deleted_at` datetime(3) NULL,`uuid` varchar(36),`name` longtext,PRIMARY KEY (`id`),INDEX `idx_organizations_deleted_at` (`deleted_at`),UNIQUE INDEX `idx_organizations_uuid` (`uuid`));
+
+CREATE TABLE `passphrases` (`id` bigint unsigned AUTO_INCREMENT,`created_at` datetime(3) NULL,`updated_at` datetime(3) NULL,`deleted_at` datetime(3) NULL,`organization_id` varchar(36),`label` varchar(255),`text_hint` longtext,`pass_hash` longtext,PRIMARY KEY (`id`),INDEX `idx_passphrases_deleted_at` (`deleted_at`),UNIQUE INDEX `idx_organization_label` (`organization_id`,`label`),CONSTRAINT `fk_passphrases_organization` FOREIGN KEY (`organization_id`) REFERENCES `organizations`(`uuid`));
+
+CREATE TABLE `providers` (`id` bigint unsigned AUTO_INCREMENT,`created_at` datetime(3) NULL,`updated_at` datetime(3) NULL,`deleted_at` datetime(3) NULL,`uuid` varchar(36),`uri` varchar(255),`name` longtext,`terms_link` longtext,`privacy_link` longtext,`support_link` longtext,PRIMARY KEY (`id`),INDEX `idx_providers_deleted_at` (`deleted_at`),UNIQUE INDEX `idx_providers_uuid` (`uuid`),UNIQUE INDEX `idx_providers_uri` (`uri`));
+
+CREATE TABLE `cached_licenses` (`id` bigint unsigned AUTO_INCREMENT,`created_at` datetime(3) NULL,`license_id` varchar(36),`hash` varchar(64),`document` longblob,PRIMARY KEY (`id`),INDEX `idx_cached_licenses_license_id` (`license_id`),UNIQUE INDEX `idx_cached_licenses_hash` (`hash`));
+
+CREATE TABLE `resources` (`id` bigint unsigned AUTO_INCREMENT,`created_at` datetime(3) NULL,`updated_at` datetime(3) NULL,`deleted_at` datetime(3) NULL,`publication_id` varchar(36),`position` bigint,`href` longtext,`content_type` longtext,`size` int unsigned,`checksum` longtext,`duration` double,`storage_key` longtext,PRIMARY KEY (`id`),INDEX `idx_resources_deleted_at` (`deleted_at`),UNIQUE INDEX `idx_publication_position` (`publication_id`,`position`),CONSTRAINT `fk_resources_publication` FOREIGN KEY (`publication_id`) REFERENCES `publications`(`uuid`));
+
+CREATE TABLE `media_types` (`id` bigint unsigned AUTO_INCREMENT,`created_at` datetime(3) NULL,`updated_at` datetime(3) NULL,`deleted_at` datetime(3) NULL,`format` varchar(64),`content_type` longtext,`label` longtext,PRIMARY KEY (`id`),INDEX `idx_media_types_deleted_at` (`deleted_at`),UNIQUE INDEX `idx_media_types_format` (`format`));
+
+CREATE TABLE `publication_usages` (`publication_id` varchar(36),`day` varchar(10),`license_fetches` bigint,`downloads` bigint,PRIMARY KEY (`publication_id`,`day`));
+
+CREATE TABLE `devices` (`id` bigint unsigned AUTO_INCREMENT,`license_id` varchar(36),`device_id` varchar(255),`name` longtext,`registered` datetime(3) NULL,PRIMARY KEY (`id`),UNIQUE INDEX `idx_license_device` (`license_id`,`device_id`),CONSTRAINT `fk_devices_license` FOREIGN KEY (`license_id`) REFERENCES `license_infos`(`uuid`));
+
+CREATE TABLE `propagations` (`id` bigint unsigned AUTO_INCREMENT,`created_at` datetime(3) NULL,`license_id` varchar(36),`channel` varchar(64),`attempts` bigint,`last_error` longtext,`confirmed` datetime(3) NULL,PRIMARY KEY (`id`),UNIQUE INDEX `idx_license_channel` (`license_id`,`channel`),INDEX `idx_propagations_confirmed` (`confirmed`));
+
+CREATE TABLE `actions` (`id` bigint unsigned AUTO_INCREMENT,`created_at` datetime(3) NULL,`license_id` varchar(36),`type` longtext,`at` datetime(3) NULL,`end` datetime(3) NULL,`reason` longtext,`actor` longtext,`executed` datetime(3) NULL,`error` longtext,PRIMARY KEY (`id`),INDEX `idx_actions_license_id` (`license_id`),INDEX `idx_actions_executed` (`executed`));
+
+CREATE TABLE `jobs` (`id` bigint unsigned AUTO_INCREMENT,`created_at` datetime(3) NULL,`updated_at` datetime(3) NULL,`uuid` varchar(36),`type` longtext,`status` varchar(16),`total` bigint,`processed` bigint,`failed` bigint,`error` longtext,`finished` datetime(3) NULL,`results` longtext,PRIMARY KEY (`id`),UNIQUE INDEX `idx_jobs_uuid` (`uuid`),INDEX `idx_jobs_status` (`status`));
@@ -0,0 +1,3 @@
+ALTER TABLE `license_infos` DROP INDEX `idx_license_infos_bundle_id`, DROP COLUMN `bundle_id`;
+DROP TABLE `collection_members`;
+DROP TABLE `collections`;
@@ -0,0 +1,7 @@
+-- relations between publications (series, bundles), and licenses issued for a bundle
+
+CREATE TABLE `collections` (`id` bigint unsigned AUTO_INCREMENT,`created_at` datetime(3) NULL,`updated_at` datetime(3) NULL,`deleted_at` datetime(3) NULL,`uuid` varchar(36),`type` varchar(16),`title` longtext,PRIMARY KEY (`id`),INDEX `idx_collections_deleted_at` (`deleted_at`),UNIQUE INDEX `idx_collections_uuid` (`uuid`));
+
+CREATE TABLE `collection_members` (`id` bigint unsigned AUTO_INCREMENT,`collection_id` varchar(36),`publication_id` varchar(36),`position` bigint,PRIMARY KEY (`id`),UNIQUE INDEX `idx_collection_publication` (`collection_id`,`publication_id`),INDEX `idx_collection_members_publication_id` (`publication_id`),CONSTRAINT `fk_collection_members_collection` FOREIGN KEY (`collection_id`) REFERENCES `collections`(`uuid`),CONSTRAINT `fk_collection_members_publication` FOREIGN KEY (`publication_id`) REFERENCES `publications`(`uuid`));
+
+ALTER TABLE `license_infos` ADD COLUMN `bundle_id` varchar(36), ADD INDEX `idx_license_infos_bundle_id` (`bundle_id`);
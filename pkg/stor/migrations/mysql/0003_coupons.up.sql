@@ -0,0 +1,5 @@
+-- entitlement codes exchanged for licenses, and their redemptions
+
+CREATE TABLE `coupons` (`id` bigint unsigned AUTO_INCREMENT,`created_at` datetime(3) NULL,`updated_at` datetime(3) NULL,`deleted_at` datetime(3) NULL,`code` varchar(64),`publication_id` varchar(36),`template` longtext,`days` bigint,`copy` int,`print` int,`max_uses` bigint,`uses` bigint,`expires` datetime(3) NULL,PRIMARY KEY (`id`),UNIQUE INDEX `idx_coupons_code` (`code`),INDEX `idx_coupons_publication_id` (`publication_id`),INDEX `idx_coupons_deleted_at` (`deleted_at`),CONSTRAINT `fk_coupons_publication` FOREIGN KEY (`publication_id`) REFERENCES `publications`(`uuid`));
+
+CREATE TABLE `redemptions` (`id` bigint unsigned AUTO_INCREMENT,`coupon_code` varchar(64),`license_id` varchar(36),`user_id` varchar(255),`redeemed` datetime(3) NULL,PRIMARY KEY (`id`),INDEX `idx_redemptions_coupon_code` (`coupon_code`));
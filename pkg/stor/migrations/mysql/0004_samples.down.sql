@@ -0,0 +1 @@
+ALTER TABLE `publications` DROP INDEX `idx_publications_sample_of`, DROP COLUMN `sample_of`;
@@ -0,0 +1,3 @@
+-- samples of publications, from which preview licenses are issued
+
+ALTER TABLE `publications` ADD COLUMN `sample_of` varchar(36), ADD INDEX `idx_publications_sample_of` (`sample_of`);
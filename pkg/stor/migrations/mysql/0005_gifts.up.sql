@@ -0,0 +1,3 @@
+-- licenses bought for a recipient, pending until claimed
+
+CREATE TABLE `gifts` (`id` bigint unsigned AUTO_INCREMENT,`created_at` datetime(3) NULL,`updated_at` datetime(3) NULL,`deleted_at` datetime(3) NULL,`uuid` varchar(36),`token_hash` varchar(64),`publication_id` varchar(36),`template` longtext,`days` bigint,`copy` int,`print` int,`purchaser` varchar(255),`status` varchar(16),`expires` datetime(3) NULL,`license_id` varchar(36),`claimed` datetime(3) NULL,PRIMARY KEY (`id`),UNIQUE INDEX `idx_gifts_uuid` (`uuid`),UNIQUE INDEX `idx_gifts_token_hash` (`token_hash`),INDEX `idx_gifts_publication_id` (`publication_id`),INDEX `idx_gifts_purchaser` (`purchaser`),INDEX `idx_gifts_deleted_at` (`deleted_at`),CONSTRAINT `fk_gifts_publication` FOREIGN KEY (`publication_id`) REFERENCES `publications`(`uuid`));
@@ -0,0 +1,2 @@
+ALTER TABLE `license_infos` DROP INDEX `idx_license_infos_provider`, MODIFY `provider` longtext;
+ALTER TABLE `publications` DROP INDEX `idx_publications_provider`, DROP COLUMN `provider`;
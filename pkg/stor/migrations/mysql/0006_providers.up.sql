@@ -0,0 +1,4 @@
+-- publications and licenses partitioned by provider, for the clients restricted to a provider
+
+ALTER TABLE `publications` ADD COLUMN `provider` varchar(255), ADD INDEX `idx_publications_provider` (`provider`);
+ALTER TABLE `license_infos` MODIFY `provider` varchar(255), ADD INDEX `idx_license_infos_provider` (`provider`);
@@ -0,0 +1 @@
+ALTER TABLE `license_infos` DROP INDEX `idx_license_infos_order_ref`, DROP COLUMN `order_ref`;
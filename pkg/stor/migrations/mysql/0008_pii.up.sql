@@ -0,0 +1,4 @@
+-- personal data of the licenses encrypted at rest, by the data key of their provider
+
+CREATE TABLE `data_keys` (`id` bigint unsigned AUTO_INCREMENT,`created_at` datetime(3) NULL,`provider` varchar(255),`wrapped` longblob,PRIMARY KEY (`id`),UNIQUE INDEX `idx_data_keys_provider` (`provider`));
+ALTER TABLE `license_infos` ADD COLUMN `pii` longblob;
@@ -0,0 +1 @@
+ALTER TABLE `events` DROP INDEX `idx_events_previous`, DROP COLUMN `digest`, DROP COLUMN `previous`;
@@ -0,0 +1,3 @@
+-- hash chain of the events, which makes the revocation history tamper-evident
+
+ALTER TABLE `events` ADD COLUMN `previous` varchar(64), ADD COLUMN `digest` varchar(64), ADD UNIQUE INDEX `idx_events_previous` (`previous`);
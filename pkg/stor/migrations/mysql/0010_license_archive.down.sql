@@ -0,0 +1,4 @@
+INSERT INTO `license_infos` SELECT * FROM `archived_licenses`;
+DROP TABLE `archived_licenses`;
+ALTER TABLE `events` ADD CONSTRAINT `fk_events_license` FOREIGN KEY (`license_id`) REFERENCES `license_infos`(`uuid`);
+ALTER TABLE `devices` ADD CONSTRAINT `fk_devices_license` FOREIGN KEY (`license_id`) REFERENCES `license_infos`(`uuid`);
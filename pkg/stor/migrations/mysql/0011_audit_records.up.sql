@@ -0,0 +1,3 @@
+-- audit log of the changes of the licenses: who changed which values, and when
+
+CREATE TABLE `audit_records` (`id` bigint unsigned AUTO_INCREMENT,`timestamp` datetime(3) NULL,`license_id` varchar(36),`actor` varchar(255),`action` varchar(16),`changes` longtext,PRIMARY KEY (`id`),INDEX `idx_audit_records_license_id` (`license_id`));
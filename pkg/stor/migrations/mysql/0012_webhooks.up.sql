@@ -0,0 +1,3 @@
+-- delivery state of the webhooks notified of the changes of the licenses
+
+CREATE TABLE `webhooks` (`id` bigint unsigned AUTO_INCREMENT,`updated_at` datetime(3) NULL,`name` varchar(64),`audit_cursor` bigint unsigned,`event_cursor` bigint unsigned,`attempts` bigint,`next_attempt` datetime(3) NULL,`last_error` longtext,PRIMARY KEY (`id`),UNIQUE INDEX `idx_webhooks_name` (`name`));
@@ -0,0 +1,3 @@
+-- notifications dropped after the last failed attempt of their delivery
+
+CREATE TABLE `dead_letters` (`id` bigint unsigned AUTO_INCREMENT,`created_at` datetime(3) NULL,`target` varchar(64),`notification_id` varchar(32),`type` varchar(32),`license_id` varchar(36),`payload` longtext,`attempts` bigint,`last_error` longtext,PRIMARY KEY (`id`),INDEX `idx_dead_letters_target` (`target`));
@@ -0,0 +1,4 @@
+-- head of the chain of the events, locked by each append; it starts at the latest chained event
+
+CREATE TABLE `chain_heads` (`id` bigint unsigned AUTO_INCREMENT,`digest` varchar(64),PRIMARY KEY (`id`));
+INSERT INTO `chain_heads` (`id`, `digest`) SELECT 1, COALESCE((SELECT `digest` FROM `events` WHERE `digest` <> '' ORDER BY `id` DESC LIMIT 1), '');
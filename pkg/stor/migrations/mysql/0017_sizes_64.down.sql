@@ -0,0 +1,2 @@
+ALTER TABLE `resources` MODIFY `size` int unsigned;
+ALTER TABLE `publications` MODIFY `size` int unsigned, MODIFY `source_size` int unsigned;
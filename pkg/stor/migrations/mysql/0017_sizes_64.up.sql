@@ -0,0 +1,4 @@
+-- sizes of publications and resources on 64 bits, as a publication may exceed 4 GB
+
+ALTER TABLE `publications` MODIFY `size` bigint, MODIFY `source_size` bigint;
+ALTER TABLE `resources` MODIFY `size` bigint;
@@ -0,0 +1,62 @@
+-- initial schema, as created by the releases preceding versioned migrations
+
+CREATE TABLE "publications" ("id" bigserial,"created_at" timestamptz,"updated_at" timestamptz,"deleted_at" timestamptz,"uuid" varchar(36),"title" text,"author" text,"encryption_key" bytea,"location" text,"content_type" text,"size" bigint,"source_size" bigint,"checksum" text,"source" varchar(255),"storage_key" text,"tier" varchar(16) DEFAULT 'hot',"last_fulfilled" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX "idx_publications_tier" ON "publications" ("tier");
+CREATE INDEX "idx_publications_source" ON "publications" ("source");
+CREATE UNIQUE INDEX "idx_publications_uuid" ON "publications" ("uuid");
+CREATE INDEX "idx_publications_deleted_at" ON "publications" ("deleted_at");
+
+CREATE TABLE "license_infos" ("id" bigserial,"created_at" timestamptz,"updated_at" timestamptz,"deleted_at" timestamptz,"updated" timestamptz,"uuid" varchar(36),"provider" text,"user_id" varchar(255),"start" timestamptz,"end" timestamptz,"max_end" timestamptz,"copy" integer,"print" integer,"status" varchar(16),"status_updated" timestamptz,"device_count" bigint,"publication_id" varchar(36),"pass_hash" text,"key_check" bytea,"text_hint" text,PRIMARY KEY ("id"),CONSTRAINT "fk_license_infos_publication" FOREIGN KEY ("publication_id") REFERENCES "publications"("uuid"));
+CREATE INDEX "idx_license_infos_status" ON "license_infos" ("status");
+CREATE INDEX "idx_license_infos_user_id" ON "license_infos" ("user_id");
+CREATE UNIQUE INDEX "idx_license_infos_uuid" ON "license_infos" ("uuid");
+CREATE INDEX "idx_license_infos_deleted_at" ON "license_infos" ("deleted_at");
+
+CREATE TABLE "events" ("id" bigserial,"timestamp" timestamptz,"type" text,"device_name" text,"device_id" varchar(255),"license_id" varchar(36),"reason" text,"actor" text,PRIMARY KEY ("id"),CONSTRAINT "fk_events_license" FOREIGN KEY ("license_id") REFERENCES "license_infos"("uuid"));
+CREATE INDEX "idx_events_license_id" ON "events" ("license_id");
+CREATE INDEX "idx_events_device_id" ON "events" ("device_id");
+
+CREATE TABLE "organizations" ("id" bigserial,"created_at" timestamptz,"updated_at" timestamptz,"deleted_at" timestamptz,"uuid" varchar(36),"name" text,PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX "idx_organizations_uuid" ON "organizations" ("uuid");
+CREATE INDEX "idx_organizations_deleted_at" ON "organizations" ("deleted_at");
+
+CREATE TABLE "passphrases" ("id" bigserial,"created_at" timestamptz,"updated_at" timestamptz,"deleted_at" timestamptz,"organization_id" varchar(36),"label" varchar(255),"text_hint" text,"pass_hash" text,PRIMARY KEY ("id"),CONSTRAINT "fk_passphrases_organization" FOREIGN KEY ("organization_id") REFERENCES "organizations"("uuid"));
+CREATE UNIQUE INDEX "idx_organization_label" ON "passphrases" ("organization_id","label");
+CREATE INDEX "idx_passphrases_deleted_at" ON "passphrases" ("deleted_at");
+
+CREATE TABLE "providers" ("id" bigserial,"created_at" timestamptz,"updated_at" timestamptz,"deleted_at" timestamptz,"uuid" varchar(36),"uri" varchar(255),"name" text,"terms_link" text,"privacy_link" text,"support_link" text,PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX "idx_providers_uri" ON "providers" ("uri");
+CREATE UNIQUE INDEX "idx_providers_uuid" ON "providers" ("uuid");
+CREATE INDEX "idx_providers_deleted_at" ON "providers" ("deleted_at");
+
+CREATE TABLE "cached_licenses" ("id" bigserial,"created_at" timestamptz,"license_id" varchar(36),"hash" varchar(64),"document" bytea,PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX "idx_cached_licenses_hash" ON "cached_licenses" ("hash");
+CREATE INDEX "idx_cached_licenses_license_id" ON "cached_licenses" ("license_id");
+
+CREATE TABLE "resources" ("id" bigserial,"created_at" timestamptz,"updated_at" timestamptz,"deleted_at" timestamptz,"publication_id" varchar(36),"position" bigint,"href" text,"content_type" text,"size" bigint,"checksum" text,"duration" decimal,"storage_key" text,PRIMARY KEY ("id"),CONSTRAINT "fk_resources_publication" FOREIGN KEY ("publication_id") REFERENCES "publications"("uuid"));
+CREATE UNIQUE INDEX "idx_publication_position" ON "resources" ("publication_id","position");
+CREATE INDEX "idx_resources_deleted_at" ON "resources" ("deleted_at");
+
+CREATE TABLE "media_types" ("id" bigserial,"created_at" timestamptz,"updated_at" timestamptz,"deleted_at" timestamptz,"format" varchar(64),"content_type" text,"label" text,PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX "idx_media_types_format" ON "media_types" ("format");
+CREATE INDEX "idx_media_types_deleted_at" ON "media_types" ("deleted_at");
+
+CREATE TABLE "publication_usages" ("publication_id" varchar(36),"day" varchar(10),"license_fetches" bigint,"downloads" bigint,PRIMARY KEY ("publication_id","day"));
+
+CREATE TABLE "devices" ("id" bigserial,"license_id" varchar(36),"device_id" varchar(255),"name" text,"registered" timestamptz,PRIMARY KEY ("id"),CONSTRAINT "fk_devices_license" FOREIGN KEY ("license_id") REFERENCES "license_infos"("uuid"));
+CREATE UNIQUE INDEX "idx_license_device" ON "devices" ("license_id","device_id");
+
+CREATE TABLE "propagations" ("id" bigserial,"created_at" timestamptz,"license_id" varchar(36),"channel" varchar(64),"attempts" bigint,"last_error" text,"confirmed" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX "idx_propagations_confirmed" ON "propagations" ("confirmed");
+CREATE UNIQUE INDEX "idx_license_channel" ON "propagations" ("license_id","channel");
+
+CREATE TABLE "actions" ("id" bigserial,"created_at" timestamptz,"license_id" varchar(36),"type" text,"at" timestamptz,"end" timestamptz,"reason" text,"actor" text,"executed" timestamptz,"error" text,PRIMARY KEY ("id"));
+CREATE INDEX "idx_actions_license_id" ON "actions" ("license_id");
+CREATE INDEX "idx_actions_executed" ON "actions" ("executed");
+
+CREATE TABLE "jobs" ("id" bigserial,"created_at" timestamptz,"updated_at" timestamptz,"uuid" varchar(36),"type" text,"status" varchar(16),"total" bigint,"processed" bigint,"failed" bigint,"error" text,"finished" timestamptz,"results" text,PRIMARY KEY ("id"));
+CREATE INDEX "idx_jobs_status" ON "jobs" ("status");
+CREATE UNIQUE INDEX "idx_jobs_uuid" ON "jobs" ("uuid");
+
+-- full-text search of publications, see searchVector
+CREATE INDEX idx_publications_search ON publications USING GIN (to_tsvector('simple', COALESCE(title, '') || ' ' || COALESCE(author, '')));
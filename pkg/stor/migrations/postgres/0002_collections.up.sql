@@ -0,0 +1,12 @@
+-- relations between publications (series, bundles), and licenses issued for a bundle
+
+CREATE TABLE "collections" ("id" bigserial,"created_at" timestamptz,"updated_at" timestamptz,"deleted_at" timestamptz,"uuid" varchar(36),"type" varchar(16),"title" text,PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX "idx_collections_uuid" ON "collections" ("uuid");
+CREATE INDEX "idx_collections_deleted_at" ON "collections" ("deleted_at");
+
+CREATE TABLE "collection_members" ("id" bigserial,"collection_id" varchar(36),"publication_id" varchar(36),"position" bigint,PRIMARY KEY ("id"),CONSTRAINT "fk_collection_members_collection" FOREIGN KEY ("collection_id") REFERENCES "collections"("uuid"),CONSTRAINT "fk_collection_members_publication" FOREIGN KEY ("publication_id") REFERENCES "publications"("uuid"));
+CREATE INDEX "idx_collection_members_publication_id" ON "collection_members" ("publication_id");
+CREATE UNIQUE INDEX "idx_collection_publication" ON "collection_members" ("collection_id","publication_id");
+
+ALTER TABLE "license_infos" ADD COLUMN "bundle_id" varchar(36);
+CREATE INDEX "idx_license_infos_bundle_id" ON "license_infos" ("bundle_id");
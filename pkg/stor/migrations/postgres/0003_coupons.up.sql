@@ -0,0 +1,9 @@
+-- entitlement codes exchanged for licenses, and their redemptions
+
+CREATE TABLE "coupons" ("id" bigserial,"created_at" timestamptz,"updated_at" timestamptz,"deleted_at" timestamptz,"code" varchar(64),"publication_id" varchar(36),"template" text,"days" bigint,"copy" integer,"print" integer,"max_uses" bigint,"uses" bigint,"expires" timestamptz,PRIMARY KEY ("id"),CONSTRAINT "fk_coupons_publication" FOREIGN KEY ("publication_id") REFERENCES "publications"("uuid"));
+CREATE INDEX "idx_coupons_deleted_at" ON "coupons" ("deleted_at");
+CREATE INDEX "idx_coupons_publication_id" ON "coupons" ("publication_id");
+CREATE UNIQUE INDEX "idx_coupons_code" ON "coupons" ("code");
+
+CREATE TABLE "redemptions" ("id" bigserial,"coupon_code" varchar(64),"license_id" varchar(36),"user_id" varchar(255),"redeemed" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX "idx_redemptions_coupon_code" ON "redemptions" ("coupon_code");
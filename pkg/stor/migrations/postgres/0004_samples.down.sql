@@ -0,0 +1,2 @@
+DROP INDEX "idx_publications_sample_of";
+ALTER TABLE "publications" DROP COLUMN "sample_of";
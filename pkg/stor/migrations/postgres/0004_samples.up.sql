@@ -0,0 +1,4 @@
+-- samples of publications, from which preview licenses are issued
+
+ALTER TABLE "publications" ADD COLUMN "sample_of" varchar(36);
+CREATE INDEX "idx_publications_sample_of" ON "publications" ("sample_of");
@@ -0,0 +1,8 @@
+-- licenses bought for a recipient, pending until claimed
+
+CREATE TABLE "gifts" ("id" bigserial,"created_at" timestamptz,"updated_at" timestamptz,"deleted_at" timestamptz,"uuid" varchar(36),"token_hash" varchar(64),"publication_id" varchar(36),"template" text,"days" bigint,"copy" integer,"print" integer,"purchaser" varchar(255),"status" varchar(16),"expires" timestamptz,"license_id" varchar(36),"claimed" timestamptz,PRIMARY KEY ("id"),CONSTRAINT "fk_gifts_publication" FOREIGN KEY ("publication_id") REFERENCES "publications"("uuid"));
+CREATE UNIQUE INDEX "idx_gifts_uuid" ON "gifts" ("uuid");
+CREATE UNIQUE INDEX "idx_gifts_token_hash" ON "gifts" ("token_hash");
+CREATE INDEX "idx_gifts_publication_id" ON "gifts" ("publication_id");
+CREATE INDEX "idx_gifts_purchaser" ON "gifts" ("purchaser");
+CREATE INDEX "idx_gifts_deleted_at" ON "gifts" ("deleted_at");
@@ -0,0 +1,4 @@
+DROP INDEX "idx_license_infos_provider";
+ALTER TABLE "license_infos" ALTER COLUMN "provider" TYPE text;
+DROP INDEX "idx_publications_provider";
+ALTER TABLE "publications" DROP COLUMN "provider";
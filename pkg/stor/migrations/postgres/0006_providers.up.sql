@@ -0,0 +1,6 @@
+-- publications and licenses partitioned by provider, for the clients restricted to a provider
+
+ALTER TABLE "publications" ADD COLUMN "provider" varchar(255);
+CREATE INDEX "idx_publications_provider" ON "publications" ("provider");
+ALTER TABLE "license_infos" ALTER COLUMN "provider" TYPE varchar(255);
+CREATE INDEX "idx_license_infos_provider" ON "license_infos" ("provider");
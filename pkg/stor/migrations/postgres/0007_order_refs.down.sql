@@ -0,0 +1,2 @@
+DROP INDEX "idx_license_infos_order_ref";
+ALTER TABLE "license_infos" DROP COLUMN "order_ref";
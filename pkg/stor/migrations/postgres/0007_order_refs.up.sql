@@ -0,0 +1,4 @@
+-- references of the storefront orders licenses are sold by, e.g. to void the licenses of a refunded order
+
+ALTER TABLE "license_infos" ADD COLUMN "order_ref" varchar(255);
+CREATE INDEX "idx_license_infos_order_ref" ON "license_infos" ("order_ref");
@@ -0,0 +1,5 @@
+-- personal data of the licenses encrypted at rest, by the data key of their provider
+
+CREATE TABLE "data_keys" ("id" bigserial,"created_at" timestamptz,"provider" varchar(255),"wrapped" bytea,PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX "idx_data_keys_provider" ON "data_keys" ("provider");
+ALTER TABLE "license_infos" ADD COLUMN "pii" bytea;
@@ -0,0 +1,3 @@
+DROP INDEX "idx_events_previous";
+ALTER TABLE "events" DROP COLUMN "digest";
+ALTER TABLE "events" DROP COLUMN "previous";
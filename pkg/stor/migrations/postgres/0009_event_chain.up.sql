@@ -0,0 +1,5 @@
+-- hash chain of the events, which makes the revocation history tamper-evident
+
+ALTER TABLE "events" ADD COLUMN "previous" varchar(64);
+ALTER TABLE "events" ADD COLUMN "digest" varchar(64);
+CREATE UNIQUE INDEX "idx_events_previous" ON "events" ("previous");
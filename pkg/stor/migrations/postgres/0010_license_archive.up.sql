@@ -0,0 +1,6 @@
+-- archive of the licenses terminated long ago, which keeps the table of the licenses small;
+-- the events and devices of the archived licenses are kept, so they no longer reference the table of the licenses
+
+CREATE TABLE "archived_licenses" (LIKE "license_infos" INCLUDING INDEXES);
+ALTER TABLE "events" DROP CONSTRAINT "fk_events_license";
+ALTER TABLE "devices" DROP CONSTRAINT "fk_devices_license";
@@ -0,0 +1,4 @@
+-- delivery state of the webhooks notified of the changes of the licenses
+
+CREATE TABLE "webhooks" ("id" bigserial,"updated_at" timestamptz,"name" varchar(64),"audit_cursor" bigint,"event_cursor" bigint,"attempts" bigint,"next_attempt" timestamptz,"last_error" text,PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX "idx_webhooks_name" ON "webhooks" ("name");
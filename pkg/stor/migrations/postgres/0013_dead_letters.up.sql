@@ -0,0 +1,4 @@
+-- notifications dropped after the last failed attempt of their delivery
+
+CREATE TABLE "dead_letters" ("id" bigserial,"created_at" timestamptz,"target" varchar(64),"notification_id" varchar(32),"type" varchar(32),"license_id" varchar(36),"payload" text,"attempts" bigint,"last_error" text,PRIMARY KEY ("id"));
+CREATE INDEX "idx_dead_letters_target" ON "dead_letters" ("target");
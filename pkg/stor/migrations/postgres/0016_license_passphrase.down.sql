@@ -0,0 +1,2 @@
+ALTER TABLE "archived_licenses" DROP COLUMN "passphrase_id";
+ALTER TABLE "license_infos" DROP COLUMN "passphrase_id";
@@ -0,0 +1,4 @@
+-- passphrase of the pool of an organization a license was issued with
+
+ALTER TABLE "license_infos" ADD COLUMN "passphrase_id" bigint;
+ALTER TABLE "archived_licenses" ADD COLUMN "passphrase_id" bigint;
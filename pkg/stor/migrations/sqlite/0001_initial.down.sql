@@ -0,0 +1,15 @@
+-- drops every table, in the reverse order of their dependencies
+DROP TABLE `jobs`;
+DROP TABLE `actions`;
+DROP TABLE `propagations`;
+DROP TABLE `devices`;
+DROP TABLE `publication_usages`;
+DROP TABLE `media_types`;
+DROP TABLE `resources`;
+DROP TABLE `cached_licenses`;
+DROP TABLE `providers`;
+DROP TABLE `passphrases`;
+DROP TABLE `organizations`;
+DROP TABLE `events`;
+DROP TABLE `license_infos`;
+DROP TABLE `publications`;
@@ -0,0 +1,59 @@
+-- initial schema, as created by the releases preceding versioned migrations
+
+CREATE TABLE `publications` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`uuid` text,`title` text,`author` text,`encryption_key` blob,`location` text,`content_type` text,`size` integer,`source_size` integer,`checksum` text,`source` text,`storage_key` text,`tier` text DEFAULT "hot",`last_fulfilled` datetime,PRIMARY KEY (`id`));
+CREATE INDEX `idx_publications_deleted_at` ON `publications`(`deleted_at`);
+CREATE INDEX `idx_publications_tier` ON `publications`(`tier`);
+CREATE INDEX `idx_publications_source` ON `publications`(`source`);
+CREATE UNIQUE INDEX `idx_publications_uuid` ON `publications`(`uuid`);
+
+CREATE TABLE `license_infos` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`updated` datetime,`uuid` text,`provider` text,`user_id` text,`start` datetime,`end` datetime,`max_end` datetime,`copy` integer,`print` integer,`status` text,`status_updated` datetime,`device_count` integer,`publication_id` text,`pass_hash` text,`key_check` blob,`text_hint` text,PRIMARY KEY (`id`),CONSTRAINT `fk_license_infos_publication` FOREIGN KEY (`publication_id`) REFERENCES `publications`(`uuid`));
+CREATE INDEX `idx_license_infos_user_id` ON `license_infos`(`user_id`);
+CREATE UNIQUE INDEX `idx_license_infos_uuid` ON `license_infos`(`uuid`);
+CREATE INDEX `idx_license_infos_deleted_at` ON `license_infos`(`deleted_at`);
+CREATE INDEX `idx_license_infos_status` ON `license_infos`(`status`);
+
+CREATE TABLE `events` (`id` integer,`timestamp` datetime,`type` text,`device_name` text,`device_id` text,`license_id` text,`reason` text,`actor` text,PRIMARY KEY (`id`),CONSTRAINT `fk_events_license` FOREIGN KEY (`license_id`) REFERENCES `license_infos`(`uuid`));
+CREATE INDEX `idx_events_license_id` ON `events`(`license_id`);
+CREATE INDEX `idx_events_device_id` ON `events`(`device_id`);
+
+CREATE TABLE `organizations` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`uuid` text,`name` text,PRIMARY KEY (`id`));
+CREATE UNIQUE INDEX `idx_organizations_uuid` ON `organizations`(`uuid`);
+CREATE INDEX `idx_organizations_deleted_at` ON `organizations`(`deleted_at`);
+
+CREATE TABLE `passphrases` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`organization_id` text,`label` text,`text_hint` text,`pass_hash` text,PRIMARY KEY (`id`),CONSTRAINT `fk_passphrases_organization` FOREIGN KEY (`organization_id`) REFERENCES `organizations`(`uuid`));
+CREATE UNIQUE INDEX `idx_organization_label` ON `passphrases`(`organization_id`,`label`);
+CREATE INDEX `idx_passphrases_deleted_at` ON `passphrases`(`deleted_at`);
+
+CREATE TABLE `providers` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`uuid` text,`uri` text,`name` text,`terms_link` text,`privacy_link` text,`support_link` text,PRIMARY KEY (`id`));
+CREATE UNIQUE INDEX `idx_providers_uri` ON `providers`(`uri`);
+CREATE UNIQUE INDEX `idx_providers_uuid` ON `providers`(`uuid`);
+CREATE INDEX `idx_providers_deleted_at` ON `providers`(`deleted_at`);
+
+CREATE TABLE `cached_licenses` (`id` integer,`created_at` datetime,`license_id` text,`hash` text,`document` blob,PRIMARY KEY (`id`));
+CREATE INDEX `idx_cached_licenses_license_id` ON `cached_licenses`(`license_id`);
+CREATE UNIQUE INDEX `idx_cached_licenses_hash` ON `cached_licenses`(`hash`);
+
+CREATE TABLE `resources` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`publication_id` text,`position` integer,`href` text,`content_type` text,`size` integer,`checksum` text,`duration` real,`storage_key` text,PRIMARY KEY (`id`),CONSTRAINT `fk_resources_publication` FOREIGN KEY (`publication_id`) REFERENCES `publications`(`uuid`));
+CREATE UNIQUE INDEX `idx_publication_position` ON `resources`(`publication_id`,`position`);
+CREATE INDEX `idx_resources_deleted_at` ON `resources`(`deleted_at`);
+
+CREATE TABLE `media_types` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`format` text,`content_type` text,`label` text,PRIMARY KEY (`id`));
+CREATE UNIQUE INDEX `idx_media_types_format` ON `media_types`(`format`);
+CREATE INDEX `idx_media_types_deleted_at` ON `media_types`(`deleted_at`);
+
+CREATE TABLE `publication_usages` (`publication_id` text,`day` text,`license_fetches` integer,`downloads` integer,PRIMARY KEY (`publication_id`,`day`));
+
+CREATE TABLE `devices` (`id` integer,`license_id` text,`device_id` text,`name` text,`registered` datetime,PRIMARY KEY (`id`),CONSTRAINT `fk_devices_license` FOREIGN KEY (`license_id`) REFERENCES `license_infos`(`uuid`));
+CREATE UNIQUE INDEX `idx_license_device` ON `devices`(`license_id`,`device_id`);
+
+CREATE TABLE `propagations` (`id` integer,`created_at` datetime,`license_id` text,`channel` text,`attempts` integer,`last_error` text,`confirmed` datetime,PRIMARY KEY (`id`));
+CREATE INDEX `idx_propagations_confirmed` ON `propagations`(`confirmed`);
+CREATE UNIQUE INDEX `idx_license_channel` ON `propagations`(`license_id`,`channel`);
+
+CREATE TABLE `actions` (`id` integer,`created_at` datetime,`license_id` text,`type` text,`at` datetime,`end` datetime,`reason` text,`actor` text,`executed` datetime,`error` text,PRIMARY KEY (`id`));
+CREATE INDEX `idx_actions_executed` ON `actions`(`executed`);
+CREATE INDEX `idx_actions_license_id` ON `actions`(`license_id`);
+
+CREATE TABLE `jobs` (`id` integer,`created_at` datetime,`updated_at` datetime,`uuid` text,`type` text,`status` text,`total` integer,`processed` integer,`failed` integer,`error` text,`finished` datetime,`results` text,PRIMARY KEY (`id`));
+CREATE INDEX `idx_jobs_status` ON `jobs`(`status`);
+CREATE UNIQUE INDEX `idx_jobs_uuid` ON `jobs`(`uuid`);
@@ -0,0 +1,4 @@
+DROP INDEX `idx_license_infos_bundle_id`;
+ALTER TABLE `license_infos` DROP COLUMN `bundle_id`;
+DROP TABLE `collection_members`;
+DROP TABLE `collections`;
@@ -0,0 +1,12 @@
+-- relations between publications (series, bundles), and licenses issued for a bundle
+
+CREATE TABLE `collections` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`uuid` text,`type` text,`title` text,PRIMARY KEY (`id`));
+CREATE UNIQUE INDEX `idx_collections_uuid` ON `collections`(`uuid`);
+CREATE INDEX `idx_collections_deleted_at` ON `collections`(`deleted_at`);
+
+CREATE TABLE `collection_members` (`id` integer,`collection_id` text,`publication_id` text,`position` integer,PRIMARY KEY (`id`),CONSTRAINT `fk_collection_members_publication` FOREIGN KEY (`publication_id`) REFERENCES `publications`(`uuid`),CONSTRAINT `fk_collection_members_collection` FOREIGN KEY (`collection_id`) REFERENCES `collections`(`uuid`));
+CREATE UNIQUE INDEX `idx_collection_publication` ON `collection_members`(`collection_id`,`publication_id`);
+CREATE INDEX `idx_collection_members_publication_id` ON `collection_members`(`publication_id`);
+
+ALTER TABLE `license_infos` ADD COLUMN `bundle_id` text;
+CREATE INDEX `idx_license_infos_bundle_id` ON `license_infos`(`bundle_id`);
@@ -0,0 +1,9 @@
+-- entitlement codes exchanged for licenses, and their redemptions
+
+CREATE TABLE `coupons` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`code` text,`publication_id` text,`template` text,`days` integer,`copy` integer,`print` integer,`max_uses` integer,`uses` integer,`expires` datetime,PRIMARY KEY (`id`),CONSTRAINT `fk_coupons_publication` FOREIGN KEY (`publication_id`) REFERENCES `publications`(`uuid`));
+CREATE INDEX `idx_coupons_publication_id` ON `coupons`(`publication_id`);
+CREATE UNIQUE INDEX `idx_coupons_code` ON `coupons`(`code`);
+CREATE INDEX `idx_coupons_deleted_at` ON `coupons`(`deleted_at`);
+
+CREATE TABLE `redemptions` (`id` integer,`coupon_code` text,`license_id` text,`user_id` text,`redeemed` datetime,PRIMARY KEY (`id`));
+CREATE INDEX `idx_redemptions_coupon_code` ON `redemptions`(`coupon_code`);
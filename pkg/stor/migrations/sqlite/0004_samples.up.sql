@@ -0,0 +1,4 @@
+-- samples of publications, from which preview licenses are issued
+
+ALTER TABLE `publications` ADD COLUMN `sample_of` text;
+CREATE INDEX `idx_publications_sample_of` ON `publications`(`sample_of`);
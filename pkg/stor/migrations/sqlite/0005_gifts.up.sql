@@ -0,0 +1,8 @@
+-- licenses bought for a recipient, pending until claimed
+
+CREATE TABLE `gifts` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`uuid` text,`token_hash` text,`publication_id` text,`template` text,`days` integer,`copy` integer,`print` integer,`purchaser` text,`status` text,`expires` datetime,`license_id` text,`claimed` datetime,PRIMARY KEY (`id`),CONSTRAINT `fk_gifts_publication` FOREIGN KEY (`publication_id`) REFERENCES `publications`(`uuid`));
+CREATE UNIQUE INDEX `idx_gifts_uuid` ON `gifts`(`uuid`);
+CREATE UNIQUE INDEX `idx_gifts_token_hash` ON `gifts`(`token_hash`);
+CREATE INDEX `idx_gifts_publication_id` ON `gifts`(`publication_id`);
+CREATE INDEX `idx_gifts_purchaser` ON `gifts`(`purchaser`);
+CREATE INDEX `idx_gifts_deleted_at` ON `gifts`(`deleted_at`);
@@ -0,0 +1,3 @@
+DROP INDEX `idx_license_infos_provider`;
+DROP INDEX `idx_publications_provider`;
+ALTER TABLE `publications` DROP COLUMN `provider`;
@@ -0,0 +1,5 @@
+-- publications and licenses partitioned by provider, for the clients restricted to a provider
+
+ALTER TABLE `publications` ADD COLUMN `provider` text;
+CREATE INDEX `idx_publications_provider` ON `publications`(`provider`);
+CREATE INDEX `idx_license_infos_provider` ON `license_infos`(`provider`);
@@ -0,0 +1,2 @@
+ALTER TABLE `license_infos` DROP COLUMN `pii`;
+DROP TABLE `data_keys`;
@@ -0,0 +1,5 @@
+-- hash chain of the events, which makes the revocation history tamper-evident
+
+ALTER TABLE `events` ADD COLUMN `previous` text;
+ALTER TABLE `events` ADD COLUMN `digest` text;
+CREATE UNIQUE INDEX `idx_events_previous` ON `events`(`previous`);
@@ -0,0 +1,16 @@
+INSERT INTO `license_infos` (`id`,`created_at`,`updated_at`,`deleted_at`,`updated`,`uuid`,`provider`,`user_id`,`start`,`end`,`max_end`,`copy`,`print`,`status`,`status_updated`,`device_count`,`publication_id`,`pass_hash`,`key_check`,`text_hint`,`bundle_id`,`order_ref`,`pii`) SELECT `id`,`created_at`,`updated_at`,`deleted_at`,`updated`,`uuid`,`provider`,`user_id`,`start`,`end`,`max_end`,`copy`,`print`,`status`,`status_updated`,`device_count`,`publication_id`,`pass_hash`,`key_check`,`text_hint`,`bundle_id`,`order_ref`,`pii` FROM `archived_licenses`;
+DROP TABLE `archived_licenses`;
+
+CREATE TABLE `events_bound` (`id` integer,`timestamp` datetime,`type` text,`device_name` text,`device_id` text,`license_id` text,`reason` text,`actor` text,`previous` text,`digest` text,PRIMARY KEY (`id`),CONSTRAINT `fk_events_license` FOREIGN KEY (`license_id`) REFERENCES `license_infos`(`uuid`));
+INSERT INTO `events_bound` SELECT `id`,`timestamp`,`type`,`device_name`,`device_id`,`license_id`,`reason`,`actor`,`previous`,`digest` FROM `events`;
+DROP TABLE `events`;
+ALTER TABLE `events_bound` RENAME TO `events`;
+CREATE INDEX `idx_events_license_id` ON `events`(`license_id`);
+CREATE INDEX `idx_events_device_id` ON `events`(`device_id`);
+CREATE UNIQUE INDEX `idx_events_previous` ON `events`(`previous`);
+
+CREATE TABLE `devices_bound` (`id` integer,`license_id` text,`device_id` text,`name` text,`registered` datetime,PRIMARY KEY (`id`),CONSTRAINT `fk_devices_license` FOREIGN KEY (`license_id`) REFERENCES `license_infos`(`uuid`));
+INSERT INTO `devices_bound` SELECT `id`,`license_id`,`device_id`,`name`,`registered` FROM `devices`;
+DROP TABLE `devices`;
+ALTER TABLE `devices_bound` RENAME TO `devices`;
+CREATE UNIQUE INDEX `idx_license_device` ON `devices`(`license_id`,`device_id`);
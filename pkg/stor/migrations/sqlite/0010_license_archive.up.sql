@@ -0,0 +1,20 @@
+-- archive of the licenses terminated long ago, which keeps the table of the licenses small;
+-- the events and devices of the archived licenses are kept, so they no longer reference the table of the licenses
+
+CREATE TABLE `archived_licenses` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`updated` datetime,`uuid` text,`provider` text,`user_id` text,`start` datetime,`end` datetime,`max_end` datetime,`copy` integer,`print` integer,`status` text,`status_updated` datetime,`device_count` integer,`publication_id` text,`pass_hash` text,`key_check` blob,`text_hint` text,`bundle_id` text,`order_ref` text,`pii` blob,PRIMARY KEY (`id`));
+CREATE UNIQUE INDEX `idx_archived_licenses_uuid` ON `archived_licenses`(`uuid`);
+CREATE INDEX `idx_archived_licenses_deleted_at` ON `archived_licenses`(`deleted_at`);
+
+CREATE TABLE `events_unbound` (`id` integer,`timestamp` datetime,`type` text,`device_name` text,`device_id` text,`license_id` text,`reason` text,`actor` text,`previous` text,`digest` text,PRIMARY KEY (`id`));
+INSERT INTO `events_unbound` SELECT `id`,`timestamp`,`type`,`device_name`,`device_id`,`license_id`,`reason`,`actor`,`previous`,`digest` FROM `events`;
+DROP TABLE `events`;
+ALTER TABLE `events_unbound` RENAME TO `events`;
+CREATE INDEX `idx_events_license_id` ON `events`(`license_id`);
+CREATE INDEX `idx_events_device_id` ON `events`(`device_id`);
+CREATE UNIQUE INDEX `idx_events_previous` ON `events`(`previous`);
+
+CREATE TABLE `devices_unbound` (`id` integer,`license_id` text,`device_id` text,`name` text,`registered` datetime,PRIMARY KEY (`id`));
+INSERT INTO `devices_unbound` SELECT `id`,`license_id`,`device_id`,`name`,`registered` FROM `devices`;
+DROP TABLE `devices`;
+ALTER TABLE `devices_unbound` RENAME TO `devices`;
+CREATE UNIQUE INDEX `idx_license_device` ON `devices`(`license_id`,`device_id`);
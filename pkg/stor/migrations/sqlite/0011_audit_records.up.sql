@@ -0,0 +1,4 @@
+-- audit log of the changes of the licenses: who changed which values, and when
+
+CREATE TABLE `audit_records` (`id` integer,`timestamp` datetime,`license_id` text,`actor` text,`action` text,`changes` text,PRIMARY KEY (`id`));
+CREATE INDEX `idx_audit_records_license_id` ON `audit_records`(`license_id`);
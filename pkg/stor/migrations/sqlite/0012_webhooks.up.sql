@@ -0,0 +1,4 @@
+-- delivery state of the webhooks notified of the changes of the licenses
+
+CREATE TABLE `webhooks` (`id` integer,`updated_at` datetime,`name` text,`audit_cursor` integer,`event_cursor` integer,`attempts` integer,`next_attempt` datetime,`last_error` text,PRIMARY KEY (`id`));
+CREATE UNIQUE INDEX `idx_webhooks_name` ON `webhooks`(`name`);
@@ -0,0 +1,4 @@
+-- notifications dropped after the last failed attempt of their delivery
+
+CREATE TABLE `dead_letters` (`id` integer,`created_at` datetime,`target` text,`notification_id` text,`type` text,`license_id` text,`payload` text,`attempts` integer,`last_error` text,PRIMARY KEY (`id`));
+CREATE INDEX `idx_dead_letters_target` ON `dead_letters`(`target`);
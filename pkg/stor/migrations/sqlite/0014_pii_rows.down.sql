@@ -0,0 +1,3 @@
+ALTER TABLE `jobs` DROP COLUMN `pii`;
+ALTER TABLE `gifts` DROP COLUMN `pii`;
+ALTER TABLE `redemptions` DROP COLUMN `pii`;
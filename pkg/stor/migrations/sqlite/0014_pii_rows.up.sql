@@ -0,0 +1,5 @@
+-- personal data of the coupon redemptions, gift purchasers and job results encrypted at rest
+
+ALTER TABLE `redemptions` ADD COLUMN `pii` blob;
+ALTER TABLE `gifts` ADD COLUMN `pii` blob;
+ALTER TABLE `jobs` ADD COLUMN `pii` blob;
@@ -0,0 +1 @@
+-- the columns are left as is
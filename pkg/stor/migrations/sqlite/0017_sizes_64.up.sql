@@ -0,0 +1,2 @@
+-- sizes of publications and resources on 64 bits, as a publication may exceed 4 GB;
+-- the columns are already 64-bit integers
@@ -0,0 +1,86 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Order is the sort order of a listing. It is only built by parsing a sort expression
+// against the sortable columns of an entity, so that no arbitrary SQL reaches the database.
+// The zero value keeps the default order, by creation.
+type Order struct {
+	column string
+	desc   bool
+}
+
+// sortable columns, by entity
+var (
+	publicationColumns = []string{"created_at", "updated_at", "title", "content_type", "size"}
+	licenseColumns     = []string{"created_at", "updated_at", "start", "end", "status", "status_updated", "user_id", "device_count"}
+)
+
+// PublicationOrder parses a sort expression of publications, e.g. "title" or "-created_at" (descending).
+func PublicationOrder(sort string) (Order, error) {
+	return parseOrder(sort, publicationColumns)
+}
+
+// LicenseOrder parses a sort expression of licenses, e.g. "end" or "-created_at" (descending).
+func LicenseOrder(sort string) (Order, error) {
+	return parseOrder(sort, licenseColumns)
+}
+
+func parseOrder(sort string, columns []string) (Order, error) {
+	if sort == "" {
+		return Order{}, nil
+	}
+	o := Order{column: strings.TrimPrefix(sort, "-"), desc: strings.HasPrefix(sort, "-")}
+	for _, c := range columns {
+		if c == o.column {
+			return o, nil
+		}
+	}
+	return Order{}, fmt.Errorf("cannot sort by %q, sortable fields are %s", o.column, strings.Join(columns, ", "))
+}
+
+// String returns the sort expression of the order.
+func (o Order) String() string {
+	if o.desc {
+		return "-" + o.column
+	}
+	return o.column
+}
+
+// apply returns a session sorted by the order, the id being kept as a tie-breaker by the queries.
+func (o Order) apply(db *gorm.DB) *gorm.DB {
+	if o.column == "" {
+		return db
+	}
+	return db.Order(clause.OrderByColumn{Column: clause.Column{Name: o.column}, Desc: o.desc}).Session(&gorm.Session{})
+}
+
+// Sorted returns the publication repository whose listings are sorted by an order.
+func (s publicationStore) Sorted(o Order) PublicationRepository {
+	return &publicationStore{db: o.apply(s.db), provider: s.provider}
+}
+
+// Sorted returns the license repository whose listings are sorted by an order.
+func (s licenseStore) Sorted(o Order) LicenseRepository {
+	return &licenseStore{db: o.apply(s.db), provider: s.provider}
+}
+
+// WithDeleted returns the publication repository whose listings and counts include deleted publications.
+func (s publicationStore) WithDeleted() PublicationRepository {
+	return &publicationStore{db: s.db.Unscoped(), provider: s.provider}
+}
+
+// WithDeleted returns the license repository whose listings and counts include deleted licenses.
+func (s licenseStore) WithDeleted() LicenseRepository {
+	return &licenseStore{db: s.db.Unscoped(), provider: s.provider}
+}
@@ -0,0 +1,99 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+// Organization data model
+// An organization (e.g. a school) holds a pool of pre-provisioned passphrases,
+// which are used for generating the licenses of its members.
+type Organization struct {
+	gorm.Model
+	UUID string `json:"uuid" validate:"required,uuid" gorm:"size:36;uniqueIndex"`
+	Name string `json:"name" validate:"required"`
+}
+
+// Passphrase data model
+// Only the hash of a passphrase is stored, never the passphrase itself.
+type Passphrase struct {
+	gorm.Model
+	OrganizationID string       `json:"organization_id" validate:"required,uuid" gorm:"size:36;uniqueIndex:idx_organization_label"` // implicit foreign key to the related organization
+	Label          string       `json:"label" validate:"required,max=255" gorm:"size:255;uniqueIndex:idx_organization_label"`
+	TextHint       string       `json:"text_hint" validate:"required"`
+	PassHash       string       `json:"pass_hash" validate:"required"`         // format depends on the hashing scheme declared by the CMS
+	Organization   Organization `json:"-" gorm:"references:UUID" validate:"-"` // the passphrase belongs to the organization
+}
+
+// Validate checks required fields and values
+func (o *Organization) Validate() error {
+
+	validate := validator.New()
+	return validate.Struct(o)
+}
+
+// Validate checks required fields and values
+func (p *Passphrase) Validate() error {
+
+	validate := validator.New()
+	return validate.Struct(p)
+}
+
+func (s organizationStore) ListAll() (*[]Organization, error) {
+	organizations := []Organization{}
+	// security: limited to 1000 results
+	return &organizations, s.db.Limit(1000).Order("id ASC").Find(&organizations).Error
+}
+
+func (s organizationStore) Get(uuid string) (*Organization, error) {
+	var organization Organization
+	return &organization, s.db.Where("uuid = ?", uuid).First(&organization).Error
+}
+
+func (s organizationStore) Create(newOrganization *Organization) error {
+	return translateError(s.db.Create(newOrganization).Error)
+}
+
+func (s organizationStore) Update(changedOrganization *Organization) error {
+	return s.db.Save(changedOrganization).Error
+}
+
+// Delete deletes an organization and its passphrase pool. As for a passphrase, a hard delete allows the organization
+// to be created again with the same identifier and labels.
+func (s organizationStore) Delete(deletedOrganization *Organization) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("organization_id = ?", deletedOrganization.UUID).Delete(&Passphrase{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(deletedOrganization).Error
+	})
+}
+
+func (s organizationStore) ListPassphrases(organizationID string) (*[]Passphrase, error) {
+	passphrases := []Passphrase{}
+	return &passphrases, s.db.Limit(1000).Where("organization_id = ?", organizationID).Order("id ASC").Find(&passphrases).Error
+}
+
+func (s organizationStore) GetPassphrase(organizationID string, label string) (*Passphrase, error) {
+	var passphrase Passphrase
+	return &passphrase, s.db.Where("organization_id = ? AND label = ?", organizationID, label).First(&passphrase).Error
+}
+
+// GetPassphraseByID returns a passphrase of any pool, e.g. the one a license was issued with.
+func (s organizationStore) GetPassphraseByID(id uint) (*Passphrase, error) {
+	var passphrase Passphrase
+	return &passphrase, s.db.Where("id = ?", id).First(&passphrase).Error
+}
+
+func (s organizationStore) AddPassphrase(newPassphrase *Passphrase) error {
+	return translateError(s.db.Omit("Organization").Create(newPassphrase).Error)
+}
+
+func (s organizationStore) DeletePassphrase(deletedPassphrase *Passphrase) error {
+	// a hard delete allows the label to be reused
+	return s.db.Unscoped().Delete(deletedPassphrase).Error
+}
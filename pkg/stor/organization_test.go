@@ -0,0 +1,66 @@
+package stor
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestOrganization(t *testing.T) {
+	var err error
+
+	org := Organization{UUID: uuid.New().String(), Name: "Springfield Elementary"}
+	err = St.Organization().Create(&org)
+	if err != nil {
+		t.Fatalf("Failed to create an organization: %v", err)
+	}
+
+	// fill the passphrase pool
+	for _, label := range []string{"teachers", "pupils"} {
+		p := Passphrase{
+			OrganizationID: org.UUID,
+			Label:          label,
+			TextHint:       "The name of the school",
+			PassHash:       "FAEB00CA518BEA7CB11A7EF31FB6183B489B1B6EADB792BEC64A03B3F6FF80A8",
+		}
+		if err = p.Validate(); err != nil {
+			t.Fatalf("Failed to validate a passphrase: %v", err)
+		}
+		if err = St.Organization().AddPassphrase(&p); err != nil {
+			t.Fatalf("Failed to add a passphrase: %v", err)
+		}
+	}
+	// labels are unique per organization
+	dup := Passphrase{OrganizationID: org.UUID, Label: "pupils", TextHint: "x", PassHash: "x"}
+	if err = St.Organization().AddPassphrase(&dup); err == nil {
+		t.Error("A duplicate passphrase label should be rejected")
+	}
+
+	passphrases, err := St.Organization().ListPassphrases(org.UUID)
+	if err != nil {
+		t.Fatalf("Failed to list passphrases: %v", err)
+	}
+	if len(*passphrases) != 2 || (*passphrases)[0].Label != "teachers" {
+		t.Fatalf("Expected 2 passphrases, got %d", len(*passphrases))
+	}
+
+	p, err := St.Organization().GetPassphrase(org.UUID, "pupils")
+	if err != nil {
+		t.Fatalf("Failed to get a passphrase: %v", err)
+	}
+	if err = St.Organization().DeletePassphrase(p); err != nil {
+		t.Fatalf("Failed to delete a passphrase: %v", err)
+	}
+	if _, err = St.Organization().GetPassphrase(org.UUID, "pupils"); err == nil {
+		t.Error("The passphrase should have been deleted")
+	}
+
+	// deleting the organization deletes its pool
+	if err = St.Organization().Delete(&org); err != nil {
+		t.Fatalf("Failed to delete an organization: %v", err)
+	}
+	passphrases, _ = St.Organization().ListPassphrases(org.UUID)
+	if len(*passphrases) != 0 {
+		t.Error("The passphrase pool should have been deleted")
+	}
+}
@@ -0,0 +1,410 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DataKey is the data key of a provider, encrypting the personal data of its licenses.
+// It is stored wrapped by the master key of the server, and only unwrapped in memory.
+type DataKey struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Provider  string `gorm:"size:255;uniqueIndex"`
+	Wrapped   []byte
+}
+
+// name of the gorm plugin encrypting the personal data
+const piiPluginName = "lcp:pii"
+
+// piiPlugin encrypts the personal data at rest by data keys wrapped by a master key (envelope encryption):
+// the user identifier and the passphrase hint of the licenses, by the data key of their provider, and the user
+// identifiers of the coupon redemptions, gift purchasers and job results, by the data key of the server.
+// The user identifier columns keep a blind index, an HMAC of the identifier, so that the rows of a user can still
+// be found; the rows stored before the encryption was enabled keep their clear data until they are updated.
+type piiPlugin struct {
+	wrapper  cipher.AEAD // wraps the data keys
+	indexKey []byte      // key of the blind indexes
+
+	mu   sync.Mutex
+	keys map[string]cipher.AEAD // unwrapped data keys, by provider
+}
+
+// serverDataKey is the provider of the data key of the rows which don't belong to a provider
+const serverDataKey = ""
+
+// personalData is a model holding personal data, encrypted by the pii plugin
+type personalData interface {
+	// piiScope returns the provider whose data key encrypts the row, and the identifier the ciphertext is bound to
+	piiScope() (provider, id string)
+	// takePII returns the clear personal data of the row, replacing them by their blind index or an empty value,
+	// or nil if the row has none, e.g. the model of an update by column
+	takePII(index func(string) string) interface{}
+	// putPII gives the row its personal data back, from the JSON encoding of a value returned by takePII
+	putPII(data []byte) error
+	// sealedPII returns the column of the encrypted personal data
+	sealedPII() *[]byte
+}
+
+// licensePII are the personal data of a license
+type licensePII struct {
+	UserID   string `json:"user_id"`
+	TextHint string `json:"text_hint,omitempty"`
+}
+
+func (l *LicenseInfo) piiScope() (string, string) {
+	return l.Provider, l.UUID
+}
+
+func (l *LicenseInfo) takePII(index func(string) string) interface{} {
+	if l.UserID == "" {
+		return nil
+	}
+	clear := licensePII{UserID: l.UserID, TextHint: l.TextHint}
+	l.UserID, l.TextHint = index(l.UserID), ""
+	return clear
+}
+
+func (l *LicenseInfo) putPII(data []byte) error {
+	var clear licensePII
+	if err := json.Unmarshal(data, &clear); err != nil {
+		return err
+	}
+	l.UserID, l.TextHint = clear.UserID, clear.TextHint
+	return nil
+}
+
+func (l *LicenseInfo) sealedPII() *[]byte {
+	return &l.PII
+}
+
+func (r *Redemption) piiScope() (string, string) {
+	return serverDataKey, r.LicenseID
+}
+
+func (r *Redemption) takePII(index func(string) string) interface{} {
+	if r.UserID == "" {
+		return nil
+	}
+	clear := r.UserID
+	r.UserID = index(r.UserID)
+	return clear
+}
+
+func (r *Redemption) putPII(data []byte) error {
+	return json.Unmarshal(data, &r.UserID)
+}
+
+func (r *Redemption) sealedPII() *[]byte {
+	return &r.PII
+}
+
+func (g *Gift) piiScope() (string, string) {
+	return serverDataKey, g.UUID
+}
+
+func (g *Gift) takePII(index func(string) string) interface{} {
+	if g.Purchaser == "" {
+		return nil
+	}
+	clear := g.Purchaser
+	g.Purchaser = index(g.Purchaser)
+	return clear
+}
+
+func (g *Gift) putPII(data []byte) error {
+	return json.Unmarshal(data, &g.Purchaser)
+}
+
+func (g *Gift) sealedPII() *[]byte {
+	return &g.PII
+}
+
+func (j *Job) piiScope() (string, string) {
+	return serverDataKey, j.UUID
+}
+
+// takePII returns the user identifiers of the results, by position; they are not indexed
+func (j *Job) takePII(index func(string) string) interface{} {
+	var clear map[int]string
+	for i := range j.Results {
+		if j.Results[i].UserID != "" {
+			if clear == nil {
+				clear = make(map[int]string)
+			}
+			clear[i], j.Results[i].UserID = j.Results[i].UserID, ""
+		}
+	}
+	if clear == nil {
+		return nil
+	}
+	return clear
+}
+
+func (j *Job) putPII(data []byte) error {
+	var clear map[int]string
+	if err := json.Unmarshal(data, &clear); err != nil {
+		return err
+	}
+	for i, userID := range clear {
+		if i < len(j.Results) {
+			j.Results[i].UserID = userID
+		}
+	}
+	return nil
+}
+
+func (j *Job) sealedPII() *[]byte {
+	return &j.PII
+}
+
+// EnablePIIEncryption encrypts the personal data of the licenses of a store set up by DBSetup, with data keys
+// wrapped by a 32 bytes master key. The master key must never change, as the data keys could not be unwrapped.
+func EnablePIIEncryption(st Store, masterKey []byte) error {
+	if sharded, ok := st.(*shardedStore); ok {
+		for _, shard := range append([]Store{sharded.Store}, Regions(sharded)...) {
+			if rs, ok := shard.(*regionStore); ok {
+				shard = rs.Store
+			}
+			if err := EnablePIIEncryption(shard, masterKey); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	s, ok := st.(*dbStore)
+	if !ok {
+		return errors.New("encryption is only supported by database stores")
+	}
+	if len(masterKey) != 32 {
+		return errors.New("the master key must have 32 bytes")
+	}
+	wrapper, err := newAEAD(deriveKey(masterKey, "lcp-server data keys"))
+	if err != nil {
+		return err
+	}
+	return s.db.Use(&piiPlugin{
+		wrapper:  wrapper,
+		indexKey: deriveKey(masterKey, "lcp-server blind indexes"),
+		keys:     make(map[string]cipher.AEAD),
+	})
+}
+
+func (p *piiPlugin) Name() string {
+	return piiPluginName
+}
+
+// Initialize registers the callbacks encrypting the licenses before they are written, restoring their clear data
+// after the write, and decrypting them after they are read.
+func (p *piiPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().Before("gorm:create").Register("lcp:pii_encrypt", p.encrypt),
+		cb.Create().After("gorm:create").Register("lcp:pii_restore", p.restore),
+		cb.Update().Before("gorm:update").Register("lcp:pii_encrypt", p.encrypt),
+		cb.Update().After("gorm:update").Register("lcp:pii_restore", p.restore),
+		cb.Query().After("gorm:query").Register("lcp:pii_decrypt", p.decrypt),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// index returns the blind index of a user identifier
+func (p *piiPlugin) index(userID string) string {
+	mac := hmac.New(sha256.New, p.indexKey)
+	mac.Write([]byte(userID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// encrypt replaces the personal data of the written rows by their blind index and ciphertext
+func (p *piiPlugin) encrypt(db *gorm.DB) {
+	rows := piiRows(db)
+	clear := make([][]byte, len(rows))
+	db.InstanceSet(piiPluginName, clear)
+	for i, row := range rows {
+		fields := row.takePII(p.index)
+		if fields == nil {
+			continue
+		}
+		data, err := json.Marshal(fields)
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+		clear[i] = data
+		provider, id := row.piiScope()
+		aead, err := p.dataKey(db, provider)
+		if err != nil {
+			db.AddError(fmt.Errorf("failed to get the data key of %s: %w", provider, err))
+			return
+		}
+		*row.sealedPII() = seal(aead, data, []byte(id))
+	}
+}
+
+// restore gives the written rows their clear data back
+func (p *piiPlugin) restore(db *gorm.DB) {
+	v, ok := db.InstanceGet(piiPluginName)
+	if !ok {
+		return
+	}
+	clear := v.([][]byte)
+	for i, row := range piiRows(db) {
+		if i < len(clear) && clear[i] != nil {
+			if err := row.putPII(clear[i]); err != nil {
+				db.AddError(err)
+				return
+			}
+		}
+	}
+}
+
+// decrypt restores the personal data of the read rows
+func (p *piiPlugin) decrypt(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	for _, row := range piiRows(db) {
+		sealed := *row.sealedPII()
+		if len(sealed) == 0 {
+			continue
+		}
+		provider, id := row.piiScope()
+		aead, err := p.dataKey(db, provider)
+		if err != nil {
+			db.AddError(fmt.Errorf("failed to get the data key of %s: %w", provider, err))
+			return
+		}
+		data, err := open(aead, sealed, []byte(id))
+		if err != nil {
+			db.AddError(fmt.Errorf("failed to decrypt the personal data of %s: %w", id, err))
+			return
+		}
+		if err = row.putPII(data); err != nil {
+			db.AddError(err)
+			return
+		}
+	}
+}
+
+// dataKey returns the data key of a provider, created at its first use
+func (p *piiPlugin) dataKey(db *gorm.DB, provider string) (cipher.AEAD, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if aead, ok := p.keys[provider]; ok {
+		return aead, nil
+	}
+
+	// the session of the statement, e.g. its transaction
+	tx := db.Session(&gorm.Session{NewDB: true})
+	var dk DataKey
+	err := tx.Where("provider = ?", provider).First(&dk).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		key := make([]byte, 32)
+		if _, err = rand.Read(key); err != nil {
+			return nil, err
+		}
+		dk = DataKey{Provider: provider, Wrapped: seal(p.wrapper, key, []byte(provider))}
+		if err = tx.Create(&dk).Error; err != nil {
+			// created meanwhile by another instance of the server
+			err = tx.Where("provider = ?", provider).First(&dk).Error
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := open(p.wrapper, dk.Wrapped, []byte(provider))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap the data key, the master key may have changed: %w", err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	p.keys[provider] = aead
+	return aead, nil
+}
+
+// userIDs returns the values of the user identifier column of the rows of a user:
+// the identifier itself, and its blind index if the personal data are encrypted
+func userIDs(db *gorm.DB, userID string) []string {
+	if p, ok := db.Config.Plugins[piiPluginName].(*piiPlugin); ok {
+		return []string{userID, p.index(userID)}
+	}
+	return []string{userID}
+}
+
+// piiRows returns the rows of a statement holding personal data, if any
+func piiRows(db *gorm.DB) []personalData {
+	if db.Statement.Schema == nil {
+		return nil
+	}
+	var rows []personalData
+	add := func(v reflect.Value) {
+		if v.CanAddr() {
+			if row, ok := v.Addr().Interface().(personalData); ok {
+				rows = append(rows, row)
+			}
+		}
+	}
+	rv := reflect.Indirect(db.Statement.ReflectValue)
+	switch rv.Kind() {
+	case reflect.Struct:
+		add(rv)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			add(reflect.Indirect(rv.Index(i)))
+		}
+	}
+	return rows
+}
+
+// deriveKey derives a key of a given purpose from the master key
+func deriveKey(masterKey []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, masterKey)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// newAEAD returns an AES-256-GCM cipher
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts and authenticates data, bound to additional data; the random nonce prefixes the result
+func seal(aead cipher.AEAD, data, additional []byte) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	rand.Read(nonce)
+	return aead.Seal(nonce, nonce, data, additional)
+}
+
+// open decrypts data sealed with the same additional data
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional)
+}
@@ -0,0 +1,140 @@
+package stor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func TestPIIEncryption(t *testing.T) {
+
+	st, err := DBSetup("sqlite3://file:piitest?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("Failed to set up the database: %v", err)
+	}
+	pub := &Publication{UUID: uuid.New().String(), Title: "PII", ContentType: "application/epub+zip"}
+	if err = st.Publication().Create(pub); err != nil {
+		t.Fatalf("Failed to create a publication: %v", err)
+	}
+	newLicense := func(userID string) *LicenseInfo {
+		return &LicenseInfo{UUID: uuid.New().String(), Provider: "https://a.example.com", UserID: userID,
+			PublicationID: pub.UUID, Status: STATUS_READY, TextHint: "The name of your cat"}
+	}
+	legacy := newLicense("user1")
+	if err = st.License().Create(legacy); err != nil {
+		t.Fatalf("Failed to create a license: %v", err)
+	}
+
+	if err = EnablePIIEncryption(st, make([]byte, 16)); err == nil {
+		t.Error("Expected a short master key to be rejected")
+	}
+	masterKey := bytes.Repeat([]byte{7}, 32)
+	if err = EnablePIIEncryption(st, masterKey); err != nil {
+		t.Fatalf("Failed to enable the encryption: %v", err)
+	}
+
+	// the personal data are encrypted in the database, and clear for the caller
+	license := newLicense("user1")
+	if err = st.License().Create(license); err != nil {
+		t.Fatalf("Failed to create a license: %v", err)
+	}
+	if license.UserID != "user1" || license.TextHint != "The name of your cat" {
+		t.Errorf("Expected the clear data to be kept, got %q, %q", license.UserID, license.TextHint)
+	}
+	var stored LicenseInfo
+	raw := st.(*dbStore).db.Session(&gorm.Session{SkipHooks: true})
+	if err = raw.Raw("SELECT * FROM license_infos WHERE uuid = ?", license.UUID).Scan(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.UserID == "user1" || stored.TextHint != "" || len(stored.PII) == 0 || bytes.Contains(stored.PII, []byte("user1")) {
+		t.Errorf("Expected encrypted personal data, got %q, %q", stored.UserID, stored.TextHint)
+	}
+	got, err := st.License().Get(license.UUID)
+	if err != nil || got.UserID != "user1" || got.TextHint != "The name of your cat" {
+		t.Errorf("Expected the decrypted license, got %+v, %v", got, err)
+	}
+
+	// the licenses of a user are found, encrypted or not
+	if list, err := st.License().FindByUser("user1"); err != nil || len(*list) != 2 {
+		t.Errorf("Expected 2 licenses of the user, got %v", err)
+	}
+
+	// an update keeps the data encrypted, and encrypts a license stored in clear
+	legacy.Status = STATUS_ACTIVE
+	now := time.Now()
+	legacy.StatusUpdated = &now
+	if err = st.License().Update(legacy); err != nil || legacy.UserID != "user1" {
+		t.Fatalf("Failed to update a license: %v", err)
+	}
+	if err = raw.Raw("SELECT * FROM license_infos WHERE uuid = ?", legacy.UUID).Scan(&stored).Error; err != nil || stored.UserID == "user1" {
+		t.Errorf("Expected the updated license to be encrypted, got %q, %v", stored.UserID, err)
+	}
+
+	// an archived license keeps its data encrypted
+	license.Status, license.StatusUpdated = STATUS_RETURNED, &now
+	if err = st.License().Update(license); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := st.License().Archive(now.Add(time.Second), 10); err != nil || n != 1 {
+		t.Fatalf("Expected an archived license, got %d, %v", n, err)
+	}
+	if err = raw.Raw("SELECT * FROM archived_licenses WHERE uuid = ?", license.UUID).Scan(&stored).Error; err != nil || stored.UserID == "user1" {
+		t.Errorf("Expected the archived license to be encrypted, got %q, %v", stored.UserID, err)
+	}
+	if got, err = st.License().Get(license.UUID); err != nil || !got.Archived || got.UserID != "user1" || got.TextHint != "The name of your cat" {
+		t.Errorf("Expected the decrypted archived license, got %+v, %v", got, err)
+	}
+
+	// the user identifiers of the redemptions, gifts and job results are encrypted in the database
+	coupon := &Coupon{Code: "PII-" + uuid.New().String()[:8], PublicationID: pub.UUID, MaxUses: 1}
+	if err = st.Coupon().Create(coupon); err != nil {
+		t.Fatal(err)
+	}
+	if err = st.Coupon().Redeem(coupon.Code, newLicense("user2"), now); err != nil {
+		t.Fatalf("Failed to redeem a coupon: %v", err)
+	}
+	var redemption Redemption
+	if err = raw.Raw("SELECT * FROM redemptions WHERE coupon_code = ?", coupon.Code).Scan(&redemption).Error; err != nil ||
+		redemption.UserID == "user2" || len(redemption.PII) == 0 {
+		t.Errorf("Expected an encrypted redemption, got %q, %v", redemption.UserID, err)
+	}
+	if list, err := st.Coupon().ListRedemptions(coupon.Code); err != nil || len(*list) != 1 || (*list)[0].UserID != "user2" {
+		t.Errorf("Expected the decrypted redemption, got %v", err)
+	}
+
+	gift := &Gift{UUID: uuid.New().String(), TokenHash: uuid.New().String(), PublicationID: pub.UUID, Purchaser: "user3", Status: GIFT_PENDING}
+	if err = st.Gift().Create(gift); err != nil || gift.Purchaser != "user3" {
+		t.Fatalf("Failed to create a gift: %v", err)
+	}
+	var storedGift Gift
+	if err = raw.Raw("SELECT * FROM gifts WHERE uuid = ?", gift.UUID).Scan(&storedGift).Error; err != nil ||
+		storedGift.Purchaser == "user3" || len(storedGift.PII) == 0 {
+		t.Errorf("Expected an encrypted gift, got %q, %v", storedGift.Purchaser, err)
+	}
+	if list, err := st.Gift().FindByPurchaser("user3"); err != nil || len(*list) != 1 || (*list)[0].Purchaser != "user3" {
+		t.Errorf("Expected the decrypted gift of the purchaser, got %v", err)
+	}
+
+	job := &Job{UUID: uuid.New().String(), Type: "import", Status: JOB_COMPLETED,
+		Results: []JobResult{{Index: 0, Status: 400}, {Index: 1, Status: 201, UserID: "user4"}}}
+	if err = st.Job().Create(job); err != nil || job.Results[1].UserID != "user4" {
+		t.Fatalf("Failed to create a job: %v", err)
+	}
+	var results string
+	if err = raw.Raw("SELECT results FROM jobs WHERE uuid = ?", job.UUID).Scan(&results).Error; err != nil || strings.Contains(results, "user4") {
+		t.Errorf("Expected the encrypted results of a job, got %s, %v", results, err)
+	}
+	if got, err := st.Job().Get(job.UUID); err != nil || got.Results[1].UserID != "user4" || got.Results[0].UserID != "" {
+		t.Errorf("Expected the decrypted results of the job, got %+v, %v", got, err)
+	}
+
+	// the data key of the provider and the data key of the server are wrapped by the master key
+	var keys []DataKey
+	if err = raw.Order("id").Find(&keys).Error; err != nil || len(keys) != 2 || keys[0].Provider != "https://a.example.com" || keys[1].Provider != "" {
+		t.Errorf("Expected the data keys of the provider and of the server, got %d keys, %v", len(keys), err)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Propagation data model
+// The revocation of a license is propagated to every channel of the configuration, e.g. the callback of the
+// provider or a revocation list; the license is revoking until every channel confirmed the revocation.
+type Propagation struct {
+	ID        uint       `json:"-" gorm:"primaryKey"`
+	CreatedAt time.Time  `json:"created"`
+	LicenseID string     `json:"-" gorm:"size:36;uniqueIndex:idx_license_channel"`
+	Channel   string     `json:"channel" gorm:"size:64;uniqueIndex:idx_license_channel"`
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"last_error,omitempty"`
+	Confirmed *time.Time `json:"confirmed,omitempty" gorm:"index"`
+}
+
+// Start records the channels to which the revocation of a license must be propagated.
+func (s propagationStore) Start(licenseID string, channels []string) error {
+	if len(channels) == 0 {
+		return nil
+	}
+	propagations := make([]Propagation, len(channels))
+	for i, channel := range channels {
+		propagations[i] = Propagation{LicenseID: licenseID, Channel: channel}
+	}
+	return translateError(s.db.Create(&propagations).Error)
+}
+
+// List returns the propagations of the revocation of a license, by channel.
+func (s propagationStore) List(licenseID string) (*[]Propagation, error) {
+	propagations := []Propagation{}
+	return &propagations, s.db.Where("license_id = ?", licenseID).Order("channel ASC").Find(&propagations).Error
+}
+
+// Pending returns the propagations not confirmed yet, the oldest first.
+func (s propagationStore) Pending(limit int) (*[]Propagation, error) {
+	propagations := []Propagation{}
+	return &propagations, s.db.Limit(limit).Where("confirmed IS NULL").Order("id ASC").Find(&propagations).Error
+}
+
+// Confirm records that a channel confirmed a revocation. Once every channel confirmed it, the revoking license
+// is revoked, at the time of the last confirmation, and done is true.
+func (s propagationStore) Confirm(p *Propagation, t time.Time) (done bool, err error) {
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		p.Attempts++
+		p.LastError = ""
+		p.Confirmed = &t
+		if err := tx.Save(p).Error; err != nil {
+			return err
+		}
+		var pending int64
+		if err := tx.Model(&Propagation{}).Where("license_id = ? AND confirmed IS NULL", p.LicenseID).Count(&pending).Error; err != nil {
+			return err
+		}
+		if pending > 0 {
+			return nil
+		}
+		var before LicenseInfo
+		err := tx.Where("uuid = ? AND status = ?", p.LicenseID, STATUS_REVOKING).First(&before).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		result := tx.Model(&LicenseInfo{}).Where("uuid = ? AND status = ?", p.LicenseID, STATUS_REVOKING).
+			Updates(map[string]interface{}{"status": STATUS_REVOKED, "status_updated": t})
+		if result.Error != nil {
+			return result.Error
+		}
+		if done = result.RowsAffected > 0; done {
+			after := before
+			after.Status = STATUS_REVOKED
+			after.StatusUpdated = &t
+			if err = audit(tx, AUDIT_REVOKE, &before, &after); err != nil {
+				return err
+			}
+		}
+		// the status has changed
+		return licenseCacheStore{db: tx}.Invalidate(p.LicenseID)
+	})
+	return done, err
+}
+
+// Fail records a failed attempt of propagation.
+func (s propagationStore) Fail(p *Propagation, cause error) error {
+	p.Attempts++
+	p.LastError = cause.Error()
+	if len(p.LastError) > 255 {
+		p.LastError = p.LastError[:255]
+	}
+	return s.db.Save(p).Error
+}
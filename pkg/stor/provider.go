@@ -0,0 +1,59 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+// Provider data model
+// A provider (e.g. a library or a bookseller) is identified in licenses by its URI.
+// Its policy links (terms of use, privacy policy, support page) are set in its licenses and status documents.
+type Provider struct {
+	gorm.Model
+	UUID        string `json:"uuid" validate:"required,uuid" gorm:"size:36;uniqueIndex"`
+	URI         string `json:"uri" validate:"required,uri,max=255" gorm:"size:255;uniqueIndex"`
+	Name        string `json:"name,omitempty"`
+	TermsLink   string `json:"terms_link,omitempty" validate:"omitempty,url"`
+	PrivacyLink string `json:"privacy_link,omitempty" validate:"omitempty,url"`
+	SupportLink string `json:"support_link,omitempty" validate:"omitempty,url"`
+}
+
+// Validate checks required fields and values
+func (p *Provider) Validate() error {
+
+	validate := validator.New()
+	return validate.Struct(p)
+}
+
+func (s providerStore) ListAll() (*[]Provider, error) {
+	providers := []Provider{}
+	// security: limited to 1000 results
+	return &providers, s.db.Limit(1000).Order("id ASC").Find(&providers).Error
+}
+
+func (s providerStore) Get(uuid string) (*Provider, error) {
+	var provider Provider
+	return &provider, s.db.Where("uuid = ?", uuid).First(&provider).Error
+}
+
+func (s providerStore) GetByURI(uri string) (*Provider, error) {
+	var provider Provider
+	return &provider, s.db.Where("uri = ?", uri).First(&provider).Error
+}
+
+func (s providerStore) Create(newProvider *Provider) error {
+	return translateError(s.db.Create(newProvider).Error)
+}
+
+func (s providerStore) Update(changedProvider *Provider) error {
+	return translateError(s.db.Save(changedProvider).Error)
+}
+
+func (s providerStore) Delete(deletedProvider *Provider) error {
+	// a hard delete allows the provider URI to be declared again
+	return s.db.Unscoped().Delete(deletedProvider).Error
+}
@@ -5,8 +5,13 @@
 package stor
 
 import (
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/go-playground/validator/v10"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // TODO : study how to get "required" field validation  despite the empty Publication in LicenseInfo
@@ -14,13 +19,38 @@ import (
 // Publication data model
 type Publication struct {
 	gorm.Model
-	UUID          string `json:"uuid" validate:"required,uuid" gorm:"uniqueIndex"`
+	UUID          string `json:"uuid" validate:"required,uuid" gorm:"size:36;uniqueIndex"`
 	Title         string `json:"title,omitempty"`
+	Author        string `json:"author,omitempty"`
 	EncryptionKey []byte `json:"encryption_key"`
 	Location      string `json:"location" validate:"required,url"`
 	ContentType   string `json:"content_type"`
-	Size          uint32 `json:"size"`
+	Size          int64  `json:"size"`
+	SourceSize    int64  `json:"source_size,omitempty"` // size before encryption, if encrypted by the server
 	Checksum      string `json:"checksum" validate:"required,base64"`
+	Source        string `json:"source,omitempty" gorm:"size:255;index"` // publisher system the publication is synchronized from, if any
+	// storage of the protected file, if managed by the server
+	StorageKey    string     `json:"storage_key,omitempty"`
+	Tier          string     `json:"tier,omitempty" gorm:"size:16;default:hot;index"`
+	LastFulfilled *time.Time `json:"last_fulfilled,omitempty"`                 // last time a license was served for the publication
+	SampleOf      string     `json:"sample_of,omitempty" gorm:"size:36;index"` // full publication a sample is taken from, for preview licenses
+	Provider      string     `json:"provider,omitempty" gorm:"size:255;index"` // URI of the provider the publication belongs to, if restricted to one
+}
+
+// Storage tiers of managed publications, and the transitions during which their files are moved
+const (
+	TIER_HOT         = "hot"
+	TIER_COLD        = "cold"
+	TIER_ARCHIVING   = "archiving"   // moving from hot to cold
+	TIER_REHYDRATING = "rehydrating" // moving from cold to hot
+)
+
+// StorageUsage is the storage used by the managed publications of a provider in a tier.
+type StorageUsage struct {
+	Provider string `json:"provider"`
+	Tier     string `json:"tier"`
+	Count    int64  `json:"count"`
+	Size     int64  `json:"size"`
 }
 
 // Validate checks required fields and values
@@ -30,42 +60,271 @@ func (p *Publication) Validate() error {
 	return validate.Struct(p)
 }
 
+// scoped returns the session of the store, restricted to the publications of its provider, if any
+func (s publicationStore) scoped() *gorm.DB {
+	return (*dbStore)(&s).scoped("publications")
+}
+
 func (s publicationStore) ListAll() (*[]Publication, error) {
 	publications := []Publication{}
-	// security: limited to 1000 results
-	return &publications, s.db.Limit(1000).Order("id ASC").Find(&publications).Error
+	// security: limited results
+	return &publications, s.scoped().Limit(DefaultLimit).Order("id ASC").Find(&publications).Error
 }
 
 func (s publicationStore) List(pageSize, pageNum int) (*[]Publication, error) {
 	publications := []Publication{}
 	// pageNum starts at 1
 	// result sorted to assure the same order for each request
-	return &publications, s.db.Offset((pageNum - 1) * pageSize).Limit(pageSize).Order("id ASC").Find(&publications).Error
+	return &publications, s.scoped().Offset((pageNum - 1) * pageSize).Limit(pageSize).Order("id ASC").Find(&publications).Error
+}
+
+// PublicationQuery gathers the criteria of a publication search. Empty criteria are ignored,
+// the others must all be met.
+type PublicationQuery struct {
+	ContentType string
+	Text        string // searched in the title, author and uuid, case-insensitively
+	Limit       int    // max number of results; DefaultLimit if 0
+}
+
+// IsEmpty indicates that a query has no criteria.
+func (q PublicationQuery) IsEmpty() bool {
+	return q.ContentType == "" && q.Text == ""
+}
+
+// scopes returns a condition per criteria, combined by the query.
+func (q PublicationQuery) scopes() []func(*gorm.DB) *gorm.DB {
+	var scopes []func(*gorm.DB) *gorm.DB
+	if q.ContentType != "" {
+		scopes = append(scopes, func(db *gorm.DB) *gorm.DB { return db.Where("content_type= ?", q.ContentType) })
+	}
+	if q.Text != "" {
+		scopes = append(scopes, func(db *gorm.DB) *gorm.DB { return textSearch(db, q.Text) })
+	}
+	return scopes
+}
+
+// textSearch matches publications whose title, author or uuid contain a text.
+// Postgres uses the full-text index of the title and author, other databases a LIKE pattern.
+func textSearch(db *gorm.DB, text string) *gorm.DB {
+	pattern := "%" + likeEscaper.Replace(strings.ToLower(text)) + "%"
+	if db.Dialector.Name() == "postgres" {
+		return db.Where("("+searchVector+" @@ plainto_tsquery('simple', ?) OR LOWER(uuid) LIKE ? ESCAPE '!')", text, pattern)
+	}
+	return db.Where("(LOWER(title) LIKE ? ESCAPE '!' OR LOWER(author) LIKE ? ESCAPE '!' OR LOWER(uuid) LIKE ? ESCAPE '!')",
+		pattern, pattern, pattern)
+}
+
+// searchVector is the full-text document of a publication, indexed by Postgres (see the initial migration)
+const searchVector = "to_tsvector('simple', COALESCE(title, '') || ' ' || COALESCE(author, ''))"
+
+// likeEscaper escapes the wildcards of a LIKE pattern
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// Find returns the publications meeting every criteria of a query.
+func (s publicationStore) Find(q PublicationQuery) (*[]Publication, error) {
+	publications := []Publication{}
+	// security: limited results
+	return &publications, s.scoped().Limit(limitOf(q.Limit)).Scopes(q.scopes()...).Order("id ASC").Find(&publications).Error
+}
+
+// Stream calls a function with each publication meeting every criteria of a query, without limit, in the order
+// of creation; see the Stream method of the licenses.
+func (s publicationStore) Stream(q PublicationQuery, fn func(*Publication) error) error {
+	var last uint
+	for {
+		publications := []Publication{}
+		err := s.scoped().Scopes(q.scopes()...).Where("publications.id > ?", last).
+			Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}, Reorder: true}).Limit(StreamBatch).Find(&publications).Error
+		if err != nil {
+			return err
+		}
+		for i := range publications {
+			if err = fn(&publications[i]); err != nil {
+				return err
+			}
+		}
+		if len(publications) < StreamBatch {
+			return nil
+		}
+		last = publications[len(publications)-1].ID
+	}
 }
 
 func (s publicationStore) FindByType(contentType string) (*[]Publication, error) {
+	return s.Find(PublicationQuery{ContentType: contentType})
+}
+
+// FindArchivable returns a batch of managed publications of the hot tier which were not fulfilled since a given
+// time, following the publication of id afterID, by id.
+func (s publicationStore) FindArchivable(before time.Time, afterID uint, limit int) (*[]Publication, error) {
 	publications := []Publication{}
-	return &publications, s.db.Limit(1000).Find(&publications, "content_type= ?", contentType).Error
+	return &publications, s.scoped().Limit(limit).
+		Where("(storage_key <> '' OR EXISTS (SELECT 1 FROM resources WHERE resources.publication_id = publications.uuid AND resources.storage_key <> ''))").
+		Where("tier = ? AND COALESCE(last_fulfilled, created_at) < ? AND id > ?", TIER_HOT, before, afterID).
+		Order("id ASC").Find(&publications).Error
+}
+
+// changeTime is the time of the last change of a publication: its deletion, or else its last update.
+const changeTime = "COALESCE(deleted_at, updated_at)"
+
+// ListChanges returns the publications created, updated or deleted after a change,
+// identified by its time and the id of the publication changed, in the order of the changes.
+// Deleted publications are included, so that their deletion can be propagated.
+func (s publicationStore) ListChanges(since time.Time, afterID uint, limit int) (*[]Publication, error) {
+	publications := []Publication{}
+	return &publications, s.scoped().Unscoped().Limit(limit).
+		Where(changeTime+" > ? OR ("+changeTime+" = ? AND id > ?)", since, since, afterID).
+		Order(changeTime + " ASC, id ASC").Find(&publications).Error
+}
+
+// ChangedAt returns the time of the last change of a publication, which may be its deletion.
+func (p *Publication) ChangedAt() time.Time {
+	if p.DeletedAt.Valid {
+		return p.DeletedAt.Time
+	}
+	return p.UpdatedAt
+}
+
+// StartArchive moves a publication of the hot tier to the archiving transition, unless it was fulfilled since a given
+// time, and tells if it was moved.
+func (s publicationStore) StartArchive(uuid string, before time.Time) (bool, error) {
+	result := s.db.Model(&Publication{}).Where("uuid = ? AND tier = ? AND COALESCE(last_fulfilled, created_at) < ?", uuid, TIER_HOT, before).
+		UpdateColumn("tier", TIER_ARCHIVING)
+	return result.RowsAffected > 0, result.Error
+}
+
+// SwapTier sets the storage tier of a publication if it is in a given tier, and tells if it was set.
+// The transitions are locks on the files of the publication, shared by the instances of the server: the files are
+// only moved by the instance which set the transition. The update time is not modified, as the publication info itself
+// does not change.
+func (s publicationStore) SwapTier(uuid, from, to string) (bool, error) {
+	result := s.db.Model(&Publication{}).Where("uuid = ? AND tier = ?", uuid, from).UpdateColumn("tier", to)
+	return result.RowsAffected > 0, result.Error
+}
+
+// SetFulfilled records the last time a license was served for a publication.
+func (s publicationStore) SetFulfilled(uuid string, t time.Time) error {
+	return s.db.Model(&Publication{}).Where("uuid = ?", uuid).UpdateColumn("last_fulfilled", t).Error
+}
+
+// ListStorageKeys returns the storage keys of every publication and resource managed by the server.
+// Deleted publications are not considered.
+func (s publicationStore) ListStorageKeys() ([]string, error) {
+	keys := []string{}
+	err := s.scoped().Model(&Publication{}).Where("storage_key <> ''").Pluck("storage_key", &keys).Error
+	if err != nil {
+		return nil, err
+	}
+	// files of multi-part publications
+	resourceKeys := []string{}
+	err = s.scoped().Model(&Resource{}).
+		Joins("JOIN publications ON publications.uuid = resources.publication_id AND publications.deleted_at IS NULL").
+		Where("resources.storage_key <> ''").Pluck("resources.storage_key", &resourceKeys).Error
+	return append(keys, resourceKeys...), err
+}
+
+// ListSourceUUIDs returns the uuids of the publications synchronized from a source.
+func (s publicationStore) ListSourceUUIDs(source string) ([]string, error) {
+	uuids := []string{}
+	return uuids, s.scoped().Model(&Publication{}).Where("source = ?", source).Order("id ASC").Pluck("uuid", &uuids).Error
+}
+
+// StorageUsage returns the storage used by managed publications, per provider and tier: their files, and the files
+// of their resources. Multi-part publications are only made of resources.
+func (s publicationStore) StorageUsage() (*[]StorageUsage, error) {
+	files := []StorageUsage{}
+	err := s.scoped().Model(&Publication{}).
+		Select("provider, tier, COUNT(*) AS count, " + sum64(s.db, "size") + " AS size").
+		Where("storage_key <> ''").Group("provider, tier").Scan(&files).Error
+	if err != nil {
+		return nil, err
+	}
+	resources := []StorageUsage{}
+	err = s.scoped().Model(&Resource{}).
+		Select("provider, tier, COUNT(DISTINCT CASE WHEN publications.storage_key = '' THEN publications.id END) AS count, " +
+			sum64(s.db, "resources.size") + " AS size").
+		Joins("JOIN publications ON publications.uuid = resources.publication_id AND publications.deleted_at IS NULL").
+		Where("resources.storage_key <> ''").Group("provider, tier").Scan(&resources).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// the usage of the files and the resources of each provider and tier are added
+	usage := []StorageUsage{}
+	index := make(map[[2]string]int)
+	for _, u := range append(files, resources...) {
+		key := [2]string{u.Provider, u.Tier}
+		if i, ok := index[key]; ok {
+			usage[i].Count += u.Count
+			usage[i].Size += u.Size
+			continue
+		}
+		index[key] = len(usage)
+		usage = append(usage, u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Provider != usage[j].Provider {
+			return usage[i].Provider < usage[j].Provider
+		}
+		return usage[i].Tier < usage[j].Tier
+	})
+	return &usage, nil
+}
+
+// sum64 returns the SQL sum of an integer column, computed on 64 bits
+func sum64(db *gorm.DB, column string) string {
+	if db.Dialector.Name() == "mysql" {
+		return "SUM(CAST(" + column + " AS SIGNED))"
+	}
+	return "SUM(CAST(" + column + " AS BIGINT))"
 }
 
 func (s publicationStore) Count() (int64, error) {
 	var count int64
-	return count, s.db.Model(Publication{}).Count(&count).Error
+	return count, s.scoped().Model(Publication{}).Count(&count).Error
 }
 
 func (s publicationStore) Get(uuid string) (*Publication, error) {
 	var publication Publication
-	return &publication, s.db.Where("uuid = ?", uuid).First(&publication).Error
+	return &publication, s.scoped().Where("uuid = ?", uuid).First(&publication).Error
+}
+
+// GetSample returns the sample of a publication, from which preview licenses are issued.
+func (s publicationStore) GetSample(uuid string) (*Publication, error) {
+	var publication Publication
+	return &publication, s.scoped().Where("sample_of = ?", uuid).First(&publication).Error
 }
 
 func (s publicationStore) Create(newPublication *Publication) error {
-	return s.db.Create(newPublication).Error
+	if s.provider != "" {
+		newPublication.Provider = s.provider
+	}
+	return translateError(s.db.Create(newPublication).Error)
 }
 
 func (s publicationStore) Update(changedPublication *Publication) error {
+	if s.provider != "" {
+		changedPublication.Provider = s.provider
+	}
 	return s.db.Save(changedPublication).Error
 }
 
 func (s publicationStore) Delete(deletedPublication *Publication) error {
 	return s.db.Delete(deletedPublication).Error
 }
+
+// Restore undoes the deletion of a publication, and returns the restored publication.
+// Restoring a publication which is not deleted has no effect.
+func (s publicationStore) Restore(uuid string) (*Publication, error) {
+	var publication Publication
+	if err := s.scoped().Unscoped().Where("uuid = ?", uuid).First(&publication).Error; err != nil {
+		return nil, err
+	}
+	if !publication.DeletedAt.Valid {
+		return &publication, nil
+	}
+	if err := s.db.Unscoped().Model(&publication).Update("deleted_at", nil).Error; err != nil {
+		return nil, err
+	}
+	return s.Get(uuid)
+}
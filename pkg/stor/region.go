@@ -0,0 +1,200 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"context"
+)
+
+type (
+	// shardedStore keeps the publications and licenses of the providers of data residency regions in the
+	// databases of their regions. The other providers, and the entities shared by every provider, are kept
+	// in the main database.
+	shardedStore struct {
+		Store                           // main database
+		regions map[string]*regionStore // store of the region of each provider, by provider URI
+		shards  []*regionStore          // distinct stores of the regions
+		ctx     context.Context         // context of the queries, if any
+	}
+
+	// regionStore is the store of a data residency region: the publications, the licenses and the records of
+	// their usage are kept in the database of the region, the entities shared by every provider, e.g. coupons
+	// or the policy links of the providers, in the main database.
+	regionStore struct {
+		Store       // database of the region
+		main  Store // main database
+	}
+)
+
+// NewShardedStore returns a store routing the publications and licenses of providers to the stores of their
+// data residency regions, given by provider URI; the stores of the regions are set up like the main store.
+// Unless it is restricted to a provider, the returned store is the main store: the publications and licenses
+// of a region are found by ForLicense or ForPublication.
+func NewShardedStore(main Store, regions map[string]Store) Store {
+	s := &shardedStore{Store: main, regions: make(map[string]*regionStore, len(regions))}
+	shards := make(map[Store]*regionStore)
+	for provider, st := range regions {
+		shard, ok := shards[st]
+		if !ok {
+			shard = &regionStore{Store: st, main: main}
+			shards[st] = shard
+			s.shards = append(s.shards, shard)
+		}
+		s.regions[provider] = shard
+	}
+	return s
+}
+
+// Regions returns the stores of the data residency regions of a store, e.g. to run the background tasks of
+// each region, or nil if every publication and license is kept in the main database.
+func Regions(st Store) []Store {
+	s, ok := st.(*shardedStore)
+	if !ok {
+		return nil
+	}
+	stores := make([]Store, len(s.shards))
+	for i, shard := range s.shards {
+		stores[i] = shard.WithContext(s.context())
+	}
+	return stores
+}
+
+// context returns the context of the queries of the store
+func (s *shardedStore) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+func (s *shardedStore) WithContext(ctx context.Context) Store {
+	return &shardedStore{Store: s.Store.WithContext(ctx), regions: s.regions, shards: s.shards, ctx: ctx}
+}
+
+// ForProvider returns the store of the region of a provider, restricted to the provider.
+func (s *shardedStore) ForProvider(provider string) Store {
+	if provider == "" {
+		return s
+	}
+	if shard, ok := s.regions[provider]; ok {
+		return shard.WithContext(s.context()).ForProvider(provider)
+	}
+	return s.Store.ForProvider(provider)
+}
+
+// ForLicense returns the store of the region of a license, restricted to its provider,
+// or the store itself if the license is kept in the main database or does not exist.
+func (s *shardedStore) ForLicense(licenseID string) Store {
+	if _, err := s.Store.License().Get(licenseID); err == nil {
+		return s
+	}
+	for _, shard := range s.shards {
+		st := shard.WithContext(s.context())
+		if license, err := st.License().Get(licenseID); err == nil {
+			return st.ForProvider(license.Provider)
+		}
+	}
+	return s
+}
+
+// ForPublication returns the store of the region of a publication, restricted to its provider,
+// or the store itself if the publication is kept in the main database or does not exist.
+func (s *shardedStore) ForPublication(publicationID string) Store {
+	if _, err := s.Store.Publication().Get(publicationID); err == nil {
+		return s
+	}
+	for _, shard := range s.shards {
+		st := shard.WithContext(s.context())
+		if publication, err := st.Publication().Get(publicationID); err == nil {
+			return st.ForProvider(publication.Provider)
+		}
+	}
+	return s
+}
+
+// Check verifies the main database and the database of each region.
+func (s *shardedStore) Check() error {
+	if err := s.Store.Check(); err != nil {
+		return err
+	}
+	for _, shard := range s.shards {
+		if err := shard.Store.Check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ping verifies that the main database and the database of each region answer a query.
+func (s *shardedStore) Ping() error {
+	if err := s.Store.Ping(); err != nil {
+		return err
+	}
+	for _, shard := range s.shards {
+		if err := shard.Store.WithContext(s.context()).Ping(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Migrated verifies that no schema migration is pending on the main database and the database of each region.
+func (s *shardedStore) Migrated() error {
+	if err := s.Store.Migrated(); err != nil {
+		return err
+	}
+	for _, shard := range s.shards {
+		if err := shard.Store.WithContext(s.context()).Migrated(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *regionStore) WithContext(ctx context.Context) Store {
+	return &regionStore{Store: s.Store.WithContext(ctx), main: s.main.WithContext(ctx)}
+}
+
+func (s *regionStore) ForProvider(provider string) Store {
+	return &regionStore{Store: s.Store.ForProvider(provider), main: s.main}
+}
+
+func (s *regionStore) ForLicense(licenseID string) Store {
+	return s
+}
+
+func (s *regionStore) ForPublication(publicationID string) Store {
+	return s
+}
+
+// entities shared by every provider, kept in the main database
+
+func (s *regionStore) Organization() OrganizationRepository {
+	return s.main.Organization()
+}
+
+func (s *regionStore) Provider() ProviderRepository {
+	return s.main.Provider()
+}
+
+func (s *regionStore) Collection() CollectionRepository {
+	return s.main.Collection()
+}
+
+func (s *regionStore) Coupon() CouponRepository {
+	return s.main.Coupon()
+}
+
+func (s *regionStore) Gift() GiftRepository {
+	return s.main.Gift()
+}
+
+func (s *regionStore) MediaType() MediaTypeRepository {
+	return s.main.MediaType()
+}
+
+func (s *regionStore) Job() JobRepository {
+	return s.main.Job()
+}
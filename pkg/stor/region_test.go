@@ -0,0 +1,68 @@
+package stor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestShardedStore(t *testing.T) {
+
+	main, err := DBSetup("sqlite3://file:shardmain?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("Failed to set up the database: %v", err)
+	}
+	eu, err := DBSetup("sqlite3://file:shardeu?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("Failed to set up the database of the region: %v", err)
+	}
+	const provider = "https://eu.example.com"
+	st := NewShardedStore(main, map[string]Store{provider: eu, "https://eu2.example.com": eu})
+	if regions := Regions(st); len(regions) != 1 {
+		t.Fatalf("Expected a single region, got %d", len(regions))
+	}
+	if err = st.Check(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	// the publications and licenses of the provider are kept in the database of its region
+	pub := &Publication{UUID: uuid.New().String(), Title: "Residency", ContentType: "application/epub+zip"}
+	if err = st.ForProvider(provider).Publication().Create(pub); err != nil {
+		t.Fatalf("Failed to create a publication: %v", err)
+	}
+	license := &LicenseInfo{UUID: uuid.New().String(), UserID: "user1", PublicationID: pub.UUID, Status: STATUS_READY}
+	if err = st.ForProvider(provider).License().Create(license); err != nil {
+		t.Fatalf("Failed to create a license: %v", err)
+	}
+	if _, err = main.License().Get(license.UUID); err == nil {
+		t.Error("Expected the license to be absent from the main database")
+	}
+	if l, err := eu.License().Get(license.UUID); err != nil || l.Provider != provider {
+		t.Errorf("Expected the license of the provider in the database of the region, got %v", err)
+	}
+
+	// the licenses and publications of the region are found by their identifiers
+	ctx := context.Background()
+	if _, err = st.WithContext(ctx).ForLicense(license.UUID).License().Get(license.UUID); err != nil {
+		t.Errorf("Expected the license to be found, got %v", err)
+	}
+	if _, err = st.ForPublication(pub.UUID).Publication().Get(pub.UUID); err != nil {
+		t.Errorf("Expected the publication to be found, got %v", err)
+	}
+	if st.ForLicense("unknown") != st {
+		t.Error("Expected an unknown license to be looked up in the main database")
+	}
+
+	// the entities shared by every provider are kept in the main database
+	p := &Provider{UUID: uuid.New().String(), URI: provider}
+	if err = st.ForProvider(provider).Provider().Create(p); err != nil {
+		t.Fatalf("Failed to create a provider: %v", err)
+	}
+	if _, err = main.Provider().GetByURI(provider); err != nil {
+		t.Errorf("Expected the provider in the main database, got %v", err)
+	}
+	if _, err = eu.Provider().GetByURI(provider); err == nil {
+		t.Error("Expected the provider to be absent from the database of the region")
+	}
+}
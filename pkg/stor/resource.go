@@ -0,0 +1,66 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+// Resource data model
+// A resource is a file of a publication made of multiple files, e.g. a track of an audiobook.
+type Resource struct {
+	gorm.Model
+	PublicationID string      `json:"-" gorm:"size:36;uniqueIndex:idx_publication_position"` // implicit foreign key to the related publication
+	Position      int         `json:"position" gorm:"uniqueIndex:idx_publication_position"`
+	Href          string      `json:"href" validate:"required"` // path of the resource in the publication
+	ContentType   string      `json:"content_type" validate:"required"`
+	Size          int64       `json:"size"`
+	Checksum      string      `json:"checksum" validate:"required,base64"`
+	Duration      float64     `json:"duration,omitempty"`    // in seconds, for audio and video resources
+	StorageKey    string      `json:"storage_key,omitempty"` // set if the file is managed by the server
+	Publication   Publication `json:"-" gorm:"references:UUID" validate:"-"`
+}
+
+// Validate checks required fields and values
+func (r *Resource) Validate() error {
+
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// resources returns the session of the resources, restricted to the publications of the provider of the store, if any
+func (s publicationStore) resources() *gorm.DB {
+	if s.provider == "" {
+		return s.db
+	}
+	return s.db.Where("publication_id IN (?)", s.scoped().Model(&Publication{}).Select("uuid"))
+}
+
+func (s publicationStore) ListResources(publicationID string) (*[]Resource, error) {
+	resources := []Resource{}
+	return &resources, s.resources().Where("publication_id = ?", publicationID).Order("position ASC").Find(&resources).Error
+}
+
+func (s publicationStore) GetResource(publicationID string, position int) (*Resource, error) {
+	var resource Resource
+	return &resource, s.resources().Where("publication_id = ? AND position = ?", publicationID, position).First(&resource).Error
+}
+
+// SetResources replaces the resources of a publication.
+func (s publicationStore) SetResources(publicationID string, resources []Resource) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("publication_id = ?", publicationID).Delete(&Resource{}).Error; err != nil {
+			return err
+		}
+		if len(resources) == 0 {
+			return nil
+		}
+		for i := range resources {
+			resources[i].PublicationID = publicationID
+		}
+		return tx.Omit("Publication").Create(&resources).Error
+	})
+}
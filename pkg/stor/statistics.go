@@ -0,0 +1,57 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"time"
+)
+
+// Statistics are anonymous aggregates of the licenses of a provider over a period:
+// they hold no user, license or device identifier.
+type Statistics struct {
+	Licenses map[string]int64 `json:"licenses"` // licenses created during the period, by current status
+	Events   map[string]int64 `json:"events"`   // status events of the period, by type
+}
+
+// counter is a row of a grouped count
+type counter struct {
+	Name  string
+	Count int64
+}
+
+// ListProviders returns the providers of the licenses, in alphabetical order.
+func (s licenseStore) ListProviders() ([]string, error) {
+	providers := []string{}
+	return providers, s.scoped().Model(&LicenseInfo{}).Distinct("provider").Order("provider ASC").Pluck("provider", &providers).Error
+}
+
+// Statistics returns the statistics of the licenses of a provider, from a time included to a time excluded.
+func (s licenseStore) Statistics(provider string, from, to time.Time) (*Statistics, error) {
+	stats := &Statistics{Licenses: map[string]int64{}, Events: map[string]int64{}}
+
+	var counters []counter
+	err := s.db.Model(&LicenseInfo{}).Select("status AS name, COUNT(*) AS count").
+		Where("provider = ? AND created_at >= ? AND created_at < ?", provider, from, to).
+		Group("status").Scan(&counters).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range counters {
+		stats.Licenses[c.Name] = c.Count
+	}
+
+	counters = nil
+	err = s.db.Model(&Event{}).Select("events.type AS name, COUNT(*) AS count").
+		Joins("JOIN license_infos ON license_infos.uuid = events.license_id").
+		Where("license_infos.provider = ? AND events.timestamp >= ? AND events.timestamp < ?", provider, from, to).
+		Group("events.type").Scan(&counters).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range counters {
+		stats.Events[c.Name] = c.Count
+	}
+	return stats, nil
+}
@@ -6,12 +6,16 @@
 package stor
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"strings"
 	"time"
 
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -21,46 +25,243 @@ type (
 
 	// generic store
 	dbStore struct {
-		db *gorm.DB
+		db       *gorm.DB
+		provider string // provider the publications and licenses are restricted to, if any
 	}
 
 	// entity stores
-	publicationStore dbStore
-	licenseStore     dbStore
-	eventStore       dbStore
+	publicationStore  dbStore
+	licenseStore      dbStore
+	eventStore        dbStore
+	organizationStore dbStore
+	providerStore     dbStore
+	collectionStore   dbStore
+	couponStore       dbStore
+	giftStore         dbStore
+	licenseCacheStore dbStore
+	mediaTypeStore    dbStore
+	usageStore        dbStore
+	deviceStore       dbStore
+	propagationStore  dbStore
+	actionStore       dbStore
+	jobStore          dbStore
+	auditStore        dbStore
+	webhookStore      dbStore
 
 	// Store interface, giving access to specialized interfaces
 	Store interface {
 		Publication() PublicationRepository
 		License() LicenseRepository
 		Event() EventRepository
+		Organization() OrganizationRepository
+		Provider() ProviderRepository
+		Collection() CollectionRepository
+		Coupon() CouponRepository
+		Gift() GiftRepository
+		LicenseCache() LicenseCacheRepository
+		MediaType() MediaTypeRepository
+		Usage() UsageRepository
+		Device() DeviceRepository
+		Propagation() PropagationRepository
+		Action() ActionRepository
+		Job() JobRepository
+		Audit() AuditRepository
+		Webhook() WebhookRepository
+		WithContext(ctx context.Context) Store
+		ForProvider(provider string) Store
+		ForLicense(licenseID string) Store
+		ForPublication(publicationID string) Store
+		Check() error
+		Ping() error
+		Migrated() error
 	}
 
 	// PublicationRepository interface, defining publication operations
 	PublicationRepository interface {
 		ListAll() (*[]Publication, error)
 		List(pageSize, pageNum int) (*[]Publication, error)
+		Sorted(o Order) PublicationRepository
+		WithDeleted() PublicationRepository
+		Find(q PublicationQuery) (*[]Publication, error)
+		Stream(q PublicationQuery, fn func(*Publication) error) error
 		FindByType(contentType string) (*[]Publication, error)
+		FindArchivable(before time.Time, afterID uint, limit int) (*[]Publication, error)
+		StartArchive(uuid string, before time.Time) (bool, error)
+		SwapTier(uuid, from, to string) (bool, error)
+		SetFulfilled(uuid string, t time.Time) error
+		StorageUsage() (*[]StorageUsage, error)
+		ListStorageKeys() ([]string, error)
+		ListSourceUUIDs(source string) ([]string, error)
+		ListChanges(since time.Time, afterID uint, limit int) (*[]Publication, error)
+		ListResources(publicationID string) (*[]Resource, error)
+		GetResource(publicationID string, position int) (*Resource, error)
+		SetResources(publicationID string, resources []Resource) error
 		Count() (int64, error)
 		Get(uuid string) (*Publication, error)
+		GetSample(uuid string) (*Publication, error)
 		Create(p *Publication) error
 		Update(p *Publication) error
 		Delete(p *Publication) error
+		Restore(uuid string) (*Publication, error)
 	}
 
 	// LicenseRepository interface, defining license operations
 	LicenseRepository interface {
 		ListAll() (*[]LicenseInfo, error)
 		List(pageSize, pageNum int) (*[]LicenseInfo, error)
+		Sorted(o Order) LicenseRepository
+		WithDeleted() LicenseRepository
+		Find(q LicenseQuery) (*[]LicenseInfo, error)
+		Stream(q LicenseQuery, fn func(*LicenseInfo) error) error
 		FindByUser(userID string) (*[]LicenseInfo, error)
 		FindByPublication(publicationID string) (*[]LicenseInfo, error)
 		FindByStatus(status string) (*[]LicenseInfo, error)
 		FindByDeviceCount(min int, max int) (*[]LicenseInfo, error)
 		Count() (int64, error)
+		CountByPublication(publicationID string) (int64, error)
+		ListProviders() ([]string, error)
+		Statistics(provider string, from, to time.Time) (*Statistics, error)
 		Get(uuid string) (*LicenseInfo, error)
 		Create(p *LicenseInfo) error
+		CreateAll(licenses []*LicenseInfo) ([]error, error)
 		Update(p *LicenseInfo) error
 		Delete(p *LicenseInfo) error
+		Restore(uuid string) (*LicenseInfo, error)
+		Archive(before time.Time, limit int) (int64, error)
+	}
+
+	// OrganizationRepository interface, defining organization and passphrase pool operations
+	OrganizationRepository interface {
+		ListAll() (*[]Organization, error)
+		Get(uuid string) (*Organization, error)
+		Create(o *Organization) error
+		Update(o *Organization) error
+		Delete(o *Organization) error
+		ListPassphrases(organizationID string) (*[]Passphrase, error)
+		GetPassphrase(organizationID string, label string) (*Passphrase, error)
+		GetPassphraseByID(id uint) (*Passphrase, error)
+		AddPassphrase(p *Passphrase) error
+		DeletePassphrase(p *Passphrase) error
+	}
+
+	// ProviderRepository interface, defining the operations on providers and their policy links
+	ProviderRepository interface {
+		ListAll() (*[]Provider, error)
+		Get(uuid string) (*Provider, error)
+		GetByURI(uri string) (*Provider, error)
+		Create(p *Provider) error
+		Update(p *Provider) error
+		Delete(p *Provider) error
+	}
+
+	// CollectionRepository interface, defining the operations on collections (series, bundles) and their members
+	CollectionRepository interface {
+		ListAll() (*[]Collection, error)
+		FindByPublication(publicationID string) (*[]Collection, error)
+		Get(uuid string) (*Collection, error)
+		Create(c *Collection) error
+		Update(c *Collection) error
+		Delete(c *Collection) error
+		ListMembers(collectionID string) (*[]CollectionMember, error)
+		GetMember(collectionID string, publicationID string) (*CollectionMember, error)
+		SetMember(m *CollectionMember) error
+		DeleteMember(m *CollectionMember) error
+	}
+
+	// CouponRepository interface, defining the operations on coupons and their redemptions
+	CouponRepository interface {
+		ListAll() (*[]Coupon, error)
+		Get(code string) (*Coupon, error)
+		Create(c *Coupon) error
+		CreateAll(coupons []*Coupon) error
+		Update(c *Coupon) error
+		Delete(c *Coupon) error
+		Redeem(code string, license *LicenseInfo, t time.Time) error
+		ListRedemptions(code string) (*[]Redemption, error)
+	}
+
+	// GiftRepository interface, defining the operations on gifts, claimed by their recipient
+	GiftRepository interface {
+		ListAll() (*[]Gift, error)
+		FindByPurchaser(userID string) (*[]Gift, error)
+		Get(uuid string) (*Gift, error)
+		GetByToken(tokenHash string) (*Gift, error)
+		Create(g *Gift) error
+		Cancel(uuid string) error
+		Claim(uuid string, license *LicenseInfo, t time.Time) error
+	}
+
+	// LicenseCacheRepository interface, defining fresh license cache operations
+	LicenseCacheRepository interface {
+		Get(hash string, maxAge time.Duration) (*CachedLicense, error)
+		Set(c *CachedLicense) error
+		Invalidate(licenseID string) error
+		Purge(maxAge time.Duration) (int64, error)
+	}
+
+	// MediaTypeRepository interface, defining media type registry operations
+	MediaTypeRepository interface {
+		ListAll() (*[]MediaType, error)
+		Get(format string) (*MediaType, error)
+		Create(m *MediaType) error
+		Update(m *MediaType) error
+		Delete(m *MediaType) error
+		Register(mediaTypes []MediaType) error
+	}
+
+	// UsageRepository interface, defining publication usage operations
+	UsageRepository interface {
+		Record(publicationID string, t time.Time, licenseFetches, downloads int64) error
+		List(publicationID string, from, to time.Time) (*[]PublicationUsage, error)
+	}
+
+	// DeviceRepository interface, defining the operations on the devices registered with licenses
+	DeviceRepository interface {
+		List(licenseID string) (*[]Device, error)
+		Get(licenseID string, deviceID string) (*Device, error)
+		Register(d *Device) error
+	}
+
+	// PropagationRepository interface, defining the operations on the propagations of revocations
+	PropagationRepository interface {
+		Start(licenseID string, channels []string) error
+		List(licenseID string) (*[]Propagation, error)
+		Pending(limit int) (*[]Propagation, error)
+		Confirm(p *Propagation, t time.Time) (bool, error)
+		Fail(p *Propagation, cause error) error
+	}
+
+	// ActionRepository interface, defining the operations on the status changes scheduled on licenses
+	ActionRepository interface {
+		List(licenseID string) (*[]Action, error)
+		Get(licenseID string, id uint) (*Action, error)
+		Create(a *Action) error
+		Delete(a *Action) error
+		Due(t time.Time, limit int) (*[]Action, error)
+		Done(a *Action, t time.Time, cause error) error
+	}
+
+	// JobRepository interface, defining the operations on bulk jobs
+	JobRepository interface {
+		Get(uuid string) (*Job, error)
+		Create(j *Job) error
+		Update(j *Job) error
+		Interrupt(t time.Time) (int64, error)
+	}
+
+	// AuditRepository interface, defining the operations on the audit log of the licenses
+	AuditRepository interface {
+		List(licenseID string) (*[]AuditRecord, error)
+		Since(id uint, before time.Time, limit int) (*[]AuditRecord, error)
+		Last() (uint, error)
+	}
+
+	// WebhookRepository interface, defining the operations on the delivery states and the dead letters of the webhooks
+	WebhookRepository interface {
+		Get(name string) (*Webhook, error)
+		Update(w *Webhook) error
+		Drop(d *DeadLetter) error
+		DeadLetters(target string, limit int) (*[]DeadLetter, error)
 	}
 
 	// EventRepository interface, defining event operations
@@ -72,10 +273,106 @@ type (
 		Create(e *Event) error
 		Update(e *Event) error
 		Delete(e *Event) error
+		Verify() (*ChainReport, error)
+		Since(id uint, before time.Time, limit int) (*[]Event, error)
 	}
 )
 
 // implementation of the Store interface
+
+// WithContext returns a store whose queries are cancelled with the context,
+// e.g. when the client disconnects or a deadline is exceeded.
+func (s *dbStore) WithContext(ctx context.Context) Store {
+	return &dbStore{db: s.db.WithContext(ctx), provider: s.provider}
+}
+
+// ForProvider returns a store whose publications and licenses are those of a provider, identified by its URI,
+// e.g. for the clients of a publisher hosted with others: the other ones are neither listed nor found, and
+// the publications and licenses created are attached to the provider. An empty provider lifts the restriction.
+func (s *dbStore) ForProvider(provider string) Store {
+	return &dbStore{db: s.db, provider: provider}
+}
+
+// ForLicense returns the store keeping a license, i.e. the store itself, as a database keeps every license.
+// See NewShardedStore for the licenses of data residency regions.
+func (s *dbStore) ForLicense(licenseID string) Store {
+	return s
+}
+
+// ForPublication returns the store keeping a publication, i.e. the store itself.
+func (s *dbStore) ForPublication(publicationID string) Store {
+	return s
+}
+
+// scoped returns the session of the store, restricted to the rows of its provider in a table, if any
+func (s *dbStore) scoped(table string) *gorm.DB {
+	if s.provider == "" {
+		return s.db
+	}
+	return s.db.Where(table+".provider = ?", s.provider)
+}
+
+// Ping verifies that the database answers a query, e.g. for the health checks of a load balancer:
+// unlike Check, it is cheap enough to be called at each request.
+func (s *dbStore) Ping() error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	if err = sqlDB.PingContext(s.db.Statement.Context); err != nil {
+		return fmt.Errorf("failed to reach the database: %w", err)
+	}
+	var one int
+	if err = s.db.Raw("SELECT 1").Scan(&one).Error; err != nil {
+		return fmt.Errorf("failed to query the database: %w", err)
+	}
+	return nil
+}
+
+// Migrated verifies that no schema migration is pending, e.g. when the migrations of an upgrade
+// are applied with the migrate command while the server runs.
+func (s *dbStore) Migrated() error {
+	pending, err := (&Migrator{db: s.db}).Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d %w", len(pending), ErrPendingMigrations)
+	}
+	return nil
+}
+
+// Check verifies the connection to the database, that no schema migration is pending,
+// and that its schema matches the entities: every table and column must exist.
+func (s *dbStore) Check() error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	if err = sqlDB.Ping(); err != nil {
+		return fmt.Errorf("failed to reach the database: %w", err)
+	}
+	if err = s.Migrated(); err != nil {
+		return err
+	}
+	migrator := s.db.Migrator()
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: s.db}
+		if err = stmt.Parse(model); err != nil {
+			return err
+		}
+		if !migrator.HasTable(model) {
+			return fmt.Errorf("missing table %s", stmt.Schema.Table)
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !migrator.HasColumn(model, field.DBName) {
+				return fmt.Errorf("missing column %s.%s", stmt.Schema.Table, field.DBName)
+			}
+		}
+	}
+	return nil
+}
+
 func (s *dbStore) Publication() PublicationRepository {
 	return (*publicationStore)(s)
 }
@@ -88,11 +385,68 @@ func (s *dbStore) Event() EventRepository {
 	return (*eventStore)(s)
 }
 
+func (s *dbStore) Organization() OrganizationRepository {
+	return (*organizationStore)(s)
+}
+
+func (s *dbStore) Provider() ProviderRepository {
+	return (*providerStore)(s)
+}
+
+func (s *dbStore) Collection() CollectionRepository {
+	return (*collectionStore)(s)
+}
+
+func (s *dbStore) Coupon() CouponRepository {
+	return (*couponStore)(s)
+}
+
+func (s *dbStore) Gift() GiftRepository {
+	return (*giftStore)(s)
+}
+
+func (s *dbStore) LicenseCache() LicenseCacheRepository {
+	return (*licenseCacheStore)(s)
+}
+
+func (s *dbStore) MediaType() MediaTypeRepository {
+	return (*mediaTypeStore)(s)
+}
+
+func (s *dbStore) Usage() UsageRepository {
+	return (*usageStore)(s)
+}
+
+func (s *dbStore) Device() DeviceRepository {
+	return (*deviceStore)(s)
+}
+
+func (s *dbStore) Propagation() PropagationRepository {
+	return (*propagationStore)(s)
+}
+
+func (s *dbStore) Action() ActionRepository {
+	return (*actionStore)(s)
+}
+
+func (s *dbStore) Job() JobRepository {
+	return (*jobStore)(s)
+}
+
+func (s *dbStore) Audit() AuditRepository {
+	return (*auditStore)(s)
+}
+
+func (s *dbStore) Webhook() WebhookRepository {
+	return (*webhookStore)(s)
+}
+
 // List of status values as strings
 const (
 	STATUS_READY     = "ready"
 	STATUS_ACTIVE    = "active"
 	STATUS_REVOKED   = "revoked"
+	STATUS_REVOKING  = "revoking" // revoked, until every channel confirmed the revocation
 	STATUS_RETURNED  = "returned"
 	STATUS_CANCELLED = "cancelled"
 	STATUS_EXPIRED   = "expired"
@@ -103,8 +457,87 @@ const (
 	EVENT_CANCEL     = "cancel"
 )
 
-// DBSetup initializes the database
+// Now returns the time of a clock, time.Now if nil, truncated to the second as in status documents, so that the
+// status changes of licenses are recorded as they are shown.
+func Now(clock func() time.Time) time.Time {
+	if clock == nil {
+		clock = time.Now
+	}
+	return clock().Truncate(time.Second)
+}
+
+// DefaultLimit is the max number of results of a listing or a search without limit.
+const DefaultLimit = 1000
+
+// StreamBatch is the number of rows read at once by a stream.
+const StreamBatch = 500
+
+// limitOf returns the max number of results of a query
+func limitOf(limit int) int {
+	if limit <= 0 {
+		return DefaultLimit
+	}
+	return limit
+}
+
+// models are the entities persisted in the database, whose tables are created by the schema migrations
+var models = []interface{}{&Publication{}, &LicenseInfo{}, &Event{}, &Organization{}, &Passphrase{}, &Provider{}, &Collection{}, &CollectionMember{}, &Coupon{}, &Redemption{}, &Gift{}, &CachedLicense{}, &Resource{}, &MediaType{}, &PublicationUsage{}, &Device{}, &Propagation{}, &Action{}, &Job{}, &DataKey{}, &AuditRecord{}, &Webhook{}, &DeadLetter{}, &ChainHead{}}
+
+// DBSetup initializes the database: the pending schema migrations are applied.
 func DBSetup(dsn string) (Store, error) {
+	db, err := openDB(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = (&Migrator{db: db}).Up(); err != nil {
+		log.Printf("Failed migrating the database schema: %v", err)
+		return nil, err
+	}
+	return &dbStore{db: db}, nil
+}
+
+// DBOpen opens a database whose schema is migrated separately, with the migrate command.
+// It fails if a schema migration is pending.
+func DBOpen(dsn string) (Store, error) {
+	db, err := openDB(dsn)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := (&Migrator{db: db}).Pending()
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) > 0 {
+		return nil, fmt.Errorf("%d %w", len(pending), ErrPendingMigrations)
+	}
+	return &dbStore{db: db}, nil
+}
+
+// Close closes the connections to the databases of a store set up by DBSetup or DBOpen, including the databases
+// of its data residency regions. Stores of other types are left open.
+func Close(st Store) error {
+	if sharded, ok := st.(*shardedStore); ok {
+		err := Close(sharded.Store)
+		for _, shard := range sharded.shards {
+			if e := Close(shard.Store); err == nil {
+				err = e
+			}
+		}
+		return err
+	}
+	s, ok := st.(*dbStore)
+	if !ok {
+		return nil
+	}
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// openDB connects to a database, without any change to its schema
+func openDB(dsn string) (*gorm.DB, error) {
 	var err error
 
 	dialect, cnx := dbFromURI(dsn)
@@ -113,13 +546,22 @@ func DBSetup(dsn string) (Store, error) {
 	}
 
 	var dialector gorm.Dialector
-	// the use of time.Time fields for mysql requires parseTime
-	if dialect == "mysql" && !strings.Contains(cnx, "parseTime") {
-		return nil, fmt.Errorf("incomplete mysql database source name, parseTime required: %q", dsn)
-	} else if dialect == "sqlite3" {
+	switch dialect {
+	case "sqlite3", "sqlite":
+		dialect = "sqlite3"
 		dialector = sqlite.Open(cnx)
+	case "postgres", "postgresql":
+		// the driver parses the url itself
+		dialect = "postgres"
+		dialector = postgres.Open(dsn)
+	case "mysql":
+		if cnx, err = mysqlDSN(cnx); err != nil {
+			return nil, err
+		}
+		dialector = mysql.Open(cnx)
+	default:
+		return nil, fmt.Errorf("unsupported database type %q, expected sqlite3, postgres or mysql", dialect)
 	}
-	// Any constraint for other databases?
 
 	// database logger
 	newLogger := logger.New(
@@ -145,12 +587,7 @@ func DBSetup(dsn string) (Store, error) {
 		log.Printf("Failed performing dialect specific database init: %v", err)
 		return nil, err
 	}
-
-	db.AutoMigrate(&Publication{}, &LicenseInfo{}, &Event{})
-
-	stor := &dbStore{db: db}
-
-	return stor, nil
+	return db, nil
 }
 
 // dbFromURI
@@ -162,6 +599,35 @@ func dbFromURI(uri string) (string, string) {
 	return parts[0], parts[1]
 }
 
+// mysqlDSN returns the data source name of the mysql driver, e.g. "user:password@tcp(host:3306)/lcp?parseTime=true",
+// from a driver dsn or from a url, e.g. "user:password@host:3306/lcp". The use of time.Time fields requires parseTime,
+// which is set in urls.
+func mysqlDSN(cnx string) (string, error) {
+	if strings.Contains(cnx, "@tcp(") || strings.Contains(cnx, "@unix(") || strings.HasPrefix(cnx, "tcp(") {
+		if !strings.Contains(cnx, "parseTime") {
+			return "", fmt.Errorf("incomplete mysql database source name, parseTime required: %q", cnx)
+		}
+		return cnx, nil
+	}
+	u, err := url.Parse("mysql://" + cnx)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("incorrect mysql database url: %q", cnx)
+	}
+	query := u.Query()
+	if query.Get("parseTime") == "" {
+		query.Set("parseTime", "true")
+	}
+	var dsn string
+	if u.User != nil {
+		dsn = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			dsn += ":" + password
+		}
+		dsn += "@"
+	}
+	return dsn + "tcp(" + u.Host + ")" + u.Path + "?" + query.Encode(), nil
+}
+
 // performDialectSpecific
 func performDialectSpecific(db *gorm.DB, dialect string) error {
 	switch dialect {
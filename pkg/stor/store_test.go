@@ -1,6 +1,7 @@
 package stor
 
 import (
+	"context"
 	"math/rand"
 	"os"
 	"testing"
@@ -31,7 +32,7 @@ func TestMain(m *testing.M) {
 		} else {
 			pub.ContentType = "application/unknown"
 		}
-		pub.Size = uint32(faker.Number().NumberInt(5))
+		pub.Size = int64(faker.Number().NumberInt(5))
 		pub.Checksum = faker.Lorem().Characters(16)
 		Publications = append(Publications, pub)
 		// save the list of pub IDs
@@ -328,3 +329,58 @@ func TestLicenses(t *testing.T) {
 	}
 
 }
+
+func TestWithContext(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	st := St.WithContext(ctx)
+	if _, err := st.Publication().ListAll(); err != nil {
+		t.Fatalf("Failed to list publications: %v", err)
+	}
+
+	// queries are interrupted once the context is cancelled
+	cancel()
+	if _, err := st.Publication().ListAll(); err == nil {
+		t.Error("A query with a cancelled context should fail")
+	}
+	// the original store is not bound to the context
+	if _, err := St.Publication().ListAll(); err != nil {
+		t.Errorf("Failed to list publications: %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+
+	if err := St.Check(); err != nil {
+		t.Errorf("Failed to check the database: %v", err)
+	}
+}
+
+func TestMySQLDSN(t *testing.T) {
+
+	for cnx, expected := range map[string]string{
+		"lcp:secret@tcp(db:3306)/lcp?parseTime=true":  "lcp:secret@tcp(db:3306)/lcp?parseTime=true",
+		"lcp:secret@db:3306/lcp":                      "lcp:secret@tcp(db:3306)/lcp?parseTime=true",
+		"lcp@db/lcp?charset=utf8mb4":                  "lcp@tcp(db)/lcp?charset=utf8mb4&parseTime=true",
+		"lcp:secret@tcp(db:3306)/lcp?charset=utf8mb4": "",
+	} {
+		dsn, err := mysqlDSN(cnx)
+		if dsn != expected || (expected == "") != (err != nil) {
+			t.Errorf("Unexpected dsn %q for %q, %v", dsn, cnx, err)
+		}
+	}
+
+	if _, err := DBSetup("oracle://lcp@db/lcp"); err == nil {
+		t.Error("Expected an unsupported database type")
+	}
+}
+
+func TestNow(t *testing.T) {
+	clock := func() time.Time { return time.Date(2023, 5, 1, 10, 20, 30, 999, time.UTC) }
+	if now := Now(clock); !now.Equal(time.Date(2023, 5, 1, 10, 20, 30, 0, time.UTC)) {
+		t.Errorf("Expected the time of the clock truncated to the second, got %v", now)
+	}
+	if now := Now(nil); now.Nanosecond() != 0 || time.Since(now) > 2*time.Second {
+		t.Errorf("Expected the current time truncated to the second, got %v", now)
+	}
+}
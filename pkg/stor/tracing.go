@@ -0,0 +1,113 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"errors"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// name of the gorm plugin tracing the statements
+const tracingPluginName = "lcp:tracing"
+
+// key of the span of a statement, in the instance settings of the statement
+const tracingSpanKey = "lcp:span"
+
+// tracingPlugin traces each statement in a span, child of the span of the context of the store, e.g. of a request.
+// The SQL of a statement is recorded with its placeholders, as its values may be personal data.
+type tracingPlugin struct {
+	tracer trace.Tracer
+}
+
+// EnableTracing traces the statements run on a store set up by DBSetup, with the global tracer provider.
+// The statements of a sharded store are traced in the database of each region.
+func EnableTracing(st Store) error {
+	if sharded, ok := st.(*shardedStore); ok {
+		for _, shard := range append([]Store{sharded.Store}, Regions(sharded)...) {
+			if rs, ok := shard.(*regionStore); ok {
+				shard = rs.Store
+			}
+			if err := EnableTracing(shard); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	s, ok := st.(*dbStore)
+	if !ok {
+		return errors.New("tracing is only supported by database stores")
+	}
+	return s.db.Use(&tracingPlugin{tracer: otel.Tracer("github.com/edrlab/lcp-server/pkg/stor")})
+}
+
+func (p *tracingPlugin) Name() string {
+	return tracingPluginName
+}
+
+// Initialize registers the callbacks starting a span before each operation, and ending it after the operation.
+func (p *tracingPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().Before("*").Register("lcp:tracing_start", p.start),
+		cb.Create().After("*").Register("lcp:tracing_end", p.end),
+		cb.Query().Before("*").Register("lcp:tracing_start", p.start),
+		cb.Query().After("*").Register("lcp:tracing_end", p.end),
+		cb.Update().Before("*").Register("lcp:tracing_start", p.start),
+		cb.Update().After("*").Register("lcp:tracing_end", p.end),
+		cb.Delete().Before("*").Register("lcp:tracing_start", p.start),
+		cb.Delete().After("*").Register("lcp:tracing_end", p.end),
+		cb.Row().Before("*").Register("lcp:tracing_start", p.start),
+		cb.Row().After("*").Register("lcp:tracing_end", p.end),
+		cb.Raw().Before("*").Register("lcp:tracing_start", p.start),
+		cb.Raw().After("*").Register("lcp:tracing_end", p.end),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *tracingPlugin) start(db *gorm.DB) {
+	_, span := p.tracer.Start(db.Statement.Context, "db", trace.WithSpanKind(trace.SpanKindClient))
+	db.InstanceSet(tracingSpanKey, span)
+}
+
+// end names the span after the statement and its table, e.g. "SELECT license_infos", and records the failure
+// of the statement; a record not found is not a failure.
+func (p *tracingPlugin) end(db *gorm.DB) {
+	v, ok := db.InstanceGet(tracingSpanKey)
+	if !ok {
+		return
+	}
+	span := v.(trace.Span)
+	defer span.End()
+
+	sql := db.Statement.SQL.String()
+	name := strings.ToUpper(strings.SplitN(strings.TrimSpace(sql), " ", 2)[0])
+	if name == "" {
+		// the statement failed before its SQL was built
+		name = "db"
+	}
+	if db.Statement.Table != "" {
+		name += " " + db.Statement.Table
+		span.SetAttributes(semconv.DBSQLTableKey.String(db.Statement.Table))
+	}
+	span.SetName(name)
+	span.SetAttributes(
+		semconv.DBSystemKey.String(db.Dialector.Name()),
+		semconv.DBStatementKey.String(sql),
+	)
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(db.Error)
+		span.SetStatus(codes.Error, db.Error.Error())
+	}
+}
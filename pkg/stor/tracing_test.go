@@ -0,0 +1,62 @@
+package stor
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+func TestEnableTracing(t *testing.T) {
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	st, err := DBSetup("sqlite3://file:tracingtest?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("Failed to set up the database: %v", err)
+	}
+	if err = EnableTracing(st); err != nil {
+		t.Fatalf("Failed to enable the tracing: %v", err)
+	}
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	pub := Publication{UUID: "tracingtest", Title: "Tracing"}
+	if err = st.WithContext(ctx).Publication().Create(&pub); err != nil {
+		t.Fatalf("Failed to create a publication: %v", err)
+	}
+	if _, err = st.WithContext(ctx).Publication().Get("unknown"); err == nil {
+		t.Fatal("Expected an unknown publication")
+	}
+	parent.End()
+
+	var statements []string
+	for _, span := range recorder.Ended() {
+		if span.Name() == "request" {
+			continue
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("Expected the span %s to be a child of the request", span.Name())
+		}
+		// a record not found is not a failure
+		if span.Status().Code == codes.Error {
+			t.Errorf("Unexpected error in the span %s", span.Name())
+		}
+		for _, attr := range span.Attributes() {
+			if attr.Key == semconv.DBStatementKey {
+				statements = append(statements, span.Name())
+				if strings.Contains(attr.Value.AsString(), "unknown") {
+					t.Errorf("Expected the values to be left out of the statement, got %s", attr.Value.AsString())
+				}
+			}
+		}
+	}
+	if strings.Join(statements, ",") != "INSERT publications,SELECT publications" {
+		t.Errorf("Unexpected statements %v", statements)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PublicationUsage data model
+// The usage of a publication is aggregated per day: the counters of a day are incremented
+// by every fresh license fetched and every resource downloaded, instead of storing each access.
+type PublicationUsage struct {
+	PublicationID  string `json:"-" gorm:"size:36;primaryKey"`
+	Day            string `json:"day" gorm:"size:10;primaryKey"` // UTC day, formatted as 2006-01-02
+	LicenseFetches int64  `json:"license_fetches"`
+	Downloads      int64  `json:"downloads"`
+}
+
+// DayFormat is the format of the days of the usage of publications.
+const DayFormat = "2006-01-02"
+
+// Record adds license fetches and downloads to the usage of a publication on the day of a given time.
+func (s usageStore) Record(publicationID string, t time.Time, licenseFetches, downloads int64) error {
+	usage := &PublicationUsage{
+		PublicationID:  publicationID,
+		Day:            t.UTC().Format(DayFormat),
+		LicenseFetches: licenseFetches,
+		Downloads:      downloads,
+	}
+	// the counters of an existing day are incremented in place, so that concurrent requests are all counted
+	return s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "publication_id"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"license_fetches": gorm.Expr("publication_usages.license_fetches + ?", licenseFetches),
+			"downloads":       gorm.Expr("publication_usages.downloads + ?", downloads),
+		}),
+	}).Create(usage).Error
+}
+
+// List returns the daily usage of a publication between two days included, in chronological order.
+// Days without usage are not returned.
+func (s usageStore) List(publicationID string, from, to time.Time) (*[]PublicationUsage, error) {
+	usage := []PublicationUsage{}
+	return &usage, s.db.Where("publication_id = ? AND day >= ? AND day <= ?",
+		publicationID, from.UTC().Format(DayFormat), to.UTC().Format(DayFormat)).
+		Order("day ASC").Find(&usage).Error
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+)
+
+// Warmup prepares a store set up by DBSetup or DBOpen for its first requests, in the database of each region:
+// a number of connections are opened and kept idle in the pool, every model is read once, which parses its schema
+// and loads the pages of its table, and the publications most recently fulfilled are loaded. Stores of other types
+// are left as they are.
+func Warmup(ctx context.Context, st Store, conns, publications int) error {
+	if sharded, ok := st.(*shardedStore); ok {
+		for _, shard := range append([]Store{sharded.Store}, Regions(sharded)...) {
+			if rs, ok := shard.(*regionStore); ok {
+				shard = rs.Store
+			}
+			if err := Warmup(ctx, shard, conns, publications); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	s, ok := st.(*dbStore)
+	if !ok {
+		return nil
+	}
+	db := s.db.WithContext(ctx)
+
+	if conns > 0 {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		// the connections are held together, so that each one is a new connection
+		if conns > 2 {
+			sqlDB.SetMaxIdleConns(conns)
+		}
+		held := make([]*sql.Conn, 0, conns)
+		for i := 0; i < conns; i++ {
+			conn, err := sqlDB.Conn(ctx)
+			if err == nil {
+				err = conn.PingContext(ctx)
+			}
+			if err != nil {
+				for _, c := range held {
+					c.Close()
+				}
+				return fmt.Errorf("failed to open a database connection: %w", err)
+			}
+			held = append(held, conn)
+		}
+		for _, c := range held {
+			c.Close()
+		}
+	}
+
+	for _, model := range models {
+		// the models are shared, and read in a new value
+		if err := db.Limit(1).Find(reflect.New(reflect.TypeOf(model).Elem()).Interface()).Error; err != nil {
+			return fmt.Errorf("failed to read the table of %T: %w", model, err)
+		}
+	}
+
+	if publications > 0 {
+		err := db.Where("last_fulfilled IS NOT NULL").Order("last_fulfilled DESC").Limit(publications).Find(&[]Publication{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to load the publications: %w", err)
+		}
+	}
+	return nil
+}
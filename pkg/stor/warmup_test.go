@@ -0,0 +1,40 @@
+package stor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWarmup(t *testing.T) {
+
+	st, err := DBSetup("sqlite3://file:warmuptest?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("Failed to set up the database: %v", err)
+	}
+	pub := Publication{UUID: "warmuptest", Title: "Warmup"}
+	if err = st.Publication().Create(&pub); err != nil {
+		t.Fatal(err)
+	}
+	if err = st.Publication().SetFulfilled(pub.UUID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Warmup(context.Background(), st, 4, 10); err != nil {
+		t.Fatalf("Failed to warm the store up: %v", err)
+	}
+	sqlDB, err := st.(*dbStore).db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := sqlDB.Stats(); stats.Idle < 4 {
+		t.Errorf("Expected 4 idle connections, got %d", stats.Idle)
+	}
+
+	// a cancelled warmup fails
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = Warmup(ctx, st, 4, 10); err == nil {
+		t.Error("Expected a cancelled warmup to fail")
+	}
+}
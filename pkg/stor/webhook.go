@@ -0,0 +1,96 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Webhook data model
+// A webhook is the delivery state of an endpoint notified of the changes of the licenses: the notifications follow
+// the audit records and the events of the licenses, and the endpoint is notified of the records and events after
+// its cursors, in order. A failed notification is retried at its next attempt, the following ones waiting for it.
+type Webhook struct {
+	ID          uint       `json:"-" gorm:"primaryKey"`
+	UpdatedAt   time.Time  `json:"updated"`
+	Name        string     `json:"name" gorm:"size:64;uniqueIndex"`
+	AuditCursor uint       `json:"audit_cursor"` // last audit record notified, or skipped
+	EventCursor uint       `json:"event_cursor"` // last event notified, or skipped
+	Attempts    int        `json:"attempts"`     // failed attempts of the pending notification
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// DeadLetter data model
+// A dead letter is a notification dropped after the last failed attempt of its delivery, kept so that it can be
+// inspected and replayed by the receiver.
+type DeadLetter struct {
+	ID             uint            `json:"id" gorm:"primaryKey"`
+	CreatedAt      time.Time       `json:"created"`
+	Target         string          `json:"target" gorm:"size:64;index"` // name of the target, e.g. the webhook endpoint
+	NotificationID string          `json:"notification_id" gorm:"size:32"`
+	Type           string          `json:"type" gorm:"size:32"`
+	LicenseID      string          `json:"license_id" gorm:"size:36"`
+	Payload        json.RawMessage `json:"payload" gorm:"serializer:json"`
+	Attempts       int             `json:"attempts"`
+	LastError      string          `json:"last_error"`
+}
+
+// Get returns the delivery state of an endpoint. The state of a new endpoint is created, after the latest audit
+// record and event, so that the past changes of the licenses are not notified.
+func (s webhookStore) Get(name string) (*Webhook, error) {
+	var webhook Webhook
+	err := s.db.Where("name = ?", name).First(&webhook).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return &webhook, err
+	}
+	webhook = Webhook{Name: name}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&AuditRecord{}).Select("COALESCE(MAX(id), 0)").Scan(&webhook.AuditCursor).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Event{}).Select("COALESCE(MAX(id), 0)").Scan(&webhook.EventCursor).Error; err != nil {
+			return err
+		}
+		return tx.Create(&webhook).Error
+	})
+	if err != nil {
+		// created concurrently
+		if err = s.db.Where("name = ?", name).First(&webhook).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &webhook, nil
+}
+
+// Update records the delivery state of an endpoint.
+func (s webhookStore) Update(w *Webhook) error {
+	if len(w.LastError) > 255 {
+		w.LastError = w.LastError[:255]
+	}
+	return s.db.Save(w).Error
+}
+
+// Drop records a dead letter.
+func (s webhookStore) Drop(d *DeadLetter) error {
+	if len(d.LastError) > 255 {
+		d.LastError = d.LastError[:255]
+	}
+	return s.db.Create(d).Error
+}
+
+// DeadLetters returns the latest dead letters of a target, or of every target if empty, the most recent first.
+func (s webhookStore) DeadLetters(target string, limit int) (*[]DeadLetter, error) {
+	letters := []DeadLetter{}
+	db := s.db
+	if target != "" {
+		db = db.Where("target = ?", target)
+	}
+	return &letters, db.Order("id DESC").Limit(limitOf(limit)).Find(&letters).Error
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	log "github.com/sirupsen/logrus"
+)
+
+// Orphan is a stored object which no publication refers to.
+type Orphan struct {
+	Tier     string    `json:"tier"`
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Deleted  bool      `json:"deleted"`
+}
+
+// GCReport is the result of a garbage collection.
+type GCReport struct {
+	DryRun  bool     `json:"dry_run"`
+	Checked int      `json:"checked"` // number of stored objects
+	Orphans []Orphan `json:"orphans"`
+	Size    int64    `json:"size"` // total size of the orphans
+}
+
+// CollectOrphans compares the content of the storages with the publications, and deletes the objects
+// no publication refers to, e.g. after a failed upload or the deletion of a publication.
+// Objects modified during the grace period are left alone, as their publication may not be recorded yet.
+// In dry-run mode, orphans are only reported.
+func (t *Tiering) CollectOrphans(ctx context.Context, grace time.Duration, dryRun bool) (*GCReport, error) {
+
+	keys, err := t.Store.WithContext(ctx).Publication().ListStorageKeys()
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		referenced[k] = true
+	}
+
+	report := &GCReport{DryRun: dryRun, Orphans: []Orphan{}}
+	limit := time.Now().Add(-grace)
+
+	tiers := []struct {
+		name    string
+		storage Storage
+	}{{stor.TIER_HOT, t.Hot}, {stor.TIER_COLD, t.Cold}}
+
+	for _, tier := range tiers {
+		if tier.storage == nil {
+			continue
+		}
+		objects, err := tier.storage.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		report.Checked += len(objects)
+		for _, o := range objects {
+			if referenced[o.Key] || o.Modified.After(limit) {
+				continue
+			}
+			orphan := Orphan{Tier: tier.name, Key: o.Key, Size: o.Size, Modified: o.Modified}
+			if !dryRun {
+				if err := tier.storage.Delete(ctx, o.Key); err != nil {
+					log.Errorf("Failed to delete the orphan %s: %v", o.Key, err)
+				} else {
+					orphan.Deleted = true
+				}
+			}
+			report.Orphans = append(report.Orphans, orphan)
+			report.Size += o.Size
+		}
+	}
+	log.Infof("Storage GC: %d objects checked, %d orphans (dry run: %t)", report.Checked, len(report.Orphans), dryRun)
+	return report, nil
+}
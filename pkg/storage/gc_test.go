@@ -0,0 +1,72 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/google/uuid"
+)
+
+func TestCollectOrphans(t *testing.T) {
+
+	ctx := context.Background()
+	st, err := stor.DBSetup("sqlite3://file:gc?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hot, _ := NewFileStorage(t.TempDir(), "")
+	tiering := &Tiering{Hot: hot, Store: st}
+
+	pub := stor.Publication{UUID: uuid.New().String(), Location: "https://example.com/a.epub", Checksum: "YQ==", StorageKey: "a.epub"}
+	if err = st.Publication().Create(&pub); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	for _, key := range []string{"a.epub", "orphan.epub", "recent.epub"} {
+		hot.Put(ctx, key, strings.NewReader("content"))
+		if key != "recent.epub" {
+			os.Chtimes(filepath.Join(hot.dir, key), old, old)
+		}
+	}
+
+	// a dry run only reports
+	report, err := tiering.CollectOrphans(ctx, 24*time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 3 || len(report.Orphans) != 1 || report.Orphans[0].Key != "orphan.epub" || report.Orphans[0].Deleted {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	if _, err = hot.Get(ctx, "orphan.epub"); err != nil {
+		t.Error("A dry run must not delete orphans")
+	}
+
+	report, err = tiering.CollectOrphans(ctx, 24*time.Hour, false)
+	if err != nil || len(report.Orphans) != 1 || !report.Orphans[0].Deleted {
+		t.Fatalf("Unexpected report %+v, %v", report, err)
+	}
+	if _, err = hot.Get(ctx, "orphan.epub"); err == nil {
+		t.Error("The orphan should have been deleted")
+	}
+	for _, key := range []string{"a.epub", "recent.epub"} {
+		if _, err = hot.Get(ctx, key); err != nil {
+			t.Errorf("%s should have been kept", key)
+		}
+	}
+
+	// the blob of a deleted publication is an orphan
+	st.Publication().Delete(&pub)
+	report, _ = tiering.CollectOrphans(ctx, 24*time.Hour, true)
+	if len(report.Orphans) != 1 || report.Orphans[0].Key != "a.epub" {
+		t.Errorf("Unexpected report %+v", report)
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config locates an S3 bucket, on AWS or on a compatible service, e.g. MinIO.
+type S3Config struct {
+	Endpoint  string // e.g. "https://s3.eu-west-3.amazonaws.com" or "http://minio:9000"
+	Region    string
+	Bucket    string
+	Prefix    string // key prefix of the objects, e.g. "publications/"
+	AccessKey string
+	SecretKey string
+	PathStyle bool   // path-style urls (endpoint/bucket/key), required by most compatible services
+	BaseURL   string // url the bucket is served at, empty if it is not public
+}
+
+// S3Storage stores objects in an S3 bucket. Requests are signed with AWS Signature Version 4.
+type S3Storage struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	clock    func() time.Time
+}
+
+// NewS3Storage creates a storage in an existing S3 bucket.
+func NewS3Storage(c S3Config) (*S3Storage, error) {
+	if c.Bucket == "" {
+		return nil, errors.New("missing storage bucket")
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(c.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid storage endpoint %q", c.Endpoint)
+	}
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	c.BaseURL = strings.TrimSuffix(c.BaseURL, "/")
+	return &S3Storage{config: c, endpoint: endpoint, client: http.DefaultClient, clock: time.Now}, nil
+}
+
+// objectURL returns the url of an object, or of the bucket if the key is empty
+func (s *S3Storage) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	if s.config.PathStyle {
+		u.Path += "/" + s.config.Bucket + "/" + key
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+		u.Path += "/" + key
+	}
+	return &u
+}
+
+// key returns the object key of a storage key
+func (s *S3Storage) key(key string) (string, error) {
+	clean := path.Clean("/" + key)[1:]
+	if key == "" || clean != key {
+		return "", ErrInvalidKey
+	}
+	return s.config.Prefix + key, nil
+}
+
+// do sends a signed request, with optional headers, and returns the response if its status code is a success
+func (s *S3Storage) do(ctx context.Context, method string, u *url.URL, body io.Reader, size int64, payloadHash string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signV4(req, s.config.AccessKey, s.config.SecretKey, s.config.Region, "s3", payloadHash, s.clock())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, s3Error(resp)
+	}
+	return resp, nil
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return 0, err
+	}
+	// the content is spooled to a temp file, as its length and hash are sent before it
+	tmp, err := os.CreateTemp("", "lcp-upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), &contextReader{ctx: ctx, r: r})
+	if err != nil {
+		return 0, err
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.objectURL(k), tmp, n, hex.EncodeToString(hash.Sum(nil)), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return n, nil
+}
+
+// PutLocked stores an object which can be neither overwritten nor deleted until a retention date, with S3 Object Lock:
+// the bucket must have been created with object lock enabled. The lock mode is "COMPLIANCE" or "GOVERNANCE".
+func (s *S3Storage) PutLocked(ctx context.Context, key string, data []byte, mode string, until time.Time) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(data)
+	// object lock requires the md5 of the content
+	sum := md5.Sum(data)
+	header := http.Header{}
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	header.Set("X-Amz-Object-Lock-Mode", mode)
+	header.Set("X-Amz-Object-Lock-Retain-Until-Date", until.UTC().Format(time.RFC3339))
+	resp, err := s.do(ctx, http.MethodPut, s.objectURL(k), bytes.NewReader(data), int64(len(data)), hex.EncodeToString(hash[:]), header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(k), nil, 0, emptyHash, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(k), nil, 0, emptyHash, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listResult is the response of a ListObjectsV2 request
+type listResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *S3Storage) List(ctx context.Context) ([]Object, error) {
+	objects := []Object{}
+	token := ""
+	for {
+		u := s.objectURL("")
+		query := url.Values{"list-type": {"2"}}
+		if s.config.Prefix != "" {
+			query.Set("prefix", s.config.Prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = query.Encode()
+		resp, err := s.do(ctx, http.MethodGet, u, nil, 0, emptyHash, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result listResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			objects = append(objects, Object{Key: strings.TrimPrefix(c.Key, s.config.Prefix), Size: c.Size, Modified: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Ping lists at most one object of the bucket, which verifies the endpoint, the credentials and the bucket.
+func (s *S3Storage) Ping(ctx context.Context) error {
+	u := s.objectURL("")
+	query := url.Values{"list-type": {"2"}, "max-keys": {"1"}}
+	if s.config.Prefix != "" {
+		query.Set("prefix", s.config.Prefix)
+	}
+	u.RawQuery = query.Encode()
+	resp, err := s.do(ctx, http.MethodGet, u, nil, 0, emptyHash, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) URL(key string) string {
+	return publicURL(s.config.BaseURL, key)
+}
+
+// s3Error returns the error of a failed request; a missing object is reported as fs.ErrNotExist
+func s3Error(resp *http.Response) error {
+	var e struct {
+		Code    string
+		Message string
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&e)
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("s3: %s: %w", e.Code, fs.ErrNotExist)
+	}
+	if e.Code == "" {
+		e.Code = resp.Status
+	}
+	return fmt.Errorf("s3: %s: %s", e.Code, e.Message)
+}
+
+// sha256 of an empty payload
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// signV4 signs a request with AWS Signature Version 4, from the host header and the x-amz-* headers.
+func signV4(req *http.Request, accessKey, secretKey, region, service, payloadHash string, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	// canonical request
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name := strings.ToLower(name); strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	// string to sign, signed by a key derived from the secret key
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery returns the query parameters sorted by name, then by value, and encoded
+func canonicalQuery(query url.Values) string {
+	pairs := []string{}
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes every byte but the unreserved characters, and the slashes of a path
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,192 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeS3 is a minimal S3 service storing the objects of a bucket in memory, with path-style urls.
+// Listings return a single object per page.
+type fakeS3 struct {
+	t       *testing.T
+	bucket  string
+	objects map[string][]byte
+	locks   map[string]string // retention dates of the objects locked in compliance mode
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	// check the signature
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=access/") || r.Header.Get("X-Amz-Content-Sha256") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	signed := r.Clone(context.Background())
+	signed.URL.Host = r.Host
+	date, _ := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	signV4(signed, "access", "secret", "eu-west-3", "s3", r.Header.Get("X-Amz-Content-Sha256"), date)
+	if signed.Header.Get("Authorization") != auth {
+		f.t.Errorf("Invalid signature %s", auth)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/"+f.bucket+"/")
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		keys := []string{}
+		for k := range f.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		i, _ := strconv.Atoi(r.URL.Query().Get("continuation-token"))
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+		if i < len(keys) {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size><LastModified>2023-05-01T10:00:00.000Z</LastModified></Contents>", keys[i], len(f.objects[keys[i]]))
+		}
+		if i+1 < len(keys) {
+			fmt.Fprintf(w, "<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>", i+1)
+		}
+		fmt.Fprint(w, "</ListBucketResult>")
+	case r.Method == http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		hash := sha256.Sum256(data)
+		if hex.EncodeToString(hash[:]) != r.Header.Get("X-Amz-Content-Sha256") || int64(len(data)) != r.ContentLength {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if mode := r.Header.Get("X-Amz-Object-Lock-Mode"); mode != "" {
+			sum := md5.Sum(data)
+			if mode != "COMPLIANCE" || r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(sum[:]) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			f.locks[key] = r.Header.Get("X-Amz-Object-Lock-Retain-Until-Date")
+		}
+		f.objects[key] = data
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>")
+			return
+		}
+		w.Write(data)
+	case r.Method == http.MethodDelete:
+		if _, ok := f.locks[key]; ok {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "<Error><Code>AccessDenied</Code><Message>Access Denied because object protected by object lock.</Message></Error>")
+			return
+		}
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3Storage(t *testing.T) {
+
+	ctx := context.Background()
+	srv := httptest.NewServer(&fakeS3{t: t, bucket: "books", objects: map[string][]byte{"other/file": []byte("x")}})
+	defer srv.Close()
+	st, err := NewS3Storage(S3Config{Endpoint: srv.URL, Region: "eu-west-3", Bucket: "books", Prefix: "pubs/",
+		AccessKey: "access", SecretKey: "secret", PathStyle: true, BaseURL: "https://cdn.example.com/pubs/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"2023/alice in wonderland.epub", "flatland.epub"} {
+		if n, err := st.Put(ctx, key, strings.NewReader("protected content")); err != nil || n != 17 {
+			t.Fatalf("Failed to put an object: %d, %v", n, err)
+		}
+	}
+	rc, err := st.Get(ctx, "2023/alice in wonderland.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if string(data) != "protected content" {
+		t.Errorf("Unexpected content %q", data)
+	}
+	if u := st.URL("2023/alice in wonderland.epub"); u != "https://cdn.example.com/pubs/2023/alice%20in%20wonderland.epub" {
+		t.Errorf("Unexpected url %s", u)
+	}
+
+	// objects of the prefix are listed, page by page
+	objects, err := st.List(ctx)
+	if err != nil || len(objects) != 2 || objects[0].Key != "2023/alice in wonderland.epub" || objects[1].Size != 17 {
+		t.Errorf("Unexpected list %v, %v", objects, err)
+	}
+
+	if err = st.Ping(ctx); err != nil {
+		t.Errorf("Expected an available bucket, got %v", err)
+	}
+
+	if err = st.Delete(ctx, "flatland.epub"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = st.Get(ctx, "flatland.epub"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a missing object, got %v", err)
+	}
+	if _, err := st.Put(ctx, "../secret", strings.NewReader("x")); err != ErrInvalidKey {
+		t.Error("The key should be rejected")
+	}
+}
+
+func TestS3PutLocked(t *testing.T) {
+
+	ctx := context.Background()
+	fake := &fakeS3{t: t, bucket: "audit", objects: map[string][]byte{}, locks: map[string]string{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	st, err := NewS3Storage(S3Config{Endpoint: srv.URL, Region: "eu-west-3", Bucket: "audit", Prefix: "lcp/",
+		AccessKey: "access", SecretKey: "secret", PathStyle: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	until := time.Date(2030, 5, 1, 10, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+	if err = st.PutLocked(ctx, "events/1.json", []byte(`{"events":[]}`), "COMPLIANCE", until); err != nil {
+		t.Fatalf("Failed to put a locked object: %v", err)
+	}
+	if fake.locks["lcp/events/1.json"] != "2030-05-01T08:00:00Z" || string(fake.objects["lcp/events/1.json"]) != `{"events":[]}` {
+		t.Errorf("Expected a locked object, got %v", fake.locks)
+	}
+	if err = st.Delete(ctx, "events/1.json"); err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("Expected the locked object to be kept, got %v", err)
+	}
+}
+
+// get-vanilla, from the AWS Signature Version 4 test suite
+func TestSignV4(t *testing.T) {
+
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	signV4(req, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", emptyHash,
+		time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Errorf("Unexpected authorization %s", auth)
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package storage manages the files of protected publications.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+)
+
+// Storage is a place where protected publications are stored.
+// Operations are cancelled with their context.
+type Storage interface {
+	// Put stores the content of r under a key, and returns the number of bytes written.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Get opens the content stored under a key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the content stored under a key.
+	Delete(ctx context.Context, key string) error
+	// List returns every object of the storage.
+	List(ctx context.Context) ([]Object, error)
+	// URL returns the public url of a key, or an empty string if the storage is not public.
+	URL(key string) string
+}
+
+// Locker is a write-once storage, whose objects can be neither overwritten nor deleted until a retention date,
+// e.g. an S3 bucket with object lock.
+type Locker interface {
+	// PutLocked stores data under a key, locked until a date, in a lock mode given by the storage.
+	PutLocked(ctx context.Context, key string, data []byte, mode string, until time.Time) error
+}
+
+// Pinger is a storage which verifies cheaply that it is available.
+type Pinger interface {
+	// Ping returns an error if the storage can't be reached.
+	Ping(ctx context.Context) error
+}
+
+// Ping verifies that a storage is available, e.g. for the health checks of a load balancer: by its Ping method
+// if it has one, else by reading a missing object, which must be reported as missing.
+func Ping(ctx context.Context, s Storage) error {
+	if p, ok := s.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	rc, err := s.Get(ctx, ".lcpserver-ping")
+	if err == nil {
+		rc.Close()
+		return nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Object describes a stored object.
+type Object struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// ErrInvalidKey is returned for keys which could escape the storage.
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// Open returns the storage described by a configuration: an S3 bucket if a bucket is set,
+// else a directory of the file system.
+func Open(c conf.FileStorage) (Storage, error) {
+	if c.S3.Bucket == "" {
+		return NewFileStorage(c.Path, c.BaseURL)
+	}
+	return NewS3Storage(S3Config{
+		Endpoint:  c.S3.Endpoint,
+		Region:    c.S3.Region,
+		Bucket:    c.S3.Bucket,
+		Prefix:    c.S3.Prefix,
+		AccessKey: c.S3.AccessKey,
+		SecretKey: c.S3.SecretKey,
+		PathStyle: c.S3.PathStyle,
+		BaseURL:   c.BaseURL,
+	})
+}
+
+// FileStorage stores objects in a directory of the file system.
+type FileStorage struct {
+	dir     string
+	baseURL string
+}
+
+// NewFileStorage creates a file storage in dir, which is created if needed.
+// baseURL is the url the directory is served at, empty if it is not public.
+func NewFileStorage(dir, baseURL string) (*FileStorage, error) {
+	if dir == "" {
+		return nil, errors.New("missing storage directory")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStorage{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+// path returns the file path of a key
+func (s *FileStorage) path(key string) (string, error) {
+	clean := path.Clean("/" + key)[1:]
+	if key == "" || clean != key {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s *FileStorage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return 0, err
+	}
+	// write to a temp file first, so that a failed upload never leaves a partial object
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, &contextReader{ctx: ctx, r: r})
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err = tmp.Close(); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmp.Name(), p)
+}
+
+func (s *FileStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (s *FileStorage) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+func (s *FileStorage) List(ctx context.Context) ([]Object, error) {
+	objects := []Object{}
+	err := filepath.Walk(s.dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Key: filepath.ToSlash(rel), Size: info.Size(), Modified: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+// Ping verifies that the directory of the storage exists.
+func (s *FileStorage) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	info, err := os.Stat(s.dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", s.dir)
+	}
+	return nil
+}
+
+func (s *FileStorage) URL(key string) string {
+	return publicURL(s.baseURL, key)
+}
+
+// publicURL returns the url of a key under a base url, or an empty string if there is no base url
+func publicURL(baseURL, key string) string {
+	if baseURL == "" {
+		return ""
+	}
+	u := baseURL + "/"
+	for i, segment := range strings.Split(key, "/") {
+		if i > 0 {
+			u += "/"
+		}
+		u += url.PathEscape(segment)
+	}
+	return u
+}
+
+// contextReader stops reading as soon as its context is done,
+// e.g. when the client uploading a publication disconnects.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
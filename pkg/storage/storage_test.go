@@ -0,0 +1,65 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestFileStorage(t *testing.T) {
+
+	ctx := context.Background()
+	st, err := NewFileStorage(t.TempDir(), "https://cdn.example.com/pubs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := st.Put(ctx, "2023/alice in wonderland.epub", strings.NewReader("protected content"))
+	if err != nil || n != 17 {
+		t.Fatalf("Failed to put an object: %d, %v", n, err)
+	}
+	rc, err := st.Get(ctx, "2023/alice in wonderland.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if string(data) != "protected content" {
+		t.Errorf("Unexpected content %q", data)
+	}
+
+	if u := st.URL("2023/alice in wonderland.epub"); u != "https://cdn.example.com/pubs/2023/alice%20in%20wonderland.epub" {
+		t.Errorf("Unexpected url %s", u)
+	}
+
+	objects, err := st.List(ctx)
+	if err != nil || len(objects) != 1 || objects[0].Key != "2023/alice in wonderland.epub" || objects[0].Size != 17 {
+		t.Errorf("Unexpected list %v, %v", objects, err)
+	}
+
+	// keys cannot escape the storage
+	for _, key := range []string{"", "../secret", "/etc/passwd", "a/../../b", "a//b"} {
+		if _, err := st.Put(ctx, key, strings.NewReader("x")); err != ErrInvalidKey {
+			t.Errorf("The key %q should be rejected", key)
+		}
+	}
+
+	if err = st.Delete(ctx, "2023/alice in wonderland.epub"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = st.Get(ctx, "2023/alice in wonderland.epub"); err == nil {
+		t.Error("The object should have been deleted")
+	}
+
+	// a cancelled context interrupts the operation
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err = st.Put(cancelled, "2023/cancelled.epub", strings.NewReader("x")); err == nil {
+		t.Error("A cancelled put should fail")
+	}
+}
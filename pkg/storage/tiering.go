@@ -0,0 +1,245 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	log "github.com/sirupsen/logrus"
+)
+
+// Tiering moves rarely fulfilled publications from a hot storage, from which publications are
+// served, to a cheaper cold storage, and moves them back when they are requested again.
+type Tiering struct {
+	Hot   Storage
+	Cold  Storage // nil if there is no cold storage
+	Store stor.Store
+}
+
+// move copies objects from one storage to another, then deletes the sources.
+// An object already moved by a previous, interrupted, call is skipped.
+func move(ctx context.Context, keys []string, from, to Storage) error {
+	for _, key := range keys {
+		rc, err := from.Get(ctx, key)
+		if err != nil {
+			if moved, e := to.Get(ctx, key); e == nil {
+				moved.Close()
+				continue
+			}
+			return err
+		}
+		_, err = to.Put(ctx, key, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if err = from.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keys returns the storage keys of a publication: its file and the files of its resources.
+func (t *Tiering) keys(ctx context.Context, pub *stor.Publication) ([]string, error) {
+	keys := []string{}
+	if pub.StorageKey != "" {
+		keys = append(keys, pub.StorageKey)
+	}
+	resources, err := t.Store.WithContext(ctx).Publication().ListResources(pub.UUID)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range *resources {
+		if r.StorageKey != "" {
+			keys = append(keys, r.StorageKey)
+		}
+	}
+	return keys, nil
+}
+
+// ErrRehydrating is returned while an archived publication is moved back to the hot storage: the request should be
+// retried after RehydrationRetry.
+var ErrRehydrating = errors.New("the publication is being restored from the cold storage, retry later")
+
+// RehydrationRetry is the delay after which a request for a publication being rehydrated should be retried.
+const RehydrationRetry = 30 * time.Second
+
+// Archive moves a publication to the cold storage, unless it was fulfilled since a given time.
+// The files of a publication are only moved by the request which set its tier in transition: a publication being
+// moved, or fulfilled meanwhile, is left as is.
+func (t *Tiering) Archive(ctx context.Context, pub *stor.Publication, before time.Time) error {
+	if t.Cold == nil || pub.Tier != stor.TIER_HOT {
+		return nil
+	}
+	keys, err := t.keys(ctx, pub)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	publications := t.Store.WithContext(ctx).Publication()
+	if started, err := publications.StartArchive(pub.UUID, before); err != nil || !started {
+		return err
+	}
+	if err := move(ctx, keys, t.Hot, t.Cold); err != nil {
+		// the files already moved are moved back, and the publication stays in the hot storage
+		if e := move(ctx, keys, t.Cold, t.Hot); e != nil {
+			log.Errorf("Failed to move the publication %s back to the hot storage: %v", pub.UUID, e)
+		}
+		if _, e := publications.SwapTier(pub.UUID, stor.TIER_ARCHIVING, stor.TIER_HOT); e != nil {
+			log.Error(e)
+		}
+		return fmt.Errorf("failed to archive the publication %s: %w", pub.UUID, err)
+	}
+	pub.Tier = stor.TIER_COLD
+	_, err = publications.SwapTier(pub.UUID, stor.TIER_ARCHIVING, stor.TIER_COLD)
+	return err
+}
+
+// Rehydrate moves a publication back to the hot storage, where it can be downloaded from, and waits for the move.
+// Its location is unchanged. ErrRehydrating is returned if the publication is being moved by another request.
+func (t *Tiering) Rehydrate(ctx context.Context, pub *stor.Publication) error {
+	if t.Cold == nil || pub.Tier == stor.TIER_HOT {
+		return nil
+	}
+	publications := t.Store.WithContext(ctx).Publication()
+	started, err := publications.SwapTier(pub.UUID, stor.TIER_COLD, stor.TIER_REHYDRATING)
+	if err != nil {
+		return err
+	}
+	if !started {
+		// rehydrated meanwhile, or being moved
+		current, err := publications.Get(pub.UUID)
+		if err != nil {
+			return err
+		}
+		if pub.Tier = current.Tier; pub.Tier == stor.TIER_HOT {
+			return nil
+		}
+		return ErrRehydrating
+	}
+	keys, err := t.keys(ctx, pub)
+	if err == nil {
+		err = move(ctx, keys, t.Cold, t.Hot)
+	}
+	if err != nil {
+		// the files already moved are moved again by the next rehydration
+		if _, e := publications.SwapTier(pub.UUID, stor.TIER_REHYDRATING, stor.TIER_COLD); e != nil {
+			log.Error(e)
+		}
+		return fmt.Errorf("failed to rehydrate the publication %s: %w", pub.UUID, err)
+	}
+	pub.Tier = stor.TIER_HOT
+	log.Infof("Publication %s rehydrated", pub.UUID)
+	_, err = publications.SwapTier(pub.UUID, stor.TIER_REHYDRATING, stor.TIER_HOT)
+	return err
+}
+
+// Restore rehydrates an archived publication in the background, as copying large files would hold the request:
+// ErrRehydrating is returned until it is back in the hot storage.
+func (t *Tiering) Restore(ctx context.Context, pub *stor.Publication) error {
+	if t.Cold == nil {
+		return nil
+	}
+	current, err := t.Store.WithContext(ctx).Publication().Get(pub.UUID)
+	if err != nil {
+		return err
+	}
+	if pub.Tier = current.Tier; pub.Tier == stor.TIER_HOT {
+		return nil
+	}
+	if pub.Tier == stor.TIER_COLD {
+		archived := *pub
+		go func() {
+			if err := t.Rehydrate(context.Background(), &archived); err != nil && !errors.Is(err, ErrRehydrating) {
+				log.Error(err)
+			}
+		}()
+	}
+	return ErrRehydrating
+}
+
+// Fulfill records that a license is served for a publication, and restores it if it is archived.
+// The tier is read once the fulfillment is recorded, so that a publication is never archived while served.
+func (t *Tiering) Fulfill(ctx context.Context, pub *stor.Publication) error {
+	now := time.Now()
+	publications := t.Store.WithContext(ctx).Publication()
+	if err := publications.SetFulfilled(pub.UUID, now); err != nil {
+		return err
+	}
+	pub.LastFulfilled = &now
+	return t.Restore(ctx, pub)
+}
+
+// Missing returns the storage keys of a publication whose objects are missing from the storage of its tier,
+// e.g. the files of a deleted publication removed by a garbage collection.
+func (t *Tiering) Missing(ctx context.Context, pub *stor.Publication) ([]string, error) {
+	keys, err := t.keys(ctx, pub)
+	if err != nil {
+		return nil, err
+	}
+	s := t.Hot
+	if pub.Tier == stor.TIER_COLD && t.Cold != nil {
+		s = t.Cold
+	}
+	missing := []string{}
+	for _, key := range keys {
+		rc, err := s.Get(ctx, key)
+		if errors.Is(err, fs.ErrNotExist) {
+			missing = append(missing, key)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rc.Close()
+	}
+	return missing, nil
+}
+
+// ArchiveBatch is the number of publications read at once by the lifecycle.
+const ArchiveBatch = 1000
+
+// RunLifecycle archives the publications which were not fulfilled since a given time, by batches until none
+// is left, and returns the number of archived publications.
+func (t *Tiering) RunLifecycle(ctx context.Context, before time.Time) (int, error) {
+	if t.Cold == nil {
+		return 0, nil
+	}
+	count := 0
+	var afterID uint
+	for {
+		publications, err := t.Store.WithContext(ctx).Publication().FindArchivable(before, afterID, ArchiveBatch)
+		if err != nil {
+			return count, err
+		}
+		for i := range *publications {
+			if err := ctx.Err(); err != nil {
+				return count, err
+			}
+			// a failure on a publication doesn't stop the archival of the others, and is retried the next day
+			pub := &(*publications)[i]
+			afterID = pub.ID
+			if err := t.Archive(ctx, pub, before); err != nil {
+				log.Error(err)
+				continue
+			}
+			if pub.Tier == stor.TIER_COLD {
+				count++
+			}
+		}
+		if len(*publications) < ArchiveBatch {
+			break
+		}
+	}
+	if count > 0 {
+		log.Infof("%d publications archived to cold storage", count)
+	}
+	return count, nil
+}
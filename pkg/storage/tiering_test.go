@@ -0,0 +1,235 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/google/uuid"
+)
+
+func TestTiering(t *testing.T) {
+
+	ctx := context.Background()
+	st, err := stor.DBSetup("sqlite3://file:tiering?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hot, _ := NewFileStorage(t.TempDir(), "https://cdn.example.com")
+	cold, _ := NewFileStorage(t.TempDir(), "")
+	tiering := &Tiering{Hot: hot, Cold: cold, Store: st}
+
+	// a managed publication and an external one
+	managed := stor.Publication{UUID: uuid.New().String(), Location: hot.URL("a.epub"), Checksum: "YQ==", Size: 7, StorageKey: "a.epub"}
+	external := stor.Publication{UUID: uuid.New().String(), Location: "https://example.com/b.epub", Checksum: "YQ==", Size: 9}
+	for _, p := range []*stor.Publication{&managed, &external} {
+		if err = st.Publication().Create(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hot.Put(ctx, "a.epub", strings.NewReader("content"))
+
+	// nothing is archived before the deadline
+	if n, _ := tiering.RunLifecycle(ctx, time.Now().Add(-time.Hour)); n != 0 {
+		t.Errorf("Expected no archived publication, got %d", n)
+	}
+	n, err := tiering.RunLifecycle(ctx, time.Now().Add(time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 archived publication, got %d, %v", n, err)
+	}
+	if _, err = hot.Get(ctx, "a.epub"); err == nil {
+		t.Error("The archived publication should not be in the hot storage")
+	}
+
+	usage, err := st.Publication().StorageUsage()
+	if err != nil || len(*usage) != 1 || (*usage)[0].Tier != stor.TIER_COLD || (*usage)[0].Size != 7 {
+		t.Errorf("Unexpected storage usage %v, %v", usage, err)
+	}
+
+	// fulfillment rehydrates the publication in the background
+	pub, _ := st.Publication().Get(managed.UUID)
+	if err = tiering.Fulfill(ctx, pub); !errors.Is(err, ErrRehydrating) {
+		t.Fatalf("Expected the publication to be rehydrating, got %v", err)
+	}
+	pub = waitTier(t, st, managed.UUID, stor.TIER_HOT)
+	if pub.LastFulfilled == nil {
+		t.Error("The publication should be fulfilled")
+	}
+	if err = tiering.Fulfill(ctx, pub); err != nil {
+		t.Error(err)
+	}
+	if _, err = hot.Get(ctx, "a.epub"); err != nil {
+		t.Error("The rehydrated publication should be in the hot storage")
+	}
+	// a recently fulfilled publication is not archived
+	if n, _ := tiering.RunLifecycle(ctx, time.Now().Add(-time.Minute)); n != 0 {
+		t.Errorf("Expected no archived publication, got %d", n)
+	}
+}
+
+func TestLifecycleBatches(t *testing.T) {
+
+	ctx := context.Background()
+	st, err := stor.DBSetup("sqlite3://file:lifecycle?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hot, _ := NewFileStorage(t.TempDir(), "")
+	cold, _ := NewFileStorage(t.TempDir(), "")
+	tiering := &Tiering{Hot: hot, Cold: cold, Store: st}
+
+	// more publications than a batch, of two providers, one of them missing its file
+	total := ArchiveBatch + 2
+	for i := 0; i < total; i++ {
+		key := uuid.New().String() + ".epub"
+		provider := "https://a.example.com"
+		if i%2 == 1 {
+			provider = "https://b.example.com"
+		}
+		pub := stor.Publication{UUID: uuid.New().String(), Provider: provider, Location: hot.URL(key), Checksum: "YQ==", Size: 7, StorageKey: key}
+		if err = st.Publication().Create(&pub); err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 {
+			hot.Put(ctx, key, strings.NewReader("content"))
+		}
+	}
+
+	// every batch is archived
+	n, err := tiering.RunLifecycle(ctx, time.Now().Add(time.Hour))
+	if err != nil || n != total-1 {
+		t.Fatalf("Expected %d archived publications, got %d, %v", total-1, n, err)
+	}
+
+	// the storage is used per provider and tier
+	usage, err := st.Publication().StorageUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []stor.StorageUsage{
+		{Provider: "https://a.example.com", Tier: stor.TIER_COLD, Count: int64(total/2 - 1), Size: int64(7 * (total/2 - 1))},
+		{Provider: "https://a.example.com", Tier: stor.TIER_HOT, Count: 1, Size: 7},
+		{Provider: "https://b.example.com", Tier: stor.TIER_COLD, Count: int64(total / 2), Size: int64(7 * total / 2)},
+	}
+	if len(*usage) != len(expected) {
+		t.Fatalf("Unexpected storage usage %v", *usage)
+	}
+	for i := range expected {
+		if (*usage)[i] != expected[i] {
+			t.Errorf("Expected the storage usage %v, got %v", expected[i], (*usage)[i])
+		}
+	}
+}
+
+// waitTier waits for a publication to reach a storage tier, and returns it.
+func waitTier(t *testing.T, st stor.Store, uuid, tier string) *stor.Publication {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		pub, err := st.Publication().Get(uuid)
+		if err == nil && pub.Tier == tier {
+			return pub
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("The publication %s should be in the %s tier", uuid, tier)
+	return nil
+}
+
+func TestConcurrentRehydration(t *testing.T) {
+
+	ctx := context.Background()
+	st, err := stor.DBSetup("sqlite3://file:rehydration?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hot, _ := NewFileStorage(t.TempDir(), "")
+	cold, _ := NewFileStorage(t.TempDir(), "")
+	tiering := &Tiering{Hot: hot, Cold: cold, Store: st}
+
+	pub := stor.Publication{UUID: uuid.New().String(), Location: hot.URL("a.epub"), Checksum: "YQ==", Size: 7, StorageKey: "a.epub"}
+	if err = st.Publication().Create(&pub); err != nil {
+		t.Fatal(err)
+	}
+	hot.Put(ctx, "a.epub", strings.NewReader("content"))
+	if err = tiering.Archive(ctx, &pub, time.Now().Add(time.Hour)); err != nil || pub.Tier != stor.TIER_COLD {
+		t.Fatalf("Expected the publication to be archived, got %s, %v", pub.Tier, err)
+	}
+
+	// a single request moves the files, the others are told to retry
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			archived, _ := st.Publication().Get(pub.UUID)
+			if err := tiering.Rehydrate(ctx, archived); err != nil && !errors.Is(err, ErrRehydrating) {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	waitTier(t, st, pub.UUID, stor.TIER_HOT)
+	if _, err = hot.Get(ctx, "a.epub"); err != nil {
+		t.Error("The rehydrated publication should be in the hot storage")
+	}
+
+	// a publication fulfilled after the deadline is not archived, even from a stale copy
+	stale := pub
+	stale.Tier = stor.TIER_HOT
+	if err = tiering.Fulfill(ctx, &pub); err != nil {
+		t.Fatal(err)
+	}
+	if err = tiering.Archive(ctx, &stale, time.Now().Add(-time.Minute)); err != nil || stale.Tier != stor.TIER_HOT {
+		t.Errorf("Expected the publication to stay in the hot storage, got %s, %v", stale.Tier, err)
+	}
+	if _, err = hot.Get(ctx, "a.epub"); err != nil {
+		t.Error("The fulfilled publication should be in the hot storage")
+	}
+}
+
+func TestStorageUsageResources(t *testing.T) {
+
+	st, err := stor.DBSetup("sqlite3://file:" + uuid.New().String() + "?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a single file publication, and a multi-part one made of resources
+	single := stor.Publication{UUID: uuid.New().String(), Location: "a.epub", Checksum: "YQ==", Size: 7, StorageKey: "a.epub"}
+	multi := stor.Publication{UUID: uuid.New().String(), Location: "b/manifest.json", Checksum: "YQ==", Size: 1}
+	for _, p := range []*stor.Publication{&single, &multi} {
+		if err = st.Publication().Create(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resources := []stor.Resource{
+		{Position: 1, Href: "track1.mp3", ContentType: "audio/mpeg", Size: 3 << 30, Checksum: "YQ==", StorageKey: "b/track1.mp3"},
+		{Position: 2, Href: "track2.mp3", ContentType: "audio/mpeg", Size: 3 << 30, Checksum: "YQ==", StorageKey: "b/track2.mp3"},
+	}
+	if err = st.Publication().SetResources(multi.UUID, resources); err != nil {
+		t.Fatal(err)
+	}
+
+	// sizes are added on 64 bits
+	usage, err := st.Publication().StorageUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := stor.StorageUsage{Tier: stor.TIER_HOT, Count: 2, Size: 7 + 6<<30}
+	if len(*usage) != 1 || (*usage)[0] != expected {
+		t.Errorf("Expected the storage usage %v, got %v", expected, *usage)
+	}
+}
@@ -0,0 +1,1408 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package stortest provides test fixtures and a conformance test suite for implementations
+// of stor.Store, so that every storage backend proves it behaves like the reference one.
+//
+// A backend runs the suite from one of its tests:
+//
+//	func TestConformance(t *testing.T) {
+//		stortest.Run(t, func(t *testing.T) stor.Store { return newEmptyStore(t) })
+//	}
+package stortest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Factory returns an empty store; it is called once per test of the suite.
+type Factory func(t *testing.T) stor.Store
+
+// Run runs the conformance suite against the stores returned by newStore.
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, st stor.Store)
+	}{
+		{"Check", testCheck},
+		{"Publications", testPublications},
+		{"PublicationSearch", testPublicationSearch},
+		{"PublicationChanges", testPublicationChanges},
+		{"Sorting", testSorting},
+		{"Restore", testRestore},
+		{"ProviderScope", testProviderScope},
+		{"Resources", testResources},
+		{"Licenses", testLicenses},
+		{"LicenseBatch", testLicenseBatch},
+		{"LicenseSearch", testLicenseSearch},
+		{"LicenseArchive", testLicenseArchive},
+		{"Events", testEvents},
+		{"Devices", testDevices},
+		{"Propagations", testPropagations},
+		{"Actions", testActions},
+		{"Audit", testAudit},
+		{"Statistics", testStatistics},
+		{"Organizations", testOrganizations},
+		{"MediaTypes", testMediaTypes},
+		{"Providers", testProviders},
+		{"Collections", testCollections},
+		{"Coupons", testCoupons},
+		{"Gifts", testGifts},
+		{"LicenseCache", testLicenseCache},
+		{"Usage", testUsage},
+		{"Concurrency", testConcurrency},
+		{"Context", testContext},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+func testCheck(t *testing.T, st stor.Store) {
+	if err := st.Check(); err != nil {
+		t.Errorf("Failed to check an empty store: %v", err)
+	}
+	if err := st.Ping(); err != nil {
+		t.Errorf("Failed to ping the store: %v", err)
+	}
+	if err := st.Migrated(); err != nil {
+		t.Errorf("Expected no pending migration, got %v", err)
+	}
+}
+
+func testPublications(t *testing.T, st stor.Store) {
+
+	pub := NewPublication("application/epub+zip")
+	if err := st.Publication().Create(pub); err != nil {
+		t.Fatalf("Failed to create a publication: %v", err)
+	}
+	got, err := st.Publication().Get(pub.UUID)
+	if err != nil {
+		t.Fatalf("Failed to get a publication: %v", err)
+	}
+	if got.Title != pub.Title || got.Location != pub.Location || got.Checksum != pub.Checksum || string(got.EncryptionKey) != string(pub.EncryptionKey) {
+		t.Errorf("Expected %+v, got %+v", pub, got)
+	}
+	if _, err = st.Publication().Get("unknown"); err == nil {
+		t.Error("Expected an error for an unknown publication")
+	}
+
+	// uuids are unique
+	dup := NewPublication("application/epub+zip")
+	dup.UUID = pub.UUID
+	if err = st.Publication().Create(dup); !errors.Is(err, stor.ErrDuplicate) {
+		t.Errorf("Expected a duplicate error, got %v", err)
+	}
+
+	// update
+	got.Title = "Updated title"
+	if err = st.Publication().Update(got); err != nil {
+		t.Fatalf("Failed to update a publication: %v", err)
+	}
+	if got, _ = st.Publication().Get(pub.UUID); got.Title != "Updated title" {
+		t.Errorf("Expected the updated title, got %q", got.Title)
+	}
+
+	// pagination, in creation order
+	pubs := append([]*stor.Publication{pub}, CreatePublications(t, st, 6, "application/pdf+lcp")...)
+	if count, err := st.Publication().Count(); err != nil || count != 7 {
+		t.Errorf("Expected 7 publications, got %d, %v", count, err)
+	}
+	list, err := st.Publication().List(3, 2)
+	if err != nil {
+		t.Fatalf("Failed to list publications: %v", err)
+	}
+	if len(*list) != 3 || (*list)[0].UUID != pubs[3].UUID || (*list)[2].UUID != pubs[5].UUID {
+		t.Errorf("Unexpected second page %v", uuids(*list))
+	}
+	if list, _ = st.Publication().List(3, 3); len(*list) != 1 {
+		t.Errorf("Expected 1 publication on the last page, got %d", len(*list))
+	}
+	if list, _ = st.Publication().ListAll(); len(*list) != 7 {
+		t.Errorf("Expected 7 publications, got %d", len(*list))
+	}
+
+	// deleted publications are neither listed nor counted
+	if err = st.Publication().Delete(got); err != nil {
+		t.Fatalf("Failed to delete a publication: %v", err)
+	}
+	if _, err = st.Publication().Get(pub.UUID); err == nil {
+		t.Error("Expected an error for a deleted publication")
+	}
+	if count, _ := st.Publication().Count(); count != 6 {
+		t.Errorf("Expected 6 publications after a deletion, got %d", count)
+	}
+	if list, _ = st.Publication().ListAll(); len(*list) != 6 {
+		t.Errorf("Expected 6 publications after a deletion, got %d", len(*list))
+	}
+
+	// the sample of a publication
+	sample := NewPublication("application/epub+zip")
+	sample.SampleOf = pubs[1].UUID
+	if err = st.Publication().Create(sample); err != nil {
+		t.Fatalf("Failed to create a sample: %v", err)
+	}
+	if got, err = st.Publication().GetSample(pubs[1].UUID); err != nil || got.UUID != sample.UUID {
+		t.Errorf("Failed to get the sample of a publication: %v", err)
+	}
+	if _, err = st.Publication().GetSample(pubs[2].UUID); err == nil {
+		t.Error("Expected an error for a publication without sample")
+	}
+}
+
+func testPublicationSearch(t *testing.T, st stor.Store) {
+
+	CreatePublications(t, st, 2, "application/epub+zip")
+	CreatePublications(t, st, 3, "application/pdf+lcp")
+	list, err := st.Publication().FindByType("application/pdf+lcp")
+	if err != nil {
+		t.Fatalf("Failed to search publications: %v", err)
+	}
+	if len(*list) != 3 {
+		t.Errorf("Expected 3 publications, got %d", len(*list))
+	}
+	if list, _ = st.Publication().FindByType("application/unknown"); len(*list) != 0 {
+		t.Errorf("Expected no publication, got %d", len(*list))
+	}
+
+	// text search, case-insensitive, combined with the content type
+	pub := NewPublication("application/pdf+lcp")
+	pub.Title, pub.Author = "Conformance Handbook", "Jane Doe"
+	if err = st.Publication().Create(pub); err != nil {
+		t.Fatalf("Failed to create a publication: %v", err)
+	}
+	for _, q := range []stor.PublicationQuery{
+		{Text: "handbook"},
+		{Text: "DOE"},
+		{Text: pub.UUID},
+		{Text: "doe", ContentType: "application/pdf+lcp"},
+	} {
+		if list, err = st.Publication().Find(q); err != nil || len(*list) != 1 || (*list)[0].UUID != pub.UUID {
+			t.Errorf("Expected the publication %s for %+v, got %v, %v", pub.UUID, q, list, err)
+		}
+	}
+	if list, _ = st.Publication().Find(stor.PublicationQuery{Text: "doe", ContentType: "application/epub+zip"}); len(*list) != 0 {
+		t.Errorf("Expected no publication, got %d", len(*list))
+	}
+
+	// limited results, and streamed ones
+	if list, _ = st.Publication().Find(stor.PublicationQuery{ContentType: "application/pdf+lcp", Limit: 2}); len(*list) != 2 {
+		t.Errorf("Expected 2 publications, got %d", len(*list))
+	}
+	streamed := 0
+	err = st.Publication().Stream(stor.PublicationQuery{ContentType: "application/pdf+lcp"}, func(*stor.Publication) error {
+		streamed++
+		return nil
+	})
+	if err != nil || streamed != 4 {
+		t.Errorf("Expected 4 streamed publications, got %d: %v", streamed, err)
+	}
+
+	// by source
+	pub.Source = "publisher"
+	if err = st.Publication().Update(pub); err != nil {
+		t.Fatalf("Failed to update a publication: %v", err)
+	}
+	if uuids, err := st.Publication().ListSourceUUIDs("publisher"); err != nil || len(uuids) != 1 || uuids[0] != pub.UUID {
+		t.Errorf("Expected the publication %s, got %v, %v", pub.UUID, uuids, err)
+	}
+}
+
+// testPublicationChanges checks that changes, deletions included, are listed in order and can be paged.
+func testPublicationChanges(t *testing.T, st stor.Store) {
+
+	pubs := CreatePublications(t, st, 3, "application/epub+zip")
+	time.Sleep(10 * time.Millisecond)
+	pubs[0].Title = "Updated title"
+	if err := st.Publication().Update(pubs[0]); err != nil {
+		t.Fatalf("Failed to update a publication: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := st.Publication().Delete(pubs[1]); err != nil {
+		t.Fatalf("Failed to delete a publication: %v", err)
+	}
+
+	list, err := st.Publication().ListChanges(time.Time{}, 0, 10)
+	if err != nil {
+		t.Fatalf("Failed to list changes: %v", err)
+	}
+	want := []string{pubs[2].UUID, pubs[0].UUID, pubs[1].UUID}
+	if got := uuids(*list); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("Expected changes %v, got %v", want, got)
+	}
+	if deleted := (*list)[2]; !deleted.DeletedAt.Valid || !deleted.ChangedAt().Equal(deleted.DeletedAt.Time) {
+		t.Errorf("Expected the last change to be a deletion, got %+v", deleted)
+	}
+
+	// the changes following a change, in pages
+	first, err := st.Publication().ListChanges(time.Time{}, 0, 1)
+	if err != nil || len(*first) != 1 {
+		t.Fatalf("Expected 1 change, got %v", err)
+	}
+	next, err := st.Publication().ListChanges((*first)[0].ChangedAt(), (*first)[0].ID, 10)
+	if err != nil {
+		t.Fatalf("Failed to list changes: %v", err)
+	}
+	if got := uuids(*next); fmt.Sprint(got) != fmt.Sprint(want[1:]) {
+		t.Errorf("Expected changes %v, got %v", want[1:], got)
+	}
+	last := (*next)[len(*next)-1]
+	if next, _ = st.Publication().ListChanges(last.ChangedAt(), last.ID, 10); len(*next) != 0 {
+		t.Errorf("Expected no more changes, got %v", uuids(*next))
+	}
+}
+
+// testSorting checks that listings follow the requested order, and that only sortable fields are accepted.
+func testSorting(t *testing.T, st stor.Store) {
+
+	for i, title := range []string{"b", "a", "c"} {
+		pub := NewPublication("application/epub+zip")
+		pub.Title = title
+		pub.Size = int64(i)
+		if err := st.Publication().Create(pub); err != nil {
+			t.Fatalf("Failed to create a publication: %v", err)
+		}
+	}
+	titles := func(sort string) string {
+		order, err := stor.PublicationOrder(sort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo := st.Publication().Sorted(order)
+		// a sorted repository is reusable
+		if count, err := repo.Count(); err != nil || count != 3 {
+			t.Errorf("Expected 3 publications, got %d, %v", count, err)
+		}
+		list, err := repo.List(10, 1)
+		if err != nil {
+			t.Fatalf("Failed to list sorted publications: %v", err)
+		}
+		var s string
+		for _, p := range *list {
+			s += p.Title
+		}
+		return s
+	}
+	for sort, expected := range map[string]string{"": "bac", "title": "abc", "-title": "cba", "-size": "cab"} {
+		if got := titles(sort); got != expected {
+			t.Errorf("Expected the order %s when sorted by %q, got %s", expected, sort, got)
+		}
+	}
+
+	pub := CreatePublications(t, st, 1, "application/epub+zip")[0]
+	licenses := CreateLicenses(t, st, 3, pub.UUID, "user1")
+	for i, l := range licenses {
+		end := l.End.AddDate(0, 0, -i)
+		l.End = &end
+		if err := st.License().Update(l); err != nil {
+			t.Fatalf("Failed to update a license: %v", err)
+		}
+	}
+	order, _ := stor.LicenseOrder("end")
+	list, err := st.License().Sorted(order).FindByUser("user1")
+	if err != nil {
+		t.Fatalf("Failed to search sorted licenses: %v", err)
+	}
+	if len(*list) != 3 || (*list)[0].UUID != licenses[2].UUID || (*list)[2].UUID != licenses[0].UUID {
+		t.Error("Expected the licenses sorted by end date")
+	}
+
+	for _, sort := range []string{"id; DROP TABLE publications", "encryption_key", "-"} {
+		if _, err := stor.PublicationOrder(sort); err == nil {
+			t.Errorf("Expected an error when sorting by %q", sort)
+		}
+	}
+}
+
+// testResources checks that the replacement of the resources of a publication is atomic.
+// testRestore checks that deleted publications and licenses can be listed and restored.
+func testRestore(t *testing.T, st stor.Store) {
+
+	pubs := CreatePublications(t, st, 2, "application/epub+zip")
+	licenses := CreateLicenses(t, st, 2, pubs[0].UUID, "user1")
+	if err := st.Publication().Delete(pubs[1]); err != nil {
+		t.Fatalf("Failed to delete a publication: %v", err)
+	}
+	if err := st.License().Delete(licenses[1]); err != nil {
+		t.Fatalf("Failed to delete a license: %v", err)
+	}
+
+	// deleted entities are only listed and counted on request
+	if count, _ := st.Publication().WithDeleted().Count(); count != 2 {
+		t.Errorf("Expected 2 publications including the deleted one, got %d", count)
+	}
+	if list, _ := st.Publication().WithDeleted().List(10, 1); len(*list) != 2 || !(*list)[1].DeletedAt.Valid {
+		t.Errorf("Expected the deleted publication to be listed, got %v", uuids(*list))
+	}
+	if list, _ := st.License().WithDeleted().List(10, 1); len(*list) != 2 {
+		t.Errorf("Expected 2 licenses including the deleted one, got %d", len(*list))
+	}
+	if count, _ := st.License().Count(); count != 1 {
+		t.Errorf("Expected 1 license, got %d", count)
+	}
+
+	// restore
+	pub, err := st.Publication().Restore(pubs[1].UUID)
+	if err != nil || pub.DeletedAt.Valid {
+		t.Fatalf("Failed to restore a publication: %+v, %v", pub, err)
+	}
+	if _, err = st.Publication().Get(pubs[1].UUID); err != nil {
+		t.Errorf("Expected the restored publication, got %v", err)
+	}
+	license, err := st.License().Restore(licenses[1].UUID)
+	if err != nil || license.DeletedAt.Valid {
+		t.Fatalf("Failed to restore a license: %+v, %v", license, err)
+	}
+	if count, _ := st.License().Count(); count != 2 {
+		t.Errorf("Expected 2 licenses after a restoration, got %d", count)
+	}
+
+	// restoring an active entity has no effect, an unknown one is an error
+	if pub, err = st.Publication().Restore(pubs[0].UUID); err != nil || pub.UUID != pubs[0].UUID {
+		t.Errorf("Expected the active publication, got %+v, %v", pub, err)
+	}
+	if _, err = st.Publication().Restore("unknown"); err == nil {
+		t.Error("Expected an error for an unknown publication")
+	}
+	if _, err = st.License().Restore("unknown"); err == nil {
+		t.Error("Expected an error for an unknown license")
+	}
+}
+
+func testProviderScope(t *testing.T, st stor.Store) {
+
+	// publications and licenses created by the stores of two providers, and by the unrestricted store
+	a, b := st.ForProvider("https://a.example.com"), st.ForProvider("https://b.example.com")
+	pubsA := CreatePublications(t, a, 2, "application/epub+zip")
+	pubsB := CreatePublications(t, b, 1, "application/epub+zip")
+	shared := CreatePublications(t, st, 1, "application/pdf")
+	licA := CreateLicenses(t, a, 2, pubsA[0].UUID, "user1")
+	licB := CreateLicenses(t, b, 1, pubsB[0].UUID, "user1")
+	if pubsA[0].Provider != "https://a.example.com" || licA[0].Provider != "https://a.example.com" || shared[0].Provider != "" {
+		t.Errorf("Expected the provider of the store, got %q, %q, %q", pubsA[0].Provider, licA[0].Provider, shared[0].Provider)
+	}
+
+	// a provider only lists, counts and finds its own publications and licenses
+	if list, _ := a.Publication().List(10, 1); len(*list) != 2 {
+		t.Errorf("Expected the 2 publications of the provider, got %v", uuids(*list))
+	}
+	if list, _ := b.Publication().Sorted(stor.Order{}).WithDeleted().ListAll(); len(*list) != 1 {
+		t.Errorf("Expected the publication of the provider, got %v", uuids(*list))
+	}
+	if count, _ := st.Publication().Count(); count != 4 {
+		t.Errorf("Expected 4 publications, got %d", count)
+	}
+	if _, err := a.Publication().Get(pubsB[0].UUID); err == nil {
+		t.Error("Expected the publication of another provider not to be found")
+	}
+	if _, err := b.Publication().Get(shared[0].UUID); err == nil {
+		t.Error("Expected a publication without provider not to be found")
+	}
+	if list, _ := b.License().FindByUser("user1"); len(*list) != 1 || (*list)[0].UUID != licB[0].UUID {
+		t.Errorf("Expected the license of the provider, got %d", len(*list))
+	}
+	if count, _ := a.License().CountByPublication(pubsB[0].UUID); count != 0 {
+		t.Errorf("Expected no license of another provider, got %d", count)
+	}
+	if _, err := b.WithContext(context.Background()).License().Get(licA[0].UUID); err == nil {
+		t.Error("Expected the license of another provider not to be found")
+	}
+	if providers, _ := a.License().ListProviders(); len(providers) != 1 {
+		t.Errorf("Expected the provider of the store, got %v", providers)
+	}
+	if err := a.Publication().SetResources(pubsA[1].UUID, []stor.Resource{{Position: 1, Href: "track1.mp3", ContentType: "audio/mpeg", Checksum: "YWJj"}}); err != nil {
+		t.Fatalf("Failed to set the resources of a publication: %v", err)
+	}
+	if list, _ := a.Publication().ListResources(pubsA[1].UUID); len(*list) != 1 {
+		t.Errorf("Expected the resource of the provider, got %d", len(*list))
+	}
+	if _, err := b.Publication().GetResource(pubsA[1].UUID, 1); err == nil {
+		t.Error("Expected the resource of another provider not to be found")
+	}
+
+	// an update keeps the provider
+	licB[0].Provider = "https://a.example.com"
+	if err := b.License().Update(licB[0]); err != nil || licB[0].Provider != "https://b.example.com" {
+		t.Errorf("Expected the provider to be kept, got %q, %v", licB[0].Provider, err)
+	}
+	if _, err := a.License().Get(licB[0].UUID); err == nil {
+		t.Error("Expected the license to stay with its provider")
+	}
+}
+
+func testResources(t *testing.T, st stor.Store) {
+
+	pub := CreatePublications(t, st, 1, "application/audiobook+lcp")[0]
+	resources := []stor.Resource{
+		{Position: 2, Href: "track2.mp3", ContentType: "audio/mpeg", Checksum: "YWJj"},
+		{Position: 1, Href: "track1.mp3", ContentType: "audio/mpeg", Checksum: "ZGVm"},
+	}
+	if err := st.Publication().SetResources(pub.UUID, resources); err != nil {
+		t.Fatalf("Failed to set resources: %v", err)
+	}
+	list, err := st.Publication().ListResources(pub.UUID)
+	if err != nil {
+		t.Fatalf("Failed to list resources: %v", err)
+	}
+	if len(*list) != 2 || (*list)[0].Href != "track1.mp3" {
+		t.Errorf("Expected the resources sorted by position, got %+v", *list)
+	}
+	if r, err := st.Publication().GetResource(pub.UUID, 2); err != nil || r.Href != "track2.mp3" {
+		t.Errorf("Failed to get a resource by position: %+v, %v", r, err)
+	}
+
+	// a failed replacement leaves the resources unchanged
+	invalid := []stor.Resource{
+		{Position: 1, Href: "a.mp3", ContentType: "audio/mpeg", Checksum: "YWJj"},
+		{Position: 1, Href: "b.mp3", ContentType: "audio/mpeg", Checksum: "YWJj"},
+	}
+	if err = st.Publication().SetResources(pub.UUID, invalid); err == nil {
+		t.Error("Expected an error for duplicate positions")
+	}
+	if list, _ = st.Publication().ListResources(pub.UUID); len(*list) != 2 || (*list)[0].Href != "track1.mp3" {
+		t.Errorf("Expected the previous resources after a failed replacement, got %+v", *list)
+	}
+
+	// an empty list removes the resources
+	if err = st.Publication().SetResources(pub.UUID, nil); err != nil {
+		t.Fatalf("Failed to remove resources: %v", err)
+	}
+	if list, _ = st.Publication().ListResources(pub.UUID); len(*list) != 0 {
+		t.Errorf("Expected no resource, got %d", len(*list))
+	}
+}
+
+func testLicenses(t *testing.T, st stor.Store) {
+
+	pub := CreatePublications(t, st, 1, "application/epub+zip")[0]
+	license := NewLicense(pub.UUID, "user1")
+	if err := st.License().Create(license); err != nil {
+		t.Fatalf("Failed to create a license: %v", err)
+	}
+	got, err := st.License().Get(license.UUID)
+	if err != nil {
+		t.Fatalf("Failed to get a license: %v", err)
+	}
+	if got.UserID != "user1" || got.PublicationID != pub.UUID || !got.End.Equal(*license.End) {
+		t.Errorf("Expected %+v, got %+v", license, got)
+	}
+
+	// licenses reference an existing publication
+	if err = st.License().Create(NewLicense("unknown", "user1")); err == nil {
+		t.Error("Expected an error for an unknown publication")
+	}
+	dup := NewLicense(pub.UUID, "user1")
+	dup.UUID = license.UUID
+	if err = st.License().Create(dup); !errors.Is(err, stor.ErrDuplicate) {
+		t.Errorf("Expected a duplicate error, got %v", err)
+	}
+
+	// update
+	now := time.Now().Truncate(time.Second)
+	got.Status = stor.STATUS_REVOKED
+	got.StatusUpdated = &now
+	if err = st.License().Update(got); err != nil {
+		t.Fatalf("Failed to update a license: %v", err)
+	}
+	if got, _ = st.License().Get(license.UUID); got.Status != stor.STATUS_REVOKED || got.StatusUpdated == nil || !got.StatusUpdated.Equal(now) {
+		t.Errorf("Expected the updated status, got %+v", got)
+	}
+
+	// pagination, in creation order
+	licenses := append([]*stor.LicenseInfo{license}, CreateLicenses(t, st, 4, pub.UUID, "user2")...)
+	list, err := st.License().List(2, 2)
+	if err != nil {
+		t.Fatalf("Failed to list licenses: %v", err)
+	}
+	if len(*list) != 2 || (*list)[0].UUID != licenses[2].UUID {
+		t.Errorf("Unexpected second page of licenses")
+	}
+	if count, _ := st.License().Count(); count != 5 {
+		t.Errorf("Expected 5 licenses, got %d", count)
+	}
+
+	// deletion
+	if err = st.License().Delete(got); err != nil {
+		t.Fatalf("Failed to delete a license: %v", err)
+	}
+	if _, err = st.License().Get(license.UUID); err == nil {
+		t.Error("Expected an error for a deleted license")
+	}
+	if list, _ = st.License().ListAll(); len(*list) != 4 {
+		t.Errorf("Expected 4 licenses after a deletion, got %d", len(*list))
+	}
+}
+
+// testLicenseArchive checks that the licenses terminated long ago are moved to the archive, and still found by id.
+func testLicenseArchive(t *testing.T, st stor.Store) {
+
+	pub := CreatePublications(t, st, 1, "application/epub+zip")[0]
+	licenses := CreateLicenses(t, st, 4, pub.UUID, "user1")
+	longAgo := time.Now().AddDate(-2, 0, 0).Truncate(time.Second)
+	recently := time.Now().Truncate(time.Second)
+	// returned long ago, expired long ago, revoked recently, still active
+	licenses[0].Status, licenses[0].StatusUpdated = stor.STATUS_RETURNED, &longAgo
+	licenses[1].End = &longAgo
+	licenses[2].Status, licenses[2].StatusUpdated = stor.STATUS_REVOKED, &recently
+	licenses[3].Status = stor.STATUS_ACTIVE
+	for _, l := range licenses {
+		if err := st.License().Update(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.Device().Register(&stor.Device{LicenseID: licenses[0].UUID, DeviceID: "d1", Name: "device 1", Registered: longAgo}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Event().Create(&stor.Event{Timestamp: longAgo, Type: stor.EVENT_RETURN, DeviceID: "d1", LicenseID: licenses[0].UUID}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the licenses are archived by batches
+	before := time.Now().AddDate(-1, 0, 0)
+	for i, expected := range []int64{1, 1, 0} {
+		if n, err := st.License().Archive(before, 1); err != nil || n != expected {
+			t.Fatalf("Expected %d licenses archived by the batch %d, got %d, %v", expected, i, n, err)
+		}
+	}
+	if count, _ := st.License().Count(); count != 2 {
+		t.Errorf("Expected 2 licenses left, got %d", count)
+	}
+
+	// an archived license is found by id, with its events and devices
+	got, err := st.License().Get(licenses[0].UUID)
+	if err != nil || !got.Archived || got.Status != stor.STATUS_RETURNED || got.UserID != "user1" || got.DeviceCount != 1 {
+		t.Fatalf("Expected the archived license, got %+v, %v", got, err)
+	}
+	if events, err := st.Event().List(licenses[0].UUID); err != nil || len(*events) != 1 {
+		t.Errorf("Expected the event of the archived license, got %v", err)
+	}
+	if devices, err := st.Device().List(licenses[0].UUID); err != nil || len(*devices) != 1 {
+		t.Errorf("Expected the device of the archived license, got %v", err)
+	}
+	if got, err = st.License().Get(licenses[2].UUID); err != nil || got.Archived {
+		t.Errorf("Expected a recently revoked license not to be archived, got %+v, %v", got, err)
+	}
+
+	// an archived license is updated and deleted in the archive
+	got, _ = st.License().Get(licenses[1].UUID)
+	got.Status = stor.STATUS_REVOKED
+	if err = st.License().Update(got); err != nil {
+		t.Fatalf("Failed to update an archived license: %v", err)
+	}
+	if got, _ = st.License().Get(licenses[1].UUID); !got.Archived || got.Status != stor.STATUS_REVOKED {
+		t.Errorf("Expected the updated archived license, got %+v", got)
+	}
+	if count, _ := st.License().Count(); count != 2 {
+		t.Errorf("Expected the archived license not to be restored, got %d licenses", count)
+	}
+	if err = st.License().Delete(got); err != nil {
+		t.Fatalf("Failed to delete an archived license: %v", err)
+	}
+	if _, err = st.License().Get(licenses[1].UUID); err == nil {
+		t.Error("Expected an error for a deleted archived license")
+	}
+}
+
+// testLicenseBatch checks that the licenses of a batch are created or rejected independently.
+func testLicenseBatch(t *testing.T, st stor.Store) {
+
+	pub := CreatePublications(t, st, 1, "application/epub+zip")[0]
+	first := NewLicense(pub.UUID, "user1")
+	dup := NewLicense(pub.UUID, "user1")
+	dup.UUID = first.UUID
+	batch := []*stor.LicenseInfo{first, NewLicense("unknown", "user1"), dup, NewLicense(pub.UUID, "user2")}
+	errs, err := st.License().CreateAll(batch)
+	if err != nil {
+		t.Fatalf("Failed to create a batch of licenses: %v", err)
+	}
+	if len(errs) != len(batch) || errs[0] != nil || errs[1] == nil || !errors.Is(errs[2], stor.ErrDuplicate) || errs[3] != nil {
+		t.Errorf("Unexpected errors %v", errs)
+	}
+	if count, _ := st.License().Count(); count != 2 {
+		t.Errorf("Expected 2 licenses, got %d", count)
+	}
+	if got, err := st.License().Get(batch[3].UUID); err != nil || got.UserID != "user2" {
+		t.Errorf("Expected the last license of the batch, got %v, %v", got, err)
+	}
+}
+
+func testLicenseSearch(t *testing.T, st stor.Store) {
+
+	pubs := CreatePublications(t, st, 2, "application/epub+zip")
+	CreateLicenses(t, st, 2, pubs[0].UUID, "Morpheus")
+	licenses := CreateLicenses(t, st, 3, pubs[1].UUID, "Trinity")
+	bundleID := uuid.New().String()
+	for i, l := range licenses {
+		l.DeviceCount = i + 1
+		l.Status = stor.STATUS_ACTIVE
+		if i < 2 {
+			l.BundleID = bundleID
+		}
+		if err := st.License().Update(l); err != nil {
+			t.Fatalf("Failed to update a license: %v", err)
+		}
+	}
+
+	for _, c := range []struct {
+		name     string
+		find     func() (*[]stor.LicenseInfo, error)
+		expected int
+	}{
+		{"user", func() (*[]stor.LicenseInfo, error) { return st.License().FindByUser("Morpheus") }, 2},
+		{"unknown user", func() (*[]stor.LicenseInfo, error) { return st.License().FindByUser("Neo") }, 0},
+		{"publication", func() (*[]stor.LicenseInfo, error) { return st.License().FindByPublication(pubs[1].UUID) }, 3},
+		{"status", func() (*[]stor.LicenseInfo, error) { return st.License().FindByStatus(stor.STATUS_ACTIVE) }, 3},
+		{"device count", func() (*[]stor.LicenseInfo, error) { return st.License().FindByDeviceCount(2, 3) }, 2},
+		{"publication and user", func() (*[]stor.LicenseInfo, error) {
+			return st.License().Find(stor.LicenseQuery{PublicationID: pubs[1].UUID, UserID: "Morpheus"})
+		}, 0},
+		{"user, status and device count", func() (*[]stor.LicenseInfo, error) {
+			return st.License().Find(stor.LicenseQuery{UserID: "Trinity", Status: stor.STATUS_ACTIVE, DeviceCount: &stor.Range{Min: 1, Max: 2}})
+		}, 2},
+		{"bundle", func() (*[]stor.LicenseInfo, error) { return st.License().Find(stor.LicenseQuery{BundleID: bundleID}) }, 2},
+		{"no criteria", func() (*[]stor.LicenseInfo, error) { return st.License().Find(stor.LicenseQuery{}) }, 5},
+		{"limit", func() (*[]stor.LicenseInfo, error) {
+			return st.License().Find(stor.LicenseQuery{UserID: "Trinity", Limit: 2})
+		}, 2},
+	} {
+		list, err := c.find()
+		if err != nil {
+			t.Errorf("Failed to search licenses by %s: %v", c.name, err)
+		} else if len(*list) != c.expected {
+			t.Errorf("Expected %d licenses by %s, got %d", c.expected, c.name, len(*list))
+		}
+	}
+
+	// the licenses are streamed without limit, in the order of creation whatever the order of the repository
+	order, _ := stor.LicenseOrder("-device_count")
+	var streamed []string
+	err := st.License().Sorted(order).Stream(stor.LicenseQuery{PublicationID: pubs[1].UUID}, func(l *stor.LicenseInfo) error {
+		streamed = append(streamed, l.UUID)
+		return nil
+	})
+	if err != nil || len(streamed) != 3 || streamed[0] != licenses[0].UUID || streamed[2] != licenses[2].UUID {
+		t.Errorf("Unexpected stream %v: %v", streamed, err)
+	}
+	stop := errors.New("stop")
+	if err = st.License().Stream(stor.LicenseQuery{}, func(*stor.LicenseInfo) error { return stop }); err != stop {
+		t.Errorf("Expected the error of the function, got %v", err)
+	}
+}
+
+func testEvents(t *testing.T, st stor.Store) {
+
+	pub := CreatePublications(t, st, 1, "application/epub+zip")[0]
+	licenses := CreateLicenses(t, st, 2, pub.UUID, "user1")
+	now := time.Now().Truncate(time.Second)
+	events := []*stor.Event{
+		{Timestamp: now, Type: stor.EVENT_REGISTER, DeviceID: "d1", DeviceName: "device 1", LicenseID: licenses[0].UUID},
+		{Timestamp: now, Type: stor.EVENT_REGISTER, DeviceID: "d2", DeviceName: "device 2", LicenseID: licenses[0].UUID},
+		{Timestamp: now, Type: stor.EVENT_REGISTER, DeviceID: "d1", DeviceName: "device 1", LicenseID: licenses[1].UUID},
+	}
+	for _, e := range events {
+		if err := st.Event().Create(e); err != nil {
+			t.Fatalf("Failed to create an event: %v", err)
+		}
+	}
+
+	// events are listed and counted per license
+	list, err := st.Event().List(licenses[0].UUID)
+	if err != nil {
+		t.Fatalf("Failed to list events: %v", err)
+	}
+	if len(*list) != 2 || (*list)[0].DeviceID != "d1" {
+		t.Errorf("Expected the 2 events of the license, got %+v", *list)
+	}
+	if count, err := st.Event().Count(licenses[1].UUID); err != nil || count != 1 {
+		t.Errorf("Expected 1 event, got %d, %v", count, err)
+	}
+	e, err := st.Event().GetByDevice(licenses[0].UUID, "d2")
+	if err != nil || e.ID != events[1].ID {
+		t.Errorf("Expected the event of the device, got %+v, %v", e, err)
+	}
+	if _, err = st.Event().GetByDevice(licenses[1].UUID, "d2"); err == nil {
+		t.Error("Expected an error for a device unknown to the license")
+	}
+
+	// update and deletion
+	events[0].Type = stor.EVENT_RETURN
+	if err = st.Event().Update(events[0]); err != nil {
+		t.Fatalf("Failed to update an event: %v", err)
+	}
+	if e, _ = st.Event().Get(events[0].ID); e.Type != stor.EVENT_RETURN {
+		t.Errorf("Expected the updated type, got %s", e.Type)
+	}
+	if err = st.Event().Delete(events[0]); err != nil {
+		t.Fatalf("Failed to delete an event: %v", err)
+	}
+	if count, _ := st.Event().Count(licenses[0].UUID); count != 1 {
+		t.Errorf("Expected 1 event after a deletion, got %d", count)
+	}
+}
+
+// testDevices checks that a device is registered once per license, and counted by the license.
+func testDevices(t *testing.T, st stor.Store) {
+
+	pub := CreatePublications(t, st, 1, "application/epub+zip")[0]
+	license := CreateLicenses(t, st, 1, pub.UUID, "user1")[0]
+	now := time.Now().Truncate(time.Second)
+
+	// a device is registered once per license, and counted by the license
+	for _, id := range []string{"d1", "d2"} {
+		if err := st.Device().Register(&stor.Device{LicenseID: license.UUID, DeviceID: id, Name: "device " + id, Registered: now}); err != nil {
+			t.Fatalf("Failed to register a device: %v", err)
+		}
+	}
+	err := st.Device().Register(&stor.Device{LicenseID: license.UUID, DeviceID: "d1", Name: "device d1", Registered: now})
+	if !errors.Is(err, stor.ErrDuplicate) {
+		t.Errorf("Expected a duplicate device, got %v", err)
+	}
+	l, err := st.License().Get(license.UUID)
+	if err != nil || l.DeviceCount != license.DeviceCount+2 {
+		t.Errorf("Expected %d devices, got %+v, %v", license.DeviceCount+2, l, err)
+	}
+
+	// devices are listed in the order of registration
+	devices, err := st.Device().List(license.UUID)
+	if err != nil || len(*devices) != 2 || (*devices)[0].DeviceID != "d1" || !(*devices)[1].Registered.Equal(now) {
+		t.Errorf("Expected the 2 devices of the license, got %+v, %v", devices, err)
+	}
+	if d, err := st.Device().Get(license.UUID, "d2"); err != nil || d.Name != "device d2" {
+		t.Errorf("Expected the device d2, got %+v, %v", d, err)
+	}
+	if _, err = st.Device().Get(license.UUID, "d3"); err == nil {
+		t.Error("Expected an error for a device unknown to the license")
+	}
+}
+
+// testPropagations checks that a revoking license is revoked once every channel confirmed the revocation.
+func testPropagations(t *testing.T, st stor.Store) {
+
+	pub := CreatePublications(t, st, 1, "application/epub+zip")[0]
+	license := CreateLicenses(t, st, 1, pub.UUID, "user1")[0]
+	license.Status = stor.STATUS_REVOKING
+	if err := st.License().Update(license); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Propagation().Start(license.UUID, []string{"crl", "provider"}); err != nil {
+		t.Fatalf("Failed to start a propagation: %v", err)
+	}
+	if err := st.Propagation().Start(license.UUID, []string{"crl"}); !errors.Is(err, stor.ErrDuplicate) {
+		t.Errorf("Expected a duplicate propagation, got %v", err)
+	}
+
+	pending, err := st.Propagation().Pending(10)
+	if err != nil || len(*pending) != 2 {
+		t.Fatalf("Expected 2 pending propagations, got %v, %v", pending, err)
+	}
+	if err = st.Propagation().Fail(&(*pending)[0], errors.New("unavailable")); err != nil {
+		t.Errorf("Failed to record a failure: %v", err)
+	}
+	now := time.Now().Truncate(time.Second)
+	done, err := st.Propagation().Confirm(&(*pending)[1], now)
+	if err != nil || done {
+		t.Errorf("Expected a pending revocation, got %v, %v", done, err)
+	}
+	if l, _ := st.License().Get(license.UUID); l.Status != stor.STATUS_REVOKING {
+		t.Errorf("Expected a revoking license, got %s", l.Status)
+	}
+
+	// the last confirmation revokes the license
+	done, err = st.Propagation().Confirm(&(*pending)[0], now)
+	if err != nil || !done {
+		t.Errorf("Expected a complete revocation, got %v, %v", done, err)
+	}
+	if l, _ := st.License().Get(license.UUID); l.Status != stor.STATUS_REVOKED || l.StatusUpdated == nil || !l.StatusUpdated.Equal(now) {
+		t.Errorf("Expected a license revoked on %v, got %+v", now, l)
+	}
+	propagations, err := st.Propagation().List(license.UUID)
+	if err != nil || len(*propagations) != 2 || (*propagations)[0].Channel != "crl" || (*propagations)[0].Attempts != 2 || (*propagations)[0].LastError != "" {
+		t.Errorf("Unexpected propagations %+v, %v", propagations, err)
+	}
+	if pending, _ = st.Propagation().Pending(10); len(*pending) != 0 {
+		t.Errorf("Expected no pending propagation, got %+v", *pending)
+	}
+}
+
+// testActions checks that the actions scheduled on a license are due at their time, and cancellable until executed.
+func testActions(t *testing.T, st stor.Store) {
+
+	pub := CreatePublications(t, st, 1, "application/epub+zip")[0]
+	license := CreateLicenses(t, st, 1, pub.UUID, "user1")[0]
+	now := time.Now().Truncate(time.Second)
+	actions := []stor.Action{
+		{LicenseID: license.UUID, Type: stor.ACTION_RENEW, At: now.Add(time.Hour)},
+		{LicenseID: license.UUID, Type: stor.ACTION_REVOKE, At: now.Add(2 * time.Hour)},
+		{LicenseID: license.UUID, Type: stor.ACTION_REVOKE, At: now.Add(-time.Minute)},
+	}
+	for i := range actions {
+		if err := st.Action().Create(&actions[i]); err != nil {
+			t.Fatalf("Failed to schedule an action: %v", err)
+		}
+	}
+	list, err := st.Action().List(license.UUID)
+	if err != nil || len(*list) != 3 || (*list)[0].ID != actions[2].ID {
+		t.Fatalf("Expected 3 actions, the earliest first, got %+v, %v", list, err)
+	}
+
+	due, err := st.Action().Due(now.Add(time.Hour), 10)
+	if err != nil || len(*due) != 2 || (*due)[0].ID != actions[2].ID || (*due)[1].ID != actions[0].ID {
+		t.Fatalf("Expected 2 due actions, got %+v, %v", due, err)
+	}
+	if err = st.Action().Done(&(*due)[0], now, errors.New("not active")); err != nil {
+		t.Errorf("Failed to record an execution: %v", err)
+	}
+	if a, _ := st.Action().Get(license.UUID, actions[2].ID); a.Executed == nil || !a.Executed.Equal(now) || a.Error != "not active" {
+		t.Errorf("Expected a failed execution, got %+v", a)
+	}
+	if due, _ = st.Action().Due(now.Add(time.Hour), 10); len(*due) != 1 {
+		t.Errorf("Expected 1 due action, got %+v", *due)
+	}
+
+	// an executed action cannot be cancelled
+	if err = st.Action().Delete(&actions[2]); err == nil {
+		t.Error("Expected an error for an executed action")
+	}
+	if err = st.Action().Delete(&actions[1]); err != nil {
+		t.Errorf("Failed to cancel an action: %v", err)
+	}
+	if _, err = st.Action().Get(license.UUID, actions[1].ID); err == nil {
+		t.Error("Expected a cancelled action")
+	}
+}
+
+// testAudit checks that the changes of a license are recorded with their actor, and without personal data.
+func testAudit(t *testing.T, st stor.Store) {
+
+	pub := CreatePublications(t, st, 1, "application/epub+zip")[0]
+	cms := st.WithContext(stor.WithActor(context.Background(), "cms"))
+	license := NewLicense(pub.UUID, "user1")
+	if err := cms.License().Create(license); err != nil {
+		t.Fatalf("Failed to create a license: %v", err)
+	}
+
+	// the user is not recorded, so changing it alone leaves no record
+	license.UserID = "user2"
+	if err := cms.License().Update(license); err != nil {
+		t.Fatalf("Failed to update a license: %v", err)
+	}
+	end := license.End.AddDate(0, 0, 5)
+	license.End = &end
+	if err := cms.License().Update(license); err != nil {
+		t.Fatalf("Failed to update a license: %v", err)
+	}
+	license.Status = stor.STATUS_REVOKED
+	if err := st.License().Update(license); err != nil {
+		t.Fatalf("Failed to update a license: %v", err)
+	}
+	if err := cms.License().Delete(license); err != nil {
+		t.Fatalf("Failed to delete a license: %v", err)
+	}
+	if _, err := cms.License().Restore(license.UUID); err != nil {
+		t.Fatalf("Failed to restore a license: %v", err)
+	}
+
+	records, err := st.Audit().List(license.UUID)
+	if err != nil {
+		t.Fatalf("Failed to list the audit records: %v", err)
+	}
+	expected := []struct{ action, actor string }{
+		{stor.AUDIT_CREATE, "cms"},
+		{stor.AUDIT_RENEW, "cms"},
+		{stor.AUDIT_REVOKE, ""},
+		{stor.AUDIT_DELETE, "cms"},
+		{stor.AUDIT_RESTORE, "cms"},
+	}
+	if len(*records) != len(expected) {
+		t.Fatalf("Expected %d audit records, got %+v", len(expected), *records)
+	}
+	for i, r := range *records {
+		if r.Action != expected[i].action || r.Actor != expected[i].actor {
+			t.Errorf("Expected the %s of %q, got the %s of %q", expected[i].action, expected[i].actor, r.Action, r.Actor)
+		}
+	}
+	created, renewed := (*records)[0], (*records)[1]
+	if _, ok := created.Changes["user_id"]; ok || string(created.Changes["status"].To) != `"ready"` {
+		t.Errorf("Unexpected changes of the creation %+v", created.Changes)
+	}
+	if len(renewed.Changes) != 1 || renewed.Changes["end"].From == nil {
+		t.Errorf("Expected the change of the end date, got %+v", renewed.Changes)
+	}
+	if last, err := st.Audit().Last(); err != nil || last != (*records)[len(*records)-1].ID {
+		t.Errorf("Expected the last audit record, got %d, %v", last, err)
+	}
+}
+
+// testStatistics checks the aggregates of the licenses of a provider over a period.
+func testStatistics(t *testing.T, st stor.Store) {
+
+	pub := CreatePublications(t, st, 1, "application/epub+zip")[0]
+	licenses := CreateLicenses(t, st, 3, pub.UUID, "user1")
+	licenses[0].Status = stor.STATUS_ACTIVE
+	if err := st.License().Update(licenses[0]); err != nil {
+		t.Fatalf("Failed to update a license: %v", err)
+	}
+	other := NewLicense(pub.UUID, "user2")
+	other.Provider = "https://other.example.com"
+	if err := st.License().Create(other); err != nil {
+		t.Fatalf("Failed to create a license: %v", err)
+	}
+	now := time.Now()
+	for _, e := range []*stor.Event{
+		{Timestamp: now, Type: stor.EVENT_REGISTER, DeviceID: "d1", LicenseID: licenses[0].UUID},
+		{Timestamp: now.Add(-48 * time.Hour), Type: stor.EVENT_REGISTER, DeviceID: "d2", LicenseID: licenses[0].UUID},
+		{Timestamp: now, Type: stor.EVENT_REGISTER, DeviceID: "d1", LicenseID: other.UUID},
+	} {
+		if err := st.Event().Create(e); err != nil {
+			t.Fatalf("Failed to create an event: %v", err)
+		}
+	}
+
+	providers, err := st.License().ListProviders()
+	if err != nil || fmt.Sprint(providers) != "[https://other.example.com https://provider.example.com]" {
+		t.Errorf("Expected 2 providers, got %v, %v", providers, err)
+	}
+	stats, err := st.License().Statistics("https://provider.example.com", now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to get statistics: %v", err)
+	}
+	if stats.Licenses[stor.STATUS_READY] != 2 || stats.Licenses[stor.STATUS_ACTIVE] != 1 || len(stats.Events) != 1 || stats.Events[stor.EVENT_REGISTER] != 1 {
+		t.Errorf("Unexpected statistics %+v", stats)
+	}
+	if stats, _ = st.License().Statistics("https://provider.example.com", now.Add(time.Hour), now.Add(2*time.Hour)); len(stats.Licenses) != 0 || len(stats.Events) != 0 {
+		t.Errorf("Expected no statistics, got %+v", stats)
+	}
+}
+
+// testOrganizations checks that the passphrase pool is deleted with its organization, and that both can be created
+// again.
+func testOrganizations(t *testing.T, st stor.Store) {
+
+	org := NewOrganization("School")
+	if err := st.Organization().Create(org); err != nil {
+		t.Fatalf("Failed to create an organization: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		p := &stor.Passphrase{OrganizationID: org.UUID, Label: fmt.Sprintf("class%d", i), TextHint: "hint", PassHash: "hash"}
+		if err := st.Organization().AddPassphrase(p); err != nil {
+			t.Fatalf("Failed to add a passphrase: %v", err)
+		}
+	}
+	if err := st.Organization().AddPassphrase(&stor.Passphrase{OrganizationID: org.UUID, Label: "class0", TextHint: "hint", PassHash: "hash"}); !errors.Is(err, stor.ErrDuplicate) {
+		t.Errorf("Expected a duplicate error, got %v", err)
+	}
+	p, err := st.Organization().GetPassphrase(org.UUID, "class1")
+	if err != nil {
+		t.Fatalf("Failed to get a passphrase: %v", err)
+	}
+
+	// a deleted label can be reused
+	if err = st.Organization().DeletePassphrase(p); err != nil {
+		t.Fatalf("Failed to delete a passphrase: %v", err)
+	}
+	if err = st.Organization().AddPassphrase(&stor.Passphrase{OrganizationID: org.UUID, Label: "class1", TextHint: "hint", PassHash: "hash"}); err != nil {
+		t.Errorf("Failed to reuse the label of a deleted passphrase: %v", err)
+	}
+
+	if err = st.Organization().Delete(org); err != nil {
+		t.Fatalf("Failed to delete an organization: %v", err)
+	}
+	if _, err = st.Organization().Get(org.UUID); err == nil {
+		t.Error("Expected an error for a deleted organization")
+	}
+	if list, _ := st.Organization().ListPassphrases(org.UUID); len(*list) != 0 {
+		t.Errorf("Expected the passphrases to be deleted with the organization, got %d", len(*list))
+	}
+
+	// a deleted organization can be created again, with the same labels
+	again := &stor.Organization{UUID: org.UUID, Name: org.Name}
+	if err = st.Organization().Create(again); err != nil {
+		t.Fatalf("Failed to create a deleted organization again: %v", err)
+	}
+	if err = st.Organization().AddPassphrase(&stor.Passphrase{OrganizationID: org.UUID, Label: "class0", TextHint: "hint", PassHash: "hash"}); err != nil {
+		t.Errorf("Failed to reuse the label of a deleted organization: %v", err)
+	}
+	if err = st.Organization().Delete(again); err != nil {
+		t.Fatalf("Failed to delete an organization: %v", err)
+	}
+}
+
+// testMediaTypes checks that registered media types are not overwritten.
+func testMediaTypes(t *testing.T, st stor.Store) {
+
+	if err := st.MediaType().Register([]stor.MediaType{{Format: "zzcbz", ContentType: "application/vnd.comicbook+zip"}}); err != nil {
+		t.Fatalf("Failed to register a media type: %v", err)
+	}
+	m, err := st.MediaType().Get("zzcbz")
+	if err != nil {
+		t.Fatalf("Failed to get a media type: %v", err)
+	}
+	m.Label = "Comic book"
+	if err = st.MediaType().Update(m); err != nil {
+		t.Fatalf("Failed to update a media type: %v", err)
+	}
+	if err = st.MediaType().Register([]stor.MediaType{{Format: "zzcbz", ContentType: "application/zip"}}); err != nil {
+		t.Fatalf("Failed to register a media type again: %v", err)
+	}
+	if m, _ = st.MediaType().Get("zzcbz"); m.ContentType != "application/vnd.comicbook+zip" || m.Label != "Comic book" {
+		t.Errorf("A registered media type must not be overwritten, got %+v", m)
+	}
+
+	// a deleted format can be registered again
+	if err = st.MediaType().Delete(m); err != nil {
+		t.Fatalf("Failed to delete a media type: %v", err)
+	}
+	if err = st.MediaType().Create(&stor.MediaType{Format: "zzcbz", ContentType: "application/zip"}); err != nil {
+		t.Errorf("Failed to create a deleted format again: %v", err)
+	}
+}
+
+func testLicenseCache(t *testing.T, st stor.Store) {
+
+	if err := st.LicenseCache().Set(&stor.CachedLicense{LicenseID: "l1", Hash: "h1", Document: []byte("v1")}); err != nil {
+		t.Fatalf("Failed to cache a license: %v", err)
+	}
+	// a stale entry is replaced
+	if err := st.LicenseCache().Set(&stor.CachedLicense{LicenseID: "l1", Hash: "h1", Document: []byte("v2")}); err != nil {
+		t.Fatalf("Failed to replace a cached license: %v", err)
+	}
+	c, err := st.LicenseCache().Get("h1", time.Minute)
+	if err != nil || string(c.Document) != "v2" {
+		t.Errorf("Expected the latest cached license, got %+v, %v", c, err)
+	}
+	if err = st.LicenseCache().Invalidate("l1"); err != nil {
+		t.Fatalf("Failed to invalidate a cached license: %v", err)
+	}
+	if _, err = st.LicenseCache().Get("h1", time.Minute); err == nil {
+		t.Error("Expected an error for an invalidated license")
+	}
+
+	// expired entries are purged
+	if err = st.LicenseCache().Set(&stor.CachedLicense{LicenseID: "l2", Hash: "h2", Document: []byte("v1")}); err != nil {
+		t.Fatalf("Failed to cache a license: %v", err)
+	}
+	if n, err := st.LicenseCache().Purge(time.Hour); err != nil || n != 0 {
+		t.Errorf("Expected no purged license, got %d, %v", n, err)
+	}
+	if n, err := st.LicenseCache().Purge(-time.Minute); err != nil || n != 1 {
+		t.Errorf("Expected a purged license, got %d, %v", n, err)
+	}
+}
+
+// testUsage checks that the usage of a publication is aggregated per day.
+func testUsage(t *testing.T, st stor.Store) {
+
+	day := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	for _, record := range []struct {
+		t                         time.Time
+		licenseFetches, downloads int64
+	}{
+		{day, 1, 0},
+		{day.Add(time.Hour), 1, 2},
+		{day.AddDate(0, 0, 2), 0, 1},
+	} {
+		if err := st.Usage().Record("pub1", record.t, record.licenseFetches, record.downloads); err != nil {
+			t.Fatalf("Failed to record a usage: %v", err)
+		}
+	}
+	if err := st.Usage().Record("pub2", day, 5, 5); err != nil {
+		t.Fatalf("Failed to record a usage: %v", err)
+	}
+
+	usage, err := st.Usage().List("pub1", day, day.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatalf("Failed to list a usage: %v", err)
+	}
+	want := []stor.PublicationUsage{
+		{PublicationID: "pub1", Day: "2023-05-01", LicenseFetches: 2, Downloads: 2},
+		{PublicationID: "pub1", Day: "2023-05-03", LicenseFetches: 0, Downloads: 1},
+	}
+	if fmt.Sprint(*usage) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, *usage)
+	}
+	if usage, _ = st.Usage().List("pub1", day.AddDate(0, 0, 1), day.AddDate(0, 0, 1)); len(*usage) != 0 {
+		t.Errorf("Expected no usage, got %v", *usage)
+	}
+}
+
+// testConcurrency checks that concurrent writers and readers do not interfere.
+func testConcurrency(t *testing.T, st stor.Store) {
+
+	const workers, perWorker = 8, 10
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*perWorker)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				if err := st.Publication().Create(NewPublication("application/epub+zip")); err != nil {
+					errs <- err
+				}
+				if _, err := st.Publication().List(10, 1); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Concurrent access failed: %v", err)
+	}
+	if count, _ := st.Publication().Count(); count != workers*perWorker {
+		t.Errorf("Expected %d publications, got %d", workers*perWorker, count)
+	}
+}
+
+// testContext checks that the queries of a store bound to a cancelled context fail.
+func testContext(t *testing.T, st stor.Store) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	bound := st.WithContext(ctx)
+	if _, err := bound.Publication().Count(); err != nil {
+		t.Fatalf("Failed to count publications: %v", err)
+	}
+	cancel()
+	if _, err := bound.Publication().Count(); err == nil {
+		t.Error("A query with a cancelled context should fail")
+	}
+	if _, err := st.Publication().Count(); err != nil {
+		t.Errorf("The original store must not be bound to the context: %v", err)
+	}
+}
+
+func uuids(pubs []stor.Publication) []string {
+	ids := make([]string, len(pubs))
+	for i, p := range pubs {
+		ids[i] = p.UUID
+	}
+	return ids
+}
+
+// testProviders checks that a provider is found by its URI, which is unique.
+func testCollections(t *testing.T, st stor.Store) {
+
+	pubs := CreatePublications(t, st, 3, "application/epub+zip")
+	series := NewCollection(stor.COLLECTION_SERIES, "Series")
+	bundle := NewCollection(stor.COLLECTION_BUNDLE, "Bundle")
+	for _, c := range []*stor.Collection{series, bundle} {
+		if err := st.Collection().Create(c); err != nil {
+			t.Fatalf("Failed to create a collection: %v", err)
+		}
+	}
+
+	// volumes are listed in position order, and a member is moved rather than duplicated
+	for i, pos := range []int{2, 1, 3} {
+		if err := st.Collection().SetMember(&stor.CollectionMember{CollectionID: series.UUID, PublicationID: pubs[i].UUID, Position: pos}); err != nil {
+			t.Fatalf("Failed to add a member: %v", err)
+		}
+	}
+	if err := st.Collection().SetMember(&stor.CollectionMember{CollectionID: series.UUID, PublicationID: pubs[2].UUID, Position: 0}); err != nil {
+		t.Fatalf("Failed to move a member: %v", err)
+	}
+	members, err := st.Collection().ListMembers(series.UUID)
+	if err != nil || len(*members) != 3 {
+		t.Fatalf("Expected 3 members, got %v, %v", members, err)
+	}
+	if (*members)[0].PublicationID != pubs[2].UUID || (*members)[1].PublicationID != pubs[1].UUID {
+		t.Errorf("Unexpected member order %+v", *members)
+	}
+
+	// a publication can be a member of several collections
+	if err = st.Collection().SetMember(&stor.CollectionMember{CollectionID: bundle.UUID, PublicationID: pubs[0].UUID}); err != nil {
+		t.Fatalf("Failed to add a member: %v", err)
+	}
+	collections, err := st.Collection().FindByPublication(pubs[0].UUID)
+	if err != nil || len(*collections) != 2 {
+		t.Errorf("Expected 2 collections, got %v, %v", collections, err)
+	}
+
+	member, err := st.Collection().GetMember(series.UUID, pubs[0].UUID)
+	if err != nil || member.Position != 2 {
+		t.Fatalf("Failed to get a member: %v", err)
+	}
+	if err = st.Collection().DeleteMember(member); err != nil {
+		t.Errorf("Failed to remove a member: %v", err)
+	}
+	if _, err = st.Collection().GetMember(series.UUID, pubs[0].UUID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+
+	// the members are removed with the collection
+	if err = st.Collection().Delete(series); err != nil {
+		t.Fatalf("Failed to delete a collection: %v", err)
+	}
+	if members, _ = st.Collection().ListMembers(series.UUID); len(*members) != 0 {
+		t.Errorf("Expected no member left, got %d", len(*members))
+	}
+	if err = st.Collection().Create(NewCollection(stor.COLLECTION_SERIES, "Series")); err != nil {
+		t.Errorf("Failed to create a collection: %v", err)
+	}
+}
+
+func testCoupons(t *testing.T, st stor.Store) {
+
+	pub := CreatePublications(t, st, 1, "application/epub+zip")[0]
+	coupon := NewCoupon(pub.UUID, 2)
+	if err := st.Coupon().CreateAll([]*stor.Coupon{coupon, NewCoupon(pub.UUID, 1)}); err != nil {
+		t.Fatalf("Failed to create coupons: %v", err)
+	}
+	// a batch with a duplicate code creates no coupon
+	other := NewCoupon(pub.UUID, 1)
+	if err := st.Coupon().CreateAll([]*stor.Coupon{other, NewCoupon(pub.UUID, 1), coupon}); !errors.Is(err, stor.ErrDuplicate) {
+		t.Errorf("Expected a duplicate error, got %v", err)
+	}
+	if _, err := st.Coupon().Get(other.Code); err == nil {
+		t.Error("Expected no coupon created by a failed batch")
+	}
+
+	// every use issues a license, up to the limit
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		license := NewLicense(pub.UUID, "Neo")
+		if err := st.Coupon().Redeem(coupon.Code, license, now); err != nil {
+			t.Fatalf("Failed to redeem a coupon: %v", err)
+		}
+		if _, err := st.License().Get(license.UUID); err != nil {
+			t.Errorf("Expected the issued license to be stored, got %v", err)
+		}
+	}
+	license := NewLicense(pub.UUID, "Neo")
+	if err := st.Coupon().Redeem(coupon.Code, license, now); !errors.Is(err, stor.ErrNotRedeemable) {
+		t.Errorf("Expected a used coupon, got %v", err)
+	}
+	if _, err := st.License().Get(license.UUID); err == nil {
+		t.Error("Expected no license issued by a used coupon")
+	}
+	c, err := st.Coupon().Get(coupon.Code)
+	if err != nil || c.Uses != 2 || c.Redeemable(now) {
+		t.Errorf("Expected 2 uses, got %+v, %v", c, err)
+	}
+	redemptions, err := st.Coupon().ListRedemptions(coupon.Code)
+	if err != nil || len(*redemptions) != 2 || (*redemptions)[0].UserID != "Neo" {
+		t.Errorf("Expected 2 redemptions, got %v, %v", redemptions, err)
+	}
+
+	// an expired coupon is not redeemed
+	expired := NewCoupon(pub.UUID, 1)
+	yesterday := now.AddDate(0, 0, -1)
+	expired.Expires = &yesterday
+	if err = st.Coupon().Create(expired); err != nil {
+		t.Fatalf("Failed to create a coupon: %v", err)
+	}
+	if err = st.Coupon().Redeem(expired.Code, NewLicense(pub.UUID, "Neo"), now); !errors.Is(err, stor.ErrNotRedeemable) {
+		t.Errorf("Expected an expired coupon, got %v", err)
+	}
+
+	// a deleted code can be reused
+	if err = st.Coupon().Delete(c); err != nil {
+		t.Fatalf("Failed to delete a coupon: %v", err)
+	}
+	if redemptions, _ = st.Coupon().ListRedemptions(coupon.Code); len(*redemptions) != 0 {
+		t.Errorf("Expected the redemptions to be deleted, got %d", len(*redemptions))
+	}
+	reused := NewCoupon(pub.UUID, 1)
+	reused.Code = coupon.Code
+	if err = st.Coupon().Create(reused); err != nil {
+		t.Errorf("Failed to reuse a deleted code: %v", err)
+	}
+}
+
+func testGifts(t *testing.T, st stor.Store) {
+
+	pub := CreatePublications(t, st, 1, "application/epub+zip")[0]
+	now := time.Now()
+	gift := NewGift(pub.UUID, "Trinity", now.AddDate(0, 0, 1))
+	if err := st.Gift().Create(gift); err != nil {
+		t.Fatalf("Failed to create a gift: %v", err)
+	}
+	if g, err := st.Gift().GetByToken(gift.TokenHash); err != nil || g.UUID != gift.UUID || g.StatusAt(now) != stor.GIFT_PENDING {
+		t.Errorf("Expected the pending gift, got %+v, %v", g, err)
+	}
+
+	// a gift is claimed once, with the license of its recipient
+	license := NewLicense(pub.UUID, "Neo")
+	if err := st.Gift().Claim(gift.UUID, license, now); err != nil {
+		t.Fatalf("Failed to claim a gift: %v", err)
+	}
+	if err := st.Gift().Claim(gift.UUID, NewLicense(pub.UUID, "Morpheus"), now); !errors.Is(err, stor.ErrNotClaimable) {
+		t.Errorf("Expected a claimed gift, got %v", err)
+	}
+	if err := st.Gift().Cancel(gift.UUID); !errors.Is(err, stor.ErrNotClaimable) {
+		t.Errorf("Expected a claimed gift not to be cancelled, got %v", err)
+	}
+	g, err := st.Gift().Get(gift.UUID)
+	if err != nil || g.Status != stor.GIFT_CLAIMED || g.LicenseID != license.UUID || g.Claimed == nil {
+		t.Errorf("Unexpected claimed gift %+v, %v", g, err)
+	}
+	if _, err := st.License().Get(license.UUID); err != nil {
+		t.Errorf("Expected the license of the gift to be stored, got %v", err)
+	}
+
+	// an expired or cancelled gift is not claimed
+	expired := NewGift(pub.UUID, "Trinity", now.AddDate(0, 0, -1))
+	cancelled := NewGift(pub.UUID, "Trinity", now.AddDate(0, 0, 1))
+	for _, g := range []*stor.Gift{expired, cancelled} {
+		if err := st.Gift().Create(g); err != nil {
+			t.Fatalf("Failed to create a gift: %v", err)
+		}
+	}
+	if err := st.Gift().Cancel(cancelled.UUID); err != nil {
+		t.Errorf("Failed to cancel a gift: %v", err)
+	}
+	for _, g := range []*stor.Gift{expired, cancelled} {
+		license := NewLicense(pub.UUID, "Neo")
+		if err := st.Gift().Claim(g.UUID, license, now); !errors.Is(err, stor.ErrNotClaimable) {
+			t.Errorf("Expected an unclaimable gift, got %v", err)
+		}
+		if _, err := st.License().Get(license.UUID); err == nil {
+			t.Error("Expected no license issued by an unclaimable gift")
+		}
+	}
+	if expired.StatusAt(now) != stor.GIFT_EXPIRED {
+		t.Errorf("Expected an expired gift, got %s", expired.StatusAt(now))
+	}
+	if gifts, err := st.Gift().FindByPurchaser("Trinity"); err != nil || len(*gifts) != 3 {
+		t.Errorf("Expected 3 gifts of the purchaser, got %v, %v", gifts, err)
+	}
+}
+
+func testProviders(t *testing.T, st stor.Store) {
+
+	provider := NewProvider("https://provider.example.com")
+	provider.TermsLink = "https://provider.example.com/terms"
+	if err := st.Provider().Create(provider); err != nil {
+		t.Fatalf("Failed to create a provider: %v", err)
+	}
+	if err := st.Provider().Create(NewProvider(provider.URI)); !errors.Is(err, stor.ErrDuplicate) {
+		t.Errorf("Expected a duplicate error, got %v", err)
+	}
+	p, err := st.Provider().GetByURI(provider.URI)
+	if err != nil || p.UUID != provider.UUID || p.TermsLink != provider.TermsLink {
+		t.Fatalf("Failed to get a provider by its URI: %v", err)
+	}
+
+	// a deleted URI can be declared again
+	if err = st.Provider().Delete(p); err != nil {
+		t.Fatalf("Failed to delete a provider: %v", err)
+	}
+	if _, err = st.Provider().GetByURI(provider.URI); err == nil {
+		t.Error("Expected an error for a deleted provider")
+	}
+	if err = st.Provider().Create(NewProvider(provider.URI)); err != nil {
+		t.Errorf("Failed to declare a deleted provider again: %v", err)
+	}
+}
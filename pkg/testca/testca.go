@@ -0,0 +1,126 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package testca generates a local certificate authority and provider certificates
+// compatible with the LCP basic profile (RSA keys, SHA-256 signatures), so that tests
+// and demos never depend on the certificates delivered by EDRLab.
+// Reading applications only accept licenses signed by an EDRLab provider certificate:
+// these certificates are for evaluation only.
+package testca
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"time"
+)
+
+// KeySize is the size of the generated RSA keys.
+const KeySize = 2048
+
+// Credentials are a certificate and its private key.
+type Credentials struct {
+	Cert *x509.Certificate
+	Key  *rsa.PrivateKey
+}
+
+// CA is a certificate authority issuing provider certificates.
+type CA struct {
+	Credentials
+}
+
+// New generates a self-signed certificate authority, valid for ten years.
+func New(name string) (*CA, error) {
+	now := time.Now()
+	tpl := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name, Organization: []string{"LCP test CA"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	cred, err := create(tpl, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{*cred}, nil
+}
+
+// Provider issues the certificate of a license provider, valid until notAfter.
+// An expired certificate is issued if notAfter is in the past.
+func (ca *CA) Provider(provider string, notAfter time.Time) (*Credentials, error) {
+	notBefore := time.Now().Add(-time.Hour)
+	if start := notAfter.AddDate(-1, 0, 0); start.Before(notBefore) {
+		notBefore = start
+	}
+	tpl := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: provider, Organization: []string{"LCP test provider"}},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	return create(tpl, &ca.Credentials)
+}
+
+// create generates a key and its certificate, signed by the parent credentials or self-signed.
+func create(tpl *x509.Certificate, parent *Credentials) (*Credentials, error) {
+	key, err := rsa.GenerateKey(rand.Reader, KeySize)
+	if err != nil {
+		return nil, err
+	}
+	if tpl.SerialNumber, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64)); err != nil {
+		return nil, err
+	}
+	issuer, signer := tpl, key
+	if parent != nil {
+		issuer, signer = parent.Cert, parent.Key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, issuer, &key.PublicKey, signer)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Credentials{Cert: cert, Key: key}, nil
+}
+
+// CertPEM returns the PEM encoding of the certificate.
+func (c *Credentials) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw})
+}
+
+// KeyPEM returns the PEM encoding of the private key, in PKCS #8 form.
+func (c *Credentials) KeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(c.Key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// TLSCertificate returns the credentials in the form loaded by the server.
+func (c *Credentials) TLSCertificate() *tls.Certificate {
+	return &tls.Certificate{Certificate: [][]byte{c.Cert.Raw}, PrivateKey: c.Key, Leaf: c.Cert}
+}
+
+// WriteFiles writes the certificate and the private key as PEM files, the key being readable by its owner only.
+func (c *Credentials) WriteFiles(certFile, keyFile string) error {
+	keyPem, err := c.KeyPEM()
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(certFile, c.CertPEM(), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(keyFile, keyPem, 0600)
+}
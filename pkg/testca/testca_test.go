@@ -0,0 +1,76 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package testca
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/sign"
+)
+
+func TestProvider(t *testing.T) {
+
+	ca, err := New("LCP test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := ca.Provider("https://provider.example.com", time.Now().AddDate(1, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the provider certificate is verified by the CA
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	if _, err = provider.Cert.Verify(x509.VerifyOptions{Roots: roots}); err != nil {
+		t.Errorf("Failed to verify the provider certificate: %v", err)
+	}
+	if provider.Cert.SignatureAlgorithm != x509.SHA256WithRSA {
+		t.Errorf("Expected a SHA-256 RSA signature, got %v", provider.Cert.SignatureAlgorithm)
+	}
+
+	// the files written are loaded by the server, and sign with the basic profile algorithm
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err = provider.WriteFiles(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := sign.NewSigner(&cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := map[string]string{"id": "1"}
+	sig, err := signer.Sign(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.Algorithm != sign.SignatureAlgorithm_RSA {
+		t.Errorf("Unexpected signature algorithm %s", sig.Algorithm)
+	}
+	checker, err := sign.NewSignChecker(sig.Certificate, sig.Algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = checker.Check(doc, sig.Value); err != nil {
+		t.Errorf("Failed to check the signature: %v", err)
+	}
+
+	// expired certificates can be issued
+	expired, err := ca.Provider("https://provider.example.com", time.Now().AddDate(0, 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = expired.Cert.Verify(x509.VerifyOptions{Roots: roots}); err == nil {
+		t.Error("Expected an expired certificate")
+	}
+}
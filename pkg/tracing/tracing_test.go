@@ -0,0 +1,73 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMiddleware(t *testing.T) {
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/licenses/{licenseID}", func(w http.ResponseWriter, r *http.Request) {
+		// the spans of the handler are children of the span of the request
+		_, span := Start(r.Context(), "generate license")
+		span.End()
+		if chi.URLParam(r, "licenseID") == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	// the trace of the caller is continued
+	req := httptest.NewRequest("GET", "/licenses/123", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	inner, server := spans[0], spans[1]
+	if server.Name() != "GET /licenses/{licenseID}" || server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("Unexpected span %s of kind %s", server.Name(), server.SpanKind())
+	}
+	if server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Expected the trace of the caller to be continued, got %s", server.Parent().TraceID())
+	}
+	if inner.Name() != "generate license" || inner.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("Expected the span of the handler to be a child of the request span, got %s", inner.Name())
+	}
+	for _, attr := range server.Attributes() {
+		if attr.Key == semconv.HTTPStatusCodeKey && attr.Value.AsInt64() != http.StatusOK {
+			t.Errorf("Expected the status code 200, got %d", attr.Value.AsInt64())
+		}
+	}
+	if server.Status().Code == codes.Error {
+		t.Error("Expected a successful span")
+	}
+
+	// a request failed on the server is an error
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/licenses/fail", nil))
+	spans = recorder.Ended()
+	if server = spans[len(spans)-1]; server.Status().Code != codes.Error || server.Parent().IsValid() {
+		t.Errorf("Expected a new trace with an error span, got %v", server.Status())
+	}
+}
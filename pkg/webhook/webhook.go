@@ -0,0 +1,433 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package webhook notifies the endpoints of the configuration of the changes of the licenses, e.g. so that a content
+// management system reacts to a revocation without polling the server, and any other target, e.g. a message bus or
+// the status callback. The notifications follow the audit log and the events of the licenses: each target is
+// notified of the changes in their order, and a failed notification is retried with an exponential backoff, the
+// following ones waiting for it; it is kept as a dead letter after its last attempt. A notification may be
+// repeated, e.g. after a restart, and is identified by its id and type.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	log "github.com/sirupsen/logrus"
+)
+
+// BatchSize is the number of changes read at once.
+const BatchSize = 100
+
+// Settle is the time after which a change is notified, so that a change committed meanwhile by a concurrent
+// transaction, with a lower identifier, is not skipped.
+const Settle = 5 * time.Second
+
+// MaxBackoff is the max time between two attempts of a notification.
+const MaxBackoff = time.Hour
+
+// CallbackTarget is the name of the target of the status callback, under which its cursors are stored.
+const CallbackTarget = "callback:status"
+
+// SignatureHeader is the header of the signature of the notifications posted with a secret, see Signature.
+const SignatureHeader = "X-LCP-Signature"
+
+// Notification is the payload sent to a target.
+type Notification struct {
+	ID             string     `json:"id"`   // identifier of the change, e.g. "audit-12" or "event-34"
+	Type           string     `json:"type"` // event, e.g. license.revoked
+	Timestamp      time.Time  `json:"timestamp"`
+	LicenseID      string     `json:"license_id"`
+	Provider       string     `json:"provider,omitempty"`
+	Status         string     `json:"status,omitempty"`          // status of the license after the change
+	PreviousStatus string     `json:"previous_status,omitempty"` // status of the license before a status change
+	Actor          string     `json:"actor,omitempty"`           // who made the change, if known
+	Action         string     `json:"action,omitempty"`          // action of a reader: register, renew or return
+	End            *time.Time `json:"end,omitempty"`             // end of the license after a renewal
+	Device         *Device    `json:"device,omitempty"`          // registered device
+}
+
+// Device is a device registered with a license.
+type Device struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Target is a destination of the notifications: a webhook endpoint, a message bus, or the status callback.
+type Target struct {
+	Events []string                                         // events notified; every event if empty
+	Send   func(ctx context.Context, n *Notification) error // delivers a notification
+}
+
+// Subscribed tells if a target is notified of an event.
+func (t Target) Subscribed(event string) bool {
+	if len(t.Events) == 0 {
+		return true
+	}
+	for _, subscribed := range t.Events {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Dispatcher notifies its targets of the changes of the licenses at each interval. Each target follows the changes
+// with its own cursors, stored by name.
+type Dispatcher struct {
+	Config  conf.Webhooks     // interval and attempts of the notifications
+	Targets map[string]Target // targets by name; the endpoints of the configuration by default
+	Store   stor.Store
+	Client  *http.Client     // a client with a 30s timeout if nil
+	Clock   func() time.Time // returns the current time; time.Now if nil
+}
+
+// NewDispatcher creates a dispatcher notifying the endpoints of a configuration.
+func NewDispatcher(c conf.Webhooks, st stor.Store) *Dispatcher {
+	d := &Dispatcher{
+		Config:  c,
+		Targets: make(map[string]Target, len(c.Endpoints)),
+		Store:   st,
+		Clock:   time.Now,
+	}
+	for name, e := range c.Endpoints {
+		endpoint := e.Endpoint
+		d.Targets[name] = Target{
+			Events: e.Events,
+			Send: func(ctx context.Context, n *Notification) error {
+				return d.post(ctx, endpoint, "", n)
+			},
+		}
+	}
+	return d
+}
+
+// Callback returns the target posting the actions of the readers to the status callback, signed with its secret.
+func (d *Dispatcher) Callback(c conf.StatusCallback) Target {
+	return Target{
+		Events: []string{conf.WEBHOOK_READER_ACTION},
+		Send: func(ctx context.Context, n *Notification) error {
+			return d.post(ctx, c.Endpoint, c.Secret, n)
+		},
+	}
+}
+
+// Interval returns the time between two rounds of notifications.
+func (d *Dispatcher) Interval() time.Duration {
+	if d.Config.Interval <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(d.Config.Interval) * time.Second
+}
+
+// Run notifies the targets at each interval, until the context is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.Dispatch(ctx); err != nil {
+				log.Errorf("Notifications failed: %v", err)
+			}
+		}
+	}
+}
+
+// Dispatch notifies each target of the changes following its cursors. A failure on a target doesn't stop
+// the notifications of the other targets, the last error is returned.
+func (d *Dispatcher) Dispatch(ctx context.Context) error {
+	names := make([]string, 0, len(d.Targets))
+	for name := range d.Targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lastErr error
+	for _, name := range names {
+		if err := d.dispatch(ctx, name, d.Targets[name]); err != nil {
+			log.Errorf("Failed to notify %s: %v", name, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// dispatch notifies a target of the audit records, then of the events, following its cursors. The changes are
+// read by batches until none is left, and the cursors are stored after each batch.
+func (d *Dispatcher) dispatch(ctx context.Context, name string, target Target) error {
+	st := d.Store.WithContext(ctx)
+	state, err := st.Webhook().Get(name)
+	if err != nil {
+		return err
+	}
+	now := d.now()
+	if state.NextAttempt != nil && now.Before(*state.NextAttempt) {
+		return nil
+	}
+	before := now.Add(-Settle)
+
+	for {
+		records, err := st.Audit().Since(state.AuditCursor, before, BatchSize)
+		if err != nil {
+			return err
+		}
+		for i := range *records {
+			record := &(*records)[i]
+			if n, err := d.notify(ctx, target, d.auditNotifications(st, record)); err != nil {
+				return d.failed(st, state, n, err, func() { state.AuditCursor = record.ID })
+			}
+			state.AuditCursor = record.ID
+		}
+		if len(*records) > 0 {
+			if err = d.delivered(st, state); err != nil {
+				return err
+			}
+		}
+		if len(*records) < BatchSize {
+			break
+		}
+	}
+
+	for {
+		events, err := st.Event().Since(state.EventCursor, before, BatchSize)
+		if err != nil {
+			return err
+		}
+		for i := range *events {
+			event := &(*events)[i]
+			if n, err := d.notify(ctx, target, d.eventNotifications(st, event)); err != nil {
+				return d.failed(st, state, n, err, func() { state.EventCursor = event.ID })
+			}
+			state.EventCursor = event.ID
+		}
+		if len(*events) > 0 {
+			if err = d.delivered(st, state); err != nil {
+				return err
+			}
+		}
+		if len(*events) < BatchSize {
+			break
+		}
+	}
+	return nil
+}
+
+// auditNotifications returns the notifications of an audit record: the creation of a license, its status change,
+// its revocation, and the action of a reader
+func (d *Dispatcher) auditNotifications(st stor.Store, record *stor.AuditRecord) []Notification {
+	var notifications []Notification
+	n := Notification{
+		ID:        fmt.Sprintf("audit-%d", record.ID),
+		Timestamp: record.Timestamp,
+		LicenseID: record.LicenseID,
+		Actor:     record.Actor,
+	}
+	var from, to string
+	if change, ok := record.Changes["status"]; ok {
+		json.Unmarshal(change.From, &from)
+		json.Unmarshal(change.To, &to)
+	}
+	if record.Action == stor.AUDIT_CREATE {
+		created := n
+		created.Type = conf.WEBHOOK_LICENSE_CREATED
+		created.Status = to
+		notifications = append(notifications, created)
+	} else if from != to {
+		changed := n
+		changed.Type = conf.WEBHOOK_STATUS_CHANGED
+		changed.Status, changed.PreviousStatus = to, from
+		notifications = append(notifications, changed)
+		if revoked(to) && !revoked(from) {
+			revocation := n
+			revocation.Type = conf.WEBHOOK_LICENSE_REVOKED
+			revocation.Status = to
+			notifications = append(notifications, revocation)
+		}
+	}
+	switch record.Action {
+	case stor.AUDIT_REGISTER, stor.AUDIT_RENEW, stor.AUDIT_RETURN:
+		action := n
+		action.Type = conf.WEBHOOK_READER_ACTION
+		action.Action = record.Action
+		action.Status = to
+		if change, ok := record.Changes["end"]; ok {
+			var end time.Time
+			if json.Unmarshal(change.To, &end) == nil && !end.IsZero() {
+				action.End = &end
+			}
+		}
+		notifications = append(notifications, action)
+	}
+	return withLicense(st, notifications)
+}
+
+// eventNotifications returns the notifications of an event: the registration of a device
+func (d *Dispatcher) eventNotifications(st stor.Store, event *stor.Event) []Notification {
+	if event.Type != stor.EVENT_REGISTER {
+		return nil
+	}
+	return withLicense(st, []Notification{{
+		ID:        fmt.Sprintf("event-%d", event.ID),
+		Type:      conf.WEBHOOK_DEVICE_REGISTERED,
+		Timestamp: event.Timestamp,
+		LicenseID: event.LicenseID,
+		Device:    &Device{ID: event.DeviceID, Name: event.DeviceName},
+	}})
+}
+
+// withLicense sets the provider of the license of notifications, and the status of the notifications of an
+// unchanged status, if the license is found
+func withLicense(st stor.Store, notifications []Notification) []Notification {
+	if len(notifications) == 0 {
+		return nil
+	}
+	license, err := st.License().WithDeleted().Get(notifications[0].LicenseID)
+	if err != nil {
+		return notifications
+	}
+	for i := range notifications {
+		notifications[i].Provider = license.Provider
+		if notifications[i].Type == conf.WEBHOOK_READER_ACTION && notifications[i].Status == "" {
+			notifications[i].Status = license.Status
+		}
+	}
+	return notifications
+}
+
+// revoked tells if a status is a revocation
+func revoked(status string) bool {
+	return status == stor.STATUS_REVOKED || status == stor.STATUS_REVOKING
+}
+
+// notify sends the notifications a target subscribed to, and returns the notification which failed, if any
+func (d *Dispatcher) notify(ctx context.Context, target Target, notifications []Notification) (*Notification, error) {
+	for i := range notifications {
+		if !target.Subscribed(notifications[i].Type) {
+			continue
+		}
+		if err := target.Send(ctx, &notifications[i]); err != nil {
+			return &notifications[i], err
+		}
+	}
+	return nil, nil
+}
+
+// Signature returns the signature of a notification posted at a time: "t=<unix time>,v1=<HMAC-SHA256>", where the
+// HMAC-SHA256 of the secret is computed on the time and the body, joined by a dot, and encoded in hexadecimal. The
+// receiver checks the signature, and that the time is recent, so that a notification cannot be replayed.
+func Signature(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// post posts a notification to an endpoint, signed if a secret is set
+func (d *Dispatcher) post(ctx context.Context, endpoint conf.Endpoint, secret string, n *Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if endpoint.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+endpoint.Token)
+	}
+	if secret != "" {
+		req.Header.Set(SignatureHeader, Signature(secret, d.now(), data))
+	}
+	client := d.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the endpoint %s returned the status %d", endpoint.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+// delivered records the delivery of the notifications up to the cursors
+func (d *Dispatcher) delivered(st stor.Store, state *stor.Webhook) error {
+	state.Attempts = 0
+	state.NextAttempt = nil
+	state.LastError = ""
+	return st.Webhook().Update(state)
+}
+
+// failed records a failed attempt of a notification, retried after a backoff doubled at each attempt.
+// After the last attempt, the notification is kept as a dead letter and skipped.
+func (d *Dispatcher) failed(st stor.Store, state *stor.Webhook, n *Notification, cause error, skip func()) error {
+	state.Attempts++
+	state.LastError = cause.Error()
+	if state.Attempts >= d.Config.Attempts() {
+		payload, _ := json.Marshal(n)
+		err := st.Webhook().Drop(&stor.DeadLetter{
+			Target:         state.Name,
+			NotificationID: n.ID,
+			Type:           n.Type,
+			LicenseID:      n.LicenseID,
+			Payload:        payload,
+			Attempts:       state.Attempts,
+			LastError:      cause.Error(),
+		})
+		if err != nil {
+			// the notification is attempted again, until it is kept
+			return err
+		}
+		log.Errorf("Notification %s %s of %s dropped after %d attempts: %v", n.ID, n.Type, state.Name, state.Attempts, cause)
+		skip()
+		state.Attempts = 0
+		state.NextAttempt = nil
+	} else {
+		next := d.now().Add(d.backoff(state.Attempts))
+		state.NextAttempt = &next
+	}
+	if err := st.Webhook().Update(state); err != nil {
+		return err
+	}
+	return cause
+}
+
+// backoff returns the time before the next attempt of a notification which failed a number of times
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.Interval()
+	for i := 1; i < attempts && backoff < MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > MaxBackoff {
+		return MaxBackoff
+	}
+	return backoff
+}
+
+// now returns the current time
+func (d *Dispatcher) now() time.Time {
+	if d.Clock == nil {
+		return time.Now()
+	}
+	return d.Clock()
+}
@@ -0,0 +1,253 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edrlab/lcp-server/pkg/conf"
+	"github.com/edrlab/lcp-server/pkg/stor"
+	"github.com/edrlab/lcp-server/pkg/stortest"
+)
+
+func TestDispatch(t *testing.T) {
+
+	st := stortest.SQLite()(t)
+
+	var mu sync.Mutex
+	var notifications []Notification
+	status := http.StatusServiceUnavailable
+	cms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var n Notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Error(err)
+		}
+		if status == http.StatusOK {
+			notifications = append(notifications, n)
+		}
+		w.WriteHeader(status)
+	}))
+	defer cms.Close()
+
+	c := conf.Webhooks{Endpoints: map[string]conf.WebhookEndpoint{
+		"cms": {Endpoint: conf.Endpoint{URL: cms.URL, Token: "secret"}},
+		"revocations": {Endpoint: conf.Endpoint{URL: cms.URL, Token: "secret"},
+			Events: []string{conf.WEBHOOK_LICENSE_REVOKED}},
+	}, MaxAttempts: 3}
+	now := time.Now()
+	d := NewDispatcher(c, st)
+	d.Clock = func() time.Time { return now }
+
+	// the endpoints are notified of the changes following their first round
+	if err := d.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pub := stortest.CreatePublications(t, st, 1, "application/epub+zip")[0]
+	license := stortest.CreateLicenses(t, st, 1, pub.UUID, "user1")[0]
+	license.Status = stor.STATUS_REVOKED
+	if err := st.License().Update(license); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Event().Create(&stor.Event{Timestamp: time.Now(), Type: stor.EVENT_REGISTER, LicenseID: license.UUID, DeviceID: "d1", DeviceName: "Reader"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the recent changes wait for concurrent transactions to settle
+	if err := d.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed notification is retried after a backoff
+	now = now.Add(time.Minute)
+	if err := d.Dispatch(context.Background()); err == nil {
+		t.Error("Expected an error")
+	}
+	state, err := st.Webhook().Get("cms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Attempts != 1 || state.NextAttempt == nil || !state.NextAttempt.After(now) || state.LastError == "" {
+		t.Errorf("Expected a failed attempt, got %+v", state)
+	}
+	status = http.StatusOK
+	if err = d.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 0 {
+		t.Errorf("Expected no notification before the backoff, got %d", len(notifications))
+	}
+	now = now.Add(d.backoff(1))
+	if err = d.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var types []string
+	for _, n := range notifications {
+		if n.LicenseID != license.UUID || n.Provider != license.Provider {
+			t.Errorf("Unexpected notification %+v", n)
+		}
+		types = append(types, n.Type)
+	}
+	expected := []string{
+		conf.WEBHOOK_LICENSE_CREATED,
+		conf.WEBHOOK_STATUS_CHANGED, conf.WEBHOOK_LICENSE_REVOKED,
+		conf.WEBHOOK_DEVICE_REGISTERED,
+		conf.WEBHOOK_LICENSE_REVOKED,
+	}
+	if len(types) != len(expected) {
+		t.Fatalf("Expected the notifications %v, got %v", expected, types)
+	}
+	for i := range expected {
+		if types[i] != expected[i] {
+			t.Fatalf("Expected the notifications %v, got %v", expected, types)
+		}
+	}
+	if n := notifications[1]; n.PreviousStatus != stor.STATUS_READY || n.Status != stor.STATUS_REVOKED {
+		t.Errorf("Unexpected status change %+v", n)
+	}
+	if n := notifications[3]; n.Device == nil || n.Device.ID != "d1" || n.Device.Name != "Reader" {
+		t.Errorf("Unexpected device registration %+v", n)
+	}
+
+	// the notifications are not repeated
+	if err = d.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != len(expected) {
+		t.Errorf("Expected %d notifications, got %d", len(expected), len(notifications))
+	}
+}
+
+func TestDrop(t *testing.T) {
+
+	st := stortest.SQLite()(t)
+	attempts := 0
+	cms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer cms.Close()
+
+	c := conf.Webhooks{Endpoints: map[string]conf.WebhookEndpoint{
+		"cms": {Endpoint: conf.Endpoint{URL: cms.URL}},
+	}, MaxAttempts: 2}
+	now := time.Now()
+	d := NewDispatcher(c, st)
+	d.Clock = func() time.Time { return now }
+	d.Dispatch(context.Background())
+	pub := stortest.CreatePublications(t, st, 1, "application/epub+zip")[0]
+	stortest.CreateLicenses(t, st, 1, pub.UUID, "user1")
+
+	// a notification is dropped after the max attempts
+	for i := 0; i < 3; i++ {
+		now = now.Add(MaxBackoff)
+		d.Dispatch(context.Background())
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts)
+	}
+	state, err := st.Webhook().Get("cms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.AuditCursor == 0 || state.Attempts != 0 || state.NextAttempt != nil {
+		t.Errorf("Expected a dropped notification, got %+v", state)
+	}
+
+	// it is kept as a dead letter
+	letters, err := st.Webhook().DeadLetters("cms", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*letters) != 1 || (*letters)[0].Type != conf.WEBHOOK_LICENSE_CREATED || (*letters)[0].Attempts != 2 {
+		t.Fatalf("Expected a dead letter, got %+v", *letters)
+	}
+	var n Notification
+	if err = json.Unmarshal((*letters)[0].Payload, &n); err != nil || n.ID != (*letters)[0].NotificationID {
+		t.Errorf("Unexpected payload %s", (*letters)[0].Payload)
+	}
+}
+
+func TestCallback(t *testing.T) {
+
+	st := stortest.SQLite()(t)
+	secret := "0123456789abcdef"
+	var notifications []Notification
+	cms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		// the signature is checked with the time it tells
+		signature := r.Header.Get(SignatureHeader)
+		unix, _ := strconv.ParseInt(strings.TrimPrefix(strings.Split(signature, ",")[0], "t="), 10, 64)
+		if signature != Signature(secret, time.Unix(unix, 0), body) {
+			t.Errorf("Invalid signature %s", signature)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var n Notification
+		if err := json.Unmarshal(body, &n); err != nil {
+			t.Error(err)
+		}
+		notifications = append(notifications, n)
+	}))
+	defer cms.Close()
+
+	now := time.Now()
+	d := NewDispatcher(conf.Webhooks{}, st)
+	d.Clock = func() time.Time { return now }
+	d.Targets[CallbackTarget] = d.Callback(conf.StatusCallback{Endpoint: conf.Endpoint{URL: cms.URL}, Secret: secret})
+	d.Dispatch(context.Background())
+
+	// the callback is notified of the actions of the readers only
+	pub := stortest.CreatePublications(t, st, 1, "application/epub+zip")[0]
+	license := stortest.CreateLicenses(t, st, 1, pub.UUID, "user1")[0]
+	end := license.End.Add(24 * time.Hour).Truncate(time.Second)
+	for _, change := range []func(){
+		func() { license.Status = stor.STATUS_ACTIVE },
+		func() { license.End = &end },
+		func() { license.Status = stor.STATUS_RETURNED },
+	} {
+		change()
+		if err := st.License().Update(license); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Minute)
+		if err := d.Dispatch(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []struct{ action, status string }{
+		{stor.AUDIT_REGISTER, stor.STATUS_ACTIVE},
+		{stor.AUDIT_RENEW, stor.STATUS_ACTIVE},
+		{stor.AUDIT_RETURN, stor.STATUS_RETURNED},
+	}
+	if len(notifications) != len(expected) {
+		t.Fatalf("Expected %d notifications, got %+v", len(expected), notifications)
+	}
+	for i, e := range expected {
+		n := notifications[i]
+		if n.Type != conf.WEBHOOK_READER_ACTION || n.Action != e.action || n.Status != e.status || n.LicenseID != license.UUID {
+			t.Errorf("Unexpected notification %+v", n)
+		}
+	}
+	if n := notifications[1]; n.End == nil || !n.End.Equal(end) {
+		t.Errorf("Expected the end of the renewed license, got %+v", n)
+	}
+}